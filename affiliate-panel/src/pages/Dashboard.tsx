@@ -19,6 +19,8 @@ export default function Dashboard() {
     total_signups: number;
     real_income?: number;
     total_income: number;
+    license_income?: number;
+    offline_income?: number;
     balance: number;
     registrations_chart: { name: string; count: number }[];
     sales_chart: { name: string; sales: number }[];
@@ -98,7 +100,10 @@ export default function Dashboard() {
     return sum + amt;
   }, 0);
   const realIncomeDisplay = realIncomeFromApi > 0 ? realIncomeFromApi : computedRealIncome;
-  const totalIncomeDisplay = totalIncomeFromApi > 0 ? totalIncomeFromApi : realIncomeDisplay;
+  // درآمد شما از دفتر پورسانت می‌آید (پورسانت لایسنس‌ها + فروش آفلاین)
+  const totalIncomeDisplay = totalIncomeFromApi;
+  const licenseIncome = Number(data.license_income ?? 0);
+  const offlineIncome = Number(data.offline_income ?? 0);
 
   const regChart = (data.registrations_chart || []).map((d: { name: string; count?: number }) => ({ ...d, count: Number(d.count) || 0 }));
   const salesChart = (data.sales_chart || []).map((d: { name: string; sales?: number; count?: number }) => ({ ...d, sales: Number(d.sales) || Number((d as { count?: number }).count) || 0 }));
@@ -186,6 +191,7 @@ export default function Dashboard() {
               <div>
                 <p className="text-sm text-muted-foreground">درآمد شما</p>
                 <p className="text-3xl font-bold text-foreground mt-1">{totalIncomeDisplay.toLocaleString("fa-IR")} <span className="text-sm font-normal text-muted-foreground">تومان</span></p>
+                <p className="text-xs text-muted-foreground mt-1">لایسنس‌ها: {licenseIncome.toLocaleString("fa-IR")} · فروش آفلاین: {offlineIncome.toLocaleString("fa-IR")}</p>
               </div>
               <div className="w-14 h-14 rounded-2xl bg-green-500/15 flex items-center justify-center">
                 <Wallet className="w-7 h-7 text-green-600" />
@@ -0,0 +1,109 @@
+// Command backup runs and verifies the encrypted backups configured in the
+// backup section of the config.
+//
+//	go run ./cmd/backup run                          - back up now to every target
+//	go run ./cmd/backup list [target]                - list the archives of a target
+//	go run ./cmd/backup restore-drill [target] [name] - restore an archive (newest by default) into backup.scratch_database and compare row counts
+//	go run ./cmd/backup decrypt <archive> [out]       - decrypt an archive into a plain .tar.gz
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	config.LoadConfig()
+	command, args := os.Args[1], os.Args[2:]
+
+	// Decrypting needs neither the database nor the targets
+	if command == "decrypt" {
+		decrypt(args)
+		return
+	}
+
+	models.ConnectDatabase()
+	service, err := services.NewBackupServiceFromConfig(nil)
+	if err != nil {
+		log.Fatalf("backup: %v", err)
+	}
+
+	switch command {
+	case "run":
+		result, err := service.Run()
+		printJSON(result)
+		if err != nil {
+			log.Fatalf("backup: %v", err)
+		}
+	case "list":
+		target, objects, err := service.ListArchives(arg(args, 0))
+		if err != nil {
+			log.Fatalf("backup: %v", err)
+		}
+		for _, object := range objects {
+			fmt.Printf("%s\t%s\t%d\n", target.Name(), object.Name, object.Size)
+		}
+	case "restore-drill":
+		report, err := service.RestoreDrill(arg(args, 0), arg(args, 1))
+		if err != nil {
+			log.Fatalf("restore drill: %v", err)
+		}
+		printJSON(report)
+		if !report.OK() {
+			log.Fatalf("restore drill: %d tables differ, %d of %d uploads restored",
+				len(report.Mismatches), report.UploadFilesRestored, report.UploadFilesExpected)
+		}
+		log.Printf("restore drill: %s restored with %d tables matching", report.Archive, len(report.Tables))
+	default:
+		usage()
+	}
+}
+
+func decrypt(args []string) {
+	archive := arg(args, 0)
+	if archive == "" {
+		usage()
+	}
+	out := arg(args, 1)
+	if out == "" || out == archive {
+		out = strings.TrimSuffix(archive, ".enc")
+		if out == archive {
+			out += ".tar.gz"
+		}
+	}
+	service, err := services.NewBackupService(config.AppConfig.Backup, nil, nil)
+	if err != nil {
+		log.Fatalf("backup: %v", err)
+	}
+	if err := service.DecryptArchive(archive, out); err != nil {
+		log.Fatalf("decrypt: %v", err)
+	}
+	log.Printf("decrypted to %s", out)
+}
+
+func arg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+func printJSON(v interface{}) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup run | list [target] | restore-drill [target] [archive] | decrypt <archive> [out]")
+	os.Exit(2)
+}
@@ -14,6 +14,11 @@ type Config struct {
 	OpenAI      OpenAIConfig      `mapstructure:"openai"`
 	SMS         SMSConfig         `mapstructure:"sms"`
 	Push        PushConfig        `mapstructure:"push"`
+	OTP         OTPConfig         `mapstructure:"otp"`
+	Matching    MatchingConfig    `mapstructure:"matching"`
+	License     LicenseConfig     `mapstructure:"license"`
+	Outbox      OutboxConfig      `mapstructure:"outbox"`
+	Backup      BackupConfig      `mapstructure:"backup"`
 	Environment EnvironmentConfig `mapstructure:"environment"`
 }
 
@@ -31,8 +36,9 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret      string `mapstructure:"secret"`
-	ExpiryHours int    `mapstructure:"expiry_hours"`
+	Secret             string `mapstructure:"secret"`
+	ExpiryHours        int    `mapstructure:"expiry_hours"`         // access token lifetime
+	RefreshExpiryHours int    `mapstructure:"refresh_expiry_hours"` // refresh token and session lifetime
 }
 
 type CORSConfig struct {
@@ -54,6 +60,8 @@ type SMSConfig struct {
 	Originator              string `mapstructure:"originator"`
 	PatternCode             string `mapstructure:"pattern_code"`
 	PasswordRecoveryPattern string `mapstructure:"password_recovery_pattern"`
+	// الگوی پیامک کد تایید شماره موبایل؛ اگر خالی باشد از الگوی بازیابی رمز استفاده می‌شود
+	PhoneVerificationPattern string `mapstructure:"phone_verification_pattern"`
 	// برای لاگین خودکار و گرفتن توکن (روشی که جواب می‌دهد)
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
@@ -64,6 +72,86 @@ type PushConfig struct {
 	VAPIDPrivateKey string `mapstructure:"vapid_private_key"`
 	VAPIDSubject    string `mapstructure:"vapid_subject"`  // mailto: or https://
 	FCMServerKey    string `mapstructure:"fcm_server_key"` // FCM Server Key for REST API
+	// ClickBaseURL is the API base that tracked campaign links start with, as
+	// seen from the site (the site proxies /backend/api/v1 to this server)
+	ClickBaseURL string `mapstructure:"click_base_url"`
+}
+
+// OTPConfig controls one-time codes used for password recovery and phone verification
+type OTPConfig struct {
+	TTLMinutes               int  `mapstructure:"ttl_minutes"`
+	MaxAttempts              int  `mapstructure:"max_attempts"`
+	ResendCooldownSeconds    int  `mapstructure:"resend_cooldown_seconds"`
+	RequirePhoneVerification bool `mapstructure:"require_phone_verification"` // require a verified phone at registration
+}
+
+// MatchingConfig controls how visitors are scored for matching requests.
+// Weights are the maximum points each signal can contribute.
+type MatchingConfig struct {
+	CountryWeight       float64 `mapstructure:"country_weight"`
+	ProductWeight       float64 `mapstructure:"product_weight"`
+	LanguageWeight      float64 `mapstructure:"language_weight"`
+	RatingWeight        float64 `mapstructure:"rating_weight"`
+	ResponseRateWeight  float64 `mapstructure:"response_rate_weight"`
+	ExperienceWeight    float64 `mapstructure:"experience_weight"`
+	FeaturedWeight      float64 `mapstructure:"featured_weight"`
+	RequireCountryMatch bool    `mapstructure:"require_country_match"`
+	MinScore            float64 `mapstructure:"min_score"`
+	TopN                int     `mapstructure:"top_n"` // 0 = no cap
+}
+
+// LicenseConfig controls license expiry reminders and the grace period after expiry
+type LicenseConfig struct {
+	GracePeriodDays    int    `mapstructure:"grace_period_days"`    // licensed access kept after expiry
+	ReminderDays       []int  `mapstructure:"reminder_days"`        // days before expiry to remind users
+	ReminderSMSPattern string `mapstructure:"reminder_sms_pattern"` // empty disables reminder SMS
+}
+
+// OutboxConfig controls the workers that deliver queued notifications.
+// Rates are per second across all workers of a channel; 0 means unlimited.
+type OutboxConfig struct {
+	Workers        int     `mapstructure:"workers"` // workers per channel
+	MaxAttempts    int     `mapstructure:"max_attempts"`
+	SMSPerSecond   float64 `mapstructure:"sms_per_second"`
+	PushPerSecond  float64 `mapstructure:"push_per_second"`
+	InAppPerSecond float64 `mapstructure:"in_app_per_second"`
+}
+
+// BackupConfig controls the nightly backup of the database and uploads.
+// Archives are encrypted with EncryptionKey and sent to every target in
+// Targets (local, s3, telegram).
+type BackupConfig struct {
+	Enabled         bool                  `mapstructure:"enabled"`
+	Time            string                `mapstructure:"time"` // HH:MM server time of the daily run
+	Targets         []string              `mapstructure:"targets"`
+	EncryptionKey   string                `mapstructure:"encryption_key"`
+	UploadsDir      string                `mapstructure:"uploads_dir"`
+	WorkDir         string                `mapstructure:"work_dir"`         // where archives are built
+	ScratchDatabase string                `mapstructure:"scratch_database"` // restore drill target; dropped and recreated
+	Local           BackupLocalConfig     `mapstructure:"local"`
+	S3              BackupS3Config        `mapstructure:"s3"`
+	Retention       BackupRetentionConfig `mapstructure:"retention"`
+}
+
+type BackupLocalConfig struct {
+	Dir string `mapstructure:"dir"`
+}
+
+type BackupS3Config struct {
+	Endpoint  string `mapstructure:"endpoint"` // e.g. https://s3.ir-thr-at1.arvanstorage.ir or http://127.0.0.1:9000
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	Prefix    string `mapstructure:"prefix"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+}
+
+// BackupRetentionConfig is how many of the newest daily, weekly and monthly
+// archives each target keeps
+type BackupRetentionConfig struct {
+	Daily   int `mapstructure:"daily"`
+	Weekly  int `mapstructure:"weekly"`
+	Monthly int `mapstructure:"monthly"`
 }
 
 // EnvironmentConfig controls high-level deployment behaviour (e.g. Iran vs global)
@@ -86,11 +174,45 @@ func LoadConfig() {
 	viper.SetDefault("server.host", "localhost")
 	viper.SetDefault("jwt.secret", "default_secret_change_in_production")
 	viper.SetDefault("jwt.expiry_hours", 24)
+	viper.SetDefault("jwt.refresh_expiry_hours", 720)
 	viper.SetDefault("openai.api_url", "https://api.openai.com/v1/chat/completions")
 	viper.SetDefault("openai.model", "gpt-3.5-turbo")
 	viper.SetDefault("openai.max_tokens", 1000)
 	viper.SetDefault("openai.temperature", 0.7)
 	viper.SetDefault("sms.pattern_code", "9i276pvpwvuj40w")
+	viper.SetDefault("otp.ttl_minutes", 5)
+	viper.SetDefault("otp.max_attempts", 5)
+	viper.SetDefault("otp.resend_cooldown_seconds", 120)
+	viper.SetDefault("otp.require_phone_verification", false)
+	viper.SetDefault("matching.country_weight", 40)
+	viper.SetDefault("matching.product_weight", 25)
+	viper.SetDefault("matching.language_weight", 10)
+	viper.SetDefault("matching.rating_weight", 10)
+	viper.SetDefault("matching.response_rate_weight", 10)
+	viper.SetDefault("matching.experience_weight", 3)
+	viper.SetDefault("matching.featured_weight", 2)
+	viper.SetDefault("matching.require_country_match", true)
+	viper.SetDefault("matching.min_score", 30)
+	viper.SetDefault("matching.top_n", 50)
+	viper.SetDefault("license.grace_period_days", 3)
+	viper.SetDefault("license.reminder_days", []int{30, 7, 1})
+	viper.SetDefault("outbox.workers", 2)
+	viper.SetDefault("outbox.max_attempts", 5)
+	viper.SetDefault("outbox.sms_per_second", 5)
+	viper.SetDefault("outbox.push_per_second", 50)
+	viper.SetDefault("outbox.in_app_per_second", 0)
+	viper.SetDefault("push.click_base_url", "/backend/api/v1")
+	viper.SetDefault("backup.enabled", true)
+	viper.SetDefault("backup.time", "00:00")
+	viper.SetDefault("backup.targets", []string{"local"})
+	viper.SetDefault("backup.uploads_dir", "uploads")
+	viper.SetDefault("backup.work_dir", "tmp/backup")
+	viper.SetDefault("backup.local.dir", "backups")
+	viper.SetDefault("backup.s3.region", "us-east-1")
+	viper.SetDefault("backup.s3.prefix", "backups")
+	viper.SetDefault("backup.retention.daily", 7)
+	viper.SetDefault("backup.retention.weekly", 4)
+	viper.SetDefault("backup.retention.monthly", 6)
 	// By default assume non-Iran environment; can be overridden in config.yaml / production.yaml
 	viper.SetDefault("environment.is_in_iran", false)
 
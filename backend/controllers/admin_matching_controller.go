@@ -133,77 +133,12 @@ func (amc *AdminMatchingController) GetMatchingRequestStats(c *gin.Context) {
 
 // GetAllMatchingChats gets all matching chats for admin
 func (amc *AdminMatchingController) GetAllMatchingChats(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
-
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
-	}
-
-	var chats []models.MatchingChat
-	var total int64
-
-	query := amc.db.Model(&models.MatchingChat{}).
-		Preload("MatchingRequest").
-		Preload("Supplier").
-		Preload("Visitor")
-
-	if err := query.Count(&total).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در شمارش چت‌ها"})
-		return
-	}
-
-	offset := (page - 1) * perPage
-	// Order by last update time (no last_message_at column on MatchingChat)
-	if err := query.Offset(offset).Limit(perPage).
-		Order("updated_at DESC, created_at DESC").Find(&chats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت چت‌ها"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"data":        chats,
-		"total":       total,
-		"page":        page,
-		"per_page":    perPage,
-		"total_pages": (int(total) + perPage - 1) / perPage,
-	})
+	respondAdminConversations(c, amc.db, models.ConversationContextMatchingRequest)
 }
 
 // GetMatchingChatMessages gets messages for a specific chat (admin view)
 func (amc *AdminMatchingController) GetMatchingChatMessages(c *gin.Context) {
-	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه چت نامعتبر است"})
-		return
-	}
-
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
-
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 50
-	}
-
-	messages, total, err := models.GetMatchingChatMessages(amc.db, uint(chatID), page, perPage)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیام‌ها"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"messages":    messages,
-		"total":       total,
-		"page":        page,
-		"per_page":    perPage,
-		"total_pages": (int(total) + perPage - 1) / perPage,
-	})
+	respondAdminConversationMessages(c, amc.db, models.ConversationContextMatchingRequest)
 }
 
 // GetAllVisitorProjects gets all visitor projects for admin
@@ -319,44 +254,7 @@ func (amc *AdminMatchingController) GetVisitorProjectStats(c *gin.Context) {
 
 // GetAllVisitorProjectChats gets all visitor project chats for admin
 func (amc *AdminMatchingController) GetAllVisitorProjectChats(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
-
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
-	}
-
-	var chats []models.VisitorProjectChat
-	var total int64
-
-	query := amc.db.Model(&models.VisitorProjectChat{}).
-		Preload("VisitorProject").
-		Preload("Supplier").
-		Preload("Visitor")
-
-	if err := query.Count(&total).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در شمارش چت‌ها"})
-		return
-	}
-
-	offset := (page - 1) * perPage
-	// Use portable ordering without database-specific NULLS LAST syntax
-	if err := query.Offset(offset).Limit(perPage).
-		Order("last_message_at DESC, created_at DESC").Find(&chats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت چت‌ها"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"data":        chats,
-		"total":       total,
-		"page":        page,
-		"per_page":    perPage,
-		"total_pages": (int(total) + perPage - 1) / perPage,
-	})
+	respondAdminConversations(c, amc.db, models.ConversationContextVisitorProject)
 }
 
 // ==================== Admin mutations for Matching Requests ====================
@@ -527,33 +425,5 @@ func (amc *AdminMatchingController) DeleteVisitorProjectAdmin(c *gin.Context) {
 
 // GetVisitorProjectChatMessages gets messages for a specific visitor project chat (admin view)
 func (amc *AdminMatchingController) GetVisitorProjectChatMessages(c *gin.Context) {
-	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه چت نامعتبر است"})
-		return
-	}
-
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
-
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 50
-	}
-
-	messages, total, err := models.GetVisitorProjectChatMessages(amc.db, uint(chatID), page, perPage)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیام‌ها"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"messages":    messages,
-		"total":       total,
-		"page":        page,
-		"per_page":    perPage,
-		"total_pages": (int(total) + perPage - 1) / perPage,
-	})
+	respondAdminConversationMessages(c, amc.db, models.ConversationContextVisitorProject)
 }
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -178,33 +179,32 @@ func (ac *AffiliateController) GetDashboard(c *gin.Context) {
 		referralLink = "" // Will show "درحال آماده سازی لینک شما..." in frontend
 	}
 
-	// درآمد واقعی = مجموع مبلغ پرداخت‌های تأییدشده (همان لیست خریداران بالا). هر پرداخت بدون مبلغ یا ۰ = ۶ میلیون تومان. درآمد کل = واقعی × (درصد افیلیت/۱۰۰)
-	var realIncome float64
+	// درآمد واقعی = مجموع مبلغ فروش‌های تأییدشده در لیست خریداران بالا (هر فروش بدون مبلغ یا ۰ = ۶ میلیون تومان)
+	var realIncome int64
 	for _, b := range confirmedBuyers {
 		amt := int64(models.DefaultAmountToman)
 		if b.AmountToman != nil && *b.AmountToman > 0 {
 			amt = *b.AmountToman
 		}
-		realIncome += float64(amt)
+		realIncome += amt
 	}
-	percent := aff.CommissionPercent
-	if percent <= 0 {
-		percent = 100
+	// درآمد افیلیت از دفتر پورسانت: پورسانت لایسنس‌های کاربران معرفی‌شده و فروش‌های آفلاین (لیست فروش / CSV) جدا
+	licenseIncome, offlineIncome, err := models.GetAffiliateEarnings(db, affID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در بارگذاری اطلاعات پیش آمد. لطفاً صفحه را رفرش کنید."})
+		return
 	}
-	totalIncome := realIncome * (percent / 100)
-	log.Printf("[Affiliate] GetDashboard affID=%d confirmedBuyers=%d realIncome=%.0f totalIncome=%.0f", affID, len(confirmedBuyers), realIncome, totalIncome)
-
-	// اعداد صحیح برای JSON تا فرانت بدون ابهام بخواند
-	realIncomeInt := int64(realIncome)
-	totalIncomeInt := int64(totalIncome)
+	log.Printf("[Affiliate] GetDashboard affID=%d confirmedBuyers=%d realIncome=%d licenseIncome=%.0f offlineIncome=%.0f", affID, len(confirmedBuyers), realIncome, licenseIncome, offlineIncome)
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
 			"referral_link":          referralLink,
 			"referral_code":          aff.ReferralCode,
 			"total_signups":          totalSignups,
-			"real_income":            realIncomeInt,
-			"total_income":           totalIncomeInt,
+			"real_income":            realIncome,
+			"total_income":           int64(licenseIncome + offlineIncome),
+			"license_income":         int64(licenseIncome),
+			"offline_income":         int64(offlineIncome),
 			"balance":                aff.Balance,
 			"registrations_chart":    chartData,
 			"sales_chart":            salesChartData,
@@ -358,7 +358,7 @@ func (ac *AffiliateController) CreateWithdrawalRequest(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
-	// اجازه ثبت درخواست بدون بررسی موجودی — ادمین در بخش مدیریت افیلیت وضعیت را بررسی می‌کند
+	// مبلغ درخواست در همان تراکنش از موجودی کسر و تا تعیین وضعیت توسط ادمین نگه داشته می‌شود
 	wr := &models.AffiliateWithdrawalRequest{
 		AffiliateID:    affID,
 		Amount:         req.Amount,
@@ -369,6 +369,10 @@ func (ac *AffiliateController) CreateWithdrawalRequest(c *gin.Context) {
 		BankName:       req.BankName,
 	}
 	if err := models.CreateAffiliateWithdrawalRequest(db, wr); err != nil {
+		if errors.Is(err, models.ErrInsufficientAffiliateBalance) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "مبلغ درخواستی بیشتر از موجودی قابل برداشت است"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ثبت درخواست"})
 		return
 	}
@@ -426,6 +430,42 @@ func (ac *AffiliateController) GetWithdrawalRequests(c *gin.Context) {
 	})
 }
 
+// GetLedger returns the affiliate's balance and ledger entries
+func (ac *AffiliateController) GetLedger(c *gin.Context) {
+	affID := getAffiliateID(c)
+	page := 1
+	perPage := 20
+	if p := c.Query("page"); p != "" {
+		if v, _ := parseInt(p); v > 0 {
+			page = v
+		}
+	}
+	if pp := c.Query("per_page"); pp != "" {
+		if v, _ := parseInt(pp); v > 0 && v <= 100 {
+			perPage = v
+		}
+	}
+	balance, err := models.GetAffiliateLedgerBalance(ac.DB, affID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در بارگذاری اطلاعات پیش آمد. لطفاً صفحه را رفرش کنید."})
+		return
+	}
+	entries, total, err := models.GetAffiliateLedger(ac.DB, affID, perPage, (page-1)*perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در بارگذاری اطلاعات پیش آمد. لطفاً صفحه را رفرش کنید."})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"balance":  balance,
+			"entries":  entries,
+			"total":    total,
+			"page":     page,
+			"per_page": perPage,
+		},
+	})
+}
+
 func parseInt(s string) (int, bool) {
 	var n int
 	_, err := fmt.Sscanf(s, "%d", &n)
@@ -57,10 +57,14 @@ func Chat(c *gin.Context) {
 	}
 
 	if !canSend {
+		dailyLimit := models.DailyAIMessageLimit
+		if plan, err := models.GetUserLicensePlan(db, user.ID); err == nil {
+			dailyLimit = plan.DailyAIMessages
+		}
 		c.JSON(http.StatusTooManyRequests, gin.H{
 			"error":           "Daily message limit exceeded",
-			"message":         "شما به حد روزانه ۲۰ پیام رسیده‌اید. فردا دوباره تلاش کنید.",
-			"daily_limit":     models.DailyAIMessageLimit,
+			"message":         fmt.Sprintf("شما به حد روزانه %d پیام رسیده‌اید. فردا دوباره تلاش کنید.", dailyLimit),
+			"daily_limit":     dailyLimit,
 			"remaining_count": remaining,
 		})
 		return
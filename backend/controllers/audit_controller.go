@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"asl-market-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// auditExportLimit caps the number of rows written to an audit Excel export
+const auditExportLimit = 50000
+
+// auditSnapshot loads the bare row of a record (no associations) for an audit
+// diff. It returns nil if the record cannot be loaded.
+func auditSnapshot(dest interface{}, id uint) interface{} {
+	if err := models.GetDB().First(dest, id).Error; err != nil {
+		return nil
+	}
+	return dest
+}
+
+// parseAuditFilter reads audit filters from the query string
+func parseAuditFilter(c *gin.Context) (models.AuditEventFilter, error) {
+	filter := models.AuditEventFilter{
+		ActorType:  c.Query("actor_type"),
+		Action:     strings.TrimSpace(c.Query("action")),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+
+	if v := c.Query("actor_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("شناسه مدیر نامعتبر است")
+		}
+		actorID := uint(id)
+		filter.ActorID = &actorID
+	}
+	if v := c.Query("actor_telegram_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("شناسه تلگرام نامعتبر است")
+		}
+		filter.ActorTelegramID = &id
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("تاریخ شروع نامعتبر است (YYYY-MM-DD)")
+		}
+		filter.From = &from
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("تاریخ پایان نامعتبر است (YYYY-MM-DD)")
+		}
+		// Inclusive of the whole day
+		to = to.Add(24*time.Hour - time.Nanosecond)
+		filter.To = &to
+	}
+	return filter, nil
+}
+
+// GetAuditEvents returns the admin audit log with filters and pagination
+func GetAuditEvents(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+
+	events, total, err := models.GetAuditEvents(models.GetDB(), filter, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت گزارش عملیات"})
+		return
+	}
+
+	items := make([]models.AuditEventResponse, len(events))
+	for i, event := range events {
+		items[i] = event.ToResponse()
+	}
+
+	totalPages := (int(total) + perPage - 1) / perPage
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"events":      items,
+			"total":       total,
+			"page":        page,
+			"per_page":    perPage,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// ExportAuditEventsToExcel writes the filtered audit log to an Excel file
+func ExportAuditEventsToExcel(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := models.GetAuditEventsForExport(models.GetDB(), filter, auditExportLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت گزارش عملیات"})
+		return
+	}
+
+	f := excelize.NewFile()
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Error closing Excel file: %v", err)
+		}
+	}()
+
+	sheetName := "Audit"
+	index, err := f.NewSheet(sheetName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد فایل Excel"})
+		return
+	}
+
+	headers := []string{"ID", "زمان", "نوع عامل", "شناسه عامل", "تلگرام", "نام عامل", "عملیات", "نوع موجودیت", "شناسه موجودیت", "تغییرات", "جزئیات", "IP"}
+	for i, header := range headers {
+		cell := fmt.Sprintf("%c1", 'A'+i)
+		f.SetCellValue(sheetName, cell, header)
+	}
+
+	for i, event := range events {
+		row := i + 2
+		resp := event.ToResponse()
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), event.ID)
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), event.CreatedAt.Format("2006-01-02 15:04:05"))
+		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), event.ActorType)
+		if event.ActorID != nil {
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), *event.ActorID)
+		}
+		if event.ActorTelegramID != nil {
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), *event.ActorTelegramID)
+		}
+		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), event.ActorName)
+		f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), event.Action)
+		f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), event.TargetType)
+		f.SetCellValue(sheetName, fmt.Sprintf("I%d", row), event.TargetID)
+		f.SetCellValue(sheetName, fmt.Sprintf("J%d", row), formatAuditChanges(resp.Changes))
+		f.SetCellValue(sheetName, fmt.Sprintf("K%d", row), event.Metadata)
+		f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), event.IPAddress)
+	}
+
+	f.SetActiveSheet(index)
+
+	if err := os.MkdirAll("./uploads/exports", 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره فایل Excel"})
+		return
+	}
+	filename := fmt.Sprintf("audit_export_%s.xlsx", time.Now().Format("20060102_150405"))
+	filepath := fmt.Sprintf("./uploads/exports/%s", filename)
+
+	if err := f.SaveAs(filepath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره فایل Excel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "فایل Excel با موفقیت ایجاد شد",
+		"filename": filename,
+		"url":      fmt.Sprintf("/uploads/exports/%s", filename),
+		"count":    len(events),
+	})
+}
+
+// formatAuditChanges renders a diff as "field: before → after" lines
+func formatAuditChanges(changes map[string]models.AuditChange) string {
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("%s: %v → %v", key, changes[key].Before, changes[key].After)
+	}
+	return strings.Join(lines, "\n")
+}
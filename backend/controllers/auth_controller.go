@@ -1,12 +1,12 @@
 package controllers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"strings"
+	"time"
 
 	"asl-market-backend/middleware"
 	"asl-market-backend/models"
@@ -25,6 +25,31 @@ func NewAuthController(db *gorm.DB) *AuthController {
 	return &AuthController{DB: db}
 }
 
+// issueTokens starts an auth session for the subject and returns its access
+// and refresh tokens
+func (ac *AuthController) issueTokens(c *gin.Context, subjectType string, subjectID uint, identifier string) (string, string, error) {
+	refreshToken, refreshHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	session, err := models.CreateAuthSession(ac.DB, subjectType, subjectID, refreshHash,
+		c.Request.UserAgent(), c.ClientIP(), time.Now().Add(utils.RefreshTokenTTL()))
+	if err != nil {
+		return "", "", err
+	}
+
+	var accessToken string
+	if subjectType == utils.SubjectAffiliate {
+		accessToken, err = utils.GenerateAffiliateToken(subjectID, identifier, session.ID)
+	} else {
+		accessToken, err = utils.GenerateToken(subjectType, subjectID, identifier, session.ID)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
 func (ac *AuthController) Register(c *gin.Context) {
 	var req models.RegisterRequest
 
@@ -89,7 +114,22 @@ func (ac *AuthController) Register(c *gin.Context) {
 		AffiliateID: affiliateID,
 	}
 
-	if err := ac.DB.Create(&user).Error; err != nil {
+	// Require a verified phone number when enabled in config. It is consumed
+	// with the insert, so a failed registration can be retried.
+	var phoneErr error
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if phoneErr = services.NewOTPService(tx).RequirePhoneVerified(req.Phone); phoneErr != nil {
+			return phoneErr
+		}
+		return tx.Create(&user).Error
+	})
+	if phoneErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": services.OTPErrorMessage(phoneErr),
+		})
+		return
+	}
+	if err != nil {
 		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در ایجاد کاربر", err, "register_user")
 		return
 	}
@@ -100,7 +140,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 		identifier = user.Email
 	}
 
-	token, err := utils.GenerateToken(user.ID, identifier)
+	token, refreshToken, err := ac.issueTokens(c, utils.SubjectUser, user.ID, identifier)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید.",
@@ -111,8 +151,9 @@ func (ac *AuthController) Register(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "ثبت‌نام با موفقیت انجام شد",
 		"data": models.AuthResponse{
-			Token: token,
-			User:  user.ToResponse(),
+			Token:        token,
+			RefreshToken: refreshToken,
+			User:         user.ToResponse(),
 		},
 	})
 }
@@ -183,7 +224,7 @@ func (ac *AuthController) Login(c *gin.Context) {
 		identifier = user.Email
 	}
 
-	token, err := utils.GenerateToken(user.ID, identifier)
+	token, refreshToken, err := ac.issueTokens(c, utils.SubjectUser, user.ID, identifier)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید.",
@@ -194,8 +235,9 @@ func (ac *AuthController) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "ورود موفقیت‌آمیز",
 		"data": models.AuthResponse{
-			Token: token,
-			User:  user.ToResponse(),
+			Token:        token,
+			RefreshToken: refreshToken,
+			User:         user.ToResponse(),
 		},
 	})
 }
@@ -291,7 +333,7 @@ func (ac *AuthController) AdminLogin(c *gin.Context) {
 				identifier = user.Email
 			}
 
-			token, err := utils.GenerateToken(user.ID, identifier)
+			token, refreshToken, err := ac.issueTokens(c, utils.SubjectUser, user.ID, identifier)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید.",
@@ -300,8 +342,9 @@ func (ac *AuthController) AdminLogin(c *gin.Context) {
 			}
 
 			c.JSON(http.StatusOK, gin.H{
-				"message": "ورود موفقیت‌آمیز",
-				"token":   token,
+				"message":       "ورود موفقیت‌آمیز",
+				"token":         token,
+				"refresh_token": refreshToken,
 				"user": gin.H{
 					"id":          user.ID,
 					"name":        fmt.Sprintf("%s %s", user.FirstName, user.LastName),
@@ -352,21 +395,15 @@ func (ac *AuthController) AdminLogin(c *gin.Context) {
 		log.Printf("AdminLogin: Error updating last login: %v", err)
 	}
 
-	// Parse permissions
-	permissions := []string{}
-	if webAdmin.Permissions != "" {
-		if webAdmin.Permissions[0] == '[' {
-			_ = json.Unmarshal([]byte(webAdmin.Permissions), &permissions)
-		} else {
-			parts := strings.Split(webAdmin.Permissions, ",")
-			for _, part := range parts {
-				permissions = append(permissions, strings.TrimSpace(part))
-			}
-		}
+	// Effective permissions: the role's permissions plus the admin's own grants
+	permissions, err := models.GetEffectivePermissions(ac.DB, webAdmin.Role, webAdmin.PermissionList())
+	if err != nil {
+		log.Printf("AdminLogin: Error loading permissions: %v", err)
+		permissions = webAdmin.PermissionList()
 	}
 
 	// Generate token
-	token, err := utils.GenerateToken(webAdmin.ID, webAdmin.Username)
+	token, refreshToken, err := ac.issueTokens(c, utils.SubjectWebAdmin, webAdmin.ID, webAdmin.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید.",
@@ -375,8 +412,9 @@ func (ac *AuthController) AdminLogin(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "ورود موفقیت‌آمیز",
-		"token":   token,
+		"message":       "ورود موفقیت‌آمیز",
+		"token":         token,
+		"refresh_token": refreshToken,
 		"user": gin.H{
 			"id":          webAdmin.ID,
 			"name":        webAdmin.Name,
@@ -443,7 +481,7 @@ func (ac *AuthController) AffiliateLogin(c *gin.Context) {
 	}
 
 	// Generate token
-	token, err := utils.GenerateAffiliateToken(aff.ID, aff.Username)
+	token, refreshToken, err := ac.issueTokens(c, utils.SubjectAffiliate, aff.ID, aff.Username)
 	if err != nil {
 		log.Printf("AffiliateLogin: Error generating token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید."})
@@ -453,8 +491,9 @@ func (ac *AuthController) AffiliateLogin(c *gin.Context) {
 	log.Printf("AffiliateLogin: Login successful for affiliate ID=%d, Username=%s", aff.ID, aff.Username)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "ورود موفقیت‌آمیز",
-		"token":   token,
+		"message":       "ورود موفقیت‌آمیز",
+		"token":         token,
+		"refresh_token": refreshToken,
 		"user": gin.H{
 			"id":             aff.ID,
 			"name":           aff.Name,
@@ -466,6 +505,123 @@ func (ac *AuthController) AffiliateLogin(c *gin.Context) {
 	})
 }
 
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. The presented refresh token stops working.
+func (ac *AuthController) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	newRefreshToken, newHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید."})
+		return
+	}
+
+	session, err := models.RotateAuthSession(ac.DB, utils.HashRefreshToken(req.RefreshToken), newHash, time.Now().Add(utils.RefreshTokenTTL()))
+	if err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) || errors.Is(err, models.ErrSessionRevoked) || errors.Is(err, models.ErrSessionExpired) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "نشست شما منقضی شده است. لطفاً دوباره وارد شوید."})
+			return
+		}
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در تمدید نشست", err, "refresh_token")
+		return
+	}
+
+	identifier, active := ac.sessionSubject(session)
+	if !active {
+		models.RevokeAuthSession(ac.DB, session.ID, models.SessionRevokeDeactivated)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "حساب کاربری غیرفعال شده است"})
+		return
+	}
+
+	var token string
+	if session.SubjectType == utils.SubjectAffiliate {
+		token, err = utils.GenerateAffiliateToken(session.SubjectID, identifier, session.ID)
+	} else {
+		token, err = utils.GenerateToken(session.SubjectType, session.SubjectID, identifier, session.ID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در ایجاد نشست کاربری پیش آمد. لطفاً دوباره تلاش کنید."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": newRefreshToken,
+	})
+}
+
+// sessionSubject returns the token identifier of a session's subject and
+// whether the subject may still sign in
+func (ac *AuthController) sessionSubject(session *models.AuthSession) (string, bool) {
+	switch session.SubjectType {
+	case utils.SubjectUser:
+		var user models.User
+		if err := ac.DB.First(&user, session.SubjectID).Error; err != nil {
+			return "", false
+		}
+		if user.Email != "" {
+			return user.Email, user.IsActive
+		}
+		return user.Phone, user.IsActive
+	case utils.SubjectWebAdmin:
+		var admin models.WebAdmin
+		if err := ac.DB.Where("id = ? AND deleted_at IS NULL", session.SubjectID).First(&admin).Error; err != nil {
+			return "", false
+		}
+		return admin.Username, admin.IsActive
+	case utils.SubjectAffiliate:
+		var aff models.Affiliate
+		if err := ac.DB.First(&aff, session.SubjectID).Error; err != nil {
+			return "", false
+		}
+		return aff.Username, aff.IsActive
+	}
+	return "", false
+}
+
+// currentSubject returns the subject type and ID of the authenticated caller
+func currentSubject(c *gin.Context) (string, uint) {
+	subjectType := c.GetString("subject_type")
+	if subjectType == utils.SubjectAffiliate {
+		return subjectType, c.GetUint("affiliate_id")
+	}
+	return subjectType, c.GetUint("user_id")
+}
+
+// Logout revokes the caller's current session
+func (ac *AuthController) Logout(c *gin.Context) {
+	if err := models.RevokeAuthSession(ac.DB, c.GetUint("session_id"), models.SessionRevokeLogout); err != nil {
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در خروج از حساب", err, "logout")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "با موفقیت از حساب خارج شدید",
+		"success": true,
+	})
+}
+
+// LogoutAll revokes every session of the caller ("log out all devices")
+func (ac *AuthController) LogoutAll(c *gin.Context) {
+	subjectType, subjectID := currentSubject(c)
+	if err := models.RevokeAllAuthSessions(ac.DB, subjectType, subjectID, models.SessionRevokeLogoutAll); err != nil {
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در خروج از همه دستگاه‌ها", err, "logout_all")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "از همه دستگاه‌ها خارج شدید",
+		"success": true,
+	})
+}
+
 func (ac *AuthController) Me(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -516,16 +672,17 @@ func (ac *AuthController) RequestPasswordRecovery(c *gin.Context) {
 		return
 	}
 
-	recoveryCode := fmt.Sprintf("%06d", rand.Intn(1000000))
-	smsService := services.GetSMSService()
-	if smsService != nil {
-		phoneNumber := services.ValidateIranianPhoneNumber(req.Phone)
-		if phoneNumber != "" {
-			err := smsService.SendPasswordRecoverySMS(phoneNumber, recoveryCode)
-			if err != nil {
-				fmt.Printf("Failed to send password recovery SMS: %v\n", err)
-			}
+	otpService := services.NewOTPService(ac.DB)
+	if err := otpService.SendCode(user.Phone, models.OTPPurposePasswordRecovery); err != nil {
+		if err == models.ErrOTPCooldown {
+			remaining := otpService.CooldownRemaining(user.Phone, models.OTPPurposePasswordRecovery)
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       services.OTPErrorMessage(err),
+				"retry_after": int(remaining.Seconds()),
+			})
+			return
 		}
+		log.Printf("Failed to send password recovery code: %v", err)
 	}
 
 	c.JSON(http.StatusOK, models.PasswordRecoveryResponse{
@@ -534,6 +691,65 @@ func (ac *AuthController) RequestPasswordRecovery(c *gin.Context) {
 	})
 }
 
+// SendPhoneVerificationCode sends a verification code to a phone number before registration
+func (ac *AuthController) SendPhoneVerificationCode(c *gin.Context) {
+	var req models.PhoneVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	otpService := services.NewOTPService(ac.DB)
+	if err := otpService.SendCode(req.Phone, models.OTPPurposePhoneVerification); err != nil {
+		if err == models.ErrOTPCooldown {
+			remaining := otpService.CooldownRemaining(req.Phone, models.OTPPurposePhoneVerification)
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       services.OTPErrorMessage(err),
+				"retry_after": int(remaining.Seconds()),
+			})
+			return
+		}
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در ارسال کد تایید", err, "send_phone_verification_code")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "کد تایید به شماره موبایل شما ارسال شد",
+		"success": true,
+	})
+}
+
+// VerifyPhone confirms a phone verification code
+func (ac *AuthController) VerifyPhone(c *gin.Context) {
+	var req models.PhoneVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := services.NewOTPService(ac.DB).VerifyPhone(req.Phone, req.Code); err != nil {
+		if !services.IsOTPRejection(err) {
+			middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در بررسی کد تایید", err, "verify_phone")
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": services.OTPErrorMessage(err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "شماره موبایل با موفقیت تایید شد",
+		"success": true,
+	})
+}
+
 // ResetPassword resets password using recovery code
 func (ac *AuthController) ResetPassword(c *gin.Context) {
 	var req models.PasswordResetRequest
@@ -555,8 +771,17 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	// TODO: Verify the recovery code (you should implement proper code verification)
-	// For now, we'll accept any code for demonstration
+	// Verify the recovery code (single use, limited attempts)
+	if err := services.NewOTPService(ac.DB).Verify(user.Phone, models.OTPPurposePasswordRecovery, req.Code); err != nil {
+		if !services.IsOTPRejection(err) {
+			middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در بررسی کد بازیابی", err, "reset_password_verify_code")
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": services.OTPErrorMessage(err),
+		})
+		return
+	}
 
 	// Hash the new password
 	hashedPassword, err := utils.HashPassword(req.NewPassword)
@@ -575,6 +800,11 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	// Sign out every device that used the old password
+	if err := models.RevokeAllAuthSessions(ac.DB, utils.SubjectUser, user.ID, models.SessionRevokeLogoutAll); err != nil {
+		log.Printf("ResetPassword: failed to revoke sessions for user %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "رمز عبور با موفقیت تغییر یافت",
 		"success": true,
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"asl-market-backend/middleware"
+	"asl-market-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chatHeartbeatInterval keeps proxies from closing an idle chat stream
+const chatHeartbeatInterval = 25 * time.Second
+
+// IssueChatStreamTicket returns a short-lived, single-use ticket for opening
+// the chat stream as /chat/stream?ticket=, since EventSource cannot send the
+// Authorization header
+func IssueChatStreamTicket(c *gin.Context) {
+	if c.GetBool("is_web_admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "این بخش فقط برای کاربران در دسترس است"})
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	ticket, expiresAt, err := middleware.IssueStreamTicket(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد بلیت اتصال"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_at": expiresAt})
+}
+
+// StreamChatEvents holds a Server-Sent Events stream open and forwards the
+// user's matching and visitor-project chat events (new messages, typing and
+// read receipts) as they happen
+func StreamChatEvents(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+		return
+	}
+	// Admin IDs live in their own table and would collide with user IDs
+	if c.GetBool("is_web_admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "این بخش فقط برای کاربران در دسترس است"})
+		return
+	}
+	userIDUint := userID.(uint)
+
+	events, unsubscribe := services.GetChatHub().Subscribe(userIDUint)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // disable nginx response buffering
+	c.Status(http.StatusOK)
+
+	c.SSEvent("ready", gin.H{"user_id": userIDUint})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(chatHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			c.SSEvent(event.Type, event)
+		case now := <-heartbeat.C:
+			c.SSEvent("ping", now.Unix())
+		}
+		c.Writer.Flush()
+	}
+}
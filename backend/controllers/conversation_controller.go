@@ -0,0 +1,496 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ConversationController handles the conversation subsystem shared by
+// matching, visitor project and direct chats
+type ConversationController struct {
+	db *gorm.DB
+}
+
+// NewConversationController creates a new conversation controller
+func NewConversationController(db *gorm.DB) *ConversationController {
+	return &ConversationController{db: db}
+}
+
+// GetConversations lists the current user's conversations (?context_type=)
+func (cc *ConversationController) GetConversations(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+		return
+	}
+	userIDUint := userID.(uint)
+	page, perPage := conversationPagination(c, 20)
+
+	conversations, total, err := models.GetConversationsForUser(cc.db, userIDUint, c.Query("context_type"), page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت مکالمات"})
+		return
+	}
+	views, err := models.BuildConversationViews(cc.db, conversations, userIDUint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت مکالمات"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": views,
+		"pagination": gin.H{
+			"page":        page,
+			"per_page":    perPage,
+			"total":       total,
+			"total_pages": (total + int64(perPage) - 1) / int64(perPage),
+		},
+	})
+}
+
+// StartDirectConversation opens (or returns) the direct conversation between
+// the current user and user_id
+func (cc *ConversationController) StartDirectConversation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+		return
+	}
+	userIDUint := userID.(uint)
+
+	var req struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات نامعتبر است"})
+		return
+	}
+	if req.UserID == userIDUint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "امکان گفتگو با خودتان وجود ندارد"})
+		return
+	}
+	var other models.User
+	if err := cc.db.Where("id = ? AND is_active = ?", req.UserID, true).First(&other).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر مورد نظر یافت نشد"})
+		return
+	}
+
+	conversation, err := models.GetOrCreateDirectConversation(cc.db, userIDUint, other.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد گفتگو"})
+		return
+	}
+	views, err := models.BuildConversationViews(cc.db, []models.Conversation{*conversation}, userIDUint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد گفتگو"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": views[0]})
+}
+
+// GetConversationMessages gets a page of messages and marks them as read
+func (cc *ConversationController) GetConversationMessages(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	respondConversationMessages(c, cc.db, conversation, userID)
+}
+
+// SendConversationMessage sends a message with optional attachments
+func (cc *ConversationController) SendConversationMessage(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	message, ok := sendConversationMessage(c, cc.db, conversation, userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "پیام با موفقیت ارسال شد",
+		"data":    message,
+	})
+}
+
+// EditConversationMessage replaces the text of one of the current user's messages
+func (cc *ConversationController) EditConversationMessage(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	messageID, err := strconv.ParseUint(c.Param("messageId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه پیام نامعتبر است"})
+		return
+	}
+
+	var req models.EditConversationMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "متن پیام الزامی است"})
+		return
+	}
+
+	message, err := models.EditConversationMessage(cc.db, conversation.ID, uint(messageID), userID, req.Message)
+	if err != nil {
+		respondConversationError(c, err)
+		return
+	}
+	messages := []models.ConversationMessage{*message}
+	models.FillConversationMessages(conversation, messages)
+	publishConversationEvent(conversation, userID, services.ChatEventMessageUpdated, messages[0])
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "پیام ویرایش شد",
+		"data":    messages[0],
+	})
+}
+
+// DeleteConversationMessage removes one of the current user's messages
+func (cc *ConversationController) DeleteConversationMessage(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	messageID, err := strconv.ParseUint(c.Param("messageId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه پیام نامعتبر است"})
+		return
+	}
+
+	message, err := models.DeleteConversationMessage(cc.db, conversation.ID, uint(messageID), userID)
+	if err != nil {
+		respondConversationError(c, err)
+		return
+	}
+	publishConversationEvent(conversation, userID, services.ChatEventMessageDeleted, gin.H{"message_id": message.ID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "پیام حذف شد"})
+}
+
+// MarkConversationRead marks the messages the current user received as read
+func (cc *ConversationController) MarkConversationRead(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	count := markConversationRead(cc.db, conversation, userID)
+	c.JSON(http.StatusOK, gin.H{"marked": count})
+}
+
+// SendConversationTyping tells the other participants that the current user
+// started or stopped typing
+func (cc *ConversationController) SendConversationTyping(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	sendConversationTyping(c, conversation, userID)
+}
+
+// GetConversationsForAdmin lists every conversation (?context_type=)
+func (cc *ConversationController) GetConversationsForAdmin(c *gin.Context) {
+	respondAdminConversations(c, cc.db, c.Query("context_type"))
+}
+
+// GetConversationMessagesForAdmin gets a conversation's messages without
+// touching its read state
+func (cc *ConversationController) GetConversationMessagesForAdmin(c *gin.Context) {
+	respondAdminConversationMessages(c, cc.db, "")
+}
+
+// conversationPagination reads page and per_page, capping per_page at 100
+func conversationPagination(c *gin.Context, defaultPerPage int) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = defaultPerPage
+	}
+	return page, perPage
+}
+
+// loadParticipantConversation loads the conversation in :id and checks that
+// the current user takes part in it. A non-empty contextType also requires
+// the conversation to be of that type. On failure the error response is
+// already written.
+func loadParticipantConversation(c *gin.Context, db *gorm.DB, contextType string) (*models.Conversation, uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+		return nil, 0, false
+	}
+	// Admin IDs live in their own table and would collide with user IDs
+	if c.GetBool("is_web_admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
+		return nil, 0, false
+	}
+	userIDUint := userID.(uint)
+
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه چت نامعتبر است"})
+		return nil, 0, false
+	}
+	conversation, err := models.GetConversation(db, uint(conversationID))
+	if err != nil || (contextType != "" && conversation.ContextType != contextType) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "چت یافت نشد"})
+		return nil, 0, false
+	}
+	if conversation.Participant(userIDUint) == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
+		return nil, 0, false
+	}
+	return conversation, userIDUint, true
+}
+
+// respondConversationError writes the response for an error from the
+// conversation model
+func respondConversationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotConversationParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
+	case errors.Is(err, models.ErrConversationClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "این گفتگو بسته شده است"})
+	case errors.Is(err, models.ErrEmptyConversationMessage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "متن پیام یا فایل پیوست الزامی است"})
+	case errors.Is(err, models.ErrTooManyAttachments):
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("هر پیام حداکثر %d فایل پیوست می‌تواند داشته باشد", models.MaxConversationAttachments)})
+	case errors.Is(err, models.ErrConversationMessageNotOwned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "فقط فرستنده می‌تواند پیام را ویرایش یا حذف کند"})
+	case errors.Is(err, models.ErrConversationMessageRemoved):
+		c.JSON(http.StatusConflict, gin.H{"error": "این پیام حذف شده است"})
+	case errors.Is(err, models.ErrOfferMessageLocked):
+		c.JSON(http.StatusConflict, gin.H{"error": "پیام پیشنهاد قیمت قابل ویرایش یا حذف نیست"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "پیام یافت نشد"})
+	default:
+		log.Printf("Conversation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در پردازش پیام"})
+	}
+}
+
+// respondConversationMessages writes a page of messages for a participant
+// and marks the conversation as read for them
+func respondConversationMessages(c *gin.Context, db *gorm.DB, conversation *models.Conversation, userID uint) {
+	page, perPage := conversationPagination(c, 50)
+
+	messages, total, err := models.GetConversationMessages(db, conversation.ID, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیام‌ها"})
+		return
+	}
+	models.FillConversationMessages(conversation, messages)
+	markConversationRead(db, conversation, userID)
+
+	writeConversationMessages(c, messages, total, page, perPage)
+}
+
+// writeConversationMessages writes messages with pagination in both the flat
+// form of the visitor project chat API and the nested form of the matching one
+func writeConversationMessages(c *gin.Context, messages []models.ConversationMessage, total int64, page, perPage int) {
+	totalPages := (total + int64(perPage) - 1) / int64(perPage)
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    messages,
+		"total":       total,
+		"page":        page,
+		"per_page":    perPage,
+		"total_pages": totalPages,
+		"pagination": gin.H{
+			"page":        page,
+			"per_page":    perPage,
+			"total":       total,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// sendConversationMessage binds and stores a message from userID and delivers
+// it to the other participants. On failure the error response is already
+// written.
+func sendConversationMessage(c *gin.Context, db *gorm.DB, conversation *models.Conversation, userID uint) (*models.ConversationMessage, bool) {
+	var req models.SendConversationMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "اطلاعات ارسالی نامعتبر است",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	message, err := models.CreateConversationMessage(db, conversation, userID, req.Message, req.AttachmentInputs())
+	if err != nil {
+		respondConversationError(c, err)
+		return nil, false
+	}
+	return deliverConversationMessage(conversation, userID, message), true
+}
+
+// deliverConversationMessage fills the response fields of a new message from
+// userID and delivers it to the other participants, by web push to those
+// with no open stream
+func deliverConversationMessage(conversation *models.Conversation, userID uint, message *models.ConversationMessage) *models.ConversationMessage {
+	messages := []models.ConversationMessage{*message}
+	models.FillConversationMessages(conversation, messages)
+	message = &messages[0]
+
+	push := conversationPushMessage(conversation, message)
+	for _, recipientID := range conversation.OtherParticipants(userID) {
+		services.DeliverChatMessage(recipientID, conversationEvent(conversation, services.ChatEventMessage, message), push)
+	}
+	return message
+}
+
+// sendConversationTyping publishes a typing event from userID. Typing events
+// are only sent to open streams.
+func sendConversationTyping(c *gin.Context, conversation *models.Conversation, userID uint) {
+	var req models.ChatTypingRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات ارسالی نامعتبر است", "details": err.Error()})
+		return
+	}
+
+	delivered := publishConversationEvent(conversation, userID, services.ChatEventTyping,
+		gin.H{"user_id": userID, "typing": req.IsTyping()})
+	c.JSON(http.StatusOK, gin.H{"delivered": delivered})
+}
+
+// markConversationRead marks userID's received messages as read and sends a
+// read receipt to the other participants when anything changed
+func markConversationRead(db *gorm.DB, conversation *models.Conversation, userID uint) int64 {
+	count, err := models.MarkConversationRead(db, conversation.ID, userID)
+	if err != nil {
+		log.Printf("Failed to mark conversation %d as read: %v", conversation.ID, err)
+		return 0
+	}
+	if count > 0 {
+		publishConversationEvent(conversation, userID, services.ChatEventRead,
+			gin.H{"reader_id": userID, "count": count, "read_at": time.Now()})
+	}
+	return count
+}
+
+// publishConversationEvent sends an event to every participant but userID
+// and reports whether any of them had an open stream
+func publishConversationEvent(conversation *models.Conversation, userID uint, eventType string, data interface{}) bool {
+	delivered := false
+	for _, recipientID := range conversation.OtherParticipants(userID) {
+		if services.GetChatHub().Publish(recipientID, conversationEvent(conversation, eventType, data)) {
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+// conversationEvent builds a chat event that names the conversation both by
+// its own ID and by the ID its context's chat routes use
+func conversationEvent(conversation *models.Conversation, eventType string, data interface{}) services.ChatEvent {
+	event := services.ChatEvent{
+		Type:           eventType,
+		ChatID:         conversation.ID,
+		ConversationID: conversation.ID,
+		Data:           data,
+	}
+	switch conversation.ContextType {
+	case models.ConversationContextMatchingRequest:
+		event.Chat = services.ChatKindMatching
+		event.ChatID = conversation.ContextID
+	case models.ConversationContextVisitorProject:
+		event.Chat = services.ChatKindVisitorProject
+	default:
+		event.Chat = services.ChatKindDirect
+	}
+	return event
+}
+
+// conversationPushMessage is the web push sent for a new message to a
+// participant with no open stream
+func conversationPushMessage(conversation *models.Conversation, message *models.ConversationMessage) services.PushMessage {
+	push := services.PushMessage{
+		Title:   "پیام جدید",
+		Message: fmt.Sprintf("پیام جدید از %s", message.Sender.Name()),
+		Icon:    "/pwa.png",
+		Tag:     fmt.Sprintf("conversation-%d", conversation.ID),
+		Urgency: services.PushUrgencyHigh,
+		Data: map[string]interface{}{
+			"url":             fmt.Sprintf("/conversations/%d", conversation.ID),
+			"type":            "conversation",
+			"conversation_id": conversation.ID,
+		},
+	}
+	switch conversation.ContextType {
+	case models.ConversationContextMatchingRequest:
+		push.Title = "پیام جدید در چت Matching"
+		push.Data["url"] = fmt.Sprintf("/matching/requests/%d/chat", conversation.ContextID)
+		push.Data["type"] = "matching_chat"
+	case models.ConversationContextVisitorProject:
+		push.Title = "پیام جدید در چت پروژه ویزیتوری"
+		push.Data["url"] = "/visitor-project-chats"
+		push.Data["type"] = "visitor_project_chat"
+	}
+	return push
+}
+
+// respondAdminConversations writes a page of conversations of contextType
+// (all of them when empty) for the admin viewers
+func respondAdminConversations(c *gin.Context, db *gorm.DB, contextType string) {
+	page, perPage := conversationPagination(c, 20)
+
+	conversations, total, err := models.GetConversations(db, contextType, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت چت‌ها"})
+		return
+	}
+	views, err := models.BuildConversationViews(db, conversations, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت چت‌ها"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":        views,
+		"total":       total,
+		"page":        page,
+		"per_page":    perPage,
+		"total_pages": (int(total) + perPage - 1) / perPage,
+	})
+}
+
+// respondAdminConversationMessages writes the messages of the conversation in
+// :id for the admin viewers
+func respondAdminConversationMessages(c *gin.Context, db *gorm.DB, contextType string) {
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه چت نامعتبر است"})
+		return
+	}
+	conversation, err := models.GetConversation(db, uint(conversationID))
+	if err != nil || (contextType != "" && conversation.ContextType != contextType) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "چت یافت نشد"})
+		return
+	}
+
+	page, perPage := conversationPagination(c, 50)
+	messages, total, err := models.GetConversationMessages(db, conversation.ID, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیام‌ها"})
+		return
+	}
+	models.FillConversationMessages(conversation, messages)
+
+	writeConversationMessages(c, messages, total, page, perPage)
+}
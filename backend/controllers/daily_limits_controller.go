@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"asl-market-backend/models"
+	"asl-market-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -36,36 +37,57 @@ func GetDailyLimitsStatus(c *gin.Context) {
 		return
 	}
 
-	// Calculate max limits based on license type
-	visitorMax := 3
-	supplierMax := 3
-	availableProductMax := 3
-	if license.Type == "pro" {
-		supplierMax = 6
-		availableProductMax = 6
+	plan, err := models.GetLicensePlan(db, license.Type)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت محدودیت‌ها"})
+		return
+	}
+	matchingUsed, _ := models.CountTodayMatchingRequests(db, userID)
+	proposalsUsed, _ := models.CountTodayProposals(db, userID)
+	aiUsage, _ := services.NewAIUsageService(db).GetUsageInfo(userID)
+	aiUsed := 0
+	if aiUsage != nil {
+		aiUsed = aiUsage.MessageCount
 	}
 
+	// A max of 0 means unlimited; remaining is then -1
 	c.JSON(http.StatusOK, gin.H{
 		"license_type": license.Type,
+		"plan":         plan,
 		"visitor_limits": gin.H{
 			"used":      limits.VisitorViews,
-			"max":       visitorMax,
+			"max":       plan.DailyVisitorViews,
 			"remaining": visitorRemaining,
 		},
 		"supplier_limits": gin.H{
 			"used":      limits.SupplierViews,
-			"max":       supplierMax,
+			"max":       plan.DailySupplierViews,
 			"remaining": supplierRemaining,
 		},
 		"available_product_limits": gin.H{
 			"used":      limits.AvailableProductViews,
-			"max":       availableProductMax,
+			"max":       plan.DailyAvailableProductViews,
 			"remaining": availableProductRemaining,
 		},
-		"date": limits.Date.Format("2006-01-02"),
+		"ai_message_limits":       dailyQuota(aiUsed, plan.DailyAIMessages),
+		"matching_request_limits": dailyQuota(matchingUsed, plan.DailyMatchingRequests),
+		"proposal_limits":         dailyQuota(proposalsUsed, plan.DailyProposals),
+		"date":                    limits.Date.Format("2006-01-02"),
 	})
 }
 
+// dailyQuota describes usage of a daily quota where a max of 0 is unlimited
+func dailyQuota(used, max int) gin.H {
+	remaining := -1
+	if max > 0 {
+		remaining = max - used
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+	return gin.H{"used": used, "max": max, "remaining": remaining}
+}
+
 // CheckVisitorViewPermission checks if user can view a visitor
 func CheckVisitorViewPermission(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
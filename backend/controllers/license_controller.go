@@ -27,37 +27,14 @@ func VerifyLicense(c *gin.Context) {
 
 	userIDUint := userID.(uint)
 
-	// Check if user already has a license
-	hasLicense, err := models.CheckUserLicense(models.GetDB(), userIDUint)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی وضعیت لایسنس"})
-		return
-	}
-
-	if hasLicense {
-		// Instead of blocking, show current license info
-		existingLicense, err := models.GetUserLicense(models.GetDB(), userIDUint)
-		if err == nil && existingLicense != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "شما در حال حاضر لایسنس فعال دارید",
-				"current_license": gin.H{
-					"code":       existingLicense.Code,
-					"type":       existingLicense.Type,
-					"expires_at": existingLicense.ExpiresAt,
-				},
-			})
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "شما قبلاً از یک لایسنس استفاده کرده‌اید"})
-		}
-		return
-	}
-
-	// Try to use the license
+	// Redeem the license; if the user still has an active license the new
+	// one is queued after it
 	license, err := models.UseLicense(models.GetDB(), req.License, userIDUint)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	renewed := license.StartsAt != nil && license.UsedAt != nil && license.StartsAt.After(*license.UsedAt)
 
 	// Calculate remaining time
 	now := time.Now()
@@ -95,6 +72,18 @@ func VerifyLicense(c *gin.Context) {
 		}
 	}()
 
+	if renewed {
+		c.JSON(http.StatusOK, models.LicenseVerifyResponse{
+			Message:        fmt.Sprintf("لایسنس %s ثبت شد و پس از پایان لایسنس فعلی، از %s فعال می‌شود.", licenseTypeName, license.StartsAt.Format("2006-01-02")),
+			Status:         "renewed",
+			Type:           license.Type,
+			ExpiresAt:      license.ExpiresAt.Format("2006-01-02 15:04:05"),
+			RemainingDays:  remainingDays,
+			RemainingHours: remainingHours,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.LicenseVerifyResponse{
 		Message:        fmt.Sprintf("لایسنس %s با موفقیت فعال شد! اکنون می‌توانید از تمام امکانات سایت استفاده کنید.", licenseTypeName),
 		Status:         "activated",
@@ -115,17 +104,21 @@ func CheckLicenseStatus(c *gin.Context) {
 
 	userIDUint := userID.(uint)
 
-	// Check if user has valid license
-	hasLicense, err := models.CheckUserLicense(models.GetDB(), userIDUint)
+	// Check if user has valid license; an expired license keeps working
+	// during the grace period
+	access, err := models.GetUserLicenseAccess(models.GetDB(), userIDUint, models.LicenseGracePeriod())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی وضعیت لایسنس"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"has_license": hasLicense,
-		"is_approved": hasLicense, // Since licenses are auto-approved now
-		"is_active":   hasLicense,
+		"has_license":   access.HasLicense,
+		"is_approved":   access.HasLicense, // Since licenses are auto-approved now
+		"is_active":     access.HasLicense,
+		"in_grace":      access.InGrace,
+		"expires_at":    access.ExpiresAt,
+		"grace_ends_at": access.GraceEndsAt,
 	})
 }
 
@@ -140,14 +133,14 @@ func RefreshLicense(c *gin.Context) {
 
 	userIDUint := userID.(uint)
 
-	// Check if user has valid license
-	hasLicense, err := models.CheckUserLicense(models.GetDB(), userIDUint)
+	// Check if user has valid license, counting the grace period
+	access, err := models.GetUserLicenseAccess(models.GetDB(), userIDUint, models.LicenseGracePeriod())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی وضعیت لایسنس"})
 		return
 	}
 
-	if !hasLicense {
+	if !access.HasLicense {
 		c.JSON(http.StatusOK, gin.H{
 			"message":     "شما در حال حاضر لایسنس فعال ندارید",
 			"has_license": false,
@@ -162,9 +155,12 @@ func RefreshLicense(c *gin.Context) {
 		return
 	}
 
-	// Calculate remaining time
+	// Calculate remaining time; a license in its grace period has none left
 	now := time.Now()
 	remaining := license.ExpiresAt.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
 	remainingDays := int(remaining.Hours() / 24)
 	remainingHours := int(remaining.Hours()) % 24
 
@@ -173,9 +169,16 @@ func RefreshLicense(c *gin.Context) {
 		licenseTypeName = "پرو"
 	}
 
+	message := fmt.Sprintf("لایسنس %s شما فعال است", licenseTypeName)
+	if access.InGrace {
+		message = fmt.Sprintf("لایسنس %s شما منقضی شده و تا %s در مهلت تمدید فعال است", licenseTypeName, access.GraceEndsAt.Format("2006-01-02"))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":     fmt.Sprintf("لایسنس %s شما فعال است", licenseTypeName),
-		"has_license": true,
+		"message":       message,
+		"has_license":   true,
+		"in_grace":      access.InGrace,
+		"grace_ends_at": access.GraceEndsAt,
 		"license_info": gin.H{
 			"code":            license.Code,
 			"type":            license.Type,
@@ -260,3 +263,27 @@ func GetUserLicenseInfo(c *gin.Context) {
 		"is_active":       isActive,
 	})
 }
+
+// GetLicenseHistory returns the user's license timeline, marking the active
+// license and renewals that are queued after it
+func GetLicenseHistory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+		return
+	}
+
+	history, err := models.GetUserLicenseHistory(models.GetDB(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت تاریخچه لایسنس"})
+		return
+	}
+
+	events, _ := models.GetUserLicenseEvents(models.GetDB(), userID.(uint))
+
+	c.JSON(http.StatusOK, gin.H{
+		"licenses": history,
+		"events":   events,
+		"total":    len(history),
+	})
+}
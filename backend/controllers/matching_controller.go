@@ -55,6 +55,21 @@ func (mc *MatchingController) CreateMatchingRequest(c *gin.Context) {
 		return
 	}
 
+	// Check the daily quota of the user's license plan
+	allowed, plan, err := models.CanCreateMatchingRequest(mc.db, userIDUint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی محدودیت روزانه"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":         fmt.Sprintf("شما امروز به سقف %d درخواست Matching رسیده‌اید. فردا دوباره تلاش کنید.", plan.DailyMatchingRequests),
+			"limit_reached": true,
+			"daily_limit":   plan.DailyMatchingRequests,
+		})
+		return
+	}
+
 	// Validate product_id belongs to this supplier
 	if req.ProductID != nil {
 		var product models.SupplierProduct
@@ -832,6 +847,12 @@ func (mc *MatchingController) CreateMatchingRating(c *gin.Context) {
 	})
 }
 
+// suggestedVisitorResponse is a visitor with the score breakdown explaining the suggestion
+type suggestedVisitorResponse struct {
+	models.VisitorResponse
+	MatchScore services.VisitorScoreBreakdown `json:"match_score"`
+}
+
 // GetSuggestedVisitors gets suggested visitors for a matching request (for supplier)
 func (mc *MatchingController) GetSuggestedVisitors(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -859,8 +880,8 @@ func (mc *MatchingController) GetSuggestedVisitors(c *gin.Context) {
 		return
 	}
 
-	// Find matching visitors
-	visitors, err := mc.matchingService.FindMatchingVisitors(request)
+	// Score matching visitors
+	matches, err := mc.matchingService.ScoreVisitors(request, services.GetMatchingScoreOptions())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "خطا در یافتن ویزیتورهای مناسب",
@@ -869,9 +890,10 @@ func (mc *MatchingController) GetSuggestedVisitors(c *gin.Context) {
 	}
 
 	// Convert to response format
-	var responseVisitors []models.VisitorResponse
-	for _, visitor := range visitors {
-		responseVisitors = append(responseVisitors, models.VisitorResponse{
+	responseVisitors := make([]suggestedVisitorResponse, 0, len(matches))
+	for _, match := range matches {
+		visitor := match.Visitor
+		responseVisitors = append(responseVisitors, suggestedVisitorResponse{VisitorResponse: models.VisitorResponse{
 			ID:                            visitor.ID,
 			UserID:                        visitor.UserID,
 			FullName:                      visitor.FullName,
@@ -905,7 +927,7 @@ func (mc *MatchingController) GetSuggestedVisitors(c *gin.Context) {
 			IsFeatured:                    visitor.IsFeatured,
 			FeaturedAt:                    visitor.FeaturedAt,
 			CreatedAt:                     visitor.CreatedAt,
-		})
+		}, MatchScore: match.Score})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -916,179 +938,125 @@ func (mc *MatchingController) GetSuggestedVisitors(c *gin.Context) {
 
 // GetMatchingChatMessages gets all messages for a matching chat
 func (mc *MatchingController) GetMatchingChatMessages(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+	conversation, userID, ok := mc.matchingConversationForParticipant(c)
+	if !ok {
 		return
 	}
+	respondConversationMessages(c, mc.db, conversation, userID)
+}
 
-	userIDUint := userID.(uint)
-
-	requestID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه درخواست معتبر نیست. لطفاً صفحه را رفرش کنید."})
+// SendMatchingChatMessage sends a message in a matching chat
+func (mc *MatchingController) SendMatchingChatMessage(c *gin.Context) {
+	conversation, userID, ok := mc.matchingConversationForParticipant(c)
+	if !ok {
 		return
 	}
-
-	// Get or create chat
-	chat, err := models.GetOrCreateMatchingChat(mc.db, uint(requestID))
-	if err != nil {
-		if err == gorm.ErrInvalidValue {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "این درخواست accepted نشده است یا شما دسترسی ندارید",
-			})
-			return
-		}
-		c.JSON(http.StatusNotFound, gin.H{"error": "چت یافت نشد"})
+	message, ok := sendConversationMessage(c, mc.db, conversation, userID)
+	if !ok {
 		return
 	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": message,
+	})
+}
 
-	// Verify user has access to this chat
-	if chat.SupplierUserID != userIDUint && chat.VisitorUserID != userIDUint {
-		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
+// MarkMatchingChatRead marks the messages the current user received in a
+// matching chat as read, e.g. when one arrives over the chat stream while the
+// chat is open
+func (mc *MatchingController) MarkMatchingChatRead(c *gin.Context) {
+	conversation, userID, ok := mc.matchingConversationForParticipant(c)
+	if !ok {
 		return
 	}
+	count := markConversationRead(mc.db, conversation, userID)
+	c.JSON(http.StatusOK, gin.H{"marked": count})
+}
 
-	// Get pagination params
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
-
-	// Get messages
-	messages, total, err := models.GetMatchingChatMessages(mc.db, chat.ID, page, perPage)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیام‌ها"})
+// SendMatchingChatTyping tells the other party that the current user started
+// or stopped typing. Typing events are only sent to open streams.
+func (mc *MatchingController) SendMatchingChatTyping(c *gin.Context) {
+	conversation, userID, ok := mc.matchingConversationForParticipant(c)
+	if !ok {
 		return
 	}
+	sendConversationTyping(c, conversation, userID)
+}
 
-	// Mark messages as read
-	go models.MarkMatchingMessagesAsRead(mc.db, chat.ID, userIDUint)
-
-	// Convert to response format
-	var responseMessages []models.MatchingMessageResponse
-	for _, msg := range messages {
-		responseMessages = append(responseMessages, models.MatchingMessageResponse{
-			ID:             msg.ID,
-			MatchingChatID: msg.MatchingChatID,
-			SenderID:       msg.SenderID,
-			SenderName:     msg.Sender.Name(),
-			SenderType:     msg.SenderType,
-			Message:        msg.Message,
-			ImageURL:       msg.ImageURL,
-			IsRead:         msg.IsRead,
-			ReadAt:         msg.ReadAt,
-			CreatedAt:      msg.CreatedAt,
-		})
+// StartMatchingChat opens (or returns) the conversation between the current
+// user and the other party of the matching request in :id. Visitors can open
+// one to negotiate while the request is still open.
+func (mc *MatchingController) StartMatchingChat(c *gin.Context) {
+	conversation, userID, ok := mc.matchingConversationForParticipant(c)
+	if !ok {
+		return
+	}
+	views, err := models.BuildConversationViews(mc.db, []models.Conversation{*conversation}, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد چت"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"messages": responseMessages,
-		"pagination": gin.H{
-			"page":        page,
-			"per_page":    perPage,
-			"total":       total,
-			"total_pages": (total + int64(perPage) - 1) / int64(perPage),
-		},
+		"message": "چت با موفقیت ایجاد شد",
+		"chat":    views[0],
 	})
 }
 
-// SendMatchingChatMessage sends a message in a matching chat
-func (mc *MatchingController) SendMatchingChatMessage(c *gin.Context) {
+// matchingConversationForParticipant loads the conversation of the matching
+// request in :id for the current user: the supplier gets the one with the
+// accepted visitor, a visitor gets their own. On failure the error response
+// is already written.
+func (mc *MatchingController) matchingConversationForParticipant(c *gin.Context) (*models.Conversation, uint, bool) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
-		return
+		return nil, 0, false
 	}
-
 	userIDUint := userID.(uint)
 
 	requestID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه درخواست معتبر نیست. لطفاً صفحه را رفرش کنید."})
-		return
+		return nil, 0, false
 	}
 
-	// Get or create chat
-	chat, err := models.GetOrCreateMatchingChat(mc.db, uint(requestID))
+	request, err := models.GetMatchingRequestByID(mc.db, uint(requestID))
 	if err != nil {
-		if err == gorm.ErrInvalidValue {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "این درخواست accepted نشده است",
-			})
-			return
-		}
-		c.JSON(http.StatusNotFound, gin.H{"error": "چت یافت نشد"})
-		return
+		c.JSON(http.StatusNotFound, gin.H{"error": "درخواست مورد نظر یافت نشد. ممکن است حذف شده باشد."})
+		return nil, 0, false
 	}
 
-	// Determine sender type
-	var senderType string
-	if chat.SupplierUserID == userIDUint {
-		senderType = "supplier"
-	} else if chat.VisitorUserID == userIDUint {
-		senderType = "visitor"
+	var visitorID uint
+	if request.UserID == userIDUint {
+		if request.AcceptedVisitorID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "این درخواست accepted نشده است یا شما دسترسی ندارید"})
+			return nil, 0, false
+		}
+		visitorID = *request.AcceptedVisitorID
 	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
-		return
-	}
-
-	var req models.SendMatchingMessageRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "اطلاعات ارسالی نامعتبر است",
-			"details": err.Error(),
-		})
-		return
+		visitor, err := models.GetVisitorByUserID(mc.db, userIDUint)
+		if err != nil || visitor.Status != "approved" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
+			return nil, 0, false
+		}
+		visitorID = visitor.ID
 	}
 
-	// Create message
-	message, err := models.CreateMatchingMessage(mc.db, chat.ID, userIDUint, senderType, req.Message, req.ImageURL)
+	conversation, err := models.GetOrCreateMatchingConversation(mc.db, request.ID, visitorID)
 	if err != nil {
 		if err == gorm.ErrInvalidValue {
-			c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
-			return
+			c.JSON(http.StatusBadRequest, gin.H{"error": "این درخواست accepted نشده است یا شما دسترسی ندارید"})
+			return nil, 0, false
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ارسال پیام"})
-		return
-	}
-
-	// Send push notification to the other party
-	pushService := services.GetPushNotificationService()
-	var recipientID uint
-	if senderType == "supplier" {
-		recipientID = chat.VisitorUserID
-	} else {
-		recipientID = chat.SupplierUserID
-	}
-
-	pushMessage := services.PushMessage{
-		Title:   "پیام جدید در چت Matching",
-		Message: fmt.Sprintf("پیام جدید از %s", message.Sender.Name()),
-		Icon:    "/pwa.png",
-		Tag:     fmt.Sprintf("matching-chat-%d", chat.ID),
-		Data: map[string]interface{}{
-			"url":  fmt.Sprintf("/matching/requests/%d/chat", requestID),
-			"type": "matching_chat",
-		},
+		c.JSON(http.StatusNotFound, gin.H{"error": "چت یافت نشد"})
+		return nil, 0, false
 	}
-	go pushService.SendPushNotification(recipientID, pushMessage)
 
-	// Convert to response
-	response := models.MatchingMessageResponse{
-		ID:             message.ID,
-		MatchingChatID: message.MatchingChatID,
-		SenderID:       message.SenderID,
-		SenderName:     message.Sender.Name(),
-		SenderType:     message.SenderType,
-		Message:        message.Message,
-		ImageURL:       message.ImageURL,
-		IsRead:         message.IsRead,
-		ReadAt:         message.ReadAt,
-		CreatedAt:      message.CreatedAt,
+	if conversation.Participant(userIDUint) == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی به این چت ندارید"})
+		return nil, 0, false
 	}
-
-	c.JSON(http.StatusCreated, gin.H{
-		"message": response,
-	})
+	return conversation, userIDUint, true
 }
 
 // GetMatchingChatConversations gets all chat conversations for the current user
@@ -1102,57 +1070,41 @@ func (mc *MatchingController) GetMatchingChatConversations(c *gin.Context) {
 	userIDUint := userID.(uint)
 
 	// Get pagination params
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	page, perPage := conversationPagination(c, 20)
 
 	// Get chats
-	chats, total, err := models.GetMatchingChatsForUser(mc.db, userIDUint, page, perPage)
+	conversations, total, err := models.GetConversationsForUser(mc.db, userIDUint, models.ConversationContextMatchingRequest, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت مکالمات"})
+		return
+	}
+	views, err := models.BuildConversationViews(mc.db, conversations, userIDUint)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت مکالمات"})
 		return
 	}
 
 	// Convert to response format
-	var responseChats []models.MatchingChatResponse
-	for _, chat := range chats {
-		// Get unread count
-		var unreadCount int64
-		mc.db.Model(&models.MatchingMessage{}).
-			Where("matching_chat_id = ? AND sender_id != ? AND is_read = ?", chat.ID, userIDUint, false).
-			Count(&unreadCount)
-
-		// Get last message
-		var lastMessage models.MatchingMessage
-		var lastMessageText string
-		var lastMessageAt *time.Time
-		if err := mc.db.Where("matching_chat_id = ?", chat.ID).
-			Order("created_at DESC").First(&lastMessage).Error; err == nil {
-			// Show image indicator if message has image
-			if lastMessage.ImageURL != "" {
-				if lastMessage.Message != "" {
-					lastMessageText = "📷 " + lastMessage.Message
-				} else {
-					lastMessageText = "📷 تصویر"
-				}
-			} else {
-				lastMessageText = lastMessage.Message
-			}
-			lastMessageAt = &lastMessage.CreatedAt
+	responseChats := []models.MatchingChatResponse{}
+	for _, view := range views {
+		chat := models.MatchingChatResponse{
+			ID:                view.ID,
+			MatchingRequestID: view.MatchingRequestID,
+			SupplierID:        view.SupplierID,
+			VisitorID:         view.VisitorID,
+			IsActive:          view.IsActive,
+			LastMessage:       view.LastMessagePreview,
+			LastMessageAt:     view.LastMessageAt,
+			UnreadCount:       int(view.UnreadCount),
+			CreatedAt:         view.CreatedAt,
 		}
-
-		responseChats = append(responseChats, models.MatchingChatResponse{
-			ID:                chat.ID,
-			MatchingRequestID: chat.MatchingRequestID,
-			SupplierID:        chat.SupplierID,
-			SupplierName:      chat.Supplier.FullName,
-			VisitorID:         chat.VisitorID,
-			VisitorName:       chat.Visitor.FullName,
-			IsActive:          chat.IsActive,
-			LastMessage:       lastMessageText,
-			LastMessageAt:     lastMessageAt,
-			UnreadCount:       int(unreadCount),
-			CreatedAt:         chat.CreatedAt,
-		})
+		if view.Supplier != nil {
+			chat.SupplierName = view.Supplier.FullName
+		}
+		if view.Visitor != nil {
+			chat.VisitorName = view.Visitor.FullName
+		}
+		responseChats = append(responseChats, chat)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
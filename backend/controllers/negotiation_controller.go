@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetNegotiationOffers gets the versioned offer history of a conversation
+func (cc *ConversationController) GetNegotiationOffers(c *gin.Context) {
+	conversation, _, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+
+	offers, err := models.GetNegotiationOffers(cc.db, conversation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیشنهادها"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"offers": offers})
+}
+
+// CreateNegotiationOffer posts a structured price offer as a chat message
+func (cc *ConversationController) CreateNegotiationOffer(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	var req models.NegotiationOfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات ارسالی نامعتبر است", "details": err.Error()})
+		return
+	}
+
+	message, err := models.CreateNegotiationOffer(cc.db, conversation, userID, req)
+	if err != nil {
+		respondNegotiationError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "پیشنهاد ارسال شد",
+		"data":    deliverConversationMessage(conversation, userID, message),
+	})
+}
+
+// CounterNegotiationOffer answers the other party's offer with a new version
+func (cc *ConversationController) CounterNegotiationOffer(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	offerID, ok := negotiationOfferID(c)
+	if !ok {
+		return
+	}
+	var req models.NegotiationOfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات ارسالی نامعتبر است", "details": err.Error()})
+		return
+	}
+
+	message, err := models.CounterNegotiationOffer(cc.db, conversation, offerID, userID, req)
+	if err != nil {
+		respondNegotiationError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "پیشنهاد متقابل ارسال شد",
+		"data":    deliverConversationMessage(conversation, userID, message),
+	})
+}
+
+// AcceptNegotiationOffer accepts the other party's offer, which accepts the
+// matching request or visitor project on its terms
+func (cc *ConversationController) AcceptNegotiationOffer(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	offerID, ok := negotiationOfferID(c)
+	if !ok {
+		return
+	}
+
+	message, err := models.AcceptNegotiationOffer(cc.db, conversation, offerID, userID)
+	if err != nil {
+		respondNegotiationError(c, err)
+		return
+	}
+	message = publishOfferUpdate(conversation, userID, message)
+
+	// Tell the party who made the offer
+	title, actionURL := "پیشنهاد شما پذیرفته شد", fmt.Sprintf("/conversations/%d", conversation.ID)
+	if conversation.ContextType == models.ConversationContextMatchingRequest {
+		actionURL = fmt.Sprintf("/matching/requests/%d", conversation.ContextID)
+	}
+	proposerID := message.Offer.ProposedByID
+	notification := models.Notification{
+		UserID:      &proposerID,
+		Title:       title,
+		Message:     fmt.Sprintf("پیشنهاد %s %s شما پذیرفته شد", message.Offer.Price, message.Offer.Currency),
+		Type:        "matching",
+		Priority:    "high",
+		IsRead:      false,
+		CreatedByID: userID,
+		ActionURL:   actionURL,
+	}
+	if err := cc.db.Create(&notification).Error; err != nil {
+		log.Printf("Failed to notify user %d of accepted offer %d: %v", proposerID, offerID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "پیشنهاد پذیرفته شد",
+		"data":    message,
+	})
+}
+
+// RejectNegotiationOffer declines the other party's offer
+func (cc *ConversationController) RejectNegotiationOffer(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, cc.db, "")
+	if !ok {
+		return
+	}
+	offerID, ok := negotiationOfferID(c)
+	if !ok {
+		return
+	}
+
+	message, err := models.RejectNegotiationOffer(cc.db, conversation, offerID, userID)
+	if err != nil {
+		respondNegotiationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "پیشنهاد رد شد",
+		"data":    publishOfferUpdate(conversation, userID, message),
+	})
+}
+
+// negotiationOfferID reads :offerId. On failure the error response is
+// already written.
+func negotiationOfferID(c *gin.Context) (uint, bool) {
+	offerID, err := strconv.ParseUint(c.Param("offerId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه پیشنهاد نامعتبر است"})
+		return 0, false
+	}
+	return uint(offerID), true
+}
+
+// publishOfferUpdate sends the message of an answered offer, with its new
+// status, to the other participants
+func publishOfferUpdate(conversation *models.Conversation, userID uint, message *models.ConversationMessage) *models.ConversationMessage {
+	messages := []models.ConversationMessage{*message}
+	models.FillConversationMessages(conversation, messages)
+	publishConversationEvent(conversation, userID, services.ChatEventMessageUpdated, messages[0])
+	return &messages[0]
+}
+
+// respondNegotiationError writes the response for an error from the
+// negotiation model
+func respondNegotiationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNegotiationNotSupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "پیشنهاد قیمت فقط در چت Matching و پروژه‌های ویزیتوری امکان‌پذیر است"})
+	case errors.Is(err, models.ErrNegotiationClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "این درخواست دیگر برای مذاکره باز نیست"})
+	case errors.Is(err, models.ErrInvalidOffer):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "قیمت و واحد پول الزامی است، اینکوترم باید معتبر باشد و تاریخ اعتبار باید در آینده باشد"})
+	case errors.Is(err, models.ErrOfferAwaitingResponse):
+		c.JSON(http.StatusConflict, gin.H{"error": "ابتدا به پیشنهاد طرف مقابل پاسخ دهید"})
+	case errors.Is(err, models.ErrOfferNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "این پیشنهاد قبلاً پاسخ داده شده است"})
+	case errors.Is(err, models.ErrOfferExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": "اعتبار این پیشنهاد به پایان رسیده است"})
+	case errors.Is(err, models.ErrOwnOffer):
+		c.JSON(http.StatusForbidden, gin.H{"error": "امکان پاسخ به پیشنهاد خودتان وجود ندارد"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "پیشنهاد یافت نشد"})
+	default:
+		respondConversationError(c, err)
+	}
+}
@@ -1,15 +1,19 @@
 package controllers
 
 import (
-	"fmt"
-	"log"
+	"errors"
+	"io"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 
+	"asl-market-backend/middleware"
 	"asl-market-backend/models"
 	"asl-market-backend/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
 	"gorm.io/gorm"
 )
 
@@ -98,11 +102,24 @@ func GetNotification(c *gin.Context) {
 	}
 
 	// Check if user can access this notification
-	if notification.UserID != nil && *notification.UserID != user.ID {
+	canAccess, err := models.CanUserAccessNotification(db, notification, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notification"})
+		return
+	}
+	if !canAccess {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
 
+	// Report this user's own read state (broadcasts are shared rows)
+	single := []models.Notification{*notification}
+	if err := models.ApplyNotificationReadState(db, user.ID, single); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notification"})
+		return
+	}
+	notification.IsRead = single[0].IsRead
+
 	// Convert to response format
 	response := models.NotificationResponse{
 		ID:          notification.ID,
@@ -155,6 +172,10 @@ func MarkNotificationAsRead(c *gin.Context) {
 
 	err = models.MarkNotificationAsRead(db, uint(notificationID), user.ID)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification as read"})
 		return
 	}
@@ -162,6 +183,30 @@ func MarkNotificationAsRead(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
 }
 
+// DismissNotification hides a notification from the current user's list
+func DismissNotification(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	user := c.MustGet("user").(models.User)
+
+	notificationID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	err = models.DismissNotification(db, uint(notificationID), user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to dismiss notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification dismissed"})
+}
+
 // MarkAllNotificationsAsRead marks all notifications as read for the current user
 func MarkAllNotificationsAsRead(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
@@ -190,6 +235,48 @@ func GetUnreadNotificationCount(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"count": count})
 }
 
+// GetNotificationPreferences returns the current user's delivery settings
+func GetNotificationPreferences(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	user := c.MustGet("user").(models.User)
+
+	pref, err := models.GetNotificationPreference(db, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت تنظیمات اعلان‌ها"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": pref.Settings()})
+}
+
+// UpdateNotificationPreferences changes the current user's delivery settings.
+// Fields left out of the body keep their value.
+func UpdateNotificationPreferences(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	user := c.MustGet("user").(models.User)
+
+	var req models.NotificationPreferenceSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pref, err := models.SaveNotificationPreference(db, user.ID, req)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidNotificationPreference) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "تنظیمات اعلان‌ها نامعتبر است", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره تنظیمات اعلان‌ها"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "تنظیمات اعلان‌ها ذخیره شد",
+		"data":    pref.Settings(),
+	})
+}
+
 // CreateNotification creates a new notification (Admin only)
 func CreateNotification(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
@@ -206,8 +293,15 @@ func CreateNotification(c *gin.Context) {
 		return
 	}
 
-	notification, err := models.CreateNotification(db, user.ID, req)
+	// Creates the notification and, unless scheduled for later, sends it to its
+	// audience with optional push and SMS fan-out
+	notification, err := services.NewNotificationDispatcher(db).CreateAndDispatch(user.ID, req)
 	if err != nil {
+		var audienceErr *models.NotificationAudienceError
+		if errors.As(err, &audienceErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": audienceErr.Message})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create notification"})
 		return
 	}
@@ -230,6 +324,8 @@ func CreateNotification(c *gin.Context) {
 		UpdatedAt:   notification.UpdatedAt,
 	}
 
+	response.Delivery = notification.DeliveryInfo()
+
 	// Add created by info
 	response.CreatedBy = models.UserResponse{
 		ID:        notification.CreatedBy.ID,
@@ -238,32 +334,6 @@ func CreateNotification(c *gin.Context) {
 		Email:     notification.CreatedBy.Email,
 	}
 
-	// Send push notification
-	pushService := services.GetPushNotificationService()
-	pushMessage := services.PushMessage{
-		Title:   notification.Title,
-		Message: notification.Message,
-		Icon:    "/pwa.png",
-		Tag:     fmt.Sprintf("notification-%d", notification.ID),
-		Data: map[string]interface{}{
-			"url":  notification.ActionURL,
-			"type": notification.Type,
-		},
-	}
-
-	// Send to specific user or all users
-	if notification.UserID != nil {
-		// Send to specific user
-		if err := pushService.SendPushNotification(*notification.UserID, pushMessage); err != nil {
-			log.Printf("Failed to send push notification: %v", err)
-		}
-	} else {
-		// Broadcast to all users
-		if err := pushService.SendPushNotificationToAll(pushMessage); err != nil {
-			log.Printf("Failed to send push notification to all: %v", err)
-		}
-	}
-
 	c.JSON(http.StatusCreated, response)
 }
 
@@ -291,6 +361,11 @@ func UpdateNotification(c *gin.Context) {
 
 	notification, err := models.UpdateNotification(db, uint(notificationID), req)
 	if err != nil {
+		var audienceErr *models.NotificationAudienceError
+		if errors.As(err, &audienceErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": audienceErr.Message})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
 		return
 	}
@@ -313,6 +388,8 @@ func UpdateNotification(c *gin.Context) {
 		UpdatedAt:   notification.UpdatedAt,
 	}
 
+	response.Delivery = notification.DeliveryInfo()
+
 	// Add created by info
 	response.CreatedBy = models.UserResponse{
 		ID:        notification.CreatedBy.ID,
@@ -367,3 +444,193 @@ func GetNotificationStats(c *gin.Context) {
 
 	c.JSON(http.StatusOK, stats)
 }
+
+// PreviewNotificationAudience counts the users a segment would reach (Admin only)
+func PreviewNotificationAudience(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	user := c.MustGet("user").(models.User)
+
+	if !user.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی لازم برای انجام این عملیات را ندارید."})
+		return
+	}
+
+	var segment models.NotificationSegment
+	if err := c.ShouldBindJSON(&segment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := segment.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.CountSegmentUsers(db, segment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count audience"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipient_count": count})
+}
+
+// ParseNotificationAudiencePhones reads a phone list from an uploaded Excel/CSV
+// file for use as segment.phones (Admin only). Phones are read from the first column.
+func ParseNotificationAudiencePhones(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	if !user.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما دسترسی لازم برای انجام این عملیات را ندارید."})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "فایل آپلود نشده است"})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext != ".xlsx" && ext != ".csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "فرمت فایل نامعتبر است. فقط Excel (.xlsx) و CSV مجاز است"})
+		return
+	}
+	if file.Size > 5*1024*1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "حجم فایل نباید بیشتر از 5 مگابایت باشد"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در باز کردن فایل"})
+		return
+	}
+	defer src.Close()
+
+	var cells []string
+	if ext == ".csv" {
+		content, err := io.ReadAll(src)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "خطا در خواندن فایل"})
+			return
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			cells = append(cells, strings.Trim(strings.TrimSpace(strings.Split(line, ",")[0]), "\""))
+		}
+	} else {
+		f, err := excelize.OpenReader(src)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "خطا در خواندن فایل Excel"})
+			return
+		}
+		defer f.Close()
+
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "فایل Excel خالی است"})
+			return
+		}
+		rows, err := f.GetRows(sheets[0])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "خطا در خواندن داده‌های Excel"})
+			return
+		}
+		for _, row := range rows {
+			if len(row) > 0 {
+				cells = append(cells, row[0])
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	phones := []string{}
+	invalid := 0
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) == "" {
+			continue
+		}
+		phone := models.NormalizeLocalPhone(cell)
+		if phone == "" {
+			// Header rows and malformed numbers
+			invalid++
+			continue
+		}
+		if !seen[phone] {
+			seen[phone] = true
+			phones = append(phones, phone)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"phones":        phones,
+		"count":         len(phones),
+		"invalid_count": invalid,
+	})
+}
+
+// GetNotificationDeliveries lists the notification outbox jobs, e.g. the
+// dead-lettered ones with ?status=dead, with counts per channel and status (Admin only)
+func GetNotificationDeliveries(c *gin.Context) {
+	db := models.GetDB()
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 200 {
+		perPage = 50
+	}
+
+	filter := models.NotificationJobFilter{
+		Status:     c.Query("status"),
+		Channel:    c.Query("channel"),
+		SourceType: c.Query("source_type"),
+	}
+	jobs, total, err := models.GetNotificationJobs(db, filter, perPage, (page-1)*perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت وضعیت ارسال اعلان‌ها"})
+		return
+	}
+	stats, err := models.GetNotificationJobStats(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت وضعیت ارسال اعلان‌ها"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":     jobs,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+		"stats":    stats,
+	})
+}
+
+// RetryNotificationDelivery puts a dead-lettered delivery back in the queue (Admin only)
+func RetryNotificationDelivery(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه نامعتبر است"})
+		return
+	}
+
+	job, err := models.RetryNotificationJob(models.GetDB(), uint(jobID))
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "ارسال مورد نظر یافت نشد"})
+		case errors.Is(err, models.ErrNotificationJobNotDead):
+			c.JSON(http.StatusConflict, gin.H{"error": "فقط ارسال‌های ناموفق قابل تلاش مجدد هستند"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در تلاش مجدد ارسال"})
+		}
+		return
+	}
+	middleware.RecordAudit(c, "notification_job.retry", "notification_job", jobID, nil, nil, map[string]interface{}{"channel": job.Channel})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ارسال دوباره در صف قرار گرفت",
+		"job":     job,
+	})
+}
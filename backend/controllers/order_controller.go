@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"asl-market-backend/middleware"
+	"asl-market-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OrderController handles buyer and seller order endpoints
+type OrderController struct {
+	db *gorm.DB
+}
+
+// NewOrderController creates a new order controller
+func NewOrderController(db *gorm.DB) *OrderController {
+	return &OrderController{db: db}
+}
+
+// orderErrorStatus maps order domain errors to HTTP status codes
+func orderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrOrderNotParticipant):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrOrderInvalidTransition), errors.Is(err, models.ErrOrderInsufficientStock):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// CreateOrder places an order for an available product (Buyer)
+func (oc *OrderController) CreateOrder(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req models.CreateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "اطلاعات ارسالی نامعتبر است",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	order, err := models.PlaceOrder(oc.db, userID, req)
+	if err != nil {
+		var validationErr *models.OrderValidationError
+		if errors.As(err, &validationErr) || errors.Is(err, models.ErrOrderProductUnavailable) ||
+			errors.Is(err, models.ErrOrderOwnProduct) || errors.Is(err, models.ErrOrderInsufficientStock) {
+			c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در ثبت سفارش", err, "create_order")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "سفارش با موفقیت ثبت شد",
+		"order":   order,
+	})
+}
+
+// GetMyOrders lists orders placed by the current user (Buyer)
+func (oc *OrderController) GetMyOrders(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	page, perPage := orderPagination(c)
+
+	orders, total, err := models.GetBuyerOrders(oc.db, userID, c.DefaultQuery("status", "all"), page, perPage)
+	if err != nil {
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در دریافت سفارش‌ها", err, "get_my_orders")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders":     orders,
+		"pagination": orderPaginationResponse(page, perPage, total),
+	})
+}
+
+// GetSellerOrders lists orders received for the current user's products (Seller)
+func (oc *OrderController) GetSellerOrders(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	page, perPage := orderPagination(c)
+
+	orders, total, err := models.GetSellerOrders(oc.db, userID, c.DefaultQuery("status", "all"), page, perPage)
+	if err != nil {
+		middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در دریافت سفارش‌ها", err, "get_seller_orders")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders":     orders,
+		"pagination": orderPaginationResponse(page, perPage, total),
+	})
+}
+
+// GetOrder returns a single order visible to its buyer or seller
+func (oc *OrderController) GetOrder(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه سفارش نامعتبر است"})
+		return
+	}
+
+	order, err := models.GetOrderForParticipant(oc.db, uint(orderID), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "سفارش یافت نشد"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order": order})
+}
+
+// UpdateOrderStatus moves an order through its lifecycle (Buyer or Seller)
+func (oc *OrderController) UpdateOrderStatus(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه سفارش نامعتبر است"})
+		return
+	}
+
+	var req models.UpdateOrderStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "اطلاعات ارسالی نامعتبر است",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	order, err := models.TransitionOrder(oc.db, uint(orderID), userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "سفارش یافت نشد"})
+		case errors.Is(err, models.ErrOrderNotParticipant), errors.Is(err, models.ErrOrderInvalidTransition):
+			c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()})
+		default:
+			middleware.RespondWithError(c, http.StatusInternalServerError, "خطا در بروزرسانی وضعیت سفارش", err, "update_order_status")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "وضعیت سفارش با موفقیت بروزرسانی شد",
+		"order":   order,
+	})
+}
+
+func orderPagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+	return page, perPage
+}
+
+func orderPaginationResponse(page, perPage int, total int64) gin.H {
+	return gin.H{
+		"page":        page,
+		"per_page":    perPage,
+		"total":       total,
+		"total_pages": (total + int64(perPage) - 1) / int64(perPage),
+	}
+}
@@ -161,21 +161,16 @@ func (pc *ProfileController) GetUserProfile(c *gin.Context) {
 		profile["is_visitor"] = false
 	}
 
-	// Get user's license info
-	hasLicense, _ := models.CheckUserLicense(pc.db, uint(userID))
-	if hasLicense {
-		var license models.License
-		if err := pc.db.Where("used_by = ? AND is_used = ?", userID, true).
-			Order("expires_at DESC").First(&license).Error; err == nil {
-			// Check if not expired
-			isActive := license.ExpiresAt != nil && license.ExpiresAt.After(time.Now())
-			profile["has_license"] = true
-			profile["license"] = gin.H{
-				"is_active":  isActive,
-				"expires_at": license.ExpiresAt,
-			}
-		} else {
-			profile["has_license"] = false
+	// Get user's license info; an expired license keeps working during the
+	// grace period
+	access, err := models.GetUserLicenseAccess(pc.db, uint(userID), models.LicenseGracePeriod())
+	if err == nil && access.HasLicense {
+		profile["has_license"] = true
+		profile["license"] = gin.H{
+			"is_active":     !access.InGrace,
+			"in_grace":      access.InGrace,
+			"expires_at":    access.ExpiresAt,
+			"grace_ends_at": access.GraceEndsAt,
 		}
 	} else {
 		profile["has_license"] = false
@@ -183,8 +178,8 @@ func (pc *ProfileController) GetUserProfile(c *gin.Context) {
 
 	// Get user's activity stats
 	var chatsCount int64
-	pc.db.Model(&models.MatchingChat{}).
-		Joins("INNER JOIN suppliers ON suppliers.id = matching_chats.supplier_id OR matching_chats.supplier_id IN (SELECT id FROM suppliers WHERE user_id = ?)", userID).
+	pc.db.Model(&models.ConversationParticipant{}).
+		Where("user_id = ?", userID).
 		Count(&chatsCount)
 
 	profile["activity"] = gin.H{
@@ -108,6 +108,23 @@ func (c *PublicRegistrationController) RegisterPublicSupplier(ctx *gin.Context)
 		return
 	}
 
+	// Start transaction
+	tx := c.db.Begin()
+	if tx.Error != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start transaction",
+		})
+		return
+	}
+
+	// Require a verified mobile number when enabled in config; the
+	// verification is only used up if the registration commits
+	if err := services.NewOTPService(tx).RequirePhoneVerified(req.Mobile); err != nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": services.OTPErrorMessage(err)})
+		return
+	}
+
 	// Create a temporary user for the supplier
 	tempUser := models.User{
 		FirstName: "Temp",
@@ -120,7 +137,8 @@ func (c *PublicRegistrationController) RegisterPublicSupplier(ctx *gin.Context)
 	}
 
 	// Create user first
-	if err := c.db.Create(&tempUser).Error; err != nil {
+	if err := tx.Create(&tempUser).Error; err != nil {
+		tx.Rollback()
 		log.Printf("Error creating temp user: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create user",
@@ -147,15 +165,6 @@ func (c *PublicRegistrationController) RegisterPublicSupplier(ctx *gin.Context)
 		Status:                   "pending",
 	}
 
-	// Start transaction
-	tx := c.db.Begin()
-	if tx.Error != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to start transaction",
-		})
-		return
-	}
-
 	// Create supplier
 	if err := tx.Create(&supplier).Error; err != nil {
 		tx.Rollback()
@@ -271,6 +280,23 @@ func (c *PublicRegistrationController) RegisterPublicVisitor(ctx *gin.Context) {
 		}
 	}
 
+	// Start transaction
+	tx := c.db.Begin()
+	if tx.Error != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start transaction",
+		})
+		return
+	}
+
+	// Require a verified mobile number when enabled in config; the
+	// verification is only used up if the registration commits
+	if err := services.NewOTPService(tx).RequirePhoneVerified(req.Mobile); err != nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": services.OTPErrorMessage(err)})
+		return
+	}
+
 	// Create a temporary user for the visitor
 	tempUser := models.User{
 		FirstName: "Temp",
@@ -283,7 +309,8 @@ func (c *PublicRegistrationController) RegisterPublicVisitor(ctx *gin.Context) {
 	}
 
 	// Create user first
-	if err := c.db.Create(&tempUser).Error; err != nil {
+	if err := tx.Create(&tempUser).Error; err != nil {
+		tx.Rollback()
 		log.Printf("Error creating temp user: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create user",
@@ -323,7 +350,8 @@ func (c *PublicRegistrationController) RegisterPublicVisitor(ctx *gin.Context) {
 	}
 
 	// Create visitor
-	if err := c.db.Create(&visitor).Error; err != nil {
+	if err := tx.Create(&visitor).Error; err != nil {
+		tx.Rollback()
 		log.Printf("Error creating visitor: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create visitor",
@@ -331,6 +359,14 @@ func (c *PublicRegistrationController) RegisterPublicVisitor(ctx *gin.Context) {
 		return
 	}
 
+	// Commit transaction
+	if err := tx.Commit().Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to commit transaction",
+		})
+		return
+	}
+
 	message := "🆕 درخواست ثبت‌نام ویزیتور جدید\n\n"
 	message += "📋 اطلاعات ویزیتور:\n"
 	message += "👤 نام: " + req.FullName + "\n"
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// pushCampaignDetails is a campaign with its results per variant
+type pushCampaignDetails struct {
+	*models.PushCampaign
+	Results []models.PushCampaignVariantResult `json:"results"`
+}
+
+// CreatePushCampaign creates a push campaign and, unless scheduled for later,
+// queues its pushes (Admin only)
+func (pc *PushController) CreatePushCampaign(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req models.CreatePushCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات ارسالی نامعتبر است", "details": err.Error()})
+		return
+	}
+
+	campaign, err := services.NewPushCampaignService(pc.db).CreateAndDispatch(userID, req)
+	if err != nil {
+		var campaignErr *models.PushCampaignError
+		if errors.As(err, &campaignErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": campaignErr.Message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد کمپین"})
+		return
+	}
+
+	pc.respondWithCampaign(c, http.StatusCreated, campaign)
+}
+
+// PreviewPushCampaignAudience counts the users with push a campaign to the
+// given segment would reach; an empty body counts every user with push (Admin only)
+func (pc *PushController) PreviewPushCampaignAudience(c *gin.Context) {
+	var segment *models.NotificationSegment
+	if c.Request.ContentLength != 0 {
+		segment = &models.NotificationSegment{}
+		if err := c.ShouldBindJSON(segment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات ارسالی نامعتبر است", "details": err.Error()})
+			return
+		}
+		if err := segment.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	userIDs, err := models.ResolvePushCampaignAudience(pc.db, &models.PushCampaign{Segment: segment})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در شمارش مخاطبان"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recipient_count": len(userIDs)})
+}
+
+// GetPushCampaigns lists campaigns, newest first (Admin only)
+func (pc *PushController) GetPushCampaigns(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+
+	campaigns, total, err := models.GetPushCampaigns(pc.db, perPage, (page-1)*perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت کمپین‌ها"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"campaigns": campaigns,
+		"total":     total,
+		"page":      page,
+		"per_page":  perPage,
+	})
+}
+
+// GetPushCampaign returns a campaign with delivery and click results per
+// variant (Admin only)
+func (pc *PushController) GetPushCampaign(c *gin.Context) {
+	campaign, ok := pc.loadCampaign(c)
+	if !ok {
+		return
+	}
+	pc.respondWithCampaign(c, http.StatusOK, campaign)
+}
+
+// CancelPushCampaign cancels a scheduled campaign (Admin only)
+func (pc *PushController) CancelPushCampaign(c *gin.Context) {
+	campaignID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه نامعتبر است"})
+		return
+	}
+
+	if err := models.CancelPushCampaign(pc.db, uint(campaignID)); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "کمپین مورد نظر یافت نشد"})
+		case errors.Is(err, models.ErrPushCampaignNotScheduled):
+			c.JSON(http.StatusConflict, gin.H{"error": "فقط کمپین‌هایی که هنوز ارسال نشده‌اند قابل لغو هستند"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در لغو کمپین"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "کمپین لغو شد"})
+}
+
+// SendTestPushCampaign sends every variant of a campaign to one user's
+// devices, like SendTestPush. Admins of the admin panel have no devices of
+// their own, so they name the user with user_id (Admin only).
+func (pc *PushController) SendTestPushCampaign(c *gin.Context) {
+	campaign, ok := pc.loadCampaign(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID uint `json:"user_id"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات ارسالی نامعتبر است"})
+			return
+		}
+	}
+	userID := req.UserID
+	if userID == 0 && !c.GetBool("is_web_admin") {
+		userID = c.GetUint("user_id")
+	}
+	if userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر دریافت‌کننده پوش آزمایشی را وارد کنید"})
+		return
+	}
+
+	if err := services.NewPushCampaignService(pc.db).SendTest(campaign.ID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ارسال push notification", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Push notification با موفقیت ارسال شد"})
+}
+
+// TrackPushCampaignClick counts a click on a campaign push and redirects to
+// the variant's deep link. It is public: the service worker opens it without
+// a token. HEAD requests, prefetches and bots are redirected without being
+// counted, so they do not inflate click_rate.
+func (pc *PushController) TrackPushCampaignClick(c *gin.Context) {
+	var link string
+	var err error
+	if isAutomatedClick(c.Request) {
+		_, link, err = models.GetPushCampaignClickLink(pc.db, c.Param("token"))
+	} else {
+		link, err = models.RecordPushCampaignClick(pc.db, c.Param("token"))
+	}
+	if err != nil {
+		// An unknown or mistyped link still lands on the site
+		link = "/"
+	}
+	c.Redirect(http.StatusFound, link)
+}
+
+// botUserAgents are substrings of the user agents of crawlers and link
+// preview fetchers
+var botUserAgents = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "whatsapp", "preview", "headless"}
+
+// isAutomatedClick reports whether a request to a tracked link was made by
+// software rather than a user opening the push
+func isAutomatedClick(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for _, header := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		value := strings.ToLower(r.Header.Get(header))
+		if strings.Contains(value, "prefetch") || strings.Contains(value, "preview") {
+			return true
+		}
+	}
+	userAgent := strings.ToLower(r.UserAgent())
+	if userAgent == "" {
+		return true
+	}
+	for _, bot := range botUserAgents {
+		if strings.Contains(userAgent, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCampaign loads the campaign of the :id parameter, responding with an
+// error when it cannot
+func (pc *PushController) loadCampaign(c *gin.Context) (*models.PushCampaign, bool) {
+	campaignID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه نامعتبر است"})
+		return nil, false
+	}
+	campaign, err := models.GetPushCampaign(pc.db, uint(campaignID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "کمپین مورد نظر یافت نشد"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت کمپین"})
+		}
+		return nil, false
+	}
+	return campaign, true
+}
+
+// respondWithCampaign writes a campaign with its results per variant
+func (pc *PushController) respondWithCampaign(c *gin.Context, status int, campaign *models.PushCampaign) {
+	results, err := models.GetPushCampaignResults(pc.db, campaign)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت نتایج کمپین"})
+		return
+	}
+	c.JSON(status, pushCampaignDetails{PushCampaign: campaign, Results: results})
+}
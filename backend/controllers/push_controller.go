@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"asl-market-backend/models"
 	"asl-market-backend/services"
@@ -30,6 +33,10 @@ type SubscribeRequest struct {
 		P256dh string `json:"p256dh"` // Optional for FCM
 		Auth   string `json:"auth"`   // Optional for FCM
 	} `json:"keys"` // Optional for FCM
+	// Transport is webpush or fcm and Platform is web, android or ios. Both
+	// are optional and guessed from the endpoint and user agent when missing.
+	Transport string `json:"transport"`
+	Platform  string `json:"platform"`
 }
 
 // Subscribe handles push subscription
@@ -51,60 +58,66 @@ func (pc *PushController) Subscribe(c *gin.Context) {
 		return
 	}
 
+	if req.Transport != "" && !models.IsValidPushTransport(req.Transport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "نوع اتصال push نامعتبر است (webpush یا fcm)"})
+		return
+	}
+	if req.Platform != "" && !models.IsValidPushPlatform(req.Platform) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "پلتفرم نامعتبر است (web، android یا ios)"})
+		return
+	}
+
 	userAgent := c.GetHeader("User-Agent")
 	if userAgent == "" {
 		userAgent = "Unknown"
 	}
 
+	platform := req.Platform
+	if platform == "" {
+		platform = models.DetectPushPlatform(userAgent)
+	}
+
 	// Handle FCM token (if endpoint is FCM token format)
 	// FCM tokens can be sent as endpoint directly
+	endpoint := req.Endpoint
 	p256dh := req.Keys.P256dh
 	auth := req.Keys.Auth
 
-	// If endpoint looks like FCM token, keys might be empty
-	if p256dh == "" && auth == "" && (strings.HasPrefix(req.Endpoint, "fcm:") || len(req.Endpoint) > 100) {
-		// This is likely an FCM token
+	transport := req.Transport
+	if transport == "" {
+		// Older clients send FCM tokens without keys, sometimes prefixed
+		// with fcm: or as a full FCM URL
+		if p256dh == "" && auth == "" && (strings.HasPrefix(endpoint, "fcm:") || len(endpoint) > 100) {
+			transport = models.PushTransportFCM
+		} else {
+			transport = models.DetectPushTransport(endpoint)
+		}
+	}
+
+	if transport == models.PushTransportFCM {
 		// Extract token if it's in URL format
-		endpoint := req.Endpoint
 		if strings.Contains(endpoint, "fcm.googleapis.com") {
 			parts := strings.Split(endpoint, "/")
-			if len(parts) > 0 {
-				endpoint = parts[len(parts)-1]
-			}
+			endpoint = parts[len(parts)-1]
 		} else if strings.HasPrefix(endpoint, "fcm:") {
 			endpoint = endpoint[4:]
 		}
-
-		subscription, err := models.CreatePushSubscription(
-			pc.db,
-			userIDUint,
-			endpoint,
-			"", // FCM doesn't use these
-			"", // FCM doesn't use these
-			userAgent,
-		)
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "خطا در ثبت subscription",
-			})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"message":      "FCM Subscription با موفقیت ثبت شد",
-			"subscription": subscription,
-		})
+		// FCM doesn't use these
+		p256dh, auth = "", ""
+	} else if p256dh == "" || auth == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "کلیدهای p256dh و auth برای webpush الزامی است"})
 		return
 	}
 
 	subscription, err := models.CreatePushSubscription(
 		pc.db,
 		userIDUint,
-		req.Endpoint,
+		endpoint,
 		p256dh,
 		auth,
 		userAgent,
+		transport,
+		platform,
 	)
 
 	if err != nil {
@@ -114,8 +127,12 @@ func (pc *PushController) Subscribe(c *gin.Context) {
 		return
 	}
 
+	message := "Subscription با موفقیت ثبت شد"
+	if transport == models.PushTransportFCM {
+		message = "FCM Subscription با موفقیت ثبت شد"
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "Subscription با موفقیت ثبت شد",
+		"message":      message,
 		"subscription": subscription,
 	})
 }
@@ -175,6 +192,12 @@ func (pc *PushController) SendTestPush(c *gin.Context) {
 	}
 
 	if err := pushService.SendPushNotification(userIDUint, message); err != nil {
+		if errors.Is(err, services.ErrNoPushSubscriptions) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "هیچ دستگاه فعالی برای دریافت push notification ثبت نشده است",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "خطا در ارسال push notification",
 		})
@@ -202,3 +225,39 @@ func (pc *PushController) GetVAPIDPublicKey(c *gin.Context) {
 		"public_key": publicKey,
 	})
 }
+
+// GetPushMetrics returns the health of push subscriptions per transport and
+// platform, and the daily delivery outcomes of the last ?days= days (14 by
+// default) for the admin panel
+func (pc *PushController) GetPushMetrics(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "14"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "تعداد روزها باید بین ۱ تا ۹۰ باشد"})
+		return
+	}
+
+	health, err := models.GetPushSubscriptionHealth(pc.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت وضعیت subscription ها"})
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -(days - 1))
+	metrics, err := models.GetPushDeliveryMetrics(pc.db, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت آمار ارسال push"})
+		return
+	}
+
+	totals := map[string]int64{}
+	for _, metric := range metrics {
+		totals[metric.Outcome] += metric.Deliveries
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":          days,
+		"subscriptions": health,
+		"totals":        totals,
+		"daily":         metrics,
+	})
+}
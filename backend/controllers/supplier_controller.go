@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"asl-market-backend/middleware"
 	"asl-market-backend/models"
 
 	"github.com/gin-gonic/gin"
@@ -139,14 +141,17 @@ func GetApprovedSuppliers(c *gin.Context) {
 
 	userIDUint := userID.(uint)
 
-	// Check if user has license
-	hasLicense, err := models.CheckUserLicense(models.GetDB(), userIDUint)
+	// Check if user has license, counting the grace period
+	access, err := models.GetUserLicenseAccess(models.GetDB(), userIDUint, models.LicenseGracePeriod())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی وضعیت لایسنس"})
 		return
 	}
+	if access.InGrace {
+		c.Header("X-License-Grace-Ends-At", access.GraceEndsAt.Format(time.RFC3339))
+	}
 
-	if !hasLicense {
+	if !access.HasLicense {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error": "برای مشاهده تأمین‌کنندگان نیاز به لایسنس معتبر دارید",
 			"license_status": gin.H{
@@ -335,13 +340,16 @@ func GetSuppliersMatchingCapacity(c *gin.Context) {
 
 	userIDUint := userID.(uint)
 
-	// Check license (similar to GetApprovedSuppliers)
-	hasLicense, err := models.CheckUserLicense(models.GetDB(), userIDUint)
+	// Check license (similar to GetApprovedSuppliers), counting the grace period
+	access, err := models.GetUserLicenseAccess(models.GetDB(), userIDUint, models.LicenseGracePeriod())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی وضعیت لایسنس"})
 		return
 	}
-	if !hasLicense {
+	if access.InGrace {
+		c.Header("X-License-Grace-Ends-At", access.GraceEndsAt.Format(time.RFC3339))
+	}
+	if !access.HasLicense {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error": "برای مشاهده این بخش نیاز به لایسنس معتبر دارید",
 			"license_status": gin.H{
@@ -468,11 +476,13 @@ func ApproveSupplier(c *gin.Context) {
 	}
 	c.ShouldBindJSON(&req)
 
+	before := auditSnapshot(&models.Supplier{}, uint(supplierID))
 	err = models.ApproveSupplier(models.GetDB(), uint(supplierID), adminID.(uint), req.Notes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در تأیید تأمین‌کننده"})
 		return
 	}
+	middleware.RecordAudit(c, "supplier.approve", "supplier", supplierID, before, auditSnapshot(&models.Supplier{}, uint(supplierID)), nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "تأمین‌کننده با موفقیت تأیید شد",
@@ -502,11 +512,13 @@ func RejectSupplier(c *gin.Context) {
 		return
 	}
 
+	before := auditSnapshot(&models.Supplier{}, uint(supplierID))
 	err = models.RejectSupplier(models.GetDB(), uint(supplierID), adminID.(uint), req.Notes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در رد تأمین‌کننده"})
 		return
 	}
+	middleware.RecordAudit(c, "supplier.reject", "supplier", supplierID, before, auditSnapshot(&models.Supplier{}, uint(supplierID)), nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "تأمین‌کننده رد شد",
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"asl-market-backend/middleware"
 	"asl-market-backend/models"
 
 	"github.com/gin-gonic/gin"
@@ -489,11 +490,13 @@ func ApproveVisitorByAdmin(c *gin.Context) {
 	}
 	c.ShouldBindJSON(&req)
 
+	before := auditSnapshot(&models.Visitor{}, uint(visitorID))
 	err = models.ApproveVisitor(models.GetDB(), uint(visitorID), adminID, req.AdminNotes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در تایید ویزیتور"})
 		return
 	}
+	middleware.RecordAudit(c, "visitor.approve", "visitor", visitorID, before, auditSnapshot(&models.Visitor{}, uint(visitorID)), nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "ویزیتور با موفقیت تایید شد",
@@ -528,11 +531,13 @@ func RejectVisitorByAdmin(c *gin.Context) {
 		return
 	}
 
+	before := auditSnapshot(&models.Visitor{}, uint(visitorID))
 	err = models.RejectVisitor(models.GetDB(), uint(visitorID), adminID, req.AdminNotes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در رد درخواست ویزیتور"})
 		return
 	}
+	middleware.RecordAudit(c, "visitor.reject", "visitor", visitorID, before, auditSnapshot(&models.Visitor{}, uint(visitorID)), nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "درخواست ویزیتور رد شد",
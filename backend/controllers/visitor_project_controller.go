@@ -2,11 +2,13 @@ package controllers
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"asl-market-backend/models"
+	"asl-market-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -59,6 +61,19 @@ func (vpc *VisitorProjectController) CreateVisitorProject(c *gin.Context) {
 		return
 	}
 
+	// Notify matching suppliers in background on a fresh copy, like matching
+	// requests, so the goroutine does not share the project being serialized
+	projectID := project.ID
+	go func() {
+		fullProject, err := models.GetVisitorProjectByID(vpc.db, projectID)
+		if err == nil {
+			err = services.NewVisitorProjectService(vpc.db).ProcessVisitorProject(fullProject)
+		}
+		if err != nil {
+			log.Printf("Failed to notify suppliers of visitor project %d: %v", projectID, err)
+		}
+	}()
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "پروژه ویزیتوری با موفقیت ایجاد شد. تأمین‌کننده‌های مناسب به زودی مطلع خواهند شد.",
 		"project": project,
@@ -452,6 +467,21 @@ func (vpc *VisitorProjectController) SubmitProposal(c *gin.Context) {
 		return
 	}
 
+	// Check the daily quota of the user's license plan
+	allowed, plan, err := models.CanSubmitProposal(vpc.db, userIDUint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی محدودیت روزانه"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":         fmt.Sprintf("شما امروز به سقف %d پیشنهاد رسیده‌اید. فردا دوباره تلاش کنید.", plan.DailyProposals),
+			"limit_reached": true,
+			"daily_limit":   plan.DailyProposals,
+		})
+		return
+	}
+
 	// Create proposal
 	proposal, err := models.CreateVisitorProjectProposal(vpc.db, uint(projectID), supplier.ID, userIDUint, req)
 	if err != nil {
@@ -608,15 +638,14 @@ func (vpc *VisitorProjectController) GetVisitorProjectChats(c *gin.Context) {
 	}
 
 	userIDUint := userID.(uint)
+	page, perPage := conversationPagination(c, 100)
 
-	// Check if user is supplier or visitor
-	isSupplier := false
-	_, err := models.GetSupplierByUserID(vpc.db, userIDUint)
-	if err == nil {
-		isSupplier = true
+	conversations, _, err := models.GetConversationsForUser(vpc.db, userIDUint, models.ConversationContextVisitorProject, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت چت‌ها"})
+		return
 	}
-
-	chats, err := models.GetVisitorProjectChatsForUser(vpc.db, userIDUint, isSupplier)
+	chats, err := models.BuildConversationViews(vpc.db, conversations, userIDUint)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت چت‌ها"})
 		return
@@ -627,85 +656,49 @@ func (vpc *VisitorProjectController) GetVisitorProjectChats(c *gin.Context) {
 
 // GetVisitorProjectChatMessages gets messages for a specific chat
 func (vpc *VisitorProjectController) GetVisitorProjectChatMessages(c *gin.Context) {
-	_, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
-		return
-	}
-
-	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه چت نامعتبر است"})
+	conversation, userID, ok := loadParticipantConversation(c, vpc.db, models.ConversationContextVisitorProject)
+	if !ok {
 		return
 	}
-
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
-
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 50
-	}
-
-	messages, total, err := models.GetVisitorProjectChatMessages(vpc.db, uint(chatID), page, perPage)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پیام‌ها"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"messages":    messages,
-		"total":       total,
-		"page":        page,
-		"per_page":    perPage,
-		"total_pages": (int(total) + perPage - 1) / perPage,
-	})
+	respondConversationMessages(c, vpc.db, conversation, userID)
 }
 
 // SendVisitorProjectChatMessage sends a message in a chat
 func (vpc *VisitorProjectController) SendVisitorProjectChatMessage(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "برای دسترسی به این بخش، لطفاً ابتدا وارد حساب کاربری خود شوید."})
+	conversation, userID, ok := loadParticipantConversation(c, vpc.db, models.ConversationContextVisitorProject)
+	if !ok {
 		return
 	}
-
-	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه چت نامعتبر است"})
-		return
-	}
-
-	var req struct {
-		Message  string `json:"message" binding:"required"`
-		ImageURL string `json:"image_url"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "پیام نامعتبر است"})
+	message, ok := sendConversationMessage(c, vpc.db, conversation, userID)
+	if !ok {
 		return
 	}
 
-	userIDUint := userID.(uint)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "پیام با موفقیت ارسال شد",
+		"data":    message,
+	})
+}
 
-	// Determine sender type
-	senderType := "visitor"
-	_, err = models.GetSupplierByUserID(vpc.db, userIDUint)
-	if err == nil {
-		senderType = "supplier"
+// MarkVisitorProjectChatRead marks the messages the current user received in
+// a chat as read
+func (vpc *VisitorProjectController) MarkVisitorProjectChatRead(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, vpc.db, models.ConversationContextVisitorProject)
+	if !ok {
+		return
 	}
+	count := markConversationRead(vpc.db, conversation, userID)
+	c.JSON(http.StatusOK, gin.H{"marked": count})
+}
 
-	message, err := models.CreateVisitorProjectMessage(vpc.db, uint(chatID), userIDUint, senderType, req.Message, req.ImageURL)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ارسال پیام"})
+// SendVisitorProjectChatTyping tells the other party that the current user
+// started or stopped typing. Typing events are only sent to open streams.
+func (vpc *VisitorProjectController) SendVisitorProjectChatTyping(c *gin.Context) {
+	conversation, userID, ok := loadParticipantConversation(c, vpc.db, models.ConversationContextVisitorProject)
+	if !ok {
 		return
 	}
-
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "پیام با موفقیت ارسال شد",
-		"data":    message,
-	})
+	sendConversationTyping(c, conversation, userID)
 }
 
 // StartVisitorProjectChat starts a chat between visitor and supplier
@@ -759,7 +752,12 @@ func (vpc *VisitorProjectController) StartVisitorProjectChat(c *gin.Context) {
 	}
 
 	// Get or create chat
-	chat, err := models.GetOrCreateVisitorProjectChat(vpc.db, uint(projectID), visitorID, supplierID)
+	conversation, err := models.GetOrCreateVisitorProjectConversation(vpc.db, uint(projectID), visitorID, supplierID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد چت"})
+		return
+	}
+	chats, err := models.BuildConversationViews(vpc.db, []models.Conversation{*conversation}, userIDUint)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد چت"})
 		return
@@ -767,6 +765,6 @@ func (vpc *VisitorProjectController) StartVisitorProjectChat(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "چت با موفقیت ایجاد شد",
-		"chat":    chat,
+		"chat":    chats[0],
 	})
 }
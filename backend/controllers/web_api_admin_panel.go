@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"asl-market-backend/config"
+	"asl-market-backend/middleware"
 	"asl-market-backend/models"
 	"asl-market-backend/services"
 	"asl-market-backend/utils"
@@ -62,7 +65,7 @@ func GetAdminDashboardStats(c *gin.Context) {
 	db.Model(&models.License{}).Where("is_used = ?", false).Count(&availableLicenses)
 
 	// Get withdrawal statistics
-	withdrawalStats, _ := models.GetWithdrawalStats(db, nil)
+	withdrawalStats, _ := models.GetWithdrawalStats(db, nil, c.Query("base_currency"))
 	var pendingWithdrawals int64
 	var completedWithdrawals int64
 	db.Model(&models.WithdrawalRequest{}).Where("status = ?", "pending").Count(&pendingWithdrawals)
@@ -252,6 +255,7 @@ func GetUserDetailsForAdmin(c *gin.Context) {
 	// Get user's license info if exists
 	var license *models.License
 	license, _ = models.GetUserLicense(db, user.ID)
+	licenseHistory, _ := models.GetUserLicenseHistory(db, user.ID)
 
 	// Get user's withdrawal requests
 	withdrawals, _ := models.GetUserWithdrawalRequests(db, user.ID)
@@ -263,12 +267,13 @@ func GetUserDetailsForAdmin(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"user":        user.ToResponse(),
-			"supplier":    supplier,
-			"visitor":     visitor,
-			"license":     license,
-			"withdrawals": withdrawals,
-			"tickets":     tickets,
+			"user":            user.ToResponse(),
+			"supplier":        supplier,
+			"visitor":         visitor,
+			"license":         license,
+			"license_history": licenseHistory,
+			"withdrawals":     withdrawals,
+			"tickets":         tickets,
 		},
 	})
 }
@@ -430,6 +435,9 @@ func UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در به‌روزرسانی اطلاعات کاربر"})
 		return
 	}
+	if req.IsActive != nil && !*req.IsActive {
+		revokeSubjectSessions(utils.SubjectUser, user.ID, models.SessionRevokeDeactivated)
+	}
 
 	// Reload user to get updated data
 	if err := db.First(&user, userID).Error; err != nil {
@@ -451,6 +459,14 @@ func UpdateUser(c *gin.Context) {
 	})
 }
 
+// revokeSubjectSessions signs a user, web admin or affiliate out of every
+// device. Failures are logged; the admin action itself already succeeded.
+func revokeSubjectSessions(subjectType string, subjectID uint, reason string) {
+	if err := models.RevokeAllAuthSessions(models.GetDB(), subjectType, subjectID, reason); err != nil {
+		log.Printf("Failed to revoke %s %d sessions: %v", subjectType, subjectID, err)
+	}
+}
+
 // UpdateUserStatus updates a user's active status
 func UpdateUserStatus(c *gin.Context) {
 	db := models.GetDB()
@@ -462,7 +478,7 @@ func UpdateUserStatus(c *gin.Context) {
 	}
 
 	var req struct {
-		IsActive bool `json:"is_active" binding:"required"`
+		IsActive *bool `json:"is_active" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -470,10 +486,13 @@ func UpdateUserStatus(c *gin.Context) {
 		return
 	}
 
-	if err := db.Model(&models.User{}).Where("id = ?", userID).Update("is_active", req.IsActive).Error; err != nil {
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Update("is_active", *req.IsActive).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در به‌روزرسانی وضعیت کاربر"})
 		return
 	}
+	if !*req.IsActive {
+		revokeSubjectSessions(utils.SubjectUser, uint(userID), models.SessionRevokeDeactivated)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -491,10 +510,13 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
+	before := auditSnapshot(&models.User{}, uint(userID))
 	if err := db.Delete(&models.User{}, userID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در حذف کاربر"})
 		return
 	}
+	middleware.RecordAudit(c, "user.delete", "user", userID, before, nil, nil)
+	revokeSubjectSessions(utils.SubjectUser, uint(userID), models.SessionRevokeDeleted)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -513,8 +535,9 @@ func GetLicensesForAdmin(c *gin.Context) {
 	// Parse query parameters
 	pageStr := c.DefaultQuery("page", "1")
 	perPageStr := c.DefaultQuery("per_page", "10")
-	status := c.Query("status")    // used, available, all
+	status := c.Query("status")    // used, available, revoked, all
 	licenseType := c.Query("type") // pro, plus, plus4
+	batchID := c.Query("batch_id")
 
 	page, err := strconv.Atoi(pageStr)
 	if err != nil || page < 1 {
@@ -533,7 +556,14 @@ func GetLicensesForAdmin(c *gin.Context) {
 	if status == "used" {
 		query = query.Where("is_used = ?", true)
 	} else if status == "available" {
-		query = query.Where("is_used = ?", false)
+		query = query.Where("is_used = ? AND revoked_at IS NULL", false)
+	} else if status == "revoked" {
+		query = query.Where("revoked_at IS NOT NULL")
+	}
+
+	// Apply batch filter
+	if batchID != "" {
+		query = query.Where("batch_id = ?", batchID)
 	}
 
 	// Apply type filter
@@ -556,8 +586,10 @@ func GetLicensesForAdmin(c *gin.Context) {
 	// Get statistics
 	var usedCount int64
 	var availableCount int64
+	var revokedCount int64
 	db.Model(&models.License{}).Where("is_used = ?", true).Count(&usedCount)
-	db.Model(&models.License{}).Where("is_used = ?", false).Count(&availableCount)
+	db.Model(&models.License{}).Where("is_used = ? AND revoked_at IS NULL", false).Count(&availableCount)
+	db.Model(&models.License{}).Where("revoked_at IS NOT NULL").Count(&revokedCount)
 
 	totalPages := (int(total) + perPage - 1) / perPage
 
@@ -568,6 +600,7 @@ func GetLicensesForAdmin(c *gin.Context) {
 			"total":       total,
 			"used":        usedCount,
 			"available":   availableCount,
+			"revoked":     revokedCount,
 			"page":        page,
 			"per_page":    perPage,
 			"total_pages": totalPages,
@@ -577,7 +610,29 @@ func GetLicensesForAdmin(c *gin.Context) {
 	})
 }
 
-// GenerateLicensesForAdmin generates new licenses (admin only)
+// GetExpiringLicensesForAdmin lists licenses expiring in the next days (7 by
+// default) and those in their grace period
+func GetExpiringLicensesForAdmin(c *gin.Context) {
+	db := models.GetDB()
+
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if days < 1 || days > 90 {
+		days = 7
+	}
+
+	report, err := models.GetLicenseExpiryReport(db, days, models.LicenseGracePeriod())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت لایسنس‌های رو به انقضا"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// GenerateLicensesForAdmin generates a named batch of licenses (admin only)
 func GenerateLicensesForAdmin(c *gin.Context) {
 	db := models.GetDB()
 	adminID := c.GetUint("user_id")
@@ -588,28 +643,165 @@ func GenerateLicensesForAdmin(c *gin.Context) {
 		return
 	}
 
-	codes, err := models.GenerateLicenses(db, req.Count, req.Type, adminID)
+	actorType, actorID, actorName := middleware.CurrentActor(c)
+	batch, codes, err := models.CreateLicenseBatch(db, req.BatchRequest(), models.LicenseBatchActor{
+		Type:   actorType,
+		ID:     actorID,
+		Name:   actorName,
+		UserID: adminID,
+	})
 	if err != nil {
+		var batchErr *models.LicenseBatchError
+		if errors.As(err, &batchErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": batchErr.Message})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در تولید لایسنس‌ها"})
 		return
 	}
-
-	// Get duration based on type
-	duration := 12 // default for plus
-	if req.Type == "pro" {
-		duration = 30
-	} else if req.Type == "plus4" {
-		duration = 4
-	}
+	middleware.RecordAudit(c, "license.generate", "license_batch", batch.ID, nil, nil, map[string]interface{}{
+		"batch_id": batch.ID,
+		"count":    len(codes),
+		"type":     batch.Type,
+		"channel":  batch.Channel,
+		"name":     batch.Name,
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
 		"data": models.LicenseGenerateResponse{
-			Message:  fmt.Sprintf("%d لایسنس %s با موفقیت تولید شد", req.Count, req.Type),
-			Count:    req.Count,
-			Type:     req.Type,
-			Duration: duration,
+			Message:  fmt.Sprintf("%d لایسنس %s با موفقیت تولید شد", batch.Count, batch.Type),
+			Count:    batch.Count,
+			Type:     batch.Type,
+			Duration: batch.Duration,
 			Licenses: codes,
+			Batch:    batch,
+		},
+	})
+}
+
+// GetLicenseBatchesForAdmin returns paginated license batches with their
+// redemption stats
+func GetLicenseBatchesForAdmin(c *gin.Context) {
+	db := models.GetDB()
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if err != nil || perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+
+	filter := models.LicenseBatchFilter{
+		Channel: c.Query("channel"),
+		Type:    c.Query("type"),
+		Page:    page,
+		PerPage: perPage,
+	}
+	if affiliateID, err := strconv.ParseUint(c.Query("affiliate_id"), 10, 32); err == nil {
+		id := uint(affiliateID)
+		filter.AffiliateID = &id
+	}
+
+	batches, total, err := models.GetLicenseBatches(db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت دسته‌های لایسنس"})
+		return
+	}
+
+	totalPages := (int(total) + perPage - 1) / perPage
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"batches":     batches,
+			"channels":    models.LicenseChannels,
+			"total":       total,
+			"page":        page,
+			"per_page":    perPage,
+			"total_pages": totalPages,
+			"has_next":    page < totalPages,
+			"has_prev":    page > 1,
+		},
+	})
+}
+
+// GetLicenseBatchForAdmin returns a license batch with its redemption stats
+func GetLicenseBatchForAdmin(c *gin.Context) {
+	db := models.GetDB()
+
+	batchID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه دسته نامعتبر است"})
+		return
+	}
+
+	batch, err := models.GetLicenseBatch(db, uint(batchID))
+	if err != nil {
+		if errors.Is(err, models.ErrLicenseBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "دسته لایسنس یافت نشد"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت دسته لایسنس"})
+		return
+	}
+
+	stats, err := models.GetLicenseBatchStats(db, []models.LicenseBatch{*batch})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در محاسبه آمار دسته لایسنس"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.LicenseBatchWithStats{LicenseBatch: *batch, Stats: stats[batch.ID]},
+	})
+}
+
+// RevokeLicenseBatchForAdmin revokes the unused codes of a license batch.
+// Codes that were already redeemed keep working.
+func RevokeLicenseBatchForAdmin(c *gin.Context) {
+	db := models.GetDB()
+
+	batchID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه دسته نامعتبر است"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&req)
+
+	batch, err := models.GetLicenseBatch(db, uint(batchID))
+	if err != nil {
+		if errors.Is(err, models.ErrLicenseBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "دسته لایسنس یافت نشد"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت دسته لایسنس"})
+		return
+	}
+
+	revoked, err := models.RevokeLicenseBatch(db, batch.ID, strings.TrimSpace(req.Reason))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در باطل کردن لایسنس‌ها"})
+		return
+	}
+	middleware.RecordAudit(c, "license_batch.revoke", "license_batch", batch.ID, nil, nil, map[string]interface{}{
+		"revoked": revoked,
+		"reason":  req.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("%d لایسنس استفاده‌نشده از دسته «%s» باطل شد", revoked, batch.Name),
+		"data": gin.H{
+			"batch_id": batch.ID,
+			"revoked":  revoked,
 		},
 	})
 }
@@ -1162,7 +1354,7 @@ func GetAllNotificationsForAdmin(c *gin.Context) {
 	// Parse query parameters
 	pageStr := c.DefaultQuery("page", "1")
 	perPageStr := c.DefaultQuery("per_page", "10")
-	status := c.Query("status")         // active, inactive, all
+	status := c.Query("status")         // active, inactive, scheduled, all
 	notificationType := c.Query("type") // info, warning, error, success
 
 	page, err := strconv.Atoi(pageStr)
@@ -1183,6 +1375,8 @@ func GetAllNotificationsForAdmin(c *gin.Context) {
 		query = query.Where("is_active = ?", true)
 	} else if status == "inactive" {
 		query = query.Where("is_active = ?", false)
+	} else if status == "scheduled" {
+		query = query.Where("status = ?", models.NotificationStatusScheduled)
 	}
 
 	// Apply type filter
@@ -1222,6 +1416,8 @@ func GetAllNotificationsForAdmin(c *gin.Context) {
 			UpdatedAt:   notification.UpdatedAt,
 		}
 
+		response.Delivery = notification.DeliveryInfo()
+
 		// Add created by info
 		if notification.CreatedBy.ID > 0 {
 			response.CreatedBy = models.UserResponse{
@@ -1650,16 +1846,74 @@ func ExportVisitorsToExcel(c *gin.Context) {
 	})
 }
 
-// ExportLicensesToExcel exports licenses to Excel file
+// ExportLicensesToExcel exports licenses to an Excel file, or a CSV file with
+// ?format=csv. ?batch_id= limits the export to one batch for reconciliation.
 func ExportLicensesToExcel(c *gin.Context) {
 	db := models.GetDB()
 
+	query := db.Preload("User").Preload("Admin").Preload("Batch")
+	filePrefix := "licenses_export"
+	if batchIDStr := c.Query("batch_id"); batchIDStr != "" {
+		batchID, err := strconv.ParseUint(batchIDStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه دسته نامعتبر است"})
+			return
+		}
+		if _, err := models.GetLicenseBatch(db, uint(batchID)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "دسته لایسنس یافت نشد"})
+			return
+		}
+		query = query.Where("batch_id = ?", batchID)
+		filePrefix = fmt.Sprintf("license_batch_%d_export", batchID)
+	}
+
 	var licenses []models.License
-	if err := db.Preload("User").Preload("Admin").Find(&licenses).Error; err != nil {
+	if err := query.Order("id ASC").Find(&licenses).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت لایسنس‌ها"})
 		return
 	}
 
+	headers := []string{"ID", "کد لایسنس", "نوع", "مدت (ماه)", "وضعیت", "کاربر", "موبایل کاربر", "تاریخ استفاده", "دسته", "کانال فروش", "تاریخ تولید"}
+	rows := make([][]string, len(licenses))
+	for i, license := range licenses {
+		status := "استفاده نشده"
+		if license.IsUsed {
+			status = "استفاده شده"
+		} else if license.RevokedAt != nil {
+			status = "باطل شده"
+		}
+		var userName, userPhone, usedAt, batchName, channel string
+		if license.User != nil {
+			userName = fmt.Sprintf("%s %s", license.User.FirstName, license.User.LastName)
+			userPhone = license.User.Phone
+		}
+		if license.UsedAt != nil {
+			usedAt = license.UsedAt.Format("2006-01-02 15:04:05")
+		}
+		if license.Batch != nil {
+			batchName = license.Batch.Name
+			channel = license.Batch.Channel
+		}
+		rows[i] = []string{
+			strconv.FormatUint(uint64(license.ID), 10), license.Code, license.Type, strconv.Itoa(license.Duration),
+			status, userName, userPhone, usedAt, batchName, channel, license.CreatedAt.Format("2006-01-02 15:04:05"),
+		}
+	}
+
+	// License codes are credentials, so the file is sent in this
+	// authenticated response and never saved under the public uploads folder
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := writeExportCSV(&buf, headers, rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد فایل CSV"})
+			return
+		}
+		filename := fmt.Sprintf("%s_%s.csv", filePrefix, time.Now().Format("20060102_150405"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+		return
+	}
+
 	// Create Excel file
 	f := excelize.NewFile()
 	defer func() {
@@ -1676,43 +1930,41 @@ func ExportLicensesToExcel(c *gin.Context) {
 	}
 
 	// Set headers
-	headers := []string{"ID", "کد لایسنس", "نوع", "مدت (ماه)", "استفاده شده", "کاربر", "تاریخ تولید"}
 	for i, header := range headers {
 		cell := fmt.Sprintf("%c1", 'A'+i)
 		f.SetCellValue(sheetName, cell, header)
 	}
 
 	// Add data
-	for i, license := range licenses {
-		row := i + 2
-		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), license.ID)
-		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), license.Code)
-		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), license.Type)
-		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), license.Duration)
-		f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), license.IsUsed)
-		if license.User != nil {
-			f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), fmt.Sprintf("%s %s", license.User.FirstName, license.User.LastName))
+	for i, row := range rows {
+		for j, value := range row {
+			f.SetCellValue(sheetName, fmt.Sprintf("%c%d", 'A'+j, i+2), value)
 		}
-		f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), license.CreatedAt.Format("2006-01-02 15:04:05"))
 	}
 
 	f.SetActiveSheet(index)
 
-	// Save file
-	filename := fmt.Sprintf("licenses_export_%s.xlsx", time.Now().Format("20060102_150405"))
-	filepath := fmt.Sprintf("./uploads/exports/%s", filename)
-
-	if err := f.SaveAs(filepath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره فایل Excel"})
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد فایل Excel"})
 		return
 	}
+	filename := fmt.Sprintf("%s_%s.xlsx", filePrefix, time.Now().Format("20060102_150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":  true,
-		"message":  "فایل Excel با موفقیت ایجاد شد",
-		"filename": filename,
-		"url":      fmt.Sprintf("/uploads/exports/%s", filename),
-	})
+// writeExportCSV writes rows as UTF-8 CSV with a BOM so Excel shows Persian
+// text correctly
+func writeExportCSV(out io.Writer, headers []string, rows [][]string) error {
+	if _, err := io.WriteString(out, "\ufeff"); err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	if err := w.Write(headers); err != nil {
+		return err
+	}
+	return w.WriteAll(rows)
 }
 
 // ============================================
@@ -2074,6 +2326,11 @@ func CreateWebAdmin(c *gin.Context) {
 	log.Printf("CreateWebAdmin: Creating admin with Username: '%s', Email: '%s', Name: '%s', Role: '%s', IsActive: %v",
 		req.Username, req.Email, req.Name, req.Role, req.IsActive)
 
+	if req.Role == models.AdminRoleSuperAdmin && c.GetString("admin_role") != models.AdminRoleSuperAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "فقط مدیر ارشد می‌تواند مدیر ارشد ایجاد کند"})
+		return
+	}
+
 	// Check if username already exists
 	var existingUsername models.WebAdmin
 	if err := db.Where("username = ? AND deleted_at IS NULL", req.Username).First(&existingUsername).Error; err == nil {
@@ -2180,12 +2437,19 @@ func UpdateWebAdmin(c *gin.Context) {
 	}
 
 	// Check if admin exists
-	_, err = models.GetWebAdminByID(db, uint(id))
+	existing, err := models.GetWebAdminByID(db, uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "مدیر یافت نشد"})
 		return
 	}
 
+	// Only super admins may touch super admin accounts or promote to super admin
+	if (existing.Role == models.AdminRoleSuperAdmin || req.Role == models.AdminRoleSuperAdmin) &&
+		c.GetString("admin_role") != models.AdminRoleSuperAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "فقط مدیر ارشد می‌تواند مدیر ارشد را تغییر دهد"})
+		return
+	}
+
 	// Build updates map
 	updates := make(map[string]interface{})
 
@@ -2243,6 +2507,9 @@ func UpdateWebAdmin(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در به‌روزرسانی مدیر: " + err.Error()})
 		return
 	}
+	if (req.IsActive != nil && !*req.IsActive) || req.Password != "" {
+		revokeSubjectSessions(utils.SubjectWebAdmin, uint(id), models.SessionRevokeDeactivated)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -2262,16 +2529,22 @@ func DeleteWebAdmin(c *gin.Context) {
 	}
 
 	// Check if admin exists
-	_, err = models.GetWebAdminByID(db, uint(id))
+	existing, err := models.GetWebAdminByID(db, uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "مدیر یافت نشد"})
 		return
 	}
 
+	if existing.Role == models.AdminRoleSuperAdmin && c.GetString("admin_role") != models.AdminRoleSuperAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "فقط مدیر ارشد می‌تواند مدیر ارشد را حذف کند"})
+		return
+	}
+
 	if err := models.DeleteWebAdmin(db, uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در حذف مدیر"})
 		return
 	}
+	revokeSubjectSessions(utils.SubjectWebAdmin, uint(id), models.SessionRevokeDeleted)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -2279,6 +2552,205 @@ func DeleteWebAdmin(c *gin.Context) {
 	})
 }
 
+// ============================================
+// ROLE PERMISSIONS
+// ============================================
+
+// GetAdminPermissions returns the permission catalogue, the role to permission
+// map and the caller's own effective permissions
+func GetAdminPermissions(c *gin.Context) {
+	db := models.GetDB()
+
+	roles, err := models.GetRolePermissionMap(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت دسترسی‌ها"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"permissions":    models.AdminPermissions,
+			"roles":          roles,
+			"defaults":       models.DefaultRolePermissions,
+			"my_role":        c.GetString("admin_role"),
+			"my_permissions": c.GetStringSlice("admin_permissions"),
+		},
+	})
+}
+
+// UpdateRolePermissions replaces the permissions of a role (super admin only)
+func UpdateRolePermissions(c *gin.Context) {
+	db := models.GetDB()
+
+	var req struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Permissions == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "لیست دسترسی‌ها الزامی است"})
+		return
+	}
+
+	role := c.Param("role")
+	before, _ := models.GetRolePermissions(db, role)
+	permissions, err := models.SetRolePermissions(db, role, req.Permissions, c.GetUint("user_id"))
+	if err != nil {
+		var permErr *models.AdminPermissionError
+		if errors.As(err, &permErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": permErr.Message})
+			return
+		}
+		log.Printf("UpdateRolePermissions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره دسترسی‌ها"})
+		return
+	}
+	middleware.RecordAudit(c, "role_permissions.update", "admin_role", role,
+		gin.H{"permissions": before}, gin.H{"permissions": permissions}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "دسترسی‌های نقش با موفقیت به‌روزرسانی شد",
+		"data": gin.H{
+			"role":        role,
+			"permissions": permissions,
+		},
+	})
+}
+
+// ResetRolePermissions restores the default permissions of a role (super admin only)
+func ResetRolePermissions(c *gin.Context) {
+	db := models.GetDB()
+
+	role := c.Param("role")
+	if !models.IsValidAdminRole(role) || role == models.AdminRoleSuperAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "نقش نامعتبر است"})
+		return
+	}
+
+	if err := models.ResetRolePermissions(db, role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بازنشانی دسترسی‌ها"})
+		return
+	}
+
+	permissions, _ := models.GetRolePermissions(db, role)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "دسترسی‌های نقش به حالت پیش‌فرض بازگشت",
+		"data": gin.H{
+			"role":        role,
+			"permissions": permissions,
+		},
+	})
+}
+
+// ============================================
+// LICENSE PLANS
+// ============================================
+
+// GetLicensePlans returns the daily quotas of every license plan
+func GetLicensePlans(c *gin.Context) {
+	db := models.GetDB()
+
+	plans, err := models.GetLicensePlans(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پلن‌های لایسنس"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"plans":    plans,
+			"defaults": models.DefaultLicensePlans,
+		},
+	})
+}
+
+// UpdateLicensePlan replaces the daily quotas of a license plan. A quota of 0
+// means unlimited.
+func UpdateLicensePlan(c *gin.Context) {
+	db := models.GetDB()
+
+	var req struct {
+		DailyVisitorViews          *int   `json:"daily_visitor_views" binding:"required"`
+		DailySupplierViews         *int   `json:"daily_supplier_views" binding:"required"`
+		DailyAvailableProductViews *int   `json:"daily_available_product_views" binding:"required"`
+		DailyAIMessages            *int   `json:"daily_ai_messages" binding:"required"`
+		DailyMatchingRequests      *int   `json:"daily_matching_requests" binding:"required"`
+		DailyProposals             *int   `json:"daily_proposals" binding:"required"`
+		PriceToman                 *int64 `json:"price_toman"` // optional, kept when omitted
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "همه سقف‌های روزانه پلن الزامی است"})
+		return
+	}
+
+	licenseType := c.Param("type")
+	before, _ := models.GetLicensePlan(db, licenseType)
+	var price int64
+	if req.PriceToman != nil {
+		price = *req.PriceToman
+	} else if before != nil {
+		price = before.PriceToman
+	}
+	plan, err := models.SetLicensePlan(db, models.LicensePlan{
+		Type:                       licenseType,
+		DailyVisitorViews:          *req.DailyVisitorViews,
+		DailySupplierViews:         *req.DailySupplierViews,
+		DailyAvailableProductViews: *req.DailyAvailableProductViews,
+		DailyAIMessages:            *req.DailyAIMessages,
+		DailyMatchingRequests:      *req.DailyMatchingRequests,
+		DailyProposals:             *req.DailyProposals,
+		PriceToman:                 price,
+	}, c.GetUint("user_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnknownLicensePlan):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "نوع لایسنس نامعتبر است"})
+		case errors.Is(err, models.ErrNegativeQuota):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "سقف‌های روزانه نمی‌توانند منفی باشند"})
+		case errors.Is(err, models.ErrNegativePrice):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "قیمت پلن نمی‌تواند منفی باشد"})
+		default:
+			log.Printf("UpdateLicensePlan: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره پلن لایسنس"})
+		}
+		return
+	}
+	middleware.RecordAudit(c, "license_plan.update", "license_plan", licenseType, before, plan, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "پلن لایسنس با موفقیت به‌روزرسانی شد",
+		"data":    plan,
+	})
+}
+
+// ResetLicensePlan restores the default quotas of a license plan
+func ResetLicensePlan(c *gin.Context) {
+	db := models.GetDB()
+
+	licenseType := c.Param("type")
+	if !models.IsValidLicensePlanType(licenseType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "نوع لایسنس نامعتبر است"})
+		return
+	}
+
+	before, _ := models.GetLicensePlan(db, licenseType)
+	if err := models.ResetLicensePlan(db, licenseType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بازنشانی پلن لایسنس"})
+		return
+	}
+
+	plan, _ := models.GetLicensePlan(db, licenseType)
+	middleware.RecordAudit(c, "license_plan.reset", "license_plan", licenseType, before, plan, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "پلن لایسنس به حالت پیش‌فرض بازگشت",
+		"data":    plan,
+	})
+}
+
 // ==================== AFFILIATE MANAGEMENT (Admin Panel) ====================
 
 // GetAffiliates returns all affiliates with pagination and aggregate stats
@@ -2487,9 +2959,6 @@ func UpdateAffiliate(c *gin.Context) {
 	if req.IsActive != nil {
 		updates["is_active"] = *req.IsActive
 	}
-	if req.Balance != nil {
-		updates["balance"] = *req.Balance
-	}
 	if req.ReferralLink != nil {
 		link := strings.TrimSpace(*req.ReferralLink)
 		updates["referral_link"] = link
@@ -2500,6 +2969,14 @@ func UpdateAffiliate(c *gin.Context) {
 			updates["commission_percent"] = pct
 		}
 	}
+	// Balance is derived from the ledger, so an edited balance is posted as an adjustment
+	if req.Balance != nil {
+		_, _, actorName := middleware.CurrentActor(c)
+		if err := models.AdjustAffiliateBalance(db, uint(id), *req.Balance, "manual adjustment by "+actorName); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در اصلاح موجودی افیلیت"})
+			return
+		}
+	}
 	if len(updates) == 0 {
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "بدون تغییر"})
 		return
@@ -2508,6 +2985,9 @@ func UpdateAffiliate(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در به‌روزرسانی افیلیت"})
 		return
 	}
+	if (req.IsActive != nil && !*req.IsActive) || updates["password"] != nil {
+		revokeSubjectSessions(utils.SubjectAffiliate, uint(id), models.SessionRevokeDeactivated)
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "افیلیت با موفقیت به‌روزرسانی شد"})
 }
 
@@ -2528,6 +3008,7 @@ func DeleteAffiliate(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در حذف افیلیت"})
 		return
 	}
+	revokeSubjectSessions(utils.SubjectAffiliate, uint(id), models.SessionRevokeDeleted)
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "افیلیت با موفقیت حذف شد"})
 }
 
@@ -2842,7 +3323,10 @@ type MatchAffiliateSalesRequestBody struct {
 	} `json:"buyers"`
 }
 
-// MatchAffiliateSales matches a list of buyers (name+phone) against affiliate's registered users; returns matched list
+// MatchAffiliateSales reconciles a sales list (name+phone) against the
+// affiliate's registered users. Rows are returned as matched, probable (with
+// a confidence to review) or unmatched; phones in any Iranian format and
+// Persian/Arabic spelling variants of names are matched.
 func MatchAffiliateSales(c *gin.Context) {
 	db := models.GetDB()
 	idStr := c.Param("id")
@@ -2860,45 +3344,53 @@ func MatchAffiliateSales(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "اطلاعات وارد شده صحیح نیست. لطفاً تمام فیلدهای الزامی را پر کنید."})
 		return
 	}
-	normalizePhone := func(s string) string {
-		return strings.Map(func(r rune) rune {
-			if r >= '0' && r <= '9' {
-				return r
-			}
-			return -1
-		}, strings.TrimSpace(s))
-	}
-	normalizeName := func(s string) string {
-		return strings.TrimSpace(strings.ToLower(s))
-	}
 	// load all registered users for this affiliate (no pagination for matching)
 	var regUsers []models.AffiliateRegisteredUser
 	if err := db.Where("affiliate_id = ?", uint(id)).Find(&regUsers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت لیست ثبت‌نام‌ها"})
 		return
 	}
-	regMap := make(map[string]models.AffiliateRegisteredUser) // key: normalized phone + "|" + normalized name
-	for _, u := range regUsers {
-		key := normalizePhone(u.Phone) + "|" + normalizeName(u.Name)
-		regMap[key] = u
-	}
-	var matched []gin.H
+	rows := make([]services.SalesRow, 0, len(req.Buyers))
 	for _, b := range req.Buyers {
-		name := strings.TrimSpace(b.Name)
-		phone := strings.TrimSpace(b.Phone)
-		if name == "" || phone == "" {
-			continue
-		}
-		key := normalizePhone(phone) + "|" + normalizeName(name)
-		if u, ok := regMap[key]; ok {
-			regAt := ""
-			if u.RegisteredAt != nil {
-				regAt = u.RegisteredAt.Format("2006-01-02")
-			}
-			matched = append(matched, gin.H{"name": u.Name, "phone": u.Phone, "registered_at": regAt})
-		}
+		rows = append(rows, services.SalesRow{Name: b.Name, Phone: b.Phone})
 	}
-	c.JSON(http.StatusOK, gin.H{"data": gin.H{"matched": matched, "count": len(matched)}})
+	result := services.ReconcileAffiliateSales(regUsers, rows)
+
+	matchRow := func(m services.ReconcileMatch) gin.H {
+		regAt := ""
+		if m.Registered.RegisteredAt != nil {
+			regAt = m.Registered.RegisteredAt.Format("2006-01-02")
+		}
+		return gin.H{
+			"name":          m.Registered.Name,
+			"phone":         m.Registered.Phone,
+			"registered_at": regAt,
+			"buyer_name":    m.Buyer.Name,
+			"buyer_phone":   m.Buyer.Phone,
+			"confidence":    m.Confidence,
+			"phone_match":   m.PhoneMatch,
+			"name_score":    m.NameScore,
+		}
+	}
+	matched := make([]gin.H, 0, len(result.Matched))
+	for _, m := range result.Matched {
+		matched = append(matched, matchRow(m))
+	}
+	probable := make([]gin.H, 0, len(result.Probable))
+	for _, m := range result.Probable {
+		probable = append(probable, matchRow(m))
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"matched":   matched,
+		"count":     len(matched),
+		"probable":  probable,
+		"unmatched": result.Unmatched,
+		"summary": gin.H{
+			"matched":   len(matched),
+			"probable":  len(probable),
+			"unmatched": len(result.Unmatched),
+		},
+	}})
 }
 
 // ConfirmAffiliateBuyersRequestBody for confirming matched buyers
@@ -2920,7 +3412,8 @@ func ConfirmAffiliateBuyers(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه وارد شده معتبر نیست. لطفاً دوباره تلاش کنید."})
 		return
 	}
-	if _, err = models.GetAffiliateByID(db, uint(id)); err != nil {
+	aff, err := models.GetAffiliateByID(db, uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "افیلیت مورد نظر یافت نشد. ممکن است حذف شده یا غیرفعال باشد."})
 		return
 	}
@@ -2957,11 +3450,27 @@ func ConfirmAffiliateBuyers(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "لیست خریداران خالی است"})
 		return
 	}
-	if err := models.CreateAffiliateBuyerBatch(db, uint(id), purchasedAt, rows); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره: " + err.Error()})
+	// Offline sales earn commission like redeemed licenses; buyers already
+	// credited online are skipped. Buyers and commissions are saved together.
+	credited, skipped, err := models.ConfirmAffiliateBuyers(db, aff, purchasedAt, rows)
+	if err != nil {
+		log.Printf("ConfirmAffiliateBuyers: failed to save buyers of affiliate %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ثبت خریداران و کمیسیون‌ها. هیچ خریداری ثبت نشد؛ لطفاً دوباره تلاش کنید."})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": fmt.Sprintf("%d خریدار ثبت شد", len(rows)), "count": len(rows)})
+	middleware.RecordAudit(c, "affiliate.buyers_confirm", "affiliate", id, nil, nil, map[string]interface{}{
+		"count":            len(rows),
+		"purchased_at":     purchasedAt.Format("2006-01-02"),
+		"credited":         credited,
+		"already_credited": skipped,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"message":          fmt.Sprintf("%d خریدار ثبت شد", len(rows)),
+		"count":            len(rows),
+		"credited":         credited,
+		"already_credited": skipped,
+	})
 }
 
 // GetAffiliateBuyers returns paginated buyers for an affiliate (admin)
@@ -3006,6 +3515,39 @@ func GetAffiliateBuyers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{"items": out, "total": total, "page": page, "per_page": perPage}})
 }
 
+// GetAffiliateLedger returns the ledger entries of an affiliate (admin)
+func GetAffiliateLedger(c *gin.Context) {
+	db := models.GetDB()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه وارد شده معتبر نیست. لطفاً دوباره تلاش کنید."})
+		return
+	}
+	if _, err = models.GetAffiliateByID(db, uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "افیلیت مورد نظر یافت نشد. ممکن است حذف شده یا غیرفعال باشد."})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = 50
+	}
+	balance, err := models.GetAffiliateLedgerBalance(db, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در بارگذاری اطلاعات پیش آمد. لطفاً صفحه را رفرش کنید."})
+		return
+	}
+	list, total, err := models.GetAffiliateLedger(db, uint(id), perPage, (page-1)*perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "مشکلی در بارگذاری اطلاعات پیش آمد. لطفاً صفحه را رفرش کنید."})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"balance": balance, "items": list, "total": total, "page": page, "per_page": perPage}})
+}
+
 // GetAffiliateWithdrawalRequests returns withdrawal requests for an affiliate (admin)
 func GetAffiliateWithdrawalRequests(c *gin.Context) {
 	db := models.GetDB()
@@ -3090,10 +3632,17 @@ func UpdateAffiliateWithdrawalStatus(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "وضعیت باید completed یا rejected باشد"})
 		return
 	}
+	before := auditSnapshot(&models.AffiliateWithdrawalRequest{}, uint(reqID))
 	if err := models.UpdateAffiliateWithdrawalStatus(db, uint(reqID), newStatus, body.AdminNotes); err != nil {
+		if errors.Is(err, models.ErrAffiliateWithdrawalClosed) {
+			c.JSON(http.StatusConflict, gin.H{"error": "وضعیت این درخواست قبلاً نهایی شده است"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره: " + err.Error()})
 		return
 	}
+	middleware.RecordAudit(c, "affiliate.payout_status", "affiliate_withdrawal_request", reqID, before,
+		auditSnapshot(&models.AffiliateWithdrawalRequest{}, uint(reqID)), map[string]interface{}{"affiliate_id": affID})
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "وضعیت به‌روزرسانی شد"})
 }
 
@@ -1,11 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"asl-market-backend/config"
+	"asl-market-backend/middleware"
 	"asl-market-backend/models"
 	"asl-market-backend/services"
 
@@ -104,8 +107,14 @@ func (wc *WithdrawalController) GetWithdrawalRequest(c *gin.Context) {
 		return
 	}
 
+	history, err := models.GetWithdrawalStatusHistory(wc.db, request.ID)
+	if err != nil {
+		log.Printf("Failed to load history of withdrawal request %d: %v", request.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"request": request,
+		"history": history,
 	})
 }
 
@@ -113,7 +122,7 @@ func (wc *WithdrawalController) GetWithdrawalRequest(c *gin.Context) {
 func (wc *WithdrawalController) GetUserWithdrawalStats(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
-	stats, err := models.GetWithdrawalStats(wc.db, &userID)
+	stats, err := models.GetWithdrawalStats(wc.db, &userID, c.Query("base_currency"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت آمار"})
 		return
@@ -197,7 +206,6 @@ func (wc *WithdrawalController) GetAllWithdrawalRequests(c *gin.Context) {
 
 // UpdateWithdrawalStatus updates the status of a withdrawal request (admin only)
 func (wc *WithdrawalController) UpdateWithdrawalStatus(c *gin.Context) {
-	adminID := c.GetUint("user_id")
 	requestIDStr := c.Param("id")
 
 	requestID, err := strconv.ParseUint(requestIDStr, 10, 32)
@@ -218,7 +226,7 @@ func (wc *WithdrawalController) UpdateWithdrawalStatus(c *gin.Context) {
 	}
 
 	// Check if the request exists
-	_, err = models.GetWithdrawalRequestByID(wc.db, uint(requestID))
+	currentRequest, err := models.GetWithdrawalRequestByID(wc.db, uint(requestID))
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "درخواست مورد نظر یافت نشد. ممکن است حذف شده باشد."})
@@ -228,14 +236,25 @@ func (wc *WithdrawalController) UpdateWithdrawalStatus(c *gin.Context) {
 		return
 	}
 
+	actorType, actorID, actorName := middleware.CurrentActor(c)
+	actor := models.WithdrawalActor{Type: actorType, ID: &actorID, Name: actorName}
+
+	before := auditSnapshot(&models.WithdrawalRequest{}, uint(requestID))
 	status := models.WithdrawalStatus(req.Status)
-	if err := models.UpdateWithdrawalStatus(wc.db, uint(requestID), status, &adminID, req.AdminNotes, req.DestinationAccount); err != nil {
+	updatedRequest, err := services.TransitionWithdrawal(wc.db, uint(requestID), status, actor, req.AdminNotes, req.DestinationAccount)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidWithdrawalTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": "تغییر وضعیت درخواست از «" + string(currentRequest.Status) + "» به «" + req.Status + "» مجاز نیست"})
+			return
+		}
+		if errors.Is(err, models.ErrExchangeRateNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "نرخ ارز " + currentRequest.Currency + " برای امروز ثبت نشده است. ابتدا نرخ روز را وارد کنید."})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بروزرسانی وضعیت"})
 		return
 	}
-
-	// Get updated request
-	updatedRequest, _ := models.GetWithdrawalRequestByID(wc.db, uint(requestID))
+	middleware.RecordAudit(c, "withdrawal.status", "withdrawal_request", requestID, before, auditSnapshot(&models.WithdrawalRequest{}, uint(requestID)), nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "وضعیت درخواست با موفقیت بروزرسانی شد",
@@ -245,7 +264,7 @@ func (wc *WithdrawalController) UpdateWithdrawalStatus(c *gin.Context) {
 
 // GetAllWithdrawalStats gets withdrawal statistics for all users (admin only)
 func (wc *WithdrawalController) GetAllWithdrawalStats(c *gin.Context) {
-	stats, err := models.GetWithdrawalStats(wc.db, nil)
+	stats, err := models.GetWithdrawalStats(wc.db, nil, c.Query("base_currency"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت آمار"})
 		return
@@ -310,9 +329,15 @@ func (wc *WithdrawalController) GetWithdrawalRequestAdmin(c *gin.Context) {
 		return
 	}
 
+	history, err := models.GetWithdrawalStatusHistory(wc.db, request.ID)
+	if err != nil {
+		log.Printf("Failed to load history of withdrawal request %d: %v", request.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"request": request,
+		"history": history,
 	})
 }
 
@@ -454,7 +479,8 @@ func (wc *WithdrawalController) UploadReceipt(c *gin.Context) {
 
 	// If the status was approved, change it to processing after receipt upload
 	if request.Status == models.WithdrawalStatusApproved {
-		if err := models.UpdateWithdrawalStatus(wc.db, uint(requestID), models.WithdrawalStatusProcessing, nil, "کاربر فیش را بارگذاری کرد", ""); err != nil {
+		requester := models.WithdrawalActor{Type: models.WithdrawalActorRequester, ID: &userID}
+		if _, err := services.TransitionWithdrawal(wc.db, uint(requestID), models.WithdrawalStatusProcessing, requester, "کاربر فیش را بارگذاری کرد", ""); err != nil {
 			// Log error but don't fail the request since receipt was uploaded successfully
 			log.Printf("Failed to update status to processing after receipt upload: %v", err)
 		}
@@ -479,3 +505,93 @@ func (wc *WithdrawalController) UploadReceipt(c *gin.Context) {
 		"receipt_path": receiptPath,
 	})
 }
+
+// GetExchangeRates lists the daily exchange rates, newest first, together
+// with the latest rate of every currency (admin only)
+func (wc *WithdrawalController) GetExchangeRates(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rates, err := models.GetExchangeRates(wc.db, c.Query("currency"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت نرخ‌های ارز"})
+		return
+	}
+	latest, err := models.GetLatestExchangeRates(wc.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت نرخ‌های ارز"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rates":  rates,
+		"latest": latest,
+	})
+}
+
+// SetExchangeRate enters the rial rate of a currency for a day, today when no
+// date is given. A second entry for the same day replaces the first (admin only).
+func (wc *WithdrawalController) SetExchangeRate(c *gin.Context) {
+	var req struct {
+		Currency string  `json:"currency" binding:"required"`
+		RateIRR  float64 `json:"rate_irr" binding:"required,gt=0"`
+		Date     string  `json:"date"` // YYYY-MM-DD
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ارز و نرخ ریالی (بزرگ‌تر از صفر) الزامی است"})
+		return
+	}
+
+	date := time.Now()
+	if req.Date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "تاریخ باید به صورت YYYY-MM-DD باشد"})
+			return
+		}
+		date = parsed
+	}
+
+	updatedByID := c.GetUint("user_id")
+	rate, err := models.SetExchangeRate(wc.db, req.Currency, date, req.RateIRR, &updatedByID)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidExchangeRate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ارز نامعتبر است"})
+			return
+		}
+		log.Printf("SetExchangeRate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ثبت نرخ ارز"})
+		return
+	}
+	middleware.RecordAudit(c, "exchange_rate.set", "exchange_rate", rate.ID, nil, rate, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "نرخ ارز ثبت شد",
+		"rate":    rate,
+	})
+}
+
+// DeleteExchangeRate removes a rate entered by mistake. Withdrawals already
+// settled with it keep their applied rate (admin only).
+func (wc *WithdrawalController) DeleteExchangeRate(c *gin.Context) {
+	rateID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه نرخ ارز نامعتبر است"})
+		return
+	}
+
+	before := auditSnapshot(&models.ExchangeRate{}, uint(rateID))
+	if err := models.DeleteExchangeRate(wc.db, uint(rateID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "نرخ ارز یافت نشد"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در حذف نرخ ارز"})
+		return
+	}
+	middleware.RecordAudit(c, "exchange_rate.delete", "exchange_rate", rateID, before, nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "نرخ ارز حذف شد"})
+}
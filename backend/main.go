@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"asl-market-backend/config"
+	"asl-market-backend/middleware"
 	"asl-market-backend/models"
 	"asl-market-backend/routes"
 	"asl-market-backend/services"
@@ -26,11 +27,13 @@ func main() {
 	if !config.AppConfig.Environment.IsInIran {
 		telegramService = services.GetTelegramService()
 		log.Printf("Telegram bot initialized for admin IDs: %v", services.ADMIN_IDS)
-		services.StartBackupScheduler(telegramService)
 	} else {
 		log.Println("Running in Iran environment - Telegram bot is disabled")
 	}
 
+	// Start nightly encrypted backups (local, S3 and/or Telegram targets)
+	services.StartBackupScheduler(telegramService)
+
 	// Initialize SMS service (با username/password برای لاگین خودکار Edge)
 	if config.AppConfig.SMS.APIKey != "" {
 		services.InitSMSService(
@@ -49,8 +52,9 @@ func main() {
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
-	// Create Gin router
-	router := gin.Default()
+	// Create Gin router; the access log redacts credentials from query strings
+	router := gin.New()
+	router.Use(middleware.RedactedLogger(), gin.Recovery())
 
 	// Setup CORS — فقط یک لایه (همین) تا هدر Access-Control-Allow-Origin فقط یک بار ست شود.
 	// اگر nginx هم CORS ست کند، مقدار دو بار می‌آید و مرورگر خطای "multiple values" می‌دهد.
@@ -91,6 +95,15 @@ func main() {
 		}
 	}()
 
+	// Start scheduled notification sender in background
+	services.StartNotificationScheduler()
+
+	// Start notification outbox workers (in-app, push and SMS deliveries) in background
+	services.StartNotificationOutbox()
+
+	// Start license expiry reminders and grace period handling in background
+	services.StartLicenseLifecycleScheduler()
+
 	// Setup routes
 	routes.SetupRoutes(router, telegramService)
 
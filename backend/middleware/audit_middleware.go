@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"asl-market-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditActor builds the actor part of an audit event from the auth context
+func auditActor(c *gin.Context) models.AuditEvent {
+	event := models.AuditEvent{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+
+	if webAdmin, ok := c.Get("web_admin"); ok {
+		if admin, ok := webAdmin.(models.WebAdmin); ok && c.GetBool("is_web_admin") {
+			id := admin.ID
+			event.ActorType = models.AuditActorWebAdmin
+			event.ActorID = &id
+			event.ActorName = admin.Username
+			return event
+		}
+	}
+
+	if userValue, ok := c.Get("user"); ok {
+		if user, ok := userValue.(models.User); ok {
+			id := user.ID
+			event.ActorType = models.AuditActorUser
+			event.ActorID = &id
+			event.ActorName = user.Email
+			if event.ActorName == "" {
+				event.ActorName = user.Phone
+			}
+		}
+	}
+	return event
+}
+
+// CurrentActor returns the type (AuditActor*), ID and display name of the
+// admin making the request
+func CurrentActor(c *gin.Context) (string, uint, string) {
+	event := auditActor(c)
+	if event.ActorID == nil {
+		return event.ActorType, 0, event.ActorName
+	}
+	return event.ActorType, *event.ActorID, event.ActorName
+}
+
+// RecordAudit stores an audit event for the current admin request. before and
+// after are snapshots of the target (either may be nil); only the fields that
+// differ are kept. Failures are logged and never fail the request.
+func RecordAudit(c *gin.Context, action, targetType string, targetID interface{}, before, after interface{}, metadata map[string]interface{}) {
+	event := auditActor(c)
+	if event.ActorType == "" {
+		log.Printf("RecordAudit: no actor in context for %s", action)
+		return
+	}
+	event.Action = action
+	event.TargetType = targetType
+	event.TargetID = models.AuditTargetID(targetID)
+
+	if err := models.RecordAuditEvent(models.GetDB(), event, before, after, metadata); err != nil {
+		log.Printf("RecordAudit: failed to record %s on %s %v: %v", action, targetType, targetID, err)
+		return
+	}
+	c.Set("audit_recorded", true)
+}
+
+// AuditAdminActions records every successful state-changing /admin request
+// that its handler did not already record with RecordAudit. Must run after
+// AuthMiddleware.
+func AuditAdminActions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		route := strings.TrimPrefix(c.FullPath(), "/api/v1")
+		if !strings.HasPrefix(route, "/admin/") || c.Writer.Status() >= http.StatusBadRequest || c.GetBool("audit_recorded") {
+			return
+		}
+
+		// e.g. "POST /admin/suppliers/:id/feature" targets "suppliers"
+		targetType := strings.SplitN(strings.TrimPrefix(route, "/admin/"), "/", 2)[0]
+		var targetID interface{}
+		if len(c.Params) > 0 {
+			targetID = c.Params[0].Value
+		}
+
+		metadata := map[string]interface{}{"status": c.Writer.Status()}
+		if c.Request.URL.RawQuery != "" {
+			metadata["query"] = c.Request.URL.RawQuery
+		}
+		RecordAudit(c, c.Request.Method+" "+route, targetType, targetID, nil, nil, metadata)
+	}
+}
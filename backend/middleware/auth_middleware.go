@@ -19,6 +19,66 @@ func DatabaseMiddleware() gin.HandlerFunc {
 	}
 }
 
+// authenticate validates an access token and loads its subject into the
+// context. Users and web admins are looked up only in their own table, and the
+// token's session must still be active.
+func authenticate(c *gin.Context, token string) error {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return err
+	}
+
+	db := models.GetDB()
+	if _, err := models.GetActiveAuthSession(db, claims.SessionID, claims.SubjectType, claims.UserID); err != nil {
+		return err
+	}
+
+	switch claims.SubjectType {
+	case utils.SubjectWebAdmin:
+		var webAdmin models.WebAdmin
+		if err := db.Where("id = ? AND is_active = ? AND deleted_at IS NULL", claims.UserID, true).First(&webAdmin).Error; err != nil {
+			return err
+		}
+		// Set admin information in context
+		c.Set("user_id", claims.UserID)
+		c.Set("user_email", claims.Email)
+		c.Set("is_web_admin", true)
+		c.Set("web_admin", webAdmin)
+		c.Set("user_role", webAdmin.Role) // super_admin, admin, moderator
+
+		// Also set a user object for backward compatibility (if needed)
+		var user models.User
+		user.ID = uint(claims.UserID)
+		user.Email = webAdmin.Email
+		user.IsAdmin = true
+		c.Set("user", user)
+
+	case utils.SubjectUser:
+		var user models.User
+		if err := db.First(&user, claims.UserID).Error; err != nil {
+			return err
+		}
+		if !user.IsActive {
+			return models.ErrSessionRevoked
+		}
+		c.Set("user_id", claims.UserID)
+		c.Set("user_email", claims.Email)
+		c.Set("user", user)
+		c.Set("is_web_admin", false)
+
+		// Set user_role based on IsAdmin flag
+		if user.IsAdmin {
+			c.Set("user_role", "admin")
+		} else {
+			c.Set("user_role", "user")
+		}
+	}
+
+	c.Set("subject_type", claims.SubjectType)
+	c.Set("session_id", claims.SessionID)
+	return nil
+}
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -49,9 +109,7 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Validate token
-		claims, err := utils.ValidateToken(token)
-		if err != nil {
+		if err := authenticate(c, token); err != nil {
 			log.Printf("Token validation failed: %v", err)
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Invalid or expired token",
@@ -60,50 +118,6 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Get user from database - try WebAdmin first, then User
-		db := models.GetDB()
-
-		// First, try to find in WebAdmin table (for admin panel admins)
-		var webAdmin models.WebAdmin
-		if err := db.Where("id = ? AND is_active = ? AND deleted_at IS NULL", claims.UserID, true).First(&webAdmin).Error; err == nil {
-			// Set admin information in context
-			c.Set("user_id", claims.UserID)
-			c.Set("user_email", claims.Email)
-			c.Set("is_web_admin", true)
-			c.Set("web_admin", webAdmin)
-			c.Set("user_role", webAdmin.Role) // super_admin, admin, moderator
-
-			// Also set a user object for backward compatibility (if needed)
-			var user models.User
-			user.ID = uint(claims.UserID)
-			user.Email = webAdmin.Email
-			user.IsAdmin = true
-			c.Set("user", user)
-		} else {
-			// If not found in WebAdmin, try User table
-			var user models.User
-			if err := db.First(&user, claims.UserID).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"error": "User not found",
-				})
-				c.Abort()
-				return
-			}
-
-			// Set user information in context
-			c.Set("user_id", claims.UserID)
-			c.Set("user_email", claims.Email)
-			c.Set("user", user)
-			c.Set("is_web_admin", false)
-
-			// Set user_role based on IsAdmin flag
-			if user.IsAdmin {
-				c.Set("user_role", "admin")
-			} else {
-				c.Set("user_role", "user")
-			}
-		}
-
 		c.Next()
 	}
 }
@@ -120,45 +134,8 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			token := strings.TrimPrefix(authHeader, "Bearer ")
 			if token != "" {
-				claims, err := utils.ValidateToken(token)
-				if err == nil {
-					// Get user from database - try WebAdmin first, then User
-					db := models.GetDB()
-
-					// First, try to find in WebAdmin table (for admin panel admins)
-					var webAdmin models.WebAdmin
-					if err := db.Where("id = ? AND is_active = ? AND deleted_at IS NULL", claims.UserID, true).First(&webAdmin).Error; err == nil {
-						// Set admin information in context
-						c.Set("user_id", claims.UserID)
-						c.Set("user_email", claims.Email)
-						c.Set("is_web_admin", true)
-						c.Set("web_admin", webAdmin)
-						c.Set("user_role", webAdmin.Role) // super_admin, admin, moderator
-
-						// Also set a user object for backward compatibility (if needed)
-						var user models.User
-						user.ID = uint(claims.UserID)
-						user.Email = webAdmin.Email
-						user.IsAdmin = true
-						c.Set("user", user)
-					} else {
-						// If not found in WebAdmin, try User table
-						var user models.User
-						if err := db.First(&user, claims.UserID).Error; err == nil {
-							c.Set("user_id", claims.UserID)
-							c.Set("user_email", claims.Email)
-							c.Set("user", user)
-							c.Set("is_web_admin", false)
-
-							// Set user_role based on IsAdmin flag
-							if user.IsAdmin {
-								c.Set("user_role", "admin")
-							} else {
-								c.Set("user_role", "user")
-							}
-						}
-					}
-				}
+				// An invalid token just leaves the request anonymous
+				_ = authenticate(c, token)
 			}
 		}
 
@@ -194,6 +171,12 @@ func AffiliateAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 		db := models.GetDB()
+		if _, err := models.GetActiveAuthSession(db, claims.SessionID, utils.SubjectAffiliate, claims.AffiliateID); err != nil {
+			log.Printf("Affiliate session check failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
 		var aff models.Affiliate
 		if err := db.First(&aff, claims.AffiliateID).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Affiliate not found"})
@@ -207,6 +190,8 @@ func AffiliateAuthMiddleware() gin.HandlerFunc {
 		}
 		c.Set("affiliate_id", aff.ID)
 		c.Set("affiliate", aff)
+		c.Set("subject_type", utils.SubjectAffiliate)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	}
 }
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"asl-market-backend/models"
 
@@ -24,8 +25,9 @@ func LicenseMiddleware() gin.HandlerFunc {
 
 		userIDUint := userID.(uint)
 
-		// Check if user has valid license
-		hasLicense, err := models.CheckUserLicense(models.GetDB(), userIDUint)
+		// Check if user has valid license; an expired license keeps working
+		// during the grace period
+		access, err := models.GetUserLicenseAccess(models.GetDB(), userIDUint, models.LicenseGracePeriod())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "خطا در بررسی وضعیت لایسنس",
@@ -34,12 +36,20 @@ func LicenseMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		hasLicense := access.HasLicense
+
 		// Create response with user's license status
 		licenseStatus := gin.H{
 			"needs_license": !hasLicense,
 			"has_license":   hasLicense,
 			"is_approved":   hasLicense, // Auto-approved now
 			"is_active":     hasLicense,
+			"in_grace":      access.InGrace,
+			"expires_at":    access.ExpiresAt,
+		}
+		if access.InGrace {
+			licenseStatus["grace_ends_at"] = access.GraceEndsAt
+			c.Header("X-License-Grace-Ends-At", access.GraceEndsAt.Format(time.RFC3339))
 		}
 
 		// Check if this is an AI endpoint
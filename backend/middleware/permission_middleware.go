@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"asl-market-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminRoleAndGrants returns the admin role of the authenticated caller and
+// any permissions granted to them individually. Legacy users flagged IsAdmin
+// keep the full access they had before roles existed and count as super admins,
+// matching the role AdminLogin reports for them.
+func adminRoleAndGrants(c *gin.Context) (string, []string, bool) {
+	if isWebAdmin, _ := c.Get("is_web_admin"); isWebAdmin == true {
+		if webAdmin, ok := c.Get("web_admin"); ok {
+			if admin, ok := webAdmin.(models.WebAdmin); ok {
+				return admin.Role, admin.PermissionList(), true
+			}
+		}
+		return "", nil, false
+	}
+
+	if userValue, ok := c.Get("user"); ok {
+		if user, ok := userValue.(models.User); ok && user.IsAdmin {
+			return models.AdminRoleSuperAdmin, nil, true
+		}
+	}
+	return "", nil, false
+}
+
+// RequirePermission allows the request only if the authenticated admin holds
+// every given permission. Must run after AuthMiddleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, grants, ok := adminRoleAndGrants(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "دسترسی غیرمجاز - فقط ادمین"})
+			c.Abort()
+			return
+		}
+
+		granted, err := models.GetEffectivePermissions(models.GetDB(), role, grants)
+		if err != nil {
+			log.Printf("RequirePermission: failed to load permissions for role %s: %v", role, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی دسترسی"})
+			c.Abort()
+			return
+		}
+
+		if !models.HasAllPermissions(granted, permissions...) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                "شما به این بخش دسترسی ندارید",
+				"required_permissions": permissions,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("admin_role", role)
+		c.Set("admin_permissions", granted)
+		c.Next()
+	}
+}
+
+// RequireSuperAdmin allows the request only for super admins
+func RequireSuperAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _, ok := adminRoleAndGrants(c)
+		if !ok || role != models.AdminRoleSuperAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "این بخش فقط برای مدیر ارشد در دسترس است"})
+			c.Abort()
+			return
+		}
+		c.Set("admin_role", role)
+		c.Next()
+	}
+}
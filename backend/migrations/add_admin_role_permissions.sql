@@ -0,0 +1,11 @@
+-- Migration: Add admin_role_permissions table for the editable role → permission map
+-- نقشی که ردیفی در این جدول ندارد از دسترسی‌های پیش‌فرض استفاده می‌کند؛ مدیر ارشد همیشه همه دسترسی‌ها را دارد
+
+CREATE TABLE IF NOT EXISTS `admin_role_permissions` (
+  `role` varchar(50) NOT NULL,
+  `permissions` text,
+  `updated_by_id` bigint unsigned DEFAULT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`role`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
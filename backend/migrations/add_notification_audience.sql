@@ -0,0 +1,34 @@
+-- Migration: Add audience targeting, scheduling and delivery counts to notifications
+-- مخاطبان بخش‌بندی‌شده، زمان‌بندی ارسال و آمار ارسال push/پیامک
+
+ALTER TABLE notifications
+ADD COLUMN IF NOT EXISTS audience VARCHAR(20) DEFAULT 'all',
+ADD COLUMN IF NOT EXISTS audience_license_types VARCHAR(100) DEFAULT NULL,
+ADD COLUMN IF NOT EXISTS audience_roles VARCHAR(100) DEFAULT NULL,
+ADD COLUMN IF NOT EXISTS audience_cities TEXT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci DEFAULT NULL,
+ADD COLUMN IF NOT EXISTS audience_phones MEDIUMTEXT DEFAULT NULL,
+ADD COLUMN IF NOT EXISTS audience_license_expires_from DATETIME NULL,
+ADD COLUMN IF NOT EXISTS audience_license_expires_to DATETIME NULL,
+ADD COLUMN IF NOT EXISTS status VARCHAR(20) DEFAULT 'sent',
+ADD COLUMN IF NOT EXISTS scheduled_at DATETIME NULL,
+ADD COLUMN IF NOT EXISTS sent_at DATETIME NULL,
+ADD COLUMN IF NOT EXISTS send_push BOOLEAN DEFAULT FALSE,
+ADD COLUMN IF NOT EXISTS send_sms BOOLEAN DEFAULT FALSE,
+ADD COLUMN IF NOT EXISTS recipient_count INT DEFAULT 0,
+ADD COLUMN IF NOT EXISTS push_sent_count INT DEFAULT 0,
+ADD COLUMN IF NOT EXISTS push_failed_count INT DEFAULT 0,
+ADD COLUMN IF NOT EXISTS sms_sent_count INT DEFAULT 0,
+ADD COLUMN IF NOT EXISTS sms_failed_count INT DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_status ON notifications(status);
+CREATE INDEX IF NOT EXISTS idx_notifications_scheduled_at ON notifications(scheduled_at);
+
+CREATE TABLE IF NOT EXISTS `notification_recipients` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `notification_id` bigint unsigned NOT NULL,
+  `user_id` bigint unsigned NOT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_notification_recipient` (`notification_id`, `user_id`),
+  KEY `idx_notification_recipients_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
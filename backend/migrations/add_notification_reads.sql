@@ -0,0 +1,15 @@
+-- Migration: Add notification_reads table for per-recipient read state
+-- اعلان‌های همگانی (user_id = NULL) یک ردیف مشترک دارند؛ وضعیت خواندن/بستن هر کاربر اینجا ذخیره می‌شود
+
+CREATE TABLE IF NOT EXISTS `notification_reads` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL,
+  `notification_id` bigint unsigned NOT NULL,
+  `read_at` datetime DEFAULT NULL,
+  `dismissed_at` datetime DEFAULT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_notification_read_user_notification` (`user_id`, `notification_id`),
+  KEY `idx_notification_reads_notification_id` (`notification_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,294 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Web admin roles
+const (
+	AdminRoleSuperAdmin = "super_admin"
+	AdminRoleAdmin      = "admin"
+	AdminRoleModerator  = "moderator"
+)
+
+// Admin permissions enforced per route on the admin API
+const (
+	PermissionDashboardView        = "dashboard:view"
+	PermissionUsersView            = "users:view"
+	PermissionUsersManage          = "users:manage"
+	PermissionUsersDelete          = "users:delete"
+	PermissionLicensesView         = "licenses:view"
+	PermissionLicensesGenerate     = "licenses:generate"
+	PermissionLicensesRevoke       = "licenses:revoke"
+	PermissionUpgradesManage       = "upgrades:manage"
+	PermissionSuppliersView        = "suppliers:view"
+	PermissionSuppliersManage      = "suppliers:manage"
+	PermissionVisitorsView         = "visitors:view"
+	PermissionVisitorsManage       = "visitors:manage"
+	PermissionProductsManage       = "products:manage"
+	PermissionContentManage        = "content:manage"
+	PermissionWithdrawalsView      = "withdrawals:view"
+	PermissionWithdrawalsManage    = "withdrawals:manage"
+	PermissionWithdrawalsApprove   = "withdrawals:approve"
+	PermissionNotificationsView    = "notifications:view"
+	PermissionNotificationsSend    = "notifications:send"
+	PermissionSupportManage        = "support:manage"
+	PermissionAffiliatesView       = "affiliates:view"
+	PermissionAffiliatesManage     = "affiliates:manage"
+	PermissionAffiliatesPayout     = "affiliates:payout"
+	PermissionExportsDownload      = "exports:download"
+	PermissionMatchingManage       = "matching:manage"
+	PermissionTelegramAdminsManage = "telegram_admins:manage"
+	PermissionAdminsManage         = "admins:manage"
+	PermissionSystemMonitor        = "system:monitor"
+	PermissionAuditView            = "audit:view"
+	PermissionLicensePlansManage   = "license_plans:manage"
+)
+
+// AdminPermissionInfo describes a permission for the admin panel
+type AdminPermissionInfo struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+}
+
+// AdminPermissions is the catalogue of every permission, in display order
+var AdminPermissions = []AdminPermissionInfo{
+	{PermissionDashboardView, "مشاهده داشبورد و آمار"},
+	{PermissionUsersView, "مشاهده کاربران"},
+	{PermissionUsersManage, "ایجاد، ویرایش و ورود گروهی کاربران"},
+	{PermissionUsersDelete, "حذف کاربران"},
+	{PermissionLicensesView, "مشاهده لایسنس‌ها"},
+	{PermissionLicensesGenerate, "تولید لایسنس"},
+	{PermissionLicensesRevoke, "باطل کردن دسته‌های لایسنس"},
+	{PermissionUpgradesManage, "بررسی درخواست‌های ارتقا"},
+	{PermissionSuppliersView, "مشاهده تأمین‌کنندگان"},
+	{PermissionSuppliersManage, "مدیریت و تأیید تأمین‌کنندگان"},
+	{PermissionVisitorsView, "مشاهده ویزیتورها"},
+	{PermissionVisitorsManage, "مدیریت و تأیید ویزیتورها"},
+	{PermissionProductsManage, "مدیریت محصولات تحقیقی و موجود"},
+	{PermissionContentManage, "مدیریت اسلایدرها، پاپ‌آپ‌ها و آموزش‌ها"},
+	{PermissionWithdrawalsView, "مشاهده درخواست‌های برداشت"},
+	{PermissionWithdrawalsManage, "ثبت، ویرایش و حذف درخواست‌های برداشت"},
+	{PermissionWithdrawalsApprove, "تأیید و تغییر وضعیت برداشت"},
+	{PermissionNotificationsView, "مشاهده اعلان‌ها و آمار آن‌ها"},
+	{PermissionNotificationsSend, "ارسال و ویرایش اعلان‌ها"},
+	{PermissionSupportManage, "پاسخ به تیکت‌های پشتیبانی"},
+	{PermissionAffiliatesView, "مشاهده همکاران فروش"},
+	{PermissionAffiliatesManage, "مدیریت همکاران فروش و تنظیمات"},
+	{PermissionAffiliatesPayout, "تأیید پرداخت همکاران فروش"},
+	{PermissionExportsDownload, "خروجی اکسل"},
+	{PermissionMatchingManage, "مدیریت درخواست‌های مچینگ و پروژه‌های ویزیتور"},
+	{PermissionTelegramAdminsManage, "مدیریت ادمین‌های تلگرام"},
+	{PermissionAdminsManage, "مدیریت مدیران پنل"},
+	{PermissionSystemMonitor, "پایش سرویس‌های سیستم"},
+	{PermissionAuditView, "مشاهده و خروجی گزارش عملیات مدیران"},
+	{PermissionLicensePlansManage, "ویرایش سقف‌های روزانه پلن‌های لایسنس"},
+}
+
+// DefaultRolePermissions is used for a role until a super admin edits it.
+// Super admins always hold every permission and are not listed here.
+var DefaultRolePermissions = map[string][]string{
+	AdminRoleAdmin: {
+		PermissionDashboardView, PermissionUsersView, PermissionUsersManage, PermissionUsersDelete,
+		PermissionLicensesView, PermissionLicensesGenerate, PermissionLicensesRevoke, PermissionUpgradesManage,
+		PermissionSuppliersView, PermissionSuppliersManage, PermissionVisitorsView, PermissionVisitorsManage,
+		PermissionProductsManage, PermissionContentManage, PermissionWithdrawalsView,
+		PermissionWithdrawalsManage, PermissionWithdrawalsApprove, PermissionNotificationsView,
+		PermissionNotificationsSend, PermissionSupportManage, PermissionAffiliatesView,
+		PermissionAffiliatesManage, PermissionAffiliatesPayout, PermissionExportsDownload,
+		PermissionMatchingManage, PermissionTelegramAdminsManage, PermissionSystemMonitor,
+		PermissionLicensePlansManage,
+	},
+	AdminRoleModerator: {
+		PermissionDashboardView, PermissionUsersView, PermissionSuppliersView, PermissionSuppliersManage,
+		PermissionVisitorsView, PermissionVisitorsManage, PermissionProductsManage, PermissionContentManage,
+		PermissionNotificationsView, PermissionSupportManage, PermissionMatchingManage,
+	},
+}
+
+// AdminRolePermissions stores the edited permission set of a role. A role
+// without a row uses DefaultRolePermissions.
+type AdminRolePermissions struct {
+	Role        string    `json:"role" gorm:"primaryKey;size:50"`
+	Permissions string    `json:"-" gorm:"type:text"` // comma-separated permission keys
+	UpdatedByID *uint     `json:"updated_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for AdminRolePermissions
+func (AdminRolePermissions) TableName() string {
+	return "admin_role_permissions"
+}
+
+// AdminPermissionError is returned for invalid role or permission edits
+type AdminPermissionError struct {
+	Message string
+}
+
+func (e *AdminPermissionError) Error() string {
+	return e.Message
+}
+
+// IsValidAdminRole reports whether role is a known web admin role
+func IsValidAdminRole(role string) bool {
+	return role == AdminRoleSuperAdmin || role == AdminRoleAdmin || role == AdminRoleModerator
+}
+
+// IsValidAdminPermission reports whether key is in the permission catalogue
+func IsValidAdminPermission(key string) bool {
+	for _, p := range AdminPermissions {
+		if p.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func allAdminPermissionKeys() []string {
+	keys := make([]string, len(AdminPermissions))
+	for i, p := range AdminPermissions {
+		keys[i] = p.Key
+	}
+	return keys
+}
+
+func splitPermissions(value string) []string {
+	permissions := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			permissions = append(permissions, part)
+		}
+	}
+	return permissions
+}
+
+// GetRolePermissions returns the permissions currently granted to a role
+func GetRolePermissions(db *gorm.DB, role string) ([]string, error) {
+	if role == AdminRoleSuperAdmin {
+		return allAdminPermissionKeys(), nil
+	}
+
+	var row AdminRolePermissions
+	err := db.Where("role = ?", role).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return append([]string{}, DefaultRolePermissions[role]...), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitPermissions(row.Permissions), nil
+}
+
+// GetRolePermissionMap returns the permissions of every web admin role
+func GetRolePermissionMap(db *gorm.DB) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, role := range []string{AdminRoleSuperAdmin, AdminRoleAdmin, AdminRoleModerator} {
+		permissions, err := GetRolePermissions(db, role)
+		if err != nil {
+			return nil, err
+		}
+		result[role] = permissions
+	}
+	return result, nil
+}
+
+// SetRolePermissions replaces the permission set of a role. The super admin
+// role always holds every permission and cannot be edited.
+func SetRolePermissions(db *gorm.DB, role string, permissions []string, updatedByID uint) ([]string, error) {
+	if !IsValidAdminRole(role) {
+		return nil, &AdminPermissionError{Message: "نقش نامعتبر است"}
+	}
+	if role == AdminRoleSuperAdmin {
+		return nil, &AdminPermissionError{Message: "دسترسی‌های مدیر ارشد قابل تغییر نیست"}
+	}
+
+	seen := make(map[string]bool, len(permissions))
+	cleaned := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		p = strings.TrimSpace(p)
+		if !IsValidAdminPermission(p) {
+			return nil, &AdminPermissionError{Message: "دسترسی نامعتبر: " + p}
+		}
+		if !seen[p] {
+			seen[p] = true
+			cleaned = append(cleaned, p)
+		}
+	}
+	sort.Strings(cleaned)
+
+	row := AdminRolePermissions{
+		Role:        role,
+		Permissions: strings.Join(cleaned, ","),
+		UpdatedByID: &updatedByID,
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "role"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permissions", "updated_by_id", "updated_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	return cleaned, nil
+}
+
+// ResetRolePermissions drops a role's edits so it falls back to the defaults
+func ResetRolePermissions(db *gorm.DB, role string) error {
+	return db.Where("role = ?", role).Delete(&AdminRolePermissions{}).Error
+}
+
+// PermissionList parses the admin's own extra permissions, stored either as a
+// JSON array or a comma-separated list
+func (w *WebAdmin) PermissionList() []string {
+	if w.Permissions == "" {
+		return []string{}
+	}
+	if w.Permissions[0] == '[' {
+		permissions := []string{}
+		_ = json.Unmarshal([]byte(w.Permissions), &permissions)
+		return permissions
+	}
+	return splitPermissions(w.Permissions)
+}
+
+// GetEffectivePermissions returns the role permissions of an admin together
+// with any permissions granted to the admin individually
+func GetEffectivePermissions(db *gorm.DB, role string, extra []string) ([]string, error) {
+	permissions, err := GetRolePermissions(db, role)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		seen[p] = true
+	}
+	for _, p := range extra {
+		if IsValidAdminPermission(p) && !seen[p] {
+			seen[p] = true
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, nil
+}
+
+// HasAllPermissions reports whether granted contains every required permission
+func HasAllPermissions(granted []string, required ...string) bool {
+	set := make(map[string]bool, len(granted))
+	for _, p := range granted {
+		set[p] = true
+	}
+	for _, p := range required {
+		if !set[p] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestRolePermissionsDefaultAndEdit(t *testing.T) {
+	db := testutil.NewTestDB(t)
+
+	perms, err := models.GetRolePermissions(db, models.AdminRoleModerator)
+	if err != nil {
+		t.Fatalf("get moderator permissions: %v", err)
+	}
+	if models.HasAllPermissions(perms, models.PermissionUsersDelete) {
+		t.Fatalf("moderator should not delete users by default")
+	}
+
+	updated, err := models.SetRolePermissions(db, models.AdminRoleModerator,
+		[]string{models.PermissionUsersDelete, models.PermissionUsersView, models.PermissionUsersView}, 1)
+	if err != nil {
+		t.Fatalf("set moderator permissions: %v", err)
+	}
+	if len(updated) != 2 {
+		t.Fatalf("expected duplicates to be dropped, got %v", updated)
+	}
+
+	perms, _ = models.GetRolePermissions(db, models.AdminRoleModerator)
+	if !models.HasAllPermissions(perms, models.PermissionUsersDelete) || models.HasAllPermissions(perms, models.PermissionContentManage) {
+		t.Fatalf("edited permissions not applied: %v", perms)
+	}
+
+	// An empty set is stored as-is rather than falling back to the defaults
+	if _, err := models.SetRolePermissions(db, models.AdminRoleModerator, []string{}, 1); err != nil {
+		t.Fatalf("clear moderator permissions: %v", err)
+	}
+	perms, _ = models.GetRolePermissions(db, models.AdminRoleModerator)
+	if len(perms) != 0 {
+		t.Fatalf("expected no permissions, got %v", perms)
+	}
+
+	if err := models.ResetRolePermissions(db, models.AdminRoleModerator); err != nil {
+		t.Fatalf("reset moderator permissions: %v", err)
+	}
+	perms, _ = models.GetRolePermissions(db, models.AdminRoleModerator)
+	if len(perms) != len(models.DefaultRolePermissions[models.AdminRoleModerator]) {
+		t.Fatalf("reset should restore defaults, got %v", perms)
+	}
+}
+
+func TestSetRolePermissionsRejectsInvalidInput(t *testing.T) {
+	db := testutil.NewTestDB(t)
+
+	cases := []struct {
+		role        string
+		permissions []string
+	}{
+		{models.AdminRoleSuperAdmin, []string{models.PermissionUsersView}},
+		{"owner", []string{models.PermissionUsersView}},
+		{models.AdminRoleAdmin, []string{"users:everything"}},
+	}
+	for _, tc := range cases {
+		_, err := models.SetRolePermissions(db, tc.role, tc.permissions, 1)
+		var permErr *models.AdminPermissionError
+		if !errors.As(err, &permErr) {
+			t.Errorf("SetRolePermissions(%q, %v): expected AdminPermissionError, got %v", tc.role, tc.permissions, err)
+		}
+	}
+}
+
+func TestEffectivePermissionsIncludeAdminGrants(t *testing.T) {
+	db := testutil.NewTestDB(t)
+
+	admin := models.WebAdmin{Role: models.AdminRoleModerator, Permissions: `["licenses:generate","bogus"]`}
+	perms, err := models.GetEffectivePermissions(db, admin.Role, admin.PermissionList())
+	if err != nil {
+		t.Fatalf("effective permissions: %v", err)
+	}
+	if !models.HasAllPermissions(perms, models.PermissionLicensesGenerate, models.PermissionSupportManage) {
+		t.Fatalf("expected role and individual permissions, got %v", perms)
+	}
+	if models.HasAllPermissions(perms, "bogus") {
+		t.Fatalf("unknown permissions must be ignored")
+	}
+
+	superPerms, _ := models.GetRolePermissions(db, models.AdminRoleSuperAdmin)
+	if len(superPerms) != len(models.AdminPermissions) {
+		t.Fatalf("super admin should hold every permission, got %d", len(superPerms))
+	}
+}
@@ -0,0 +1,360 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"asl-market-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Affiliate ledger accounts. An affiliate's balance is the credit balance of
+// its payable account; every posting moves the same amount out of one account
+// and into another so the ledger always balances.
+const (
+	AffiliateAccountPayable       = "affiliate_payable"        // owed to the affiliate (Balance)
+	AffiliateAccountPayoutPending = "affiliate_payout_pending" // held for a withdrawal request
+	AffiliateAccountCommission    = "commission_expense"       // commissions earned on sales
+	AffiliateAccountCash          = "cash"                     // paid out to the affiliate
+	AffiliateAccountAdjustments   = "adjustments"              // manual corrections and opening balances
+)
+
+// Affiliate ledger entry kinds
+const (
+	AffiliateLedgerCommission        = "commission"         // license redeemed by a referred user
+	AffiliateLedgerOfflineSale       = "offline_sale"       // sale confirmed from a sales list
+	AffiliateLedgerWithdrawalHold    = "withdrawal_hold"    // withdrawal requested
+	AffiliateLedgerWithdrawalRelease = "withdrawal_release" // withdrawal rejected, amount returned
+	AffiliateLedgerPayout            = "payout"             // withdrawal paid
+	AffiliateLedgerAdjustment        = "adjustment"
+)
+
+// ErrInsufficientAffiliateBalance is returned when a withdrawal exceeds the balance
+var ErrInsufficientAffiliateBalance = errors.New("موجودی کافی نیست")
+
+// AffiliateLedgerEntry is one line of a ledger transaction. Each transaction
+// has a debit line and a credit line of the same amount; TransactionID is
+// unique per posting so the same sale or payout is never posted twice.
+// Amounts are in toman.
+type AffiliateLedgerEntry struct {
+	ID                  uint      `json:"id" gorm:"primaryKey"`
+	TransactionID       string    `json:"transaction_id" gorm:"size:64;not null;uniqueIndex:idx_affiliate_ledger_line"`
+	Account             string    `json:"account" gorm:"size:40;not null;uniqueIndex:idx_affiliate_ledger_line;index"`
+	AffiliateID         uint      `json:"affiliate_id" gorm:"not null;index"`
+	Kind                string    `json:"kind" gorm:"size:30;not null"`
+	Debit               float64   `json:"debit" gorm:"type:decimal(14,2);default:0"`
+	Credit              float64   `json:"credit" gorm:"type:decimal(14,2);default:0"`
+	LicenseID           *uint     `json:"license_id" gorm:"index"`
+	AffiliateBuyerID    *uint     `json:"affiliate_buyer_id"`
+	WithdrawalRequestID *uint     `json:"withdrawal_request_id"`
+	Description         string    `json:"description" gorm:"size:255"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for AffiliateLedgerEntry
+func (AffiliateLedgerEntry) TableName() string {
+	return "affiliate_ledger_entries"
+}
+
+// AffiliatePosting describes a ledger transaction moving Amount from
+// CreditAccount to DebitAccount
+type AffiliatePosting struct {
+	TransactionID       string
+	AffiliateID         uint
+	Kind                string
+	Amount              float64
+	DebitAccount        string
+	CreditAccount       string
+	LicenseID           *uint
+	AffiliateBuyerID    *uint
+	WithdrawalRequestID *uint
+	Description         string
+}
+
+// PostAffiliateLedger writes a balanced transaction and refreshes the
+// affiliate's cached balance. It returns false without an error if the
+// transaction was already posted.
+func PostAffiliateLedger(db *gorm.DB, p AffiliatePosting) (bool, error) {
+	if p.Amount <= 0 {
+		return false, fmt.Errorf("ledger amount must be positive, got %v", p.Amount)
+	}
+	line := AffiliateLedgerEntry{
+		TransactionID:       p.TransactionID,
+		AffiliateID:         p.AffiliateID,
+		Kind:                p.Kind,
+		LicenseID:           p.LicenseID,
+		AffiliateBuyerID:    p.AffiliateBuyerID,
+		WithdrawalRequestID: p.WithdrawalRequestID,
+		Description:         p.Description,
+	}
+	debit, credit := line, line
+	debit.Account, debit.Debit = p.DebitAccount, p.Amount
+	credit.Account, credit.Credit = p.CreditAccount, p.Amount
+
+	posted := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&[]AffiliateLedgerEntry{debit, credit})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		posted = true
+		return SyncAffiliateBalance(tx, p.AffiliateID)
+	})
+	return posted, err
+}
+
+// affiliateLedgerTotal sums credit minus debit of an affiliate account
+func affiliateLedgerTotal(db *gorm.DB, affiliateID uint, account string, kinds ...string) (float64, error) {
+	var total float64
+	query := db.Model(&AffiliateLedgerEntry{}).
+		Select("COALESCE(SUM(credit - debit), 0)").
+		Where("affiliate_id = ? AND account = ?", affiliateID, account)
+	if len(kinds) > 0 {
+		query = query.Where("kind IN ?", kinds)
+	}
+	err := query.Scan(&total).Error
+	return total, err
+}
+
+// GetAffiliateLedgerBalance returns the affiliate's withdrawable balance
+func GetAffiliateLedgerBalance(db *gorm.DB, affiliateID uint) (float64, error) {
+	return affiliateLedgerTotal(db, affiliateID, AffiliateAccountPayable)
+}
+
+// GetAffiliateEarnings returns the commissions credited to an affiliate from
+// licenses redeemed by referred users and from confirmed offline sales lists
+func GetAffiliateEarnings(db *gorm.DB, affiliateID uint) (licenses, offline float64, err error) {
+	if licenses, err = affiliateLedgerTotal(db, affiliateID, AffiliateAccountPayable, AffiliateLedgerCommission); err != nil {
+		return 0, 0, err
+	}
+	if offline, err = affiliateLedgerTotal(db, affiliateID, AffiliateAccountPayable, AffiliateLedgerOfflineSale); err != nil {
+		return 0, 0, err
+	}
+	return licenses, offline, nil
+}
+
+// SyncAffiliateBalance stores the ledger balance and earnings on the
+// affiliate row, which only caches them for listing
+func SyncAffiliateBalance(db *gorm.DB, affiliateID uint) error {
+	balance, err := GetAffiliateLedgerBalance(db, affiliateID)
+	if err != nil {
+		return err
+	}
+	licenses, offline, err := GetAffiliateEarnings(db, affiliateID)
+	if err != nil {
+		return err
+	}
+	return db.Model(&Affiliate{}).Where("id = ?", affiliateID).
+		Updates(map[string]interface{}{"balance": balance, "total_earnings": licenses + offline}).Error
+}
+
+// affiliateCommission returns an affiliate's share of a sale. A commission
+// percent of 0 means the full amount, as in the affiliate dashboard.
+func affiliateCommission(affiliate *Affiliate, saleToman int64) float64 {
+	percent := affiliate.CommissionPercent
+	if percent <= 0 {
+		percent = 100
+	}
+	return math.Round(float64(saleToman) * percent / 100)
+}
+
+// PostLicenseCommission credits the referring affiliate when a referred user
+// redeems a license. The sale price is the license batch's unit price, or
+// the plan price for codes sold outside a priced batch. Nothing is posted if
+// the affiliate no longer exists.
+func PostLicenseCommission(db *gorm.DB, license *License, affiliateID uint) (bool, error) {
+	var affiliate Affiliate
+	if err := db.First(&affiliate, affiliateID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var price int64
+	if license.BatchID != nil {
+		var batch LicenseBatch
+		if err := db.Select("unit_price_toman").First(&batch, *license.BatchID).Error; err == nil {
+			price = batch.UnitPriceToman
+		}
+	}
+	if price <= 0 {
+		var err error
+		if price, err = LicensePlanPrice(db, license.Type); err != nil {
+			return false, err
+		}
+	}
+
+	amount := affiliateCommission(&affiliate, price)
+	if amount <= 0 {
+		return false, nil
+	}
+	licenseID := license.ID
+	return PostAffiliateLedger(db, AffiliatePosting{
+		TransactionID: fmt.Sprintf("license:%d", license.ID),
+		AffiliateID:   affiliateID,
+		Kind:          AffiliateLedgerCommission,
+		Amount:        amount,
+		DebitAccount:  AffiliateAccountCommission,
+		CreditAccount: AffiliateAccountPayable,
+		LicenseID:     &licenseID,
+		Description:   fmt.Sprintf("license %s (%s) at %d toman", license.Code, license.Type, price),
+	})
+}
+
+// ConfirmAffiliateBuyers saves a confirmed offline sales list and posts its
+// commissions in one transaction, so buyers are never saved without their
+// commissions. It returns the number of buyers credited and skipped.
+func ConfirmAffiliateBuyers(db *gorm.DB, affiliate *Affiliate, purchasedAt *time.Time, buyers []AffiliateBuyer) (int, int, error) {
+	var credited, skipped int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := CreateAffiliateBuyerBatch(tx, affiliate.ID, purchasedAt, buyers); err != nil {
+			return err
+		}
+		var err error
+		credited, skipped, err = CreditAffiliateBuyers(tx, affiliate, buyers)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return credited, skipped, nil
+}
+
+// CreditAffiliateBuyers posts commissions for buyers confirmed from an
+// offline sales list. Buyers who are referred users and already earned a
+// license commission are skipped so a sale is never credited twice.
+func CreditAffiliateBuyers(db *gorm.DB, affiliate *Affiliate, buyers []AffiliateBuyer) (int, int, error) {
+	var onlinePhones []string
+	if err := db.Model(&User{}).
+		Joins("JOIN licenses ON licenses.used_by = users.id").
+		Joins("JOIN affiliate_ledger_entries ON affiliate_ledger_entries.license_id = licenses.id AND affiliate_ledger_entries.account = ?", AffiliateAccountPayable).
+		Where("users.affiliate_id = ?", affiliate.ID).
+		Distinct("users.phone").Pluck("users.phone", &onlinePhones).Error; err != nil {
+		return 0, 0, err
+	}
+	credited := make(map[string]bool, len(onlinePhones))
+	for _, phone := range onlinePhones {
+		credited[utils.NormalizeIranianPhone(phone)] = true
+	}
+
+	posted, skipped := 0, 0
+	for i := range buyers {
+		buyer := &buyers[i]
+		if credited[utils.NormalizeIranianPhone(buyer.Phone)] {
+			skipped++
+			continue
+		}
+		sale := int64(DefaultAmountToman)
+		if buyer.AmountToman != nil && *buyer.AmountToman > 0 {
+			sale = *buyer.AmountToman
+		}
+		amount := affiliateCommission(affiliate, sale)
+		if amount <= 0 {
+			continue
+		}
+		buyerID := buyer.ID
+		ok, err := PostAffiliateLedger(db, AffiliatePosting{
+			TransactionID:    fmt.Sprintf("buyer:%d", buyer.ID),
+			AffiliateID:      affiliate.ID,
+			Kind:             AffiliateLedgerOfflineSale,
+			Amount:           amount,
+			DebitAccount:     AffiliateAccountCommission,
+			CreditAccount:    AffiliateAccountPayable,
+			AffiliateBuyerID: &buyerID,
+			Description:      fmt.Sprintf("offline sale to %s at %d toman", buyer.Phone, sale),
+		})
+		if err != nil {
+			return posted, skipped, err
+		}
+		if ok {
+			posted++
+		}
+	}
+	return posted, skipped, nil
+}
+
+// AdjustAffiliateBalance posts a manual correction that brings the
+// affiliate's balance to target
+func AdjustAffiliateBalance(db *gorm.DB, affiliateID uint, target float64, note string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var affiliate Affiliate
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&affiliate, affiliateID).Error; err != nil {
+			return err
+		}
+		balance, err := GetAffiliateLedgerBalance(tx, affiliateID)
+		if err != nil {
+			return err
+		}
+		diff := math.Round((target-balance)*100) / 100
+		if diff == 0 {
+			return nil
+		}
+
+		posting := AffiliatePosting{
+			TransactionID: fmt.Sprintf("adjustment:%d:%d", affiliateID, time.Now().UnixNano()),
+			AffiliateID:   affiliateID,
+			Kind:          AffiliateLedgerAdjustment,
+			Amount:        math.Abs(diff),
+			DebitAccount:  AffiliateAccountAdjustments,
+			CreditAccount: AffiliateAccountPayable,
+			Description:   note,
+		}
+		if diff < 0 {
+			posting.DebitAccount, posting.CreditAccount = AffiliateAccountPayable, AffiliateAccountAdjustments
+		}
+		_, err = PostAffiliateLedger(tx, posting)
+		return err
+	})
+}
+
+// SeedAffiliateOpeningBalances posts the hand-edited balance of affiliates
+// that have no ledger entries yet, so moving to the ledger keeps their balance
+func SeedAffiliateOpeningBalances(db *gorm.DB) error {
+	var affiliates []Affiliate
+	if err := db.Where("balance <> 0").
+		Where("NOT EXISTS (SELECT 1 FROM affiliate_ledger_entries e WHERE e.affiliate_id = affiliates.id)").
+		Find(&affiliates).Error; err != nil {
+		return err
+	}
+	for _, a := range affiliates {
+		posting := AffiliatePosting{
+			TransactionID: fmt.Sprintf("opening:%d", a.ID),
+			AffiliateID:   a.ID,
+			Kind:          AffiliateLedgerAdjustment,
+			Amount:        math.Abs(a.Balance),
+			DebitAccount:  AffiliateAccountAdjustments,
+			CreditAccount: AffiliateAccountPayable,
+			Description:   "opening balance",
+		}
+		if a.Balance < 0 {
+			posting.DebitAccount, posting.CreditAccount = AffiliateAccountPayable, AffiliateAccountAdjustments
+		}
+		if _, err := PostAffiliateLedger(db, posting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAffiliateLedger returns the lines of an affiliate's payable account,
+// newest first
+func GetAffiliateLedger(db *gorm.DB, affiliateID uint, limit, offset int) ([]AffiliateLedgerEntry, int64, error) {
+	var list []AffiliateLedgerEntry
+	var total int64
+	query := db.Model(&AffiliateLedgerEntry{}).Where("affiliate_id = ? AND account = ?", affiliateID, AffiliateAccountPayable)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("created_at DESC").Order("id DESC").Limit(limit).Offset(offset).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
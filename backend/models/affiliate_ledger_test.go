@@ -0,0 +1,156 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"gorm.io/gorm"
+)
+
+func createAffiliate(t *testing.T, db *gorm.DB, percent float64) *models.Affiliate {
+	t.Helper()
+	aff := &models.Affiliate{Name: "Partner", Username: "partner", Password: "x", IsActive: true, CommissionPercent: percent}
+	if err := models.CreateAffiliate(db, aff); err != nil {
+		t.Fatalf("create affiliate: %v", err)
+	}
+	return aff
+}
+
+func affiliateBalance(t *testing.T, db *gorm.DB, id uint) float64 {
+	t.Helper()
+	balance, err := models.GetAffiliateLedgerBalance(db, id)
+	if err != nil {
+		t.Fatalf("ledger balance: %v", err)
+	}
+	var aff models.Affiliate
+	db.First(&aff, id)
+	if aff.Balance != balance {
+		t.Fatalf("cached balance %v differs from ledger balance %v", aff.Balance, balance)
+	}
+	return balance
+}
+
+func TestLicenseRedemptionPostsAffiliateCommission(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	aff := createAffiliate(t, db, 20)
+	referred := testutil.CreateUser(t, db, "09120000002")
+	db.Model(referred).Update("affiliate_id", aff.ID)
+	direct := testutil.CreateUser(t, db, "09120000003")
+
+	codes, err := models.GenerateLicenses(db, 3, "plus", admin.ID)
+	if err != nil {
+		t.Fatalf("generate licenses: %v", err)
+	}
+	if _, err := models.UseLicense(db, codes[0], referred.ID); err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if _, err := models.UseLicense(db, codes[1], direct.ID); err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+
+	want := float64(models.DefaultAmountToman) * 0.2
+	if got := affiliateBalance(t, db, aff.ID); got != want {
+		t.Fatalf("balance = %v, want %v", got, want)
+	}
+
+	// Every transaction balances
+	var net float64
+	db.Model(&models.AffiliateLedgerEntry{}).Select("COALESCE(SUM(debit - credit), 0)").Scan(&net)
+	if net != 0 {
+		t.Fatalf("ledger debits and credits differ by %v", net)
+	}
+
+	// A renewal earns another commission
+	if _, err := models.UseLicense(db, codes[2], referred.ID); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if got := affiliateBalance(t, db, aff.ID); got != 2*want {
+		t.Fatalf("balance after renewal = %v, want %v", got, 2*want)
+	}
+
+	// An offline sale is reported apart from the license commissions
+	if _, _, err := models.ConfirmAffiliateBuyers(db, aff, nil, []models.AffiliateBuyer{{Name: "Buyer", Phone: "09121111111"}}); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	licenses, offline, err := models.GetAffiliateEarnings(db, aff.ID)
+	if err != nil || licenses != 2*want || offline != want {
+		t.Fatalf("earnings = %v license, %v offline (err %v), want %v and %v", licenses, offline, err, 2*want, want)
+	}
+}
+
+func TestAffiliateWithdrawalDebitsLedger(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	aff := createAffiliate(t, db, 100)
+	if err := models.AdjustAffiliateBalance(db, aff.ID, 1000000, "opening"); err != nil {
+		t.Fatalf("adjust balance: %v", err)
+	}
+
+	tooMuch := &models.AffiliateWithdrawalRequest{AffiliateID: aff.ID, Amount: 1500000}
+	if err := models.CreateAffiliateWithdrawalRequest(db, tooMuch); !errors.Is(err, models.ErrInsufficientAffiliateBalance) {
+		t.Fatalf("overdraw: err = %v, want ErrInsufficientAffiliateBalance", err)
+	}
+
+	first := &models.AffiliateWithdrawalRequest{AffiliateID: aff.ID, Amount: 600000}
+	if err := models.CreateAffiliateWithdrawalRequest(db, first); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if got := affiliateBalance(t, db, aff.ID); got != 400000 {
+		t.Fatalf("balance after request = %v, want 400000", got)
+	}
+	second := &models.AffiliateWithdrawalRequest{AffiliateID: aff.ID, Amount: 600000}
+	if err := models.CreateAffiliateWithdrawalRequest(db, second); !errors.Is(err, models.ErrInsufficientAffiliateBalance) {
+		t.Fatalf("second withdrawal over the held balance: err = %v", err)
+	}
+
+	if err := models.UpdateAffiliateWithdrawalStatus(db, first.ID, models.AffiliateWithdrawalRejected, "wrong card"); err != nil {
+		t.Fatalf("reject: %v", err)
+	}
+	if got := affiliateBalance(t, db, aff.ID); got != 1000000 {
+		t.Fatalf("balance after rejection = %v, want 1000000", got)
+	}
+	if err := models.UpdateAffiliateWithdrawalStatus(db, first.ID, models.AffiliateWithdrawalCompleted, ""); !errors.Is(err, models.ErrAffiliateWithdrawalClosed) {
+		t.Fatalf("complete a rejected withdrawal: err = %v, want ErrAffiliateWithdrawalClosed", err)
+	}
+
+	paid := &models.AffiliateWithdrawalRequest{AffiliateID: aff.ID, Amount: 1000000}
+	if err := models.CreateAffiliateWithdrawalRequest(db, paid); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if err := models.UpdateAffiliateWithdrawalStatus(db, paid.ID, models.AffiliateWithdrawalCompleted, "paid"); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if got := affiliateBalance(t, db, aff.ID); got != 0 {
+		t.Fatalf("balance after payout = %v, want 0", got)
+	}
+}
+
+func TestConfirmAffiliateBuyersSavesNothingWhenCommissionsFail(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	aff := createAffiliate(t, db, 10)
+	buyers := func() []models.AffiliateBuyer {
+		return []models.AffiliateBuyer{{Name: "Buyer", Phone: "09121111111"}}
+	}
+
+	credited, _, err := models.ConfirmAffiliateBuyers(db, aff, nil, buyers())
+	if err != nil || credited != 1 {
+		t.Fatalf("confirm: credited = %d, err = %v", credited, err)
+	}
+
+	// Without the ledger the commissions cannot be posted, and the buyers
+	// must not be saved either
+	if err := db.Migrator().DropTable(&models.AffiliateLedgerEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := models.ConfirmAffiliateBuyers(db, aff, nil, buyers()); err == nil {
+		t.Fatal("confirm succeeded without a ledger")
+	}
+	var count int64
+	db.Model(&models.AffiliateBuyer{}).Where("affiliate_id = ?", aff.ID).Count(&count)
+	if count != 1 {
+		t.Fatalf("%d buyers saved, want 1", count)
+	}
+}
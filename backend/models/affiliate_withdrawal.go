@@ -1,9 +1,12 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AffiliateWithdrawalStatus string
@@ -52,10 +55,42 @@ func GetAffiliateWithdrawalRequests(db *gorm.DB, affiliateID uint, limit, offset
 	return list, total, nil
 }
 
+// CreateAffiliateWithdrawalRequest records a withdrawal and, in the same
+// transaction, moves its amount from the affiliate's balance to the payout
+// hold. It fails with ErrInsufficientAffiliateBalance if the balance is short.
 func CreateAffiliateWithdrawalRequest(db *gorm.DB, req *AffiliateWithdrawalRequest) error {
-	req.Status = AffiliateWithdrawalPending
-	req.RequestedAt = time.Now()
-	return db.Create(req).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Serialize withdrawals of the same affiliate so the balance check holds
+		var affiliate Affiliate
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&affiliate, req.AffiliateID).Error; err != nil {
+			return err
+		}
+		balance, err := GetAffiliateLedgerBalance(tx, req.AffiliateID)
+		if err != nil {
+			return err
+		}
+		if req.Amount > balance {
+			return ErrInsufficientAffiliateBalance
+		}
+
+		req.Status = AffiliateWithdrawalPending
+		req.RequestedAt = time.Now()
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		requestID := req.ID
+		_, err = PostAffiliateLedger(tx, AffiliatePosting{
+			TransactionID:       fmt.Sprintf("withdrawal:%d", req.ID),
+			AffiliateID:         req.AffiliateID,
+			Kind:                AffiliateLedgerWithdrawalHold,
+			Amount:              req.Amount,
+			DebitAccount:        AffiliateAccountPayable,
+			CreditAccount:       AffiliateAccountPayoutPending,
+			WithdrawalRequestID: &requestID,
+			Description:         "withdrawal requested",
+		})
+		return err
+	})
 }
 
 func GetAffiliateWithdrawalByID(db *gorm.DB, id uint) (*AffiliateWithdrawalRequest, error) {
@@ -66,16 +101,65 @@ func GetAffiliateWithdrawalByID(db *gorm.DB, id uint) (*AffiliateWithdrawalReque
 	return &req, nil
 }
 
+// ErrAffiliateWithdrawalClosed is returned when a completed or rejected
+// withdrawal is changed again
+var ErrAffiliateWithdrawalClosed = errors.New("این درخواست قبلاً بسته شده است")
+
+// UpdateAffiliateWithdrawalStatus changes a withdrawal's status. Completing
+// it pays out the held amount; rejecting it returns the amount to the
+// affiliate's balance. Requests made before the ledger have no hold and
+// only change status.
 func UpdateAffiliateWithdrawalStatus(db *gorm.DB, id uint, status AffiliateWithdrawalStatus, notes string) error {
-	updates := map[string]interface{}{"status": status, "admin_notes": notes}
-	now := time.Now()
-	switch status {
-	case AffiliateWithdrawalApproved:
-		updates["approved_at"] = now
-	case AffiliateWithdrawalCompleted:
-		updates["completed_at"] = now
-	case AffiliateWithdrawalRejected:
-		updates["rejected_at"] = now
-	}
-	return db.Model(&AffiliateWithdrawalRequest{}).Where("id = ?", id).Updates(updates).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		var req AffiliateWithdrawalRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&req, id).Error; err != nil {
+			return err
+		}
+		if req.Status == AffiliateWithdrawalCompleted || req.Status == AffiliateWithdrawalRejected {
+			return ErrAffiliateWithdrawalClosed
+		}
+
+		updates := map[string]interface{}{"status": status, "admin_notes": notes}
+		now := time.Now()
+		switch status {
+		case AffiliateWithdrawalApproved:
+			updates["approved_at"] = now
+		case AffiliateWithdrawalCompleted:
+			updates["completed_at"] = now
+		case AffiliateWithdrawalRejected:
+			updates["rejected_at"] = now
+		}
+		if err := tx.Model(&AffiliateWithdrawalRequest{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if status != AffiliateWithdrawalCompleted && status != AffiliateWithdrawalRejected {
+			return nil
+		}
+		var held int64
+		if err := tx.Model(&AffiliateLedgerEntry{}).Where("transaction_id = ?", fmt.Sprintf("withdrawal:%d", id)).Count(&held).Error; err != nil {
+			return err
+		}
+		if held == 0 {
+			return nil
+		}
+
+		posting := AffiliatePosting{
+			AffiliateID:         req.AffiliateID,
+			Amount:              req.Amount,
+			WithdrawalRequestID: &req.ID,
+			Description:         notes,
+		}
+		if status == AffiliateWithdrawalCompleted {
+			posting.TransactionID = fmt.Sprintf("withdrawal:%d:payout", id)
+			posting.Kind = AffiliateLedgerPayout
+			posting.DebitAccount, posting.CreditAccount = AffiliateAccountPayoutPending, AffiliateAccountCash
+		} else {
+			posting.TransactionID = fmt.Sprintf("withdrawal:%d:release", id)
+			posting.Kind = AffiliateLedgerWithdrawalRelease
+			posting.DebitAccount, posting.CreditAccount = AffiliateAccountPayoutPending, AffiliateAccountPayable
+		}
+		_, err := PostAffiliateLedger(tx, posting)
+		return err
+	})
 }
@@ -33,5 +33,5 @@ type AIUsageResponse struct {
 
 // Constants for AI usage limits
 const (
-	DailyAIMessageLimit = 20
+	DailyAIMessageLimit = 20 // default for every license plan; see LicensePlan.DailyAIMessages
 )
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit actor types
+const (
+	AuditActorWebAdmin = "web_admin"
+	AuditActorUser     = "user" // legacy admin flagged on the users table
+	AuditActorTelegram = "telegram"
+)
+
+// ErrAuditEventImmutable is returned when code tries to change a recorded event
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent is an append-only record of a state-changing admin action
+type AuditEvent struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	ActorType       string    `json:"actor_type" gorm:"size:20;not null;index"`
+	ActorID         *uint     `json:"actor_id" gorm:"index"`
+	ActorTelegramID *int64    `json:"actor_telegram_id" gorm:"index"`
+	ActorName       string    `json:"actor_name" gorm:"size:255"`
+	Action          string    `json:"action" gorm:"size:150;not null;index"`
+	TargetType      string    `json:"target_type" gorm:"size:50;index:idx_audit_event_target"`
+	TargetID        string    `json:"target_id" gorm:"size:100;index:idx_audit_event_target"`
+	Changes         string    `json:"-" gorm:"type:mediumtext"` // JSON object of field -> {before, after}
+	Metadata        string    `json:"-" gorm:"type:text"`       // JSON object with extra context
+	IPAddress       string    `json:"ip_address" gorm:"size:45"`
+	UserAgent       string    `json:"user_agent" gorm:"size:500"`
+	CreatedAt       time.Time `json:"created_at" gorm:"index"`
+}
+
+// TableName specifies the table name for AuditEvent
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// BeforeUpdate keeps recorded events immutable
+func (AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete keeps recorded events immutable
+func (AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// AuditChange is the before and after value of a single field
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEventResponse is an audit event with its JSON columns decoded
+type AuditEventResponse struct {
+	AuditEvent
+	Changes  map[string]AuditChange `json:"changes"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// ToResponse decodes the stored changes and metadata
+func (e AuditEvent) ToResponse() AuditEventResponse {
+	resp := AuditEventResponse{AuditEvent: e}
+	if e.Changes != "" {
+		_ = json.Unmarshal([]byte(e.Changes), &resp.Changes)
+	}
+	if e.Metadata != "" {
+		_ = json.Unmarshal([]byte(e.Metadata), &resp.Metadata)
+	}
+	return resp
+}
+
+// auditSnapshot flattens a value into its JSON fields. Fields hidden from JSON
+// (passwords and the like) never reach the audit log.
+func auditSnapshot(value interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	snapshot := map[string]interface{}{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		// Not an object (e.g. a list); keep it under a single key
+		var raw interface{}
+		_ = json.Unmarshal(data, &raw)
+		return map[string]interface{}{"value": raw}
+	}
+	return snapshot
+}
+
+// DiffAuditSnapshots returns the fields that differ between before and after.
+// Either side may be nil for creations and deletions.
+func DiffAuditSnapshots(before, after interface{}) map[string]AuditChange {
+	b, a := auditSnapshot(before), auditSnapshot(after)
+	changes := make(map[string]AuditChange)
+	for key, bv := range b {
+		if key == "updated_at" {
+			continue
+		}
+		if av, ok := a[key]; !ok || !reflect.DeepEqual(bv, av) {
+			changes[key] = AuditChange{Before: bv, After: a[key]}
+		}
+	}
+	for key, av := range a {
+		if key == "updated_at" {
+			continue
+		}
+		if _, ok := b[key]; !ok {
+			changes[key] = AuditChange{Before: nil, After: av}
+		}
+	}
+	return changes
+}
+
+// RecordAuditEvent stores an event with the diff between before and after
+func RecordAuditEvent(db *gorm.DB, event AuditEvent, before, after interface{}, metadata map[string]interface{}) error {
+	if event.Action == "" || event.ActorType == "" {
+		return errors.New("audit event requires an actor and an action")
+	}
+
+	if changes := DiffAuditSnapshots(before, after); len(changes) > 0 {
+		data, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		event.Changes = string(data)
+	}
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		event.Metadata = string(data)
+	}
+	if len(event.UserAgent) > 500 {
+		event.UserAgent = event.UserAgent[:500]
+	}
+	event.ID = 0
+	return db.Create(&event).Error
+}
+
+// AuditTargetID formats an entity ID for the target_id column
+func AuditTargetID(id interface{}) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprint(id)
+}
+
+// AuditEventFilter narrows down audit events
+type AuditEventFilter struct {
+	ActorType       string
+	ActorID         *uint
+	ActorTelegramID *int64
+	Action          string // exact action or a prefix ending in "."
+	TargetType      string
+	TargetID        string
+	From            *time.Time
+	To              *time.Time
+}
+
+func (f AuditEventFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.ActorType != "" {
+		query = query.Where("actor_type = ?", f.ActorType)
+	}
+	if f.ActorID != nil {
+		query = query.Where("actor_id = ?", *f.ActorID)
+	}
+	if f.ActorTelegramID != nil {
+		query = query.Where("actor_telegram_id = ?", *f.ActorTelegramID)
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			query = query.Where("action LIKE ?", f.Action+"%")
+		} else {
+			query = query.Where("action = ?", f.Action)
+		}
+	}
+	if f.TargetType != "" {
+		query = query.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		query = query.Where("target_id = ?", f.TargetID)
+	}
+	if f.From != nil {
+		query = query.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		query = query.Where("created_at <= ?", *f.To)
+	}
+	return query
+}
+
+// GetAuditEvents returns a page of audit events, newest first
+func GetAuditEvents(db *gorm.DB, filter AuditEventFilter, page, perPage int) ([]AuditEvent, int64, error) {
+	var events []AuditEvent
+	var total int64
+
+	query := filter.apply(db.Model(&AuditEvent{}))
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	if err := query.Order("created_at DESC, id DESC").Offset(offset).Limit(perPage).Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// GetAuditEventsForExport returns up to limit matching events, newest first
+func GetAuditEventsForExport(db *gorm.DB, filter AuditEventFilter, limit int) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := filter.apply(db.Model(&AuditEvent{})).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,73 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestRecordAuditEventStoresDiff(t *testing.T) {
+	db := testutil.NewTestDB(t)
+
+	adminID := uint(7)
+	before := &models.WithdrawalRequest{ID: 3, Status: models.WithdrawalStatusPending, Amount: 100}
+	after := &models.WithdrawalRequest{ID: 3, Status: models.WithdrawalStatusApproved, Amount: 100}
+	event := models.AuditEvent{
+		ActorType:  models.AuditActorWebAdmin,
+		ActorID:    &adminID,
+		Action:     "withdrawal.status",
+		TargetType: "withdrawal_request",
+		TargetID:   models.AuditTargetID(3),
+	}
+	if err := models.RecordAuditEvent(db, event, before, after, map[string]interface{}{"note": "ok"}); err != nil {
+		t.Fatalf("record audit event: %v", err)
+	}
+
+	events, total, err := models.GetAuditEvents(db, models.AuditEventFilter{Action: "withdrawal."}, 1, 10)
+	if err != nil || total != 1 {
+		t.Fatalf("expected one event, got %d (%v)", total, err)
+	}
+	resp := events[0].ToResponse()
+	if len(resp.Changes) != 1 {
+		t.Fatalf("expected only status to change, got %v", resp.Changes)
+	}
+	if change := resp.Changes["status"]; change.Before != "pending" || change.After != "approved" {
+		t.Fatalf("unexpected status change: %+v", change)
+	}
+	if resp.Metadata["note"] != "ok" {
+		t.Fatalf("metadata not stored: %v", resp.Metadata)
+	}
+
+	// Filters that do not match return nothing
+	_, total, _ = models.GetAuditEvents(db, models.AuditEventFilter{TargetID: "4"}, 1, 10)
+	if total != 0 {
+		t.Fatalf("expected no events for another target, got %d", total)
+	}
+}
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	db := testutil.NewTestDB(t)
+
+	telegramID := int64(12345)
+	event := models.AuditEvent{ActorType: models.AuditActorTelegram, ActorTelegramID: &telegramID, Action: "supplier.approve"}
+	if err := models.RecordAuditEvent(db, event, nil, nil, nil); err != nil {
+		t.Fatalf("record audit event: %v", err)
+	}
+
+	var stored models.AuditEvent
+	if err := db.First(&stored).Error; err != nil {
+		t.Fatalf("load audit event: %v", err)
+	}
+	if err := db.Model(&stored).Update("action", "changed").Error; !errors.Is(err, models.ErrAuditEventImmutable) {
+		t.Fatalf("expected update to be refused, got %v", err)
+	}
+	if err := db.Delete(&stored).Error; !errors.Is(err, models.ErrAuditEventImmutable) {
+		t.Fatalf("expected delete to be refused, got %v", err)
+	}
+
+	if err := models.RecordAuditEvent(db, models.AuditEvent{Action: "x"}, nil, nil, nil); err == nil {
+		t.Fatalf("expected an event without an actor to be rejected")
+	}
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Auth session revoke reasons
+const (
+	SessionRevokeLogout      = "logout"
+	SessionRevokeLogoutAll   = "logout_all"
+	SessionRevokeDeactivated = "deactivated"
+	SessionRevokeDeleted     = "deleted"
+	SessionRevokeTokenReuse  = "refresh_token_reuse"
+)
+
+// Auth session errors
+var (
+	ErrSessionNotFound = errors.New("auth session not found")
+	ErrSessionRevoked  = errors.New("auth session revoked")
+	ErrSessionExpired  = errors.New("auth session expired")
+)
+
+// AuthSession is a login of a user, web admin or affiliate. Access tokens
+// carry the session ID so that revoking the session ends them immediately;
+// the refresh token is rotated on every use and only its hash is stored.
+type AuthSession struct {
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	SubjectType         string     `json:"subject_type" gorm:"size:20;not null;index:idx_auth_session_subject"`
+	SubjectID           uint       `json:"subject_id" gorm:"not null;index:idx_auth_session_subject"`
+	RefreshTokenHash    string     `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	PreviousRefreshHash string     `json:"-" gorm:"size:64;index"` // last rotated-out token, kept to detect reuse
+	UserAgent           string     `json:"user_agent" gorm:"size:500"`
+	IPAddress           string     `json:"ip_address" gorm:"size:45"`
+	ExpiresAt           time.Time  `json:"expires_at" gorm:"not null"`
+	LastUsedAt          *time.Time `json:"last_used_at"`
+	RevokedAt           *time.Time `json:"revoked_at"`
+	RevokeReason        string     `json:"revoke_reason,omitempty" gorm:"size:50"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for AuthSession
+func (AuthSession) TableName() string {
+	return "auth_sessions"
+}
+
+// CreateAuthSession starts a session for a subject with the hash of its first
+// refresh token
+func CreateAuthSession(db *gorm.DB, subjectType string, subjectID uint, refreshHash, userAgent, ip string, expiresAt time.Time) (*AuthSession, error) {
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	session := AuthSession{
+		SubjectType:      subjectType,
+		SubjectID:        subjectID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        userAgent,
+		IPAddress:        ip,
+		ExpiresAt:        expiresAt,
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveAuthSession returns the session if it belongs to the subject and
+// is neither revoked nor expired
+func GetActiveAuthSession(db *gorm.DB, id uint, subjectType string, subjectID uint) (*AuthSession, error) {
+	var session AuthSession
+	err := db.Where("id = ? AND subject_type = ? AND subject_id = ?", id, subjectType, subjectID).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	return &session, nil
+}
+
+// RotateAuthSession swaps the presented refresh token for a new one. A token
+// that was already rotated out is treated as stolen: the whole session is
+// revoked and ErrSessionRevoked is returned.
+func RotateAuthSession(db *gorm.DB, presentedHash, newHash string, expiresAt time.Time) (*AuthSession, error) {
+	var session AuthSession
+	reused := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("refresh_token_hash = ? OR previous_refresh_hash = ?", presentedHash, presentedHash).
+			First(&session).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+		if session.RevokedAt != nil {
+			return ErrSessionRevoked
+		}
+		if !time.Now().Before(session.ExpiresAt) {
+			return ErrSessionExpired
+		}
+		if session.RefreshTokenHash != presentedHash {
+			reused = true
+			return nil
+		}
+
+		now := time.Now()
+		session.PreviousRefreshHash = session.RefreshTokenHash
+		session.RefreshTokenHash = newHash
+		session.ExpiresAt = expiresAt
+		session.LastUsedAt = &now
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"previous_refresh_hash": session.PreviousRefreshHash,
+			"refresh_token_hash":    session.RefreshTokenHash,
+			"expires_at":            session.ExpiresAt,
+			"last_used_at":          session.LastUsedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		if err := RevokeAuthSession(db, session.ID, SessionRevokeTokenReuse); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionRevoked
+	}
+	return &session, nil
+}
+
+func revokeSessions(query *gorm.DB, reason string) error {
+	return query.Model(&AuthSession{}).
+		Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoke_reason": reason}).Error
+}
+
+// RevokeAuthSession ends a single session
+func RevokeAuthSession(db *gorm.DB, id uint, reason string) error {
+	return revokeSessions(db.Where("id = ?", id), reason)
+}
+
+// RevokeAllAuthSessions ends every session of a subject ("log out all devices")
+func RevokeAllAuthSessions(db *gorm.DB, subjectType string, subjectID uint, reason string) error {
+	return revokeSessions(db.Where("subject_type = ? AND subject_id = ?", subjectType, subjectID), reason)
+}
+
+// GetActiveAuthSessions lists the live sessions of a subject, newest first
+func GetActiveAuthSessions(db *gorm.DB, subjectType string, subjectID uint) ([]AuthSession, error) {
+	var sessions []AuthSession
+	err := db.Where("subject_type = ? AND subject_id = ? AND revoked_at IS NULL AND expires_at > ?", subjectType, subjectID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
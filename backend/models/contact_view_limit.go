@@ -60,11 +60,9 @@ type ContactLimitsResponse struct {
 
 // GetContactLimits returns the current contact view limits for a user
 func (u *User) GetContactLimits(db *gorm.DB) (*ContactLimitsResponse, error) {
-	// Get user's license info first
-	license, err := GetUserLicense(db, u.ID)
+	plan, err := GetUserLicensePlan(db, u.ID)
 	if err != nil {
-		// No license - use default limits (same as plus)
-		license = &License{Type: "plus"}
+		return nil, err
 	}
 
 	// Get current usage
@@ -75,19 +73,12 @@ func (u *User) GetContactLimits(db *gorm.DB) (*ContactLimitsResponse, error) {
 
 	totalUsed := limits.VisitorViews + limits.SupplierViews + limits.AvailableProductViews
 
-	// Calculate max based on license type
-	visitorMax := 3
-	supplierMax := 3
-	availableProductMax := 3
-	if license.Type == "pro" {
-		supplierMax = 6
-		availableProductMax = 6
-	}
-	totalMax := visitorMax + supplierMax + availableProductMax
-	totalRemaining := totalMax - totalUsed
-
-	if totalRemaining < 0 {
-		totalRemaining = 0
+	// An unlimited quota makes the total unlimited too (reported as 0 / -1)
+	totalMax := 0
+	totalRemaining := -1
+	if plan.DailyVisitorViews > 0 && plan.DailySupplierViews > 0 && plan.DailyAvailableProductViews > 0 {
+		totalMax = plan.DailyVisitorViews + plan.DailySupplierViews + plan.DailyAvailableProductViews
+		totalRemaining = quotaRemaining(totalMax, totalUsed)
 	}
 
 	return &ContactLimitsResponse{
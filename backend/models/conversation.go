@@ -0,0 +1,790 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"asl-market-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conversation context types: what a conversation is about
+const (
+	ConversationContextMatchingRequest = "matching_request"
+	ConversationContextVisitorProject  = "visitor_project"
+	ConversationContextDirect          = "direct"
+)
+
+// Conversation participant roles
+const (
+	ConversationRoleSupplier = "supplier"
+	ConversationRoleVisitor  = "visitor"
+	ConversationRoleMember   = "member"
+)
+
+// Conversation statuses
+const (
+	ConversationStatusActive = "active"
+	ConversationStatusClosed = "closed"
+)
+
+// MaxConversationAttachments caps the attachments of a single message
+const MaxConversationAttachments = 10
+
+var (
+	ErrNotConversationParticipant  = errors.New("user is not a participant of this conversation")
+	ErrConversationClosed          = errors.New("conversation is closed")
+	ErrEmptyConversationMessage    = errors.New("message needs text or an attachment")
+	ErrTooManyAttachments          = fmt.Errorf("a message can have at most %d attachments", MaxConversationAttachments)
+	ErrConversationMessageNotOwned = errors.New("only the sender can change a message")
+	ErrConversationMessageRemoved  = errors.New("message has been deleted")
+)
+
+// Conversation is a chat between users about a context: a matching request,
+// a visitor project (one per supplier), or nothing (direct). Support tickets
+// keep their own messages, since admin replies have no user sender.
+type Conversation struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	ContextType string `json:"context_type" gorm:"size:30;not null;index:idx_conversation_context"`
+	ContextID   uint   `json:"context_id" gorm:"index:idx_conversation_context"` // 0 for direct conversations
+	// ContextKey makes the conversation unique within its context,
+	// e.g. "visitor_project:5:supplier:3"
+	ContextKey string `json:"-" gorm:"size:100;not null;uniqueIndex"`
+
+	// Status: active, closed
+	Status string `json:"status" gorm:"size:20;default:'active'"`
+
+	// Last message info (for sorting/preview)
+	LastMessageAt      *time.Time `json:"last_message_at"`
+	LastMessagePreview string     `json:"last_message_preview" gorm:"size:255"`
+
+	Participants []ConversationParticipant `json:"participants" gorm:"foreignKey:ConversationID"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// ConversationParticipant is a user taking part in a conversation. Messages
+// after LastReadMessageID that others sent are unread for them.
+type ConversationParticipant struct {
+	ID             uint  `json:"id" gorm:"primaryKey"`
+	ConversationID uint  `json:"conversation_id" gorm:"not null;uniqueIndex:idx_conversation_participant"`
+	UserID         uint  `json:"user_id" gorm:"not null;uniqueIndex:idx_conversation_participant;index"`
+	User           *User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+
+	// Role: supplier, visitor or member
+	Role string `json:"role" gorm:"size:20;not null"`
+	// Supplier or visitor ID for those roles
+	ProfileID uint `json:"profile_id"`
+
+	LastReadMessageID uint       `json:"last_read_message_id" gorm:"default:0"`
+	LastReadAt        *time.Time `json:"last_read_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationMessage is a message in a conversation. Deleted messages keep
+// their place in the history with RemovedAt set and no content.
+type ConversationMessage struct {
+	ID             uint   `json:"id" gorm:"primaryKey"`
+	ConversationID uint   `json:"conversation_id" gorm:"not null;index"`
+	SenderID       uint   `json:"sender_id" gorm:"not null;index"` // User ID
+	Sender         User   `json:"sender" gorm:"foreignKey:SenderID"`
+	SenderType     string `json:"sender_type" gorm:"size:20;not null"` // sender's participant role
+
+	Message     string                   `json:"message" gorm:"type:text"`
+	Attachments []ConversationAttachment `json:"attachments" gorm:"foreignKey:MessageID"`
+	// Set on messages that carry a negotiation offer
+	OfferID *uint             `json:"offer_id,omitempty" gorm:"index"`
+	Offer   *NegotiationOffer `json:"offer,omitempty" gorm:"foreignKey:OfferID"`
+
+	EditedAt  *time.Time `json:"edited_at"`
+	RemovedAt *time.Time `json:"removed_at"`
+
+	// Source row of messages moved over from the matching and visitor
+	// project chat tables
+	LegacySource string `json:"-" gorm:"size:30;uniqueIndex:idx_conversation_message_legacy"`
+	LegacyID     *uint  `json:"-" gorm:"uniqueIndex:idx_conversation_message_legacy"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Filled by FillConversationMessages for responses
+	SenderName string     `json:"sender_name" gorm:"-"`
+	ImageURL   string     `json:"image_url,omitempty" gorm:"-"`
+	IsRead     bool       `json:"is_read" gorm:"-"`
+	ReadAt     *time.Time `json:"read_at" gorm:"-"`
+}
+
+// ConversationAttachment is a file uploaded with a message
+type ConversationAttachment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	MessageID uint      `json:"message_id" gorm:"not null;index"`
+	URL       string    `json:"url" gorm:"size:500;not null"`
+	FileName  string    `json:"file_name" gorm:"size:255"`
+	MimeType  string    `json:"mime_type" gorm:"size:100"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationAttachmentInput describes an already uploaded file
+type ConversationAttachmentInput struct {
+	URL      string `json:"url" binding:"required"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+}
+
+// SendConversationMessageRequest represents request to send a message
+type SendConversationMessageRequest struct {
+	Message     string                        `json:"message"`   // Optional if there is an attachment
+	ImageURL    string                        `json:"image_url"` // Shorthand for a single image attachment
+	Attachments []ConversationAttachmentInput `json:"attachments" binding:"dive"`
+}
+
+// AttachmentInputs returns the attachments including the image_url shorthand
+func (r SendConversationMessageRequest) AttachmentInputs() []ConversationAttachmentInput {
+	if r.ImageURL == "" {
+		return r.Attachments
+	}
+	return append([]ConversationAttachmentInput{{URL: r.ImageURL}}, r.Attachments...)
+}
+
+// EditConversationMessageRequest represents request to edit a message
+type EditConversationMessageRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+// ChatTypingRequest is the body of the typing endpoints. An empty body means
+// the user is typing.
+type ChatTypingRequest struct {
+	Typing *bool `json:"typing"`
+}
+
+// IsTyping reports whether the user started (true) or stopped typing
+func (r ChatTypingRequest) IsTyping() bool {
+	return r.Typing == nil || *r.Typing
+}
+
+// ConversationMember is a participant to add when opening a conversation
+type ConversationMember struct {
+	UserID    uint
+	Role      string
+	ProfileID uint
+}
+
+// MatchingConversationKey is the context key of the conversation between a
+// matching request's supplier and one visitor
+func MatchingConversationKey(requestID, visitorID uint) string {
+	return fmt.Sprintf("%s:%d:visitor:%d", ConversationContextMatchingRequest, requestID, visitorID)
+}
+
+// VisitorProjectConversationKey is the context key of the conversation between
+// a visitor project's owner and one supplier
+func VisitorProjectConversationKey(projectID, supplierID uint) string {
+	return fmt.Sprintf("%s:%d:supplier:%d", ConversationContextVisitorProject, projectID, supplierID)
+}
+
+// DirectConversationKey is the context key of the direct conversation between
+// two users, whichever of them opened it
+func DirectConversationKey(userID, otherUserID uint) string {
+	if userID > otherUserID {
+		userID, otherUserID = otherUserID, userID
+	}
+	return fmt.Sprintf("%s:%d:%d", ConversationContextDirect, userID, otherUserID)
+}
+
+// GetOrCreateConversation returns the conversation with contextKey, creating
+// it if needed, and makes sure every member takes part in it
+func GetOrCreateConversation(db *gorm.DB, contextType string, contextID uint, contextKey string, members []ConversationMember) (*Conversation, error) {
+	var conversation Conversation
+	err := db.Where("context_key = ?", contextKey).First(&conversation).Error
+	if err == gorm.ErrRecordNotFound {
+		conversation = Conversation{
+			ContextType: contextType,
+			ContextID:   contextID,
+			ContextKey:  contextKey,
+			Status:      ConversationStatusActive,
+		}
+		// Another request may open the same conversation at the same time
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&conversation).Error; err != nil {
+			return nil, err
+		}
+		err = db.Where("context_key = ?", contextKey).First(&conversation).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		participant := ConversationParticipant{
+			ConversationID: conversation.ID,
+			UserID:         member.UserID,
+			Role:           member.Role,
+			ProfileID:      member.ProfileID,
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&participant).Error; err != nil {
+			return nil, err
+		}
+	}
+	return GetConversation(db, conversation.ID)
+}
+
+// GetOrCreateMatchingConversation opens the conversation between the supplier
+// of a matching request and a visitor. Before the request is accepted any
+// visitor may open one to negotiate; afterwards only the accepted visitor's
+// conversation can be opened. gorm.ErrInvalidValue is returned otherwise.
+func GetOrCreateMatchingConversation(db *gorm.DB, requestID, visitorID uint) (*Conversation, error) {
+	var existing Conversation
+	err := db.Where("context_key = ?", MatchingConversationKey(requestID, visitorID)).First(&existing).Error
+	if err == nil {
+		return GetConversation(db, existing.ID)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var request MatchingRequest
+	if err := db.First(&request, requestID).Error; err != nil {
+		return nil, err
+	}
+	if request.AcceptedVisitorID != nil {
+		if *request.AcceptedVisitorID != visitorID {
+			return nil, gorm.ErrInvalidValue
+		}
+	} else if !request.IsOpenForNegotiation() {
+		return nil, gorm.ErrInvalidValue
+	}
+	var visitor Visitor
+	if err := db.First(&visitor, visitorID).Error; err != nil {
+		return nil, err
+	}
+
+	return GetOrCreateConversation(db, ConversationContextMatchingRequest, requestID, MatchingConversationKey(requestID, visitorID), []ConversationMember{
+		{UserID: request.UserID, Role: ConversationRoleSupplier, ProfileID: request.SupplierID},
+		{UserID: visitor.UserID, Role: ConversationRoleVisitor, ProfileID: visitor.ID},
+	})
+}
+
+// GetOrCreateVisitorProjectConversation opens the conversation between a
+// visitor project's owner and a supplier
+func GetOrCreateVisitorProjectConversation(db *gorm.DB, projectID, visitorID, supplierID uint) (*Conversation, error) {
+	var visitor Visitor
+	if err := db.First(&visitor, visitorID).Error; err != nil {
+		return nil, err
+	}
+	var supplier Supplier
+	if err := db.First(&supplier, supplierID).Error; err != nil {
+		return nil, err
+	}
+
+	return GetOrCreateConversation(db, ConversationContextVisitorProject, projectID, VisitorProjectConversationKey(projectID, supplierID), []ConversationMember{
+		{UserID: visitor.UserID, Role: ConversationRoleVisitor, ProfileID: visitor.ID},
+		{UserID: supplier.UserID, Role: ConversationRoleSupplier, ProfileID: supplier.ID},
+	})
+}
+
+// GetOrCreateDirectConversation opens the direct conversation between two users
+func GetOrCreateDirectConversation(db *gorm.DB, userID, otherUserID uint) (*Conversation, error) {
+	return GetOrCreateConversation(db, ConversationContextDirect, 0, DirectConversationKey(userID, otherUserID), []ConversationMember{
+		{UserID: userID, Role: ConversationRoleMember},
+		{UserID: otherUserID, Role: ConversationRoleMember},
+	})
+}
+
+// GetConversation loads a conversation with its participants
+func GetConversation(db *gorm.DB, id uint) (*Conversation, error) {
+	var conversation Conversation
+	if err := db.Preload("Participants.User").First(&conversation, id).Error; err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// Participant returns userID's participant record, or nil if they do not
+// take part in the conversation
+func (c *Conversation) Participant(userID uint) *ConversationParticipant {
+	for i := range c.Participants {
+		if c.Participants[i].UserID == userID {
+			return &c.Participants[i]
+		}
+	}
+	return nil
+}
+
+// ParticipantByRole returns the first participant with role, or nil
+func (c *Conversation) ParticipantByRole(role string) *ConversationParticipant {
+	for i := range c.Participants {
+		if c.Participants[i].Role == role {
+			return &c.Participants[i]
+		}
+	}
+	return nil
+}
+
+// OtherParticipants returns the user IDs of everyone but userID
+func (c *Conversation) OtherParticipants(userID uint) []uint {
+	var ids []uint
+	for _, p := range c.Participants {
+		if p.UserID != userID {
+			ids = append(ids, p.UserID)
+		}
+	}
+	return ids
+}
+
+// GetConversationsForUser lists the conversations userID takes part in,
+// most recently active first. contextType may be empty for all of them.
+func GetConversationsForUser(db *gorm.DB, userID uint, contextType string, page, perPage int) ([]Conversation, int64, error) {
+	query := db.Model(&Conversation{}).Where("id IN (?)",
+		db.Model(&ConversationParticipant{}).Select("conversation_id").Where("user_id = ?", userID))
+	return listConversations(query, contextType, page, perPage)
+}
+
+// GetConversations lists every conversation (admin view)
+func GetConversations(db *gorm.DB, contextType string, page, perPage int) ([]Conversation, int64, error) {
+	return listConversations(db.Model(&Conversation{}), contextType, page, perPage)
+}
+
+func listConversations(query *gorm.DB, contextType string, page, perPage int) ([]Conversation, int64, error) {
+	if contextType != "" {
+		query = query.Where("context_type = ?", contextType)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var conversations []Conversation
+	offset := (page - 1) * perPage
+	// Conversations without messages (NULL last_message_at) sort last in both MySQL and SQLite
+	err := query.Preload("Participants.User").
+		Order("last_message_at DESC, created_at DESC").
+		Offset(offset).Limit(perPage).Find(&conversations).Error
+	return conversations, total, err
+}
+
+// GetConversationMessages gets a page of messages, oldest first
+func GetConversationMessages(db *gorm.DB, conversationID uint, page, perPage int) ([]ConversationMessage, int64, error) {
+	var messages []ConversationMessage
+	var total int64
+
+	query := db.Model(&ConversationMessage{}).Where("conversation_id = ?", conversationID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	err := query.Preload("Sender").Preload("Attachments").Preload("Offer").
+		Order("id ASC").Offset(offset).Limit(perPage).Find(&messages).Error
+	return messages, total, err
+}
+
+// CreateConversationMessage adds a message from senderID, who must take part
+// in the conversation. The sender's own read cursor moves past it.
+func CreateConversationMessage(db *gorm.DB, conversation *Conversation, senderID uint, text string, attachments []ConversationAttachmentInput) (*ConversationMessage, error) {
+	sender := conversation.Participant(senderID)
+	if sender == nil {
+		return nil, ErrNotConversationParticipant
+	}
+	if conversation.Status == ConversationStatusClosed {
+		return nil, ErrConversationClosed
+	}
+	text = strings.TrimSpace(text)
+	if text == "" && len(attachments) == 0 {
+		return nil, ErrEmptyConversationMessage
+	}
+	if len(attachments) > MaxConversationAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	message := ConversationMessage{
+		ConversationID: conversation.ID,
+		SenderID:       senderID,
+		SenderType:     sender.Role,
+		Message:        text,
+	}
+	for _, a := range attachments {
+		url := utils.NormalizeImagePath(strings.TrimSpace(a.URL))
+		if url == "" {
+			return nil, ErrEmptyConversationMessage
+		}
+		mimeType := a.MimeType
+		if mimeType == "" {
+			mimeType = attachmentMimeType(url)
+		}
+		fileName := a.FileName
+		if fileName == "" {
+			fileName = path.Base(url)
+		}
+		message.Attachments = append(message.Attachments, ConversationAttachment{
+			URL: url, FileName: fileName, MimeType: mimeType, Size: a.Size,
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return insertConversationMessage(tx, sender, &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return getConversationMessage(db, conversation.ID, message.ID)
+}
+
+// insertConversationMessage stores a message from sender, updates the
+// conversation's preview and moves the sender's own read cursor past it
+func insertConversationMessage(tx *gorm.DB, sender *ConversationParticipant, message *ConversationMessage) error {
+	if err := tx.Create(message).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Conversation{}).Where("id = ?", message.ConversationID).Updates(map[string]interface{}{
+		"last_message_at":      message.CreatedAt,
+		"last_message_preview": conversationPreview(message),
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&ConversationParticipant{}).
+		Where("id = ? AND last_read_message_id < ?", sender.ID, message.ID).
+		Updates(map[string]interface{}{"last_read_message_id": message.ID, "last_read_at": message.CreatedAt}).Error
+}
+
+// EditConversationMessage replaces the text of one of userID's messages
+func EditConversationMessage(db *gorm.DB, conversationID, messageID, userID uint, text string) (*ConversationMessage, error) {
+	message, err := getConversationMessage(db, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrConversationMessageNotOwned
+	}
+	if message.RemovedAt != nil {
+		return nil, ErrConversationMessageRemoved
+	}
+	if message.OfferID != nil {
+		return nil, ErrOfferMessageLocked
+	}
+	text = strings.TrimSpace(text)
+	if text == "" && len(message.Attachments) == 0 {
+		return nil, ErrEmptyConversationMessage
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ConversationMessage{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{"message": text, "edited_at": now}).Error; err != nil {
+			return err
+		}
+		return refreshConversationPreview(tx, conversationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getConversationMessage(db, conversationID, messageID)
+}
+
+// DeleteConversationMessage removes the content and attachments of one of
+// userID's messages, leaving a placeholder in the history
+func DeleteConversationMessage(db *gorm.DB, conversationID, messageID, userID uint) (*ConversationMessage, error) {
+	message, err := getConversationMessage(db, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrConversationMessageNotOwned
+	}
+	if message.RemovedAt != nil {
+		return message, nil
+	}
+	if message.OfferID != nil {
+		return nil, ErrOfferMessageLocked
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", message.ID).Delete(&ConversationAttachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&ConversationMessage{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{"message": "", "removed_at": now}).Error; err != nil {
+			return err
+		}
+		return refreshConversationPreview(tx, conversationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getConversationMessage(db, conversationID, messageID)
+}
+
+// MarkConversationRead moves userID's read cursor to the newest message and
+// returns how many messages from others became read
+func MarkConversationRead(db *gorm.DB, conversationID, userID uint) (int64, error) {
+	var participant ConversationParticipant
+	if err := db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&participant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, ErrNotConversationParticipant
+		}
+		return 0, err
+	}
+
+	var lastID uint
+	if err := db.Model(&ConversationMessage{}).Where("conversation_id = ?", conversationID).
+		Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error; err != nil {
+		return 0, err
+	}
+	if lastID <= participant.LastReadMessageID {
+		return 0, nil
+	}
+
+	var count int64
+	if err := db.Model(&ConversationMessage{}).
+		Where("conversation_id = ? AND id > ? AND id <= ? AND sender_id != ? AND removed_at IS NULL",
+			conversationID, participant.LastReadMessageID, lastID, userID).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+
+	// The guard keeps a concurrent read from moving the cursor backwards
+	result := db.Model(&ConversationParticipant{}).
+		Where("id = ? AND last_read_message_id < ?", participant.ID, lastID).
+		Updates(map[string]interface{}{"last_read_message_id": lastID, "last_read_at": time.Now()})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, nil
+	}
+	return count, nil
+}
+
+// GetConversationUnreadCounts returns how many unread messages userID has in
+// each of the given conversations
+func GetConversationUnreadCounts(db *gorm.DB, userID uint, conversationIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64)
+	if len(conversationIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		ConversationID uint
+		Count          int64
+	}
+	err := db.Table("conversation_messages AS m").
+		Select("m.conversation_id, COUNT(*) AS count").
+		Joins("JOIN conversation_participants AS p ON p.conversation_id = m.conversation_id AND p.user_id = ?", userID).
+		Where("m.conversation_id IN ? AND m.id > p.last_read_message_id AND m.sender_id != ? AND m.removed_at IS NULL", conversationIDs, userID).
+		Group("m.conversation_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ConversationID] = row.Count
+	}
+	return counts, nil
+}
+
+// FillConversationMessages sets the response-only fields of messages: the
+// sender's name, the first image for clients that show one image per message,
+// and whether another participant has read it
+func FillConversationMessages(conversation *Conversation, messages []ConversationMessage) {
+	for i := range messages {
+		m := &messages[i]
+		m.SenderName = m.Sender.Name()
+		for _, a := range m.Attachments {
+			if strings.HasPrefix(a.MimeType, "image/") {
+				m.ImageURL = a.URL
+				break
+			}
+		}
+		m.IsRead, m.ReadAt = false, nil
+		for _, p := range conversation.Participants {
+			if p.UserID != m.SenderID && p.LastReadMessageID >= m.ID {
+				m.IsRead = true
+				m.ReadAt = p.LastReadAt
+				break
+			}
+		}
+	}
+}
+
+func getConversationMessage(db *gorm.DB, conversationID, messageID uint) (*ConversationMessage, error) {
+	var message ConversationMessage
+	if err := db.Preload("Sender").Preload("Attachments").Preload("Offer").
+		Where("conversation_id = ?", conversationID).First(&message, messageID).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// refreshConversationPreview re-derives the preview after the last message
+// was edited or deleted
+func refreshConversationPreview(tx *gorm.DB, conversationID uint) error {
+	var last ConversationMessage
+	err := tx.Preload("Attachments").Where("conversation_id = ?", conversationID).Order("id DESC").First(&last).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&Conversation{}).Where("id = ?", conversationID).
+		Update("last_message_preview", conversationPreview(&last)).Error
+}
+
+// conversationPreview is the text shown for a message in conversation lists
+func conversationPreview(message *ConversationMessage) string {
+	if message.RemovedAt != nil {
+		return "پیام حذف شد"
+	}
+	preview := message.Message
+	if len(message.Attachments) > 0 {
+		icon, label := "📎", "فایل"
+		if strings.HasPrefix(message.Attachments[0].MimeType, "image/") {
+			icon, label = "📷", "تصویر"
+		}
+		if preview == "" {
+			preview = label
+		}
+		preview = icon + " " + preview
+	}
+	if runes := []rune(preview); len(runes) > 100 {
+		preview = string(runes[:100]) + "..."
+	}
+	return preview
+}
+
+// attachmentMimeType guesses a file's type from its extension
+func attachmentMimeType(url string) string {
+	ext := strings.ToLower(path.Ext(url))
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return strings.SplitN(mimeType, ";", 2)[0]
+	}
+	return "application/octet-stream"
+}
+
+// ConversationView is a conversation with the records its context and
+// participants refer to, as shown in chat lists
+type ConversationView struct {
+	Conversation
+	UnreadCount int64 `json:"unread_count"`
+	IsActive    bool  `json:"is_active"`
+
+	MatchingRequestID uint             `json:"matching_request_id,omitempty"`
+	MatchingRequest   *MatchingRequest `json:"matching_request,omitempty"`
+	VisitorProjectID  uint             `json:"visitor_project_id,omitempty"`
+	VisitorProject    *VisitorProject  `json:"visitor_project,omitempty"`
+	SupplierID        uint             `json:"supplier_id,omitempty"`
+	Supplier          *Supplier        `json:"supplier,omitempty"`
+	VisitorID         uint             `json:"visitor_id,omitempty"`
+	Visitor           *Visitor         `json:"visitor,omitempty"`
+}
+
+// BuildConversationViews loads the context records of conversations (which
+// must have their participants loaded). Unread counts are for userID; pass 0
+// to skip them.
+func BuildConversationViews(db *gorm.DB, conversations []Conversation, userID uint) ([]ConversationView, error) {
+	var conversationIDs, requestIDs, projectIDs, supplierIDs, visitorIDs []uint
+	for _, conv := range conversations {
+		conversationIDs = append(conversationIDs, conv.ID)
+		switch conv.ContextType {
+		case ConversationContextMatchingRequest:
+			requestIDs = append(requestIDs, conv.ContextID)
+		case ConversationContextVisitorProject:
+			projectIDs = append(projectIDs, conv.ContextID)
+		}
+		for _, p := range conv.Participants {
+			switch p.Role {
+			case ConversationRoleSupplier:
+				supplierIDs = append(supplierIDs, p.ProfileID)
+			case ConversationRoleVisitor:
+				visitorIDs = append(visitorIDs, p.ProfileID)
+			}
+		}
+	}
+
+	requests := make(map[uint]*MatchingRequest)
+	if len(requestIDs) > 0 {
+		var rows []MatchingRequest
+		if err := db.Where("id IN ?", requestIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			requests[rows[i].ID] = &rows[i]
+		}
+	}
+	projects := make(map[uint]*VisitorProject)
+	if len(projectIDs) > 0 {
+		var rows []VisitorProject
+		if err := db.Where("id IN ?", projectIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			projects[rows[i].ID] = &rows[i]
+		}
+	}
+	suppliers := make(map[uint]*Supplier)
+	if len(supplierIDs) > 0 {
+		var rows []Supplier
+		if err := db.Where("id IN ?", supplierIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			suppliers[rows[i].ID] = &rows[i]
+		}
+	}
+	visitors := make(map[uint]*Visitor)
+	if len(visitorIDs) > 0 {
+		var rows []Visitor
+		if err := db.Where("id IN ?", visitorIDs).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			visitors[rows[i].ID] = &rows[i]
+		}
+	}
+
+	unread := make(map[uint]int64)
+	if userID != 0 {
+		var err error
+		if unread, err = GetConversationUnreadCounts(db, userID, conversationIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	views := make([]ConversationView, 0, len(conversations))
+	for _, conv := range conversations {
+		view := ConversationView{
+			Conversation: conv,
+			UnreadCount:  unread[conv.ID],
+			IsActive:     conv.Status != ConversationStatusClosed,
+		}
+		switch conv.ContextType {
+		case ConversationContextMatchingRequest:
+			view.MatchingRequestID = conv.ContextID
+			view.MatchingRequest = requests[conv.ContextID]
+		case ConversationContextVisitorProject:
+			view.VisitorProjectID = conv.ContextID
+			view.VisitorProject = projects[conv.ContextID]
+		}
+		if p := conv.ParticipantByRole(ConversationRoleSupplier); p != nil {
+			view.SupplierID = p.ProfileID
+			view.Supplier = suppliers[p.ProfileID]
+		}
+		if p := conv.ParticipantByRole(ConversationRoleVisitor); p != nil {
+			view.VisitorID = p.ProfileID
+			view.Visitor = visitors[p.ProfileID]
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
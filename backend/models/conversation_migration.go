@@ -0,0 +1,304 @@
+package models
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Legacy sources of migrated conversation messages
+const (
+	legacySourceMatchingMessage       = "matching_message"
+	legacySourceVisitorProjectMessage = "visitor_project_message"
+)
+
+// legacyChatMessage is a message from either of the old chat tables
+type legacyChatMessage struct {
+	ID         uint
+	SenderID   uint
+	SenderType string
+	Message    string
+	ImageURL   string
+	IsRead     bool
+	ReadAt     *time.Time
+	CreatedAt  time.Time
+}
+
+// legacyChatBatchSize is how many old chats are migrated per round of queries
+const legacyChatBatchSize = 100
+
+// MigrateLegacyChats moves matching chats and visitor project chats into
+// conversations, keeping message order, timestamps, images and read state.
+// Messages copied by an earlier run are skipped, so a run that failed half
+// way can be retried. It runs once, from RunDataMigrations.
+func MigrateLegacyChats(db *gorm.DB) error {
+	var matchingChats []MatchingChat
+	err := db.Order("id").FindInBatches(&matchingChats, legacyChatBatchSize, func(_ *gorm.DB, _ int) error {
+		chatIDs := make([]uint, len(matchingChats))
+		for i, chat := range matchingChats {
+			chatIDs[i] = chat.ID
+		}
+		var messages []MatchingMessage
+		if err := db.Where("matching_chat_id IN ?", chatIDs).Order("created_at ASC, id ASC").Find(&messages).Error; err != nil {
+			return err
+		}
+		byChat := make(map[uint][]legacyChatMessage, len(matchingChats))
+		for _, m := range messages {
+			byChat[m.MatchingChatID] = append(byChat[m.MatchingChatID], legacyChatMessage{
+				ID: m.ID, SenderID: m.SenderID, SenderType: m.SenderType, Message: m.Message,
+				ImageURL: m.ImageURL, IsRead: m.IsRead, ReadAt: m.ReadAt, CreatedAt: m.CreatedAt,
+			})
+		}
+
+		for _, chat := range matchingChats {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				conv, err := GetOrCreateConversation(tx, ConversationContextMatchingRequest, chat.MatchingRequestID,
+					MatchingConversationKey(chat.MatchingRequestID, chat.VisitorID), []ConversationMember{
+						{UserID: chat.SupplierUserID, Role: ConversationRoleSupplier, ProfileID: chat.SupplierID},
+						{UserID: chat.VisitorUserID, Role: ConversationRoleVisitor, ProfileID: chat.VisitorID},
+					})
+				if err != nil {
+					return err
+				}
+				if !chat.IsActive {
+					if err := tx.Model(conv).Update("status", ConversationStatusClosed).Error; err != nil {
+						return err
+					}
+				}
+				return copyLegacyMessages(tx, conv, legacySourceMatchingMessage, byChat[chat.ID])
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var projectChats []VisitorProjectChat
+	return db.Preload("Visitor").Preload("Supplier").Order("id").FindInBatches(&projectChats, legacyChatBatchSize, func(_ *gorm.DB, _ int) error {
+		chatIDs := make([]uint, len(projectChats))
+		for i, chat := range projectChats {
+			chatIDs[i] = chat.ID
+		}
+		var messages []VisitorProjectMessage
+		if err := db.Where("chat_id IN ?", chatIDs).Order("created_at ASC, id ASC").Find(&messages).Error; err != nil {
+			return err
+		}
+		byChat := make(map[uint][]legacyChatMessage, len(projectChats))
+		for _, m := range messages {
+			byChat[m.ChatID] = append(byChat[m.ChatID], legacyChatMessage{
+				ID: m.ID, SenderID: m.SenderID, SenderType: m.SenderType, Message: m.Message,
+				ImageURL: m.ImageURL, IsRead: m.IsRead, CreatedAt: m.CreatedAt,
+			})
+		}
+
+		for _, chat := range projectChats {
+			if chat.Visitor.UserID == 0 || chat.Supplier.UserID == 0 {
+				log.Printf("MigrateLegacyChats: visitor project chat %d has no visitor or supplier, skipping", chat.ID)
+				continue
+			}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				conv, err := GetOrCreateConversation(tx, ConversationContextVisitorProject, chat.VisitorProjectID,
+					VisitorProjectConversationKey(chat.VisitorProjectID, chat.SupplierID), []ConversationMember{
+						{UserID: chat.Visitor.UserID, Role: ConversationRoleVisitor, ProfileID: chat.VisitorID},
+						{UserID: chat.Supplier.UserID, Role: ConversationRoleSupplier, ProfileID: chat.SupplierID},
+					})
+				if err != nil {
+					return err
+				}
+				if chat.Status == ConversationStatusClosed {
+					if err := tx.Model(conv).Update("status", ConversationStatusClosed).Error; err != nil {
+						return err
+					}
+				}
+				return copyLegacyMessages(tx, conv, legacySourceVisitorProjectMessage, byChat[chat.ID])
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// RekeyMatchingConversations moves matching conversations opened before
+// their key named the visitor ("matching_request:<id>") to
+// MatchingConversationKey. If the visitor has since opened the conversation
+// under the new key, the old one is merged into it.
+func RekeyMatchingConversations(db *gorm.DB) error {
+	var conversations []Conversation
+	if err := db.Preload("Participants").
+		Where("context_type = ? AND context_key NOT LIKE ?", ConversationContextMatchingRequest, "%:visitor:%").
+		Find(&conversations).Error; err != nil {
+		return err
+	}
+
+	for _, conv := range conversations {
+		var visitorID uint
+		for _, p := range conv.Participants {
+			if p.Role == ConversationRoleVisitor {
+				visitorID = p.ProfileID
+				break
+			}
+		}
+		if visitorID == 0 {
+			log.Printf("RekeyMatchingConversations: conversation %d has no visitor, skipping", conv.ID)
+			continue
+		}
+		key := MatchingConversationKey(conv.ContextID, visitorID)
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var target Conversation
+			err := tx.Where("context_key = ?", key).First(&target).Error
+			if err == gorm.ErrRecordNotFound {
+				return tx.Model(&Conversation{}).Where("id = ?", conv.ID).Update("context_key", key).Error
+			}
+			if err != nil {
+				return err
+			}
+			return mergeConversation(tx, &conv, &target)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeConversation moves the messages, participants and offers of from into
+// into and deletes from
+func mergeConversation(tx *gorm.DB, from, into *Conversation) error {
+	var offers, targetOffers int64
+	if err := tx.Model(&NegotiationOffer{}).Where("conversation_id = ?", from.ID).Count(&offers).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&NegotiationOffer{}).Where("conversation_id = ?", into.ID).Count(&targetOffers).Error; err != nil {
+		return err
+	}
+	if offers > 0 && targetOffers > 0 {
+		// Offer versions would collide; both negotiations are kept as they are
+		log.Printf("RekeyMatchingConversations: conversations %d and %d both have offers, not merging", from.ID, into.ID)
+		return nil
+	}
+	if err := tx.Model(&NegotiationOffer{}).Where("conversation_id = ?", from.ID).Update("conversation_id", into.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&ConversationMessage{}).Where("conversation_id = ?", from.ID).Update("conversation_id", into.ID).Error; err != nil {
+		return err
+	}
+
+	for _, p := range from.Participants {
+		participant := ConversationParticipant{ConversationID: into.ID, UserID: p.UserID, Role: p.Role, ProfileID: p.ProfileID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&participant).Error; err != nil {
+			return err
+		}
+		// Message IDs grow over both conversations, so the later cursor wins
+		if err := tx.Model(&ConversationParticipant{}).
+			Where("conversation_id = ? AND user_id = ? AND last_read_message_id < ?", into.ID, p.UserID, p.LastReadMessageID).
+			Updates(map[string]interface{}{"last_read_message_id": p.LastReadMessageID, "last_read_at": p.LastReadAt}).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("conversation_id = ?", from.ID).Delete(&ConversationParticipant{}).Error; err != nil {
+		return err
+	}
+
+	if from.LastMessageAt != nil && (into.LastMessageAt == nil || from.LastMessageAt.After(*into.LastMessageAt)) {
+		if err := tx.Model(&Conversation{}).Where("id = ?", into.ID).Updates(map[string]interface{}{
+			"last_message_at":      from.LastMessageAt,
+			"last_message_preview": from.LastMessagePreview,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&Conversation{}, from.ID).Error
+}
+
+// copyLegacyMessages inserts the messages of one old chat that were not
+// copied yet, in order, and moves each participant's read cursor to the last
+// copied message they had read
+func copyLegacyMessages(tx *gorm.DB, conv *Conversation, source string, messages []legacyChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	legacyIDs := make([]uint, 0, len(messages))
+	for _, m := range messages {
+		legacyIDs = append(legacyIDs, m.ID)
+	}
+	var copiedIDs []uint
+	if err := tx.Model(&ConversationMessage{}).
+		Where("legacy_source = ? AND legacy_id IN ?", source, legacyIDs).
+		Pluck("legacy_id", &copiedIDs).Error; err != nil {
+		return err
+	}
+	copied := make(map[uint]bool, len(copiedIDs))
+	for _, id := range copiedIDs {
+		copied[id] = true
+	}
+
+	type cursor struct {
+		messageID uint
+		readAt    time.Time
+	}
+	cursors := make(map[uint]cursor)
+	var last *ConversationMessage
+	for _, m := range messages {
+		if copied[m.ID] {
+			continue
+		}
+		legacyID := m.ID
+		message := ConversationMessage{
+			ConversationID: conv.ID,
+			SenderID:       m.SenderID,
+			SenderType:     m.SenderType,
+			Message:        m.Message,
+			LegacySource:   source,
+			LegacyID:       &legacyID,
+			CreatedAt:      m.CreatedAt,
+			UpdatedAt:      m.CreatedAt,
+		}
+		if m.ImageURL != "" {
+			message.Attachments = []ConversationAttachment{{
+				URL:       m.ImageURL,
+				MimeType:  attachmentMimeType(m.ImageURL),
+				CreatedAt: m.CreatedAt,
+			}}
+		}
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		last = &message
+
+		if m.IsRead {
+			readAt := m.CreatedAt
+			if m.ReadAt != nil {
+				readAt = *m.ReadAt
+			}
+			for _, userID := range conv.OtherParticipants(m.SenderID) {
+				cursors[userID] = cursor{messageID: message.ID, readAt: readAt}
+			}
+		}
+	}
+
+	for userID, c := range cursors {
+		if err := tx.Model(&ConversationParticipant{}).
+			Where("conversation_id = ? AND user_id = ? AND last_read_message_id < ?", conv.ID, userID, c.messageID).
+			Updates(map[string]interface{}{"last_read_message_id": c.messageID, "last_read_at": c.readAt}).Error; err != nil {
+			return err
+		}
+	}
+
+	if last != nil && (conv.LastMessageAt == nil || !last.CreatedAt.Before(*conv.LastMessageAt)) {
+		return tx.Model(&Conversation{}).Where("id = ?", conv.ID).Updates(map[string]interface{}{
+			"last_message_at":      last.CreatedAt,
+			"last_message_preview": conversationPreview(last),
+		}).Error
+	}
+	return nil
+}
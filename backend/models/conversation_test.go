@@ -0,0 +1,280 @@
+package models_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"gorm.io/gorm"
+)
+
+func TestConversationMessagesAndReadCursor(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	alice := testutil.CreateUser(t, db, "09120000011")
+	bob := testutil.CreateUser(t, db, "09120000012")
+	outsider := testutil.CreateUser(t, db, "09120000013")
+
+	conv, err := models.GetOrCreateDirectConversation(db, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("open conversation: %v", err)
+	}
+	again, err := models.GetOrCreateDirectConversation(db, bob.ID, alice.ID)
+	if err != nil || again.ID != conv.ID || len(again.Participants) != 2 {
+		t.Fatalf("reopen from the other side = %+v, %v; want conversation %d with 2 participants", again, err, conv.ID)
+	}
+
+	if _, err := models.CreateConversationMessage(db, conv, outsider.ID, "hi", nil); !errors.Is(err, models.ErrNotConversationParticipant) {
+		t.Fatalf("outsider send error = %v", err)
+	}
+	if _, err := models.CreateConversationMessage(db, conv, alice.ID, "  ", nil); !errors.Is(err, models.ErrEmptyConversationMessage) {
+		t.Fatalf("empty send error = %v", err)
+	}
+
+	first, err := models.CreateConversationMessage(db, conv, alice.ID, "سلام", nil)
+	if err != nil {
+		t.Fatalf("send text: %v", err)
+	}
+	photo, err := models.CreateConversationMessage(db, conv, alice.ID, "", []models.ConversationAttachmentInput{{URL: "/uploads/chat/a.png"}})
+	if err != nil {
+		t.Fatalf("send image: %v", err)
+	}
+	if len(photo.Attachments) != 1 || photo.Attachments[0].MimeType != "image/png" || photo.Attachments[0].FileName != "a.png" {
+		t.Fatalf("attachment = %+v", photo.Attachments)
+	}
+
+	counts, err := models.GetConversationUnreadCounts(db, bob.ID, []uint{conv.ID})
+	if err != nil || counts[conv.ID] != 2 {
+		t.Fatalf("bob unread = %v, %v; want 2", counts, err)
+	}
+	if counts, _ := models.GetConversationUnreadCounts(db, alice.ID, []uint{conv.ID}); counts[conv.ID] != 0 {
+		t.Fatalf("sender has %d unread of their own messages", counts[conv.ID])
+	}
+
+	if n, err := models.MarkConversationRead(db, conv.ID, bob.ID); err != nil || n != 2 {
+		t.Fatalf("mark read = %d, %v; want 2", n, err)
+	}
+	if n, _ := models.MarkConversationRead(db, conv.ID, bob.ID); n != 0 {
+		t.Fatalf("second mark read = %d, want 0", n)
+	}
+	if _, err := models.MarkConversationRead(db, conv.ID, outsider.ID); !errors.Is(err, models.ErrNotConversationParticipant) {
+		t.Fatalf("outsider mark read error = %v", err)
+	}
+
+	conv, _ = models.GetConversation(db, conv.ID)
+	messages, total, err := models.GetConversationMessages(db, conv.ID, 1, 50)
+	if err != nil || total != 2 {
+		t.Fatalf("messages total = %d, %v", total, err)
+	}
+	models.FillConversationMessages(conv, messages)
+	if !messages[0].IsRead || !messages[1].IsRead || messages[1].ImageURL != "/uploads/chat/a.png" {
+		t.Fatalf("filled messages = %+v", messages)
+	}
+
+	if _, err := models.EditConversationMessage(db, conv.ID, first.ID, bob.ID, "x"); !errors.Is(err, models.ErrConversationMessageNotOwned) {
+		t.Fatalf("edit by other error = %v", err)
+	}
+	edited, err := models.EditConversationMessage(db, conv.ID, first.ID, alice.ID, "سلام دوباره")
+	if err != nil || edited.Message != "سلام دوباره" || edited.EditedAt == nil {
+		t.Fatalf("edit = %+v, %v", edited, err)
+	}
+
+	removed, err := models.DeleteConversationMessage(db, conv.ID, photo.ID, alice.ID)
+	if err != nil || removed.RemovedAt == nil || len(removed.Attachments) != 0 {
+		t.Fatalf("delete = %+v, %v", removed, err)
+	}
+	if _, err := models.EditConversationMessage(db, conv.ID, photo.ID, alice.ID, "x"); !errors.Is(err, models.ErrConversationMessageRemoved) {
+		t.Fatalf("edit deleted message error = %v", err)
+	}
+	if _, err := models.DeleteConversationMessage(db, conv.ID+1, first.ID, alice.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("delete through another conversation error = %v", err)
+	}
+	conv, _ = models.GetConversation(db, conv.ID)
+	if conv.LastMessagePreview != "پیام حذف شد" {
+		t.Fatalf("preview after delete = %q", conv.LastMessagePreview)
+	}
+
+	if err := db.Model(conv).Update("status", models.ConversationStatusClosed).Error; err != nil {
+		t.Fatal(err)
+	}
+	conv.Status = models.ConversationStatusClosed
+	if _, err := models.CreateConversationMessage(db, conv, bob.ID, "hi", nil); !errors.Is(err, models.ErrConversationClosed) {
+		t.Fatalf("send to closed conversation error = %v", err)
+	}
+}
+
+func TestMigrateLegacyChats(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	supplierUser := testutil.CreateUser(t, db, "09120000021")
+	visitorUser := testutil.CreateUser(t, db, "09120000022")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "زعفران")
+
+	request := models.MatchingRequest{
+		SupplierID: supplier.ID, UserID: supplierUser.ID, ProductName: "زعفران", Quantity: "10", Unit: "kg",
+		DestinationCountries: "AE", Price: "1000", Currency: "USD", ExpiresAt: time.Now().Add(time.Hour),
+		Status: "accepted", AcceptedVisitorID: &visitor.ID,
+	}
+	if err := db.Create(&request).Error; err != nil {
+		t.Fatal(err)
+	}
+	chat := models.MatchingChat{
+		MatchingRequestID: request.ID, SupplierID: supplier.ID, VisitorID: visitor.ID,
+		SupplierUserID: supplierUser.ID, VisitorUserID: visitorUser.ID, IsActive: true,
+	}
+	if err := db.Create(&chat).Error; err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().Add(-time.Hour)
+	readAt := base.Add(30 * time.Minute)
+	// Inserted out of order: the migration must follow created_at
+	legacy := []models.MatchingMessage{
+		{MatchingChatID: chat.ID, SenderID: supplierUser.ID, SenderType: "supplier", Message: "third", CreatedAt: base.Add(3 * time.Minute)},
+		{MatchingChatID: chat.ID, SenderID: supplierUser.ID, SenderType: "supplier", Message: "first", IsRead: true, ReadAt: &readAt, CreatedAt: base.Add(time.Minute)},
+		{MatchingChatID: chat.ID, SenderID: visitorUser.ID, SenderType: "visitor", ImageURL: "/uploads/chat/b.jpg", IsRead: true, CreatedAt: base.Add(2 * time.Minute)},
+	}
+	for i := range legacy {
+		if err := db.Create(&legacy[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	project := models.VisitorProject{
+		VisitorID: visitor.ID, UserID: visitorUser.ID, ProjectTitle: "p", ProductName: "p", Quantity: "1", Unit: "kg",
+		TargetCountries: "AE", Currency: "USD", ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := db.Create(&project).Error; err != nil {
+		t.Fatal(err)
+	}
+	projectChat := models.VisitorProjectChat{VisitorProjectID: project.ID, VisitorID: visitor.ID, SupplierID: supplier.ID, Status: "closed"}
+	if err := db.Create(&projectChat).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.VisitorProjectMessage{ChatID: projectChat.ID, SenderID: visitorUser.ID, SenderType: "visitor", Message: "hello"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := models.MigrateLegacyChats(db); err != nil {
+			t.Fatalf("migrate run %d: %v", run, err)
+		}
+	}
+
+	conv, err := models.GetOrCreateMatchingConversation(db, request.ID, visitor.ID)
+	if err != nil {
+		t.Fatalf("matching conversation: %v", err)
+	}
+	messages, total, _ := models.GetConversationMessages(db, conv.ID, 1, 50)
+	if total != 3 {
+		t.Fatalf("migrated %d matching messages, want 3 (rerun must not duplicate)", total)
+	}
+	if messages[0].Message != "first" || messages[2].Message != "third" || !messages[0].CreatedAt.Equal(legacy[1].CreatedAt) {
+		t.Fatalf("message order = %q, %q, %q", messages[0].Message, messages[1].Message, messages[2].Message)
+	}
+	if len(messages[1].Attachments) != 1 || messages[1].Attachments[0].MimeType != "image/jpeg" {
+		t.Fatalf("image attachment = %+v", messages[1].Attachments)
+	}
+	if conv.LastMessagePreview != "third" {
+		t.Fatalf("preview = %q", conv.LastMessagePreview)
+	}
+
+	unread, _ := models.GetConversationUnreadCounts(db, visitorUser.ID, []uint{conv.ID})
+	if unread[conv.ID] != 1 {
+		t.Fatalf("visitor unread = %d, want 1 (only the unread legacy message)", unread[conv.ID])
+	}
+	unread, _ = models.GetConversationUnreadCounts(db, supplierUser.ID, []uint{conv.ID})
+	if unread[conv.ID] != 0 {
+		t.Fatalf("supplier unread = %d, want 0", unread[conv.ID])
+	}
+	if p := conv.Participant(visitorUser.ID); p == nil || p.LastReadAt == nil || !p.LastReadAt.Equal(readAt) {
+		t.Fatalf("visitor read cursor = %+v", p)
+	}
+
+	projectConv, err := models.GetOrCreateVisitorProjectConversation(db, project.ID, visitor.ID, supplier.ID)
+	if err != nil {
+		t.Fatalf("visitor project conversation: %v", err)
+	}
+	if projectConv.Status != models.ConversationStatusClosed {
+		t.Fatalf("closed legacy chat migrated as %q", projectConv.Status)
+	}
+	if _, total, _ := models.GetConversationMessages(db, projectConv.ID, 1, 50); total != 1 {
+		t.Fatalf("migrated %d visitor project messages, want 1", total)
+	}
+
+	// On startup the migration runs once: later starts skip it
+	for run := 0; run < 2; run++ {
+		if err := models.RunDataMigrations(db); err != nil {
+			t.Fatalf("data migrations run %d: %v", run, err)
+		}
+		if run == 0 {
+			late := models.MatchingMessage{MatchingChatID: chat.ID, SenderID: visitorUser.ID, SenderType: "visitor", Message: "late"}
+			if err := db.Create(&late).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, total, _ := models.GetConversationMessages(db, conv.ID, 1, 50); total != 3 {
+		t.Fatalf("%d matching messages after startup runs, want 3", total)
+	}
+	var markers int64
+	db.Model(&models.DataMigration{}).Where("name = ?", "migrate_legacy_chats").Count(&markers)
+	if markers != 1 {
+		t.Fatalf("%d migrate_legacy_chats markers", markers)
+	}
+}
+
+func TestRekeyMatchingConversations(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	supplierUser := testutil.CreateUser(t, db, "09120000031")
+	visitorUser := testutil.CreateUser(t, db, "09120000032")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "زعفران")
+	members := []models.ConversationMember{
+		{UserID: supplierUser.ID, Role: models.ConversationRoleSupplier, ProfileID: supplier.ID},
+		{UserID: visitorUser.ID, Role: models.ConversationRoleVisitor, ProfileID: visitor.ID},
+	}
+
+	// Request 1 only has an old-format conversation; request 2 was also
+	// opened under the new key since
+	old1, err := models.GetOrCreateConversation(db, models.ConversationContextMatchingRequest, 1, "matching_request:1", members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old2, err := models.GetOrCreateConversation(db, models.ConversationContextMatchingRequest, 2, "matching_request:2", members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreateConversationMessage(db, old2, supplierUser.ID, "old", nil); err != nil {
+		t.Fatal(err)
+	}
+	current, err := models.GetOrCreateConversation(db, models.ConversationContextMatchingRequest, 2, models.MatchingConversationKey(2, visitor.ID), members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreateConversationMessage(db, current, visitorUser.ID, "new", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := models.RekeyMatchingConversations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	db.Model(&models.Conversation{}).Order("id").Pluck("context_key", &keys)
+	want := []string{models.MatchingConversationKey(1, visitor.ID), models.MatchingConversationKey(2, visitor.ID)}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	var renamed models.Conversation
+	db.Where("context_key = ?", want[0]).First(&renamed)
+	if renamed.ID != old1.ID {
+		t.Fatalf("request 1 conversation %d replaced by %d", old1.ID, renamed.ID)
+	}
+	messages, total, _ := models.GetConversationMessages(db, current.ID, 1, 50)
+	if total != 2 || messages[0].Message != "old" || messages[1].Message != "new" {
+		t.Fatalf("merged messages = %+v", messages)
+	}
+}
@@ -47,29 +47,30 @@ func GetDailyLimits(db *gorm.DB, userID uint, date time.Time) (*DailyViewLimit,
 
 // CanViewVisitor checks if user can view another visitor today
 func CanViewVisitor(db *gorm.DB, userID uint, licenseType string) (bool, error) {
+	plan, err := GetLicensePlan(db, licenseType)
+	if err != nil {
+		return false, err
+	}
 	limits, err := GetDailyLimits(db, userID, time.Now())
 	if err != nil {
 		return false, err
 	}
 
-	maxViews := 3 // Default for both plus and pro
-
-	return limits.VisitorViews < maxViews, nil
+	return quotaAllows(plan.DailyVisitorViews, limits.VisitorViews), nil
 }
 
 // CanViewSupplier checks if user can view another supplier today
 func CanViewSupplier(db *gorm.DB, userID uint, licenseType string) (bool, error) {
-	limits, err := GetDailyLimits(db, userID, time.Now())
+	plan, err := GetLicensePlan(db, licenseType)
 	if err != nil {
 		return false, err
 	}
-
-	maxViews := 3 // Default for plus
-	if licenseType == "pro" {
-		maxViews = 6
+	limits, err := GetDailyLimits(db, userID, time.Now())
+	if err != nil {
+		return false, err
 	}
 
-	return limits.SupplierViews < maxViews, nil
+	return quotaAllows(plan.DailySupplierViews, limits.SupplierViews), nil
 }
 
 // IncrementVisitorView increments visitor view count
@@ -94,17 +95,16 @@ func IncrementSupplierView(db *gorm.DB, userID uint) error {
 
 // CanViewAvailableProduct checks if user can view available products today
 func CanViewAvailableProduct(db *gorm.DB, userID uint, licenseType string) (bool, error) {
-	limits, err := GetDailyLimits(db, userID, time.Now())
+	plan, err := GetLicensePlan(db, licenseType)
 	if err != nil {
 		return false, err
 	}
-
-	maxViews := 3 // Default for plus
-	if licenseType == "pro" {
-		maxViews = 6
+	limits, err := GetDailyLimits(db, userID, time.Now())
+	if err != nil {
+		return false, err
 	}
 
-	return limits.AvailableProductViews < maxViews, nil
+	return quotaAllows(plan.DailyAvailableProductViews, limits.AvailableProductViews), nil
 }
 
 // IncrementAvailableProductView increments available product view count
@@ -117,35 +117,20 @@ func IncrementAvailableProductView(db *gorm.DB, userID uint) error {
 	return db.Model(limits).Update("available_product_views", limits.AvailableProductViews+1).Error
 }
 
-// GetRemainingLimits returns remaining views for today
+// GetRemainingLimits returns remaining views for today. Unlimited quotas
+// report -1.
 func GetRemainingLimits(db *gorm.DB, userID uint, licenseType string) (int, int, int, error) {
-	limits, err := GetDailyLimits(db, userID, time.Now())
+	plan, err := GetLicensePlan(db, licenseType)
 	if err != nil {
 		return 0, 0, 0, err
 	}
-
-	visitorMax := 3
-	supplierMax := 3
-	availableProductMax := 3
-	if licenseType == "pro" {
-		supplierMax = 6
-		availableProductMax = 6
-	}
-
-	visitorRemaining := visitorMax - limits.VisitorViews
-	if visitorRemaining < 0 {
-		visitorRemaining = 0
-	}
-
-	supplierRemaining := supplierMax - limits.SupplierViews
-	if supplierRemaining < 0 {
-		supplierRemaining = 0
-	}
-
-	availableProductRemaining := availableProductMax - limits.AvailableProductViews
-	if availableProductRemaining < 0 {
-		availableProductRemaining = 0
+	limits, err := GetDailyLimits(db, userID, time.Now())
+	if err != nil {
+		return 0, 0, 0, err
 	}
 
-	return visitorRemaining, supplierRemaining, availableProductRemaining, nil
+	return quotaRemaining(plan.DailyVisitorViews, limits.VisitorViews),
+		quotaRemaining(plan.DailySupplierViews, limits.SupplierViews),
+		quotaRemaining(plan.DailyAvailableProductViews, limits.AvailableProductViews),
+		nil
 }
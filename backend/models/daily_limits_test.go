@@ -0,0 +1,63 @@
+package models_test
+
+import (
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestCanViewSupplierByLicenseType(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	plusUser := testutil.CreateUser(t, db, "09120000001")
+	proUser := testutil.CreateUser(t, db, "09120000002")
+
+	cases := []struct {
+		userID      uint
+		licenseType string
+		allowed     int
+	}{
+		{plusUser.ID, "plus", 3},
+		{proUser.ID, "pro", 6},
+	}
+
+	for _, tc := range cases {
+		for i := 0; i < tc.allowed; i++ {
+			ok, err := models.CanViewSupplier(db, tc.userID, tc.licenseType)
+			if err != nil {
+				t.Fatalf("%s: can view supplier: %v", tc.licenseType, err)
+			}
+			if !ok {
+				t.Fatalf("%s: view %d rejected, want %d allowed", tc.licenseType, i+1, tc.allowed)
+			}
+			if err := models.IncrementSupplierView(db, tc.userID); err != nil {
+				t.Fatalf("%s: increment supplier view: %v", tc.licenseType, err)
+			}
+		}
+
+		ok, err := models.CanViewSupplier(db, tc.userID, tc.licenseType)
+		if err != nil {
+			t.Fatalf("%s: can view supplier: %v", tc.licenseType, err)
+		}
+		if ok {
+			t.Fatalf("%s: view %d allowed past the daily limit", tc.licenseType, tc.allowed+1)
+		}
+	}
+}
+
+func TestCanViewVisitorDailyLimit(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := models.CanViewVisitor(db, user.ID, "pro"); !ok {
+			t.Fatalf("visitor view %d rejected", i+1)
+		}
+		if err := models.IncrementVisitorView(db, user.ID); err != nil {
+			t.Fatalf("increment visitor view: %v", err)
+		}
+	}
+	if ok, _ := models.CanViewVisitor(db, user.ID, "pro"); ok {
+		t.Fatal("fourth visitor view allowed")
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DataMigration marks a one-time data migration as applied, so later starts
+// skip it
+type DataMigration struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"size:100;not null;uniqueIndex"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// dataMigration is a named step that rewrites existing rows after the schema
+// is migrated
+type dataMigration struct {
+	name string
+	run  func(db *gorm.DB) error
+}
+
+// dataMigrations run in this order, each until it succeeds once. A step is
+// never renamed, or it runs again.
+var dataMigrations = []dataMigration{
+	// Before migrate_legacy_chats, which opens conversations by the new key
+	{name: "rekey_matching_conversations", run: RekeyMatchingConversations},
+	{name: "migrate_legacy_chats", run: MigrateLegacyChats},
+	{name: "backfill_push_subscription_transports", run: BackfillPushSubscriptionTransports},
+}
+
+// RunDataMigrations applies the data migrations that have not been applied
+// yet. It stops at the first failure, which is not marked and is retried on
+// the next start.
+func RunDataMigrations(db *gorm.DB) error {
+	var applied []string
+	if err := db.Model(&DataMigration{}).Pluck("name", &applied).Error; err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(applied))
+	for _, name := range applied {
+		done[name] = true
+	}
+
+	for _, m := range dataMigrations {
+		if done[m.name] {
+			continue
+		}
+		if err := m.run(db); err != nil {
+			return fmt.Errorf("data migration %s: %w", m.name, err)
+		}
+		// Another instance starting at the same time may have marked it too
+		marker := DataMigration{Name: m.name, AppliedAt: time.Now()}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&marker).Error; err != nil {
+			return err
+		}
+		log.Printf("Data migration %s applied", m.name)
+	}
+	return nil
+}
@@ -32,15 +32,55 @@ func ConnectDatabase() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	DB = database
+	SetDB(database)
 	log.Println("Database connected successfully")
 
-	// Auto-migrate models
-	if err := database.AutoMigrate(&User{}, &Chat{}, &Message{}, &License{}, &Supplier{}, &SupplierProduct{}, &Visitor{}, &ResearchProduct{}, &MarketingPopup{}, &AvailableProduct{}, &DailyViewLimit{}, &ContactViewLimit{}, &DailyContactViewLimit{}, &WithdrawalRequest{}, &UserProgress{}, &TrainingCategory{}, &TrainingVideo{}, &UpgradeRequest{}, &VideoWatch{}, &AIUsage{}, &SpotPlayerLicense{}, &SupportTicket{}, &SupportTicketMessage{}, &Notification{}, &TelegramAdmin{}, &WebAdmin{}, &Affiliate{}, &AffiliateWithdrawalRequest{}, &AffiliateRegisteredUser{}, &AffiliateBuyer{}, &AffiliateSettings{}, &MatchingRequest{}, &MatchingResponse{}, &MatchingRating{}, &MatchingNotification{}, &PushSubscription{}, &MatchingChat{}, &MatchingMessage{}, &Slider{}, &VisitorProject{}, &VisitorProjectProposal{}, &VisitorProjectNotification{}, &VisitorProjectChat{}, &VisitorProjectMessage{}); err != nil {
+	if err := MigrateDatabase(database); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
 	log.Println("Database migration completed")
+
+	if err := SeedAffiliateOpeningBalances(database); err != nil {
+		log.Printf("Failed to seed affiliate opening balances: %v", err)
+	}
+
+	if err := RunDataMigrations(database); err != nil {
+		log.Printf("Failed to run data migrations: %v", err)
+	}
+}
+
+// SetDB replaces the global database handle (used by tests to inject an
+// in-process database instead of MySQL)
+func SetDB(database *gorm.DB) {
+	DB = database
+}
+
+// AllModels returns every model managed by auto-migration
+func AllModels() []interface{} {
+	return []interface{}{
+		&User{}, &Chat{}, &Message{}, &License{}, &Supplier{}, &SupplierProduct{}, &Visitor{},
+		&ResearchProduct{}, &MarketingPopup{}, &AvailableProduct{}, &DailyViewLimit{},
+		&ContactViewLimit{}, &DailyContactViewLimit{}, &WithdrawalRequest{}, &UserProgress{},
+		&TrainingCategory{}, &TrainingVideo{}, &UpgradeRequest{}, &VideoWatch{}, &AIUsage{},
+		&SpotPlayerLicense{}, &SupportTicket{}, &SupportTicketMessage{}, &Notification{},
+		&TelegramAdmin{}, &WebAdmin{}, &Affiliate{}, &AffiliateWithdrawalRequest{},
+		&AffiliateRegisteredUser{}, &AffiliateBuyer{}, &AffiliateSettings{}, &MatchingRequest{},
+		&MatchingResponse{}, &MatchingRating{}, &MatchingNotification{}, &PushSubscription{},
+		&MatchingChat{}, &MatchingMessage{}, &Slider{}, &VisitorProject{}, &VisitorProjectProposal{},
+		&VisitorProjectNotification{}, &VisitorProjectChat{}, &VisitorProjectMessage{}, &OTPCode{},
+		&Order{}, &NotificationRead{}, &NotificationRecipient{}, &AdminRolePermissions{},
+		&AuditEvent{}, &AuthSession{}, &LicensePlan{}, &LicenseEvent{},
+		&LicenseBatch{}, &AffiliateLedgerEntry{}, &Conversation{}, &ConversationParticipant{},
+		&ConversationMessage{}, &ConversationAttachment{}, &NegotiationOffer{}, &WithdrawalStatusChange{}, &ExchangeRate{},
+		&NotificationJob{}, &NotificationPreference{}, &PushDeliveryMetric{},
+		&PushCampaign{}, &PushCampaignVariant{}, &PushCampaignRecipient{}, &DataMigration{},
+	}
+}
+
+// MigrateDatabase auto-migrates all models on the given connection
+func MigrateDatabase(database *gorm.DB) error {
+	return database.AutoMigrate(AllModels()...)
 }
 
 func GetDB() *gorm.DB {
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CurrencyIRR is the currency exchange rates are quoted in. Withdrawals are
+// paid to Iranian accounts in rial.
+const CurrencyIRR = "IRR"
+
+// SettlementRateMaxAgeDays is how old the latest rate of a currency may be
+// for a withdrawal to be settled with it
+const SettlementRateMaxAgeDays = 3
+
+// Exchange rate errors
+var (
+	ErrInvalidExchangeRate  = errors.New("exchange rate must be positive and its currency set")
+	ErrExchangeRateNotFound = errors.New("no exchange rate for currency")
+)
+
+// ExchangeRate is the admin-maintained rial rate of a currency for one day
+type ExchangeRate struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Currency    string    `json:"currency" gorm:"size:10;not null;uniqueIndex:idx_exchange_rate_day"`
+	Date        time.Time `json:"date" gorm:"type:date;not null;uniqueIndex:idx_exchange_rate_day"`
+	RateIRR     float64   `json:"rate_irr" gorm:"not null"` // rials per unit of Currency
+	UpdatedByID *uint     `json:"updated_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// NormalizeCurrency upper-cases a currency code
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// SetExchangeRate stores the rate of a currency for the day of date,
+// replacing the rate already entered for that day
+func SetExchangeRate(db *gorm.DB, currency string, date time.Time, rateIRR float64, updatedByID *uint) (*ExchangeRate, error) {
+	currency = NormalizeCurrency(currency)
+	if currency == "" || currency == CurrencyIRR || rateIRR <= 0 {
+		return nil, ErrInvalidExchangeRate
+	}
+
+	rate := ExchangeRate{
+		Currency:    currency,
+		Date:        startOfDay(date),
+		RateIRR:     rateIRR,
+		UpdatedByID: updatedByID,
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate_irr", "updated_by_id", "updated_at"}),
+	}).Create(&rate).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var saved ExchangeRate
+	if err := db.Where("currency = ? AND date = ?", rate.Currency, rate.Date).First(&saved).Error; err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// GetExchangeRates lists the rates of a currency (all currencies when empty),
+// newest first
+func GetExchangeRates(db *gorm.DB, currency string, limit int) ([]ExchangeRate, error) {
+	query := db.Model(&ExchangeRate{})
+	if currency = NormalizeCurrency(currency); currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var rates []ExchangeRate
+	err := query.Order("date DESC, currency ASC").Find(&rates).Error
+	return rates, err
+}
+
+// GetLatestExchangeRates returns the newest rate of every currency
+func GetLatestExchangeRates(db *gorm.DB) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+	err := db.Where("date = (SELECT MAX(r.date) FROM exchange_rates r WHERE r.currency = exchange_rates.currency)").
+		Order("currency ASC").Find(&rates).Error
+	return rates, err
+}
+
+// GetExchangeRate returns the newest rate of a currency dated on or before on.
+// The rial itself has a rate of 1 and no ID.
+func GetExchangeRate(db *gorm.DB, currency string, on time.Time) (*ExchangeRate, error) {
+	currency = NormalizeCurrency(currency)
+	if currency == CurrencyIRR {
+		return &ExchangeRate{Currency: CurrencyIRR, Date: startOfDay(on), RateIRR: 1}, nil
+	}
+
+	var rate ExchangeRate
+	err := db.Where("currency = ? AND date <= ?", currency, startOfDay(on)).Order("date DESC").First(&rate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w %s", ErrExchangeRateNotFound, currency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// GetSettlementRate returns the rate a withdrawal in currency is settled with
+// on the given day. Rates older than SettlementRateMaxAgeDays are not used.
+func GetSettlementRate(db *gorm.DB, currency string, on time.Time) (*ExchangeRate, error) {
+	rate, err := GetExchangeRate(db, currency, on)
+	if err != nil {
+		return nil, err
+	}
+	if rate.Date.Before(startOfDay(on).AddDate(0, 0, -SettlementRateMaxAgeDays)) {
+		return nil, fmt.Errorf("%w %s since %s", ErrExchangeRateNotFound, rate.Currency, rate.Date.Format("2006-01-02"))
+	}
+	return rate, nil
+}
+
+// DeleteExchangeRate removes a rate entered by mistake
+func DeleteExchangeRate(db *gorm.DB, id uint) error {
+	result := db.Delete(&ExchangeRate{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CurrencyConverter converts amounts between currencies through their rial
+// rates as of one day, loading each rate once
+type CurrencyConverter struct {
+	db    *gorm.DB
+	on    time.Time
+	rates map[string]float64
+}
+
+// NewCurrencyConverter creates a converter using the rates of the given day
+func NewCurrencyConverter(db *gorm.DB, on time.Time) *CurrencyConverter {
+	return &CurrencyConverter{db: db, on: on, rates: make(map[string]float64)}
+}
+
+// RateIRR returns the rial rate of a currency
+func (c *CurrencyConverter) RateIRR(currency string) (float64, error) {
+	currency = NormalizeCurrency(currency)
+	if rate, ok := c.rates[currency]; ok {
+		return rate, nil
+	}
+	rate, err := GetExchangeRate(c.db, currency, c.on)
+	if err != nil {
+		return 0, err
+	}
+	c.rates[currency] = rate.RateIRR
+	return rate.RateIRR, nil
+}
+
+// Convert converts amount from one currency to another
+func (c *CurrencyConverter) Convert(amount float64, from, to string) (float64, error) {
+	if NormalizeCurrency(from) == NormalizeCurrency(to) {
+		return amount, nil
+	}
+	fromRate, err := c.RateIRR(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := c.RateIRR(to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * fromRate / toRate, nil
+}
@@ -0,0 +1,135 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestExchangeRatesAndConversion(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	today := time.Now()
+
+	if _, err := models.SetExchangeRate(db, "USD", today.AddDate(0, 0, -1), 580000, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A second entry for the same day replaces the first
+	if _, err := models.SetExchangeRate(db, "USD", today, 590000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.SetExchangeRate(db, "usd", today, 600000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.SetExchangeRate(db, "AED", today.AddDate(0, 0, -2), 160000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.SetExchangeRate(db, "IRR", today, 1, nil); !errors.Is(err, models.ErrInvalidExchangeRate) {
+		t.Fatalf("IRR rate: err = %v", err)
+	}
+
+	rates, _ := models.GetExchangeRates(db, "USD", 0)
+	if len(rates) != 2 || rates[0].RateIRR != 600000 {
+		t.Fatalf("USD rates = %+v", rates)
+	}
+	latest, _ := models.GetLatestExchangeRates(db)
+	if len(latest) != 2 || latest[0].Currency != "AED" || latest[1].RateIRR != 600000 {
+		t.Fatalf("latest rates = %+v", latest)
+	}
+
+	// The newest rate on or before the day is used
+	rate, err := models.GetExchangeRate(db, "USD", today.AddDate(0, 0, -1))
+	if err != nil || rate.RateIRR != 580000 {
+		t.Fatalf("yesterday's USD rate = %+v, %v", rate, err)
+	}
+	if _, err := models.GetExchangeRate(db, "USD", today.AddDate(0, 0, -5)); !errors.Is(err, models.ErrExchangeRateNotFound) {
+		t.Fatalf("USD rate before any entry: err = %v", err)
+	}
+
+	converter := models.NewCurrencyConverter(db, today)
+	if amount, err := converter.Convert(100, "USD", "AED"); err != nil || amount != 375 {
+		t.Fatalf("100 USD in AED = %v, %v", amount, err)
+	}
+	if amount, err := converter.Convert(2, "AED", models.CurrencyIRR); err != nil || amount != 320000 {
+		t.Fatalf("2 AED in IRR = %v, %v", amount, err)
+	}
+	if _, err := converter.Convert(1, "SAR", "USD"); !errors.Is(err, models.ErrExchangeRateNotFound) {
+		t.Fatalf("SAR without rate: err = %v", err)
+	}
+
+	// Settlement refuses stale rates
+	if _, err := models.GetSettlementRate(db, "AED", today); err != nil {
+		t.Fatalf("AED settlement rate: %v", err)
+	}
+	if _, err := models.GetSettlementRate(db, "AED", today.AddDate(0, 0, models.SettlementRateMaxAgeDays)); !errors.Is(err, models.ErrExchangeRateNotFound) {
+		t.Fatalf("stale AED settlement rate: err = %v", err)
+	}
+}
+
+func TestWithdrawalStatsInBaseCurrency(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	actor := models.WithdrawalActor{Type: models.AuditActorUser, ID: &user.ID}
+
+	if _, err := models.SetExchangeRate(db, "USD", time.Now(), 600000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.SetExchangeRate(db, "AED", time.Now(), 150000, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func(amount float64, currency string) {
+		t.Helper()
+		request := models.WithdrawalRequest{UserID: user.ID, Amount: amount, Currency: currency, SourceCountry: "AE"}
+		if err := models.CreateWithdrawalRequest(db, &request); err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range []models.WithdrawalStatus{models.WithdrawalStatusApproved, models.WithdrawalStatusProcessing, models.WithdrawalStatusCompleted} {
+			if _, err := models.UpdateWithdrawalStatus(db, request.ID, status, actor, "", ""); err != nil {
+				t.Fatalf("%s: %v", status, err)
+			}
+		}
+	}
+	complete(100, "USD") // settled at 60,000,000 IRR
+	complete(400, "AED") // settled at 60,000,000 IRR
+
+	// The USD rate moves after settlement; settled amounts keep their rial value
+	if _, err := models.SetExchangeRate(db, "USD", time.Now(), 750000, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := models.WithdrawalRequest{UserID: user.ID, Amount: 50, Currency: "USD", SourceCountry: "AE"}
+	if err := models.CreateWithdrawalRequest(db, &pending); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := models.GetWithdrawalStats(db, &user.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["base_currency"] != models.CurrencyIRR || stats["total_amount"] != 120000000.0 {
+		t.Fatalf("IRR stats = %+v", stats)
+	}
+	if stats["total"] != int64(3) || stats["completed"] != int64(2) || stats["pending"] != int64(1) {
+		t.Fatalf("counts = %+v", stats)
+	}
+
+	stats, _ = models.GetWithdrawalStats(db, &user.ID, "usd")
+	if stats["base_currency"] != "USD" || stats["total_amount"] != 160.0 {
+		t.Fatalf("USD stats = %+v", stats)
+	}
+	byCurrency := stats["amounts_by_currency"].(map[string]float64)
+	if byCurrency["USD"] != 100 || byCurrency["AED"] != 400 {
+		t.Fatalf("amounts_by_currency = %+v", byCurrency)
+	}
+
+	chart, err := models.GetWithdrawalChartData(db, user.ID, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chart) != 1 || chart[0]["sales"] != 160.0 || chart[0]["count"] != 2 {
+		t.Fatalf("chart = %+v", chart)
+	}
+}
@@ -2,29 +2,59 @@ package models
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // License represents a generated license key
 type License struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Code        string         `json:"code" gorm:"uniqueIndex;size:32;not null"`
-	Type        string         `json:"type" gorm:"size:10;not null;default:'plus'"` // 'pro', 'plus', or 'plus4'
-	Duration    int            `json:"duration" gorm:"not null;default:12"`         // Duration in months
-	IsUsed      bool           `json:"is_used" gorm:"default:false"`
-	UsedBy      *uint          `json:"used_by" gorm:"index"`
-	User        *User          `json:"user,omitempty" gorm:"foreignKey:UsedBy"`
-	UsedAt      *time.Time     `json:"used_at"`
-	ExpiresAt   *time.Time     `json:"expires_at"` // When license expires for the user
-	GeneratedBy uint           `json:"generated_by" gorm:"not null"`
-	Admin       User           `json:"admin" gorm:"foreignKey:GeneratedBy"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	Code         string         `json:"code" gorm:"uniqueIndex;size:32;not null"`
+	Type         string         `json:"type" gorm:"size:10;not null;default:'plus'"` // 'pro', 'plus', or 'plus4'
+	Duration     int            `json:"duration" gorm:"not null;default:12"`         // Duration in months
+	IsUsed       bool           `json:"is_used" gorm:"default:false"`
+	UsedBy       *uint          `json:"used_by" gorm:"index"`
+	User         *User          `json:"user,omitempty" gorm:"foreignKey:UsedBy"`
+	UsedAt       *time.Time     `json:"used_at"`
+	StartsAt     *time.Time     `json:"starts_at"`  // When the license period begins; later than UsedAt for stacked renewals
+	ExpiresAt    *time.Time     `json:"expires_at"` // When license expires for the user
+	GeneratedBy  uint           `json:"generated_by" gorm:"not null"`
+	Admin        User           `json:"admin" gorm:"foreignKey:GeneratedBy"`
+	BatchID      *uint          `json:"batch_id" gorm:"index"`
+	Batch        *LicenseBatch  `json:"batch,omitempty" gorm:"foreignKey:BatchID"`
+	RevokedAt    *time.Time     `json:"revoked_at"` // revoked codes can no longer be redeemed
+	RevokeReason string         `json:"revoke_reason,omitempty" gorm:"size:255"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// License timeline statuses
+const (
+	LicenseStatusActive  = "active"
+	LicenseStatusQueued  = "queued"
+	LicenseStatusExpired = "expired"
+)
+
+// License redemption errors
+var (
+	// ErrLicenseUnavailable is returned when a code does not exist or was
+	// redeemed already (possibly by a concurrent request)
+	ErrLicenseUnavailable = errors.New("لایسنس نامعتبر یا قبلاً استفاده شده است")
+	// ErrLicenseRevoked is returned when a code was revoked by an admin
+	ErrLicenseRevoked = errors.New("این لایسنس باطل شده است")
+)
+
+// LicenseHistoryEntry is one license in a user's license timeline
+type LicenseHistoryEntry struct {
+	License
+	Status   string `json:"status"` // active, queued or expired
+	IsActive bool   `json:"is_active"`
 }
 
 // LicenseVerifyRequest represents the request to verify a license
@@ -42,19 +72,42 @@ type LicenseVerifyResponse struct {
 	RemainingHours int    `json:"remaining_hours,omitempty"`
 }
 
-// LicenseGenerateRequest represents the request to generate licenses
+// LicenseGenerateRequest represents the request to generate licenses. Every
+// generation creates a batch; the batch fields are optional.
 type LicenseGenerateRequest struct {
-	Count int    `json:"count" binding:"required,min=1,max=100"`
+	Count int    `json:"count" binding:"required,min=1,max=5000"`
 	Type  string `json:"type" binding:"required,oneof=pro plus plus4"`
+
+	BatchName      string `json:"batch_name"`
+	Channel        string `json:"channel"`
+	AffiliateID    *uint  `json:"affiliate_id"`
+	ResellerName   string `json:"reseller_name"`
+	UnitPriceToman int64  `json:"unit_price_toman"`
+	Note           string `json:"note"`
+}
+
+// BatchRequest returns the batch described by the request
+func (r LicenseGenerateRequest) BatchRequest() LicenseBatchRequest {
+	return LicenseBatchRequest{
+		Name:           r.BatchName,
+		Count:          r.Count,
+		Type:           r.Type,
+		Channel:        r.Channel,
+		AffiliateID:    r.AffiliateID,
+		ResellerName:   r.ResellerName,
+		UnitPriceToman: r.UnitPriceToman,
+		Note:           r.Note,
+	}
 }
 
 // LicenseGenerateResponse represents the response after generating licenses
 type LicenseGenerateResponse struct {
-	Message  string   `json:"message"`
-	Count    int      `json:"count"`
-	Type     string   `json:"type"`
-	Duration int      `json:"duration"`
-	Licenses []string `json:"licenses"`
+	Message  string        `json:"message"`
+	Count    int           `json:"count"`
+	Type     string        `json:"type"`
+	Duration int           `json:"duration"`
+	Licenses []string      `json:"licenses"`
+	Batch    *LicenseBatch `json:"batch,omitempty"`
 }
 
 // LicenseResponse represents a license in API responses
@@ -101,80 +154,144 @@ func GenerateLicenseCode() (string, error) {
 	return code, nil
 }
 
-// GenerateLicenses creates multiple license codes
+// GenerateLicenses creates multiple license codes outside of any batch
 func GenerateLicenses(db *gorm.DB, count int, licenseType string, adminID uint) ([]string, error) {
-	var licenses []License
-	var codes []string
+	return generateLicenses(db, count, licenseType, adminID, nil)
+}
 
-	for i := 0; i < count; i++ {
+// generateLicenses creates count unique license codes, optionally tagged
+// with a batch
+func generateLicenses(db *gorm.DB, count int, licenseType string, adminID uint, batchID *uint) ([]string, error) {
+	licenses := make([]License, 0, count)
+	codes := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+	duration := LicenseDuration(licenseType)
+
+	for len(codes) < count {
 		code, err := GenerateLicenseCode()
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate license code: %v", err)
 		}
 
 		// Check if code already exists (very unlikely but just in case)
-		var existing License
-		if err := db.Where("code = ?", code).First(&existing).Error; err == nil {
-			// Code exists, try again
-			i--
+		if seen[code] {
 			continue
 		}
-
-		// Set duration based on license type
-		duration := 12 // default for plus
-		if licenseType == "pro" {
-			duration = 30
-		} else if licenseType == "plus4" {
-			duration = 4
+		var existing int64
+		if err := db.Model(&License{}).Unscoped().Where("code = ?", code).Count(&existing).Error; err != nil {
+			return nil, fmt.Errorf("failed to check license code: %v", err)
 		}
+		if existing > 0 {
+			continue
+		}
+		seen[code] = true
 
-		license := License{
+		licenses = append(licenses, License{
 			Code:        code,
 			Type:        licenseType,
 			Duration:    duration,
 			GeneratedBy: adminID,
-		}
-
-		licenses = append(licenses, license)
+			BatchID:     batchID,
+		})
 		codes = append(codes, code)
 	}
 
 	// Batch insert
-	if err := db.Create(&licenses).Error; err != nil {
+	if err := db.CreateInBatches(&licenses, 500).Error; err != nil {
 		return nil, fmt.Errorf("failed to save licenses: %v", err)
 	}
 
 	return codes, nil
 }
 
-// UseLicense marks a license as used by a user
+// UseLicense redeems a license code for a user. The code is claimed with a
+// conditional update so concurrent redemptions of the same code cannot both
+// succeed. If the user still has time left, the new period is stacked onto
+// their latest expiry instead of starting now. The referring affiliate's
+// commission is posted in the same transaction.
 func UseLicense(db *gorm.DB, code string, userID uint) (*License, error) {
 	var license License
 
-	// Find unused license
-	if err := db.Where("code = ? AND is_used = ?", code, false).First(&license).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("لایسنس نامعتبر یا قبلاً استفاده شده است")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Serialize redemptions of the same user so stacked periods never overlap
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "affiliate_id").First(&user, userID).Error; err != nil {
+			return fmt.Errorf("خطا در بررسی کاربر: %v", err)
 		}
-		return nil, fmt.Errorf("خطا در بررسی لایسنس: %v", err)
-	}
 
-	// Mark as used
-	now := time.Now()
-	expiresAt := now.AddDate(0, license.Duration, 0) // Add months based on duration
+		if err := tx.Where("code = ? AND is_used = ?", code, false).First(&license).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrLicenseUnavailable
+			}
+			return fmt.Errorf("خطا در بررسی لایسنس: %v", err)
+		}
+		if license.RevokedAt != nil {
+			return ErrLicenseRevoked
+		}
 
-	license.IsUsed = true
-	license.UsedBy = &userID
-	license.UsedAt = &now
-	license.ExpiresAt = &expiresAt
+		now := time.Now()
+		startsAt := now
+		if latest, err := latestLicenseExpiry(tx, userID); err == nil && latest.After(now) {
+			startsAt = latest
+		}
+		expiresAt := startsAt.AddDate(0, license.Duration, 0) // Add months based on duration
+
+		result := tx.Model(&License{}).
+			Where("id = ? AND is_used = ? AND revoked_at IS NULL", license.ID, false).
+			Updates(map[string]interface{}{
+				"is_used":    true,
+				"used_by":    userID,
+				"used_at":    now,
+				"starts_at":  startsAt,
+				"expires_at": expiresAt,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("خطا در ثبت لایسنس: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrLicenseUnavailable
+		}
+
+		license.IsUsed = true
+		license.UsedBy = &userID
+		license.UsedAt = &now
+		license.StartsAt = &startsAt
+		license.ExpiresAt = &expiresAt
+
+		event := LicenseEventActivated
+		if startsAt.After(now) {
+			event = LicenseEventRenewed
+		}
+		if _, err := RecordLicenseEvent(tx, &license, event, ""); err != nil {
+			return err
+		}
 
-	if err := db.Save(&license).Error; err != nil {
-		return nil, fmt.Errorf("خطا در ثبت لایسنس: %v", err)
+		// Credit the affiliate who referred the user
+		if user.AffiliateID != nil {
+			if _, err := PostLicenseCommission(tx, &license, *user.AffiliateID); err != nil {
+				return fmt.Errorf("خطا در ثبت پورسانت همکار فروش: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &license, nil
 }
 
+// latestLicenseExpiry returns the furthest expiry among the user's licenses
+func latestLicenseExpiry(db *gorm.DB, userID uint) (time.Time, error) {
+	var license License
+	err := db.Where("used_by = ? AND is_used = ? AND expires_at IS NOT NULL", userID, true).
+		Order("expires_at DESC").First(&license).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	return *license.ExpiresAt, nil
+}
+
 // CheckUserLicense checks if user has a valid license
 func CheckUserLicense(db *gorm.DB, userID uint) (bool, error) {
 	var count int64
@@ -186,11 +303,27 @@ func CheckUserLicense(db *gorm.DB, userID uint) (bool, error) {
 	return count > 0, nil
 }
 
-// GetUserLicense gets the license used by a specific user
+// GetUserLicense returns the user's current license: the one whose period
+// covers now, or else the most recently expired one. Licenses without an
+// expiry (issued before expiries existed) count as current.
 func GetUserLicense(db *gorm.DB, userID uint) (*License, error) {
 	var license License
+	now := time.Now()
 
-	if err := db.Where("used_by = ? AND is_used = ?", userID, true).
+	err := db.Where("used_by = ? AND is_used = ?", userID, true).
+		Where("starts_at IS NULL OR starts_at <= ?", now).
+		Where("expires_at > ? OR expires_at IS NULL", now).
+		Order("COALESCE(starts_at, used_at) ASC").
+		Preload("Admin").First(&license).Error
+	if err == nil {
+		return &license, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := db.Where("used_by = ? AND is_used = ? AND expires_at <= ?", userID, true, now).
+		Order("expires_at DESC").
 		Preload("Admin").First(&license).Error; err != nil {
 		return nil, err
 	}
@@ -198,6 +331,37 @@ func GetUserLicense(db *gorm.DB, userID uint) (*License, error) {
 	return &license, nil
 }
 
+// GetUserLicenseHistory returns every license the user redeemed, oldest
+// first, with the one that is active right now marked
+func GetUserLicenseHistory(db *gorm.DB, userID uint) ([]LicenseHistoryEntry, error) {
+	var licenses []License
+	if err := db.Where("used_by = ? AND is_used = ?", userID, true).
+		Order("COALESCE(starts_at, used_at) ASC").Order("id ASC").
+		Find(&licenses).Error; err != nil {
+		return nil, err
+	}
+
+	var activeID uint
+	if current, err := GetUserLicense(db, userID); err == nil && current.ExpiresAt != nil && current.ExpiresAt.After(time.Now()) {
+		activeID = current.ID
+	}
+
+	now := time.Now()
+	entries := make([]LicenseHistoryEntry, 0, len(licenses))
+	for _, license := range licenses {
+		entry := LicenseHistoryEntry{License: license, Status: LicenseStatusExpired}
+		switch {
+		case license.ID == activeID:
+			entry.Status = LicenseStatusActive
+			entry.IsActive = true
+		case license.StartsAt != nil && license.StartsAt.After(now):
+			entry.Status = LicenseStatusQueued
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 // UpdateUserLicenseType updates a user's license type (for upgrades)
 func UpdateUserLicenseType(db *gorm.DB, userID uint, newType string) error {
 	// Update the license type and extend duration if upgrading to Pro
@@ -211,7 +375,20 @@ func UpdateUserLicenseType(db *gorm.DB, userID uint, newType string) error {
 		updates["expires_at"] = newExpiresAt
 	}
 
-	return db.Model(&License{}).Where("used_by = ? AND is_used = ?", userID, true).Updates(updates).Error
+	// Only the current license changes; past and queued ones keep their terms
+	current, err := GetUserLicense(db, userID)
+	if err != nil {
+		return err
+	}
+	if err := db.Model(&License{}).Where("id = ?", current.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if err := db.First(current, current.ID).Error; err != nil {
+		return err
+	}
+	_, err = RecordLicenseEvent(db, current, LicenseEventUpgraded, "type: "+newType)
+	return err
 }
 
 // TableName specifies the table name for License
@@ -0,0 +1,358 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// License distribution channels
+const (
+	LicenseChannelDirect    = "direct"    // sold by the team
+	LicenseChannelWebsite   = "website"   // online shop
+	LicenseChannelTelegram  = "telegram"  // sold through the Telegram bot/channel
+	LicenseChannelReseller  = "reseller"  // third-party reseller (ResellerName)
+	LicenseChannelAffiliate = "affiliate" // affiliate partner (AffiliateID)
+	LicenseChannelEvent     = "event"     // exhibitions, giveaways and promotions
+)
+
+// LicenseChannels lists the valid distribution channels, in display order
+var LicenseChannels = []string{
+	LicenseChannelDirect, LicenseChannelWebsite, LicenseChannelTelegram,
+	LicenseChannelReseller, LicenseChannelAffiliate, LicenseChannelEvent,
+}
+
+// MaxLicenseBatchSize caps how many codes a single batch can hold
+const MaxLicenseBatchSize = 5000
+
+// LicenseBatch groups license codes generated together for one sales
+// channel so they can be traced, reconciled and revoked as a unit
+type LicenseBatch struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	Name           string     `json:"name" gorm:"size:100;not null;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Type           string     `json:"type" gorm:"size:10;not null"`
+	Duration       int        `json:"duration" gorm:"not null"` // months
+	Count          int        `json:"count" gorm:"not null"`
+	Channel        string     `json:"channel" gorm:"size:20;not null;index"`
+	AffiliateID    *uint      `json:"affiliate_id" gorm:"index"`
+	Affiliate      *Affiliate `json:"affiliate,omitempty" gorm:"foreignKey:AffiliateID"`
+	ResellerName   string     `json:"reseller_name" gorm:"size:100;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	UnitPriceToman int64      `json:"unit_price_toman" gorm:"type:bigint;default:0"`
+	Note           string     `json:"note" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	CreatedByType  string     `json:"created_by_type" gorm:"size:20"` // web_admin or telegram (AuditActor*)
+	CreatedByID    uint       `json:"created_by_id"`
+	CreatedByName  string     `json:"created_by_name" gorm:"size:100"`
+	RevokedCount   int        `json:"revoked_count" gorm:"default:0"`
+	LastRevokedAt  *time.Time `json:"last_revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for LicenseBatch
+func (LicenseBatch) TableName() string {
+	return "license_batches"
+}
+
+// LicenseBatchRequest describes a batch to generate
+type LicenseBatchRequest struct {
+	Name           string `json:"name"`
+	Count          int    `json:"count" binding:"required,min=1,max=5000"`
+	Type           string `json:"type" binding:"required,oneof=pro plus plus4"`
+	Channel        string `json:"channel"` // defaults to direct
+	AffiliateID    *uint  `json:"affiliate_id"`
+	ResellerName   string `json:"reseller_name"`
+	UnitPriceToman int64  `json:"unit_price_toman"`
+	Note           string `json:"note"`
+}
+
+// LicenseBatchActor identifies who generated a batch
+type LicenseBatchActor struct {
+	Type   string // AuditActorWebAdmin or AuditActorTelegram
+	ID     uint
+	Name   string
+	UserID uint // stored as License.GeneratedBy
+}
+
+// LicenseBatchError is returned for an invalid batch request
+type LicenseBatchError struct {
+	Message string
+}
+
+func (e *LicenseBatchError) Error() string {
+	return e.Message
+}
+
+// ErrLicenseBatchNotFound is returned when a batch does not exist
+var ErrLicenseBatchNotFound = errors.New("license batch not found")
+
+// IsValidLicenseChannel reports whether channel is a known distribution channel
+func IsValidLicenseChannel(channel string) bool {
+	for _, c := range LicenseChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// LicenseDuration returns the duration in months of a license type
+func LicenseDuration(licenseType string) int {
+	switch licenseType {
+	case "pro":
+		return 30
+	case "plus4":
+		return 4
+	default:
+		return 12
+	}
+}
+
+func (r *LicenseBatchRequest) normalize(db *gorm.DB) error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.ResellerName = strings.TrimSpace(r.ResellerName)
+	if r.Channel == "" {
+		r.Channel = LicenseChannelDirect
+	}
+	if r.Count < 1 || r.Count > MaxLicenseBatchSize {
+		return &LicenseBatchError{Message: fmt.Sprintf("تعداد لایسنس باید بین 1 تا %d باشد", MaxLicenseBatchSize)}
+	}
+	if !IsValidLicenseChannel(r.Channel) {
+		return &LicenseBatchError{Message: "کانال فروش نامعتبر است"}
+	}
+	if r.UnitPriceToman < 0 {
+		return &LicenseBatchError{Message: "قیمت نمی‌تواند منفی باشد"}
+	}
+	switch r.Channel {
+	case LicenseChannelAffiliate:
+		if r.AffiliateID == nil {
+			return &LicenseBatchError{Message: "برای کانال همکار فروش، انتخاب همکار الزامی است"}
+		}
+		var count int64
+		if err := db.Model(&Affiliate{}).Where("id = ?", *r.AffiliateID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return &LicenseBatchError{Message: "همکار فروش یافت نشد"}
+		}
+	case LicenseChannelReseller:
+		if r.ResellerName == "" {
+			return &LicenseBatchError{Message: "برای کانال نماینده فروش، نام نماینده الزامی است"}
+		}
+	}
+	if r.Channel != LicenseChannelAffiliate {
+		r.AffiliateID = nil
+	}
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("%s %s %s", r.Type, r.Channel, time.Now().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// CreateLicenseBatch generates the codes of a new batch
+func CreateLicenseBatch(db *gorm.DB, req LicenseBatchRequest, actor LicenseBatchActor) (*LicenseBatch, []string, error) {
+	if err := req.normalize(db); err != nil {
+		return nil, nil, err
+	}
+
+	batch := LicenseBatch{
+		Name:           req.Name,
+		Type:           req.Type,
+		Duration:       LicenseDuration(req.Type),
+		Count:          req.Count,
+		Channel:        req.Channel,
+		AffiliateID:    req.AffiliateID,
+		ResellerName:   req.ResellerName,
+		UnitPriceToman: req.UnitPriceToman,
+		Note:           req.Note,
+		CreatedByType:  actor.Type,
+		CreatedByID:    actor.ID,
+		CreatedByName:  actor.Name,
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateLicenses(tx, req.Count, req.Type, actor.UserID, &batch.ID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &batch, codes, nil
+}
+
+// GetLicenseBatch returns a batch with its affiliate
+func GetLicenseBatch(db *gorm.DB, id uint) (*LicenseBatch, error) {
+	var batch LicenseBatch
+	if err := db.Preload("Affiliate").First(&batch, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLicenseBatchNotFound
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// RevokeLicenseBatch revokes every unused, unrevoked code of a batch and
+// returns how many were revoked. Redeemed codes are left untouched.
+func RevokeLicenseBatch(db *gorm.DB, batchID uint, reason string) (int64, error) {
+	var revoked int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&License{}).
+			Where("batch_id = ? AND is_used = ? AND revoked_at IS NULL", batchID, false).
+			Updates(map[string]interface{}{"revoked_at": now, "revoke_reason": reason})
+		if result.Error != nil {
+			return result.Error
+		}
+		revoked = result.RowsAffected
+		if revoked == 0 {
+			return nil
+		}
+		return tx.Model(&LicenseBatch{}).Where("id = ?", batchID).Updates(map[string]interface{}{
+			"revoked_count":   gorm.Expr("revoked_count + ?", revoked),
+			"last_revoked_at": now,
+		}).Error
+	})
+	return revoked, err
+}
+
+// LicenseBatchStats summarizes how the codes of a batch were used
+type LicenseBatchStats struct {
+	BatchID         uint       `json:"batch_id"`
+	Total           int64      `json:"total"`
+	Redeemed        int64      `json:"redeemed"`
+	Revoked         int64      `json:"revoked"`
+	Available       int64      `json:"available"`
+	RedemptionRate  float64    `json:"redemption_rate"` // percent of codes redeemed
+	RedeemedToman   int64      `json:"redeemed_toman"`  // redeemed codes at the batch unit price
+	FirstRedeemedAt *time.Time `json:"first_redeemed_at"`
+	LastRedeemedAt  *time.Time `json:"last_redeemed_at"`
+}
+
+// LicenseBatchWithStats is a batch listed with its redemption stats
+type LicenseBatchWithStats struct {
+	LicenseBatch
+	Stats LicenseBatchStats `json:"stats"`
+}
+
+// GetLicenseBatchStats returns the redemption stats of the given batches
+func GetLicenseBatchStats(db *gorm.DB, batches []LicenseBatch) (map[uint]LicenseBatchStats, error) {
+	stats := make(map[uint]LicenseBatchStats, len(batches))
+	if len(batches) == 0 {
+		return stats, nil
+	}
+	ids := make([]uint, len(batches))
+	for i, b := range batches {
+		ids[i] = b.ID
+	}
+
+	var rows []struct {
+		BatchID  uint
+		Total    int64
+		Redeemed int64
+		Revoked  int64
+	}
+	err := db.Model(&License{}).
+		Select("batch_id, COUNT(*) AS total, "+
+			"SUM(CASE WHEN is_used = ? THEN 1 ELSE 0 END) AS redeemed, "+
+			"SUM(CASE WHEN is_used = ? AND revoked_at IS NOT NULL THEN 1 ELSE 0 END) AS revoked",
+			true, false).
+		Where("batch_id IN ?", ids).
+		Group("batch_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Redemption dates are read separately so MIN/MAX come back as times on every driver
+	var dates []struct {
+		BatchID uint
+		UsedAt  *time.Time
+	}
+	if err := db.Model(&License{}).Select("batch_id, used_at").
+		Where("batch_id IN ? AND is_used = ? AND used_at IS NOT NULL", ids, true).
+		Scan(&dates).Error; err != nil {
+		return nil, err
+	}
+
+	prices := make(map[uint]int64, len(batches))
+	for _, b := range batches {
+		prices[b.ID] = b.UnitPriceToman
+		stats[b.ID] = LicenseBatchStats{BatchID: b.ID}
+	}
+	for _, r := range rows {
+		s := stats[r.BatchID]
+		s.Total = r.Total
+		s.Redeemed = r.Redeemed
+		s.Revoked = r.Revoked
+		s.Available = r.Total - r.Redeemed - r.Revoked
+		if r.Total > 0 {
+			s.RedemptionRate = float64(r.Redeemed) * 100 / float64(r.Total)
+		}
+		s.RedeemedToman = r.Redeemed * prices[r.BatchID]
+		stats[r.BatchID] = s
+	}
+	for _, d := range dates {
+		s := stats[d.BatchID]
+		if s.FirstRedeemedAt == nil || d.UsedAt.Before(*s.FirstRedeemedAt) {
+			s.FirstRedeemedAt = d.UsedAt
+		}
+		if s.LastRedeemedAt == nil || d.UsedAt.After(*s.LastRedeemedAt) {
+			s.LastRedeemedAt = d.UsedAt
+		}
+		stats[d.BatchID] = s
+	}
+	return stats, nil
+}
+
+// LicenseBatchFilter narrows the batch list
+type LicenseBatchFilter struct {
+	Channel     string
+	AffiliateID *uint
+	Type        string
+	Page        int
+	PerPage     int
+}
+
+// GetLicenseBatches returns batches, newest first, with their stats
+func GetLicenseBatches(db *gorm.DB, filter LicenseBatchFilter) ([]LicenseBatchWithStats, int64, error) {
+	query := db.Model(&LicenseBatch{})
+	if filter.Channel != "" {
+		query = query.Where("channel = ?", filter.Channel)
+	}
+	if filter.AffiliateID != nil {
+		query = query.Where("affiliate_id = ?", *filter.AffiliateID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var batches []LicenseBatch
+	if err := query.Preload("Affiliate").Order("created_at DESC").Order("id DESC").
+		Offset((filter.Page - 1) * filter.PerPage).Limit(filter.PerPage).
+		Find(&batches).Error; err != nil {
+		return nil, 0, err
+	}
+
+	stats, err := GetLicenseBatchStats(db, batches)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]LicenseBatchWithStats, len(batches))
+	for i, b := range batches {
+		result[i] = LicenseBatchWithStats{LicenseBatch: b, Stats: stats[b.ID]}
+	}
+	return result, total, nil
+}
@@ -0,0 +1,86 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestRevokeLicenseBatchLeavesRedeemedCodes(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	buyer := testutil.CreateUser(t, db, "09120000002")
+	other := testutil.CreateUser(t, db, "09120000003")
+
+	batch, codes, err := models.CreateLicenseBatch(db, models.LicenseBatchRequest{
+		Count:          3,
+		Type:           "plus",
+		Channel:        models.LicenseChannelReseller,
+		ResellerName:   "فروشگاه نمونه",
+		UnitPriceToman: 1000000,
+	}, models.LicenseBatchActor{Type: models.AuditActorWebAdmin, ID: 1, Name: "admin", UserID: admin.ID})
+	if err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if len(codes) != 3 || batch.Count != 3 || batch.Name == "" {
+		t.Fatalf("batch = %+v with %d codes, want 3 named codes", batch, len(codes))
+	}
+
+	if _, err := models.UseLicense(db, codes[0], buyer.ID); err != nil {
+		t.Fatalf("redeem before revoke: %v", err)
+	}
+
+	revoked, err := models.RevokeLicenseBatch(db, batch.ID, "reseller contract ended")
+	if err != nil {
+		t.Fatalf("revoke batch: %v", err)
+	}
+	if revoked != 2 {
+		t.Fatalf("revoked = %d, want 2 unused codes", revoked)
+	}
+
+	if _, err := models.UseLicense(db, codes[1], other.ID); !errors.Is(err, models.ErrLicenseRevoked) {
+		t.Fatalf("redeem revoked code: err = %v, want ErrLicenseRevoked", err)
+	}
+	if ok, _ := models.CheckUserLicense(db, buyer.ID); !ok {
+		t.Fatal("revoking the batch removed an already redeemed license")
+	}
+
+	stats, err := models.GetLicenseBatchStats(db, []models.LicenseBatch{*batch})
+	if err != nil {
+		t.Fatalf("batch stats: %v", err)
+	}
+	s := stats[batch.ID]
+	if s.Total != 3 || s.Redeemed != 1 || s.Revoked != 2 || s.Available != 0 {
+		t.Fatalf("stats = %+v, want 3 total, 1 redeemed, 2 revoked", s)
+	}
+	if s.RedeemedToman != 1000000 || s.FirstRedeemedAt == nil {
+		t.Fatalf("stats = %+v, want 1000000 toman redeemed with a redemption date", s)
+	}
+}
+
+func TestCreateLicenseBatchValidatesChannel(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	actor := models.LicenseBatchActor{Type: models.AuditActorWebAdmin, ID: 1, UserID: admin.ID}
+
+	cases := []models.LicenseBatchRequest{
+		{Count: 1, Type: "plus", Channel: "market"},
+		{Count: 1, Type: "plus", Channel: models.LicenseChannelReseller},
+		{Count: 1, Type: "plus", Channel: models.LicenseChannelAffiliate},
+		{Count: models.MaxLicenseBatchSize + 1, Type: "plus"},
+	}
+	for _, req := range cases {
+		var batchErr *models.LicenseBatchError
+		if _, _, err := models.CreateLicenseBatch(db, req, actor); !errors.As(err, &batchErr) {
+			t.Errorf("request %+v: err = %v, want LicenseBatchError", req, err)
+		}
+	}
+
+	var count int64
+	db.Model(&models.License{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("invalid batches created %d licenses", count)
+	}
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"asl-market-backend/config"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// License lifecycle events
+const (
+	LicenseEventActivated  = "activated"
+	LicenseEventRenewed    = "renewed"
+	LicenseEventUpgraded   = "upgraded"
+	LicenseEventExpired    = "expired"    // expiry passed, grace period started
+	LicenseEventDowngraded = "downgraded" // grace period over, licensed access removed
+)
+
+// LicenseReminderEvent is the event recorded for the reminder sent the given
+// number of days before expiry
+func LicenseReminderEvent(days int) string {
+	return fmt.Sprintf("reminder_%dd", days)
+}
+
+// LicenseEvent records a step in a license's lifecycle. Each event happens at
+// most once per license and expiry, so reminders are never sent twice even if
+// the job runs more often than expected; extending a license (a new expiry)
+// starts its reminders over.
+type LicenseEvent struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	LicenseID        uint      `json:"license_id" gorm:"not null;uniqueIndex:idx_license_event_once"`
+	UserID           uint      `json:"user_id" gorm:"not null;index"`
+	Event            string    `json:"event" gorm:"size:30;not null;uniqueIndex:idx_license_event_once"`
+	LicenseExpiresAt time.Time `json:"license_expires_at" gorm:"not null;uniqueIndex:idx_license_event_once"`
+	Details          string    `json:"details" gorm:"size:500"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for LicenseEvent
+func (LicenseEvent) TableName() string {
+	return "license_events"
+}
+
+// RecordLicenseEvent stores a lifecycle event. It returns false without an
+// error if the same event was already recorded for this license and expiry.
+func RecordLicenseEvent(db *gorm.DB, license *License, event, details string) (bool, error) {
+	if license.UsedBy == nil || license.ExpiresAt == nil {
+		return false, nil
+	}
+	row := LicenseEvent{
+		LicenseID:        license.ID,
+		UserID:           *license.UsedBy,
+		Event:            event,
+		LicenseExpiresAt: *license.ExpiresAt,
+		Details:          details,
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetUserLicenseEvents returns a user's license lifecycle events, newest first
+func GetUserLicenseEvents(db *gorm.DB, userID uint) ([]LicenseEvent, error) {
+	var events []LicenseEvent
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Order("id DESC").Find(&events).Error
+	return events, err
+}
+
+// LicenseAccess describes whether a user may use licensed features
+type LicenseAccess struct {
+	HasLicense  bool       `json:"has_license"`
+	InGrace     bool       `json:"in_grace"` // expired, but still inside the grace period
+	ExpiresAt   *time.Time `json:"expires_at"`
+	GraceEndsAt *time.Time `json:"grace_ends_at,omitempty"`
+}
+
+// LicenseGracePeriod is how long licensed features keep working after expiry
+func LicenseGracePeriod() time.Duration {
+	if config.AppConfig == nil || config.AppConfig.License.GracePeriodDays <= 0 {
+		return 0
+	}
+	return time.Duration(config.AppConfig.License.GracePeriodDays) * 24 * time.Hour
+}
+
+// GetUserLicenseAccess checks the user's license, letting an expired license
+// keep working for the grace period after its expiry
+func GetUserLicenseAccess(db *gorm.DB, userID uint, grace time.Duration) (*LicenseAccess, error) {
+	access := &LicenseAccess{}
+
+	latest, err := latestLicenseExpiry(db, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return access, nil
+		}
+		return nil, err
+	}
+	access.ExpiresAt = &latest
+
+	now := time.Now()
+	if latest.After(now) {
+		access.HasLicense = true
+		return access, nil
+	}
+
+	graceEndsAt := latest.Add(grace)
+	if graceEndsAt.After(now) {
+		access.HasLicense = true
+		access.InGrace = true
+		access.GraceEndsAt = &graceEndsAt
+	}
+	return access, nil
+}
+
+// isFinalLicense filters to licenses that are their user's last one, so a
+// license followed by a queued renewal is not reported as lapsing
+func isFinalLicense(db *gorm.DB) *gorm.DB {
+	return db.Where("NOT EXISTS (SELECT 1 FROM licenses later WHERE later.used_by = licenses.used_by "+
+		"AND later.is_used = ? AND later.deleted_at IS NULL AND later.expires_at > licenses.expires_at)", true)
+}
+
+// GetLicensesExpiringBetween returns used licenses, with their users, that
+// expire in [from, to) and are not followed by a renewal
+func GetLicensesExpiringBetween(db *gorm.DB, from, to time.Time) ([]License, error) {
+	var licenses []License
+	err := isFinalLicense(db.Model(&License{}).Preload("User").
+		Where("licenses.is_used = ? AND licenses.used_by IS NOT NULL", true).
+		Where("licenses.expires_at >= ? AND licenses.expires_at < ?", from, to)).
+		Order("licenses.expires_at ASC").
+		Find(&licenses).Error
+	return licenses, err
+}
+
+// LicenseExpiryReport lists licenses that are about to lapse
+type LicenseExpiryReport struct {
+	Days     int       `json:"days"`
+	Expiring []License `json:"expiring"` // expire within Days
+	InGrace  []License `json:"in_grace"` // expired, grace period still running
+}
+
+// GetLicenseExpiryReport returns the licenses expiring within the given
+// number of days and those currently in their grace period
+func GetLicenseExpiryReport(db *gorm.DB, days int, grace time.Duration) (*LicenseExpiryReport, error) {
+	now := time.Now()
+	expiring, err := GetLicensesExpiringBetween(db, now, now.AddDate(0, 0, days))
+	if err != nil {
+		return nil, err
+	}
+
+	inGrace := []License{}
+	if grace > 0 {
+		if inGrace, err = GetLicensesExpiringBetween(db, now.Add(-grace), now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LicenseExpiryReport{Days: days, Expiring: expiring, InGrace: inGrace}, nil
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LicensePlanTypes lists the license types that have a plan, in display order
+var LicensePlanTypes = []string{"plus4", "plus", "pro"}
+
+// LicensePlan holds the daily quotas and list price of a license type. A type
+// without a row uses DefaultLicensePlans. A quota of 0 means unlimited.
+type LicensePlan struct {
+	Type                       string    `json:"type" gorm:"primaryKey;size:20"`
+	DailyVisitorViews          int       `json:"daily_visitor_views"`
+	DailySupplierViews         int       `json:"daily_supplier_views"`
+	DailyAvailableProductViews int       `json:"daily_available_product_views"`
+	DailyAIMessages            int       `json:"daily_ai_messages"`
+	DailyMatchingRequests      int       `json:"daily_matching_requests"`
+	DailyProposals             int       `json:"daily_proposals"`                          // visitor-project proposals
+	PriceToman                 int64     `json:"price_toman" gorm:"type:bigint;default:0"` // affiliate commission base; 0 = DefaultAmountToman
+	UpdatedByID                *uint     `json:"updated_by_id"`
+	CreatedAt                  time.Time `json:"created_at"`
+	UpdatedAt                  time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for LicensePlan
+func (LicensePlan) TableName() string {
+	return "license_plans"
+}
+
+// DefaultLicensePlans is used for a license type until an admin edits it
+var DefaultLicensePlans = map[string]LicensePlan{
+	"plus4": {Type: "plus4", DailyVisitorViews: 3, DailySupplierViews: 3, DailyAvailableProductViews: 3,
+		DailyAIMessages: DailyAIMessageLimit, DailyMatchingRequests: 3, DailyProposals: 5, PriceToman: DefaultAmountToman},
+	"plus": {Type: "plus", DailyVisitorViews: 3, DailySupplierViews: 3, DailyAvailableProductViews: 3,
+		DailyAIMessages: DailyAIMessageLimit, DailyMatchingRequests: 5, DailyProposals: 10, PriceToman: DefaultAmountToman},
+	"pro": {Type: "pro", DailyVisitorViews: 3, DailySupplierViews: 6, DailyAvailableProductViews: 6,
+		DailyAIMessages: DailyAIMessageLimit, DailyMatchingRequests: 10, DailyProposals: 20, PriceToman: DefaultAmountToman},
+}
+
+// License plan errors returned by SetLicensePlan
+var (
+	ErrUnknownLicensePlan = errors.New("unknown license plan")
+	ErrNegativeQuota      = errors.New("license plan quotas cannot be negative")
+	ErrNegativePrice      = errors.New("license plan price cannot be negative")
+)
+
+// IsValidLicensePlanType reports whether licenseType has a plan
+func IsValidLicensePlanType(licenseType string) bool {
+	_, ok := DefaultLicensePlans[licenseType]
+	return ok
+}
+
+// GetLicensePlan returns the quotas of a license type. Unknown types (and
+// users without a license) get the plus plan.
+func GetLicensePlan(db *gorm.DB, licenseType string) (*LicensePlan, error) {
+	if !IsValidLicensePlanType(licenseType) {
+		licenseType = "plus"
+	}
+
+	var plan LicensePlan
+	err := db.Where("type = ?", licenseType).First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		plan = DefaultLicensePlans[licenseType]
+		return &plan, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// GetUserLicensePlan returns the plan of the user's active license
+func GetUserLicensePlan(db *gorm.DB, userID uint) (*LicensePlan, error) {
+	licenseType := "plus"
+	if license, err := GetUserLicense(db, userID); err == nil {
+		licenseType = license.Type
+	}
+	return GetLicensePlan(db, licenseType)
+}
+
+// LicensePlanPrice returns the price a license type is sold at, falling back
+// to DefaultAmountToman when the plan has none
+func LicensePlanPrice(db *gorm.DB, licenseType string) (int64, error) {
+	plan, err := GetLicensePlan(db, licenseType)
+	if err != nil {
+		return 0, err
+	}
+	if plan.PriceToman <= 0 {
+		return DefaultAmountToman, nil
+	}
+	return plan.PriceToman, nil
+}
+
+// GetLicensePlans returns the plan of every license type
+func GetLicensePlans(db *gorm.DB) ([]LicensePlan, error) {
+	plans := make([]LicensePlan, 0, len(LicensePlanTypes))
+	for _, licenseType := range LicensePlanTypes {
+		plan, err := GetLicensePlan(db, licenseType)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, *plan)
+	}
+	return plans, nil
+}
+
+// SetLicensePlan replaces the quotas of a license type
+func SetLicensePlan(db *gorm.DB, plan LicensePlan, updatedByID uint) (*LicensePlan, error) {
+	if !IsValidLicensePlanType(plan.Type) {
+		return nil, ErrUnknownLicensePlan
+	}
+	for _, quota := range []int{plan.DailyVisitorViews, plan.DailySupplierViews, plan.DailyAvailableProductViews,
+		plan.DailyAIMessages, plan.DailyMatchingRequests, plan.DailyProposals} {
+		if quota < 0 {
+			return nil, ErrNegativeQuota
+		}
+	}
+	if plan.PriceToman < 0 {
+		return nil, ErrNegativePrice
+	}
+	plan.UpdatedByID = &updatedByID
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"daily_visitor_views", "daily_supplier_views", "daily_available_product_views",
+			"daily_ai_messages", "daily_matching_requests", "daily_proposals", "price_toman", "updated_by_id", "updated_at",
+		}),
+	}).Create(&plan).Error
+	if err != nil {
+		return nil, err
+	}
+	return GetLicensePlan(db, plan.Type)
+}
+
+// ResetLicensePlan drops a license type's edits so it falls back to the defaults
+func ResetLicensePlan(db *gorm.DB, licenseType string) error {
+	return db.Where("type = ?", licenseType).Delete(&LicensePlan{}).Error
+}
+
+// quotaRemaining returns how many uses are left of a daily quota, or -1 when
+// the quota is unlimited
+func quotaRemaining(max, used int) int {
+	if max <= 0 {
+		return -1
+	}
+	if used >= max {
+		return 0
+	}
+	return max - used
+}
+
+// quotaAllows reports whether one more use fits in a daily quota
+func quotaAllows(max, used int) bool {
+	return max <= 0 || used < max
+}
+
+// startOfDay returns midnight of t in its location
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// CountTodayMatchingRequests returns how many matching requests a user created today
+func CountTodayMatchingRequests(db *gorm.DB, userID uint) (int, error) {
+	var count int64
+	err := db.Model(&MatchingRequest{}).
+		Where("user_id = ? AND created_at >= ?", userID, startOfDay(time.Now())).
+		Count(&count).Error
+	return int(count), err
+}
+
+// CountTodayProposals returns how many visitor-project proposals a user sent today
+func CountTodayProposals(db *gorm.DB, userID uint) (int, error) {
+	var count int64
+	err := db.Model(&VisitorProjectProposal{}).
+		Where("user_id = ? AND created_at >= ?", userID, startOfDay(time.Now())).
+		Count(&count).Error
+	return int(count), err
+}
+
+// CanCreateMatchingRequest checks the user's daily matching request quota
+func CanCreateMatchingRequest(db *gorm.DB, userID uint) (bool, *LicensePlan, error) {
+	plan, err := GetUserLicensePlan(db, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	used, err := CountTodayMatchingRequests(db, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	return quotaAllows(plan.DailyMatchingRequests, used), plan, nil
+}
+
+// CanSubmitProposal checks the user's daily visitor-project proposal quota
+func CanSubmitProposal(db *gorm.DB, userID uint) (bool, *LicensePlan, error) {
+	plan, err := GetUserLicensePlan(db, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	used, err := CountTodayProposals(db, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	return quotaAllows(plan.DailyProposals, used), plan, nil
+}
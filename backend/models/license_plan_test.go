@@ -0,0 +1,78 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestLicensePlanEditsApplyToLimitChecks(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	testutil.GrantLicense(t, db, user.ID, "plus4")
+
+	plan, err := models.GetUserLicensePlan(db, user.ID)
+	if err != nil {
+		t.Fatalf("get user plan: %v", err)
+	}
+	if plan.Type != "plus4" || plan.DailyMatchingRequests != models.DefaultLicensePlans["plus4"].DailyMatchingRequests {
+		t.Fatalf("plus4 user got plan %+v, want plus4 defaults", plan)
+	}
+
+	edited := models.DefaultLicensePlans["plus4"]
+	edited.DailySupplierViews = 1
+	if _, err := models.SetLicensePlan(db, edited, 1); err != nil {
+		t.Fatalf("set plan: %v", err)
+	}
+
+	if ok, _ := models.CanViewSupplier(db, user.ID, "plus4"); !ok {
+		t.Fatal("first supplier view rejected")
+	}
+	if err := models.IncrementSupplierView(db, user.ID); err != nil {
+		t.Fatalf("increment supplier view: %v", err)
+	}
+	if ok, _ := models.CanViewSupplier(db, user.ID, "plus4"); ok {
+		t.Fatal("second supplier view allowed past the edited quota")
+	}
+
+	// 0 means unlimited
+	edited.DailySupplierViews = 0
+	if _, err := models.SetLicensePlan(db, edited, 1); err != nil {
+		t.Fatalf("set plan: %v", err)
+	}
+	if ok, _ := models.CanViewSupplier(db, user.ID, "plus4"); !ok {
+		t.Fatal("supplier view rejected on an unlimited plan")
+	}
+	_, supplierRemaining, _, err := models.GetRemainingLimits(db, user.ID, "plus4")
+	if err != nil {
+		t.Fatalf("remaining limits: %v", err)
+	}
+	if supplierRemaining != -1 {
+		t.Fatalf("unlimited supplier remaining = %d, want -1", supplierRemaining)
+	}
+
+	// Resetting falls back to the defaults
+	if err := models.ResetLicensePlan(db, "plus4"); err != nil {
+		t.Fatalf("reset plan: %v", err)
+	}
+	plan, _ = models.GetLicensePlan(db, "plus4")
+	if plan.DailySupplierViews != models.DefaultLicensePlans["plus4"].DailySupplierViews {
+		t.Fatalf("reset plan has %d supplier views", plan.DailySupplierViews)
+	}
+}
+
+func TestSetLicensePlanValidates(t *testing.T) {
+	db := testutil.NewTestDB(t)
+
+	if _, err := models.SetLicensePlan(db, models.LicensePlan{Type: "gold"}, 1); !errors.Is(err, models.ErrUnknownLicensePlan) {
+		t.Fatalf("unknown plan error = %v", err)
+	}
+
+	plan := models.DefaultLicensePlans["pro"]
+	plan.DailyProposals = -1
+	if _, err := models.SetLicensePlan(db, plan, 1); !errors.Is(err, models.ErrNegativeQuota) {
+		t.Fatalf("negative quota error = %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package models_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestUseLicenseIsSingleUse(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	first := testutil.CreateUser(t, db, "09120000002")
+	second := testutil.CreateUser(t, db, "09120000003")
+
+	codes, err := models.GenerateLicenses(db, 1, "plus", admin.ID)
+	if err != nil {
+		t.Fatalf("generate licenses: %v", err)
+	}
+
+	license, err := models.UseLicense(db, codes[0], first.ID)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if license.UsedBy == nil || *license.UsedBy != first.ID {
+		t.Fatalf("license used_by = %v, want %d", license.UsedBy, first.ID)
+	}
+	if license.ExpiresAt == nil || license.ExpiresAt.Before(time.Now().AddDate(0, 11, 0)) {
+		t.Fatalf("license expires_at = %v, want about a year ahead", license.ExpiresAt)
+	}
+
+	if _, err := models.UseLicense(db, codes[0], second.ID); err == nil {
+		t.Fatal("second use of the same code succeeded")
+	}
+}
+
+func TestCheckUserLicense(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+
+	ok, err := models.CheckUserLicense(db, user.ID)
+	if err != nil {
+		t.Fatalf("check license: %v", err)
+	}
+	if ok {
+		t.Fatal("user without a license reported as licensed")
+	}
+
+	license := testutil.GrantLicense(t, db, user.ID, "plus")
+	if ok, _ := models.CheckUserLicense(db, user.ID); !ok {
+		t.Fatal("user with an active license reported as unlicensed")
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	if err := db.Model(license).Update("expires_at", expired).Error; err != nil {
+		t.Fatalf("expire license: %v", err)
+	}
+	if ok, _ := models.CheckUserLicense(db, user.ID); ok {
+		t.Fatal("user with an expired license reported as licensed")
+	}
+}
+
+func TestUseLicenseConcurrentRedemption(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	admin := testutil.CreateUser(t, db, "09120000001")
+	codes, err := models.GenerateLicenses(db, 1, "plus", admin.ID)
+	if err != nil {
+		t.Fatalf("generate licenses: %v", err)
+	}
+
+	const redeemers = 5
+	var users []*models.User
+	for i := 0; i < redeemers; i++ {
+		users = append(users, testutil.CreateUser(t, db, fmt.Sprintf("0913000000%d", i)))
+	}
+
+	var wg sync.WaitGroup
+	var successes int32
+	for _, user := range users {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			if _, err := models.UseLicense(db, codes[0], userID); err == nil {
+				atomic.AddInt32(&successes, 1)
+			}
+		}(user.ID)
+	}
+	wg.Wait()
+
+	if successes != 1 {
+		t.Fatalf("%d redemptions of one code succeeded, want 1", successes)
+	}
+}
+
+func TestUseLicenseStacksRenewals(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	user := testutil.CreateUser(t, db, "09120000002")
+
+	codes, err := models.GenerateLicenses(db, 2, "plus4", admin.ID)
+	if err != nil {
+		t.Fatalf("generate licenses: %v", err)
+	}
+
+	first, err := models.UseLicense(db, codes[0], user.ID)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	second, err := models.UseLicense(db, codes[1], user.ID)
+	if err != nil {
+		t.Fatalf("renewal: %v", err)
+	}
+	if !second.StartsAt.Equal(*first.ExpiresAt) {
+		t.Fatalf("renewal starts at %v, want the first expiry %v", second.StartsAt, first.ExpiresAt)
+	}
+	if want := first.ExpiresAt.AddDate(0, second.Duration, 0); !second.ExpiresAt.Equal(want) {
+		t.Fatalf("renewal expires at %v, want %v", second.ExpiresAt, want)
+	}
+
+	current, err := models.GetUserLicense(db, user.ID)
+	if err != nil {
+		t.Fatalf("get user license: %v", err)
+	}
+	if current.ID != first.ID {
+		t.Fatalf("current license = %d, want the first one %d", current.ID, first.ID)
+	}
+
+	history, err := models.GetUserLicenseHistory(db, user.ID)
+	if err != nil {
+		t.Fatalf("license history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history has %d licenses, want 2", len(history))
+	}
+	if history[0].Status != models.LicenseStatusActive || !history[0].IsActive {
+		t.Fatalf("first license status = %s, want active", history[0].Status)
+	}
+	if history[1].Status != models.LicenseStatusQueued {
+		t.Fatalf("renewal status = %s, want queued", history[1].Status)
+	}
+
+	// Once the first period is over the renewal becomes current
+	past := time.Now().Add(-time.Hour)
+	if err := db.Model(&models.License{}).Where("id = ?", first.ID).Update("expires_at", past).Error; err != nil {
+		t.Fatalf("expire first license: %v", err)
+	}
+	if err := db.Model(&models.License{}).Where("id = ?", second.ID).Update("starts_at", past).Error; err != nil {
+		t.Fatalf("start renewal: %v", err)
+	}
+	current, _ = models.GetUserLicense(db, user.ID)
+	if current.ID != second.ID {
+		t.Fatalf("current license after expiry = %d, want the renewal %d", current.ID, second.ID)
+	}
+}
@@ -51,6 +51,7 @@ type MatchingRequest struct {
 	AcceptedVisitorID   *uint      `json:"accepted_visitor_id" gorm:"index"`       // Visitor who accepted
 	AcceptedVisitor     *Visitor   `json:"accepted_visitor,omitempty" gorm:"foreignKey:AcceptedVisitorID"`
 	AcceptedAt          *time.Time `json:"accepted_at"`
+	AcceptedOfferID     *uint      `json:"accepted_offer_id"` // Negotiation offer whose terms were agreed, if any
 
 	// Relations
 	Responses []MatchingResponse `json:"responses" gorm:"foreignKey:MatchingRequestID"`
@@ -61,6 +62,12 @@ type MatchingRequest struct {
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// IsOpenForNegotiation reports whether visitors can still negotiate on and
+// accept the request
+func (r *MatchingRequest) IsOpenForNegotiation() bool {
+	return (r.Status == "pending" || r.Status == "active") && r.AcceptedVisitorID == nil && r.ExpiresAt.After(time.Now())
+}
+
 // MatchingResponse represents a visitor's response to a matching request
 type MatchingResponse struct {
 	ID                uint            `json:"id" gorm:"primaryKey"`
@@ -538,6 +545,9 @@ func CreateMatchingResponse(db *gorm.DB, matchingRequestID uint, visitorID uint,
 		}).Error; err != nil {
 			return nil, err
 		}
+		if err := ExpirePendingNegotiationOffers(db, ConversationContextMatchingRequest, matchingRequestID); err != nil {
+			return nil, err
+		}
 	}
 
 	return &response, nil
@@ -637,7 +647,8 @@ func CloseMatchingRequest(db *gorm.DB, id uint, userID uint) error {
 	return db.Model(&MatchingRequest{}).Where("id = ?", id).Update("status", "completed").Error
 }
 
-// MatchingChat represents a chat conversation between supplier and visitor for a matching request
+// MatchingChat represents a chat conversation between supplier and visitor for a matching request.
+// Chats now live in Conversation; this table is only read by MigrateLegacyChats.
 type MatchingChat struct {
 	ID                uint            `json:"id" gorm:"primaryKey"`
 	MatchingRequestID uint            `json:"matching_request_id" gorm:"not null;index"`
@@ -699,189 +710,6 @@ type MatchingChatResponse struct {
 	CreatedAt         time.Time  `json:"created_at"`
 }
 
-// MatchingMessageResponse represents a message in API responses
-type MatchingMessageResponse struct {
-	ID             uint       `json:"id"`
-	MatchingChatID uint       `json:"matching_chat_id"`
-	SenderID       uint       `json:"sender_id"`
-	SenderName     string     `json:"sender_name"`
-	SenderType     string     `json:"sender_type"`
-	Message        string     `json:"message"`
-	ImageURL       string     `json:"image_url,omitempty"`
-	IsRead         bool       `json:"is_read"`
-	ReadAt         *time.Time `json:"read_at"`
-	CreatedAt      time.Time  `json:"created_at"`
-}
-
-// CreateMatchingChatRequest represents request to create a chat
-type CreateMatchingChatRequest struct {
-	MatchingRequestID uint `json:"matching_request_id" binding:"required"`
-}
-
-// SendMatchingMessageRequest represents request to send a message
-type SendMatchingMessageRequest struct {
-	Message  string `json:"message"`   // Optional if image_url is provided
-	ImageURL string `json:"image_url"` // Optional image URL
-}
-
-// GetOrCreateMatchingChat gets or creates a chat for a matching request
-func GetOrCreateMatchingChat(db *gorm.DB, matchingRequestID uint) (*MatchingChat, error) {
-	// Get matching request
-	request, err := GetMatchingRequestByID(db, matchingRequestID)
-	if err != nil {
-		return nil, err
-	}
-
-	// Check if request is accepted
-	if request.Status != "accepted" || request.AcceptedVisitorID == nil {
-		return nil, gorm.ErrInvalidValue
-	}
-
-	// Check if chat already exists
-	var chat MatchingChat
-	err = db.Where("matching_request_id = ?", matchingRequestID).
-		Preload("Supplier").Preload("Visitor").
-		Preload("SupplierUser").Preload("VisitorUser").
-		First(&chat).Error
-
-	if err == nil {
-		return &chat, nil
-	}
-
-	if err != gorm.ErrRecordNotFound {
-		return nil, err
-	}
-
-	// Get visitor
-	var visitor Visitor
-	if err := db.First(&visitor, *request.AcceptedVisitorID).Error; err != nil {
-		return nil, err
-	}
-
-	// Create new chat
-	chat = MatchingChat{
-		MatchingRequestID: matchingRequestID,
-		SupplierID:        request.SupplierID,
-		VisitorID:         *request.AcceptedVisitorID,
-		SupplierUserID:    request.UserID,
-		VisitorUserID:     visitor.UserID,
-		IsActive:          true,
-	}
-
-	if err := db.Create(&chat).Error; err != nil {
-		return nil, err
-	}
-
-	// Preload relations
-	if err := db.Preload("Supplier").Preload("Visitor").
-		Preload("SupplierUser").Preload("VisitorUser").
-		First(&chat, chat.ID).Error; err != nil {
-		return nil, err
-	}
-
-	return &chat, nil
-}
-
-// GetMatchingChatMessages gets all messages for a chat
-func GetMatchingChatMessages(db *gorm.DB, chatID uint, page, perPage int) ([]MatchingMessage, int64, error) {
-	var messages []MatchingMessage
-	var total int64
-
-	query := db.Model(&MatchingMessage{}).Where("matching_chat_id = ?", chatID)
-
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
-
-	offset := (page - 1) * perPage
-	err := query.Preload("Sender").
-		Order("created_at ASC").
-		Offset(offset).Limit(perPage).Find(&messages).Error
-
-	return messages, total, err
-}
-
-// CreateMatchingMessage creates a new message in a chat
-func CreateMatchingMessage(db *gorm.DB, chatID uint, senderID uint, senderType string, message string, imageURL string) (*MatchingMessage, error) {
-	// Verify chat exists and user has access
-	var chat MatchingChat
-	if err := db.First(&chat, chatID).Error; err != nil {
-		return nil, err
-	}
-
-	// Verify sender is part of the chat
-	if senderType == "supplier" && chat.SupplierUserID != senderID {
-		return nil, gorm.ErrInvalidValue
-	}
-	if senderType == "visitor" && chat.VisitorUserID != senderID {
-		return nil, gorm.ErrInvalidValue
-	}
-
-	// Validate: either message or image_url must be provided
-	if message == "" && imageURL == "" {
-		return nil, gorm.ErrInvalidValue
-	}
-
-	matchingMessage := MatchingMessage{
-		MatchingChatID: chatID,
-		SenderID:       senderID,
-		SenderType:     senderType,
-		Message:        message,
-		ImageURL:       imageURL,
-		IsRead:         false,
-	}
-
-	if err := db.Create(&matchingMessage).Error; err != nil {
-		return nil, err
-	}
-
-	// Preload sender
-	if err := db.Preload("Sender").First(&matchingMessage, matchingMessage.ID).Error; err != nil {
-		return nil, err
-	}
-
-	// Update chat updated_at
-	db.Model(&chat).Update("updated_at", time.Now())
-
-	return &matchingMessage, nil
-}
-
-// MarkMatchingMessagesAsRead marks messages as read
-func MarkMatchingMessagesAsRead(db *gorm.DB, chatID uint, userID uint) error {
-	now := time.Now()
-	return db.Model(&MatchingMessage{}).
-		Where("matching_chat_id = ? AND sender_id != ? AND is_read = ?", chatID, userID, false).
-		Updates(map[string]interface{}{
-			"is_read": true,
-			"read_at": now,
-		}).Error
-}
-
-// GetMatchingChatsForUser gets all chats for a user
-func GetMatchingChatsForUser(db *gorm.DB, userID uint, page, perPage int) ([]MatchingChat, int64, error) {
-	var chats []MatchingChat
-	var total int64
-
-	query := db.Model(&MatchingChat{}).
-		Where("(supplier_user_id = ? OR visitor_user_id = ?) AND is_active = ?", userID, userID, true)
-
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
-
-	offset := (page - 1) * perPage
-	err := query.Preload("MatchingRequest").
-		Preload("Supplier").Preload("Visitor").
-		Preload("SupplierUser").Preload("VisitorUser").
-		Preload("Messages", func(db *gorm.DB) *gorm.DB {
-			return db.Order("created_at DESC").Limit(1)
-		}).
-		Order("updated_at DESC").
-		Offset(offset).Limit(perPage).Find(&chats).Error
-
-	return chats, total, err
-}
-
 // GetMatchingRatingsByUser gets all ratings for a user
 func GetMatchingRatingsByUser(db *gorm.DB, userID uint, page, perPage int) ([]MatchingRating, int64, error) {
 	var ratings []MatchingRating
@@ -0,0 +1,51 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"gorm.io/gorm"
+)
+
+func TestCreateMatchingResponse(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	supplierUser := testutil.CreateUser(t, db, "09120000001")
+	visitorUser := testutil.CreateUser(t, db, "09120000002")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "زعفران")
+
+	request, err := models.CreateMatchingRequest(db, supplierUser.ID, supplier.ID, models.CreateMatchingRequestRequest{
+		ProductName:          "زعفران",
+		Quantity:             "10",
+		Unit:                 "kg",
+		DestinationCountries: "AE",
+		Price:                "1000",
+		Currency:             "USD",
+		ExpiresAt:            time.Now().Add(48 * time.Hour).Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("create matching request: %v", err)
+	}
+
+	if _, err := models.CreateMatchingResponse(db, request.ID, visitor.ID, visitorUser.ID, models.MatchingResponseRequest{ResponseType: "question"}); !errors.Is(err, gorm.ErrInvalidValue) {
+		t.Fatalf("question without message error = %v, want ErrInvalidValue", err)
+	}
+
+	response, err := models.CreateMatchingResponse(db, request.ID, visitor.ID, visitorUser.ID, models.MatchingResponseRequest{ResponseType: "accepted"})
+	if err != nil {
+		t.Fatalf("accept matching request: %v", err)
+	}
+	if response.Status != "pending" {
+		t.Fatalf("response status = %q, want pending", response.Status)
+	}
+
+	var reloaded models.MatchingRequest
+	db.First(&reloaded, request.ID)
+	if reloaded.Status != "accepted" || reloaded.AcceptedVisitorID == nil || *reloaded.AcceptedVisitorID != visitor.ID {
+		t.Fatalf("matching request status=%q accepted_visitor_id=%v", reloaded.Status, reloaded.AcceptedVisitorID)
+	}
+}
@@ -0,0 +1,424 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Negotiation offer statuses
+const (
+	OfferStatusPending   = "pending"
+	OfferStatusAccepted  = "accepted"
+	OfferStatusRejected  = "rejected"
+	OfferStatusCountered = "countered"
+	OfferStatusWithdrawn = "withdrawn" // replaced by a newer offer from the same party
+	OfferStatusExpired   = "expired"   // past its validity, or the deal was closed elsewhere
+)
+
+// Incoterms are the delivery terms (Incoterms 2020) an offer may name
+var Incoterms = []string{"EXW", "FCA", "CPT", "CIP", "DAP", "DPU", "DDP", "FAS", "FOB", "CFR", "CIF"}
+
+var (
+	ErrNegotiationNotSupported = errors.New("offers can only be made in matching and visitor project conversations")
+	ErrNegotiationClosed       = errors.New("the request or project is no longer open for negotiation")
+	ErrInvalidOffer            = errors.New("offer needs a price, a currency, a known incoterm and a validity in the future")
+	ErrOfferAwaitingResponse   = errors.New("the other party's pending offer must be answered first")
+	ErrOfferNotPending         = errors.New("offer is no longer pending")
+	ErrOfferExpired            = errors.New("offer has expired")
+	ErrOwnOffer                = errors.New("an offer cannot be answered by the party that made it")
+	ErrOfferMessageLocked      = errors.New("offer messages cannot be edited or deleted")
+)
+
+// NegotiationOffer is a structured price offer made in a matching or visitor
+// project conversation. Offers in a conversation are numbered by Version; a
+// counter-offer points at the offer it answers, so the chain of versions is
+// the negotiation history. At most one offer per conversation is pending.
+type NegotiationOffer struct {
+	ID             uint   `json:"id" gorm:"primaryKey"`
+	ConversationID uint   `json:"conversation_id" gorm:"not null;uniqueIndex:idx_negotiation_offer_version"`
+	ContextType    string `json:"context_type" gorm:"size:30;not null;index:idx_negotiation_offer_context"`
+	ContextID      uint   `json:"context_id" gorm:"not null;index:idx_negotiation_offer_context"`
+	Version        int    `json:"version" gorm:"not null;uniqueIndex:idx_negotiation_offer_version"`
+	ParentOfferID  *uint  `json:"parent_offer_id"` // Offer this one counters
+
+	ProposedByID uint   `json:"proposed_by_id" gorm:"not null;index"`  // User ID
+	ProposerRole string `json:"proposer_role" gorm:"size:20;not null"` // supplier or visitor
+
+	// Terms
+	Price        string     `json:"price" gorm:"size:100;not null"`
+	Currency     string     `json:"currency" gorm:"size:10;not null"`
+	Quantity     string     `json:"quantity" gorm:"size:100"`
+	Unit         string     `json:"unit" gorm:"size:50"`
+	Incoterm     string     `json:"incoterm" gorm:"size:10"`
+	DeliveryTime string     `json:"delivery_time" gorm:"size:255"`
+	PaymentTerms string     `json:"payment_terms" gorm:"type:text"`
+	ValidUntil   *time.Time `json:"valid_until"`
+	Note         string     `json:"note" gorm:"type:text"`
+
+	// Status: pending, accepted, rejected, countered, withdrawn, expired
+	Status        string     `json:"status" gorm:"size:20;default:'pending';index"`
+	RespondedByID *uint      `json:"responded_by_id"`
+	RespondedAt   *time.Time `json:"responded_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NegotiationOfferRequest represents the terms of a new offer or counter-offer
+type NegotiationOfferRequest struct {
+	Price        string     `json:"price"`
+	Currency     string     `json:"currency"`
+	Quantity     string     `json:"quantity"`
+	Unit         string     `json:"unit"`
+	Incoterm     string     `json:"incoterm"`
+	DeliveryTime string     `json:"delivery_time"`
+	PaymentTerms string     `json:"payment_terms"`
+	ValidUntil   *time.Time `json:"valid_until"`
+	Note         string     `json:"note"`
+}
+
+// offer builds the offer the request describes, taking terms left empty
+// from parent when it counters one
+func (r NegotiationOfferRequest) offer(parent *NegotiationOffer) (*NegotiationOffer, error) {
+	offer := &NegotiationOffer{
+		Price:        strings.TrimSpace(r.Price),
+		Currency:     strings.ToUpper(strings.TrimSpace(r.Currency)),
+		Quantity:     strings.TrimSpace(r.Quantity),
+		Unit:         strings.TrimSpace(r.Unit),
+		Incoterm:     strings.ToUpper(strings.TrimSpace(r.Incoterm)),
+		DeliveryTime: strings.TrimSpace(r.DeliveryTime),
+		PaymentTerms: strings.TrimSpace(r.PaymentTerms),
+		ValidUntil:   r.ValidUntil,
+		Note:         strings.TrimSpace(r.Note),
+		Status:       OfferStatusPending,
+	}
+	if parent != nil {
+		offer.ParentOfferID = &parent.ID
+		inherit := func(value *string, from string) {
+			if *value == "" {
+				*value = from
+			}
+		}
+		inherit(&offer.Price, parent.Price)
+		inherit(&offer.Currency, parent.Currency)
+		inherit(&offer.Quantity, parent.Quantity)
+		inherit(&offer.Unit, parent.Unit)
+		inherit(&offer.Incoterm, parent.Incoterm)
+		inherit(&offer.DeliveryTime, parent.DeliveryTime)
+		inherit(&offer.PaymentTerms, parent.PaymentTerms)
+		if offer.ValidUntil == nil {
+			offer.ValidUntil = parent.ValidUntil
+		}
+	}
+
+	if offer.Price == "" || offer.Currency == "" {
+		return nil, ErrInvalidOffer
+	}
+	if offer.Incoterm != "" && !isIncoterm(offer.Incoterm) {
+		return nil, ErrInvalidOffer
+	}
+	if offer.ValidUntil != nil && !offer.ValidUntil.After(time.Now()) {
+		return nil, ErrInvalidOffer
+	}
+	return offer, nil
+}
+
+func isIncoterm(code string) bool {
+	for _, incoterm := range Incoterms {
+		if incoterm == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary is the chat text of the message that carries the offer
+func (o *NegotiationOffer) Summary() string {
+	label := "پیشنهاد قیمت"
+	if o.ParentOfferID != nil {
+		label = "پیشنهاد متقابل"
+	}
+	summary := fmt.Sprintf("💼 %s: %s %s", label, o.Price, o.Currency)
+	if o.Quantity != "" {
+		summary += fmt.Sprintf(" برای %s %s", o.Quantity, o.Unit)
+	}
+	if o.Incoterm != "" {
+		summary += fmt.Sprintf(" (%s)", o.Incoterm)
+	}
+	return strings.TrimSpace(summary)
+}
+
+// IsExpired reports whether a pending offer is past its validity
+func (o *NegotiationOffer) IsExpired(now time.Time) bool {
+	return o.ValidUntil != nil && !o.ValidUntil.After(now)
+}
+
+// GetNegotiationOffers gets the offer history of a conversation, oldest first
+func GetNegotiationOffers(db *gorm.DB, conversationID uint) ([]NegotiationOffer, error) {
+	var offers []NegotiationOffer
+	err := db.Where("conversation_id = ?", conversationID).Order("version ASC").Find(&offers).Error
+	return offers, err
+}
+
+// CreateNegotiationOffer posts a new offer from userID and returns the
+// message that carries it. A pending offer of userID's own is withdrawn and
+// replaced; one from the other party has to be answered first.
+func CreateNegotiationOffer(db *gorm.DB, conversation *Conversation, userID uint, req NegotiationOfferRequest) (*ConversationMessage, error) {
+	return postNegotiationOffer(db, conversation, userID, nil, req)
+}
+
+// CounterNegotiationOffer answers the other party's pending offer with a new
+// version. Terms left empty are copied from the countered offer.
+func CounterNegotiationOffer(db *gorm.DB, conversation *Conversation, offerID, userID uint, req NegotiationOfferRequest) (*ConversationMessage, error) {
+	parent, err := answerableOffer(db, conversation, offerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return postNegotiationOffer(db, conversation, userID, parent, req)
+}
+
+// RejectNegotiationOffer declines the other party's pending offer and returns
+// the message that carries it
+func RejectNegotiationOffer(db *gorm.DB, conversation *Conversation, offerID, userID uint) (*ConversationMessage, error) {
+	offer, err := answerableOffer(db, conversation, offerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setOfferStatus(db, offer.ID, OfferStatusRejected, userID); err != nil {
+		return nil, err
+	}
+	return getOfferMessage(db, offer)
+}
+
+// AcceptNegotiationOffer agrees to the other party's pending offer. The
+// matching request or visitor project moves to accepted with the
+// conversation's visitor or supplier as the accepted party, and every other
+// pending offer on it expires.
+func AcceptNegotiationOffer(db *gorm.DB, conversation *Conversation, offerID, userID uint) (*ConversationMessage, error) {
+	offer, err := answerableOffer(db, conversation, offerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Status == ConversationStatusClosed {
+		return nil, ErrConversationClosed
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := setOfferStatus(tx, offer.ID, OfferStatusAccepted, userID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var result *gorm.DB
+		switch conversation.ContextType {
+		case ConversationContextMatchingRequest:
+			visitor := conversation.ParticipantByRole(ConversationRoleVisitor)
+			if visitor == nil {
+				return ErrNegotiationNotSupported
+			}
+			result = tx.Model(&MatchingRequest{}).
+				Where("id = ? AND status IN ? AND accepted_visitor_id IS NULL AND expires_at > ?",
+					conversation.ContextID, []string{"pending", "active"}, now).
+				Updates(map[string]interface{}{
+					"status":              "accepted",
+					"accepted_visitor_id": visitor.ProfileID,
+					"accepted_at":         now,
+					"accepted_offer_id":   offer.ID,
+				})
+		case ConversationContextVisitorProject:
+			supplier := conversation.ParticipantByRole(ConversationRoleSupplier)
+			if supplier == nil {
+				return ErrNegotiationNotSupported
+			}
+			result = tx.Model(&VisitorProject{}).
+				Where("id = ? AND status IN ? AND accepted_supplier_id IS NULL AND expires_at > ?",
+					conversation.ContextID, []string{"pending", "active"}, now).
+				Updates(map[string]interface{}{
+					"status":               "accepted",
+					"accepted_supplier_id": supplier.ProfileID,
+					"accepted_at":          now,
+					"accepted_offer_id":    offer.ID,
+				})
+		default:
+			return ErrNegotiationNotSupported
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNegotiationClosed
+		}
+		return ExpirePendingNegotiationOffers(tx, conversation.ContextType, conversation.ContextID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getOfferMessage(db, offer)
+}
+
+// ExpirePendingNegotiationOffers expires the pending offers on a matching
+// request or visitor project once it has been accepted
+func ExpirePendingNegotiationOffers(db *gorm.DB, contextType string, contextID uint) error {
+	return db.Model(&NegotiationOffer{}).
+		Where("context_type = ? AND context_id = ? AND status = ?", contextType, contextID, OfferStatusPending).
+		Update("status", OfferStatusExpired).Error
+}
+
+// postNegotiationOffer stores a new offer version from userID, countering
+// parent when it is set, together with the message that carries it
+func postNegotiationOffer(db *gorm.DB, conversation *Conversation, userID uint, parent *NegotiationOffer, req NegotiationOfferRequest) (*ConversationMessage, error) {
+	sender := conversation.Participant(userID)
+	if sender == nil {
+		return nil, ErrNotConversationParticipant
+	}
+	if conversation.ContextType != ConversationContextMatchingRequest && conversation.ContextType != ConversationContextVisitorProject {
+		return nil, ErrNegotiationNotSupported
+	}
+	if conversation.Status == ConversationStatusClosed {
+		return nil, ErrConversationClosed
+	}
+	offer, err := req.offer(parent)
+	if err != nil {
+		return nil, err
+	}
+	offer.ConversationID = conversation.ID
+	offer.ContextType = conversation.ContextType
+	offer.ContextID = conversation.ContextID
+	offer.ProposedByID = userID
+	offer.ProposerRole = sender.Role
+
+	var message ConversationMessage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		open, err := negotiationContextOpen(tx, conversation)
+		if err != nil {
+			return err
+		}
+		if !open {
+			return ErrNegotiationClosed
+		}
+
+		var pending []NegotiationOffer
+		if err := tx.Where("conversation_id = ? AND status = ?", conversation.ID, OfferStatusPending).Find(&pending).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		parentPending := false
+		for _, p := range pending {
+			status := OfferStatusWithdrawn
+			switch {
+			case parent != nil && p.ID == parent.ID:
+				status = OfferStatusCountered
+				parentPending = true
+			case p.ProposedByID == userID:
+				// userID revises their own offer
+			case p.IsExpired(now):
+				status = OfferStatusExpired
+			default:
+				return ErrOfferAwaitingResponse
+			}
+			if err := setOfferStatus(tx, p.ID, status, userID); err != nil {
+				return err
+			}
+		}
+		if parent != nil && !parentPending {
+			return ErrOfferNotPending
+		}
+
+		if err := tx.Model(&NegotiationOffer{}).Where("conversation_id = ?", conversation.ID).
+			Select("COALESCE(MAX(version), 0) + 1").Scan(&offer.Version).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(offer).Error; err != nil {
+			return err
+		}
+
+		message = ConversationMessage{
+			ConversationID: conversation.ID,
+			SenderID:       userID,
+			SenderType:     sender.Role,
+			Message:        offer.Summary(),
+			OfferID:        &offer.ID,
+		}
+		return insertConversationMessage(tx, sender, &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getConversationMessage(db, conversation.ID, message.ID)
+}
+
+// answerableOffer loads a pending offer in the conversation that userID may
+// accept, reject or counter: one made by the other party and still valid
+func answerableOffer(db *gorm.DB, conversation *Conversation, offerID, userID uint) (*NegotiationOffer, error) {
+	if conversation.Participant(userID) == nil {
+		return nil, ErrNotConversationParticipant
+	}
+	var offer NegotiationOffer
+	if err := db.Where("conversation_id = ?", conversation.ID).First(&offer, offerID).Error; err != nil {
+		return nil, err
+	}
+	if offer.ProposedByID == userID {
+		return nil, ErrOwnOffer
+	}
+	if offer.Status != OfferStatusPending {
+		return nil, ErrOfferNotPending
+	}
+	if offer.IsExpired(time.Now()) {
+		db.Model(&NegotiationOffer{}).Where("id = ? AND status = ?", offer.ID, OfferStatusPending).
+			Update("status", OfferStatusExpired)
+		return nil, ErrOfferExpired
+	}
+	return &offer, nil
+}
+
+// setOfferStatus moves a pending offer to status. The status guard makes two
+// concurrent answers to the same offer fail with ErrOfferNotPending.
+func setOfferStatus(db *gorm.DB, offerID uint, status string, userID uint) error {
+	updates := map[string]interface{}{"status": status}
+	if status == OfferStatusAccepted || status == OfferStatusRejected || status == OfferStatusCountered {
+		updates["responded_by_id"] = userID
+		updates["responded_at"] = time.Now()
+	}
+	result := db.Model(&NegotiationOffer{}).Where("id = ? AND status = ?", offerID, OfferStatusPending).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOfferNotPending
+	}
+	return nil
+}
+
+// negotiationContextOpen reports whether the conversation's matching request
+// or visitor project still takes offers
+func negotiationContextOpen(db *gorm.DB, conversation *Conversation) (bool, error) {
+	switch conversation.ContextType {
+	case ConversationContextMatchingRequest:
+		var request MatchingRequest
+		if err := db.First(&request, conversation.ContextID).Error; err != nil {
+			return false, err
+		}
+		return request.IsOpenForNegotiation(), nil
+	case ConversationContextVisitorProject:
+		var project VisitorProject
+		if err := db.First(&project, conversation.ContextID).Error; err != nil {
+			return false, err
+		}
+		return project.IsOpenForNegotiation(), nil
+	}
+	return false, nil
+}
+
+// getOfferMessage loads the conversation message that carries offer
+func getOfferMessage(db *gorm.DB, offer *NegotiationOffer) (*ConversationMessage, error) {
+	var message ConversationMessage
+	if err := db.Select("id").Where("conversation_id = ? AND offer_id = ?", offer.ConversationID, offer.ID).
+		First(&message).Error; err != nil {
+		return nil, err
+	}
+	return getConversationMessage(db, offer.ConversationID, message.ID)
+}
@@ -6,24 +6,63 @@ import (
 	"gorm.io/gorm"
 )
 
+// Notification audiences
+const (
+	NotificationAudienceAll     = "all"     // every user
+	NotificationAudienceUser    = "user"    // a single user (UserID)
+	NotificationAudienceSegment = "segment" // users matching the Audience* criteria
+)
+
+// Notification delivery statuses
+const (
+	NotificationStatusScheduled = "scheduled"
+	NotificationStatusSending   = "sending"
+	NotificationStatusSent      = "sent"
+)
+
 type Notification struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Title       string         `json:"title" gorm:"size:255;not null;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
-	Message     string         `json:"message" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
-	Type        string         `json:"type" gorm:"size:50;default:'info'"`       // info, warning, success, error
-	Priority    string         `json:"priority" gorm:"size:20;default:'normal'"` // low, normal, high, urgent
-	IsActive    bool           `json:"is_active" gorm:"default:true"`
-	IsRead      bool           `json:"is_read" gorm:"default:false"`
-	UserID      *uint          `json:"user_id" gorm:"index"` // null means broadcast to all users
-	User        *User          `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	CreatedByID uint           `json:"created_by_id" gorm:"not null;index"`
-	CreatedBy   User           `json:"created_by" gorm:"foreignKey:CreatedByID"`
-	ExpiresAt   *time.Time     `json:"expires_at" gorm:"index"`
-	ActionURL   string         `json:"action_url" gorm:"size:500"`
-	ActionText  string         `json:"action_text" gorm:"size:100;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Title       string     `json:"title" gorm:"size:255;not null;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Message     string     `json:"message" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Type        string     `json:"type" gorm:"size:50;default:'info'"`       // info, warning, success, error
+	Priority    string     `json:"priority" gorm:"size:20;default:'normal'"` // low, normal, high, urgent
+	IsActive    bool       `json:"is_active" gorm:"default:true"`
+	IsRead      bool       `json:"is_read" gorm:"default:false"`
+	UserID      *uint      `json:"user_id" gorm:"index"` // null means broadcast to all users
+	User        *User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	CreatedByID uint       `json:"created_by_id" gorm:"not null;index"`
+	CreatedBy   User       `json:"created_by" gorm:"foreignKey:CreatedByID"`
+	ExpiresAt   *time.Time `json:"expires_at" gorm:"index"`
+	ActionURL   string     `json:"action_url" gorm:"size:500"`
+	ActionText  string     `json:"action_text" gorm:"size:100;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+
+	// Audience targeting (see NotificationSegment)
+	Audience                   string     `json:"audience" gorm:"size:20;default:'all'"`                                         // all, user, segment
+	AudienceLicenseTypes       string     `json:"audience_license_types" gorm:"size:100"`                                        // Comma separated
+	AudienceRoles              string     `json:"audience_roles" gorm:"size:100"`                                                // Comma separated
+	AudienceCities             string     `json:"audience_cities" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"` // Comma separated
+	AudiencePhones             string     `json:"-" gorm:"type:mediumtext"`                                                      // Comma separated
+	AudienceLicenseExpiresFrom *time.Time `json:"audience_license_expires_from"`
+	AudienceLicenseExpiresTo   *time.Time `json:"audience_license_expires_to"`
+	AudienceInactiveDays       int        `json:"audience_inactive_days" gorm:"default:0"`
+
+	// Scheduling and fan-out
+	Status      string     `json:"status" gorm:"size:20;default:'sent';index"` // scheduled, sending, sent
+	ScheduledAt *time.Time `json:"scheduled_at" gorm:"index"`
+	SentAt      *time.Time `json:"sent_at"`
+	SendPush    bool       `json:"send_push" gorm:"default:false"`
+	SendSMS     bool       `json:"send_sms" gorm:"default:false"`
+
+	// Delivery counts, updated as the outbox delivers each push and SMS job
+	RecipientCount  int `json:"recipient_count" gorm:"default:0"`
+	PushSentCount   int `json:"push_sent_count" gorm:"default:0"`
+	PushFailedCount int `json:"push_failed_count" gorm:"default:0"`
+	SMSSentCount    int `json:"sms_sent_count" gorm:"default:0"`
+	SMSFailedCount  int `json:"sms_failed_count" gorm:"default:0"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // DTO for creating a new notification
@@ -36,6 +75,11 @@ type CreateNotificationRequest struct {
 	ExpiresAt  *time.Time `json:"expires_at"`
 	ActionURL  string     `json:"action_url"`
 	ActionText string     `json:"action_text"`
+
+	Segment     *NotificationSegment `json:"segment"`      // optional; only for broadcasts (user_id null)
+	ScheduledAt *time.Time           `json:"scheduled_at"` // null or past sends immediately
+	SendPush    *bool                `json:"send_push"`    // defaults to true
+	SendSMS     bool                 `json:"send_sms"`
 }
 
 // DTO for updating notification
@@ -48,6 +92,8 @@ type UpdateNotificationRequest struct {
 	ExpiresAt  *time.Time `json:"expires_at"`
 	ActionURL  *string    `json:"action_url"`
 	ActionText *string    `json:"action_text"`
+
+	ScheduledAt *time.Time `json:"scheduled_at"` // only while the notification is still scheduled
 }
 
 // Response DTO for notification
@@ -68,10 +114,19 @@ type NotificationResponse struct {
 	ActionText  string        `json:"action_text"`
 	CreatedAt   time.Time     `json:"created_at"`
 	UpdatedAt   time.Time     `json:"updated_at"`
+
+	Delivery *NotificationDelivery `json:"delivery,omitempty"` // admin responses only
 }
 
-// CreateNotification creates a new notification
+// CreateNotification creates a new notification. It is stored as scheduled;
+// the notification dispatcher resolves recipients and makes it visible once due.
 func CreateNotification(db *gorm.DB, createdByID uint, req CreateNotificationRequest) (*Notification, error) {
+	now := time.Now()
+	scheduledAt := now
+	if req.ScheduledAt != nil && req.ScheduledAt.After(now) {
+		scheduledAt = *req.ScheduledAt
+	}
+
 	notification := Notification{
 		Title:       req.Title,
 		Message:     req.Message,
@@ -82,6 +137,11 @@ func CreateNotification(db *gorm.DB, createdByID uint, req CreateNotificationReq
 		ExpiresAt:   req.ExpiresAt,
 		ActionURL:   req.ActionURL,
 		ActionText:  req.ActionText,
+		Audience:    NotificationAudienceAll,
+		Status:      NotificationStatusScheduled,
+		ScheduledAt: &scheduledAt,
+		SendPush:    req.SendPush == nil || *req.SendPush,
+		SendSMS:     req.SendSMS,
 	}
 
 	// Set defaults
@@ -92,6 +152,20 @@ func CreateNotification(db *gorm.DB, createdByID uint, req CreateNotificationReq
 		notification.Priority = "normal"
 	}
 
+	switch {
+	case req.UserID != nil:
+		if req.Segment != nil {
+			return nil, &NotificationAudienceError{Message: "برای نوتیفیکیشن یک کاربر خاص نمی‌توان مخاطبان را بخش‌بندی کرد"}
+		}
+		notification.Audience = NotificationAudienceUser
+	case req.Segment != nil:
+		if err := req.Segment.Validate(); err != nil {
+			return nil, err
+		}
+		notification.Audience = NotificationAudienceSegment
+		notification.setSegment(*req.Segment)
+	}
+
 	if err := db.Create(&notification).Error; err != nil {
 		return nil, err
 	}
@@ -104,22 +178,18 @@ func CreateNotification(db *gorm.DB, createdByID uint, req CreateNotificationReq
 	return &notification, nil
 }
 
-// GetUserNotifications retrieves notifications for a specific user
+// GetUserNotifications retrieves notifications for a specific user with
+// IsRead reflecting that user's own read state
 func GetUserNotifications(db *gorm.DB, userID uint, page, perPage int, unreadOnly bool) ([]Notification, int64, error) {
 	var notifications []Notification
 	var total int64
 
-	query := db.Model(&Notification{}).Where("is_active = ?", true)
-
-	// Filter by user (broadcast notifications + user-specific notifications)
-	query = query.Where("(user_id IS NULL OR user_id = ?)", userID)
-
-	// Filter by expiration
-	query = query.Where("(expires_at IS NULL OR expires_at > ?)", time.Now())
+	// Broadcast + user-specific notifications, excluding expired and dismissed ones
+	query := visibleNotificationsQuery(db, userID)
 
 	// Filter by read status
 	if unreadOnly {
-		query = query.Where("is_read = ?", false)
+		query = unreadNotificationsFilter(query, userID)
 	}
 
 	if err := query.Count(&total).Error; err != nil {
@@ -134,6 +204,10 @@ func GetUserNotifications(db *gorm.DB, userID uint, page, perPage int, unreadOnl
 		return nil, 0, err
 	}
 
+	if err := ApplyNotificationReadState(db, userID, notifications); err != nil {
+		return nil, 0, err
+	}
+
 	return notifications, total, nil
 }
 
@@ -147,26 +221,30 @@ func GetNotification(db *gorm.DB, id uint) (*Notification, error) {
 	return &notification, nil
 }
 
-// MarkNotificationAsRead marks a notification as read
+// MarkNotificationAsRead marks a notification as read for a single user
 func MarkNotificationAsRead(db *gorm.DB, id, userID uint) error {
-	return db.Model(&Notification{}).
-		Where("id = ? AND (user_id IS NULL OR user_id = ?)", id, userID).
-		Update("is_read", true).Error
+	var notification Notification
+	if err := accessibleNotificationsQuery(db, userID).Where("notifications.id = ?", id).
+		First(&notification).Error; err != nil {
+		return err
+	}
+	return markNotificationsState(db, userID, []uint{notification.ID}, "read_at", time.Now())
 }
 
-// MarkAllNotificationsAsRead marks all notifications as read for a user
+// MarkAllNotificationsAsRead marks all visible notifications as read for a user
 func MarkAllNotificationsAsRead(db *gorm.DB, userID uint) error {
-	return db.Model(&Notification{}).
-		Where("(user_id IS NULL OR user_id = ?) AND is_read = ?", userID, false).
-		Update("is_read", true).Error
+	var ids []uint
+	if err := unreadNotificationsFilter(visibleNotificationsQuery(db, userID), userID).
+		Pluck("notifications.id", &ids).Error; err != nil {
+		return err
+	}
+	return markNotificationsState(db, userID, ids, "read_at", time.Now())
 }
 
 // GetUnreadNotificationCount gets the count of unread notifications for a user
 func GetUnreadNotificationCount(db *gorm.DB, userID uint) (int64, error) {
 	var count int64
-	err := db.Model(&Notification{}).
-		Where("is_active = ? AND (user_id IS NULL OR user_id = ?) AND is_read = ? AND (expires_at IS NULL OR expires_at > ?)",
-			true, userID, false, time.Now()).
+	err := unreadNotificationsFilter(visibleNotificationsQuery(db, userID), userID).
 		Count(&count).Error
 	return count, err
 }
@@ -203,6 +281,12 @@ func UpdateNotification(db *gorm.DB, id uint, req UpdateNotificationRequest) (*N
 	if req.ActionText != nil {
 		updates["action_text"] = *req.ActionText
 	}
+	if req.ScheduledAt != nil {
+		if notification.Status != NotificationStatusScheduled {
+			return nil, &NotificationAudienceError{Message: "زمان ارسال فقط برای نوتیفیکیشن‌های زمان‌بندی‌شده قابل تغییر است"}
+		}
+		updates["scheduled_at"] = *req.ScheduledAt
+	}
 
 	if err := db.Model(&notification).Updates(updates).Error; err != nil {
 		return nil, err
@@ -239,9 +323,11 @@ func GetNotificationStats(db *gorm.DB) (map[string]interface{}, error) {
 	}
 	stats["active"] = active
 
-	// Unread notifications (all users)
+	// Unread user-specific notifications (broadcast read state is per user, see below)
 	var unread int64
-	if err := db.Model(&Notification{}).Where("is_active = ? AND is_read = ?", true, false).Count(&unread).Error; err != nil {
+	if err := db.Model(&Notification{}).
+		Where("is_active = ? AND user_id IS NOT NULL AND is_read = ?", true, false).
+		Count(&unread).Error; err != nil {
 		return nil, err
 	}
 	stats["unread"] = unread
@@ -256,5 +342,12 @@ func GetNotificationStats(db *gorm.DB) (map[string]interface{}, error) {
 	}
 	stats["by_type"] = typeStats
 
+	// Read rates of recent broadcasts
+	broadcasts, err := GetBroadcastReadStats(db, 20)
+	if err != nil {
+		return nil, err
+	}
+	stats["broadcasts"] = broadcasts
+
 	return stats, nil
 }
@@ -0,0 +1,388 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"asl-market-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Notification segment roles
+const (
+	NotificationRoleSupplier          = "supplier"           // approved suppliers
+	NotificationRoleVisitor           = "visitor"            // approved visitors
+	NotificationRoleAffiliateReferred = "affiliate_referred" // users registered through an affiliate link
+)
+
+var notificationLicenseTypes = map[string]bool{"pro": true, "plus": true, "plus4": true}
+
+var notificationRoles = map[string]bool{
+	NotificationRoleSupplier:          true,
+	NotificationRoleVisitor:           true,
+	NotificationRoleAffiliateReferred: true,
+}
+
+// NotificationAudienceError is returned for an invalid audience or schedule
+type NotificationAudienceError struct {
+	Message string
+}
+
+func (e *NotificationAudienceError) Error() string {
+	return e.Message
+}
+
+// NotificationSegment selects the users a notification is sent to. Criteria of
+// different kinds are combined with AND; values within one kind with OR.
+type NotificationSegment struct {
+	LicenseTypes       []string   `json:"license_types"` // pro, plus, plus4 (active licenses)
+	Roles              []string   `json:"roles"`         // supplier, visitor, affiliate_referred
+	Cities             []string   `json:"cities"`        // supplier city or visitor city/province
+	LicenseExpiresFrom *time.Time `json:"license_expires_from"`
+	LicenseExpiresTo   *time.Time `json:"license_expires_to"`
+	Phones             []string   `json:"phones"`        // e.g. parsed from an uploaded Excel list
+	InactiveDays       int        `json:"inactive_days"` // users who have not used the app for this many days
+}
+
+// MaxNotificationInactiveDays bounds the inactivity criterion
+const MaxNotificationInactiveDays = 365
+
+// NotificationRecipient is a user a segmented notification was sent to
+type NotificationRecipient struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	NotificationID uint      `json:"notification_id" gorm:"not null;uniqueIndex:idx_notification_recipient"`
+	UserID         uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_notification_recipient;index"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for NotificationRecipient
+func (NotificationRecipient) TableName() string {
+	return "notification_recipients"
+}
+
+// NotificationDelivery summarises how a notification was delivered
+type NotificationDelivery struct {
+	Audience        string               `json:"audience"`
+	Segment         *NotificationSegment `json:"segment,omitempty"`
+	Status          string               `json:"status"`
+	ScheduledAt     *time.Time           `json:"scheduled_at"`
+	SentAt          *time.Time           `json:"sent_at"`
+	SendPush        bool                 `json:"send_push"`
+	SendSMS         bool                 `json:"send_sms"`
+	RecipientCount  int                  `json:"recipient_count"`
+	PushSentCount   int                  `json:"push_sent_count"`
+	PushFailedCount int                  `json:"push_failed_count"`
+	SMSSentCount    int                  `json:"sms_sent_count"`
+	SMSFailedCount  int                  `json:"sms_failed_count"`
+}
+
+// NotificationRecipientContact is the minimal user data needed for fan-out
+type NotificationRecipientContact struct {
+	ID    uint
+	Phone string
+}
+
+// Validate checks segment values
+func (s *NotificationSegment) Validate() error {
+	s.LicenseTypes = cleanList(s.LicenseTypes)
+	s.Roles = cleanList(s.Roles)
+	s.Cities = cleanList(s.Cities)
+
+	var phones []string
+	for _, p := range s.Phones {
+		if normalized := NormalizeLocalPhone(p); normalized != "" {
+			phones = append(phones, normalized)
+		}
+	}
+	s.Phones = cleanList(phones)
+
+	for _, t := range s.LicenseTypes {
+		if !notificationLicenseTypes[t] {
+			return &NotificationAudienceError{Message: "نوع لایسنس نامعتبر است: " + t}
+		}
+	}
+	for _, r := range s.Roles {
+		if !notificationRoles[r] {
+			return &NotificationAudienceError{Message: "نقش کاربری نامعتبر است: " + r}
+		}
+	}
+	if s.LicenseExpiresFrom != nil && s.LicenseExpiresTo != nil && s.LicenseExpiresTo.Before(*s.LicenseExpiresFrom) {
+		return &NotificationAudienceError{Message: "بازه انقضای لایسنس نامعتبر است"}
+	}
+	if s.InactiveDays < 0 || s.InactiveDays > MaxNotificationInactiveDays {
+		return &NotificationAudienceError{Message: "تعداد روزهای عدم فعالیت باید بین ۱ تا ۳۶۵ باشد، یا ۰ برای استفاده نکردن از این معیار"}
+	}
+	if len(s.LicenseTypes) == 0 && len(s.Roles) == 0 && len(s.Cities) == 0 && len(s.Phones) == 0 &&
+		s.LicenseExpiresFrom == nil && s.LicenseExpiresTo == nil && s.InactiveDays == 0 {
+		return &NotificationAudienceError{Message: "حداقل یک معیار برای مخاطبان انتخاب کنید"}
+	}
+	return nil
+}
+
+func cleanList(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Contains(v, ",") || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// NormalizeLocalPhone converts an Iranian mobile number to 09xxxxxxxxx, or "" if invalid
+func NormalizeLocalPhone(phone string) string {
+	digits := make([]rune, 0, len(phone))
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, r)
+		case r >= '۰' && r <= '۹': // Persian digits
+			digits = append(digits, '0'+(r-'۰'))
+		}
+	}
+	d := string(digits)
+
+	switch {
+	case strings.HasPrefix(d, "0098"):
+		d = "0" + d[4:]
+	case strings.HasPrefix(d, "98") && len(d) == 12:
+		d = "0" + d[2:]
+	case strings.HasPrefix(d, "9") && len(d) == 10:
+		d = "0" + d
+	}
+
+	if len(d) != 11 || !strings.HasPrefix(d, "09") {
+		return ""
+	}
+	return d
+}
+
+// phoneVariants lists the formats a phone may be stored in
+func phoneVariants(phones []string) []string {
+	variants := make([]string, 0, len(phones)*3)
+	for _, p := range phones {
+		variants = append(variants, p, "98"+p[1:], "+98"+p[1:])
+	}
+	return variants
+}
+
+// setSegment stores segment criteria on the notification
+func (n *Notification) setSegment(s NotificationSegment) {
+	n.AudienceLicenseTypes = strings.Join(s.LicenseTypes, ",")
+	n.AudienceRoles = strings.Join(s.Roles, ",")
+	n.AudienceCities = strings.Join(s.Cities, ",")
+	n.AudiencePhones = strings.Join(s.Phones, ",")
+	n.AudienceLicenseExpiresFrom = s.LicenseExpiresFrom
+	n.AudienceLicenseExpiresTo = s.LicenseExpiresTo
+	n.AudienceInactiveDays = s.InactiveDays
+}
+
+// Segment returns the stored segment criteria, or nil if not segmented
+func (n *Notification) Segment() *NotificationSegment {
+	if n.Audience != NotificationAudienceSegment {
+		return nil
+	}
+	return &NotificationSegment{
+		LicenseTypes:       splitList(n.AudienceLicenseTypes),
+		Roles:              splitList(n.AudienceRoles),
+		Cities:             splitList(n.AudienceCities),
+		Phones:             splitList(n.AudiencePhones),
+		LicenseExpiresFrom: n.AudienceLicenseExpiresFrom,
+		LicenseExpiresTo:   n.AudienceLicenseExpiresTo,
+		InactiveDays:       n.AudienceInactiveDays,
+	}
+}
+
+// DeliveryInfo returns the audience, schedule and delivery counts for admin views
+func (n *Notification) DeliveryInfo() *NotificationDelivery {
+	delivery := &NotificationDelivery{
+		Audience:        n.Audience,
+		Segment:         n.Segment(),
+		Status:          n.Status,
+		ScheduledAt:     n.ScheduledAt,
+		SentAt:          n.SentAt,
+		SendPush:        n.SendPush,
+		SendSMS:         n.SendSMS,
+		RecipientCount:  n.RecipientCount,
+		PushSentCount:   n.PushSentCount,
+		PushFailedCount: n.PushFailedCount,
+		SMSSentCount:    n.SMSSentCount,
+		SMSFailedCount:  n.SMSFailedCount,
+	}
+	if delivery.Segment != nil {
+		// Phone lists can be large; report the count only
+		delivery.Segment.Phones = nil
+	}
+	return delivery
+}
+
+// SegmentUsersQuery returns active users matching the segment
+func SegmentUsersQuery(db *gorm.DB, s NotificationSegment) *gorm.DB {
+	now := time.Now()
+	query := db.Model(&User{}).Where("users.is_active = ?", true)
+
+	if len(s.LicenseTypes) > 0 {
+		query = query.Where("users.id IN (?)", db.Model(&License{}).Select("used_by").
+			Where("is_used = ? AND expires_at > ? AND type IN ?", true, now, s.LicenseTypes))
+	}
+
+	if s.LicenseExpiresFrom != nil || s.LicenseExpiresTo != nil {
+		expiring := db.Model(&License{}).Select("used_by").Where("is_used = ?", true)
+		if s.LicenseExpiresFrom != nil {
+			expiring = expiring.Where("expires_at >= ?", *s.LicenseExpiresFrom)
+		}
+		if s.LicenseExpiresTo != nil {
+			expiring = expiring.Where("expires_at <= ?", *s.LicenseExpiresTo)
+		}
+		query = query.Where("users.id IN (?)", expiring)
+	}
+
+	if len(s.Roles) > 0 {
+		var conditions []string
+		var args []interface{}
+		for _, role := range s.Roles {
+			switch role {
+			case NotificationRoleSupplier:
+				conditions = append(conditions, "users.id IN (?)")
+				args = append(args, db.Model(&Supplier{}).Select("user_id").Where("status = ?", "approved"))
+			case NotificationRoleVisitor:
+				conditions = append(conditions, "users.id IN (?)")
+				args = append(args, db.Model(&Visitor{}).Select("user_id").Where("status = ?", "approved"))
+			case NotificationRoleAffiliateReferred:
+				conditions = append(conditions, "users.affiliate_id IS NOT NULL")
+			}
+		}
+		if len(conditions) > 0 {
+			query = query.Where("("+strings.Join(conditions, " OR ")+")", args...)
+		}
+	}
+
+	if len(s.Cities) > 0 {
+		query = query.Where("(users.id IN (?) OR users.id IN (?))",
+			db.Model(&Supplier{}).Select("user_id").Where("city IN ?", s.Cities),
+			db.Model(&Visitor{}).Select("user_id").Where("city_province IN ?", s.Cities))
+	}
+
+	if len(s.Phones) > 0 {
+		query = query.Where("users.phone IN ?", phoneVariants(s.Phones))
+	}
+
+	// Inactive users have not logged in or refreshed a session since the
+	// cutoff; users who registered after it are not inactive yet
+	if s.InactiveDays > 0 {
+		cutoff := now.AddDate(0, 0, -s.InactiveDays)
+		query = query.Where("users.created_at < ?", cutoff).
+			Where("users.id NOT IN (?)", db.Model(&AuthSession{}).Select("subject_id").
+				Where("subject_type = ? AND COALESCE(last_used_at, created_at) >= ?", utils.SubjectUser, cutoff))
+	}
+
+	return query
+}
+
+// CountSegmentUsers counts active users matching the segment (admin preview)
+func CountSegmentUsers(db *gorm.DB, s NotificationSegment) (int64, error) {
+	var count int64
+	err := SegmentUsersQuery(db, s).Count(&count).Error
+	return count, err
+}
+
+// ResolveNotificationRecipients returns the users a notification should reach
+func ResolveNotificationRecipients(db *gorm.DB, n *Notification) ([]NotificationRecipientContact, error) {
+	var contacts []NotificationRecipientContact
+	query := db.Model(&User{}).Where("users.is_active = ?", true)
+
+	switch {
+	case n.UserID != nil:
+		query = db.Model(&User{}).Where("users.id = ?", *n.UserID)
+	case n.Audience == NotificationAudienceSegment:
+		query = SegmentUsersQuery(db, *n.Segment())
+	}
+
+	err := query.Select("users.id, users.phone").Order("users.id").Scan(&contacts).Error
+	return contacts, err
+}
+
+// ClaimNotificationForSending moves a due scheduled notification to sending.
+// Returns false if another worker already claimed it or it is not yet due.
+func ClaimNotificationForSending(db *gorm.DB, id uint) (bool, error) {
+	result := db.Model(&Notification{}).
+		Where("id = ? AND status = ? AND (scheduled_at IS NULL OR scheduled_at <= ?)", id, NotificationStatusScheduled, time.Now()).
+		Update("status", NotificationStatusSending)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetDueNotificationIDs lists scheduled notifications whose send time has passed
+func GetDueNotificationIDs(db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&Notification{}).
+		Where("status = ? AND (scheduled_at IS NULL OR scheduled_at <= ?)", NotificationStatusScheduled, time.Now()).
+		Order("scheduled_at").
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// PublishNotification records the recipients of a segmented notification and
+// marks it sent, which makes it visible in users' notification lists. Users in
+// hiddenFrom turned the notification's topic off in-app: they are left out of
+// a segment, and get a notification to them or to everyone already dismissed.
+func PublishNotification(db *gorm.DB, n *Notification, recipients []NotificationRecipientContact, hiddenFrom []uint) error {
+	now := time.Now()
+	hidden := make(map[uint]bool, len(hiddenFrom))
+	for _, id := range hiddenFrom {
+		hidden[id] = true
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		shown := 0
+		if n.Audience == NotificationAudienceSegment {
+			rows := make([]NotificationRecipient, 0, len(recipients))
+			for _, r := range recipients {
+				if !hidden[r.ID] {
+					rows = append(rows, NotificationRecipient{NotificationID: n.ID, UserID: r.ID})
+				}
+			}
+			if len(rows) > 0 {
+				if err := tx.CreateInBatches(&rows, 500).Error; err != nil {
+					return err
+				}
+			}
+			shown = len(rows)
+		} else {
+			var dismissed []NotificationRead
+			for _, r := range recipients {
+				if hidden[r.ID] {
+					dismissed = append(dismissed, NotificationRead{UserID: r.ID, NotificationID: n.ID, DismissedAt: &now})
+				}
+			}
+			if len(dismissed) > 0 {
+				if err := tx.CreateInBatches(&dismissed, 500).Error; err != nil {
+					return err
+				}
+			}
+			shown = len(recipients) - len(dismissed)
+		}
+		return tx.Model(n).Updates(map[string]interface{}{
+			"status":          NotificationStatusSent,
+			"sent_at":         now,
+			"recipient_count": shown,
+		}).Error
+	})
+}
+
+// CanUserAccessNotification reports whether a sent notification is addressed to the user
+func CanUserAccessNotification(db *gorm.DB, n *Notification, userID uint) (bool, error) {
+	var count int64
+	err := accessibleNotificationsQuery(db, userID).Where("notifications.id = ?", n.ID).Count(&count).Error
+	return count > 0, err
+}
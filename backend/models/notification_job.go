@@ -0,0 +1,370 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"asl-market-backend/config"
+
+	"gorm.io/gorm"
+)
+
+// Delivery channels of the notification outbox
+const (
+	NotificationChannelInApp = "in_app"
+	NotificationChannelPush  = "push"
+	NotificationChannelSMS   = "sms"
+)
+
+// NotificationChannels lists every outbox channel
+var NotificationChannels = []string{NotificationChannelInApp, NotificationChannelPush, NotificationChannelSMS}
+
+// Notification job statuses. A job is retried while pending and moves to
+// dead once its attempts run out or its error cannot be retried. Jobs the
+// recipient opted out of are skipped.
+const (
+	NotificationJobPending    = "pending"
+	NotificationJobProcessing = "processing"
+	NotificationJobSent       = "sent"
+	NotificationJobDead       = "dead"
+	NotificationJobSkipped    = "skipped"
+)
+
+// What a notification job delivers. The source row is updated with the
+// job's outcome.
+const (
+	NotificationJobSourceMatching       = "matching_notification"        // MatchingNotification
+	NotificationJobSourceVisitorProject = "visitor_project_notification" // VisitorProjectNotification
+	NotificationJobSourceNotification   = "notification"                 // admin Notification fan-out
+	NotificationJobSourcePushCampaign   = "push_campaign_recipient"      // PushCampaignRecipient
+)
+
+// DefaultNotificationJobMaxAttempts is used for jobs enqueued without a limit
+// when outbox.max_attempts is not set
+const DefaultNotificationJobMaxAttempts = 5
+
+// ErrNotificationJobNotDead is returned when retrying a job that has not been dead-lettered
+var ErrNotificationJobNotDead = errors.New("only dead notification jobs can be retried")
+
+// NotificationJob is one delivery to one recipient on one channel, stored so
+// that it survives restarts and can be retried
+type NotificationJob struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Channel       string     `json:"channel" gorm:"size:10;not null;index:idx_notification_job_due,priority:2"`
+	Status        string     `json:"status" gorm:"size:20;not null;default:'pending';index:idx_notification_job_due,priority:1"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"not null;index:idx_notification_job_due,priority:3"`
+	UserID        *uint      `json:"user_id" gorm:"index"`
+	Phone         string     `json:"phone" gorm:"size:20"` // SMS recipient; the user's phone when empty
+	Title         string     `json:"title" gorm:"size:255"`
+	Message       string     `json:"message" gorm:"type:text"`
+	Payload       string     `json:"payload" gorm:"type:text"` // channel-specific JSON
+	Topic         string     `json:"topic" gorm:"size:30"`     // preference topic, empty for account notices
+	SourceType    string     `json:"source_type" gorm:"size:40;index:idx_notification_job_source"`
+	SourceID      uint       `json:"source_id" gorm:"index:idx_notification_job_source"`
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts   int        `json:"max_attempts" gorm:"default:5"`
+	LockedUntil   *time.Time `json:"locked_until"` // lease of the worker processing it
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	SentAt        *time.Time `json:"sent_at"`
+	DeadAt        *time.Time `json:"dead_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// NotificationJobFilter narrows the admin list of jobs
+type NotificationJobFilter struct {
+	Status     string
+	Channel    string
+	SourceType string
+}
+
+// NotificationJobStat counts the jobs of one channel in one status
+type NotificationJobStat struct {
+	Channel string `json:"channel"`
+	Status  string `json:"status"`
+	Count   int64  `json:"count"`
+}
+
+// NotificationJobBackoff returns how long to wait before retrying a job that
+// has failed attempts times: 30s, 1m, 2m, ... capped at one hour
+func NotificationJobBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// EnqueueNotificationJobs stores jobs for the outbox workers. Jobs without
+// their own limit get outbox.max_attempts.
+func EnqueueNotificationJobs(db *gorm.DB, jobs []NotificationJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	maxAttempts := DefaultNotificationJobMaxAttempts
+	if config.AppConfig != nil && config.AppConfig.Outbox.MaxAttempts > 0 {
+		maxAttempts = config.AppConfig.Outbox.MaxAttempts
+	}
+	now := time.Now()
+	for i := range jobs {
+		jobs[i].Status = NotificationJobPending
+		if jobs[i].NextAttemptAt.IsZero() {
+			jobs[i].NextAttemptAt = now
+		}
+		if jobs[i].MaxAttempts <= 0 {
+			jobs[i].MaxAttempts = maxAttempts
+		}
+	}
+	return db.CreateInBatches(&jobs, 500).Error
+}
+
+// ClaimNotificationJobs takes up to limit due jobs of a channel for one
+// worker, counting the attempt. Jobs whose worker died are taken again once
+// their lease runs out. Each job is claimed with a guarded update so
+// concurrent workers never get the same job.
+func ClaimNotificationJobs(db *gorm.DB, channel string, limit int, lease time.Duration) ([]NotificationJob, error) {
+	now := time.Now()
+	var ids []uint
+	err := db.Model(&NotificationJob{}).
+		Where("channel = ?", channel).
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+			NotificationJobPending, now, NotificationJobProcessing, now).
+		Order("next_attempt_at ASC, id ASC").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	lockedUntil := now.Add(lease)
+	claimed := make([]NotificationJob, 0, len(ids))
+	for _, id := range ids {
+		result := db.Model(&NotificationJob{}).
+			Where("id = ?", id).
+			Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+				NotificationJobPending, now, NotificationJobProcessing, now).
+			Updates(map[string]interface{}{
+				"status":       NotificationJobProcessing,
+				"locked_until": lockedUntil,
+				"attempts":     gorm.Expr("attempts + 1"),
+			})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		var job NotificationJob
+		if err := db.First(&job, id).Error; err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, job)
+	}
+	return claimed, nil
+}
+
+// MarkNotificationJobSent records a delivered job and its source
+func MarkNotificationJobSent(db *gorm.DB, job *NotificationJob) error {
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&NotificationJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":       NotificationJobSent,
+			"sent_at":      now,
+			"locked_until": nil,
+			"last_error":   "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		return finishNotificationJobSource(tx, job, true, "", now)
+	})
+}
+
+// MarkNotificationJobFailed records a failed attempt. The job is retried
+// after its backoff unless retryable is false or its attempts have run out,
+// in which case it is dead-lettered and its source marked failed.
+func MarkNotificationJobFailed(db *gorm.DB, job *NotificationJob, errMsg string, retryable bool) error {
+	now := time.Now()
+	if retryable && job.Attempts < job.MaxAttempts {
+		return db.Model(&NotificationJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":          NotificationJobPending,
+			"next_attempt_at": now.Add(NotificationJobBackoff(job.Attempts)),
+			"locked_until":    nil,
+			"last_error":      errMsg,
+		}).Error
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&NotificationJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":       NotificationJobDead,
+			"dead_at":      now,
+			"locked_until": nil,
+			"last_error":   errMsg,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return finishNotificationJobSource(tx, job, false, errMsg, now)
+	})
+}
+
+// MarkNotificationJobSkipped drops a job the recipient opted out of and marks
+// its source skipped
+func MarkNotificationJobSkipped(db *gorm.DB, job *NotificationJob, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&NotificationJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":       NotificationJobSkipped,
+			"locked_until": nil,
+			"last_error":   reason,
+		}).Error
+		if err != nil {
+			return err
+		}
+		switch job.SourceType {
+		case NotificationJobSourceMatching, NotificationJobSourceVisitorProject, NotificationJobSourcePushCampaign:
+			return tx.Model(notificationJobSourceModel(job.SourceType)).Where("id = ?", job.SourceID).
+				Updates(map[string]interface{}{"status": "skipped", "error": reason}).Error
+		}
+		return nil
+	})
+}
+
+// DeferNotificationJob puts a claimed job back in the queue until the given
+// time without counting the attempt, as during the recipient's quiet hours
+func DeferNotificationJob(db *gorm.DB, job *NotificationJob, until time.Time) error {
+	return db.Model(&NotificationJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":          NotificationJobPending,
+		"next_attempt_at": until,
+		"locked_until":    nil,
+		"attempts":        gorm.Expr("attempts - 1"),
+	}).Error
+}
+
+// RetryNotificationJob puts a dead job back in the queue with fresh attempts
+func RetryNotificationJob(db *gorm.DB, id uint) (*NotificationJob, error) {
+	var job NotificationJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&job, id).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&NotificationJob{}).Where("id = ? AND status = ?", id, NotificationJobDead).Updates(map[string]interface{}{
+			"status":          NotificationJobPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"dead_at":         nil,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotificationJobNotDead
+		}
+		return reopenNotificationJobSource(tx, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetNotificationJobs lists jobs, newest first
+func GetNotificationJobs(db *gorm.DB, filter NotificationJobFilter, limit, offset int) ([]NotificationJob, int64, error) {
+	query := db.Model(&NotificationJob{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Channel != "" {
+		query = query.Where("channel = ?", filter.Channel)
+	}
+	if filter.SourceType != "" {
+		query = query.Where("source_type = ?", filter.SourceType)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var jobs []NotificationJob
+	err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&jobs).Error
+	return jobs, total, err
+}
+
+// GetNotificationJobStats counts jobs per channel and status
+func GetNotificationJobStats(db *gorm.DB) ([]NotificationJobStat, error) {
+	var stats []NotificationJobStat
+	err := db.Model(&NotificationJob{}).
+		Select("channel, status, COUNT(*) AS count").
+		Group("channel, status").Order("channel, status").Scan(&stats).Error
+	return stats, err
+}
+
+// finishNotificationJobSource copies a job's final outcome to the row it
+// delivers. Admin notifications count delivered and failed push and SMS.
+func finishNotificationJobSource(tx *gorm.DB, job *NotificationJob, sent bool, errMsg string, at time.Time) error {
+	switch job.SourceType {
+	case NotificationJobSourceMatching, NotificationJobSourceVisitorProject, NotificationJobSourcePushCampaign:
+		updates := map[string]interface{}{"status": "failed", "error": errMsg}
+		if sent {
+			updates = map[string]interface{}{"status": "sent", "error": "", "sent_at": at}
+		}
+		return tx.Model(notificationJobSourceModel(job.SourceType)).Where("id = ?", job.SourceID).Updates(updates).Error
+	case NotificationJobSourceNotification:
+		column := notificationDeliveryColumn(job.Channel, sent)
+		if column == "" {
+			return nil
+		}
+		return tx.Model(&Notification{}).Where("id = ?", job.SourceID).
+			UpdateColumn(column, gorm.Expr(column+" + 1")).Error
+	}
+	return nil
+}
+
+// reopenNotificationJobSource undoes finishNotificationJobSource for a failed
+// job that is being retried
+func reopenNotificationJobSource(tx *gorm.DB, job *NotificationJob) error {
+	switch job.SourceType {
+	case NotificationJobSourceMatching, NotificationJobSourceVisitorProject, NotificationJobSourcePushCampaign:
+		return tx.Model(notificationJobSourceModel(job.SourceType)).Where("id = ?", job.SourceID).
+			Update("status", "pending").Error
+	case NotificationJobSourceNotification:
+		column := notificationDeliveryColumn(job.Channel, false)
+		if column == "" {
+			return nil
+		}
+		return tx.Model(&Notification{}).Where("id = ? AND "+column+" > 0", job.SourceID).
+			UpdateColumn(column, gorm.Expr(column+" - 1")).Error
+	}
+	return nil
+}
+
+func notificationJobSourceModel(sourceType string) interface{} {
+	switch sourceType {
+	case NotificationJobSourceVisitorProject:
+		return &VisitorProjectNotification{}
+	case NotificationJobSourcePushCampaign:
+		return &PushCampaignRecipient{}
+	}
+	return &MatchingNotification{}
+}
+
+// notificationDeliveryColumn is the Notification counter of a channel's outcome
+func notificationDeliveryColumn(channel string, sent bool) string {
+	switch {
+	case channel == NotificationChannelPush && sent:
+		return "push_sent_count"
+	case channel == NotificationChannelPush:
+		return "push_failed_count"
+	case channel == NotificationChannelSMS && sent:
+		return "sms_sent_count"
+	case channel == NotificationChannelSMS:
+		return "sms_failed_count"
+	}
+	return ""
+}
@@ -0,0 +1,391 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NotificationPreferenceChannels lists the channels a user can turn off.
+// There is no telegram channel: the bot only talks to admins and users have
+// no linked Telegram chat, so nothing would follow the switch.
+var NotificationPreferenceChannels = []string{
+	NotificationChannelInApp, NotificationChannelPush, NotificationChannelSMS,
+}
+
+// Notification topics. Deliveries without a topic are account notices (such
+// as license expiry) that only follow the channel switches, quiet hours and
+// SMS limit.
+const (
+	NotificationTopicMatchingRequests  = "matching_requests"
+	NotificationTopicChatMessages      = "chat_messages"
+	NotificationTopicTicketReplies     = "ticket_replies"
+	NotificationTopicWithdrawalUpdates = "withdrawal_updates"
+	NotificationTopicMarketing         = "marketing"
+)
+
+// NotificationTopics lists every topic a user can configure
+var NotificationTopics = []string{
+	NotificationTopicMatchingRequests, NotificationTopicChatMessages, NotificationTopicTicketReplies,
+	NotificationTopicWithdrawalUpdates, NotificationTopicMarketing,
+}
+
+// Daily SMS limit bounds. Users without preferences get the default.
+const (
+	DefaultNotificationDailySMSLimit = 20
+	MaxNotificationDailySMSLimit     = 100
+)
+
+// ErrInvalidNotificationPreference is returned for preferences that fail validation
+var ErrInvalidNotificationPreference = errors.New("invalid notification preference")
+
+// NotificationPreference holds a user's delivery settings. Users without a
+// row get DefaultNotificationPreference.
+type NotificationPreference struct {
+	ID                uint      `json:"-" gorm:"primaryKey"`
+	UserID            uint      `json:"-" gorm:"uniqueIndex;not null"`
+	InAppEnabled      bool      `json:"-" gorm:"not null"`
+	PushEnabled       bool      `json:"-" gorm:"not null"`
+	SMSEnabled        bool      `json:"-" gorm:"not null"`
+	TopicSettings     string    `json:"-" gorm:"type:text"` // JSON: topic -> channel -> enabled, only the turned off channels
+	QuietHoursEnabled bool      `json:"-" gorm:"not null"`
+	QuietHoursStart   string    `json:"-" gorm:"size:5"` // HH:MM, Asia/Tehran
+	QuietHoursEnd     string    `json:"-" gorm:"size:5"` // HH:MM, Asia/Tehran
+	DailySMSLimit     int       `json:"-" gorm:"not null"`
+	CreatedAt         time.Time `json:"-"`
+	UpdatedAt         time.Time `json:"-"`
+}
+
+// NotificationQuietHours is the daily window in which push and SMS are held
+// back. The window may run past midnight (22:00 to 08:00).
+type NotificationQuietHours struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// NotificationPreferenceSettings is the API form of a user's preferences
+type NotificationPreferenceSettings struct {
+	Channels      map[string]bool            `json:"channels"`
+	Topics        map[string]map[string]bool `json:"topics"`
+	QuietHours    *NotificationQuietHours    `json:"quiet_hours"`
+	DailySMSLimit int                        `json:"daily_sms_limit"`
+}
+
+// NotificationDecision is whether a delivery may be sent now. A delivery that
+// is not allowed is either dropped or held until DeferUntil.
+type NotificationDecision struct {
+	Allowed    bool
+	DeferUntil *time.Time
+	Reason     string
+}
+
+// Skipped reports whether the delivery should be dropped
+func (d NotificationDecision) Skipped() bool {
+	return !d.Allowed && d.DeferUntil == nil
+}
+
+// tehranLocation is the zone quiet hours and the daily SMS limit are counted in
+var tehranLocation = loadTehranLocation()
+
+func loadTehranLocation() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Tehran"); err == nil {
+		return loc
+	}
+	// Iran has had no daylight saving since 2022
+	return time.FixedZone("Asia/Tehran", 3*3600+30*60)
+}
+
+// DefaultNotificationPreference returns the preferences of a user who has not
+// changed them: every channel and topic on, no quiet hours
+func DefaultNotificationPreference(userID uint) *NotificationPreference {
+	return &NotificationPreference{
+		UserID:          userID,
+		InAppEnabled:    true,
+		PushEnabled:     true,
+		SMSEnabled:      true,
+		QuietHoursStart: "22:00",
+		QuietHoursEnd:   "08:00",
+		DailySMSLimit:   DefaultNotificationDailySMSLimit,
+	}
+}
+
+// GetNotificationPreference returns a user's preferences, or the defaults
+func GetNotificationPreference(db *gorm.DB, userID uint) (*NotificationPreference, error) {
+	var pref NotificationPreference
+	err := db.Where("user_id = ?", userID).First(&pref).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultNotificationPreference(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pref, nil
+}
+
+// SaveNotificationPreference validates and stores a user's preferences.
+// Anything left out of settings keeps its value.
+func SaveNotificationPreference(db *gorm.DB, userID uint, settings NotificationPreferenceSettings) (*NotificationPreference, error) {
+	pref, err := GetNotificationPreference(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pref.apply(settings); err != nil {
+		return nil, err
+	}
+	if err := db.Save(pref).Error; err != nil {
+		return nil, err
+	}
+	return pref, nil
+}
+
+// apply copies settings onto the preference after validating them
+func (p *NotificationPreference) apply(s NotificationPreferenceSettings) error {
+	for channel, enabled := range s.Channels {
+		field := p.channelField(channel)
+		if field == nil {
+			return fmt.Errorf("%w: unknown channel %q", ErrInvalidNotificationPreference, channel)
+		}
+		*field = enabled
+	}
+
+	topics := p.topicSettings()
+	for topic, channels := range s.Topics {
+		if !isNotificationTopic(topic) {
+			return fmt.Errorf("%w: unknown topic %q", ErrInvalidNotificationPreference, topic)
+		}
+		for channel, enabled := range channels {
+			if p.channelField(channel) == nil {
+				return fmt.Errorf("%w: unknown channel %q", ErrInvalidNotificationPreference, channel)
+			}
+			if enabled {
+				delete(topics[topic], channel)
+				continue
+			}
+			if topics[topic] == nil {
+				topics[topic] = make(map[string]bool)
+			}
+			topics[topic][channel] = false
+		}
+		if len(topics[topic]) == 0 {
+			delete(topics, topic)
+		}
+	}
+	data, _ := json.Marshal(topics)
+	p.TopicSettings = string(data)
+
+	if q := s.QuietHours; q != nil {
+		start, end := q.Start, q.End
+		if start == "" {
+			start = p.QuietHoursStart
+		}
+		if end == "" {
+			end = p.QuietHoursEnd
+		}
+		startMin, okStart := parseClock(start)
+		endMin, okEnd := parseClock(end)
+		if !okStart || !okEnd {
+			return fmt.Errorf("%w: quiet hours must be HH:MM", ErrInvalidNotificationPreference)
+		}
+		if q.Enabled && startMin == endMin {
+			return fmt.Errorf("%w: quiet hours start and end must differ", ErrInvalidNotificationPreference)
+		}
+		p.QuietHoursEnabled = q.Enabled
+		p.QuietHoursStart, p.QuietHoursEnd = start, end
+	}
+
+	if s.DailySMSLimit != 0 {
+		if s.DailySMSLimit < 1 || s.DailySMSLimit > MaxNotificationDailySMSLimit {
+			return fmt.Errorf("%w: daily SMS limit must be between 1 and %d", ErrInvalidNotificationPreference, MaxNotificationDailySMSLimit)
+		}
+		p.DailySMSLimit = s.DailySMSLimit
+	}
+	return nil
+}
+
+// Settings returns the preferences in their API form with every channel and
+// topic listed
+func (p *NotificationPreference) Settings() NotificationPreferenceSettings {
+	s := NotificationPreferenceSettings{
+		Channels: make(map[string]bool),
+		Topics:   make(map[string]map[string]bool),
+		QuietHours: &NotificationQuietHours{
+			Enabled:  p.QuietHoursEnabled,
+			Start:    p.QuietHoursStart,
+			End:      p.QuietHoursEnd,
+			Timezone: tehranLocation.String(),
+		},
+		DailySMSLimit: p.DailySMSLimit,
+	}
+	for _, channel := range NotificationPreferenceChannels {
+		s.Channels[channel] = *p.channelField(channel)
+	}
+	for _, topic := range NotificationTopics {
+		s.Topics[topic] = make(map[string]bool)
+		for _, channel := range NotificationPreferenceChannels {
+			s.Topics[topic][channel] = p.TopicEnabled(topic, channel)
+		}
+	}
+	return s
+}
+
+// TopicEnabled reports whether the user wants a topic on a channel, ignoring
+// the channel switch itself
+func (p *NotificationPreference) TopicEnabled(topic, channel string) bool {
+	enabled, ok := p.topicSettings()[topic][channel]
+	return !ok || enabled
+}
+
+// Decide returns whether a delivery on channel about topic may be sent at
+// now. smsSentToday is only used for SMS. In-app deliveries are never held
+// back by quiet hours.
+func (p *NotificationPreference) Decide(channel, topic string, now time.Time, smsSentToday int) NotificationDecision {
+	if field := p.channelField(channel); field != nil && !*field {
+		return NotificationDecision{Reason: channel + " disabled by user"}
+	}
+	if topic != "" && !p.TopicEnabled(topic, channel) {
+		return NotificationDecision{Reason: topic + " on " + channel + " disabled by user"}
+	}
+	if channel == NotificationChannelInApp {
+		return NotificationDecision{Allowed: true}
+	}
+	if until := p.quietHoursEnd(now); until != nil {
+		return NotificationDecision{DeferUntil: until, Reason: "quiet hours"}
+	}
+	if channel == NotificationChannelSMS && p.DailySMSLimit > 0 && smsSentToday >= p.DailySMSLimit {
+		tomorrow := startOfDay(now.In(tehranLocation)).AddDate(0, 0, 1)
+		if until := p.quietHoursEnd(tomorrow); until != nil {
+			tomorrow = *until
+		}
+		return NotificationDecision{DeferUntil: &tomorrow, Reason: "daily SMS limit reached"}
+	}
+	return NotificationDecision{Allowed: true}
+}
+
+// quietHoursEnd returns when the quiet hours around now end, or nil outside them
+func (p *NotificationPreference) quietHoursEnd(now time.Time) *time.Time {
+	if !p.QuietHoursEnabled {
+		return nil
+	}
+	start, okStart := parseClock(p.QuietHoursStart)
+	end, okEnd := parseClock(p.QuietHoursEnd)
+	if !okStart || !okEnd || start == end {
+		return nil
+	}
+
+	local := now.In(tehranLocation)
+	minute := local.Hour()*60 + local.Minute()
+	day := startOfDay(local)
+	var until time.Time
+	switch {
+	case start < end && minute >= start && minute < end:
+		until = day.Add(time.Duration(end) * time.Minute)
+	case start > end && minute >= start:
+		until = day.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute)
+	case start > end && minute < end:
+		until = day.Add(time.Duration(end) * time.Minute)
+	default:
+		return nil
+	}
+	return &until
+}
+
+func (p *NotificationPreference) channelField(channel string) *bool {
+	switch channel {
+	case NotificationChannelInApp:
+		return &p.InAppEnabled
+	case NotificationChannelPush:
+		return &p.PushEnabled
+	case NotificationChannelSMS:
+		return &p.SMSEnabled
+	}
+	return nil
+}
+
+func (p *NotificationPreference) topicSettings() map[string]map[string]bool {
+	topics := make(map[string]map[string]bool)
+	if p.TopicSettings != "" {
+		json.Unmarshal([]byte(p.TopicSettings), &topics)
+	}
+	return topics
+}
+
+// CheckNotificationPreference returns whether a delivery to a user on channel
+// about topic may be sent at now
+func CheckNotificationPreference(db *gorm.DB, userID uint, channel, topic string, now time.Time) (NotificationDecision, error) {
+	pref, err := GetNotificationPreference(db, userID)
+	if err != nil {
+		return NotificationDecision{}, err
+	}
+	smsSent := 0
+	if channel == NotificationChannelSMS {
+		if smsSent, err = CountSMSSentToday(db, userID, now); err != nil {
+			return NotificationDecision{}, err
+		}
+	}
+	return pref.Decide(channel, topic, now, smsSent), nil
+}
+
+// FilterNotificationRecipients returns the users who have not opted out of
+// topic on channel. Quiet hours and the daily SMS limit only hold deliveries
+// back, so they do not remove anyone.
+func FilterNotificationRecipients(db *gorm.DB, userIDs []uint, channel, topic string, now time.Time) ([]uint, error) {
+	optedOut := make(map[uint]bool)
+	// Chunk to keep the IN list bounded; users without a row have the defaults
+	for start := 0; start < len(userIDs); start += 500 {
+		end := start + 500
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		var prefs []NotificationPreference
+		if err := db.Where("user_id IN ?", userIDs[start:end]).Find(&prefs).Error; err != nil {
+			return nil, err
+		}
+		for i := range prefs {
+			if prefs[i].Decide(channel, topic, now, 0).Skipped() {
+				optedOut[prefs[i].UserID] = true
+			}
+		}
+	}
+
+	accepted := make([]uint, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !optedOut[id] {
+			accepted = append(accepted, id)
+		}
+	}
+	return accepted, nil
+}
+
+// CountSMSSentToday counts the outbox SMS delivered to a user since midnight
+// in Tehran
+func CountSMSSentToday(db *gorm.DB, userID uint, now time.Time) (int, error) {
+	var count int64
+	err := db.Model(&NotificationJob{}).
+		Where("user_id = ? AND channel = ? AND status = ? AND sent_at >= ?",
+			userID, NotificationChannelSMS, NotificationJobSent, startOfDay(now.In(tehranLocation)).In(time.Local)).
+		Count(&count).Error
+	return int(count), err
+}
+
+func isNotificationTopic(topic string) bool {
+	for _, t := range NotificationTopics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses HH:MM into minutes after midnight
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
@@ -0,0 +1,91 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestNotificationPreferenceDecisions(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+
+	tehran := time.FixedZone("Tehran", 3*3600+30*60)
+	noon := time.Date(2026, 10, 17, 12, 0, 0, 0, tehran)
+	night := time.Date(2026, 10, 17, 23, 30, 0, 0, tehran)
+
+	pref, err := models.GetNotificationPreference(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := pref.Decide(models.NotificationChannelSMS, models.NotificationTopicMarketing, night, 0); !d.Allowed {
+		t.Fatalf("defaults should allow everything: %+v", d)
+	}
+
+	pref, err = models.SaveNotificationPreference(db, user.ID, models.NotificationPreferenceSettings{
+		Topics: map[string]map[string]bool{
+			models.NotificationTopicMatchingRequests: {models.NotificationChannelSMS: false},
+		},
+		QuietHours:    &models.NotificationQuietHours{Enabled: true, Start: "22:00", End: "08:00"},
+		DailySMSLimit: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A partial update keeps the rest
+	pref, err = models.SaveNotificationPreference(db, user.ID, models.NotificationPreferenceSettings{
+		Topics: map[string]map[string]bool{models.NotificationTopicMarketing: {models.NotificationChannelInApp: false}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := pref.Settings()
+	if settings.Topics[models.NotificationTopicMarketing][models.NotificationChannelInApp] || !settings.QuietHours.Enabled || settings.DailySMSLimit != 2 ||
+		settings.Topics[models.NotificationTopicMatchingRequests][models.NotificationChannelSMS] {
+		t.Fatalf("settings = %+v", settings)
+	}
+
+	if d := pref.Decide(models.NotificationChannelSMS, models.NotificationTopicMatchingRequests, noon, 0); !d.Skipped() {
+		t.Fatalf("opted out topic: %+v", d)
+	}
+	if d := pref.Decide(models.NotificationChannelPush, models.NotificationTopicMatchingRequests, noon, 0); !d.Allowed {
+		t.Fatalf("push of the same topic: %+v", d)
+	}
+
+	// Quiet hours run past midnight and end at 08:00 Tehran time
+	d := pref.Decide(models.NotificationChannelPush, models.NotificationTopicWithdrawalUpdates, night, 0)
+	if d.Allowed || d.DeferUntil == nil || !d.DeferUntil.Equal(time.Date(2026, 10, 18, 8, 0, 0, 0, tehran)) {
+		t.Fatalf("quiet hours: %+v", d)
+	}
+	if d := pref.Decide(models.NotificationChannelInApp, models.NotificationTopicWithdrawalUpdates, night, 0); !d.Allowed {
+		t.Fatalf("in-app is not held back by quiet hours: %+v", d)
+	}
+
+	d = pref.Decide(models.NotificationChannelSMS, "", noon, 2)
+	if d.Allowed || d.DeferUntil == nil || !d.DeferUntil.Equal(time.Date(2026, 10, 18, 8, 0, 0, 0, tehran)) {
+		t.Fatalf("daily limit: %+v", d)
+	}
+
+	_, err = models.SaveNotificationPreference(db, user.ID, models.NotificationPreferenceSettings{
+		Topics: map[string]map[string]bool{"news": {models.NotificationChannelSMS: false}},
+	})
+	if !errors.Is(err, models.ErrInvalidNotificationPreference) {
+		t.Fatalf("unknown topic: err = %v", err)
+	}
+	_, err = models.SaveNotificationPreference(db, user.ID, models.NotificationPreferenceSettings{
+		Channels: map[string]bool{"telegram": false},
+	})
+	if !errors.Is(err, models.ErrInvalidNotificationPreference) {
+		t.Fatalf("unknown channel: err = %v", err)
+	}
+	_, err = models.SaveNotificationPreference(db, user.ID, models.NotificationPreferenceSettings{
+		QuietHours: &models.NotificationQuietHours{Enabled: true, Start: "25:00"},
+	})
+	if !errors.Is(err, models.ErrInvalidNotificationPreference) {
+		t.Fatalf("invalid quiet hours: err = %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NotificationRead records a single user's read/dismiss state for a notification.
+// Broadcast notifications (UserID == nil) are shared rows, so per-recipient state
+// must live here rather than on Notification.IsRead.
+type NotificationRead struct {
+	ID             uint         `json:"id" gorm:"primaryKey"`
+	UserID         uint         `json:"user_id" gorm:"not null;uniqueIndex:idx_notification_read_user_notification"`
+	User           User         `json:"-" gorm:"foreignKey:UserID"`
+	NotificationID uint         `json:"notification_id" gorm:"not null;uniqueIndex:idx_notification_read_user_notification;index"`
+	Notification   Notification `json:"-" gorm:"foreignKey:NotificationID"`
+	ReadAt         *time.Time   `json:"read_at"`
+	DismissedAt    *time.Time   `json:"dismissed_at"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// TableName specifies the table name for NotificationRead
+func (NotificationRead) TableName() string {
+	return "notification_reads"
+}
+
+// BroadcastReadStats is the read rate of a single broadcast notification
+type BroadcastReadStats struct {
+	NotificationID uint      `json:"notification_id"`
+	Title          string    `json:"title"`
+	CreatedAt      time.Time `json:"created_at"`
+	Recipients     int64     `json:"recipients"`
+	ReadCount      int64     `json:"read_count"`
+	DismissedCount int64     `json:"dismissed_count"`
+	ReadRate       float64   `json:"read_rate"` // percent of recipients
+}
+
+// accessibleNotificationsQuery scopes sent notifications addressed to a user:
+// their own, everyone broadcasts, and segments they were a recipient of
+func accessibleNotificationsQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&Notification{}).
+		Where("notifications.status = ?", NotificationStatusSent).
+		Where(`(notifications.user_id = ? OR (notifications.user_id IS NULL AND (notifications.audience <> ? OR EXISTS (
+			SELECT 1 FROM notification_recipients rc WHERE rc.notification_id = notifications.id AND rc.user_id = ?))))`,
+			userID, NotificationAudienceSegment, userID)
+}
+
+// visibleNotificationsQuery scopes notifications a user can currently see
+func visibleNotificationsQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return accessibleNotificationsQuery(db, userID).
+		Where("notifications.is_active = ?", true).
+		Where("(notifications.expires_at IS NULL OR notifications.expires_at > ?)", time.Now()).
+		Where("NOT EXISTS (SELECT 1 FROM notification_reads nr WHERE nr.notification_id = notifications.id AND nr.user_id = ? AND nr.dismissed_at IS NOT NULL)", userID)
+}
+
+// unreadNotificationsFilter keeps notifications the user has not read. The legacy
+// is_read column is still honoured for user-specific notifications.
+func unreadNotificationsFilter(query *gorm.DB, userID uint) *gorm.DB {
+	return query.
+		Where("(notifications.user_id IS NULL OR notifications.is_read = ?)", false).
+		Where("NOT EXISTS (SELECT 1 FROM notification_reads nr WHERE nr.notification_id = notifications.id AND nr.user_id = ? AND nr.read_at IS NOT NULL)", userID)
+}
+
+// markNotificationsState sets read_at or dismissed_at for a user on the given
+// notifications, keeping the earliest timestamp when already set
+func markNotificationsState(db *gorm.DB, userID uint, notificationIDs []uint, column string, at time.Time) error {
+	if len(notificationIDs) == 0 {
+		return nil
+	}
+
+	rows := make([]NotificationRead, 0, len(notificationIDs))
+	for _, id := range notificationIDs {
+		row := NotificationRead{UserID: userID, NotificationID: id}
+		if column == "read_at" {
+			row.ReadAt = &at
+		} else {
+			row.DismissedAt = &at
+		}
+		rows = append(rows, row)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&NotificationRead{}).
+			Where("user_id = ? AND notification_id IN ? AND "+column+" IS NULL", userID, notificationIDs).
+			Update(column, at).Error; err != nil {
+			return err
+		}
+		if column != "read_at" {
+			return nil
+		}
+		// Keep the legacy flag in sync for user-specific notifications
+		return tx.Model(&Notification{}).
+			Where("id IN ? AND user_id = ?", notificationIDs, userID).
+			Update("is_read", true).Error
+	})
+}
+
+// ApplyNotificationReadState overwrites IsRead on each notification with the
+// given user's own read state
+func ApplyNotificationReadState(db *gorm.DB, userID uint, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+	}
+
+	var reads []NotificationRead
+	if err := db.Where("user_id = ? AND notification_id IN ? AND read_at IS NOT NULL", userID, ids).
+		Find(&reads).Error; err != nil {
+		return err
+	}
+
+	read := make(map[uint]bool, len(reads))
+	for _, r := range reads {
+		read[r.NotificationID] = true
+	}
+	for i := range notifications {
+		if notifications[i].UserID == nil {
+			notifications[i].IsRead = read[notifications[i].ID]
+		} else {
+			notifications[i].IsRead = notifications[i].IsRead || read[notifications[i].ID]
+		}
+	}
+	return nil
+}
+
+// DismissNotification hides a notification from a user's list
+func DismissNotification(db *gorm.DB, id, userID uint) error {
+	var notification Notification
+	if err := accessibleNotificationsQuery(db, userID).Where("notifications.id = ?", id).
+		First(&notification).Error; err != nil {
+		return err
+	}
+	return markNotificationsState(db, userID, []uint{notification.ID}, "dismissed_at", time.Now())
+}
+
+// GetBroadcastReadStats returns read rates for the most recent broadcasts.
+// Recipients is the number of active users for everyone broadcasts and the
+// recorded recipient count for segmented ones.
+func GetBroadcastReadStats(db *gorm.DB, limit int) ([]BroadcastReadStats, error) {
+	var activeUsers int64
+	if err := db.Model(&User{}).Where("is_active = ?", true).Count(&activeUsers).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		BroadcastReadStats
+		Audience       string
+		RecipientCount int64
+	}
+	err := db.Model(&Notification{}).
+		Select(`notifications.id AS notification_id, notifications.title, notifications.created_at,
+			notifications.audience, notifications.recipient_count,
+			COUNT(nr.read_at) AS read_count, COUNT(nr.dismissed_at) AS dismissed_count`).
+		Joins("LEFT JOIN notification_reads nr ON nr.notification_id = notifications.id").
+		Where("notifications.user_id IS NULL AND notifications.status = ?", NotificationStatusSent).
+		Group("notifications.id, notifications.title, notifications.created_at, notifications.audience, notifications.recipient_count").
+		Order("notifications.created_at DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]BroadcastReadStats, len(rows))
+	for i, row := range rows {
+		stats[i] = row.BroadcastReadStats
+		stats[i].Recipients = activeUsers
+		if row.Audience == NotificationAudienceSegment {
+			stats[i].Recipients = row.RecipientCount
+		}
+		if stats[i].Recipients > 0 {
+			stats[i].ReadRate = float64(stats[i].ReadCount) * 100 / float64(stats[i].Recipients)
+		}
+	}
+	return stats, nil
+}
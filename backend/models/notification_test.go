@@ -0,0 +1,142 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+	"asl-market-backend/utils"
+)
+
+func TestBroadcastReadStateIsPerUser(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	alice := testutil.CreateUser(t, db, "09120000002")
+	bob := testutil.CreateUser(t, db, "09120000003")
+
+	broadcast := testutil.SendNotification(t, db, admin.ID, models.CreateNotificationRequest{Title: "همگانی", Message: "broadcast"})
+	testutil.SendNotification(t, db, admin.ID, models.CreateNotificationRequest{Title: "شخصی", Message: "direct", UserID: &alice.ID})
+
+	unread := func(userID uint) int64 {
+		t.Helper()
+		count, err := models.GetUnreadNotificationCount(db, userID)
+		if err != nil {
+			t.Fatalf("unread count: %v", err)
+		}
+		return count
+	}
+
+	if got := unread(alice.ID); got != 2 {
+		t.Fatalf("alice unread = %d, want 2", got)
+	}
+	if got := unread(bob.ID); got != 1 {
+		t.Fatalf("bob unread = %d, want 1", got)
+	}
+
+	if err := models.MarkNotificationAsRead(db, broadcast.ID, alice.ID); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if got := unread(alice.ID); got != 1 {
+		t.Fatalf("alice unread after reading broadcast = %d, want 1", got)
+	}
+	if got := unread(bob.ID); got != 1 {
+		t.Fatalf("bob unread after alice read broadcast = %d, want 1", got)
+	}
+
+	notifications, _, err := models.GetUserNotifications(db, bob.ID, 1, 20, false)
+	if err != nil {
+		t.Fatalf("list bob notifications: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].IsRead {
+		t.Fatalf("bob notifications = %+v, want one unread broadcast", notifications)
+	}
+
+	if err := models.MarkAllNotificationsAsRead(db, alice.ID); err != nil {
+		t.Fatalf("mark all read: %v", err)
+	}
+	if got := unread(alice.ID); got != 0 {
+		t.Fatalf("alice unread after read-all = %d, want 0", got)
+	}
+	if got := unread(bob.ID); got != 1 {
+		t.Fatalf("bob unread after alice read-all = %d, want 1", got)
+	}
+}
+
+func TestDismissNotificationHidesItForOneUser(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	alice := testutil.CreateUser(t, db, "09120000002")
+	bob := testutil.CreateUser(t, db, "09120000003")
+
+	broadcast := testutil.SendNotification(t, db, admin.ID, models.CreateNotificationRequest{Title: "همگانی", Message: "broadcast"})
+
+	if err := models.DismissNotification(db, broadcast.ID, alice.ID); err != nil {
+		t.Fatalf("dismiss: %v", err)
+	}
+
+	if _, total, _ := models.GetUserNotifications(db, alice.ID, 1, 20, false); total != 0 {
+		t.Fatalf("alice sees %d notifications after dismiss, want 0", total)
+	}
+	if _, total, _ := models.GetUserNotifications(db, bob.ID, 1, 20, false); total != 1 {
+		t.Fatalf("bob sees %d notifications, want 1", total)
+	}
+}
+
+func TestNotificationStatsBroadcastReadRate(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000001")
+	reader := testutil.CreateUser(t, db, "09120000002")
+	testutil.CreateUser(t, db, "09120000003")
+	testutil.CreateUser(t, db, "09120000004")
+
+	broadcast := testutil.SendNotification(t, db, admin.ID, models.CreateNotificationRequest{Title: "همگانی", Message: "broadcast"})
+	if err := models.MarkNotificationAsRead(db, broadcast.ID, reader.ID); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	// Reading twice must not double count
+	if err := models.MarkNotificationAsRead(db, broadcast.ID, reader.ID); err != nil {
+		t.Fatalf("mark read again: %v", err)
+	}
+
+	stats, err := models.GetNotificationStats(db)
+	if err != nil {
+		t.Fatalf("notification stats: %v", err)
+	}
+	broadcasts, ok := stats["broadcasts"].([]models.BroadcastReadStats)
+	if !ok || len(broadcasts) != 1 {
+		t.Fatalf("broadcasts = %#v, want one entry", stats["broadcasts"])
+	}
+	got := broadcasts[0]
+	if got.Recipients != 4 || got.ReadCount != 1 || got.ReadRate != 25 {
+		t.Fatalf("broadcast stats = %+v, want 4 recipients, 1 read, 25%%", got)
+	}
+}
+
+func TestSegmentInactiveUsers(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	active := testutil.CreateUser(t, db, "09120000001")
+	inactive := testutil.CreateUser(t, db, "09120000002")
+	newcomer := testutil.CreateUser(t, db, "09120000003")
+
+	old := time.Now().AddDate(0, 0, -60)
+	db.Model(&models.User{}).Where("id IN ?", []uint{active.ID, inactive.ID}).Update("created_at", old)
+	if _, err := models.CreateAuthSession(db, utils.SubjectUser, active.ID, "hash-active", "", "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := models.CreateAuthSession(db, utils.SubjectUser, inactive.ID, "hash-inactive", "", "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(stale).Updates(map[string]interface{}{"created_at": old, "last_used_at": old})
+
+	segment := models.NotificationSegment{InactiveDays: 30}
+	if err := segment.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	models.SegmentUsersQuery(db, segment).Pluck("users.id", &ids)
+	if len(ids) != 1 || ids[0] != inactive.ID {
+		t.Fatalf("inactive users = %v (newcomer %d)", ids, newcomer.ID)
+	}
+}
@@ -0,0 +1,338 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusPlaced    OrderStatus = "placed"
+	OrderStatusConfirmed OrderStatus = "confirmed"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCancelled OrderStatus = "cancelled"
+)
+
+// Order actor roles
+const (
+	OrderActorBuyer  = "buyer"
+	OrderActorSeller = "seller"
+)
+
+// orderTransitions lists, for each status, the statuses it may move to and
+// which party is allowed to make that move.
+var orderTransitions = map[OrderStatus]map[OrderStatus][]string{
+	OrderStatusPlaced: {
+		OrderStatusConfirmed: {OrderActorSeller},
+		OrderStatusCancelled: {OrderActorBuyer, OrderActorSeller},
+	},
+	OrderStatusConfirmed: {
+		OrderStatusShipped:   {OrderActorSeller},
+		OrderStatusCancelled: {OrderActorSeller},
+	},
+	OrderStatusShipped: {
+		OrderStatusDelivered: {OrderActorBuyer, OrderActorSeller},
+	},
+}
+
+var (
+	ErrOrderProductUnavailable = errors.New("محصول در حال حاضر برای سفارش در دسترس نیست")
+	ErrOrderOwnProduct         = errors.New("امکان ثبت سفارش برای محصول خودتان وجود ندارد")
+	ErrOrderInsufficientStock  = errors.New("موجودی محصول برای این تعداد کافی نیست")
+	ErrOrderNotParticipant     = errors.New("شما به این سفارش دسترسی ندارید")
+	ErrOrderInvalidTransition  = errors.New("تغییر وضعیت سفارش به این حالت مجاز نیست")
+)
+
+// OrderValidationError is a user-facing rejection of an order request
+type OrderValidationError struct {
+	Message string
+}
+
+func (e *OrderValidationError) Error() string {
+	return e.Message
+}
+
+// Order is a purchase of an AvailableProduct by a buyer
+type Order struct {
+	ID                 uint             `json:"id" gorm:"primaryKey"`
+	BuyerID            uint             `json:"buyer_id" gorm:"not null;index"`
+	Buyer              User             `json:"buyer" gorm:"foreignKey:BuyerID"`
+	SellerID           uint             `json:"seller_id" gorm:"not null;index"` // AvailableProduct.AddedByID at order time
+	Seller             User             `json:"seller" gorm:"foreignKey:SellerID"`
+	SupplierID         *uint            `json:"supplier_id" gorm:"index"`
+	AvailableProductID uint             `json:"available_product_id" gorm:"not null;index"`
+	AvailableProduct   AvailableProduct `json:"available_product" gorm:"foreignKey:AvailableProductID"`
+
+	// Snapshot of the product at order time
+	ProductName string `json:"product_name" gorm:"size:255;not null;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Quantity    int    `json:"quantity" gorm:"not null"`
+	Unit        string `json:"unit" gorm:"size:50"`
+	UnitPrice   string `json:"unit_price" gorm:"size:50"`
+	Currency    string `json:"currency" gorm:"size:10"`
+
+	// Buyer details
+	ShippingAddress string `json:"shipping_address" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	ContactPhone    string `json:"contact_phone" gorm:"size:20"`
+	BuyerNotes      string `json:"buyer_notes" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+
+	// Fulfilment
+	Status       OrderStatus `json:"status" gorm:"type:varchar(20);default:'placed';index"`
+	SellerNotes  string      `json:"seller_notes" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	TrackingCode string      `json:"tracking_code" gorm:"size:100"`
+	CancelReason string      `json:"cancel_reason" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	CancelledBy  string      `json:"cancelled_by" gorm:"size:20"` // buyer or seller
+	ConfirmedAt  *time.Time  `json:"confirmed_at"`
+	ShippedAt    *time.Time  `json:"shipped_at"`
+	DeliveredAt  *time.Time  `json:"delivered_at"`
+	CancelledAt  *time.Time  `json:"cancelled_at"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// CreateOrderRequest is the buyer's order payload
+type CreateOrderRequest struct {
+	AvailableProductID uint   `json:"available_product_id" binding:"required"`
+	Quantity           int    `json:"quantity" binding:"required,min=1"`
+	ShippingAddress    string `json:"shipping_address" binding:"required"`
+	ContactPhone       string `json:"contact_phone"`
+	BuyerNotes         string `json:"buyer_notes"`
+}
+
+// UpdateOrderStatusRequest moves an order to a new status
+type UpdateOrderStatusRequest struct {
+	Status       OrderStatus `json:"status" binding:"required,oneof=confirmed shipped delivered cancelled"`
+	Notes        string      `json:"notes"`
+	TrackingCode string      `json:"tracking_code"`
+}
+
+// TableName specifies the table name for Order
+func (Order) TableName() string {
+	return "orders"
+}
+
+// CanTransitionOrder reports whether actor may move an order from one status to another
+func CanTransitionOrder(from, to OrderStatus, actor string) bool {
+	for _, allowed := range orderTransitions[from][to] {
+		if allowed == actor {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateOrderQuantity checks the quantity against the product's order limits and stock
+func ValidateOrderQuantity(product *AvailableProduct, quantity int) error {
+	minQty := product.MinOrderQuantity
+	if minQty < 1 {
+		minQty = 1
+	}
+	if quantity < minQty {
+		return &OrderValidationError{Message: fmt.Sprintf("حداقل مقدار سفارش %d %s است", minQty, product.Unit)}
+	}
+	if product.MaxOrderQuantity > 0 && quantity > product.MaxOrderQuantity {
+		return &OrderValidationError{Message: fmt.Sprintf("حداکثر مقدار سفارش %d %s است", product.MaxOrderQuantity, product.Unit)}
+	}
+	if quantity > product.AvailableQuantity {
+		return ErrOrderInsufficientStock
+	}
+	return nil
+}
+
+// orderUnitPrice picks the price matching the product's sale type
+func orderUnitPrice(product *AvailableProduct) string {
+	if product.SaleType == "retail" && product.RetailPrice != "" {
+		return product.RetailPrice
+	}
+	if product.WholesalePrice != "" {
+		return product.WholesalePrice
+	}
+	return product.RetailPrice
+}
+
+// PlaceOrder creates an order and reserves stock in a single transaction
+func PlaceOrder(db *gorm.DB, buyerID uint, req CreateOrderRequest) (*Order, error) {
+	var order Order
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var product AvailableProduct
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&product, req.AvailableProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderProductUnavailable
+			}
+			return err
+		}
+
+		if product.Status != "active" {
+			return ErrOrderProductUnavailable
+		}
+		if product.AddedByID == buyerID {
+			return ErrOrderOwnProduct
+		}
+		if err := ValidateOrderQuantity(&product, req.Quantity); err != nil {
+			return err
+		}
+
+		remaining := product.AvailableQuantity - req.Quantity
+		updates := map[string]interface{}{"available_quantity": remaining}
+		if remaining == 0 {
+			updates["status"] = "out_of_stock"
+		}
+		if err := tx.Model(&product).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		order = Order{
+			BuyerID:            buyerID,
+			SellerID:           product.AddedByID,
+			SupplierID:         product.SupplierID,
+			AvailableProductID: product.ID,
+			ProductName:        product.ProductName,
+			Quantity:           req.Quantity,
+			Unit:               product.Unit,
+			UnitPrice:          orderUnitPrice(&product),
+			Currency:           product.Currency,
+			ShippingAddress:    req.ShippingAddress,
+			ContactPhone:       req.ContactPhone,
+			BuyerNotes:         req.BuyerNotes,
+			Status:             OrderStatusPlaced,
+		}
+		return tx.Create(&order).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// TransitionOrder moves an order to a new status on behalf of a buyer or seller.
+// Cancelling restores the reserved stock.
+func TransitionOrder(db *gorm.DB, orderID, actorID uint, req UpdateOrderStatusRequest) (*Order, error) {
+	var order Order
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			return err
+		}
+
+		actor := ""
+		switch actorID {
+		case order.SellerID:
+			actor = OrderActorSeller
+		case order.BuyerID:
+			actor = OrderActorBuyer
+		default:
+			return ErrOrderNotParticipant
+		}
+
+		if !CanTransitionOrder(order.Status, req.Status, actor) {
+			return ErrOrderInvalidTransition
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"status": req.Status}
+		switch req.Status {
+		case OrderStatusConfirmed:
+			updates["confirmed_at"] = now
+			if req.Notes != "" {
+				updates["seller_notes"] = req.Notes
+			}
+		case OrderStatusShipped:
+			updates["shipped_at"] = now
+			if req.TrackingCode != "" {
+				updates["tracking_code"] = req.TrackingCode
+			}
+		case OrderStatusDelivered:
+			updates["delivered_at"] = now
+		case OrderStatusCancelled:
+			updates["cancelled_at"] = now
+			updates["cancelled_by"] = actor
+			updates["cancel_reason"] = req.Notes
+
+			var product AvailableProduct
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				First(&product, order.AvailableProductID).Error; err == nil {
+				productUpdates := map[string]interface{}{
+					"available_quantity": product.AvailableQuantity + order.Quantity,
+				}
+				if product.Status == "out_of_stock" {
+					productUpdates["status"] = "active"
+				}
+				if err := tx.Model(&product).Updates(productUpdates).Error; err != nil {
+					return err
+				}
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		return tx.Model(&order).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return GetOrderByID(db, order.ID)
+}
+
+// GetOrderByID retrieves an order with its product
+func GetOrderByID(db *gorm.DB, id uint) (*Order, error) {
+	var order Order
+	if err := db.Preload("AvailableProduct").First(&order, id).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetOrderForParticipant retrieves an order only if userID is its buyer or seller
+func GetOrderForParticipant(db *gorm.DB, orderID, userID uint) (*Order, error) {
+	var order Order
+	err := db.Preload("AvailableProduct").
+		Where("id = ? AND (buyer_id = ? OR seller_id = ?)", orderID, userID, userID).
+		First(&order).Error
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetBuyerOrders lists orders placed by a buyer
+func GetBuyerOrders(db *gorm.DB, buyerID uint, status string, page, perPage int) ([]Order, int64, error) {
+	return listOrders(db.Where("buyer_id = ?", buyerID), status, page, perPage)
+}
+
+// GetSellerOrders lists orders received for a seller's products
+func GetSellerOrders(db *gorm.DB, sellerID uint, status string, page, perPage int) ([]Order, int64, error) {
+	return listOrders(db.Where("seller_id = ?", sellerID), status, page, perPage)
+}
+
+func listOrders(query *gorm.DB, status string, page, perPage int) ([]Order, int64, error) {
+	var orders []Order
+	var total int64
+
+	query = query.Model(&Order{})
+	if status != "" && status != "all" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	err := query.Preload("AvailableProduct").Order("created_at DESC").
+		Offset(offset).Limit(perPage).Find(&orders).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return orders, total, nil
+}
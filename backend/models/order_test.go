@@ -0,0 +1,107 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestPlaceOrderReservesStock(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	seller := testutil.CreateUser(t, db, "09120000001")
+	buyer := testutil.CreateUser(t, db, "09120000002")
+	product := testutil.CreateAvailableProduct(t, db, seller.ID, 5)
+
+	order, err := models.PlaceOrder(db, buyer.ID, models.CreateOrderRequest{
+		AvailableProductID: product.ID,
+		Quantity:           5,
+		ShippingAddress:    "Dubai",
+	})
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+	if order.SellerID != seller.ID || order.Status != models.OrderStatusPlaced {
+		t.Fatalf("order = %+v", order)
+	}
+
+	var reloaded models.AvailableProduct
+	db.First(&reloaded, product.ID)
+	if reloaded.AvailableQuantity != 0 || reloaded.Status != "out_of_stock" {
+		t.Fatalf("product quantity=%d status=%q, want 0 out_of_stock", reloaded.AvailableQuantity, reloaded.Status)
+	}
+
+	if _, err := models.PlaceOrder(db, buyer.ID, models.CreateOrderRequest{
+		AvailableProductID: product.ID,
+		Quantity:           1,
+		ShippingAddress:    "Dubai",
+	}); !errors.Is(err, models.ErrOrderProductUnavailable) {
+		t.Fatalf("order on sold out product error = %v, want ErrOrderProductUnavailable", err)
+	}
+}
+
+func TestPlaceOrderRejectsOwnProductAndOverdraw(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	seller := testutil.CreateUser(t, db, "09120000001")
+	buyer := testutil.CreateUser(t, db, "09120000002")
+	product := testutil.CreateAvailableProduct(t, db, seller.ID, 2)
+
+	if _, err := models.PlaceOrder(db, seller.ID, models.CreateOrderRequest{
+		AvailableProductID: product.ID,
+		Quantity:           1,
+		ShippingAddress:    "Dubai",
+	}); !errors.Is(err, models.ErrOrderOwnProduct) {
+		t.Fatalf("own product error = %v, want ErrOrderOwnProduct", err)
+	}
+
+	if _, err := models.PlaceOrder(db, buyer.ID, models.CreateOrderRequest{
+		AvailableProductID: product.ID,
+		Quantity:           3,
+		ShippingAddress:    "Dubai",
+	}); !errors.Is(err, models.ErrOrderInsufficientStock) {
+		t.Fatalf("overdraw error = %v, want ErrOrderInsufficientStock", err)
+	}
+}
+
+func TestTransitionOrderLifecycle(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	seller := testutil.CreateUser(t, db, "09120000001")
+	buyer := testutil.CreateUser(t, db, "09120000002")
+	stranger := testutil.CreateUser(t, db, "09120000003")
+	product := testutil.CreateAvailableProduct(t, db, seller.ID, 10)
+
+	order, err := models.PlaceOrder(db, buyer.ID, models.CreateOrderRequest{
+		AvailableProductID: product.ID,
+		Quantity:           4,
+		ShippingAddress:    "Dubai",
+	})
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+
+	if _, err := models.TransitionOrder(db, order.ID, stranger.ID, models.UpdateOrderStatusRequest{Status: models.OrderStatusConfirmed}); !errors.Is(err, models.ErrOrderNotParticipant) {
+		t.Fatalf("stranger error = %v, want ErrOrderNotParticipant", err)
+	}
+	if _, err := models.TransitionOrder(db, order.ID, buyer.ID, models.UpdateOrderStatusRequest{Status: models.OrderStatusConfirmed}); !errors.Is(err, models.ErrOrderInvalidTransition) {
+		t.Fatalf("buyer confirm error = %v, want ErrOrderInvalidTransition", err)
+	}
+
+	confirmed, err := models.TransitionOrder(db, order.ID, seller.ID, models.UpdateOrderStatusRequest{Status: models.OrderStatusConfirmed})
+	if err != nil {
+		t.Fatalf("seller confirm: %v", err)
+	}
+	if confirmed.Status != models.OrderStatusConfirmed || confirmed.ConfirmedAt == nil {
+		t.Fatalf("confirmed order = %+v", confirmed)
+	}
+
+	if _, err := models.TransitionOrder(db, order.ID, seller.ID, models.UpdateOrderStatusRequest{Status: models.OrderStatusCancelled, Notes: "out of stock"}); err != nil {
+		t.Fatalf("seller cancel: %v", err)
+	}
+
+	var reloaded models.AvailableProduct
+	db.First(&reloaded, product.ID)
+	if reloaded.AvailableQuantity != 10 {
+		t.Fatalf("available quantity after cancel = %d, want 10", reloaded.AvailableQuantity)
+	}
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OTP purposes
+const (
+	OTPPurposePasswordRecovery  = "password_recovery"
+	OTPPurposePhoneVerification = "phone_verification"
+)
+
+// OTP errors returned by IssueOTPCode / VerifyOTPCode
+var (
+	ErrOTPCooldown         = errors.New("otp resend cooldown active")
+	ErrOTPNotFound         = errors.New("otp code not found")
+	ErrOTPExpired          = errors.New("otp code expired")
+	ErrOTPTooManyAttempts  = errors.New("otp code attempts exceeded")
+	ErrOTPInvalid          = errors.New("otp code invalid")
+	ErrOTPPhoneNotVerified = errors.New("phone number not verified")
+)
+
+// OTPCode stores a hashed one-time code sent to a phone number
+type OTPCode struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Phone       string     `json:"phone" gorm:"size:20;not null;index:idx_otp_phone_purpose"`
+	Purpose     string     `json:"purpose" gorm:"size:50;not null;index:idx_otp_phone_purpose"`
+	CodeHash    string     `json:"-" gorm:"size:64;not null"`
+	Attempts    int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"default:5"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt  *time.Time `json:"consumed_at"`
+	VerifiedAt  *time.Time `json:"verified_at"` // set when a phone verification code is confirmed, before it is consumed
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// OTPOptions controls code lifetime and abuse limits
+type OTPOptions struct {
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendCooldown time.Duration
+}
+
+// TableName specifies the table name for OTPCode
+func (OTPCode) TableName() string {
+	return "otp_codes"
+}
+
+// HashOTPCode returns the hex sha256 of a code scoped to phone and purpose
+func HashOTPCode(phone, purpose, code string) string {
+	sum := sha256.Sum256([]byte(purpose + ":" + phone + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateOTPCode returns a random 6-digit numeric code
+func GenerateOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// IssueOTPCode creates a new code for phone/purpose, invalidating any earlier
+// unconsumed codes. Returns ErrOTPCooldown when the last code is too recent.
+func IssueOTPCode(db *gorm.DB, phone, purpose string, opts OTPOptions) (string, *OTPCode, error) {
+	code, err := GenerateOTPCode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	otp := OTPCode{
+		Phone:       phone,
+		Purpose:     purpose,
+		CodeHash:    HashOTPCode(phone, purpose, code),
+		MaxAttempts: opts.MaxAttempts,
+		ExpiresAt:   now.Add(opts.TTL),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var last OTPCode
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("phone = ? AND purpose = ?", phone, purpose).
+			Order("created_at DESC").First(&last).Error
+		if err == nil {
+			if now.Sub(last.CreatedAt) < opts.ResendCooldown {
+				return ErrOTPCooldown
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Only the most recent code is valid
+		if err := tx.Model(&OTPCode{}).
+			Where("phone = ? AND purpose = ? AND consumed_at IS NULL", phone, purpose).
+			Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&otp).Error
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return code, &otp, nil
+}
+
+// OTPCooldownRemaining returns how long until a new code may be issued
+func OTPCooldownRemaining(db *gorm.DB, phone, purpose string, cooldown time.Duration) time.Duration {
+	var last OTPCode
+	if err := db.Where("phone = ? AND purpose = ?", phone, purpose).
+		Order("created_at DESC").First(&last).Error; err != nil {
+		return 0
+	}
+	remaining := cooldown - time.Since(last.CreatedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// checkOTPCode loads the active code for phone/purpose under a row lock and
+// compares it with the submitted code, counting failed attempts.
+func checkOTPCode(tx *gorm.DB, phone, purpose, code string) (*OTPCode, error) {
+	var otp OTPCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("phone = ? AND purpose = ? AND consumed_at IS NULL", phone, purpose).
+		Order("created_at DESC").First(&otp).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOTPNotFound
+		}
+		return nil, err
+	}
+
+	if time.Now().After(otp.ExpiresAt) {
+		return nil, ErrOTPExpired
+	}
+	if otp.Attempts >= otp.MaxAttempts {
+		return nil, ErrOTPTooManyAttempts
+	}
+
+	hash := HashOTPCode(phone, purpose, code)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(otp.CodeHash)) != 1 {
+		if err := tx.Model(&otp).Update("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+			return nil, err
+		}
+		return nil, ErrOTPInvalid
+	}
+
+	return &otp, nil
+}
+
+// VerifyOTPCode checks the code and consumes it on success (single use)
+func VerifyOTPCode(db *gorm.DB, phone, purpose, code string) error {
+	var verifyErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		otp, err := checkOTPCode(tx, phone, purpose, code)
+		if err != nil {
+			// Keep the attempt counter update even when the code is wrong
+			verifyErr = err
+			return nil
+		}
+		now := time.Now()
+		return tx.Model(otp).Updates(map[string]interface{}{
+			"verified_at": now,
+			"consumed_at": now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// MarkPhoneVerified checks a phone verification code without consuming it, so
+// a later registration call can consume it with ConsumePhoneVerification.
+func MarkPhoneVerified(db *gorm.DB, phone, code string) error {
+	var verifyErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		otp, err := checkOTPCode(tx, phone, OTPPurposePhoneVerification, code)
+		if err != nil {
+			verifyErr = err
+			return nil
+		}
+		return tx.Model(otp).Update("verified_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// ConsumePhoneVerification consumes a previously verified, unexpired phone
+// verification. Returns ErrOTPPhoneNotVerified when none exists.
+func ConsumePhoneVerification(db *gorm.DB, phone string) error {
+	res := db.Model(&OTPCode{}).
+		Where("phone = ? AND purpose = ? AND verified_at IS NOT NULL AND consumed_at IS NULL AND expires_at > ?",
+			phone, OTPPurposePhoneVerification, time.Now()).
+		Update("consumed_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrOTPPhoneNotVerified
+	}
+	return nil
+}
+
+// CleanupExpiredOTPCodes removes codes that expired before the given time
+func CleanupExpiredOTPCodes(db *gorm.DB, before time.Time) error {
+	return db.Where("expires_at < ?", before).Delete(&OTPCode{}).Error
+}
@@ -0,0 +1,83 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func testOTPOptions() models.OTPOptions {
+	return models.OTPOptions{TTL: 5 * time.Minute, MaxAttempts: 2, ResendCooldown: time.Minute}
+}
+
+func TestOTPCodeIsSingleUse(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	phone := "09120000001"
+
+	code, _, err := models.IssueOTPCode(db, phone, models.OTPPurposePasswordRecovery, testOTPOptions())
+	if err != nil {
+		t.Fatalf("issue code: %v", err)
+	}
+
+	if err := models.VerifyOTPCode(db, phone, models.OTPPurposePhoneVerification, code); err == nil {
+		t.Fatal("code accepted for a different purpose")
+	}
+	if err := models.VerifyOTPCode(db, phone, models.OTPPurposePasswordRecovery, code); err != nil {
+		t.Fatalf("verify code: %v", err)
+	}
+	if err := models.VerifyOTPCode(db, phone, models.OTPPurposePasswordRecovery, code); !errors.Is(err, models.ErrOTPNotFound) {
+		t.Fatalf("reuse error = %v, want ErrOTPNotFound", err)
+	}
+}
+
+func TestOTPCodeCooldownAndAttempts(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	phone := "09120000001"
+
+	code, _, err := models.IssueOTPCode(db, phone, models.OTPPurposePasswordRecovery, testOTPOptions())
+	if err != nil {
+		t.Fatalf("issue code: %v", err)
+	}
+	if _, _, err := models.IssueOTPCode(db, phone, models.OTPPurposePasswordRecovery, testOTPOptions()); !errors.Is(err, models.ErrOTPCooldown) {
+		t.Fatalf("resend error = %v, want ErrOTPCooldown", err)
+	}
+
+	wrong := "000000"
+	if wrong == code {
+		wrong = "111111"
+	}
+	for i := 0; i < 2; i++ {
+		if err := models.VerifyOTPCode(db, phone, models.OTPPurposePasswordRecovery, wrong); !errors.Is(err, models.ErrOTPInvalid) {
+			t.Fatalf("attempt %d error = %v, want ErrOTPInvalid", i+1, err)
+		}
+	}
+	if err := models.VerifyOTPCode(db, phone, models.OTPPurposePasswordRecovery, code); !errors.Is(err, models.ErrOTPTooManyAttempts) {
+		t.Fatalf("locked code error = %v, want ErrOTPTooManyAttempts", err)
+	}
+}
+
+func TestPhoneVerificationIsConsumedOnce(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	phone := "09120000001"
+
+	if err := models.ConsumePhoneVerification(db, phone); !errors.Is(err, models.ErrOTPPhoneNotVerified) {
+		t.Fatalf("unverified error = %v, want ErrOTPPhoneNotVerified", err)
+	}
+
+	code, _, err := models.IssueOTPCode(db, phone, models.OTPPurposePhoneVerification, testOTPOptions())
+	if err != nil {
+		t.Fatalf("issue code: %v", err)
+	}
+	if err := models.MarkPhoneVerified(db, phone, code); err != nil {
+		t.Fatalf("mark verified: %v", err)
+	}
+	if err := models.ConsumePhoneVerification(db, phone); err != nil {
+		t.Fatalf("consume verification: %v", err)
+	}
+	if err := models.ConsumePhoneVerification(db, phone); !errors.Is(err, models.ErrOTPPhoneNotVerified) {
+		t.Fatalf("second consume error = %v, want ErrOTPPhoneNotVerified", err)
+	}
+}
@@ -0,0 +1,418 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Push campaign statuses. A campaign is sent once its recipients are picked
+// and their pushes queued; the outbox then delivers them at the campaign rate.
+const (
+	PushCampaignScheduled = "scheduled"
+	PushCampaignSending   = "sending"
+	PushCampaignSent      = "sent"
+	PushCampaignCanceled  = "canceled"
+)
+
+// Push campaign recipient statuses, kept in step with the outbox job
+const (
+	PushCampaignRecipientPending = "pending"
+	PushCampaignRecipientSent    = "sent"
+	PushCampaignRecipientFailed  = "failed"
+	PushCampaignRecipientSkipped = "skipped"
+)
+
+// Push campaign limits
+const (
+	MaxPushCampaignVariants          = 2
+	DefaultPushCampaignRatePerMinute = 600
+	MaxPushCampaignRatePerMinute     = 6000
+)
+
+// pushCampaignVariantLabels names the variants in order
+var pushCampaignVariantLabels = []string{"A", "B"}
+
+// ErrPushCampaignNotScheduled is returned when canceling a campaign that has
+// already started sending
+var ErrPushCampaignNotScheduled = errors.New("only scheduled push campaigns can be canceled")
+
+// PushCampaignError is returned for an invalid campaign
+type PushCampaignError struct {
+	Message string
+}
+
+func (e *PushCampaignError) Error() string {
+	return e.Message
+}
+
+// PushCampaign is a marketing push sent to all users or a segment, with up to
+// two A/B variants split evenly between the recipients
+type PushCampaign struct {
+	ID             uint                  `json:"id" gorm:"primaryKey"`
+	Name           string                `json:"name" gorm:"size:255;not null;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Audience       string                `json:"audience" gorm:"size:20;default:'all'"` // all, segment
+	SegmentJSON    string                `json:"-" gorm:"column:segment;type:mediumtext"`
+	Segment        *NotificationSegment  `json:"segment,omitempty" gorm:"-"`
+	RatePerMinute  int                   `json:"rate_per_minute" gorm:"default:600"`
+	Status         string                `json:"status" gorm:"size:20;default:'scheduled';index"`
+	ScheduledAt    *time.Time            `json:"scheduled_at" gorm:"index"`
+	SentAt         *time.Time            `json:"sent_at"`
+	RecipientCount int                   `json:"recipient_count" gorm:"default:0"`
+	CreatedByID    uint                  `json:"created_by_id" gorm:"not null;index"`
+	Variants       []PushCampaignVariant `json:"variants" gorm:"foreignKey:CampaignID"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+// TableName specifies the table name for PushCampaign
+func (PushCampaign) TableName() string {
+	return "push_campaigns"
+}
+
+// AfterFind decodes the stored segment
+func (c *PushCampaign) AfterFind(tx *gorm.DB) error {
+	if c.SegmentJSON == "" {
+		return nil
+	}
+	var segment NotificationSegment
+	if err := json.Unmarshal([]byte(c.SegmentJSON), &segment); err != nil {
+		return err
+	}
+	c.Segment = &segment
+	return nil
+}
+
+// PushCampaignVariant is one version of the campaign's push
+type PushCampaignVariant struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CampaignID uint      `json:"campaign_id" gorm:"not null;index"`
+	Label      string    `json:"label" gorm:"size:1;not null"` // A, B
+	Title      string    `json:"title" gorm:"size:255;not null;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Message    string    `json:"message" gorm:"type:text;charset:utf8mb4;collation:utf8mb4_unicode_ci"`
+	Icon       string    `json:"icon" gorm:"size:500"`
+	DeepLink   string    `json:"deep_link" gorm:"size:500"` // app path (/products) or https URL opened on click
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for PushCampaignVariant
+func (PushCampaignVariant) TableName() string {
+	return "push_campaign_variants"
+}
+
+// PushCampaignRecipient is a user a campaign was sent to. The token goes into
+// the tracked link of the push, so clicks are counted per recipient.
+type PushCampaignRecipient struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	CampaignID uint       `json:"campaign_id" gorm:"not null;uniqueIndex:idx_push_campaign_recipient"`
+	UserID     uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_push_campaign_recipient;index"`
+	VariantID  uint       `json:"variant_id" gorm:"not null;index"`
+	Token      string     `json:"-" gorm:"size:32;not null;uniqueIndex"`
+	Status     string     `json:"status" gorm:"size:20;default:'pending';index"`
+	Error      string     `json:"error" gorm:"type:text"`
+	SentAt     *time.Time `json:"sent_at"`
+	ClickedAt  *time.Time `json:"clicked_at"` // first click
+	ClickCount int        `json:"click_count" gorm:"default:0"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for PushCampaignRecipient
+func (PushCampaignRecipient) TableName() string {
+	return "push_campaign_recipients"
+}
+
+// PushCampaignVariantResult reports how one variant performed
+type PushCampaignVariantResult struct {
+	VariantID  uint    `json:"variant_id"`
+	Label      string  `json:"label"`
+	Recipients int64   `json:"recipients"`
+	Pending    int64   `json:"pending"`
+	Sent       int64   `json:"sent"`
+	Failed     int64   `json:"failed"`
+	Skipped    int64   `json:"skipped"`    // opted out of marketing pushes
+	Clicked    int64   `json:"clicked"`    // recipients who clicked at least once
+	Clicks     int64   `json:"clicks"`     // every click, including repeats
+	ClickRate  float64 `json:"click_rate"` // clicked / sent, in percent
+}
+
+// CreatePushCampaignRequest is the admin request for a new campaign
+type CreatePushCampaignRequest struct {
+	Name          string                       `json:"name" binding:"required"`
+	Segment       *NotificationSegment         `json:"segment"` // null sends to every user with push
+	Variants      []PushCampaignVariantRequest `json:"variants" binding:"required"`
+	RatePerMinute int                          `json:"rate_per_minute"` // defaults to 600
+	ScheduledAt   *time.Time                   `json:"scheduled_at"`    // null or past sends immediately
+}
+
+// PushCampaignVariantRequest is one variant of a new campaign
+type PushCampaignVariantRequest struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Icon     string `json:"icon"`
+	DeepLink string `json:"deep_link"`
+}
+
+// validPushCampaignLink accepts app paths and http(s) URLs
+func validPushCampaignLink(link string) bool {
+	if strings.HasPrefix(link, "/") {
+		return !strings.HasPrefix(link, "//")
+	}
+	return strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://")
+}
+
+// CreatePushCampaign validates and stores a campaign as scheduled
+func CreatePushCampaign(db *gorm.DB, createdByID uint, req CreatePushCampaignRequest) (*PushCampaign, error) {
+	if len(req.Variants) == 0 || len(req.Variants) > MaxPushCampaignVariants {
+		return nil, &PushCampaignError{Message: "کمپین باید یک یا دو نسخه (A/B) داشته باشد"}
+	}
+	if req.RatePerMinute == 0 {
+		req.RatePerMinute = DefaultPushCampaignRatePerMinute
+	}
+	if req.RatePerMinute < 1 || req.RatePerMinute > MaxPushCampaignRatePerMinute {
+		return nil, &PushCampaignError{Message: "سرعت ارسال باید بین ۱ تا ۶۰۰۰ پوش در دقیقه باشد"}
+	}
+
+	now := time.Now()
+	scheduledAt := now
+	if req.ScheduledAt != nil && req.ScheduledAt.After(now) {
+		scheduledAt = *req.ScheduledAt
+	}
+	campaign := PushCampaign{
+		Name:          strings.TrimSpace(req.Name),
+		Audience:      NotificationAudienceAll,
+		RatePerMinute: req.RatePerMinute,
+		Status:        PushCampaignScheduled,
+		ScheduledAt:   &scheduledAt,
+		CreatedByID:   createdByID,
+	}
+	if campaign.Name == "" {
+		return nil, &PushCampaignError{Message: "نام کمپین الزامی است"}
+	}
+
+	if req.Segment != nil {
+		if err := req.Segment.Validate(); err != nil {
+			return nil, &PushCampaignError{Message: err.Error()}
+		}
+		data, err := json.Marshal(req.Segment)
+		if err != nil {
+			return nil, err
+		}
+		campaign.Audience = NotificationAudienceSegment
+		campaign.SegmentJSON = string(data)
+	}
+
+	for i, v := range req.Variants {
+		variant := PushCampaignVariant{
+			Label:    pushCampaignVariantLabels[i],
+			Title:    strings.TrimSpace(v.Title),
+			Message:  strings.TrimSpace(v.Message),
+			Icon:     strings.TrimSpace(v.Icon),
+			DeepLink: strings.TrimSpace(v.DeepLink),
+		}
+		if variant.Title == "" || variant.Message == "" {
+			return nil, &PushCampaignError{Message: "عنوان و متن نسخه " + variant.Label + " الزامی است"}
+		}
+		if variant.DeepLink == "" {
+			variant.DeepLink = "/"
+		}
+		if !validPushCampaignLink(variant.DeepLink) {
+			return nil, &PushCampaignError{Message: "لینک نسخه " + variant.Label + " باید مسیری در اپلیکیشن (مثل /products) یا آدرس http(s) باشد"}
+		}
+		if variant.Icon != "" && !validPushCampaignLink(variant.Icon) {
+			return nil, &PushCampaignError{Message: "آیکون نسخه " + variant.Label + " نامعتبر است"}
+		}
+		campaign.Variants = append(campaign.Variants, variant)
+	}
+
+	if err := db.Create(&campaign).Error; err != nil {
+		return nil, err
+	}
+	return GetPushCampaign(db, campaign.ID)
+}
+
+// GetPushCampaign returns a campaign with its variants
+func GetPushCampaign(db *gorm.DB, id uint) (*PushCampaign, error) {
+	var campaign PushCampaign
+	err := db.Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("label") }).
+		First(&campaign, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// GetPushCampaigns lists campaigns, newest first
+func GetPushCampaigns(db *gorm.DB, limit, offset int) ([]PushCampaign, int64, error) {
+	var total int64
+	if err := db.Model(&PushCampaign{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var campaigns []PushCampaign
+	err := db.Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("label") }).
+		Order("id DESC").Limit(limit).Offset(offset).Find(&campaigns).Error
+	return campaigns, total, err
+}
+
+// CancelPushCampaign cancels a campaign that has not started sending
+func CancelPushCampaign(db *gorm.DB, id uint) error {
+	result := db.Model(&PushCampaign{}).Where("id = ? AND status = ?", id, PushCampaignScheduled).
+		Update("status", PushCampaignCanceled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := db.First(&PushCampaign{}, id).Error; err != nil {
+			return err
+		}
+		return ErrPushCampaignNotScheduled
+	}
+	return nil
+}
+
+// ClaimPushCampaignForSending moves a due scheduled campaign to sending.
+// Returns false if another worker already claimed it or it is not yet due.
+func ClaimPushCampaignForSending(db *gorm.DB, id uint) (bool, error) {
+	result := db.Model(&PushCampaign{}).
+		Where("id = ? AND status = ? AND (scheduled_at IS NULL OR scheduled_at <= ?)", id, PushCampaignScheduled, time.Now()).
+		Update("status", PushCampaignSending)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetDuePushCampaignIDs lists scheduled campaigns whose send time has passed
+func GetDuePushCampaignIDs(db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&PushCampaign{}).
+		Where("status = ? AND (scheduled_at IS NULL OR scheduled_at <= ?)", PushCampaignScheduled, time.Now()).
+		Order("scheduled_at").
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ResolvePushCampaignAudience returns the active users the campaign targets
+// who have an active push subscription, by ID
+func ResolvePushCampaignAudience(db *gorm.DB, campaign *PushCampaign) ([]uint, error) {
+	query := db.Model(&User{}).Where("users.is_active = ?", true)
+	if campaign.Segment != nil {
+		query = SegmentUsersQuery(db, *campaign.Segment)
+	}
+	var userIDs []uint
+	err := query.Where("users.id IN (?)", db.Model(&PushSubscription{}).Select("user_id").Where("is_active = ?", true)).
+		Order("users.id").Pluck("users.id", &userIDs).Error
+	return userIDs, err
+}
+
+// NewPushCampaignRecipient creates an unsaved recipient with a random click token
+func NewPushCampaignRecipient(campaignID, userID, variantID uint) PushCampaignRecipient {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return PushCampaignRecipient{
+		CampaignID: campaignID,
+		UserID:     userID,
+		VariantID:  variantID,
+		Token:      hex.EncodeToString(token),
+		Status:     PushCampaignRecipientPending,
+	}
+}
+
+// PublishPushCampaign stores the recipients of a campaign and marks it sent
+func PublishPushCampaign(db *gorm.DB, campaign *PushCampaign, recipients []PushCampaignRecipient) error {
+	if len(recipients) > 0 {
+		if err := db.CreateInBatches(&recipients, 500).Error; err != nil {
+			return err
+		}
+	}
+	return db.Model(campaign).Updates(map[string]interface{}{
+		"status":          PushCampaignSent,
+		"sent_at":         time.Now(),
+		"recipient_count": len(recipients),
+	}).Error
+}
+
+// GetPushCampaignClickLink returns the variant link behind a tracked link
+// without counting a click
+func GetPushCampaignClickLink(db *gorm.DB, token string) (*PushCampaignRecipient, string, error) {
+	var recipient PushCampaignRecipient
+	if err := db.Where("token = ?", token).First(&recipient).Error; err != nil {
+		return nil, "", err
+	}
+	var variant PushCampaignVariant
+	if err := db.First(&variant, recipient.VariantID).Error; err != nil {
+		return nil, "", err
+	}
+	return &recipient, variant.DeepLink, nil
+}
+
+// RecordPushCampaignClick counts a click on a tracked link and returns the
+// variant link to send the user to
+func RecordPushCampaignClick(db *gorm.DB, token string) (string, error) {
+	recipient, link, err := GetPushCampaignClickLink(db, token)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = db.Model(&PushCampaignRecipient{}).Where("id = ?", recipient.ID).Updates(map[string]interface{}{
+		"click_count": gorm.Expr("click_count + 1"),
+		"clicked_at":  gorm.Expr("COALESCE(clicked_at, ?)", now),
+	}).Error
+	return link, err
+}
+
+// GetPushCampaignResults reports delivery and clicks per variant
+func GetPushCampaignResults(db *gorm.DB, campaign *PushCampaign) ([]PushCampaignVariantResult, error) {
+	var rows []struct {
+		VariantID uint
+		Status    string
+		Count     int64
+		Clicked   int64
+		Clicks    int64
+	}
+	err := db.Model(&PushCampaignRecipient{}).
+		Select(`variant_id, status, COUNT(*) AS count,
+			SUM(CASE WHEN clicked_at IS NOT NULL THEN 1 ELSE 0 END) AS clicked,
+			COALESCE(SUM(click_count), 0) AS clicks`).
+		Where("campaign_id = ?", campaign.ID).
+		Group("variant_id, status").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PushCampaignVariantResult, len(campaign.Variants))
+	index := make(map[uint]int, len(campaign.Variants))
+	for i, variant := range campaign.Variants {
+		results[i] = PushCampaignVariantResult{VariantID: variant.ID, Label: variant.Label}
+		index[variant.ID] = i
+	}
+	for _, row := range rows {
+		i, ok := index[row.VariantID]
+		if !ok {
+			continue
+		}
+		result := &results[i]
+		result.Recipients += row.Count
+		result.Clicked += row.Clicked
+		result.Clicks += row.Clicks
+		switch row.Status {
+		case PushCampaignRecipientPending:
+			result.Pending += row.Count
+		case PushCampaignRecipientSent:
+			result.Sent += row.Count
+		case PushCampaignRecipientFailed:
+			result.Failed += row.Count
+		case PushCampaignRecipientSkipped:
+			result.Skipped += row.Count
+		}
+	}
+	for i := range results {
+		if results[i].Sent > 0 {
+			results[i].ClickRate = float64(results[i].Clicked) * 100 / float64(results[i].Sent)
+		}
+	}
+	return results, nil
+}
@@ -1,28 +1,117 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Push transports
+const (
+	PushTransportWebPush = "webpush" // endpoint is a Web Push URL with p256dh/auth keys
+	PushTransportFCM     = "fcm"     // endpoint is an FCM registration token
+)
+
+// Push platforms
+const (
+	PushPlatformWeb     = "web"
+	PushPlatformAndroid = "android"
+	PushPlatformIOS     = "ios"
+)
+
+// Push delivery outcomes counted in PushDeliveryMetric
+const (
+	PushOutcomeSent             = "sent"
+	PushOutcomeTransientFailure = "transient_failure"
+	PushOutcomePermanentFailure = "permanent_failure"
+)
+
+// UnhealthyPushFailureStreak is the number of failures in a row after which
+// a subscription is reported as unhealthy. It stays active: only permanent
+// errors deactivate a subscription.
+const UnhealthyPushFailureStreak = 3
+
 // PushSubscription represents a user's push notification subscription
 type PushSubscription struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	UserID    uint           `json:"user_id" gorm:"not null;index"`
-	User      User           `json:"user" gorm:"foreignKey:UserID"`
-	Endpoint  string         `json:"endpoint" gorm:"type:text;not null"` // Can be FCM token or WebPush endpoint (no index on TEXT in MySQL)
-	P256dh    string         `json:"p256dh" gorm:"type:text"`            // Optional for FCM
-	Auth      string         `json:"auth" gorm:"type:text"`              // Optional for FCM
-	UserAgent string         `json:"user_agent" gorm:"size:500"`
-	IsActive  bool           `json:"is_active" gorm:"default:true"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
-}
-
-// CreatePushSubscription creates a new push subscription
-func CreatePushSubscription(db *gorm.DB, userID uint, endpoint, p256dh, auth, userAgent string) (*PushSubscription, error) {
+	ID                  uint           `json:"id" gorm:"primaryKey"`
+	UserID              uint           `json:"user_id" gorm:"not null;index"`
+	User                User           `json:"user" gorm:"foreignKey:UserID"`
+	Endpoint            string         `json:"endpoint" gorm:"type:text;not null"` // Can be FCM token or WebPush endpoint (no index on TEXT in MySQL)
+	P256dh              string         `json:"p256dh" gorm:"type:text"`            // Optional for FCM
+	Auth                string         `json:"auth" gorm:"type:text"`              // Optional for FCM
+	Transport           string         `json:"transport" gorm:"size:10;not null;default:'webpush'"`
+	Platform            string         `json:"platform" gorm:"size:20;not null;default:'web'"`
+	UserAgent           string         `json:"user_agent" gorm:"size:500"`
+	IsActive            bool           `json:"is_active" gorm:"default:true"`
+	SuccessCount        int            `json:"success_count" gorm:"default:0"`
+	FailureCount        int            `json:"failure_count" gorm:"default:0"`
+	ConsecutiveFailures int            `json:"consecutive_failures" gorm:"default:0"`
+	LastSuccessAt       *time.Time     `json:"last_success_at"`
+	LastFailureAt       *time.Time     `json:"last_failure_at"`
+	LastError           string         `json:"last_error" gorm:"size:500"`
+	DeactivatedAt       *time.Time     `json:"deactivated_at"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// PushDeliveryMetric counts the push deliveries of one day by transport,
+// platform and outcome
+type PushDeliveryMetric struct {
+	ID         uint      `json:"-" gorm:"primaryKey"`
+	Date       time.Time `json:"date" gorm:"type:date;not null;uniqueIndex:idx_push_delivery_metric"`
+	Transport  string    `json:"transport" gorm:"size:10;not null;uniqueIndex:idx_push_delivery_metric"`
+	Platform   string    `json:"platform" gorm:"size:20;not null;uniqueIndex:idx_push_delivery_metric"`
+	Outcome    string    `json:"outcome" gorm:"size:20;not null;uniqueIndex:idx_push_delivery_metric"`
+	Deliveries int64     `json:"deliveries" gorm:"not null;default:0"`
+}
+
+// PushSubscriptionHealth counts the subscriptions of one transport and platform
+type PushSubscriptionHealth struct {
+	Transport string `json:"transport"`
+	Platform  string `json:"platform"`
+	Active    int64  `json:"active"`
+	Inactive  int64  `json:"inactive"`
+	Unhealthy int64  `json:"unhealthy"` // active with UnhealthyPushFailureStreak or more failures in a row
+}
+
+// DetectPushTransport returns the transport of an endpoint registered
+// without one: Web Push endpoints are URLs, anything else is an FCM token
+func DetectPushTransport(endpoint string) string {
+	if strings.HasPrefix(endpoint, "https://") || strings.HasPrefix(endpoint, "http://") {
+		return PushTransportWebPush
+	}
+	return PushTransportFCM
+}
+
+// DetectPushPlatform guesses the platform of a subscription from the user agent
+func DetectPushPlatform(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "android"):
+		return PushPlatformAndroid
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		return PushPlatformIOS
+	}
+	return PushPlatformWeb
+}
+
+// IsValidPushTransport reports whether transport is a known transport
+func IsValidPushTransport(transport string) bool {
+	return transport == PushTransportWebPush || transport == PushTransportFCM
+}
+
+// IsValidPushPlatform reports whether platform is a known platform
+func IsValidPushPlatform(platform string) bool {
+	return platform == PushPlatformWeb || platform == PushPlatformAndroid || platform == PushPlatformIOS
+}
+
+// CreatePushSubscription creates a new push subscription. An existing
+// subscription of the user with the same endpoint is updated and reactivated
+// with its failure streak cleared.
+func CreatePushSubscription(db *gorm.DB, userID uint, endpoint, p256dh, auth, userAgent, transport, platform string) (*PushSubscription, error) {
 	// Check if subscription already exists for this user and endpoint
 	var existing PushSubscription
 	if err := db.Where("user_id = ? AND endpoint = ?", userID, endpoint).First(&existing).Error; err == nil {
@@ -30,7 +119,11 @@ func CreatePushSubscription(db *gorm.DB, userID uint, endpoint, p256dh, auth, us
 		existing.P256dh = p256dh
 		existing.Auth = auth
 		existing.UserAgent = userAgent
+		existing.Transport = transport
+		existing.Platform = platform
 		existing.IsActive = true
+		existing.ConsecutiveFailures = 0
+		existing.DeactivatedAt = nil
 		if err := db.Save(&existing).Error; err != nil {
 			return nil, err
 		}
@@ -42,6 +135,8 @@ func CreatePushSubscription(db *gorm.DB, userID uint, endpoint, p256dh, auth, us
 		Endpoint:  endpoint,
 		P256dh:    p256dh,
 		Auth:      auth,
+		Transport: transport,
+		Platform:  platform,
 		UserAgent: userAgent,
 		IsActive:  true,
 	}
@@ -67,11 +162,141 @@ func GetAllActivePushSubscriptions(db *gorm.DB) ([]PushSubscription, error) {
 	return subscriptions, err
 }
 
+// GetActivePushSubscriptionsForUsers gets active push subscriptions of the given users
+func GetActivePushSubscriptionsForUsers(db *gorm.DB, userIDs []uint) ([]PushSubscription, error) {
+	var subscriptions []PushSubscription
+	if len(userIDs) == 0 {
+		return subscriptions, nil
+	}
+	err := db.Where("user_id IN ? AND is_active = ?", userIDs, true).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// FilterUsersWithPushSubscriptions returns which of the given users have at
+// least one active push subscription
+func FilterUsersWithPushSubscriptions(db *gorm.DB, userIDs []uint) ([]uint, error) {
+	var subscribed []uint
+	// Chunk to keep the IN list bounded
+	for start := 0; start < len(userIDs); start += 500 {
+		end := start + 500
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		var chunk []uint
+		err := db.Model(&PushSubscription{}).Distinct("user_id").
+			Where("user_id IN ? AND is_active = ?", userIDs[start:end], true).
+			Pluck("user_id", &chunk).Error
+		if err != nil {
+			return nil, err
+		}
+		subscribed = append(subscribed, chunk...)
+	}
+	return subscribed, nil
+}
+
 // DeactivatePushSubscription deactivates a push subscription
 func DeactivatePushSubscription(db *gorm.DB, userID uint, endpoint string) error {
 	return db.Model(&PushSubscription{}).
 		Where("user_id = ? AND endpoint = ?", userID, endpoint).
-		Update("is_active", false).Error
+		Updates(map[string]interface{}{"is_active": false, "deactivated_at": time.Now()}).Error
+}
+
+// RecordPushDeliverySuccess records a delivered push on the subscription and
+// in the daily metrics
+func RecordPushDeliverySuccess(db *gorm.DB, sub *PushSubscription) error {
+	now := time.Now()
+	err := db.Model(&PushSubscription{}).Where("id = ?", sub.ID).Updates(map[string]interface{}{
+		"success_count":        gorm.Expr("success_count + 1"),
+		"consecutive_failures": 0,
+		"last_success_at":      now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return incrementPushDeliveryMetric(db, now, sub, PushOutcomeSent)
+}
+
+// RecordPushDeliveryFailure records a failed push on the subscription and in
+// the daily metrics. A permanent failure (the push service no longer knows
+// the subscription) deactivates it; transient failures only count.
+func RecordPushDeliveryFailure(db *gorm.DB, sub *PushSubscription, errMsg string, permanent bool) error {
+	now := time.Now()
+	if len(errMsg) > 500 {
+		errMsg = errMsg[:500]
+	}
+	updates := map[string]interface{}{
+		"failure_count":        gorm.Expr("failure_count + 1"),
+		"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+		"last_failure_at":      now,
+		"last_error":           errMsg,
+	}
+	outcome := PushOutcomeTransientFailure
+	if permanent {
+		updates["is_active"] = false
+		updates["deactivated_at"] = now
+		outcome = PushOutcomePermanentFailure
+	}
+	if err := db.Model(&PushSubscription{}).Where("id = ?", sub.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	return incrementPushDeliveryMetric(db, now, sub, outcome)
+}
+
+func incrementPushDeliveryMetric(db *gorm.DB, at time.Time, sub *PushSubscription, outcome string) error {
+	metric := PushDeliveryMetric{
+		Date:       startOfDay(at),
+		Transport:  sub.Transport,
+		Platform:   sub.Platform,
+		Outcome:    outcome,
+		Deliveries: 1,
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "transport"}, {Name: "platform"}, {Name: "outcome"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"deliveries": gorm.Expr("deliveries + 1")}),
+	}).Create(&metric).Error
+}
+
+// GetPushDeliveryMetrics returns the daily delivery counts since the given day
+func GetPushDeliveryMetrics(db *gorm.DB, since time.Time) ([]PushDeliveryMetric, error) {
+	var metrics []PushDeliveryMetric
+	err := db.Where("date >= ?", startOfDay(since)).
+		Order("date DESC, transport, platform, outcome").Find(&metrics).Error
+	return metrics, err
+}
+
+// GetPushSubscriptionHealth counts subscriptions per transport and platform
+func GetPushSubscriptionHealth(db *gorm.DB) ([]PushSubscriptionHealth, error) {
+	var health []PushSubscriptionHealth
+	err := db.Model(&PushSubscription{}).
+		Select(`transport, platform,
+			SUM(CASE WHEN is_active THEN 1 ELSE 0 END) AS active,
+			SUM(CASE WHEN is_active THEN 0 ELSE 1 END) AS inactive,
+			SUM(CASE WHEN is_active AND consecutive_failures >= ? THEN 1 ELSE 0 END) AS unhealthy`, UnhealthyPushFailureStreak).
+		Group("transport, platform").Order("transport, platform").Scan(&health).Error
+	return health, err
+}
+
+// BackfillPushSubscriptionTransports sets the transport and platform of
+// subscriptions stored before they were recorded. Those rows got the webpush
+// and web defaults, so FCM tokens are moved to fcm and the platform is taken
+// from the user agent as DetectPushPlatform does. It runs once, from
+// RunDataMigrations: afterwards a web platform was chosen by the client.
+func BackfillPushSubscriptionTransports(db *gorm.DB) error {
+	err := db.Model(&PushSubscription{}).
+		Where("transport = ? AND endpoint NOT LIKE ? AND endpoint NOT LIKE ?", PushTransportWebPush, "https://%", "http://%").
+		Update("transport", PushTransportFCM).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&PushSubscription{}).
+		Where("platform = ? AND LOWER(user_agent) LIKE ?", PushPlatformWeb, "%android%").
+		Update("platform", PushPlatformAndroid).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&PushSubscription{}).
+		Where("platform = ? AND (LOWER(user_agent) LIKE ? OR LOWER(user_agent) LIKE ?)", PushPlatformWeb, "%iphone%", "%ipad%").
+		Update("platform", PushPlatformIOS).Error
 }
 
 // DeletePushSubscription deletes a push subscription
@@ -0,0 +1,42 @@
+package models_test
+
+import (
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestPushSubscriptionBackfillRunsOnce(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000041")
+	android := "Mozilla/5.0 (Linux; Android 14) Chrome/126.0"
+
+	// Stored before transport and platform were recorded
+	legacy, err := models.CreatePushSubscription(db, user.ID, "fcm-token", "", "", android,
+		models.PushTransportWebPush, models.PushPlatformWeb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.RunDataMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	db.First(legacy, legacy.ID)
+	if legacy.Transport != models.PushTransportFCM || legacy.Platform != models.PushPlatformAndroid {
+		t.Fatalf("legacy subscription = %s/%s, want fcm/android", legacy.Transport, legacy.Platform)
+	}
+
+	// A browser on Android registering as web later keeps its platform
+	browser, err := models.CreatePushSubscription(db, user.ID, "https://push.example.com/1", "key", "auth", android,
+		models.PushTransportWebPush, models.PushPlatformWeb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.RunDataMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	db.First(browser, browser.ID)
+	if browser.Platform != models.PushPlatformWeb {
+		t.Fatalf("web subscription moved to %s on the next start", browser.Platform)
+	}
+}
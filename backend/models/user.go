@@ -89,8 +89,9 @@ type UserResponse struct {
 }
 
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	User         UserResponse `json:"user"`
 }
 
 type PasswordRecoveryRequest struct {
@@ -103,6 +104,15 @@ type PasswordResetRequest struct {
 	NewPassword string `json:"new_password" binding:"required,min=6"`
 }
 
+type PhoneVerificationRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type PhoneVerifyRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required,len=6,numeric"`
+}
+
 type PasswordRecoveryResponse struct {
 	Message string `json:"message"`
 	Success bool   `json:"success"`
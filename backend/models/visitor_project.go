@@ -51,6 +51,7 @@ type VisitorProject struct {
 	AcceptedSupplierID   *uint      `json:"accepted_supplier_id" gorm:"index"`       // Supplier who was accepted
 	AcceptedSupplier     *Supplier  `json:"accepted_supplier,omitempty" gorm:"foreignKey:AcceptedSupplierID"`
 	AcceptedAt           *time.Time `json:"accepted_at"`
+	AcceptedOfferID      *uint      `json:"accepted_offer_id"` // Negotiation offer whose terms were agreed, if any
 
 	// Relations
 	Proposals []VisitorProjectProposal `json:"proposals" gorm:"foreignKey:VisitorProjectID"`
@@ -61,6 +62,12 @@ type VisitorProject struct {
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// IsOpenForNegotiation reports whether suppliers can still negotiate on the
+// project and be accepted
+func (p *VisitorProject) IsOpenForNegotiation() bool {
+	return (p.Status == "pending" || p.Status == "active") && p.AcceptedSupplierID == nil && p.ExpiresAt.After(time.Now())
+}
+
 // VisitorProjectProposal represents a supplier's proposal to a visitor project
 type VisitorProjectProposal struct {
 	ID               uint           `json:"id" gorm:"primaryKey"`
@@ -89,7 +96,8 @@ type VisitorProjectProposal struct {
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
-// VisitorProjectChat represents a chat conversation for a visitor project
+// VisitorProjectChat represents a chat conversation for a visitor project.
+// Chats now live in Conversation; this table is only read by MigrateLegacyChats.
 type VisitorProjectChat struct {
 	ID               uint           `json:"id" gorm:"primaryKey"`
 	VisitorProjectID uint           `json:"visitor_project_id" gorm:"not null;index"`
@@ -492,110 +500,3 @@ func CheckAndExpireVisitorProjects(db *gorm.DB) error {
 func CloseVisitorProject(db *gorm.DB, id uint) error {
 	return db.Model(&VisitorProject{}).Where("id = ?", id).Update("status", "completed").Error
 }
-
-// GetOrCreateVisitorProjectChat gets or creates a chat for a visitor project
-func GetOrCreateVisitorProjectChat(db *gorm.DB, projectID, visitorID, supplierID uint) (*VisitorProjectChat, error) {
-	var chat VisitorProjectChat
-	err := db.Where("visitor_project_id = ? AND visitor_id = ? AND supplier_id = ?", projectID, visitorID, supplierID).
-		First(&chat).Error
-	
-	if err == gorm.ErrRecordNotFound {
-		// Create new chat
-		chat = VisitorProjectChat{
-			VisitorProjectID: projectID,
-			VisitorID:        visitorID,
-			SupplierID:       supplierID,
-			Status:           "active",
-		}
-		if err := db.Create(&chat).Error; err != nil {
-			return nil, err
-		}
-		return &chat, nil
-	}
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	return &chat, nil
-}
-
-// CreateVisitorProjectMessage creates a new message in a chat
-func CreateVisitorProjectMessage(db *gorm.DB, chatID, senderID uint, senderType, message, imageURL string) (*VisitorProjectMessage, error) {
-	msg := VisitorProjectMessage{
-		ChatID:     chatID,
-		SenderID:   senderID,
-		SenderType: senderType,
-		Message:    message,
-		ImageURL:   imageURL,
-	}
-	
-	if err := db.Create(&msg).Error; err != nil {
-		return nil, err
-	}
-	
-	// Update chat's last message info
-	now := time.Now()
-	preview := message
-	if len(preview) > 100 {
-		preview = preview[:100] + "..."
-	}
-	db.Model(&VisitorProjectChat{}).Where("id = ?", chatID).Updates(map[string]interface{}{
-		"last_message_at":      &now,
-		"last_message_preview": preview,
-	})
-	
-	// Reload with relations
-	if err := db.Preload("Sender").First(&msg, msg.ID).Error; err != nil {
-		return nil, err
-	}
-	
-	return &msg, nil
-}
-
-// GetVisitorProjectChatMessages gets messages for a chat
-func GetVisitorProjectChatMessages(db *gorm.DB, chatID uint, page, perPage int) ([]VisitorProjectMessage, int64, error) {
-	var messages []VisitorProjectMessage
-	var total int64
-	
-	query := db.Model(&VisitorProjectMessage{}).Where("chat_id = ?", chatID)
-	
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
-	
-	offset := (page - 1) * perPage
-	err := query.Preload("Sender").Offset(offset).Limit(perPage).Order("created_at ASC").Find(&messages).Error
-	
-	return messages, total, err
-}
-
-// GetVisitorProjectChatsForUser gets all chats for a user (visitor or supplier)
-func GetVisitorProjectChatsForUser(db *gorm.DB, userID uint, isSupplier bool) ([]VisitorProjectChat, error) {
-	var chats []VisitorProjectChat
-	var err error
-	
-	if isSupplier {
-		// Get supplier's chats
-		var supplier Supplier
-		if err := db.Where("user_id = ?", userID).First(&supplier).Error; err != nil {
-			return nil, err
-		}
-		err = db.Preload("Visitor").Preload("Supplier").Preload("VisitorProject").
-			Where("supplier_id = ?", supplier.ID).
-			Order("last_message_at DESC NULLS LAST, created_at DESC").
-			Find(&chats).Error
-	} else {
-		// Get visitor's chats
-		var visitor Visitor
-		if err := db.Where("user_id = ?", userID).First(&visitor).Error; err != nil {
-			return nil, err
-		}
-		err = db.Preload("Visitor").Preload("Supplier").Preload("VisitorProject").
-			Where("visitor_id = ?", visitor.ID).
-			Order("last_message_at DESC NULLS LAST, created_at DESC").
-			Find(&chats).Error
-	}
-	
-	return chats, err
-}
@@ -41,6 +41,11 @@ type WithdrawalRequest struct {
 	CompletedAt *time.Time       `json:"completed_at"`
 	RejectedAt  *time.Time       `json:"rejected_at"`
 
+	// Settlement in rial, captured when the request is completed
+	ExchangeRateID   *uint    `json:"exchange_rate_id"`
+	AppliedRateIRR   *float64 `json:"applied_rate_irr"`   // rials per unit of Currency
+	SettledAmountIRR *float64 `json:"settled_amount_irr"` // rials paid to the Sheba account
+
 	// Admin notes and receipt
 	AdminNotes  string `json:"admin_notes" gorm:"type:text"`
 	ReceiptPath string `json:"receipt_path" gorm:"size:255"`
@@ -116,33 +121,6 @@ func CreateWithdrawalRequest(db *gorm.DB, request *WithdrawalRequest) error {
 	return db.Create(request).Error
 }
 
-// UpdateWithdrawalStatus updates the status of a withdrawal request
-func UpdateWithdrawalStatus(db *gorm.DB, requestID uint, status WithdrawalStatus, adminID *uint, notes string, destinationAccount string) error {
-	updates := map[string]interface{}{
-		"status":      status,
-		"admin_id":    adminID,
-		"admin_notes": notes,
-	}
-
-	now := time.Now()
-
-	switch status {
-	case WithdrawalStatusApproved:
-		updates["approved_at"] = now
-		if destinationAccount != "" {
-			updates["destination_account"] = destinationAccount
-		}
-	case WithdrawalStatusProcessing:
-		// Processing status doesn't need a specific timestamp
-	case WithdrawalStatusCompleted:
-		updates["completed_at"] = now
-	case WithdrawalStatusRejected:
-		updates["rejected_at"] = now
-	}
-
-	return db.Model(&WithdrawalRequest{}).Where("id = ?", requestID).Updates(updates).Error
-}
-
 // GetWithdrawalRequestByID retrieves a withdrawal request by ID
 func GetWithdrawalRequestByID(db *gorm.DB, id uint) (*WithdrawalRequest, error) {
 	var request WithdrawalRequest
@@ -172,77 +150,146 @@ func UploadReceipt(db *gorm.DB, requestID uint, receiptPath string) error {
 	return db.Model(&WithdrawalRequest{}).Where("id = ?", requestID).Update("receipt_path", receiptPath).Error
 }
 
-// GetWithdrawalStats returns statistics about withdrawal requests
-func GetWithdrawalStats(db *gorm.DB, userID *uint) (map[string]interface{}, error) {
+// GetWithdrawalStats returns statistics about withdrawal requests. Amounts
+// are reported in baseCurrency (rial when empty): settled requests count with
+// the rial amount actually paid, others are converted at today's rates.
+func GetWithdrawalStats(db *gorm.DB, userID *uint, baseCurrency string) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 
-	query := db.Model(&WithdrawalRequest{})
-	if userID != nil {
-		query = query.Where("user_id = ?", *userID)
+	scope := func() *gorm.DB {
+		query := db.Model(&WithdrawalRequest{})
+		if userID != nil {
+			query = query.Where("user_id = ?", *userID)
+		}
+		return query
 	}
 
 	// Total requests
 	var total int64
-	query.Count(&total)
+	if err := scope().Count(&total).Error; err != nil {
+		return nil, err
+	}
 	stats["total"] = total
 
-	// Completed requests
-	var completed int64
-	query.Where("status = ?", WithdrawalStatusCompleted).Count(&completed)
-	stats["completed"] = completed
-
-	// Pending requests
-	var pending int64
-	query.Where("status = ?", WithdrawalStatusPending).Count(&pending)
-	stats["pending"] = pending
-
-	// Processing requests
-	var processing int64
-	query.Where("status = ?", WithdrawalStatusProcessing).Count(&processing)
-	stats["processing"] = processing
+	for _, status := range []WithdrawalStatus{WithdrawalStatusCompleted, WithdrawalStatusPending, WithdrawalStatusProcessing, WithdrawalStatusRejected} {
+		var count int64
+		if err := scope().Where("status = ?", status).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		stats[string(status)] = count
+	}
 
 	// Total amount withdrawn (completed only)
-	var totalAmount float64
-	query.Where("status = ?", WithdrawalStatusCompleted).Select("COALESCE(SUM(amount), 0)").Scan(&totalAmount)
+	var totals []withdrawalCurrencyTotal
+	err := scope().Where("status = ?", WithdrawalStatusCompleted).
+		Select("currency, " + withdrawalAmountColumns).Group("currency").Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	baseCurrency = withdrawalBaseCurrency(baseCurrency)
+	converter := NewCurrencyConverter(db, time.Now())
+	totalAmount := 0.0
+	byCurrency := make(map[string]float64)
+	unconverted := []string{}
+	for _, row := range totals {
+		byCurrency[row.Currency] += row.Amount + row.SettledAmount
+		amount, err := row.convert(converter, baseCurrency)
+		if err != nil {
+			unconverted = append(unconverted, row.Currency)
+			continue
+		}
+		totalAmount += amount
+	}
+	stats["base_currency"] = baseCurrency
 	stats["total_amount"] = totalAmount
+	stats["amounts_by_currency"] = byCurrency
+	stats["unconverted_currencies"] = unconverted
 
 	return stats, nil
 }
 
-// GetWithdrawalChartData returns chart data for user withdrawal history
-func GetWithdrawalChartData(db *gorm.DB, userID uint) ([]map[string]interface{}, error) {
+// withdrawalAmountColumns sums the amount of unsettled requests in their own
+// currency and the rial amount of settled ones
+const withdrawalAmountColumns = "COALESCE(SUM(CASE WHEN settled_amount_irr IS NULL THEN amount ELSE 0 END), 0) AS amount, " +
+	"COALESCE(SUM(CASE WHEN settled_amount_irr IS NULL THEN 0 ELSE amount END), 0) AS settled_amount, " +
+	"COALESCE(SUM(settled_amount_irr), 0) AS settled_irr"
+
+// withdrawalCurrencyTotal is the sum of a group of withdrawal requests in one currency
+type withdrawalCurrencyTotal struct {
+	Currency      string
+	Amount        float64 // unsettled, in Currency
+	SettledAmount float64 // settled, in Currency
+	SettledIRR    float64 // settled, in rial
+}
+
+// convert returns the group's total in baseCurrency
+func (t withdrawalCurrencyTotal) convert(converter *CurrencyConverter, baseCurrency string) (float64, error) {
+	total, err := converter.Convert(t.SettledIRR, CurrencyIRR, baseCurrency)
+	if err != nil {
+		return 0, err
+	}
+	if t.Amount == 0 {
+		return total, nil
+	}
+	unsettled, err := converter.Convert(t.Amount, t.Currency, baseCurrency)
+	if err != nil {
+		return 0, err
+	}
+	return total + unsettled, nil
+}
+
+// withdrawalBaseCurrency returns the currency stats are reported in
+func withdrawalBaseCurrency(currency string) string {
+	if currency = NormalizeCurrency(currency); currency != "" {
+		return currency
+	}
+	return CurrencyIRR
+}
+
+// GetWithdrawalChartData returns chart data for user withdrawal history, with
+// amounts in baseCurrency (rial when empty). Days whose currencies have no
+// rate are reported without their amount.
+func GetWithdrawalChartData(db *gorm.DB, userID uint, baseCurrency string) ([]map[string]interface{}, error) {
 	var results []struct {
-		Date   string  `json:"date"`
-		Amount float64 `json:"amount"`
-		Count  int     `json:"count"`
-	}
-
-	// Get withdrawal data grouped by date (last 30 days)
-	err := db.Raw(`
-		SELECT 
-			DATE(requested_at) as date,
-			COALESCE(SUM(amount), 0) as amount,
-			COUNT(*) as count
-		FROM withdrawal_requests 
-		WHERE user_id = ? 
-		AND requested_at >= DATE_SUB(NOW(), INTERVAL 30 DAY)
-		AND status IN ('completed', 'approved', 'processing')
-		GROUP BY DATE(requested_at)
-		ORDER BY date ASC
-	`, userID).Scan(&results).Error
+		Date          string
+		Count         int
+		Currency      string
+		Amount        float64
+		SettledAmount float64
+		SettledIRR    float64
+	}
+
+	// Get withdrawal data grouped by date and currency (last 30 days)
+	err := db.Model(&WithdrawalRequest{}).
+		Select("DATE(requested_at) AS date, currency, COUNT(*) AS count, "+withdrawalAmountColumns).
+		Where("user_id = ? AND requested_at >= ?", userID, time.Now().AddDate(0, 0, -30)).
+		Where("status IN ?", []WithdrawalStatus{WithdrawalStatusCompleted, WithdrawalStatusApproved, WithdrawalStatusProcessing}).
+		Group("DATE(requested_at), currency").
+		Order("date ASC").
+		Scan(&results).Error
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert to map format for frontend
+	// Merge the currencies of each day and convert to map format for frontend
+	baseCurrency = withdrawalBaseCurrency(baseCurrency)
+	converter := NewCurrencyConverter(db, time.Now())
 	var chartData []map[string]interface{}
+	byDate := make(map[string]map[string]interface{})
 	for _, result := range results {
-		chartData = append(chartData, map[string]interface{}{
-			"name":  result.Date,
-			"sales": result.Amount,
-			"count": result.Count,
-		})
+		day, ok := byDate[result.Date]
+		if !ok {
+			day = map[string]interface{}{"name": result.Date, "sales": 0.0, "count": 0, "currency": baseCurrency}
+			byDate[result.Date] = day
+			chartData = append(chartData, day)
+		}
+		day["count"] = day["count"].(int) + result.Count
+		total := withdrawalCurrencyTotal{result.Currency, result.Amount, result.SettledAmount, result.SettledIRR}
+		if amount, err := total.convert(converter, baseCurrency); err == nil {
+			day["sales"] = day["sales"].(float64) + amount
+		}
 	}
 
 	return chartData, nil
@@ -0,0 +1,122 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestUpdateWithdrawalStatusStampsTimestamps(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	admin := testutil.CreateUser(t, db, "09120000002")
+	actor := models.WithdrawalActor{Type: models.AuditActorUser, ID: &admin.ID, Name: admin.Phone}
+
+	request := models.WithdrawalRequest{
+		UserID:        user.ID,
+		Amount:        250,
+		Currency:      "AED",
+		SourceCountry: "AE",
+	}
+	if err := models.CreateWithdrawalRequest(db, &request); err != nil {
+		t.Fatalf("create withdrawal: %v", err)
+	}
+	if request.Status != models.WithdrawalStatusPending {
+		t.Fatalf("status = %q, want pending", request.Status)
+	}
+
+	approved, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusApproved, actor, "ok", "IR120000000000000000000001")
+	if err != nil {
+		t.Fatalf("approve withdrawal: %v", err)
+	}
+	if approved.ApprovedAt == nil {
+		t.Fatal("approved_at not set")
+	}
+	if approved.DestinationAccount != "IR120000000000000000000001" {
+		t.Fatalf("destination_account = %q", approved.DestinationAccount)
+	}
+	if approved.AdminID == nil || *approved.AdminID != admin.ID {
+		t.Fatalf("admin_id = %v, want %d", approved.AdminID, admin.ID)
+	}
+
+	if _, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusProcessing, actor, "", ""); err != nil {
+		t.Fatalf("process withdrawal: %v", err)
+	}
+
+	// Completion needs the day's rate of the request's currency
+	if _, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusCompleted, actor, "", ""); !errors.Is(err, models.ErrExchangeRateNotFound) {
+		t.Fatalf("complete without rate: err = %v", err)
+	}
+	rate, err := models.SetExchangeRate(db, "aed", time.Now(), 160000, &admin.ID)
+	if err != nil {
+		t.Fatalf("set rate: %v", err)
+	}
+
+	completed, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusCompleted, actor, "", "")
+	if err != nil {
+		t.Fatalf("complete withdrawal: %v", err)
+	}
+	if completed.CompletedAt == nil || completed.Status != models.WithdrawalStatusCompleted {
+		t.Fatalf("completed withdrawal = %+v", completed)
+	}
+	if completed.AppliedRateIRR == nil || *completed.AppliedRateIRR != 160000 ||
+		completed.SettledAmountIRR == nil || *completed.SettledAmountIRR != 40000000 ||
+		completed.ExchangeRateID == nil || *completed.ExchangeRateID != rate.ID {
+		t.Fatalf("settlement = rate %v, amount %v, rate id %v", completed.AppliedRateIRR, completed.SettledAmountIRR, completed.ExchangeRateID)
+	}
+	if completed.AdminNotes != "ok" {
+		t.Fatalf("admin_notes = %q, an empty note must not clear it", completed.AdminNotes)
+	}
+}
+
+func TestWithdrawalTransitionsAndHistory(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000003")
+	telegramID := int64(424242)
+	bot := models.WithdrawalActor{Type: models.AuditActorTelegram, TelegramID: &telegramID, Name: "@ops"}
+
+	request := models.WithdrawalRequest{UserID: user.ID, Amount: 100, Currency: "USD", SourceCountry: "AE"}
+	if err := models.CreateWithdrawalRequest(db, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipping approval is not allowed
+	if _, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusCompleted, bot, "", ""); !errors.Is(err, models.ErrInvalidWithdrawalTransition) {
+		t.Fatalf("pending -> completed: err = %v", err)
+	}
+	if _, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusApproved, bot, "تایید", "ACC-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.UpdateWithdrawalStatus(db, request.ID, models.WithdrawalStatusRejected, bot, "مدارک ناقص", ""); err != nil {
+		t.Fatalf("approved -> rejected: %v", err)
+	}
+	for _, status := range []models.WithdrawalStatus{models.WithdrawalStatusCompleted, models.WithdrawalStatusPending, models.WithdrawalStatusApproved} {
+		if _, err := models.UpdateWithdrawalStatus(db, request.ID, status, bot, "", ""); !errors.Is(err, models.ErrInvalidWithdrawalTransition) {
+			t.Fatalf("rejected -> %s: err = %v", status, err)
+		}
+	}
+
+	reloaded, _ := models.GetWithdrawalRequestByID(db, request.ID)
+	if reloaded.Status != models.WithdrawalStatusRejected || reloaded.CompletedAt != nil {
+		t.Fatalf("rejected request changed: %+v", reloaded)
+	}
+
+	history, err := models.GetWithdrawalStatusHistory(db, request.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history has %d entries, want 2: %+v", len(history), history)
+	}
+	if history[0].FromStatus != models.WithdrawalStatusPending || history[0].ToStatus != models.WithdrawalStatusApproved || history[0].Note != "تایید" {
+		t.Fatalf("first entry = %+v", history[0])
+	}
+	last := history[1]
+	if last.ToStatus != models.WithdrawalStatusRejected || last.Note != "مدارک ناقص" ||
+		last.ActorType != models.AuditActorTelegram || last.ActorTelegramID == nil || *last.ActorTelegramID != telegramID || last.ActorName != "@ops" {
+		t.Fatalf("last entry = %+v", last)
+	}
+}
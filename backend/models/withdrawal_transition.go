@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WithdrawalActorRequester is the actor type of the user who owns the
+// withdrawal request, e.g. when their receipt upload moves it to processing
+const WithdrawalActorRequester = "requester"
+
+// ErrInvalidWithdrawalTransition is returned for a status change the
+// transition table does not allow
+var ErrInvalidWithdrawalTransition = errors.New("withdrawal status transition is not allowed")
+
+// withdrawalTransitions lists the statuses each status may move to. A request
+// goes pending → approved → processing → completed and can be rejected at any
+// step before completion. Completed and rejected requests are final.
+var withdrawalTransitions = map[WithdrawalStatus][]WithdrawalStatus{
+	WithdrawalStatusPending:    {WithdrawalStatusApproved, WithdrawalStatusRejected},
+	WithdrawalStatusApproved:   {WithdrawalStatusProcessing, WithdrawalStatusRejected},
+	WithdrawalStatusProcessing: {WithdrawalStatusCompleted, WithdrawalStatusRejected},
+}
+
+// CanTransitionWithdrawal reports whether a request in status from may move to to
+func CanTransitionWithdrawal(from, to WithdrawalStatus) bool {
+	for _, next := range withdrawalTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// WithdrawalActor is who changed a withdrawal request's status. Type is one
+// of the AuditActor* constants or WithdrawalActorRequester.
+type WithdrawalActor struct {
+	Type       string
+	ID         *uint
+	TelegramID *int64
+	Name       string
+}
+
+// WithdrawalStatusChange is one step in the status history of a withdrawal request
+type WithdrawalStatusChange struct {
+	ID                  uint             `json:"id" gorm:"primaryKey"`
+	WithdrawalRequestID uint             `json:"withdrawal_request_id" gorm:"not null;index"`
+	FromStatus          WithdrawalStatus `json:"from_status" gorm:"type:varchar(20);not null"`
+	ToStatus            WithdrawalStatus `json:"to_status" gorm:"type:varchar(20);not null"`
+	ActorType           string           `json:"actor_type" gorm:"size:20;not null"`
+	ActorID             *uint            `json:"actor_id"`
+	ActorTelegramID     *int64           `json:"actor_telegram_id"`
+	ActorName           string           `json:"actor_name" gorm:"size:255"`
+	Note                string           `json:"note" gorm:"type:text"`
+	CreatedAt           time.Time        `json:"created_at"`
+}
+
+// UpdateWithdrawalStatus moves a withdrawal request to status if the
+// transition table allows it, stamps the matching timestamp and records the
+// change in the request's history. Completing a request settles it at the
+// current exchange rate of its currency. The request is returned as updated.
+func UpdateWithdrawalStatus(db *gorm.DB, requestID uint, status WithdrawalStatus, actor WithdrawalActor, notes string, destinationAccount string) (*WithdrawalRequest, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var request WithdrawalRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, requestID).Error; err != nil {
+			return err
+		}
+		if !CanTransitionWithdrawal(request.Status, status) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidWithdrawalTransition, request.Status, status)
+		}
+
+		updates := map[string]interface{}{"status": status}
+		// admin_notes keeps the latest note for the existing admin views;
+		// the full trail is in the history
+		if notes != "" {
+			updates["admin_notes"] = notes
+		}
+		// admin_id refers to users, so only legacy admins can be stored there
+		if actor.Type == AuditActorUser && actor.ID != nil {
+			updates["admin_id"] = *actor.ID
+		}
+
+		now := time.Now()
+		switch status {
+		case WithdrawalStatusApproved:
+			updates["approved_at"] = now
+			if destinationAccount != "" {
+				updates["destination_account"] = destinationAccount
+			}
+		case WithdrawalStatusCompleted:
+			updates["completed_at"] = now
+			rate, err := GetSettlementRate(tx, request.Currency, now)
+			if err != nil {
+				return err
+			}
+			if rate.ID != 0 {
+				updates["exchange_rate_id"] = rate.ID
+			}
+			updates["applied_rate_irr"] = rate.RateIRR
+			updates["settled_amount_irr"] = math.Round(request.Amount * rate.RateIRR)
+		case WithdrawalStatusRejected:
+			updates["rejected_at"] = now
+		}
+
+		// The status guard keeps a concurrent change from being applied twice
+		result := tx.Model(&WithdrawalRequest{}).Where("id = ? AND status = ?", requestID, request.Status).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidWithdrawalTransition
+		}
+
+		return tx.Create(&WithdrawalStatusChange{
+			WithdrawalRequestID: requestID,
+			FromStatus:          request.Status,
+			ToStatus:            status,
+			ActorType:           actor.Type,
+			ActorID:             actor.ID,
+			ActorTelegramID:     actor.TelegramID,
+			ActorName:           actor.Name,
+			Note:                notes,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetWithdrawalRequestByID(db, requestID)
+}
+
+// GetWithdrawalStatusHistory gets the status changes of a withdrawal request, oldest first
+func GetWithdrawalStatusHistory(db *gorm.DB, requestID uint) ([]WithdrawalStatusChange, error) {
+	var history []WithdrawalStatusChange
+	err := db.Where("withdrawal_request_id = ?", requestID).Order("id ASC").Find(&history).Error
+	return history, err
+}
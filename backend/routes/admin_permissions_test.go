@@ -0,0 +1,91 @@
+package routes_test
+
+import (
+	"net/http"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminRoutesEnforcePermissions(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	user := testutil.CreateUser(t, db, "09120000001")
+	_, moderatorToken := testutil.CreateWebAdmin(t, db, "moderator", models.AdminRoleModerator)
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+
+	// Regular users never reach admin routes
+	rec, _ := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/users", testutil.Token(t, user), nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	// Moderators can list users but not generate licenses or delete users
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/users", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/admin/licenses/generate", moderatorToken, gin.H{"count": 1, "type": "plus"})
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodDelete, "/api/v1/admin/users/1", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	// Admins cannot manage panel admins by default
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/web-admins", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/withdrawal/stats", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+}
+
+func TestSuperAdminEditsRolePermissions(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	_, superToken := testutil.CreateWebAdmin(t, db, "root", models.AdminRoleSuperAdmin)
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+	_, moderatorToken := testutil.CreateWebAdmin(t, db, "moderator", models.AdminRoleModerator)
+
+	// Only super admins may edit the role map
+	rec, _ := testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/permissions/roles/moderator", adminToken,
+		gin.H{"permissions": []string{models.PermissionWithdrawalsView}})
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/permissions/roles/super_admin", superToken,
+		gin.H{"permissions": []string{}})
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/withdrawal/stats", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/permissions/roles/moderator", superToken,
+		gin.H{"permissions": []string{models.PermissionWithdrawalsView}})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/withdrawal/stats", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	// The moderator lost everything else with the edit
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/users", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/permissions", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ := body["data"].(map[string]interface{})
+	mine, _ := data["my_permissions"].([]interface{})
+	if len(mine) != 1 || mine[0] != models.PermissionWithdrawalsView {
+		t.Fatalf("unexpected my_permissions: %v", data["my_permissions"])
+	}
+
+	// Non-super admins cannot create super admins
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/permissions/roles/admin", superToken,
+		gin.H{"permissions": []string{models.PermissionAdminsManage}})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/admin/web-admins", adminToken, gin.H{
+		"name": "Root 2", "email": "root2@admin.aslmarket.local", "phone": "09120000002",
+		"username": "root2", "password": "secret123", "role": models.AdminRoleSuperAdmin, "is_active": true,
+	})
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+}
@@ -0,0 +1,73 @@
+package routes_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	user := testutil.CreateUser(t, db, "09120000001")
+	_, superToken := testutil.CreateWebAdmin(t, db, "root", models.AdminRoleSuperAdmin)
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+
+	userID := strconv.Itoa(int(user.ID))
+	rec, _ := testutil.DoJSON(t, router, http.MethodDelete, "/api/v1/admin/users/"+userID, adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	// Handlers without an explicit record are caught by the middleware
+	other := testutil.CreateUser(t, db, "09120000002")
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/users/"+strconv.Itoa(int(other.ID))+"/status", adminToken, gin.H{"is_active": true})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	// Read-only and failed requests leave no trace
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/users", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rec, _ = testutil.DoJSON(t, router, http.MethodDelete, "/api/v1/admin/users/abc", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	// Admins cannot read the audit log by default
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/audit", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/audit?actor_type=web_admin", superToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ := body["data"].(map[string]interface{})
+	if data["total"] != float64(2) {
+		t.Fatalf("expected two audit events, got %v", data["total"])
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/audit?target_type=users", superToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ = body["data"].(map[string]interface{})
+	if data["total"] != float64(1) {
+		t.Fatalf("expected the status change to be audited by the middleware, got %v", data["total"])
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/audit?action=user.delete", superToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ = body["data"].(map[string]interface{})
+	events, _ := data["events"].([]interface{})
+	if len(events) != 1 {
+		t.Fatalf("expected one user.delete event, got %v", data["events"])
+	}
+	event, _ := events[0].(map[string]interface{})
+	if event["actor_name"] != "admin" || event["target_id"] != userID {
+		t.Fatalf("unexpected audit event: %v", event)
+	}
+	changes, _ := event["changes"].(map[string]interface{})
+	if _, ok := changes["phone"]; !ok {
+		t.Fatalf("expected the deleted user's fields in the diff, got %v", changes)
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/audit?from=yesterday", superToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+}
@@ -0,0 +1,100 @@
+package routes_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUserTokenNeverAuthenticatesAsWebAdmin(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	user := testutil.CreateUser(t, db, "09120000001")
+	admin, adminToken := testutil.CreateWebAdmin(t, db, "root", models.AdminRoleSuperAdmin)
+	if admin.ID != user.ID {
+		t.Fatalf("test needs colliding IDs, got user %d and admin %d", user.ID, admin.ID)
+	}
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/users", testutil.Token(t, user), nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/users", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+	testutil.CreateUser(t, db, "09120000001")
+
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", gin.H{"phone": "09120000001", "password": "secret123"})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ := body["data"].(map[string]interface{})
+	firstRefresh, _ := data["refresh_token"].(string)
+	if firstRefresh == "" {
+		t.Fatalf("login returned no refresh token: %v", data)
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/refresh", "", gin.H{"refresh_token": firstRefresh})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	token, _ := body["token"].(string)
+	secondRefresh, _ := body["refresh_token"].(string)
+	if secondRefresh == "" || secondRefresh == firstRefresh {
+		t.Fatalf("refresh token was not rotated: %v", body)
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	// Replaying the rotated-out token revokes the whole session
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/refresh", "", gin.H{"refresh_token": firstRefresh})
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/refresh", "", gin.H{"refresh_token": secondRefresh})
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestLogoutAndRevocation(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	user := testutil.CreateUser(t, db, "09120000001")
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+
+	phone, laptop := testutil.Token(t, user), testutil.Token(t, user)
+
+	// Logout ends only the current session
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/logout", phone, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", phone, nil)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", laptop, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	// Logging out all devices ends the rest
+	tablet := testutil.Token(t, user)
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/logout-all", laptop, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", tablet, nil)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+
+	// Deactivation by an admin revokes the user's sessions
+	token := testutil.Token(t, user)
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/users/"+strconv.Itoa(int(user.ID))+"/status", adminToken, gin.H{"is_active": false})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+
+	sessions, err := models.GetActiveAuthSessions(db, "user", user.ID)
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no active sessions, got %d (%v)", len(sessions), err)
+	}
+}
@@ -0,0 +1,209 @@
+package routes_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func createVisitorProjectChat(t *testing.T, db *gorm.DB, visitor *models.Visitor, supplier *models.Supplier) *models.Conversation {
+	t.Helper()
+	project := models.VisitorProject{
+		VisitorID:       visitor.ID,
+		UserID:          visitor.UserID,
+		ProjectTitle:    "Saffron",
+		ProductName:     "Saffron",
+		Quantity:        "10",
+		Unit:            "kg",
+		TargetCountries: "AE",
+		Currency:        "USD",
+		ExpiresAt:       time.Now().Add(24 * time.Hour),
+		Status:          "active",
+	}
+	if err := db.Create(&project).Error; err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	chat, err := models.GetOrCreateVisitorProjectConversation(db, project.ID, visitor.ID, supplier.ID)
+	if err != nil {
+		t.Fatalf("create chat: %v", err)
+	}
+	return chat
+}
+
+func expectChatEvent(t *testing.T, events <-chan services.ChatEvent, eventType string) services.ChatEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Type != eventType {
+			t.Fatalf("event type = %q, want %q (%+v)", event.Type, eventType, event)
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("no %s event received", eventType)
+	}
+	return services.ChatEvent{}
+}
+
+func TestVisitorProjectChatRealtimeEvents(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	visitorUser := testutil.CreateUser(t, db, "09121111111")
+	supplierUser := testutil.CreateUser(t, db, "09122222222")
+	outsider := testutil.CreateUser(t, db, "09123333333")
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "زعفران")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+	chat := createVisitorProjectChat(t, db, visitor, supplier)
+	base := "/api/v1/visitor-projects/chats/" + strconv.FormatUint(uint64(chat.ID), 10)
+
+	supplierEvents, closeSupplier := services.GetChatHub().Subscribe(supplierUser.ID)
+	defer closeSupplier()
+	visitorEvents, closeVisitor := services.GetChatHub().Subscribe(visitorUser.ID)
+	defer closeVisitor()
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodGet, base+"/messages", testutil.Token(t, outsider), nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, base+"/send", testutil.Token(t, outsider), gin.H{"message": "hi"})
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, base+"/typing", testutil.Token(t, visitorUser), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	typing := expectChatEvent(t, supplierEvents, services.ChatEventTyping)
+	if typing.Chat != services.ChatKindVisitorProject || typing.ChatID != chat.ID || typing.ConversationID != chat.ID {
+		t.Fatalf("typing event = %+v", typing)
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, base+"/send", testutil.Token(t, visitorUser), gin.H{"message": "سلام"})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	event := expectChatEvent(t, supplierEvents, services.ChatEventMessage)
+	message, ok := event.Data.(*models.ConversationMessage)
+	if !ok || message.Message != "سلام" || message.SenderType != "visitor" {
+		t.Fatalf("message event data = %#v", event.Data)
+	}
+
+	// Reading the chat sends the sender a read receipt, once
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, base+"/messages", testutil.Token(t, supplierUser), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	read := expectChatEvent(t, visitorEvents, services.ChatEventRead)
+	if data := read.Data.(gin.H); data["count"] != int64(1) || data["reader_id"] != supplierUser.ID {
+		t.Fatalf("read event data = %v", data)
+	}
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, base+"/read", testutil.Token(t, supplierUser), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if body["marked"] != float64(0) {
+		t.Fatalf("second read marked %v messages", body["marked"])
+	}
+	select {
+	case extra := <-visitorEvents:
+		t.Fatalf("unexpected event %+v", extra)
+	default:
+	}
+}
+
+func TestChatStreamAcceptsSingleUseTicket(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	server := httptest.NewServer(newTestRouter(t))
+	defer server.Close()
+
+	user := testutil.CreateUser(t, db, "09124444444")
+
+	resp, err := http.Get(server.URL + "/api/v1/chat/stream")
+	if err != nil {
+		t.Fatalf("stream without token: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("stream without token status = %d", resp.StatusCode)
+	}
+
+	// The access token itself is not accepted in the URL
+	resp, err = http.Get(server.URL + "/api/v1/chat/stream?token=" + testutil.Token(t, user))
+	if err != nil {
+		t.Fatalf("stream with token in URL: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("stream with token in URL status = %d", resp.StatusCode)
+	}
+
+	ticketReq, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/chat/stream/ticket", nil)
+	ticketReq.Header.Set("Authorization", "Bearer "+testutil.Token(t, user))
+	resp, err = http.DefaultClient.Do(ticketReq)
+	if err != nil {
+		t.Fatalf("issue ticket: %v", err)
+	}
+	var issued struct {
+		Ticket string `json:"ticket"`
+	}
+	json.NewDecoder(resp.Body).Decode(&issued)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || issued.Ticket == "" {
+		t.Fatalf("issue ticket status = %d, ticket = %q", resp.StatusCode, issued.Ticket)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/chat/stream?ticket="+issued.Ticket, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("stream status = %d, content type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	waitFor := func(prefix string) string {
+		t.Helper()
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), prefix) {
+				return lines.Text()
+			}
+		}
+		t.Fatalf("stream ended before %q: %v", prefix, lines.Err())
+		return ""
+	}
+	waitFor("event:ready")
+
+	// A ticket opens one stream only
+	reused, err := http.Get(server.URL + "/api/v1/chat/stream?ticket=" + issued.Ticket)
+	if err != nil {
+		t.Fatalf("reuse ticket: %v", err)
+	}
+	reused.Body.Close()
+	if reused.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("reused ticket status = %d", reused.StatusCode)
+	}
+
+	if !services.GetChatHub().IsOnline(user.ID) {
+		t.Fatal("user with an open stream is not online")
+	}
+	services.GetChatHub().Publish(user.ID, services.ChatEvent{Type: services.ChatEventTyping, Chat: services.ChatKindMatching, ChatID: 5})
+	waitFor("event:typing")
+	if data := waitFor("data:"); !strings.Contains(data, `"chat":"matching"`) || !strings.Contains(data, `"chat_id":5`) {
+		t.Fatalf("typing event data = %s", data)
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for services.GetChatHub().IsOnline(user.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("stream was not released after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,131 @@
+package routes_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDirectConversationFlow(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	alice := testutil.CreateUser(t, db, "09125550001")
+	bob := testutil.CreateUser(t, db, "09125550002")
+	outsider := testutil.CreateUser(t, db, "09125550003")
+	aliceToken, bobToken := testutil.Token(t, alice), testutil.Token(t, bob)
+	// Keep Bob online so messages go over the stream instead of web push
+	_, closeBob := services.GetChatHub().Subscribe(bob.ID)
+	defer closeBob()
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/conversations/direct", aliceToken, gin.H{"user_id": alice.ID})
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/conversations/direct", aliceToken, gin.H{"user_id": bob.ID})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	conversation, _ := body["conversation"].(map[string]interface{})
+	base := "/api/v1/conversations/" + jsonID(t, conversation["id"])
+
+	rec, body = testutil.DoJSON(t, router, http.MethodPost, base+"/messages", aliceToken, gin.H{
+		"message":     "قرارداد",
+		"attachments": []gin.H{{"url": "/uploads/chat/contract.pdf", "file_name": "contract.pdf"}},
+	})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	sent, _ := body["data"].(map[string]interface{})
+	messagePath := base + "/messages/" + jsonID(t, sent["id"])
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, base+"/messages", testutil.Token(t, outsider), nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/conversations?context_type=direct", bobToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	list, _ := body["conversations"].([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["unread_count"] != float64(1) {
+		t.Fatalf("bob's conversations = %v", body["conversations"])
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, messagePath, bobToken, gin.H{"message": "x"})
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	rec, body = testutil.DoJSON(t, router, http.MethodPut, messagePath, aliceToken, gin.H{"message": "قرارداد نهایی"})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if data, _ := body["data"].(map[string]interface{}); data["message"] != "قرارداد نهایی" || data["edited_at"] == nil {
+		t.Fatalf("edited message = %v", body["data"])
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, base+"/messages", bobToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	messages, _ := body["messages"].([]interface{})
+	if len(messages) != 1 {
+		t.Fatalf("messages = %v", body["messages"])
+	}
+	attachments, _ := messages[0].(map[string]interface{})["attachments"].([]interface{})
+	if len(attachments) != 1 || attachments[0].(map[string]interface{})["mime_type"] != "application/pdf" {
+		t.Fatalf("attachments = %v", attachments)
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodDelete, messagePath, aliceToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, base+"/messages", aliceToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	deleted := body["messages"].([]interface{})[0].(map[string]interface{})
+	if deleted["removed_at"] == nil || deleted["message"] != "" || deleted["is_read"] != true {
+		t.Fatalf("deleted message = %v", deleted)
+	}
+}
+
+func TestMatchingChatUsesConversation(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	supplierUser := testutil.CreateUser(t, db, "09125550011")
+	visitorUser := testutil.CreateUser(t, db, "09125550012")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "زعفران")
+	_, closeVisitor := services.GetChatHub().Subscribe(visitorUser.ID)
+	defer closeVisitor()
+	request := models.MatchingRequest{
+		SupplierID: supplier.ID, UserID: supplierUser.ID, ProductName: "زعفران", Quantity: "10", Unit: "kg",
+		DestinationCountries: "AE", Price: "1000", Currency: "USD", ExpiresAt: time.Now().Add(time.Hour),
+		Status: "accepted", AcceptedVisitorID: &visitor.ID,
+	}
+	if err := db.Create(&request).Error; err != nil {
+		t.Fatal(err)
+	}
+	chatPath := "/api/v1/matching/chat/" + strconv.FormatUint(uint64(request.ID), 10)
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, chatPath+"/send", testutil.Token(t, supplierUser), gin.H{"image_url": "/uploads/chat/p.jpg"})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/matching/chat/conversations", testutil.Token(t, visitorUser), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	list, _ := body["conversations"].([]interface{})
+	if len(list) != 1 {
+		t.Fatalf("conversations = %v", body["conversations"])
+	}
+	summary := list[0].(map[string]interface{})
+	if summary["matching_request_id"] != float64(request.ID) || summary["unread_count"] != float64(1) ||
+		summary["supplier_name"] != supplier.FullName || summary["last_message"] != "📷 تصویر" {
+		t.Fatalf("conversation summary = %v", summary)
+	}
+
+	// The same chat is reachable through the conversation API
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/conversations/"+jsonID(t, summary["id"])+"/messages", testutil.Token(t, visitorUser), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	messages, _ := body["messages"].([]interface{})
+	if len(messages) != 1 || messages[0].(map[string]interface{})["image_url"] != "/uploads/chat/p.jpg" {
+		t.Fatalf("messages = %v", body["messages"])
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, chatPath+"/messages", testutil.Token(t, supplierUser), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if msg := body["messages"].([]interface{})[0].(map[string]interface{}); msg["is_read"] != true || msg["sender_type"] != "supplier" {
+		t.Fatalf("supplier sees %v", msg)
+	}
+}
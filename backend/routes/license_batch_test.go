@@ -0,0 +1,71 @@
+package routes_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestAdminLicenseBatchLifecycle(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+	// Licenses reference their generator on the users table
+	testutil.CreateUser(t, db, "09120000001")
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+	_, moderatorToken := testutil.CreateWebAdmin(t, db, "moderator", models.AdminRoleModerator)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/admin/licenses/generate", adminToken, map[string]interface{}{
+		"count":            150,
+		"type":             "plus",
+		"channel":          models.LicenseChannelEvent,
+		"batch_name":       "نمایشگاه پاییز",
+		"note":             "booth sales",
+		"unit_price_toman": 2500000,
+	})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	data := body["data"].(map[string]interface{})
+	if codes := data["licenses"].([]interface{}); len(codes) != 150 {
+		t.Fatalf("generated %d codes, want 150", len(codes))
+	}
+	batch := data["batch"].(map[string]interface{})
+	batchPath := "/api/v1/admin/license-batches/" + jsonID(t, batch["id"])
+
+	// Exports are sent in the response, never left under /uploads
+	firstCode := data["licenses"].([]interface{})[0].(string)
+	exportPath := "/api/v1/admin/export/licenses?batch_id=" + jsonID(t, batch["id"])
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, exportPath+"&format=csv", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") || !strings.Contains(rec.Body.String(), firstCode) {
+		t.Fatalf("csv export: disposition %q, body has code: %v", rec.Header().Get("Content-Disposition"), strings.Contains(rec.Body.String(), firstCode))
+	}
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, exportPath, adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), ".xlsx") || rec.Body.Len() == 0 {
+		t.Fatalf("xlsx export: disposition %q, %d bytes", rec.Header().Get("Content-Disposition"), rec.Body.Len())
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, batchPath+"/revoke", moderatorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, body = testutil.DoJSON(t, router, http.MethodPost, batchPath+"/revoke", adminToken, map[string]string{"reason": "lost stock"})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if revoked := body["data"].(map[string]interface{})["revoked"].(float64); revoked != 150 {
+		t.Fatalf("revoked %v codes, want 150", revoked)
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, batchPath, adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	stats := body["data"].(map[string]interface{})["stats"].(map[string]interface{})
+	if stats["revoked"].(float64) != 150 || stats["available"].(float64) != 0 {
+		t.Fatalf("stats = %v, want all 150 revoked", stats)
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/license-batches?channel=event", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if total := body["data"].(map[string]interface{})["total"].(float64); total != 1 {
+		t.Fatalf("event batches = %v, want 1", total)
+	}
+}
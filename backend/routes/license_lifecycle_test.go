@@ -0,0 +1,67 @@
+package routes_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+)
+
+func TestLicenseMiddlewareHonoursGracePeriod(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	user := testutil.CreateUser(t, db, "09120000001")
+	license := testutil.GrantLicense(t, db, user.ID, "plus")
+	token := testutil.Token(t, user)
+
+	expired := time.Now().Add(-time.Hour)
+	if err := db.Model(license).Update("expires_at", expired).Error; err != nil {
+		t.Fatalf("expire license: %v", err)
+	}
+	rec, _ := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/ai/usage", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("X-License-Grace-Ends-At") == "" {
+		t.Fatal("grace response has no X-License-Grace-Ends-At header")
+	}
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/license/status", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if body["has_license"] != true || body["in_grace"] != true || body["grace_ends_at"] == nil {
+		t.Fatalf("license status in grace = %v", body)
+	}
+
+	lapsed := time.Now().Add(-models.LicenseGracePeriod() - time.Hour)
+	if err := db.Model(license).Update("expires_at", lapsed).Error; err != nil {
+		t.Fatalf("lapse license: %v", err)
+	}
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/ai/usage", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	_, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/license/status", token, nil)
+	if body["has_license"] != false || body["in_grace"] != false {
+		t.Fatalf("license status after grace = %v", body)
+	}
+}
+
+func TestAdminExpiringLicensesReport(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+
+	soon := testutil.CreateUser(t, db, "09120000001")
+	license := testutil.GrantLicense(t, db, soon.ID, "plus")
+	if err := db.Model(license).Update("expires_at", time.Now().Add(3*24*time.Hour)).Error; err != nil {
+		t.Fatalf("set expiry: %v", err)
+	}
+	later := testutil.CreateUser(t, db, "09120000002")
+	testutil.GrantLicense(t, db, later.ID, "plus")
+
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/admin/licenses/expiring", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ := body["data"].(map[string]interface{})
+	expiring, _ := data["expiring"].([]interface{})
+	if len(expiring) != 1 {
+		t.Fatalf("expiring = %d licenses, want 1", len(expiring))
+	}
+}
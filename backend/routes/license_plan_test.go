@@ -0,0 +1,72 @@
+package routes_test
+
+import (
+	"net/http"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminEditsLicensePlanAndDailyLimitsReportIt(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	user := testutil.CreateUser(t, db, "09120000001")
+	testutil.GrantLicense(t, db, user.ID, "plus4")
+	_, adminToken := testutil.CreateWebAdmin(t, db, "admin", models.AdminRoleAdmin)
+	_, moderatorToken := testutil.CreateWebAdmin(t, db, "moderator", models.AdminRoleModerator)
+
+	plan := gin.H{
+		"daily_visitor_views":           7,
+		"daily_supplier_views":          8,
+		"daily_available_product_views": 9,
+		"daily_ai_messages":             0,
+		"daily_matching_requests":       2,
+		"daily_proposals":               4,
+	}
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/license-plans/plus4", moderatorToken, plan)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/license-plans/gold", adminToken, plan)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, "/api/v1/admin/license-plans/plus4", adminToken, plan)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/daily-limits", testutil.Token(t, user), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if body["license_type"] != "plus4" {
+		t.Fatalf("license_type = %v, want plus4", body["license_type"])
+	}
+	for key, want := range map[string]float64{
+		"visitor_limits":           7,
+		"supplier_limits":          8,
+		"available_product_limits": 9,
+		"ai_message_limits":        0,
+		"matching_request_limits":  2,
+		"proposal_limits":          4,
+	} {
+		limits, _ := body[key].(map[string]interface{})
+		if limits["max"] != want {
+			t.Fatalf("%s max = %v, want %v", key, limits["max"], want)
+		}
+	}
+	ai, _ := body["ai_message_limits"].(map[string]interface{})
+	if ai["remaining"] != float64(-1) {
+		t.Fatalf("unlimited AI remaining = %v, want -1", ai["remaining"])
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodDelete, "/api/v1/admin/license-plans/plus4", adminToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/daily-limits", testutil.Token(t, user), nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	visitor, _ := body["visitor_limits"].(map[string]interface{})
+	if visitor["max"] != float64(models.DefaultLicensePlans["plus4"].DailyVisitorViews) {
+		t.Fatalf("visitor max after reset = %v", visitor["max"])
+	}
+}
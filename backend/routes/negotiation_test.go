@@ -0,0 +1,110 @@
+package routes_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMatchingNegotiationOffers(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	supplierUser := testutil.CreateUser(t, db, "09125550021")
+	visitorUser := testutil.CreateUser(t, db, "09125550022")
+	rivalUser := testutil.CreateUser(t, db, "09125550023")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "زعفران")
+	testutil.CreateVisitor(t, db, rivalUser.ID, "دبی", "زعفران")
+	for _, user := range []*models.User{supplierUser, visitorUser, rivalUser} {
+		_, unsubscribe := services.GetChatHub().Subscribe(user.ID)
+		defer unsubscribe()
+	}
+	supplierToken, visitorToken, rivalToken := testutil.Token(t, supplierUser), testutil.Token(t, visitorUser), testutil.Token(t, rivalUser)
+
+	request := models.MatchingRequest{
+		SupplierID: supplier.ID, UserID: supplierUser.ID, ProductName: "زعفران", Quantity: "10", Unit: "kg",
+		DestinationCountries: "AE", Price: "3000", Currency: "USD", ExpiresAt: time.Now().Add(time.Hour), Status: "active",
+	}
+	if err := db.Create(&request).Error; err != nil {
+		t.Fatal(err)
+	}
+	startPath := "/api/v1/matching/requests/" + strconv.FormatUint(uint64(request.ID), 10) + "/start-chat"
+
+	// The supplier has no conversation until a visitor opens one
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, startPath, supplierToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, startPath, visitorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	offersPath := "/api/v1/conversations/" + jsonID(t, body["chat"].(map[string]interface{})["id"]) + "/offers"
+	rec, body = testutil.DoJSON(t, router, http.MethodPost, startPath, rivalToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rivalOffersPath := "/api/v1/conversations/" + jsonID(t, body["chat"].(map[string]interface{})["id"]) + "/offers"
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, offersPath, supplierToken, gin.H{"price": "3000", "currency": "usd", "incoterm": "XYZ"})
+	testutil.ExpectStatus(t, rec, http.StatusBadRequest)
+
+	rec, body = testutil.DoJSON(t, router, http.MethodPost, offersPath, supplierToken, gin.H{
+		"price": "3000", "currency": "usd", "quantity": "10", "unit": "kg", "incoterm": "fob", "payment_terms": "30% advance",
+	})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	first := body["data"].(map[string]interface{})["offer"].(map[string]interface{})
+	if first["version"] != float64(1) || first["currency"] != "USD" || first["incoterm"] != "FOB" {
+		t.Fatalf("first offer = %v", first)
+	}
+	firstPath := offersPath + "/" + jsonID(t, first["id"])
+	offerMessagePath := "/api/v1/conversations/" + jsonID(t, first["conversation_id"]) + "/messages/" + jsonID(t, body["data"].(map[string]interface{})["id"])
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, offerMessagePath, supplierToken, gin.H{"message": "x"})
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, firstPath+"/accept", supplierToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+
+	rec, body = testutil.DoJSON(t, router, http.MethodPost, firstPath+"/counter", visitorToken, gin.H{"price": "2800"})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	counter := body["data"].(map[string]interface{})["offer"].(map[string]interface{})
+	if counter["version"] != float64(2) || counter["parent_offer_id"] != first["id"] || counter["incoterm"] != "FOB" || counter["payment_terms"] != "30% advance" {
+		t.Fatalf("counter offer = %v", counter)
+	}
+
+	// The visitor's counter-offer has to be answered before a new offer
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, offersPath, supplierToken, gin.H{"price": "2900", "currency": "USD"})
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, rivalOffersPath, rivalToken, gin.H{"price": "3100", "currency": "USD"})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, offersPath+"/"+jsonID(t, counter["id"])+"/accept", supplierToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	var accepted models.MatchingRequest
+	db.First(&accepted, request.ID)
+	if accepted.Status != "accepted" || accepted.AcceptedVisitorID == nil || *accepted.AcceptedVisitorID != visitor.ID ||
+		accepted.AcceptedOfferID == nil || float64(*accepted.AcceptedOfferID) != counter["id"] {
+		t.Fatalf("request after acceptance = %+v", accepted)
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, offersPath, visitorToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	history := body["offers"].([]interface{})
+	if len(history) != 2 || history[0].(map[string]interface{})["status"] != models.OfferStatusCountered ||
+		history[1].(map[string]interface{})["status"] != models.OfferStatusAccepted {
+		t.Fatalf("offer history = %v", history)
+	}
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, rivalOffersPath, rivalToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if rival := body["offers"].([]interface{}); rival[0].(map[string]interface{})["status"] != models.OfferStatusExpired {
+		t.Fatalf("rival offer after acceptance = %v", rival)
+	}
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, rivalOffersPath, rivalToken, gin.H{"price": "2500", "currency": "USD"})
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+}
@@ -0,0 +1,68 @@
+package routes_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+)
+
+func TestPushCampaignClickIgnoresPrefetchAndBots(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+	admin := testutil.CreateUser(t, db, "09120000050")
+	user := testutil.CreateUser(t, db, "09120000051")
+	if _, err := models.CreatePushSubscription(db, user.ID, "https://push.example.com/1", "key", "auth",
+		"Mozilla/5.0", models.PushTransportWebPush, models.PushPlatformWeb); err != nil {
+		t.Fatal(err)
+	}
+	campaign, err := services.NewPushCampaignService(db).CreateAndDispatch(admin.ID, models.CreatePushCampaignRequest{
+		Name:     "click",
+		Variants: []models.PushCampaignVariantRequest{{Title: "t", Message: "m", DeepLink: "/products"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recipient models.PushCampaignRecipient
+	if err := db.Where("campaign_id = ?", campaign.ID).First(&recipient).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	click := func(method string, headers map[string]string) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/v1/public/push/click/"+recipient.Token, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 14) Chrome/126.0")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/products" {
+			t.Fatalf("%s %v: status %d, location %q", method, headers, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	clicks := func() int {
+		t.Helper()
+		var r models.PushCampaignRecipient
+		db.First(&r, recipient.ID)
+		return r.ClickCount
+	}
+
+	click(http.MethodHead, nil)
+	click(http.MethodGet, map[string]string{"Sec-Purpose": "prefetch;prerender"})
+	click(http.MethodGet, map[string]string{"Purpose": "prefetch"})
+	click(http.MethodGet, map[string]string{"X-Moz": "prefetch"})
+	click(http.MethodGet, map[string]string{"User-Agent": "TelegramBot (like TwitterBot)"})
+	click(http.MethodGet, map[string]string{"User-Agent": "WhatsApp/2.23.20.0"})
+	if n := clicks(); n != 0 {
+		t.Fatalf("automated requests counted as %d clicks", n)
+	}
+
+	click(http.MethodGet, nil)
+	if n := clicks(); n != 1 {
+		t.Fatalf("user click counted as %d clicks", n)
+	}
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"net/http"
 
+	"asl-market-backend/config"
 	"asl-market-backend/controllers"
 	"asl-market-backend/middleware"
 	"asl-market-backend/models"
@@ -24,16 +25,20 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 	matchingController := controllers.NewMatchingController(models.GetDB())
 	pushController := controllers.NewPushController(models.GetDB())
 	visitorProjectController := controllers.NewVisitorProjectController(models.GetDB())
+	conversationController := controllers.NewConversationController(models.GetDB())
 	adminMatchingController := controllers.NewAdminMatchingController(models.GetDB())
 	profileController := controllers.NewProfileController(models.GetDB())
 	popupTrackingController := controllers.NewPopupTrackingController(models.GetDB())
+	orderController := controllers.NewOrderController(models.GetDB())
 
 	// Initialize OpenAI monitor
 	openaiMonitor := services.NewOpenAIMonitor(telegramService)
 	openaiMonitorController := controllers.NewOpenAIMonitorController(openaiMonitor)
 
-	// Start OpenAI monitoring in background
-	go openaiMonitor.StartMonitoring()
+	// Start OpenAI monitoring in background (only when an API key is configured)
+	if config.AppConfig != nil && config.AppConfig.OpenAI.APIKey != "" {
+		go openaiMonitor.StartMonitoring()
+	}
 
 	// Serve uploaded files
 	router.Static("/uploads", "./uploads")
@@ -60,17 +65,23 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		auth.POST("/affiliate/login", authController.AffiliateLogin) // Affiliate panel login
 		auth.POST("/forgot-password", authController.RequestPasswordRecovery)
 		auth.POST("/reset-password", authController.ResetPassword)
+		auth.POST("/send-verification-code", authController.SendPhoneVerificationCode)
+		auth.POST("/verify-phone", authController.VerifyPhone)
+		auth.POST("/refresh", authController.RefreshToken) // Rotates the refresh token (users, admins and affiliates)
 	}
 
 	// Affiliate panel routes (affiliate JWT required)
 	affiliate := v1.Group("/affiliate")
 	affiliate.Use(middleware.AffiliateAuthMiddleware())
 	{
+		affiliate.POST("/logout", authController.Logout)
+		affiliate.POST("/logout-all", authController.LogoutAll)
 		affiliate.GET("/dashboard", affiliateController.GetDashboard)
 		affiliate.GET("/users", affiliateController.GetUsers)
 		affiliate.GET("/payments", affiliateController.GetPayments)
 		affiliate.POST("/withdrawal-request", affiliateController.CreateWithdrawalRequest)
 		affiliate.GET("/withdrawal-requests", affiliateController.GetWithdrawalRequests)
+		affiliate.GET("/ledger", affiliateController.GetLedger)
 		affiliate.GET("/registered-users", affiliateController.GetRegisteredUsers)
 		affiliate.GET("/buyers", affiliateController.GetBuyers)
 	}
@@ -82,6 +93,9 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		public.POST("/visitor/register", publicRegistrationController.RegisterPublicVisitor)
 		public.GET("/registration-status", publicRegistrationController.GetRegistrationStatus)
 		public.POST("/affiliate/register", publicRegistrationController.RegisterAffiliate)
+		// Tracked link of campaign pushes; counts the click and redirects
+		public.GET("/push/click/:token", pushController.TrackPushCampaignClick)
+		public.HEAD("/push/click/:token", pushController.TrackPushCampaignClick)
 	}
 
 	// Public routes with optional authentication
@@ -100,12 +114,19 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		publicOptional.GET("/profile/:id", profileController.GetUserProfile)
 	}
 
+	// Real-time chat stream (SSE); EventSource cannot send headers, so the
+	// stream may also be opened with a ticket from POST /chat/stream/ticket
+	v1.GET("/chat/stream", middleware.StreamTicketAuth(), middleware.AuthMiddleware(), controllers.StreamChatEvents)
+
 	// Protected routes (authentication required)
 	protected := v1.Group("/")
 	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuditAdminActions())
 	{
 		// User routes
 		protected.GET("/me", authController.Me)
+		protected.POST("/auth/logout", authController.Logout)
+		protected.POST("/auth/logout-all", authController.LogoutAll)
 		protected.PUT("/profile", authController.UpdateProfile)
 		protected.PUT("/profile/update", profileController.UpdateProfile)
 		protected.POST("/profile/upload-profile-image", profileController.UploadProfileImage)
@@ -123,15 +144,16 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.GET("/license/status", controllers.CheckLicenseStatus)
 		protected.GET("/license/info", controllers.GetUserLicenseInfo)
 		protected.POST("/license/refresh", controllers.RefreshLicense)
+		protected.GET("/license/history", controllers.GetLicenseHistory)
 
 		// Upgrade request routes
 		protected.POST("/upgrade/request", upgradeController.CreateUpgradeRequest)
 		protected.GET("/upgrade/requests", upgradeController.GetUserUpgradeRequests)
 
 		// Admin upgrade management routes
-		protected.GET("/admin/upgrade/requests", upgradeController.GetPendingUpgradeRequests)
-		protected.POST("/admin/upgrade/requests/:id/approve", upgradeController.ApproveUpgradeRequest)
-		protected.POST("/admin/upgrade/requests/:id/reject", upgradeController.RejectUpgradeRequest)
+		protected.GET("/admin/upgrade/requests", middleware.RequirePermission(models.PermissionUpgradesManage), upgradeController.GetPendingUpgradeRequests)
+		protected.POST("/admin/upgrade/requests/:id/approve", middleware.RequirePermission(models.PermissionUpgradesManage), upgradeController.ApproveUpgradeRequest)
+		protected.POST("/admin/upgrade/requests/:id/reject", middleware.RequirePermission(models.PermissionUpgradesManage), upgradeController.RejectUpgradeRequest)
 
 		// Daily limits routes
 		protected.GET("/daily-limits", controllers.GetDailyLimitsStatus)
@@ -167,15 +189,15 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.GET("/suppliers/matching-capacity", controllers.GetSuppliersMatchingCapacity)
 
 		// Admin supplier management routes
-		protected.POST("/admin/suppliers", controllers.CreateSupplierForAdmin)
-		protected.GET("/admin/suppliers", controllers.GetSuppliersForAdmin)
-		protected.GET("/admin/suppliers/:id", controllers.GetSupplierForAdmin)
-		protected.PUT("/admin/suppliers/:id", controllers.UpdateSupplierForAdmin)
-		protected.DELETE("/admin/suppliers/:id", controllers.DeleteSupplierForAdmin)
-		protected.POST("/admin/suppliers/:id/approve", controllers.ApproveSupplier)
-		protected.POST("/admin/suppliers/:id/reject", controllers.RejectSupplier)
-		protected.POST("/admin/suppliers/:id/feature", controllers.FeatureSupplier)
-		protected.POST("/admin/suppliers/:id/unfeature", controllers.UnfeatureSupplier)
+		protected.POST("/admin/suppliers", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.CreateSupplierForAdmin)
+		protected.GET("/admin/suppliers", middleware.RequirePermission(models.PermissionSuppliersView), controllers.GetSuppliersForAdmin)
+		protected.GET("/admin/suppliers/:id", middleware.RequirePermission(models.PermissionSuppliersView), controllers.GetSupplierForAdmin)
+		protected.PUT("/admin/suppliers/:id", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.UpdateSupplierForAdmin)
+		protected.DELETE("/admin/suppliers/:id", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.DeleteSupplierForAdmin)
+		protected.POST("/admin/suppliers/:id/approve", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.ApproveSupplier)
+		protected.POST("/admin/suppliers/:id/reject", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.RejectSupplier)
+		protected.POST("/admin/suppliers/:id/feature", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.FeatureSupplier)
+		protected.POST("/admin/suppliers/:id/unfeature", middleware.RequirePermission(models.PermissionSuppliersManage), controllers.UnfeatureSupplier)
 
 		// Visitor routes
 		protected.POST("/visitor/register", controllers.RegisterVisitor)
@@ -189,16 +211,16 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.POST("/submit-product", controllers.SubmitProduct)
 
 		// Admin visitor management routes
-		protected.POST("/admin/visitors", controllers.CreateVisitorForAdmin)
-		protected.GET("/admin/visitors", controllers.GetVisitorsForAdmin)
-		protected.GET("/admin/visitors/:id", controllers.GetVisitorDetails)
-		protected.POST("/admin/visitors/:id/approve", controllers.ApproveVisitorByAdmin)
-		protected.POST("/admin/visitors/:id/reject", controllers.RejectVisitorByAdmin)
-		protected.PUT("/admin/visitors/:id/status", controllers.UpdateVisitorStatus)
-		protected.PUT("/admin/visitors/:id", controllers.UpdateVisitorByAdmin)
-		protected.DELETE("/admin/visitors/:id", controllers.DeleteVisitorByAdmin)
-		protected.POST("/admin/visitors/:id/feature", controllers.FeatureVisitor)
-		protected.POST("/admin/visitors/:id/unfeature", controllers.UnfeatureVisitor)
+		protected.POST("/admin/visitors", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.CreateVisitorForAdmin)
+		protected.GET("/admin/visitors", middleware.RequirePermission(models.PermissionVisitorsView), controllers.GetVisitorsForAdmin)
+		protected.GET("/admin/visitors/:id", middleware.RequirePermission(models.PermissionVisitorsView), controllers.GetVisitorDetails)
+		protected.POST("/admin/visitors/:id/approve", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.ApproveVisitorByAdmin)
+		protected.POST("/admin/visitors/:id/reject", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.RejectVisitorByAdmin)
+		protected.PUT("/admin/visitors/:id/status", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.UpdateVisitorStatus)
+		protected.PUT("/admin/visitors/:id", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.UpdateVisitorByAdmin)
+		protected.DELETE("/admin/visitors/:id", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.DeleteVisitorByAdmin)
+		protected.POST("/admin/visitors/:id/feature", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.FeatureVisitor)
+		protected.POST("/admin/visitors/:id/unfeature", middleware.RequirePermission(models.PermissionVisitorsManage), controllers.UnfeatureVisitor)
 
 		// Research products routes (public access)
 		protected.GET("/research-products", controllers.GetResearchProducts)
@@ -223,11 +245,11 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.GET("/training/video/:id/stream", controllers.StreamVideo)
 
 		// Admin research products management routes
-		protected.POST("/admin/research-products", controllers.CreateResearchProduct)
-		protected.PUT("/admin/research-products/:id", controllers.UpdateResearchProduct)
-		protected.DELETE("/admin/research-products/:id", controllers.DeleteResearchProduct)
-		protected.PATCH("/admin/research-products/:id/status", controllers.UpdateResearchProductStatus)
-		protected.POST("/admin/research-products/import", controllers.ImportResearchProductsFromExcel)
+		protected.POST("/admin/research-products", middleware.RequirePermission(models.PermissionProductsManage), controllers.CreateResearchProduct)
+		protected.PUT("/admin/research-products/:id", middleware.RequirePermission(models.PermissionProductsManage), controllers.UpdateResearchProduct)
+		protected.DELETE("/admin/research-products/:id", middleware.RequirePermission(models.PermissionProductsManage), controllers.DeleteResearchProduct)
+		protected.PATCH("/admin/research-products/:id/status", middleware.RequirePermission(models.PermissionProductsManage), controllers.UpdateResearchProductStatus)
+		protected.POST("/admin/research-products/import", middleware.RequirePermission(models.PermissionProductsManage), controllers.ImportResearchProductsFromExcel)
 
 		// Marketing popup routes (public access for active popup)
 		protected.GET("/marketing-popups/active", controllers.GetActiveMarketingPopup)
@@ -236,31 +258,34 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.GET("/marketing-popups/:id", controllers.GetMarketingPopup)
 
 		// Admin marketing popup management routes
-		protected.GET("/admin/marketing-popups", controllers.GetMarketingPopups)
-		protected.POST("/admin/marketing-popups", controllers.CreateMarketingPopup)
-		protected.PUT("/admin/marketing-popups/:id", controllers.UpdateMarketingPopup)
-		protected.DELETE("/admin/marketing-popups/:id", controllers.DeleteMarketingPopup)
+		protected.GET("/admin/marketing-popups", middleware.RequirePermission(models.PermissionContentManage), controllers.GetMarketingPopups)
+		protected.POST("/admin/marketing-popups", middleware.RequirePermission(models.PermissionContentManage), controllers.CreateMarketingPopup)
+		protected.PUT("/admin/marketing-popups/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.UpdateMarketingPopup)
+		protected.DELETE("/admin/marketing-popups/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.DeleteMarketingPopup)
 
 		// Slider routes (GET /sliders/active is public via publicOptional; track requires auth)
 		protected.POST("/sliders/:id/click", controllers.TrackSliderClick)
 		protected.POST("/sliders/:id/view", controllers.TrackSliderView)
 
 		// Admin slider management routes
-		protected.GET("/admin/sliders", controllers.GetSliders)
-		protected.GET("/admin/sliders/:id", controllers.GetSlider)
-		protected.POST("/admin/sliders", controllers.CreateSlider)
-		protected.PUT("/admin/sliders/:id", controllers.UpdateSlider)
-		protected.DELETE("/admin/sliders/:id", controllers.DeleteSlider)
-		protected.POST("/admin/sliders/upload", controllers.UploadSliderImage)
+		protected.GET("/admin/sliders", middleware.RequirePermission(models.PermissionContentManage), controllers.GetSliders)
+		protected.GET("/admin/sliders/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.GetSlider)
+		protected.POST("/admin/sliders", middleware.RequirePermission(models.PermissionContentManage), controllers.CreateSlider)
+		protected.PUT("/admin/sliders/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.UpdateSlider)
+		protected.DELETE("/admin/sliders/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.DeleteSlider)
+		protected.POST("/admin/sliders/upload", middleware.RequirePermission(models.PermissionContentManage), controllers.UploadSliderImage)
 
 		// Admin withdrawal management routes
-		protected.POST("/admin/withdrawal/requests", withdrawalController.CreateWithdrawalRequestAdmin)
-		protected.GET("/admin/withdrawal/requests", withdrawalController.GetAllWithdrawalRequests)
-		protected.GET("/admin/withdrawal/request/:id", withdrawalController.GetWithdrawalRequestAdmin)
-		protected.PUT("/admin/withdrawal/request/:id", withdrawalController.UpdateWithdrawalRequestAdmin)
-		protected.DELETE("/admin/withdrawal/request/:id", withdrawalController.DeleteWithdrawalRequestAdmin)
-		protected.PUT("/admin/withdrawal/request/:id/status", withdrawalController.UpdateWithdrawalStatus)
-		protected.GET("/admin/withdrawal/stats", withdrawalController.GetAllWithdrawalStats)
+		protected.POST("/admin/withdrawal/requests", middleware.RequirePermission(models.PermissionWithdrawalsManage), withdrawalController.CreateWithdrawalRequestAdmin)
+		protected.GET("/admin/withdrawal/requests", middleware.RequirePermission(models.PermissionWithdrawalsView), withdrawalController.GetAllWithdrawalRequests)
+		protected.GET("/admin/withdrawal/request/:id", middleware.RequirePermission(models.PermissionWithdrawalsView), withdrawalController.GetWithdrawalRequestAdmin)
+		protected.PUT("/admin/withdrawal/request/:id", middleware.RequirePermission(models.PermissionWithdrawalsManage), withdrawalController.UpdateWithdrawalRequestAdmin)
+		protected.DELETE("/admin/withdrawal/request/:id", middleware.RequirePermission(models.PermissionWithdrawalsManage), withdrawalController.DeleteWithdrawalRequestAdmin)
+		protected.PUT("/admin/withdrawal/request/:id/status", middleware.RequirePermission(models.PermissionWithdrawalsApprove), withdrawalController.UpdateWithdrawalStatus)
+		protected.GET("/admin/withdrawal/stats", middleware.RequirePermission(models.PermissionWithdrawalsView), withdrawalController.GetAllWithdrawalStats)
+		protected.GET("/admin/exchange-rates", middleware.RequirePermission(models.PermissionWithdrawalsView), withdrawalController.GetExchangeRates)
+		protected.POST("/admin/exchange-rates", middleware.RequirePermission(models.PermissionWithdrawalsManage), withdrawalController.SetExchangeRate)
+		protected.DELETE("/admin/exchange-rates/:id", middleware.RequirePermission(models.PermissionWithdrawalsManage), withdrawalController.DeleteExchangeRate)
 
 		// Global search route
 		protected.GET("/search", controllers.GlobalSearch)
@@ -273,103 +298,135 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.GET("/available-products/:id", controllers.GetAvailableProduct)
 
 		// Admin available products management routes
-		protected.POST("/admin/available-products", controllers.CreateAvailableProduct)
-		protected.PUT("/admin/available-products/:id", controllers.UpdateAvailableProduct)
-		protected.DELETE("/admin/available-products/:id", controllers.DeleteAvailableProduct)
-		protected.PUT("/admin/available-products/:id/status", controllers.UpdateAvailableProductStatus)
+		protected.POST("/admin/available-products", middleware.RequirePermission(models.PermissionProductsManage), controllers.CreateAvailableProduct)
+		protected.PUT("/admin/available-products/:id", middleware.RequirePermission(models.PermissionProductsManage), controllers.UpdateAvailableProduct)
+		protected.DELETE("/admin/available-products/:id", middleware.RequirePermission(models.PermissionProductsManage), controllers.DeleteAvailableProduct)
+		protected.PUT("/admin/available-products/:id/status", middleware.RequirePermission(models.PermissionProductsManage), controllers.UpdateAvailableProductStatus)
 
 		// Admin training videos management routes
-		protected.GET("/admin/training/videos", controllers.GetAllVideosForAdmin)
-		protected.POST("/admin/training/videos", controllers.CreateTrainingVideo)
-		protected.PUT("/admin/training/videos/:id", controllers.UpdateTrainingVideo)
-		protected.DELETE("/admin/training/videos/:id", controllers.DeleteTrainingVideo)
+		protected.GET("/admin/training/videos", middleware.RequirePermission(models.PermissionContentManage), controllers.GetAllVideosForAdmin)
+		protected.POST("/admin/training/videos", middleware.RequirePermission(models.PermissionContentManage), controllers.CreateTrainingVideo)
+		protected.PUT("/admin/training/videos/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.UpdateTrainingVideo)
+		protected.DELETE("/admin/training/videos/:id", middleware.RequirePermission(models.PermissionContentManage), controllers.DeleteTrainingVideo)
 
 		// Admin notification management routes
-		protected.GET("/admin/notifications", controllers.GetAllNotificationsForAdmin)
-		protected.POST("/admin/notifications", controllers.CreateNotification)
-		protected.PUT("/admin/notifications/:id", controllers.UpdateNotification)
-		protected.DELETE("/admin/notifications/:id", controllers.DeleteNotification)
-		protected.GET("/admin/notifications/stats", controllers.GetNotificationStats)
-		protected.POST("/admin/training/categories", controllers.CreateTrainingCategory)
+		protected.GET("/admin/notifications", middleware.RequirePermission(models.PermissionNotificationsView), controllers.GetAllNotificationsForAdmin)
+		protected.POST("/admin/notifications", middleware.RequirePermission(models.PermissionNotificationsSend), controllers.CreateNotification)
+		protected.PUT("/admin/notifications/:id", middleware.RequirePermission(models.PermissionNotificationsSend), controllers.UpdateNotification)
+		protected.DELETE("/admin/notifications/:id", middleware.RequirePermission(models.PermissionNotificationsSend), controllers.DeleteNotification)
+		protected.GET("/admin/notifications/stats", middleware.RequirePermission(models.PermissionNotificationsView), controllers.GetNotificationStats)
+		protected.POST("/admin/notifications/audience/preview", middleware.RequirePermission(models.PermissionNotificationsSend), controllers.PreviewNotificationAudience)
+		protected.POST("/admin/notifications/audience/phones", middleware.RequirePermission(models.PermissionNotificationsSend), controllers.ParseNotificationAudiencePhones)
+		protected.GET("/admin/notifications/deliveries", middleware.RequirePermission(models.PermissionNotificationsView), controllers.GetNotificationDeliveries)
+		protected.POST("/admin/notifications/deliveries/:id/retry", middleware.RequirePermission(models.PermissionNotificationsSend), controllers.RetryNotificationDelivery)
+		protected.GET("/admin/push/metrics", middleware.RequirePermission(models.PermissionNotificationsView), pushController.GetPushMetrics)
+		protected.GET("/admin/push/campaigns", middleware.RequirePermission(models.PermissionNotificationsView), pushController.GetPushCampaigns)
+		protected.POST("/admin/push/campaigns", middleware.RequirePermission(models.PermissionNotificationsSend), pushController.CreatePushCampaign)
+		protected.POST("/admin/push/campaigns/audience/preview", middleware.RequirePermission(models.PermissionNotificationsSend), pushController.PreviewPushCampaignAudience)
+		protected.GET("/admin/push/campaigns/:id", middleware.RequirePermission(models.PermissionNotificationsView), pushController.GetPushCampaign)
+		protected.POST("/admin/push/campaigns/:id/test", middleware.RequirePermission(models.PermissionNotificationsSend), pushController.SendTestPushCampaign)
+		protected.POST("/admin/push/campaigns/:id/cancel", middleware.RequirePermission(models.PermissionNotificationsSend), pushController.CancelPushCampaign)
+		protected.POST("/admin/training/categories", middleware.RequirePermission(models.PermissionContentManage), controllers.CreateTrainingCategory)
 
 		// Admin Panel Web API routes (comprehensive admin endpoints)
-		protected.GET("/admin/dashboard/stats", controllers.GetAdminDashboardStats)
+		protected.GET("/admin/dashboard/stats", middleware.RequirePermission(models.PermissionDashboardView), controllers.GetAdminDashboardStats)
 
 		// User Management (Admin)
-		protected.GET("/admin/users", controllers.GetUsersForAdmin)
-		protected.GET("/admin/users/:id", controllers.GetUserDetailsForAdmin)
-		protected.POST("/admin/users", controllers.CreateUser)
-		protected.PUT("/admin/users/:id", controllers.UpdateUser)
-		protected.PUT("/admin/users/:id/status", controllers.UpdateUserStatus)
-		protected.DELETE("/admin/users/:id", controllers.DeleteUser)
-		protected.POST("/admin/import/users", controllers.ImportUsersFromExcel)
+		protected.GET("/admin/users", middleware.RequirePermission(models.PermissionUsersView), controllers.GetUsersForAdmin)
+		protected.GET("/admin/users/:id", middleware.RequirePermission(models.PermissionUsersView), controllers.GetUserDetailsForAdmin)
+		protected.POST("/admin/users", middleware.RequirePermission(models.PermissionUsersManage), controllers.CreateUser)
+		protected.PUT("/admin/users/:id", middleware.RequirePermission(models.PermissionUsersManage), controllers.UpdateUser)
+		protected.PUT("/admin/users/:id/status", middleware.RequirePermission(models.PermissionUsersManage), controllers.UpdateUserStatus)
+		protected.DELETE("/admin/users/:id", middleware.RequirePermission(models.PermissionUsersDelete), controllers.DeleteUser)
+		protected.POST("/admin/import/users", middleware.RequirePermission(models.PermissionUsersManage), controllers.ImportUsersFromExcel)
 
 		// License Management (Admin)
-		protected.GET("/admin/licenses", controllers.GetLicensesForAdmin)
-		protected.POST("/admin/licenses/generate", controllers.GenerateLicensesForAdmin)
+		protected.GET("/admin/licenses", middleware.RequirePermission(models.PermissionLicensesView), controllers.GetLicensesForAdmin)
+		protected.GET("/admin/licenses/expiring", middleware.RequirePermission(models.PermissionLicensesView), controllers.GetExpiringLicensesForAdmin)
+		protected.POST("/admin/licenses/generate", middleware.RequirePermission(models.PermissionLicensesGenerate), controllers.GenerateLicensesForAdmin)
+		protected.GET("/admin/license-batches", middleware.RequirePermission(models.PermissionLicensesView), controllers.GetLicenseBatchesForAdmin)
+		protected.GET("/admin/license-batches/:id", middleware.RequirePermission(models.PermissionLicensesView), controllers.GetLicenseBatchForAdmin)
+		protected.POST("/admin/license-batches/:id/revoke", middleware.RequirePermission(models.PermissionLicensesRevoke), controllers.RevokeLicenseBatchForAdmin)
 
 		// Support Ticket Management (Admin)
-		protected.GET("/admin/support/tickets", controllers.GetAllTicketsForAdmin)
-		protected.GET("/admin/support/tickets/:id", controllers.GetTicketDetailsForAdmin)
-		protected.PUT("/admin/support/tickets/:id", controllers.UpdateTicketForAdmin)
-		protected.PUT("/admin/support/tickets/:id/status", controllers.UpdateTicketStatusForAdmin)
-		protected.POST("/admin/support/tickets/:id/messages", controllers.AddAdminMessageToTicket)
-		protected.DELETE("/admin/support/tickets/:id", controllers.DeleteTicketForAdmin)
+		protected.GET("/admin/support/tickets", middleware.RequirePermission(models.PermissionSupportManage), controllers.GetAllTicketsForAdmin)
+		protected.GET("/admin/support/tickets/:id", middleware.RequirePermission(models.PermissionSupportManage), controllers.GetTicketDetailsForAdmin)
+		protected.PUT("/admin/support/tickets/:id", middleware.RequirePermission(models.PermissionSupportManage), controllers.UpdateTicketForAdmin)
+		protected.PUT("/admin/support/tickets/:id/status", middleware.RequirePermission(models.PermissionSupportManage), controllers.UpdateTicketStatusForAdmin)
+		protected.POST("/admin/support/tickets/:id/messages", middleware.RequirePermission(models.PermissionSupportManage), controllers.AddAdminMessageToTicket)
+		protected.DELETE("/admin/support/tickets/:id", middleware.RequirePermission(models.PermissionSupportManage), controllers.DeleteTicketForAdmin)
 
 		// Telegram Admin Management (Admin)
-		protected.GET("/admin/telegram-admins", controllers.GetTelegramAdminsForAdmin)
-		protected.POST("/admin/telegram-admins", controllers.AddTelegramAdmin)
-		protected.DELETE("/admin/telegram-admins/:telegram_id", controllers.RemoveTelegramAdmin)
+		protected.GET("/admin/telegram-admins", middleware.RequirePermission(models.PermissionTelegramAdminsManage), controllers.GetTelegramAdminsForAdmin)
+		protected.POST("/admin/telegram-admins", middleware.RequirePermission(models.PermissionTelegramAdminsManage), controllers.AddTelegramAdmin)
+		protected.DELETE("/admin/telegram-admins/:telegram_id", middleware.RequirePermission(models.PermissionTelegramAdminsManage), controllers.RemoveTelegramAdmin)
 
 		// Web Admin Management (Admin Panel Admins)
-		protected.GET("/admin/web-admins", controllers.GetWebAdmins)
-		protected.GET("/admin/web-admins/:id", controllers.GetWebAdmin)
-		protected.POST("/admin/web-admins", controllers.CreateWebAdmin)
-		protected.PUT("/admin/web-admins/:id", controllers.UpdateWebAdmin)
-		protected.DELETE("/admin/web-admins/:id", controllers.DeleteWebAdmin)
+		protected.GET("/admin/web-admins", middleware.RequirePermission(models.PermissionAdminsManage), controllers.GetWebAdmins)
+		protected.GET("/admin/web-admins/:id", middleware.RequirePermission(models.PermissionAdminsManage), controllers.GetWebAdmin)
+		protected.POST("/admin/web-admins", middleware.RequirePermission(models.PermissionAdminsManage), controllers.CreateWebAdmin)
+		protected.PUT("/admin/web-admins/:id", middleware.RequirePermission(models.PermissionAdminsManage), controllers.UpdateWebAdmin)
+		protected.DELETE("/admin/web-admins/:id", middleware.RequirePermission(models.PermissionAdminsManage), controllers.DeleteWebAdmin)
+
+		// Role permissions (editable by super admins only)
+		protected.GET("/admin/permissions", middleware.RequirePermission(), controllers.GetAdminPermissions)
+		protected.PUT("/admin/permissions/roles/:role", middleware.RequireSuperAdmin(), controllers.UpdateRolePermissions)
+		protected.DELETE("/admin/permissions/roles/:role", middleware.RequireSuperAdmin(), controllers.ResetRolePermissions)
+
+		// License plans (daily quotas per license type)
+		protected.GET("/admin/license-plans", middleware.RequirePermission(models.PermissionLicensePlansManage), controllers.GetLicensePlans)
+		protected.PUT("/admin/license-plans/:type", middleware.RequirePermission(models.PermissionLicensePlansManage), controllers.UpdateLicensePlan)
+		protected.DELETE("/admin/license-plans/:type", middleware.RequirePermission(models.PermissionLicensePlansManage), controllers.ResetLicensePlan)
 
 		// Affiliate management (admin panel)
-		protected.GET("/admin/affiliates", controllers.GetAffiliates)
-		protected.GET("/admin/affiliates/:id", controllers.GetAffiliate)
-		protected.POST("/admin/affiliates", controllers.CreateAffiliate)
-		protected.PUT("/admin/affiliates/:id", controllers.UpdateAffiliate)
-		protected.DELETE("/admin/affiliates/:id", controllers.DeleteAffiliate)
-		protected.GET("/admin/affiliates/:id/registered-users", controllers.GetAffiliateRegisteredUsers)
-		protected.POST("/admin/affiliates/:id/registered-users/import", controllers.ImportAffiliateRegisteredUsers)
-		protected.POST("/admin/affiliates/:id/sales-match", controllers.MatchAffiliateSales)
-		protected.POST("/admin/affiliates/:id/buyers/confirm", controllers.ConfirmAffiliateBuyers)
-		protected.GET("/admin/affiliates/:id/buyers", controllers.GetAffiliateBuyers)
-		protected.GET("/admin/affiliates/:id/withdrawal-requests", controllers.GetAffiliateWithdrawalRequests)
-		protected.PUT("/admin/affiliates/:id/withdrawal-requests/:reqId/status", controllers.UpdateAffiliateWithdrawalStatus)
-		protected.GET("/admin/affiliates/settings", controllers.GetAffiliateSettings)
-		protected.PUT("/admin/affiliates/settings", controllers.UpdateAffiliateSettings)
+		protected.GET("/admin/affiliates", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliates)
+		protected.GET("/admin/affiliates/:id", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliate)
+		protected.POST("/admin/affiliates", middleware.RequirePermission(models.PermissionAffiliatesManage), controllers.CreateAffiliate)
+		protected.PUT("/admin/affiliates/:id", middleware.RequirePermission(models.PermissionAffiliatesManage), controllers.UpdateAffiliate)
+		protected.DELETE("/admin/affiliates/:id", middleware.RequirePermission(models.PermissionAffiliatesManage), controllers.DeleteAffiliate)
+		protected.GET("/admin/affiliates/:id/registered-users", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliateRegisteredUsers)
+		protected.POST("/admin/affiliates/:id/registered-users/import", middleware.RequirePermission(models.PermissionAffiliatesManage), controllers.ImportAffiliateRegisteredUsers)
+		protected.POST("/admin/affiliates/:id/sales-match", middleware.RequirePermission(models.PermissionAffiliatesManage), controllers.MatchAffiliateSales)
+		protected.POST("/admin/affiliates/:id/buyers/confirm", middleware.RequirePermission(models.PermissionAffiliatesPayout), controllers.ConfirmAffiliateBuyers)
+		protected.GET("/admin/affiliates/:id/buyers", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliateBuyers)
+		protected.GET("/admin/affiliates/:id/ledger", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliateLedger)
+		protected.GET("/admin/affiliates/:id/withdrawal-requests", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliateWithdrawalRequests)
+		protected.PUT("/admin/affiliates/:id/withdrawal-requests/:reqId/status", middleware.RequirePermission(models.PermissionAffiliatesPayout), controllers.UpdateAffiliateWithdrawalStatus)
+		protected.GET("/admin/affiliates/settings", middleware.RequirePermission(models.PermissionAffiliatesView), controllers.GetAffiliateSettings)
+		protected.PUT("/admin/affiliates/settings", middleware.RequirePermission(models.PermissionAffiliatesManage), controllers.UpdateAffiliateSettings)
 
 		// Excel Export (Admin)
-		protected.GET("/admin/export/users", controllers.ExportUsersToExcel)
-		protected.GET("/admin/export/suppliers", controllers.ExportSuppliersToExcel)
-		protected.GET("/admin/export/visitors", controllers.ExportVisitorsToExcel)
-		protected.GET("/admin/export/licenses", controllers.ExportLicensesToExcel)
+		protected.GET("/admin/export/users", middleware.RequirePermission(models.PermissionExportsDownload), controllers.ExportUsersToExcel)
+		protected.GET("/admin/export/suppliers", middleware.RequirePermission(models.PermissionExportsDownload), controllers.ExportSuppliersToExcel)
+		protected.GET("/admin/export/visitors", middleware.RequirePermission(models.PermissionExportsDownload), controllers.ExportVisitorsToExcel)
+		protected.GET("/admin/export/licenses", middleware.RequirePermission(models.PermissionExportsDownload), controllers.ExportLicensesToExcel)
+
+		// Admin audit log
+		protected.GET("/admin/audit", middleware.RequirePermission(models.PermissionAuditView), controllers.GetAuditEvents)
+		protected.GET("/admin/audit/export", middleware.RequirePermission(models.PermissionAuditView), controllers.ExportAuditEventsToExcel)
 
 		// Admin Matching Management (Admin)
-		protected.GET("/admin/matching/requests", adminMatchingController.GetAllMatchingRequests)
-		protected.GET("/admin/matching/requests/stats", adminMatchingController.GetMatchingRequestStats)
-		protected.PUT("/admin/matching/requests/:id", adminMatchingController.UpdateMatchingRequestAdmin)
-		protected.DELETE("/admin/matching/requests/:id", adminMatchingController.DeleteMatchingRequestAdmin)
-		protected.GET("/admin/matching/chats", adminMatchingController.GetAllMatchingChats)
-		protected.GET("/admin/matching/chats/:id/messages", adminMatchingController.GetMatchingChatMessages)
+		protected.GET("/admin/matching/requests", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetAllMatchingRequests)
+		protected.GET("/admin/matching/requests/stats", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetMatchingRequestStats)
+		protected.PUT("/admin/matching/requests/:id", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.UpdateMatchingRequestAdmin)
+		protected.DELETE("/admin/matching/requests/:id", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.DeleteMatchingRequestAdmin)
+		protected.GET("/admin/matching/chats", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetAllMatchingChats)
+		protected.GET("/admin/matching/chats/:id/messages", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetMatchingChatMessages)
 
 		// Admin Visitor Projects Management (Admin)
-		protected.GET("/admin/visitor-projects", adminMatchingController.GetAllVisitorProjects)
-		protected.GET("/admin/visitor-projects/stats", adminMatchingController.GetVisitorProjectStats)
-		protected.PUT("/admin/visitor-projects/:id", adminMatchingController.UpdateVisitorProjectAdmin)
-		protected.DELETE("/admin/visitor-projects/:id", adminMatchingController.DeleteVisitorProjectAdmin)
-		protected.GET("/admin/visitor-projects/chats", adminMatchingController.GetAllVisitorProjectChats)
-		protected.GET("/admin/visitor-projects/chats/:id/messages", adminMatchingController.GetVisitorProjectChatMessages)
+		protected.GET("/admin/visitor-projects", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetAllVisitorProjects)
+		protected.GET("/admin/visitor-projects/stats", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetVisitorProjectStats)
+		protected.PUT("/admin/visitor-projects/:id", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.UpdateVisitorProjectAdmin)
+		protected.DELETE("/admin/visitor-projects/:id", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.DeleteVisitorProjectAdmin)
+		protected.GET("/admin/visitor-projects/chats", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetAllVisitorProjectChats)
+		protected.GET("/admin/visitor-projects/chats/:id/messages", middleware.RequirePermission(models.PermissionMatchingManage), adminMatchingController.GetVisitorProjectChatMessages)
+		protected.GET("/admin/conversations", middleware.RequirePermission(models.PermissionMatchingManage), conversationController.GetConversationsForAdmin)
+		protected.GET("/admin/conversations/:id/messages", middleware.RequirePermission(models.PermissionMatchingManage), conversationController.GetConversationMessagesForAdmin)
 
 		// OpenAI Monitor routes (Admin only)
-		protected.GET("/admin/openai/usage", openaiMonitorController.GetUsageStats)
-		protected.POST("/admin/openai/check", openaiMonitorController.CheckUsage)
-		protected.POST("/admin/openai/test-alert", openaiMonitorController.SendTestAlert)
+		protected.GET("/admin/openai/usage", middleware.RequirePermission(models.PermissionSystemMonitor), openaiMonitorController.GetUsageStats)
+		protected.POST("/admin/openai/check", middleware.RequirePermission(models.PermissionSystemMonitor), openaiMonitorController.CheckUsage)
+		protected.POST("/admin/openai/test-alert", middleware.RequirePermission(models.PermissionSystemMonitor), openaiMonitorController.SendTestAlert)
 
 		// SpotPlayer routes
 		protected.POST("/spotplayer/generate-license", spotPlayerController.GenerateSpotPlayerLicense)
@@ -386,8 +443,11 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.GET("/notifications", controllers.GetUserNotifications)
 		protected.GET("/notifications/:id", controllers.GetNotification)
 		protected.POST("/notifications/:id/read", controllers.MarkNotificationAsRead)
+		protected.POST("/notifications/:id/dismiss", controllers.DismissNotification)
 		protected.POST("/notifications/read-all", controllers.MarkAllNotificationsAsRead)
 		protected.GET("/notifications/unread-count", controllers.GetUnreadNotificationCount)
+		protected.GET("/notifications/preferences", controllers.GetNotificationPreferences)
+		protected.PUT("/notifications/preferences", controllers.UpdateNotificationPreferences)
 
 		// Popup tracking routes
 		protected.GET("/popup/status", popupTrackingController.GetPopupStatus)
@@ -418,10 +478,16 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.POST("/matching/requests/:id/rating", matchingController.CreateMatchingRating)
 		protected.GET("/matching/ratings/user", matchingController.GetMatchingRatingsByUser)
 
+		// Chat stream tickets (see /chat/stream)
+		protected.POST("/chat/stream/ticket", controllers.IssueChatStreamTicket)
+
 		// Matching chat routes
 		protected.GET("/matching/chat/conversations", matchingController.GetMatchingChatConversations)
+		protected.POST("/matching/requests/:id/start-chat", matchingController.StartMatchingChat)
 		protected.GET("/matching/chat/:id/messages", matchingController.GetMatchingChatMessages)
 		protected.POST("/matching/chat/:id/send", matchingController.SendMatchingChatMessage)
+		protected.POST("/matching/chat/:id/read", matchingController.MarkMatchingChatRead)
+		protected.POST("/matching/chat/:id/typing", matchingController.SendMatchingChatTyping)
 
 		// Visitor Project routes (Two-way matching: Visitors create projects, Suppliers propose)
 		// Visitor creates and manages their visitor projects
@@ -437,23 +503,43 @@ func SetupRoutes(router *gin.Engine, telegramService *services.TelegramService)
 		protected.POST("/visitor-projects/:id/proposal", visitorProjectController.SubmitProposal)
 		protected.GET("/visitor-projects/supplier-capacity", visitorProjectController.GetSupplierCapacityForVisitorProjects)
 
+		// Conversation routes (matching, visitor project and direct chats)
+		protected.GET("/conversations", conversationController.GetConversations)
+		protected.POST("/conversations/direct", conversationController.StartDirectConversation)
+		protected.GET("/conversations/:id/messages", conversationController.GetConversationMessages)
+		protected.POST("/conversations/:id/messages", conversationController.SendConversationMessage)
+		protected.PUT("/conversations/:id/messages/:messageId", conversationController.EditConversationMessage)
+		protected.DELETE("/conversations/:id/messages/:messageId", conversationController.DeleteConversationMessage)
+		protected.POST("/conversations/:id/read", conversationController.MarkConversationRead)
+		protected.POST("/conversations/:id/typing", conversationController.SendConversationTyping)
+		protected.GET("/conversations/:id/offers", conversationController.GetNegotiationOffers)
+		protected.POST("/conversations/:id/offers", conversationController.CreateNegotiationOffer)
+		protected.POST("/conversations/:id/offers/:offerId/accept", conversationController.AcceptNegotiationOffer)
+		protected.POST("/conversations/:id/offers/:offerId/reject", conversationController.RejectNegotiationOffer)
+		protected.POST("/conversations/:id/offers/:offerId/counter", conversationController.CounterNegotiationOffer)
+
 		// Visitor Project Chat routes
 		protected.GET("/visitor-projects/chats", visitorProjectController.GetVisitorProjectChats)
 		protected.POST("/visitor-projects/:id/start-chat", visitorProjectController.StartVisitorProjectChat)
 		protected.GET("/visitor-projects/chats/:id/messages", visitorProjectController.GetVisitorProjectChatMessages)
 		protected.POST("/visitor-projects/chats/:id/send", visitorProjectController.SendVisitorProjectChatMessage)
+		protected.POST("/visitor-projects/chats/:id/read", visitorProjectController.MarkVisitorProjectChatRead)
+		protected.POST("/visitor-projects/chats/:id/typing", visitorProjectController.SendVisitorProjectChatTyping)
 
 		// License-protected routes
 		licensed := protected.Group("/")
 		licensed.Use(middleware.LicenseMiddleware())
 		{
 			// User-specific data
-			licensed.GET("/my-orders", getMyOrders)
+			licensed.GET("/my-orders", orderController.GetMyOrders)
 			licensed.GET("/my-products", controllers.GetUserAvailableProducts)
 			licensed.GET("/my-products/:id", controllers.GetUserAvailableProduct)
 			licensed.PUT("/my-products/:id", controllers.UpdateUserAvailableProduct)
 			licensed.DELETE("/my-products/:id", controllers.DeleteUserAvailableProduct)
-			licensed.POST("/orders", createOrder)
+			licensed.POST("/orders", orderController.CreateOrder)
+			licensed.GET("/orders/:id", orderController.GetOrder)
+			licensed.PUT("/orders/:id/status", orderController.UpdateOrderStatus)
+			licensed.GET("/supplier/orders", orderController.GetSellerOrders)
 			// Profile update is now handled in protected routes above
 
 			// AI Chat routes
@@ -505,7 +591,7 @@ func getDashboard(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
 	// Get withdrawal statistics
-	withdrawalStats, err := models.GetWithdrawalStats(models.GetDB(), &userID)
+	withdrawalStats, err := models.GetWithdrawalStats(models.GetDB(), &userID, c.Query("base_currency"))
 	if err != nil {
 		withdrawalStats = map[string]interface{}{
 			"total":        0,
@@ -524,7 +610,7 @@ func getDashboard(c *gin.Context) {
 	}
 
 	// Get withdrawal history for chart data
-	chartData, err := models.GetWithdrawalChartData(models.GetDB(), userID)
+	chartData, err := models.GetWithdrawalChartData(models.GetDB(), userID, c.Query("base_currency"))
 	if err != nil {
 		chartData = []map[string]interface{}{}
 	}
@@ -565,23 +651,6 @@ func getDashboard(c *gin.Context) {
 	})
 }
 
-func getMyOrders(c *gin.Context) {
-	userID := c.GetUint("user_id")
-	c.JSON(http.StatusOK, gin.H{
-		"message": "User orders",
-		"user_id": userID,
-		"orders":  []gin.H{},
-	})
-}
-
-func createOrder(c *gin.Context) {
-	userID := c.GetUint("user_id")
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Order created successfully",
-		"user_id": userID,
-	})
-}
-
 func updateProfile(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,164 @@
+package routes_test
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+	"asl-market-backend/routes"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	routes.SetupRoutes(router, nil)
+	return router
+}
+
+func TestRegisterLoginAndMe(t *testing.T) {
+	testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/register", "", gin.H{
+		"first_name": "Ali",
+		"last_name":  "Rezaei",
+		"phone":      "09121234567",
+		"password":   "secret123",
+	})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", gin.H{
+		"phone":    "09121234567",
+		"password": "wrong-password",
+	})
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", gin.H{
+		"phone":    "09121234567",
+		"password": "secret123",
+	})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	data, _ := body["data"].(map[string]interface{})
+	token, _ := data["token"].(string)
+	if token == "" {
+		t.Fatalf("login response has no token: %v", body)
+	}
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/me", "", nil)
+	testutil.ExpectStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestRegisterKeepsPhoneVerificationWhenInsertFails(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+	config.AppConfig.OTP.RequirePhoneVerification = true
+	t.Cleanup(func() { config.AppConfig.OTP.RequirePhoneVerification = false })
+
+	phone := services.NormalizeOTPPhone("09121234568")
+	code, _, err := models.IssueOTPCode(db, phone, models.OTPPurposePhoneVerification, models.OTPOptions{TTL: time.Minute, MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.MarkPhoneVerified(db, phone, code); err != nil {
+		t.Fatal(err)
+	}
+
+	failInsert := true
+	err = db.Callback().Create().Before("gorm:create").Register("test:fail_user_insert", func(tx *gorm.DB) {
+		if failInsert && tx.Statement.Table == "users" {
+			tx.AddError(errors.New("insert failed"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	register := gin.H{"first_name": "Ali", "last_name": "Rezaei", "phone": "09121234568", "password": "secret123"}
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/register", "", register)
+	testutil.ExpectStatus(t, rec, http.StatusInternalServerError)
+
+	// The verification survived the failed insert
+	failInsert = false
+	rec, _ = testutil.DoJSON(t, router, http.MethodPost, "/api/v1/auth/register", "", register)
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+}
+
+func TestLicensedRoutesRequireLicense(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	token := testutil.Token(t, user)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodGet, "/api/v1/my-orders", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusForbidden)
+	if _, ok := body["license_status"]; !ok {
+		t.Fatalf("forbidden response missing license_status: %v", body)
+	}
+
+	testutil.GrantLicense(t, db, user.ID, "plus")
+	rec, _ = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/my-orders", token, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+}
+
+func TestOrderFlow(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	router := newTestRouter(t)
+
+	seller := testutil.CreateUser(t, db, "09120000001")
+	buyer := testutil.CreateUser(t, db, "09120000002")
+	testutil.GrantLicense(t, db, seller.ID, "pro")
+	testutil.GrantLicense(t, db, buyer.ID, "plus")
+	sellerToken := testutil.Token(t, seller)
+	buyerToken := testutil.Token(t, buyer)
+	product := testutil.CreateAvailableProduct(t, db, seller.ID, 3)
+
+	rec, _ := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/orders", buyerToken, gin.H{
+		"available_product_id": product.ID,
+		"quantity":             5,
+		"shipping_address":     "Dubai",
+	})
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+
+	rec, body := testutil.DoJSON(t, router, http.MethodPost, "/api/v1/orders", buyerToken, gin.H{
+		"available_product_id": product.ID,
+		"quantity":             2,
+		"shipping_address":     "Dubai",
+	})
+	testutil.ExpectStatus(t, rec, http.StatusCreated)
+	order, _ := body["order"].(map[string]interface{})
+	orderPath := "/api/v1/orders/" + jsonID(t, order["id"])
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, orderPath+"/status", buyerToken, gin.H{"status": "confirmed"})
+	testutil.ExpectStatus(t, rec, http.StatusConflict)
+
+	rec, _ = testutil.DoJSON(t, router, http.MethodPut, orderPath+"/status", sellerToken, gin.H{"status": "confirmed"})
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+
+	rec, body = testutil.DoJSON(t, router, http.MethodGet, "/api/v1/supplier/orders", sellerToken, nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if orders, _ := body["orders"].([]interface{}); len(orders) != 1 {
+		t.Fatalf("seller orders = %v, want 1", body["orders"])
+	}
+}
+
+func jsonID(t *testing.T, v interface{}) string {
+	t.Helper()
+	id, ok := v.(float64)
+	if !ok {
+		t.Fatalf("unexpected id %v", v)
+	}
+	return strconv.Itoa(int(id))
+}
@@ -29,7 +29,7 @@ func main77777() {
 	fmt.Printf("👤 Testing with user: %s %s (ID: %d)\n", user.FirstName, user.LastName, user.ID)
 
 	// Get withdrawal stats
-	stats, err := models.GetWithdrawalStats(db, &user.ID)
+	stats, err := models.GetWithdrawalStats(db, &user.ID, models.CurrencyIRR)
 	if err != nil {
 		log.Printf("Error getting stats: %v", err)
 	} else {
@@ -37,7 +37,7 @@ func main77777() {
 	}
 
 	// Get chart data
-	chartData, err := models.GetWithdrawalChartData(db, user.ID)
+	chartData, err := models.GetWithdrawalChartData(db, user.ID, models.CurrencyIRR)
 	if err != nil {
 		log.Printf("Error getting chart data: %v", err)
 	} else {
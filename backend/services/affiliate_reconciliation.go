@@ -0,0 +1,219 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"asl-market-backend/models"
+	"asl-market-backend/utils"
+)
+
+// Reconciliation thresholds
+const (
+	// A row with the same phone is matched once its name is this similar
+	ReconcileNameMatch = 0.85
+	// Rows scoring at least this are offered to the admin as probable matches
+	ReconcileProbableMin = 0.5
+)
+
+// SalesRow is one buyer from a sales list
+type SalesRow struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+// ReconcileMatch pairs a sales row with a registered user
+type ReconcileMatch struct {
+	Buyer      SalesRow                       `json:"buyer"`
+	Registered models.AffiliateRegisteredUser `json:"registered"`
+	Confidence float64                        `json:"confidence"`  // 0–1
+	PhoneMatch string                         `json:"phone_match"` // exact or typo
+	NameScore  float64                        `json:"name_score"`
+}
+
+// ReconcileResult splits a sales list into matched, probable and unmatched rows
+type ReconcileResult struct {
+	Matched   []ReconcileMatch `json:"matched"`
+	Probable  []ReconcileMatch `json:"probable"`
+	Unmatched []SalesRow       `json:"unmatched"`
+}
+
+type reconcileCandidate struct {
+	user  models.AffiliateRegisteredUser
+	phone string
+	name  string
+}
+
+// ReconcileAffiliateSales matches sales rows against an affiliate's
+// registered users. Phones are compared in 98XXXXXXXXXX form and names after
+// Persian normalization. A row whose phone matches and whose name is similar
+// enough is matched; a row whose phone matches with a different name, or
+// whose phone is one digit off, is a probable match to review.
+func ReconcileAffiliateSales(registered []models.AffiliateRegisteredUser, rows []SalesRow) ReconcileResult {
+	byPhone := make(map[string][]*reconcileCandidate)
+	// A single mistyped digit leaves either the first or the last half intact
+	byHalf := make(map[string][]*reconcileCandidate)
+	for _, u := range registered {
+		c := &reconcileCandidate{user: u, phone: utils.NormalizeIranianPhone(u.Phone), name: utils.NormalizePersianText(u.Name)}
+		if c.phone == "" {
+			continue
+		}
+		byPhone[c.phone] = append(byPhone[c.phone], c)
+		for _, key := range phoneHalves(c.phone) {
+			byHalf[key] = append(byHalf[key], c)
+		}
+	}
+
+	result := ReconcileResult{Matched: []ReconcileMatch{}, Probable: []ReconcileMatch{}, Unmatched: []SalesRow{}}
+	for _, row := range rows {
+		row.Name = strings.TrimSpace(row.Name)
+		row.Phone = strings.TrimSpace(row.Phone)
+		if row.Name == "" && row.Phone == "" {
+			continue
+		}
+		phone := utils.NormalizeIranianPhone(row.Phone)
+		name := utils.NormalizePersianText(row.Name)
+
+		var best *ReconcileMatch
+		consider := func(c *reconcileCandidate, phoneMatch string, phoneScore float64) {
+			nameScore := NameSimilarity(name, c.name)
+			m := ReconcileMatch{
+				Buyer:      row,
+				Registered: c.user,
+				Confidence: roundScore(0.6*phoneScore + 0.4*nameScore),
+				PhoneMatch: phoneMatch,
+				NameScore:  roundScore(nameScore),
+			}
+			if best == nil || m.Confidence > best.Confidence {
+				best = &m
+			}
+		}
+
+		for _, c := range byPhone[phone] {
+			consider(c, "exact", 1)
+		}
+		if best == nil && phone != "" {
+			seen := make(map[*reconcileCandidate]bool)
+			for _, key := range phoneHalves(phone) {
+				for _, c := range byHalf[key] {
+					if !seen[c] && hammingDistance(phone, c.phone) == 1 {
+						seen[c] = true
+						consider(c, "typo", 0.5)
+					}
+				}
+			}
+		}
+
+		switch {
+		case best != nil && best.PhoneMatch == "exact" && best.NameScore >= ReconcileNameMatch:
+			result.Matched = append(result.Matched, *best)
+		case best != nil && best.Confidence >= ReconcileProbableMin:
+			result.Probable = append(result.Probable, *best)
+		default:
+			result.Unmatched = append(result.Unmatched, row)
+		}
+	}
+
+	// Most doubtful first so admins review them before the near-certain ones
+	sort.SliceStable(result.Probable, func(i, j int) bool {
+		return result.Probable[i].Confidence < result.Probable[j].Confidence
+	})
+	return result
+}
+
+// phoneHalves returns lookup keys for the first and last halves of a phone
+func phoneHalves(phone string) []string {
+	half := len(phone) / 2
+	return []string{"<" + phone[:half], ">" + phone[half:]}
+}
+
+func hammingDistance(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) + len(b)
+	}
+	d := 0
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			d++
+		}
+	}
+	return d
+}
+
+// NameSimilarity scores two normalized names from 0 to 1. Word order is
+// ignored and a name that is a subset of the other (a missing surname)
+// still scores partially.
+func NameSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	ta, tb := strings.Fields(a), strings.Fields(b)
+
+	// Whole names, with words sorted so "رضا محمدی" equals "محمدی رضا"
+	sortedA, sortedB := append([]string(nil), ta...), append([]string(nil), tb...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	whole := stringSimilarity(strings.Join(sortedA, ""), strings.Join(sortedB, ""))
+
+	// Word by word: each word of the shorter name against its best partner
+	short, long := ta, tb
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	var sum float64
+	for _, w := range short {
+		bestWord := 0.0
+		for _, v := range long {
+			if s := stringSimilarity(w, v); s > bestWord {
+				bestWord = s
+			}
+		}
+		sum += bestWord
+	}
+	words := sum / float64(len(long))
+
+	if whole > words {
+		return whole
+	}
+	return words
+}
+
+// stringSimilarity is 1 minus the edit distance relative to the longer string
+func stringSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func roundScore(v float64) float64 {
+	return float64(int(v*100+0.5)) / 100
+}
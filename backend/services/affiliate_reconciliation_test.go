@@ -0,0 +1,71 @@
+package services_test
+
+import (
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+)
+
+func TestReconcileAffiliateSales(t *testing.T) {
+	registered := []models.AffiliateRegisteredUser{
+		{ID: 1, Name: "علي كريمي", Phone: "09121234567"},
+		{ID: 2, Name: "Sara Ahmadi", Phone: "09351112233"},
+		{ID: 3, Name: "مریم رضایی", Phone: "09187654321"},
+		{ID: 4, Name: "حسین نوری", Phone: "09390001122"},
+	}
+	rows := []services.SalesRow{
+		{Name: "علی  کریمی", Phone: "+98 912 123 4567"}, // Arabic letters, +98, spaces
+		{Name: "sara ahmadi", Phone: "۰۹۳۵۱۱۱۲۲۳۳"},     // Persian digits
+		{Name: "مریم", Phone: "989187654321"},           // same phone, surname missing
+		{Name: "حسین نوری", Phone: "09390001121"},       // one digit off
+		{Name: "ناشناس", Phone: "09000000000"},
+	}
+
+	result := services.ReconcileAffiliateSales(registered, rows)
+
+	if len(result.Matched) != 2 {
+		t.Fatalf("matched = %+v, want the first two rows", result.Matched)
+	}
+	for _, m := range result.Matched {
+		if m.Registered.ID != 1 && m.Registered.ID != 2 {
+			t.Errorf("matched %q to registered user %d", m.Buyer.Name, m.Registered.ID)
+		}
+	}
+
+	if len(result.Probable) != 2 {
+		t.Fatalf("probable = %+v, want the partial name and the phone typo", result.Probable)
+	}
+	for _, m := range result.Probable {
+		if m.Confidence < services.ReconcileProbableMin || m.Confidence >= 1 {
+			t.Errorf("probable %q has confidence %v", m.Buyer.Name, m.Confidence)
+		}
+		switch m.Registered.ID {
+		case 3:
+			if m.PhoneMatch != "exact" {
+				t.Errorf("partial-name row phone match = %q, want exact", m.PhoneMatch)
+			}
+		case 4:
+			if m.PhoneMatch != "typo" {
+				t.Errorf("typo row phone match = %q, want typo", m.PhoneMatch)
+			}
+		default:
+			t.Errorf("probable %q paired with registered user %d", m.Buyer.Name, m.Registered.ID)
+		}
+	}
+	if result.Probable[0].Confidence > result.Probable[1].Confidence {
+		t.Error("probable matches are not sorted most doubtful first")
+	}
+
+	if len(result.Unmatched) != 1 || result.Unmatched[0].Name != "ناشناس" {
+		t.Fatalf("unmatched = %+v, want only the unknown buyer", result.Unmatched)
+	}
+}
+
+func TestValidateIranianPhoneNumberFormats(t *testing.T) {
+	for _, phone := range []string{"09121234567", "9121234567", "+989121234567", "00989121234567", "۰۹۱۲-۱۲۳-۴۵۶۷"} {
+		if got := services.ValidateIranianPhoneNumber(phone); got != "989121234567" {
+			t.Errorf("ValidateIranianPhoneNumber(%q) = %q, want 989121234567", phone, got)
+		}
+	}
+}
@@ -44,14 +44,23 @@ func (s *AIUsageService) GetTodayUsage(userID uint) (*models.AIUsage, error) {
 	return &usage, nil
 }
 
-// CanSendMessage checks if user can send a message (hasn't reached daily limit)
+// CanSendMessage checks if user can send a message (hasn't reached the daily
+// limit of their license plan)
 func (s *AIUsageService) CanSendMessage(userID uint) (bool, int, error) {
 	usage, err := s.GetTodayUsage(userID)
 	if err != nil {
 		return false, 0, err
 	}
+	plan, err := models.GetUserLicensePlan(s.db, userID)
+	if err != nil {
+		return false, 0, err
+	}
 
-	remaining := models.DailyAIMessageLimit - usage.MessageCount
+	// An unlimited plan (0) reports -1 remaining
+	if plan.DailyAIMessages <= 0 {
+		return true, -1, nil
+	}
+	remaining := plan.DailyAIMessages - usage.MessageCount
 	if remaining <= 0 {
 		return false, 0, nil
 	}
@@ -91,16 +100,23 @@ func (s *AIUsageService) GetUsageInfo(userID uint) (*models.AIUsageResponse, err
 	if err != nil {
 		return nil, err
 	}
+	plan, err := models.GetUserLicensePlan(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	remaining := models.DailyAIMessageLimit - usage.MessageCount
-	if remaining < 0 {
-		remaining = 0
+	remaining := -1
+	if plan.DailyAIMessages > 0 {
+		remaining = plan.DailyAIMessages - usage.MessageCount
+		if remaining < 0 {
+			remaining = 0
+		}
 	}
 
 	return &models.AIUsageResponse{
 		Date:           usage.Date.Format("2006-01-02"),
 		MessageCount:   usage.MessageCount,
 		RemainingCount: remaining,
-		DailyLimit:     models.DailyAIMessageLimit,
+		DailyLimit:     plan.DailyAIMessages,
 	}, nil
 }
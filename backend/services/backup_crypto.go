@@ -0,0 +1,181 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backups start with backupMagic and a random scrypt salt, followed
+// by AES-256-GCM sealed chunks. Each chunk is prefixed with its sealed length
+// and its nonce holds the chunk number and whether it is the last one, so
+// chunks cannot be reordered, dropped or cut off without the archive failing
+// to decrypt.
+const (
+	backupMagic     = "ASLBAK01"
+	backupSaltSize  = 16
+	backupChunkSize = 64 * 1024
+)
+
+// ErrBackupDecrypt is returned for archives that were not encrypted with the
+// given key or were modified
+var ErrBackupDecrypt = errors.New("backup archive cannot be decrypted: wrong key or corrupted file")
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func backupNonce(aead cipher.AEAD, counter uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[:8], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// backupEncrypter seals everything written to it in chunks
+type backupEncrypter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+// EncryptBackup returns a writer that encrypts into w with a key derived from
+// passphrase. Close must be called to write the final chunk; it does not
+// close w.
+func EncryptBackup(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(backupMagic), salt...)); err != nil {
+		return nil, err
+	}
+	return &backupEncrypter{w: w, aead: aead, buf: make([]byte, 0, backupChunkSize)}, nil
+}
+
+func (e *backupEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the last
+		// chunk is always the one sealed by Close
+		if len(e.buf) == backupChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):backupChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *backupEncrypter) Close() error {
+	return e.seal(true)
+}
+
+func (e *backupEncrypter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, backupNonce(e.aead, e.counter, last), e.buf, nil)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// backupDecrypter opens the chunks of an encrypted archive
+type backupDecrypter struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// DecryptBackup returns a reader of the plain archive encrypted in r. Reading
+// fails with ErrBackupDecrypt if the key is wrong or the archive was changed
+// or cut short.
+func DecryptBackup(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(backupMagic)+backupSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, ErrBackupDecrypt
+	}
+	aead, err := backupCipher(passphrase, header[len(backupMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &backupDecrypter{r: r, aead: aead}, nil
+}
+
+func (d *backupDecrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *backupDecrypter) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		// The stream ended before the last chunk
+		return ErrBackupDecrypt
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > backupChunkSize+uint32(d.aead.Overhead()) {
+		return ErrBackupDecrypt
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrBackupDecrypt
+	}
+
+	// The last chunk is sealed with its own nonce, and nothing may follow it
+	plain, err := d.aead.Open(nil, backupNonce(d.aead, d.counter, false), sealed, nil)
+	if err != nil {
+		plain, err = d.aead.Open(nil, backupNonce(d.aead, d.counter, true), sealed, nil)
+		if err != nil {
+			return ErrBackupDecrypt
+		}
+		if n, _ := d.r.Read(make([]byte, 1)); n > 0 {
+			return ErrBackupDecrypt
+		}
+		d.done = true
+	}
+	d.counter++
+	d.plain = plain
+	return nil
+}
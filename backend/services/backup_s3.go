@@ -0,0 +1,279 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"asl-market-backend/config"
+)
+
+// S3BackupTarget stores archives in a bucket of any S3-compatible store
+// (AWS, MinIO, ArvanCloud). Requests are signed with AWS Signature V4 and use
+// path-style URLs so self-hosted stores work without DNS setup.
+type S3BackupTarget struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewS3BackupTarget creates a target from the backup.s3 config
+func NewS3BackupTarget(cfg config.BackupS3Config) (*S3BackupTarget, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 backup target needs endpoint, bucket, access_key and secret_key")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3BackupTarget{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    cfg.Bucket,
+		prefix:    prefix,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		client:    &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+func (t *S3BackupTarget) Name() string { return BackupTargetS3 }
+
+func (t *S3BackupTarget) Upload(name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := t.request(http.MethodPut, t.prefix+name, nil, file, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = t.do(req, nil)
+	return err
+}
+
+// s3ListResult is the ListObjectsV2 response
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (t *S3BackupTarget) List() ([]BackupObject, error) {
+	var names []string
+	var sizes []int64
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {t.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := t.request(http.MethodGet, "", query, nil, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if _, err := t.do(req, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, t.prefix))
+			sizes = append(sizes, object.Size)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return backupObjectsFromNames(names, sizes), nil
+}
+
+func (t *S3BackupTarget) Download(name, destPath string) error {
+	req, err := t.request(http.MethodGet, t.prefix+name, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp, err := t.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (t *S3BackupTarget) Delete(name string) error {
+	req, err := t.request(http.MethodDelete, t.prefix+name, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	_, err = t.do(req, nil)
+	return err
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// request builds a signed request for a key of the bucket (the bucket itself
+// when key is empty)
+func (t *S3BackupTarget) request(method, key string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	uri := path.Join(t.endpoint.Path, "/"+t.bucket)
+	if key != "" {
+		uri += "/" + key
+	}
+	u := *t.endpoint
+	u.Path = uri
+	u.RawPath = s3EscapePath(uri)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req, payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds an AWS Signature V4 authorization header
+func (t *S3BackupTarget) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + t.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), day)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+// do sends a request, decoding an XML body into out when given. The caller
+// closes the body of the returned response when out is nil.
+func (t *S3BackupTarget) do(req *http.Request, out interface{}) (*http.Response, error) {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if out != nil {
+		defer resp.Body.Close()
+		return resp, xml.NewDecoder(resp.Body).Decode(out)
+	}
+	if req.Method != http.MethodGet {
+		resp.Body.Close()
+	}
+	return resp, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// Signature V4 requires
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
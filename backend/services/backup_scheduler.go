@@ -1,143 +1,728 @@
 package services
 
 import (
-	"archive/zip"
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"asl-market-backend/config"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
+// Entries of a backup archive. The archive is a gzipped tar encrypted with
+// EncryptBackup.
 const (
-	uploadsDir  = "uploads"
-	backupTmp   = "tmp/backup"
+	backupManifestEntry = "manifest.json"
+	backupDatabaseEntry = "database.sql"
+	backupUploadsEntry  = "uploads"
+	backupNameLayout    = "20060102-150405"
+	backupNamePrefix    = "asl-market-"
+	backupNameSuffix    = ".tar.gz.enc"
 )
 
-// runMidnightBackup creates uploads zip + full DB dump and sends both to Telegram admins.
-func runMidnightBackup(telegramService *TelegramService) {
-	tmpDir := filepath.Join(backupTmp, time.Now().Format("20060102_150405"))
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		log.Printf("backup: failed to create temp dir %s: %v", tmpDir, err)
-		return
-	}
-	defer func() {
-		os.RemoveAll(tmpDir)
-	}()
+// ErrBackupEncryptionKeyMissing is returned when backups are configured
+// without an encryption key
+var ErrBackupEncryptionKeyMissing = errors.New("backup.encryption_key is not set")
 
-	// 1) Zip uploads
-	zipPath := filepath.Join(tmpDir, "uploads_backup.zip")
-	if err := zipDir(uploadsDir, zipPath); err != nil {
-		log.Printf("backup: zip uploads failed: %v", err)
-		notifyBackupError(telegramService, "zip uploads", err)
-		return
+// BackupManifest describes what an archive holds. Table counts are read
+// from the dump itself, so they describe the same snapshot the restore drill
+// loads even while the site is writing.
+type BackupManifest struct {
+	CreatedAt   time.Time        `json:"created_at"`
+	Database    string           `json:"database"`
+	TableCounts map[string]int64 `json:"table_counts"`
+	UploadFiles int              `json:"upload_files"`
+}
+
+// BackupDatabase dumps the database into archives and restores a dump into
+// the scratch database of the restore drill
+type BackupDatabase interface {
+	Name() string
+	Dump(outPath string) error
+	RestoreScratch(sqlPath string) (map[string]int64, error)
+}
+
+// BackupRunResult is the outcome of one backup run
+type BackupRunResult struct {
+	Archive string            `json:"archive"`
+	Size    int64             `json:"size"`
+	Errors  map[string]string `json:"errors"`  // target -> upload or retention error
+	Deleted map[string]int    `json:"deleted"` // target -> archives removed by retention
+}
+
+// RestoreDrillTable compares the rows of one table in the manifest and in
+// the restored database
+type RestoreDrillTable struct {
+	Table    string `json:"table"`
+	Expected int64  `json:"expected"`
+	Restored int64  `json:"restored"`
+}
+
+// RestoreDrillReport is the outcome of restoring an archive into the scratch
+// database
+type RestoreDrillReport struct {
+	Archive             string              `json:"archive"`
+	Target              string              `json:"target"`
+	CreatedAt           time.Time           `json:"created_at"`
+	Tables              []RestoreDrillTable `json:"tables"`
+	Mismatches          []RestoreDrillTable `json:"mismatches"`
+	UploadFilesExpected int                 `json:"upload_files_expected"`
+	UploadFilesRestored int                 `json:"upload_files_restored"`
+}
+
+// OK reports whether every table and upload came back
+func (r *RestoreDrillReport) OK() bool {
+	return len(r.Mismatches) == 0 && r.UploadFilesExpected == r.UploadFilesRestored
+}
+
+// BackupService builds encrypted archives of the database and uploads,
+// ships them to its targets and prunes old archives
+type BackupService struct {
+	cfg      config.BackupConfig
+	database BackupDatabase
+	targets  []BackupTarget
+}
+
+// NewBackupService creates a backup service. The config must have an
+// encryption key.
+func NewBackupService(cfg config.BackupConfig, database BackupDatabase, targets []BackupTarget) (*BackupService, error) {
+	if cfg.EncryptionKey == "" {
+		return nil, ErrBackupEncryptionKeyMissing
 	}
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = filepath.Join("tmp", "backup")
+	}
+	if cfg.UploadsDir == "" {
+		cfg.UploadsDir = "uploads"
+	}
+	return &BackupService{cfg: cfg, database: database, targets: targets}, nil
+}
 
-	// 2) Mysqldump full backup
-	sqlPath := filepath.Join(tmpDir, "db_full_backup.sql")
-	db := &config.AppConfig.Database
-	if err := runMysqldump(db.User, db.Password, db.Host, db.Port, db.Name, sqlPath); err != nil {
-		log.Printf("backup: mysqldump failed: %v", err)
-		notifyBackupError(telegramService, "mysqldump", err)
-		return
+// NewBackupServiceFromConfig creates a backup service for the MySQL database
+// with the targets of the backup config. The Telegram target is left out when
+// the bot is not running.
+func NewBackupServiceFromConfig(telegramService *TelegramService) (*BackupService, error) {
+	cfg := config.AppConfig.Backup
+	var targets []BackupTarget
+	for _, name := range cfg.Targets {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case BackupTargetLocal:
+			targets = append(targets, NewLocalBackupTarget(cfg.Local.Dir))
+		case BackupTargetS3:
+			target, err := NewS3BackupTarget(cfg.S3)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, target)
+		case BackupTargetTelegram:
+			if telegramService == nil {
+				log.Printf("backup: telegram target skipped, the bot is not running")
+				continue
+			}
+			targets = append(targets, NewTelegramBackupTarget(telegramService, ADMIN_IDS))
+		default:
+			return nil, fmt.Errorf("unknown backup target %q", name)
+		}
 	}
+	database := NewMySQLBackupDatabase(config.AppConfig.Database, cfg.ScratchDatabase)
+	return NewBackupService(cfg, database, targets)
+}
 
-	telegramService.SendBackupToAdmins(zipPath, sqlPath)
-	log.Printf("backup: nightly backup sent to admins (zip + sql)")
+// Targets returns the targets archives are sent to
+func (s *BackupService) Targets() []BackupTarget {
+	return s.targets
 }
 
-// RunBackupForChat creates uploads zip + full DB dump and sends both to the given chatID (e.g. admin who requested).
-// Call from a goroutine so the bot stays responsive. On error, sends an error message to chatID.
-func RunBackupForChat(telegramService *TelegramService, chatID int64) {
-	tmpDir := filepath.Join(backupTmp, "manual_"+time.Now().Format("20060102_150405"))
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		log.Printf("backup: failed to create temp dir %s: %v", tmpDir, err)
-		sendBackupErrorToChat(telegramService, chatID, "ایجاد پوشه موقت", err)
-		return
+// BackupArchiveName returns the name of the archive created at t
+func BackupArchiveName(t time.Time) string {
+	return backupNamePrefix + t.Format(backupNameLayout) + backupNameSuffix
+}
+
+// ParseBackupArchiveName returns when an archive was created from its name
+func ParseBackupArchiveName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupNamePrefix) || !strings.HasSuffix(name, backupNameSuffix) {
+		return time.Time{}, false
 	}
-	defer os.RemoveAll(tmpDir)
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupNamePrefix), backupNameSuffix)
+	t, err := time.ParseInLocation(backupNameLayout, stamp, time.Local)
+	return t, err == nil
+}
 
-	zipPath := filepath.Join(tmpDir, "uploads_backup.zip")
-	if err := zipDir(uploadsDir, zipPath); err != nil {
-		log.Printf("backup: zip uploads failed: %v", err)
-		sendBackupErrorToChat(telegramService, chatID, "فشرده‌سازی پوشه uploads", err)
-		return
+// Run creates an archive, sends it to every target and applies retention to
+// the targets it reached. The run fails only if no target got the archive.
+func (s *BackupService) Run() (*BackupRunResult, error) {
+	name, path, err := s.CreateArchive(time.Now())
+	if err != nil {
+		return nil, err
 	}
+	defer os.Remove(path)
 
-	sqlPath := filepath.Join(tmpDir, "db_full_backup.sql")
-	db := &config.AppConfig.Database
-	if err := runMysqldump(db.User, db.Password, db.Host, db.Port, db.Name, sqlPath); err != nil {
-		log.Printf("backup: mysqldump failed: %v", err)
-		sendBackupErrorToChat(telegramService, chatID, "بک‌آپ دیتابیس", err)
-		return
+	result := &BackupRunResult{Archive: name, Errors: map[string]string{}, Deleted: map[string]int{}}
+	if info, err := os.Stat(path); err == nil {
+		result.Size = info.Size()
 	}
 
-	telegramService.SendBackupToChat(chatID, zipPath, sqlPath)
-	log.Printf("backup: manual backup sent to chat %d", chatID)
-}
+	uploaded := 0
+	for _, target := range s.targets {
+		if err := target.Upload(name, path); err != nil {
+			result.Errors[target.Name()] = err.Error()
+			log.Printf("backup: upload of %s to %s failed: %v", name, target.Name(), err)
+			continue
+		}
+		uploaded++
 
-func sendBackupErrorToChat(t *TelegramService, chatID int64, step string, err error) {
-	if t == nil {
-		return
+		deleted, err := s.ApplyRetention(target)
+		if err != nil && !errors.Is(err, ErrBackupTargetNotListable) {
+			result.Errors[target.Name()] = "retention: " + err.Error()
+			log.Printf("backup: retention on %s failed: %v", target.Name(), err)
+		}
+		result.Deleted[target.Name()] = deleted
 	}
-	msg := fmt.Sprintf("❌ خطا در بک‌آپ (%s): %v", step, err)
-	t.bot.Send(tgbotapi.NewMessage(chatID, msg))
+	if uploaded == 0 {
+		return result, fmt.Errorf("archive %s reached no target", name)
+	}
+	return result, nil
 }
 
-func notifyBackupError(t *TelegramService, step string, err error) {
-	if t == nil {
-		return
+// CreateArchive dumps the database and packs it with the uploads into an
+// encrypted archive in the work directory. The caller removes the file.
+func (s *BackupService) CreateArchive(now time.Time) (string, string, error) {
+	tmpDir := filepath.Join(s.cfg.WorkDir, now.Format(backupNameLayout))
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return "", "", err
 	}
-	msg := fmt.Sprintf("❌ خطا در بک‌آپ شبانه (%s): %v", step, err)
-	for _, adminID := range ADMIN_IDS {
-		if _, sendErr := t.bot.Send(tgbotapi.NewMessage(adminID, msg)); sendErr != nil {
-			log.Printf("backup: failed to send error to admin %d: %v", adminID, sendErr)
-		}
+	defer os.RemoveAll(tmpDir)
+
+	sqlPath := filepath.Join(tmpDir, backupDatabaseEntry)
+	if err := s.database.Dump(sqlPath); err != nil {
+		return "", "", fmt.Errorf("dump database: %v", err)
 	}
+
+	manifest := BackupManifest{CreatedAt: now, Database: s.database.Name()}
+	counts, err := countDumpFileRows(sqlPath)
+	if err != nil {
+		return "", "", fmt.Errorf("count rows: %v", err)
+	}
+	manifest.TableCounts = counts
+
+	name := BackupArchiveName(now)
+	path := filepath.Join(s.cfg.WorkDir, name)
+	if err := s.writeArchive(path, sqlPath, &manifest); err != nil {
+		os.Remove(path)
+		return "", "", err
+	}
+	return name, path, nil
 }
 
-// zipDir creates a zip file at zipPath containing the whole dir (relative to cwd).
-func zipDir(dir, zipPath string) error {
-	f, err := os.Create(zipPath)
+func (s *BackupService) writeArchive(path, sqlPath string, manifest *BackupManifest) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	w := zip.NewWriter(f)
-	defer w.Close()
+	defer file.Close()
+
+	encrypted, err := EncryptBackup(file, s.cfg.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(encrypted)
+	tw := tar.NewWriter(gz)
 
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	if err := addFileToTar(tw, sqlPath, backupDatabaseEntry); err != nil {
+		return fmt.Errorf("pack database: %v", err)
+	}
+	err = filepath.Walk(s.cfg.UploadsDir, func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if !info.Mode().IsRegular() {
 			return nil
 		}
-		rel, err := filepath.Rel(dir, path)
+		rel, err := filepath.Rel(s.cfg.UploadsDir, p)
 		if err != nil {
 			return err
 		}
-		rel = filepath.Join(filepath.Base(dir), rel)
-		rel = filepath.ToSlash(rel)
+		manifest.UploadFiles++
+		return addFileToTar(tw, p, backupUploadsEntry+"/"+filepath.ToSlash(rel))
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("pack uploads: %v", err)
+	}
+
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	header := &tar.Header{Name: backupManifestEntry, Mode: 0600, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := encrypted.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// ExtractBackupArchive decrypts an archive into dir and returns its manifest
+func ExtractBackupArchive(archivePath, passphrase, dir string) (*BackupManifest, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	plain, err := DecryptBackup(file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(plain)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
 
-		zf, err := w.Create(rel)
+	var manifest *BackupManifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return err
+			return nil, err
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(dest, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return nil, fmt.Errorf("archive entry %q escapes the restore directory", header.Name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			return nil, err
 		}
-		file, err := os.Open(path)
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return nil, err
+		}
+		out.Close()
+
+		if header.Name == backupManifestEntry {
+			data, err := os.ReadFile(dest)
+			if err != nil {
+				return nil, err
+			}
+			manifest = &BackupManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %v", err)
+			}
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	return manifest, nil
+}
+
+// ApplyRetention deletes the archives of a target that SelectBackupsToKeep
+// does not keep and returns how many were deleted
+func (s *BackupService) ApplyRetention(target BackupTarget) (int, error) {
+	objects, err := target.List()
+	if err != nil {
+		return 0, err
+	}
+	keep := SelectBackupsToKeep(objects, s.cfg.Retention)
+	deleted := 0
+	for _, object := range objects {
+		if keep[object.Name] {
+			continue
+		}
+		if err := target.Delete(object.Name); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// SelectBackupsToKeep returns the archives a retention policy keeps: the
+// newest archive of each of the last Daily days, Weekly ISO weeks and Monthly
+// months that have archives. The newest archive is always kept, and a policy
+// of all zeros keeps everything.
+func SelectBackupsToKeep(objects []BackupObject, retention config.BackupRetentionConfig) map[string]bool {
+	keep := make(map[string]bool)
+	if len(objects) == 0 {
+		return keep
+	}
+	sorted := append([]BackupObject(nil), objects...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CreatedAt.After(sorted[j].CreatedAt) })
+
+	if retention.Daily <= 0 && retention.Weekly <= 0 && retention.Monthly <= 0 {
+		for _, object := range sorted {
+			keep[object.Name] = true
+		}
+		return keep
+	}
+	keep[sorted[0].Name] = true
+
+	periods := []struct {
+		count int
+		key   func(time.Time) string
+	}{
+		{retention.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{retention.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{retention.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, period := range periods {
+		seen := make(map[string]bool)
+		for _, object := range sorted {
+			if len(seen) >= period.count {
+				break
+			}
+			key := period.key(object.CreatedAt)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keep[object.Name] = true
+		}
+	}
+	return keep
+}
+
+// ListArchives returns the archives of a listable target, oldest first. An
+// empty name picks the first listable target.
+func (s *BackupService) ListArchives(targetName string) (BackupTarget, []BackupObject, error) {
+	for _, target := range s.targets {
+		if targetName != "" && target.Name() != targetName {
+			continue
+		}
+		objects, err := target.List()
+		if errors.Is(err, ErrBackupTargetNotListable) && targetName == "" {
+			continue
+		}
+		return target, objects, err
+	}
+	if targetName == "" {
+		return nil, nil, errors.New("no listable backup target configured")
+	}
+	return nil, nil, fmt.Errorf("backup target %q is not configured", targetName)
+}
+
+// RestoreDrill downloads an archive (the newest when name is empty), restores
+// its dump into the scratch database and compares the row counts and uploads
+// with its manifest
+func (s *BackupService) RestoreDrill(targetName, name string) (*RestoreDrillReport, error) {
+	target, objects, err := s.ListArchives(targetName)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no archives in %s", target.Name())
+		}
+		name = objects[len(objects)-1].Name
+	}
+
+	dir := filepath.Join(s.cfg.WorkDir, "restore_"+time.Now().Format(backupNameLayout))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, filepath.Base(name))
+	if err := target.Download(name, archivePath); err != nil {
+		return nil, fmt.Errorf("download %s: %v", name, err)
+	}
+	extracted := filepath.Join(dir, "archive")
+	manifest, err := ExtractBackupArchive(archivePath, s.cfg.EncryptionKey, extracted)
+	if err != nil {
+		return nil, err
+	}
+
+	restored, err := s.database.RestoreScratch(filepath.Join(extracted, backupDatabaseEntry))
+	if err != nil {
+		return nil, fmt.Errorf("restore into scratch database: %v", err)
+	}
+
+	report := &RestoreDrillReport{
+		Archive:             name,
+		Target:              target.Name(),
+		CreatedAt:           manifest.CreatedAt,
+		UploadFilesExpected: manifest.UploadFiles,
+		UploadFilesRestored: countFiles(filepath.Join(extracted, backupUploadsEntry)),
+	}
+	tables := make([]string, 0, len(manifest.TableCounts))
+	for table := range manifest.TableCounts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		row := RestoreDrillTable{Table: table, Expected: manifest.TableCounts[table], Restored: restored[table]}
+		report.Tables = append(report.Tables, row)
+		if row.Expected != row.Restored {
+			report.Mismatches = append(report.Mismatches, row)
 		}
-		defer file.Close()
-		_, err = io.Copy(zf, file)
+	}
+	return report, nil
+}
+
+// DecryptArchive writes the plain tar.gz of an encrypted archive. An archive
+// received from Telegram in parts is read from its .partNN files.
+func (s *BackupService) DecryptArchive(archivePath, outPath string) error {
+	in, err := openBackupArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	plain, err := DecryptBackup(in, s.cfg.EncryptionKey)
+	if err != nil {
 		return err
+	}
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, plain); err != nil {
+		out.Close()
+		os.Remove(outPath)
+		return err
+	}
+	return out.Close()
+}
+
+func countFiles(dir string) int {
+	count := 0
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			count++
+		}
+		return nil
 	})
+	return count
+}
+
+// scratchDatabaseName guards the restore drill against overwriting a real database
+var scratchDatabaseName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// MySQLBackupDatabase dumps with mysqldump and restores with the mysql client
+type MySQLBackupDatabase struct {
+	cfg     config.DatabaseConfig
+	scratch string
+}
+
+// NewMySQLBackupDatabase creates a MySQL backup database; scratch is the
+// database the restore drill drops and recreates
+func NewMySQLBackupDatabase(cfg config.DatabaseConfig, scratch string) *MySQLBackupDatabase {
+	return &MySQLBackupDatabase{cfg: cfg, scratch: scratch}
+}
+
+func (m *MySQLBackupDatabase) Name() string { return m.cfg.Name }
+
+func (m *MySQLBackupDatabase) Dump(outPath string) error {
+	return runMysqldump(m.cfg.User, m.cfg.Password, m.cfg.Host, m.cfg.Port, m.cfg.Name, outPath)
+}
+
+// RestoreScratch recreates the scratch database, loads the dump into it and
+// counts its rows
+func (m *MySQLBackupDatabase) RestoreScratch(sqlPath string) (map[string]int64, error) {
+	if !scratchDatabaseName.MatchString(m.scratch) || m.scratch == m.cfg.Name {
+		return nil, fmt.Errorf("backup.scratch_database must be set to a database other than %q", m.cfg.Name)
+	}
+
+	reset := fmt.Sprintf("DROP DATABASE IF EXISTS `%s`; CREATE DATABASE `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;", m.scratch, m.scratch)
+	if err := m.runMysql("", nil, "-e", reset); err != nil {
+		return nil, err
+	}
+	dump, err := os.Open(sqlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dump.Close()
+	if err := m.runMysql(m.scratch, dump); err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		m.cfg.User, m.cfg.Password, m.cfg.Host, m.cfg.Port, m.scratch)
+	scratchDB, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := scratchDB.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	return countTableRows(scratchDB)
+}
+
+// runMysql runs the mysql client, feeding it stdin when given
+func (m *MySQLBackupDatabase) runMysql(database string, stdin io.Reader, extra ...string) error {
+	args := []string{"-u", m.cfg.User, "-h", m.cfg.Host, "-P", m.cfg.Port}
+	args = append(args, extra...)
+	if database != "" {
+		args = append(args, database)
+	}
+	cmd := exec.Command("mysql", args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+m.cfg.Password)
+	return cmd.Run()
+}
+
+// countTableRows counts the rows of every table of a database
+func countTableRows(db *gorm.DB) (map[string]int64, error) {
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var count int64
+		if err := db.Table(table).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("count %s: %v", table, err)
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+func countDumpFileRows(path string) (map[string]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return CountDumpRows(file)
+}
+
+// CountDumpRows counts the rows a mysqldump script inserts into each table.
+// Tables the script creates without rows count as zero.
+func CountDumpRows(r io.Reader) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	reader := bufio.NewReaderSize(r, 1<<20)
+	for {
+		// mysqldump escapes newlines in values, so every statement is a line
+		line, err := reader.ReadString('\n')
+		if table, ok := dumpStatementTable(line, "CREATE TABLE "); ok {
+			if _, seen := counts[table]; !seen {
+				counts[table] = 0
+			}
+		} else if table, ok := dumpStatementTable(line, "INSERT INTO "); ok {
+			counts[table] += countInsertTuples(line)
+		}
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dumpStatementTable returns the table of a statement starting with prefix
+func dumpStatementTable(line, prefix string) (string, bool) {
+	if !strings.HasPrefix(line, prefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(line, prefix)
+	if strings.HasPrefix(rest, "IF NOT EXISTS ") {
+		rest = strings.TrimPrefix(rest, "IF NOT EXISTS ")
+	}
+	if strings.HasPrefix(rest, "`") {
+		end := strings.Index(rest[1:], "`")
+		if end < 0 {
+			return "", false
+		}
+		return rest[1 : end+1], true
+	}
+	end := strings.IndexAny(rest, " (")
+	if end <= 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+// countInsertTuples counts the value tuples of an extended INSERT, skipping
+// parentheses inside quoted values
+func countInsertTuples(line string) int64 {
+	values := strings.Index(line, " VALUES ")
+	if values < 0 {
+		return 0
+	}
+	var count int64
+	depth := 0
+	var quote byte
+	for i := values; i < len(line); i++ {
+		ch := line[i]
+		if quote != 0 {
+			switch ch {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '\'', '"':
+			quote = ch
+		case '(':
+			if depth == 0 {
+				count++
+			}
+			depth++
+		case ')':
+			depth--
+		}
+	}
+	return count
 }
 
 // runMysqldump runs: mysqldump -u user -h host -P port --routines --triggers --events --single-transaction db_name > outPath
@@ -167,23 +752,131 @@ func runMysqldump(user, password, host, port, dbName, outPath string) error {
 	return cmd.Run()
 }
 
-// StartBackupScheduler starts a goroutine that runs full backup every night at 00:00 local time
-// and sends uploads zip + DB dump to Telegram admins. Call only when telegramService is non-nil.
+// RunBackupForChat creates an encrypted backup and sends it to the given chat
+// (e.g. the admin who requested it). Call from a goroutine so the bot stays
+// responsive. On error, sends an error message to chatID.
+func RunBackupForChat(telegramService *TelegramService, chatID int64) {
+	cfg := config.AppConfig.Backup
+	database := NewMySQLBackupDatabase(config.AppConfig.Database, cfg.ScratchDatabase)
+	service, err := NewBackupService(cfg, database, []BackupTarget{NewTelegramBackupTarget(telegramService, []int64{chatID})})
+	if err != nil {
+		sendBackupErrorToChat(telegramService, chatID, "تنظیمات بک‌آپ", err)
+		return
+	}
+
+	name, path, err := service.CreateArchive(time.Now())
+	if err != nil {
+		log.Printf("backup: manual backup failed: %v", err)
+		sendBackupErrorToChat(telegramService, chatID, "تهیه بک‌آپ", err)
+		return
+	}
+	defer os.Remove(path)
+
+	if err := service.Targets()[0].Upload(name, path); err != nil {
+		log.Printf("backup: failed to send manual backup to chat %d: %v", chatID, err)
+		sendBackupErrorToChat(telegramService, chatID, "ارسال فایل", err)
+		return
+	}
+	log.Printf("backup: manual backup sent to chat %d", chatID)
+}
+
+func sendBackupErrorToChat(t *TelegramService, chatID int64, step string, err error) {
+	if t == nil {
+		return
+	}
+	msg := fmt.Sprintf("❌ خطا در بک‌آپ (%s): %v", step, err)
+	t.bot.Send(tgbotapi.NewMessage(chatID, msg))
+}
+
+func notifyBackupError(t *TelegramService, step string, err error) {
+	if t == nil {
+		return
+	}
+	msg := fmt.Sprintf("❌ خطا در بک‌آپ شبانه (%s): %v", step, err)
+	for _, adminID := range ADMIN_IDS {
+		if _, sendErr := t.bot.Send(tgbotapi.NewMessage(adminID, msg)); sendErr != nil {
+			log.Printf("backup: failed to send error to admin %d: %v", adminID, sendErr)
+		}
+	}
+}
+
+// warnBackupsOff reports on every start that no nightly backups will run:
+// backups were turned off, or they cannot run on an install without the bot.
+func warnBackupsOff(t *TelegramService, reason string) {
+	log.Printf("backup: WARNING: nightly backups are NOT running (%s); set backup.enabled and backup.encryption_key in config.yaml", reason)
+	if t == nil {
+		return
+	}
+	msg := fmt.Sprintf("⚠️ بک‌آپ شبانه اجرا نمی‌شود (%s).\nbackup.enabled و backup.encryption_key را در config.yaml تنظیم کنید.", reason)
+	for _, adminID := range ADMIN_IDS {
+		if _, err := t.bot.Send(tgbotapi.NewMessage(adminID, msg)); err != nil {
+			log.Printf("backup: failed to warn admin %d: %v", adminID, err)
+		}
+	}
+}
+
+// nextBackupTime returns the next daily run at clock (HH:MM) after now
+func nextBackupTime(now time.Time, clock string) time.Time {
+	minutes, ok := parseBackupClock(clock)
+	if !ok {
+		minutes = 0
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), minutes/60, minutes%60, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func parseBackupClock(clock string) (int, bool) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// StartBackupScheduler runs the backup every day at backup.time when backups
+// are enabled. Failures are reported to the Telegram admins when the bot runs.
+// With the bot running and no encryption key it stops the server instead.
 func StartBackupScheduler(telegramService *TelegramService) {
-	if telegramService == nil {
+	cfg := config.AppConfig.Backup
+	if !cfg.Enabled {
+		warnBackupsOff(telegramService, "backup.enabled is false")
 		return
 	}
+	if _, ok := parseBackupClock(cfg.Time); !ok {
+		log.Printf("backup: invalid backup.time %q, running at 00:00", cfg.Time)
+	}
+	service, err := NewBackupServiceFromConfig(telegramService)
+	if errors.Is(err, ErrBackupEncryptionKeyMissing) && telegramService != nil {
+		// Installs running the bot had a nightly Telegram backup without any
+		// configuration; starting without one would lose it unnoticed
+		log.Fatalf("backup: refusing to start: the Telegram bot is running but backup.encryption_key is not set. " +
+			"Set it (and add \"telegram\" to backup.targets to keep receiving backups on Telegram), " +
+			"or set backup.enabled: false to run without backups")
+	}
+	if err != nil {
+		warnBackupsOff(telegramService, err.Error())
+		return
+	}
+
 	go func() {
 		for {
-			now := time.Now()
-			midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-			if !now.Before(midnight) {
-				midnight = midnight.Add(24 * time.Hour)
+			next := nextBackupTime(time.Now(), cfg.Time)
+			log.Printf("backup: next run at %s (in %s)", next.Format("2006-01-02 15:04"), time.Until(next).Round(time.Second))
+			time.Sleep(time.Until(next))
+
+			result, err := service.Run()
+			if err != nil {
+				log.Printf("backup: run failed: %v", err)
+				notifyBackupError(telegramService, "اجرا", err)
+				continue
+			}
+			for target, targetErr := range result.Errors {
+				notifyBackupError(telegramService, target, errors.New(targetErr))
 			}
-			d := time.Until(midnight)
-			log.Printf("backup: next run at %s (in %s)", midnight.Format("2006-01-02 15:04"), d.Round(time.Second))
-			time.Sleep(d)
-			runMidnightBackup(telegramService)
+			log.Printf("backup: %s (%d bytes) done, retention deleted %v", result.Archive, result.Size, result.Deleted)
 		}
 	}()
 }
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup target names used in the backup.targets config
+const (
+	BackupTargetLocal    = "local"
+	BackupTargetS3       = "s3"
+	BackupTargetTelegram = "telegram"
+)
+
+// ErrBackupTargetNotListable is returned by targets that can only receive
+// archives, such as Telegram
+var ErrBackupTargetNotListable = errors.New("backup target cannot list or fetch archives")
+
+// BackupObject is an archive stored in a target
+type BackupObject struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"` // parsed from the name
+}
+
+// BackupTarget stores backup archives. Targets that return
+// ErrBackupTargetNotListable from List are left out of retention and restore.
+type BackupTarget interface {
+	Name() string
+	Upload(name, path string) error
+	List() ([]BackupObject, error)
+	Download(name, destPath string) error
+	Delete(name string) error
+}
+
+// backupObjectsFromNames keeps the names that are backup archives, oldest first
+func backupObjectsFromNames(names []string, sizes []int64) []BackupObject {
+	var objects []BackupObject
+	for i, name := range names {
+		createdAt, ok := ParseBackupArchiveName(name)
+		if !ok {
+			continue
+		}
+		objects = append(objects, BackupObject{Name: name, Size: sizes[i], CreatedAt: createdAt})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].CreatedAt.Before(objects[j].CreatedAt) })
+	return objects
+}
+
+// LocalBackupTarget keeps archives in a directory, e.g. a mounted disk
+type LocalBackupTarget struct {
+	dir string
+}
+
+// NewLocalBackupTarget creates a target writing into dir
+func NewLocalBackupTarget(dir string) *LocalBackupTarget {
+	return &LocalBackupTarget{dir: dir}
+}
+
+func (t *LocalBackupTarget) Name() string { return BackupTargetLocal }
+
+// Upload copies the archive into the directory. It is written under a
+// temporary name first so a half-written copy is never listed.
+func (t *LocalBackupTarget) Upload(name, path string) error {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	dest := filepath.Join(t.dir, name)
+	if err := copyFile(path, dest+".part"); err != nil {
+		os.Remove(dest + ".part")
+		return err
+	}
+	return os.Rename(dest+".part", dest)
+}
+
+func (t *LocalBackupTarget) List() ([]BackupObject, error) {
+	entries, err := os.ReadDir(t.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var sizes []int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+		sizes = append(sizes, info.Size())
+	}
+	return backupObjectsFromNames(names, sizes), nil
+}
+
+func (t *LocalBackupTarget) Download(name, destPath string) error {
+	return copyFile(filepath.Join(t.dir, filepath.Base(name)), destPath)
+}
+
+func (t *LocalBackupTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(t.dir, filepath.Base(name)))
+}
+
+// telegramDocumentLimit is the largest file a bot may send
+const telegramDocumentLimit = 50 << 20
+
+// telegramPartSize is the size archives are split into for Telegram, under
+// the limit with room for the multipart upload
+const telegramPartSize = telegramDocumentLimit - 1<<20
+
+// TelegramBackupTarget sends archives to chats as documents, split into
+// parts when they are over the Telegram limit. Telegram cannot be listed, so
+// it gets no retention and cannot be restored from directly.
+type TelegramBackupTarget struct {
+	telegram *TelegramService
+	chatIDs  []int64
+}
+
+// NewTelegramBackupTarget creates a target sending to the given chats
+func NewTelegramBackupTarget(telegram *TelegramService, chatIDs []int64) *TelegramBackupTarget {
+	return &TelegramBackupTarget{telegram: telegram, chatIDs: chatIDs}
+}
+
+func (t *TelegramBackupTarget) Name() string { return BackupTargetTelegram }
+
+func (t *TelegramBackupTarget) Upload(name, path string) error {
+	parts, err := SplitBackupArchive(path, telegramPartSize)
+	if err != nil {
+		return fmt.Errorf("split archive: %v", err)
+	}
+	if len(parts) > 1 {
+		defer func() {
+			for _, part := range parts {
+				os.Remove(part)
+			}
+		}()
+	}
+
+	var failed []string
+	for _, chatID := range t.chatIDs {
+		for i, part := range parts {
+			partName := name
+			if len(parts) > 1 {
+				partName = filepath.Base(part)
+			}
+			if err := t.telegram.SendBackupArchiveToChat(chatID, part, partName, i+1, len(parts)); err != nil {
+				failed = append(failed, fmt.Sprintf("%d: %v", chatID, err))
+				break
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send to %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// SplitBackupArchive splits an archive into <path>.part01, <path>.part02, ...
+// of at most partSize bytes, which concatenated give the archive back. An
+// archive that fits is returned as is. The caller removes the parts.
+func SplitBackupArchive(path string, partSize int64) ([]string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= partSize {
+		return []string{path}, nil
+	}
+
+	var parts []string
+	for i := 1; int64(len(parts))*partSize < info.Size(); i++ {
+		part := fmt.Sprintf("%s.part%02d", path, i)
+		parts = append(parts, part)
+		out, err := os.OpenFile(part, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return parts, err
+		}
+		_, err = io.CopyN(out, in, partSize)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && err != io.EOF {
+			return parts, err
+		}
+	}
+	return parts, nil
+}
+
+// openBackupArchive opens an archive, or the concatenation of its parts when
+// it was downloaded from Telegram in parts
+func openBackupArchive(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return file, err
+	}
+	parts, globErr := filepath.Glob(path + ".part[0-9][0-9]")
+	if globErr != nil || len(parts) == 0 {
+		return nil, err
+	}
+	sort.Strings(parts)
+	files := make([]*os.File, 0, len(parts))
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		f, err := os.Open(part)
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+	return &multiFileReader{Reader: io.MultiReader(readers...), files: files}, nil
+}
+
+// multiFileReader reads several files in a row and closes them all
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (m *multiFileReader) Close() error {
+	var first error
+	for _, f := range m.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t *TelegramBackupTarget) List() ([]BackupObject, error) {
+	return nil, ErrBackupTargetNotListable
+}
+
+func (t *TelegramBackupTarget) Download(name, destPath string) error {
+	return ErrBackupTargetNotListable
+}
+
+func (t *TelegramBackupTarget) Delete(name string) error {
+	return ErrBackupTargetNotListable
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,304 @@
+package services_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/services"
+)
+
+func TestBackupEncryptionRoundTripAndTamperDetection(t *testing.T) {
+	plain := make([]byte, 200*1024+17) // several chunks and a partial one
+	rand.Read(plain)
+
+	var sealed bytes.Buffer
+	w, err := services.EncryptBackup(&sealed, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain[:1000])
+	w.Write(plain[1000:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed.Bytes(), plain[:64]) {
+		t.Fatal("archive is not encrypted")
+	}
+
+	decrypt := func(data []byte, key string) ([]byte, error) {
+		r, err := services.DecryptBackup(bytes.NewReader(data), key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	got, err := decrypt(sealed.Bytes(), "secret")
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("round trip failed: err = %v, %d bytes", err, len(got))
+	}
+	if _, err := decrypt(sealed.Bytes(), "wrong"); !errors.Is(err, services.ErrBackupDecrypt) {
+		t.Fatalf("wrong key: err = %v", err)
+	}
+
+	tampered := append([]byte(nil), sealed.Bytes()...)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := decrypt(tampered, "secret"); !errors.Is(err, services.ErrBackupDecrypt) {
+		t.Fatalf("tampered: err = %v", err)
+	}
+	// Cutting the archive at a chunk boundary must not go unnoticed
+	firstChunk := 8 + 16 + 4 + 64*1024 + 16
+	if _, err := decrypt(sealed.Bytes()[:firstChunk], "secret"); !errors.Is(err, services.ErrBackupDecrypt) {
+		t.Fatalf("truncated: err = %v", err)
+	}
+}
+
+func TestSelectBackupsToKeep(t *testing.T) {
+	// One archive a day from 2026-01-01 to 2026-03-31
+	var objects []services.BackupObject
+	for day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local); day.Month() <= 3; day = day.AddDate(0, 0, 1) {
+		objects = append(objects, services.BackupObject{Name: services.BackupArchiveName(day), CreatedAt: day})
+	}
+
+	keep := services.SelectBackupsToKeep(objects, config.BackupRetentionConfig{Daily: 3, Weekly: 2, Monthly: 3})
+	want := []string{
+		"20260331", "20260330", "20260329", // daily
+		// weekly: the week of 2026-03-30 is already kept, 2026-03-29 closes the previous ISO week
+		"20260228", "20260131", // monthly
+	}
+	if len(keep) != len(want) {
+		t.Fatalf("kept %d archives: %v", len(keep), keep)
+	}
+	for _, day := range want {
+		found := false
+		for name := range keep {
+			if strings.Contains(name, day) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("archive of %s not kept: %v", day, keep)
+		}
+	}
+
+	if all := services.SelectBackupsToKeep(objects, config.BackupRetentionConfig{}); len(all) != len(objects) {
+		t.Fatalf("empty policy kept %d of %d", len(all), len(objects))
+	}
+}
+
+// fakeDump is a mysqldump script with a value that looks like a tuple
+// boundary and a table without rows
+const fakeDump = "CREATE TABLE `users` (\n  `id` int NOT NULL\n);\n" +
+	"INSERT INTO `users` VALUES (1,'a'),(2,'it\\'s ),(\\\\'),(3,NULL);\n" +
+	"CREATE TABLE `orders` (\n  `id` int NOT NULL\n);\n"
+
+// fakeBackupDatabase dumps fakeDump and restores it by counting its rows,
+// minus the rows it is told to lose
+type fakeBackupDatabase struct {
+	lost map[string]int64
+}
+
+func (f *fakeBackupDatabase) Name() string { return "asl_market" }
+
+func (f *fakeBackupDatabase) Dump(outPath string) error {
+	return os.WriteFile(outPath, []byte(fakeDump), 0600)
+}
+
+func (f *fakeBackupDatabase) RestoreScratch(sqlPath string) (map[string]int64, error) {
+	file, err := os.Open(sqlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	restored, err := services.CountDumpRows(file)
+	if err != nil {
+		return nil, err
+	}
+	for table, lost := range f.lost {
+		restored[table] -= lost
+	}
+	return restored, nil
+}
+
+func TestCountDumpRows(t *testing.T) {
+	counts, err := services.CountDumpRows(strings.NewReader(fakeDump + "INSERT INTO `users` VALUES (4,'\\\\');\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts["users"] != 4 || counts["orders"] != 0 {
+		t.Fatalf("counts = %v", counts)
+	}
+}
+
+func TestBackupRunAndRestoreDrill(t *testing.T) {
+	dir := t.TempDir()
+	uploads := filepath.Join(dir, "uploads")
+	os.MkdirAll(filepath.Join(uploads, "products"), 0755)
+	os.WriteFile(filepath.Join(uploads, "products", "a.jpg"), []byte("jpg"), 0644)
+	os.WriteFile(filepath.Join(uploads, "b.pdf"), []byte("pdf"), 0644)
+
+	cfg := config.BackupConfig{
+		EncryptionKey: "secret",
+		UploadsDir:    uploads,
+		WorkDir:       filepath.Join(dir, "work"),
+		Retention:     config.BackupRetentionConfig{Daily: 7},
+	}
+	local := services.NewLocalBackupTarget(filepath.Join(dir, "backups"))
+	database := &fakeBackupDatabase{}
+
+	if _, err := services.NewBackupService(config.BackupConfig{}, database, nil); !errors.Is(err, services.ErrBackupEncryptionKeyMissing) {
+		t.Fatalf("no key: err = %v", err)
+	}
+	service, err := services.NewBackupService(cfg, database, []services.BackupTarget{local})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := service.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 0 || result.Size == 0 {
+		t.Fatalf("result = %+v", result)
+	}
+	if _, objects, _ := service.ListArchives(""); len(objects) != 1 || objects[0].Name != result.Archive {
+		t.Fatalf("archives = %+v", objects)
+	}
+
+	report, err := service.RestoreDrill("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.UploadFilesExpected != 2 || len(report.Tables) != 2 || report.Tables[1].Restored != 3 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Rows the restore does not bring back are reported
+	database.lost = map[string]int64{"users": 1}
+	if _, err := service.Run(); err != nil {
+		t.Fatal(err)
+	}
+	report, err = service.RestoreDrill(services.BackupTargetLocal, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || len(report.Mismatches) != 1 || report.Mismatches[0].Expected != 3 {
+		t.Fatalf("mismatches = %+v", report.Mismatches)
+	}
+
+	// Archives cannot be read without the key
+	archive := filepath.Join(dir, "backups", report.Archive)
+	if _, err := services.ExtractBackupArchive(archive, "wrong", filepath.Join(dir, "out")); !errors.Is(err, services.ErrBackupDecrypt) {
+		t.Fatalf("wrong key: err = %v", err)
+	}
+
+	// Archives over the Telegram limit go in parts that decrypt together
+	whole := filepath.Join(dir, "whole.tar.gz")
+	if err := service.DecryptArchive(archive, whole); err != nil {
+		t.Fatal(err)
+	}
+	split := filepath.Join(dir, "split", report.Archive)
+	os.MkdirAll(filepath.Dir(split), 0755)
+	data, _ := os.ReadFile(archive)
+	os.WriteFile(split, data, 0600)
+	parts, err := services.SplitBackupArchive(split, int64(len(data)/3+1))
+	if err != nil || len(parts) != 3 {
+		t.Fatalf("split into %d parts, err = %v", len(parts), err)
+	}
+	os.Remove(split)
+	joined := filepath.Join(dir, "joined.tar.gz")
+	if err := service.DecryptArchive(split, joined); err != nil {
+		t.Fatalf("decrypt parts: %v", err)
+	}
+	want, _ := os.ReadFile(whole)
+	if got, _ := os.ReadFile(joined); !bytes.Equal(got, want) {
+		t.Fatal("archive decrypted from parts differs")
+	}
+}
+
+func TestS3BackupTarget(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") ||
+			r.Header.Get("x-amz-content-sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/bucket":
+			prefix := r.URL.Query().Get("prefix")
+			fmt.Fprint(w, `<ListBucketResult>`)
+			for name, data := range objects {
+				if strings.HasPrefix(name, prefix) {
+					fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, name, len(data))
+				}
+			}
+			fmt.Fprint(w, `<IsTruncated>false</IsTruncated></ListBucketResult>`)
+		case r.Method == http.MethodPut:
+			objects[key], _ = io.ReadAll(r.Body)
+		case r.Method == http.MethodGet:
+			data, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	target, err := services.NewS3BackupTarget(config.BackupS3Config{
+		Endpoint: server.URL, Bucket: "bucket", Prefix: "nightly", AccessKey: "access", SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "archive")
+	os.WriteFile(src, []byte("encrypted archive"), 0600)
+	name := services.BackupArchiveName(time.Now())
+	if err := target.Upload(name, src); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["nightly/"+name]; !ok {
+		t.Fatalf("objects = %v", objects)
+	}
+
+	list, err := target.List()
+	if err != nil || len(list) != 1 || list[0].Name != name || list[0].Size != 17 {
+		t.Fatalf("list = %+v, err = %v", list, err)
+	}
+	dest := filepath.Join(dir, "download")
+	if err := target.Download(name, dest); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "encrypted archive" {
+		t.Fatalf("downloaded %q", data)
+	}
+	if err := target.Delete(name); err != nil || len(objects) != 0 {
+		t.Fatalf("delete: err = %v, objects = %v", err, objects)
+	}
+	if err := target.Download(name, dest); err == nil {
+		t.Fatal("downloaded a deleted archive")
+	}
+}
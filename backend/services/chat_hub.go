@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"asl-market-backend/models"
+)
+
+// Chat event types pushed over the chat stream
+const (
+	ChatEventMessage        = "message"
+	ChatEventMessageUpdated = "message_updated"
+	ChatEventMessageDeleted = "message_deleted"
+	ChatEventTyping         = "typing"
+	ChatEventRead           = "read"
+)
+
+// Chat kinds an event can belong to
+const (
+	ChatKindMatching       = "matching"
+	ChatKindVisitorProject = "visitor_project"
+	ChatKindDirect         = "direct"
+)
+
+// chatSubscriberBuffer is how many events a slow client may fall behind
+// before further events to it are dropped
+const chatSubscriberBuffer = 32
+
+// ChatEvent is one real-time update for a chat participant
+type ChatEvent struct {
+	Type           string      `json:"type"`            // message, message_updated, message_deleted, typing or read
+	Chat           string      `json:"chat"`            // matching, visitor_project or direct
+	ChatID         uint        `json:"chat_id"`         // ID used in that chat's routes: the matching request ID for matching chats
+	ConversationID uint        `json:"conversation_id"` // ID used in the /conversations routes
+	Data           interface{} `json:"data"`
+	SentAt         time.Time   `json:"sent_at"`
+}
+
+// ChatHub fans chat events out to the open streams of each user. A user may
+// have several streams (tabs, devices); all of them receive every event.
+type ChatHub struct {
+	mu          sync.RWMutex
+	subscribers map[uint]map[chan ChatEvent]struct{}
+}
+
+var chatHubInstance = NewChatHub()
+
+// GetChatHub returns the process-wide chat hub
+func GetChatHub() *ChatHub {
+	return chatHubInstance
+}
+
+// NewChatHub creates an empty hub
+func NewChatHub() *ChatHub {
+	return &ChatHub{subscribers: make(map[uint]map[chan ChatEvent]struct{})}
+}
+
+// Subscribe opens a stream for userID. The returned func must be called when
+// the client goes away; it closes the channel.
+func (h *ChatHub) Subscribe(userID uint) (<-chan ChatEvent, func()) {
+	ch := make(chan ChatEvent, chatSubscriberBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan ChatEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[userID], ch)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// IsOnline reports whether userID has at least one open stream
+func (h *ChatHub) IsOnline(userID uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[userID]) > 0
+}
+
+// Publish sends event to every open stream of userID and reports whether any
+// stream took it. Streams whose buffer is full are skipped rather than
+// blocking the sender.
+func (h *ChatHub) Publish(userID uint, event ChatEvent) bool {
+	if event.SentAt.IsZero() {
+		event.SentAt = time.Now()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	delivered := false
+	for ch := range h.subscribers[userID] {
+		select {
+		case ch <- event:
+			delivered = true
+		default:
+			log.Printf("ChatHub: stream of user %d is full, dropping %s event", userID, event.Type)
+		}
+	}
+	return delivered
+}
+
+// DeliverChatMessage publishes a new-message event to the recipient and falls
+// back to a web push when none of their streams received it, unless the
+// recipient turned off chat pushes or is in their quiet hours
+func DeliverChatMessage(recipientID uint, event ChatEvent, fallback PushMessage) {
+	if GetChatHub().Publish(recipientID, event) {
+		return
+	}
+	go func() {
+		decision, err := models.CheckNotificationPreference(models.GetDB(), recipientID,
+			models.NotificationChannelPush, models.NotificationTopicChatMessages, time.Now())
+		if err != nil {
+			log.Printf("DeliverChatMessage: failed to load preferences of user %d: %v", recipientID, err)
+		} else if !decision.Allowed {
+			return
+		}
+		err = GetPushNotificationService().SendPushNotification(recipientID, fallback)
+		if err != nil && !errors.Is(err, ErrNoPushSubscriptions) {
+			log.Printf("DeliverChatMessage: push to user %d failed: %v", recipientID, err)
+		}
+	}()
+}
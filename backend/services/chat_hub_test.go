@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"asl-market-backend/services"
+)
+
+func receiveChatEvent(t *testing.T, events <-chan services.ChatEvent) services.ChatEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no chat event received")
+	}
+	return services.ChatEvent{}
+}
+
+func TestChatHubDeliversToEveryStreamOfUser(t *testing.T) {
+	hub := services.NewChatHub()
+	if hub.Publish(7, services.ChatEvent{Type: services.ChatEventMessage}) {
+		t.Fatal("publish to an offline user reported delivery")
+	}
+
+	tab1, close1 := hub.Subscribe(7)
+	tab2, close2 := hub.Subscribe(7)
+	other, closeOther := hub.Subscribe(8)
+	defer closeOther()
+
+	if !hub.IsOnline(7) {
+		t.Fatal("user with open streams is not online")
+	}
+	if !hub.Publish(7, services.ChatEvent{Type: services.ChatEventTyping, Chat: services.ChatKindMatching, ChatID: 3}) {
+		t.Fatal("publish to an online user reported no delivery")
+	}
+	for _, events := range []<-chan services.ChatEvent{tab1, tab2} {
+		event := receiveChatEvent(t, events)
+		if event.Type != services.ChatEventTyping || event.ChatID != 3 || event.SentAt.IsZero() {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+	select {
+	case event := <-other:
+		t.Fatalf("other user received %+v", event)
+	default:
+	}
+
+	close1()
+	close1() // closing twice is harmless
+	if _, open := <-tab1; open {
+		t.Fatal("unsubscribed stream was not closed")
+	}
+	if !hub.IsOnline(7) {
+		t.Fatal("user went offline while a stream is still open")
+	}
+	close2()
+	if hub.IsOnline(7) {
+		t.Fatal("user still online after closing every stream")
+	}
+}
+
+func TestChatHubDropsEventsForFullStream(t *testing.T) {
+	hub := services.NewChatHub()
+	events, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+
+	// A client that stops reading must not block senders
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			hub.Publish(1, services.ChatEvent{Type: services.ChatEventMessage})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a full stream")
+	}
+	if len(events) == 0 {
+		t.Fatal("buffered events were lost")
+	}
+}
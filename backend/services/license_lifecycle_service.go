@@ -0,0 +1,223 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// LicenseLifecycleService reminds users before their license expires and
+// records when it expires and when its grace period ends
+type LicenseLifecycleService struct {
+	db           *gorm.DB
+	dispatcher   *NotificationDispatcher
+	reminderDays []int
+	grace        time.Duration
+	smsPattern   string
+}
+
+// NewLicenseLifecycleService creates a license lifecycle service using the
+// license settings from the config
+func NewLicenseLifecycleService(db *gorm.DB) *LicenseLifecycleService {
+	cfg := config.AppConfig.License
+	days := make([]int, 0, len(cfg.ReminderDays))
+	for _, d := range cfg.ReminderDays {
+		if d > 0 {
+			days = append(days, d)
+		}
+	}
+	sort.Ints(days)
+
+	return &LicenseLifecycleService{
+		db:           db,
+		dispatcher:   NewNotificationDispatcher(db),
+		reminderDays: days,
+		grace:        models.LicenseGracePeriod(),
+		smsPattern:   cfg.ReminderSMSPattern,
+	}
+}
+
+// Run sends due reminders and processes expired licenses
+func (s *LicenseLifecycleService) Run() {
+	reminders := s.SendExpiryReminders()
+	expired, downgraded := s.ProcessExpiredLicenses()
+	if reminders+expired+downgraded > 0 {
+		log.Printf("License lifecycle: %d reminders, %d expired, %d downgraded", reminders, expired, downgraded)
+	}
+}
+
+// SendExpiryReminders sends one reminder per tier before a license expires.
+// A license only gets the closest tier it is inside, so a license redeemed
+// with five days left is not sent the 30 and 7 day reminders at once.
+func (s *LicenseLifecycleService) SendExpiryReminders() int {
+	if len(s.reminderDays) == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	maxDays := s.reminderDays[len(s.reminderDays)-1]
+	licenses, err := models.GetLicensesExpiringBetween(s.db, now, now.AddDate(0, 0, maxDays))
+	if err != nil {
+		log.Printf("License lifecycle: failed to load expiring licenses: %v", err)
+		return 0
+	}
+
+	sent := 0
+	for i := range licenses {
+		license := &licenses[i]
+		daysLeft := int(license.ExpiresAt.Sub(now).Hours()/24) + 1
+
+		tier := 0
+		for _, d := range s.reminderDays {
+			if daysLeft <= d {
+				tier = d
+				break
+			}
+		}
+		if tier == 0 {
+			continue
+		}
+
+		created, err := models.RecordLicenseEvent(s.db, license, models.LicenseReminderEvent(tier), fmt.Sprintf("%d days left", daysLeft))
+		if err != nil {
+			log.Printf("License lifecycle: failed to record reminder for license %d: %v", license.ID, err)
+			continue
+		}
+		if !created {
+			continue
+		}
+
+		s.notify(license, "⏰ لایسنس شما رو به پایان است",
+			fmt.Sprintf("لایسنس شما %d روز دیگر (%s) منقضی می‌شود. برای ادامه استفاده از امکانات، لایسنس خود را تمدید کنید.",
+				daysLeft, license.ExpiresAt.Format("2006-01-02")),
+			"warning")
+		s.queueReminderSMS(license, daysLeft)
+		sent++
+	}
+	return sent
+}
+
+// ProcessExpiredLicenses records licenses that expired (starting their grace
+// period) and licenses whose grace period ended, notifying their users. Only
+// the last day past each point is scanned so old licenses are not announced
+// again after a deploy.
+func (s *LicenseLifecycleService) ProcessExpiredLicenses() (int, int) {
+	now := time.Now()
+	graceEnd := now.Add(-s.grace)
+
+	expired := 0
+	if s.grace > 0 {
+		licenses, err := models.GetLicensesExpiringBetween(s.db, graceEnd, now)
+		if err != nil {
+			log.Printf("License lifecycle: failed to load expired licenses: %v", err)
+		}
+		for i := range licenses {
+			license := &licenses[i]
+			if !s.record(license, models.LicenseEventExpired) {
+				continue
+			}
+			s.notify(license, "⚠️ لایسنس شما منقضی شد",
+				fmt.Sprintf("لایسنس شما منقضی شده است. تا %s می‌توانید بدون قطع دسترسی آن را تمدید کنید.",
+					license.ExpiresAt.Add(s.grace).Format("2006-01-02 15:04")),
+				"warning")
+			expired++
+		}
+	}
+
+	downgraded := 0
+	licenses, err := models.GetLicensesExpiringBetween(s.db, graceEnd.Add(-24*time.Hour), graceEnd)
+	if err != nil {
+		log.Printf("License lifecycle: failed to load lapsed licenses: %v", err)
+	}
+	for i := range licenses {
+		license := &licenses[i]
+		if !s.record(license, models.LicenseEventDowngraded) {
+			continue
+		}
+		s.notify(license, "🔒 دسترسی لایسنس شما پایان یافت",
+			"مهلت تمدید لایسنس شما به پایان رسید و دسترسی به امکانات ویژه غیرفعال شد. با فعال‌سازی لایسنس جدید دسترسی شما بازمی‌گردد.",
+			"error")
+		downgraded++
+	}
+
+	return expired, downgraded
+}
+
+// record stores a lifecycle event and reports whether it is new
+func (s *LicenseLifecycleService) record(license *models.License, event string) bool {
+	created, err := models.RecordLicenseEvent(s.db, license, event, "")
+	if err != nil {
+		log.Printf("License lifecycle: failed to record %s for license %d: %v", event, license.ID, err)
+		return false
+	}
+	return created
+}
+
+// notify sends an in-app notification with web push to the license's user
+func (s *LicenseLifecycleService) notify(license *models.License, title, message, notificationType string) {
+	userID := *license.UsedBy
+	sendPush := true
+	_, err := s.dispatcher.CreateAndDispatch(userID, models.CreateNotificationRequest{
+		Title:      title,
+		Message:    message,
+		Type:       notificationType,
+		Priority:   "high",
+		UserID:     &userID,
+		ActionURL:  "/license",
+		ActionText: "تمدید لایسنس",
+		SendPush:   &sendPush,
+	})
+	if err != nil {
+		log.Printf("License lifecycle: failed to notify user %d: %v", userID, err)
+	}
+}
+
+// queueReminderSMS queues the reminder SMS in the outbox, which holds it
+// back during quiet hours and counts it toward the daily SMS limit; the
+// in-app reminder is sent either way
+func (s *LicenseLifecycleService) queueReminderSMS(license *models.License, daysLeft int) {
+	if license.User == nil {
+		return
+	}
+	if strings.TrimSpace(s.smsPattern) == "" {
+		log.Printf("License reminder SMS pattern not configured, skipping SMS to user %d", license.User.ID)
+		return
+	}
+	phone := ValidateIranianPhoneNumber(license.User.Mobile())
+	if phone == "" {
+		return
+	}
+	name := strings.TrimSpace(license.User.Name())
+	text := fmt.Sprintf("%s عزیز، لایسنس شما %d روز دیگر منقضی می‌شود.", name, daysLeft)
+	job := NewPatternSMSJob(license.User.ID, "", phone, text, strings.TrimSpace(s.smsPattern), map[string]string{
+		"name": name,
+		"days": strconv.Itoa(daysLeft),
+	})
+	if err := models.EnqueueNotificationJobs(s.db, []models.NotificationJob{job}); err != nil {
+		log.Printf("License lifecycle: failed to queue reminder SMS for user %d: %v", license.User.ID, err)
+	}
+}
+
+// StartLicenseLifecycleScheduler runs the license lifecycle job every hour
+func StartLicenseLifecycleScheduler() {
+	go func() {
+		service := NewLicenseLifecycleService(models.GetDB())
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		// Run immediately on startup to catch anything missed while down
+		service.Run()
+
+		for range ticker.C {
+			service.Run()
+		}
+	}()
+}
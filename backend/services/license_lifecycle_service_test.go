@@ -0,0 +1,118 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+)
+
+func expireLicenseAt(t *testing.T, license *models.License, at time.Time) {
+	t.Helper()
+	if err := models.GetDB().Model(license).Update("expires_at", at).Error; err != nil {
+		t.Fatalf("set expires_at: %v", err)
+	}
+}
+
+func licenseEvents(t *testing.T, userID uint) map[string]int {
+	t.Helper()
+	events, err := models.GetUserLicenseEvents(models.GetDB(), userID)
+	if err != nil {
+		t.Fatalf("license events: %v", err)
+	}
+	counts := map[string]int{}
+	for _, e := range events {
+		counts[e.Event]++
+	}
+	return counts
+}
+
+func TestLicenseRemindersSendClosestTierOnce(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	license := testutil.GrantLicense(t, db, user.ID, "plus")
+	expireLicenseAt(t, license, time.Now().Add(5*24*time.Hour+time.Hour))
+
+	config.AppConfig.License.ReminderSMSPattern = "reminder-pattern"
+	t.Cleanup(func() { config.AppConfig.License.ReminderSMSPattern = "" })
+
+	service := services.NewLicenseLifecycleService(db)
+	if sent := service.SendExpiryReminders(); sent != 1 {
+		t.Fatalf("first run sent %d reminders, want 1", sent)
+	}
+	if sent := service.SendExpiryReminders(); sent != 0 {
+		t.Fatalf("second run sent %d reminders, want 0", sent)
+	}
+
+	events := licenseEvents(t, user.ID)
+	if events[models.LicenseReminderEvent(7)] != 1 || events[models.LicenseReminderEvent(30)] != 0 {
+		t.Fatalf("events = %v, want only the 7 day reminder", events)
+	}
+	if got := unreadCount(t, user.ID); got != 1 {
+		t.Fatalf("unread notifications = %d, want 1", got)
+	}
+
+	// The SMS goes through the outbox so quiet hours and the daily limit apply
+	var jobs []models.NotificationJob
+	db.Where("channel = ? AND user_id = ?", models.NotificationChannelSMS, user.ID).Find(&jobs)
+	if len(jobs) != 1 || !strings.Contains(jobs[0].Payload, "reminder-pattern") {
+		t.Fatalf("reminder SMS jobs = %+v", jobs)
+	}
+}
+
+func TestLicenseRemindersSkipRenewedUsers(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	license := testutil.GrantLicense(t, db, user.ID, "plus")
+	expireLicenseAt(t, license, time.Now().Add(2*24*time.Hour))
+
+	codes, err := models.GenerateLicenses(db, 1, "plus", user.ID)
+	if err != nil {
+		t.Fatalf("generate licenses: %v", err)
+	}
+	if _, err := models.UseLicense(db, codes[0], user.ID); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+
+	if sent := services.NewLicenseLifecycleService(db).SendExpiryReminders(); sent != 0 {
+		t.Fatalf("renewed user got %d reminders", sent)
+	}
+}
+
+func TestExpiredLicenseEntersGraceThenDowngrades(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	license := testutil.GrantLicense(t, db, user.ID, "plus")
+	grace := models.LicenseGracePeriod()
+
+	expireLicenseAt(t, license, time.Now().Add(-time.Hour))
+	service := services.NewLicenseLifecycleService(db)
+	if expired, downgraded := service.ProcessExpiredLicenses(); expired != 1 || downgraded != 0 {
+		t.Fatalf("expired=%d downgraded=%d, want 1 and 0", expired, downgraded)
+	}
+
+	access, err := models.GetUserLicenseAccess(db, user.ID, grace)
+	if err != nil {
+		t.Fatalf("license access: %v", err)
+	}
+	if !access.HasLicense || !access.InGrace {
+		t.Fatalf("access = %+v, want in grace", access)
+	}
+
+	expireLicenseAt(t, license, time.Now().Add(-grace-time.Hour))
+	if expired, downgraded := service.ProcessExpiredLicenses(); expired != 0 || downgraded != 1 {
+		t.Fatalf("expired=%d downgraded=%d, want 0 and 1", expired, downgraded)
+	}
+	if _, downgraded := service.ProcessExpiredLicenses(); downgraded != 0 {
+		t.Fatal("downgrade recorded twice")
+	}
+
+	access, _ = models.GetUserLicenseAccess(db, user.ID, grace)
+	if access.HasLicense {
+		t.Fatalf("access after grace = %+v, want none", access)
+	}
+}
@@ -1,6 +1,10 @@
 package services
 
 import (
+	"fmt"
+
+	"asl-market-backend/models"
+
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -59,7 +63,7 @@ func (s *TelegramService) handleLicenseTypeSelection(chatID int64, licenseType s
 	message := "➕ **تولید لایسنس " + licenseTypeName + "**\n\n" +
 		"📝 لطفا تعداد لایسنس‌هایی که می‌خواهید تولید کنید را وارد کنید:\n\n" +
 		"🔢 **حداقل:** 1 لایسنس\n" +
-		"🔢 **حداکثر:** 100 لایسنس\n\n" +
+		"🔢 **حداکثر:** " + fmt.Sprint(models.MaxLicenseBatchSize) + " لایسنس\n\n" +
 		"⌨️ لطفا عدد مورد نظر را وارد کنید:"
 
 	msg := tgbotapi.NewMessage(chatID, message)
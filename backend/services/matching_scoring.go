@@ -0,0 +1,366 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// MatchingWeights holds the maximum points each scoring signal can contribute
+type MatchingWeights struct {
+	Country      float64 `json:"country"`
+	Product      float64 `json:"product"`
+	Language     float64 `json:"language"`
+	Rating       float64 `json:"rating"`
+	ResponseRate float64 `json:"response_rate"`
+	Experience   float64 `json:"experience"`
+	Featured     float64 `json:"featured"`
+}
+
+// MatchingScoreOptions controls filtering of scored visitors
+type MatchingScoreOptions struct {
+	Weights             MatchingWeights
+	RequireCountryMatch bool
+	MinScore            float64
+	TopN                int
+}
+
+// VisitorScoreBreakdown explains how a visitor's matching score was computed
+type VisitorScoreBreakdown struct {
+	Total            float64  `json:"total"`
+	Country          float64  `json:"country"`
+	Product          float64  `json:"product"`
+	Language         float64  `json:"language"`
+	Rating           float64  `json:"rating"`
+	ResponseRate     float64  `json:"response_rate"`
+	Experience       float64  `json:"experience"`
+	Featured         float64  `json:"featured"`
+	MatchedCountries []string `json:"matched_countries"`
+	AverageRating    float64  `json:"average_rating"`
+	RatingCount      int      `json:"rating_count"`
+	ResponseRatePct  float64  `json:"response_rate_percent"`
+	Reasons          []string `json:"reasons"`
+}
+
+// VisitorMatch is a visitor with its score for a matching request
+type VisitorMatch struct {
+	Visitor models.Visitor        `json:"visitor"`
+	Score   VisitorScoreBreakdown `json:"score"`
+}
+
+// visitorHistory is the per-visitor track record used for scoring
+type visitorHistory struct {
+	averageRating float64
+	ratingCount   int
+	notified      int
+	responded     int
+}
+
+// countryAliases maps ISO codes to the names and cities users type for them.
+// Ambiguous cities (e.g. "صور", which exists in Oman and Lebanon) are left out.
+var countryAliases = map[string][]string{
+	"AE": {"امارات", "امارات متحده عربی", "دبی", "ابوظبی", "شارجه", "عجمان", "راس الخیمه", "راس الخيمه", "فجیره", "ام القوین", "uae", "emirates", "dubai", "abu dhabi", "sharjah", "ajman"},
+	"SA": {"عربستان", "سعودی", "ریاض", "جده", "دمام", "مکه", "مدینه", "طائف", "ابها", "saudi", "ksa", "riyadh", "jeddah", "dammam"},
+	"KW": {"کویت", "الاحمدی", "حولی", "الفروانیه", "الجهراء", "kuwait"},
+	"QA": {"قطر", "دوحه", "الریان", "الوکره", "الخور", "qatar", "doha"},
+	"BH": {"بحرین", "منامه", "المحرق", "رفاع", "bahrain", "manama"},
+	"OM": {"عمان", "مسقط", "صلاله", "نزوا", "صحار", "البريمي", "oman", "muscat", "salalah"},
+	"YE": {"یمن", "صنعا", "عدن", "تعز", "حدیده", "yemen", "sanaa"},
+	"JO": {"اردن", "زرقا", "اربد", "عقبه", "jordan", "amman", "aqaba"},
+	"LB": {"لبنان", "بیروت", "صیدا", "lebanon", "beirut"},
+	"IQ": {"عراق", "بغداد", "بصره", "موصل", "کربلا", "نجف", "اربیل", "iraq", "baghdad", "basra", "erbil"},
+	"EG": {"مصر", "قاهره", "اسکندریه", "جیزه", "شرم الشیخ", "egypt", "cairo", "alexandria"},
+}
+
+// GetMatchingScoreOptions builds scoring options from the matching config section
+func GetMatchingScoreOptions() MatchingScoreOptions {
+	opts := MatchingScoreOptions{
+		Weights: MatchingWeights{
+			Country: 40, Product: 25, Language: 10, Rating: 10,
+			ResponseRate: 10, Experience: 3, Featured: 2,
+		},
+		RequireCountryMatch: true,
+		MinScore:            30,
+		TopN:                50,
+	}
+	if config.AppConfig == nil {
+		return opts
+	}
+
+	cfg := config.AppConfig.Matching
+	opts.Weights = MatchingWeights{
+		Country:      cfg.CountryWeight,
+		Product:      cfg.ProductWeight,
+		Language:     cfg.LanguageWeight,
+		Rating:       cfg.RatingWeight,
+		ResponseRate: cfg.ResponseRateWeight,
+		Experience:   cfg.ExperienceWeight,
+		Featured:     cfg.FeaturedWeight,
+	}
+	opts.RequireCountryMatch = cfg.RequireCountryMatch
+	opts.MinScore = cfg.MinScore
+	opts.TopN = cfg.TopN
+	return opts
+}
+
+// resolveCountryCodes returns the country codes mentioned in free text
+func resolveCountryCodes(text string) map[string]bool {
+	codes := make(map[string]bool)
+	lower := strings.ToLower(text)
+	for _, part := range parseCountries(text) {
+		if code := strings.ToUpper(strings.TrimSpace(part)); len(code) == 2 {
+			if _, ok := countryAliases[code]; ok {
+				codes[code] = true
+			}
+		}
+	}
+	for code, aliases := range countryAliases {
+		for _, alias := range aliases {
+			if strings.Contains(lower, alias) {
+				codes[code] = true
+				break
+			}
+		}
+	}
+	return codes
+}
+
+// productMatchRatio returns 0..1 for how well the visitor's interests cover the product
+func productMatchRatio(interests, productName string) float64 {
+	productLower := strings.ToLower(strings.TrimSpace(productName))
+	if productLower == "" || strings.TrimSpace(interests) == "" {
+		return 0
+	}
+
+	productWords := strings.Fields(productLower)
+	best := 0.0
+	for _, interest := range parseProducts(interests) {
+		interestLower := strings.ToLower(interest)
+		if strings.Contains(interestLower, productLower) || strings.Contains(productLower, interestLower) {
+			return 1
+		}
+		// Partial credit for shared words ("زعفران سرگل" vs "زعفران")
+		shared := 0
+		for _, word := range productWords {
+			if len([]rune(word)) > 1 && strings.Contains(interestLower, word) {
+				shared++
+			}
+		}
+		if len(productWords) > 0 {
+			if ratio := float64(shared) / float64(len(productWords)); ratio > best {
+				best = ratio
+			}
+		}
+	}
+	return best
+}
+
+// languageRatio maps a visitor language level to 0..1
+func languageRatio(level string) float64 {
+	switch strings.ToLower(level) {
+	case "excellent":
+		return 1
+	case "good":
+		return 0.75
+	case "weak":
+		return 0.5
+	}
+	return 0
+}
+
+// loadVisitorHistory batch-loads ratings and response rates for visitors
+func loadVisitorHistory(db *gorm.DB, visitors []models.Visitor) map[uint]*visitorHistory {
+	history := make(map[uint]*visitorHistory, len(visitors))
+	if len(visitors) == 0 {
+		return history
+	}
+
+	visitorIDs := make([]uint, 0, len(visitors))
+	userToVisitor := make(map[uint]uint, len(visitors))
+	userIDs := make([]uint, 0, len(visitors))
+	for _, v := range visitors {
+		history[v.ID] = &visitorHistory{}
+		visitorIDs = append(visitorIDs, v.ID)
+		if v.UserID > 0 {
+			userIDs = append(userIDs, v.UserID)
+			userToVisitor[v.UserID] = v.ID
+		}
+	}
+
+	var ratings []struct {
+		RatedID       uint
+		AverageRating float64
+		TotalRatings  int
+	}
+	db.Model(&models.MatchingRating{}).
+		Select("rated_id, AVG(rating) as average_rating, COUNT(*) as total_ratings").
+		Where("rated_id IN ? AND rated_type = ?", userIDs, "visitor").
+		Group("rated_id").Scan(&ratings)
+	for _, r := range ratings {
+		if h, ok := history[userToVisitor[r.RatedID]]; ok {
+			h.averageRating = r.AverageRating
+			h.ratingCount = r.TotalRatings
+		}
+	}
+
+	var notified []struct {
+		VisitorID uint
+		Total     int
+	}
+	db.Model(&models.MatchingNotification{}).
+		Select("visitor_id, COUNT(DISTINCT matching_request_id) as total").
+		Where("visitor_id IN ?", visitorIDs).
+		Group("visitor_id").Scan(&notified)
+	for _, n := range notified {
+		if h, ok := history[n.VisitorID]; ok {
+			h.notified = n.Total
+		}
+	}
+
+	var responded []struct {
+		VisitorID uint
+		Total     int
+	}
+	db.Model(&models.MatchingResponse{}).
+		Select("visitor_id, COUNT(DISTINCT matching_request_id) as total").
+		Where("visitor_id IN ?", visitorIDs).
+		Group("visitor_id").Scan(&responded)
+	for _, r := range responded {
+		if h, ok := history[r.VisitorID]; ok {
+			h.responded = r.Total
+		}
+	}
+
+	return history
+}
+
+// scoreVisitor computes the score breakdown of one visitor for a request.
+// The second return value is false when the visitor must be excluded.
+func scoreVisitor(request *models.MatchingRequest, requestCountries map[string]bool, visitor *models.Visitor, h *visitorHistory, opts MatchingScoreOptions) (VisitorScoreBreakdown, bool) {
+	w := opts.Weights
+	b := VisitorScoreBreakdown{MatchedCountries: []string{}, Reasons: []string{}}
+
+	// 1. Country overlap
+	if len(requestCountries) > 0 {
+		visitorCountries := resolveCountryCodes(visitor.DestinationCities)
+		for code := range requestCountries {
+			if visitorCountries[code] {
+				b.MatchedCountries = append(b.MatchedCountries, code)
+			}
+		}
+		sort.Strings(b.MatchedCountries)
+		if len(b.MatchedCountries) == 0 {
+			if opts.RequireCountryMatch {
+				return b, false
+			}
+		} else {
+			b.Country = w.Country * float64(len(b.MatchedCountries)) / float64(len(requestCountries))
+			b.Reasons = append(b.Reasons, "فعالیت در کشورهای مقصد: "+strings.Join(b.MatchedCountries, ", "))
+		}
+	} else {
+		// Request destinations could not be resolved; don't penalise anyone
+		b.Country = w.Country / 2
+	}
+
+	// 2. Product interest
+	if ratio := productMatchRatio(visitor.InterestedProducts, request.ProductName); ratio > 0 {
+		b.Product = w.Product * ratio
+		b.Reasons = append(b.Reasons, "علاقه‌مند به محصول مشابه")
+	}
+
+	// 3. Language level
+	if ratio := languageRatio(visitor.LanguageLevel); ratio > 0 {
+		b.Language = w.Language * ratio
+		if ratio == 1 {
+			b.Reasons = append(b.Reasons, "سطح زبان عالی")
+		}
+	}
+
+	// 4. Past ratings (neutral when the visitor has no ratings yet)
+	b.AverageRating = math.Round(h.averageRating*10) / 10
+	b.RatingCount = h.ratingCount
+	if h.ratingCount > 0 {
+		b.Rating = w.Rating * h.averageRating / 5
+		if h.averageRating >= 4 {
+			b.Reasons = append(b.Reasons, "امتیاز بالا در همکاری‌های قبلی")
+		}
+	} else {
+		b.Rating = w.Rating / 2
+	}
+
+	// 5. Response rate (neutral when the visitor was never notified)
+	if h.notified > 0 {
+		rate := float64(h.responded) / float64(h.notified)
+		if rate > 1 {
+			rate = 1
+		}
+		b.ResponseRatePct = math.Round(rate * 100)
+		b.ResponseRate = w.ResponseRate * rate
+		if rate >= 0.5 {
+			b.Reasons = append(b.Reasons, "پاسخگویی بالا به درخواست‌ها")
+		}
+	} else {
+		b.ResponseRate = w.ResponseRate / 2
+	}
+
+	// 6. Marketing experience and featured bonus
+	if visitor.HasMarketingExperience {
+		b.Experience = w.Experience
+		b.Reasons = append(b.Reasons, "سابقه بازاریابی")
+	}
+	if visitor.IsFeatured {
+		b.Featured = w.Featured
+	}
+
+	b.Total = b.Country + b.Product + b.Language + b.Rating + b.ResponseRate + b.Experience + b.Featured
+	roundScores(&b)
+	return b, b.Total >= opts.MinScore
+}
+
+func roundScores(b *VisitorScoreBreakdown) {
+	for _, v := range []*float64{&b.Total, &b.Country, &b.Product, &b.Language, &b.Rating, &b.ResponseRate, &b.Experience, &b.Featured} {
+		*v = math.Round(*v*10) / 10
+	}
+}
+
+// ScoreVisitors scores approved visitors for a matching request and returns
+// those above the minimum score, best first, capped at TopN.
+func (s *MatchingService) ScoreVisitors(request *models.MatchingRequest, opts MatchingScoreOptions) ([]VisitorMatch, error) {
+	var visitors []models.Visitor
+	if err := s.db.Preload("User").Where("status = ?", "approved").Find(&visitors).Error; err != nil {
+		return nil, err
+	}
+
+	requestCountries := resolveCountryCodes(request.DestinationCountries)
+	history := loadVisitorHistory(s.db, visitors)
+
+	matches := make([]VisitorMatch, 0, len(visitors))
+	for i := range visitors {
+		score, ok := scoreVisitor(request, requestCountries, &visitors[i], history[visitors[i].ID], opts)
+		if ok {
+			matches = append(matches, VisitorMatch{Visitor: visitors[i], Score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score.Total != matches[j].Score.Total {
+			return matches[i].Score.Total > matches[j].Score.Total
+		}
+		// Tie-break on most recently approved
+		ai, aj := matches[i].Visitor.ApprovedAt, matches[j].Visitor.ApprovedAt
+		if ai == nil || aj == nil {
+			return ai != nil
+		}
+		return ai.After(*aj)
+	})
+
+	if opts.TopN > 0 && len(matches) > opts.TopN {
+		matches = matches[:opts.TopN]
+	}
+	return matches, nil
+}
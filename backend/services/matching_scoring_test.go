@@ -0,0 +1,53 @@
+package services_test
+
+import (
+	"testing"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+)
+
+func TestScoreVisitorsRanksCountryAndProductMatches(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	dubaiSaffron := testutil.CreateVisitor(t, db, testutil.CreateUser(t, db, "09120000001").ID, "دبی، ابوظبی", "زعفران، خرما")
+	dubaiOnly := testutil.CreateVisitor(t, db, testutil.CreateUser(t, db, "09120000002").ID, "Dubai", "فرش")
+	testutil.CreateVisitor(t, db, testutil.CreateUser(t, db, "09120000003").ID, "مسقط", "زعفران")
+
+	request := &models.MatchingRequest{ProductName: "زعفران", DestinationCountries: "AE"}
+	matches, err := services.NewMatchingService(db).ScoreVisitors(request, services.GetMatchingScoreOptions())
+	if err != nil {
+		t.Fatalf("score visitors: %v", err)
+	}
+
+	if len(matches) != 2 {
+		t.Fatalf("got %d matches, want 2 (visitor outside AE filtered)", len(matches))
+	}
+	if matches[0].Visitor.ID != dubaiSaffron.ID || matches[1].Visitor.ID != dubaiOnly.ID {
+		t.Fatalf("ranking = [%d %d], want [%d %d]", matches[0].Visitor.ID, matches[1].Visitor.ID, dubaiSaffron.ID, dubaiOnly.ID)
+	}
+	if matches[0].Score.Product == 0 || matches[1].Score.Product != 0 {
+		t.Fatalf("product points = %v / %v", matches[0].Score.Product, matches[1].Score.Product)
+	}
+	if len(matches[0].Score.MatchedCountries) == 0 || matches[0].Score.MatchedCountries[0] != "AE" {
+		t.Fatalf("matched countries = %v, want [AE]", matches[0].Score.MatchedCountries)
+	}
+}
+
+func TestScoreVisitorsWithoutCountryRequirement(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	testutil.CreateVisitor(t, db, testutil.CreateUser(t, db, "09120000001").ID, "مسقط", "زعفران")
+
+	opts := services.GetMatchingScoreOptions()
+	opts.RequireCountryMatch = false
+	opts.MinScore = 0
+
+	request := &models.MatchingRequest{ProductName: "زعفران", DestinationCountries: "AE"}
+	matches, err := services.NewMatchingService(db).ScoreVisitors(request, opts)
+	if err != nil {
+		t.Fatalf("score visitors: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Score.Country != 0 {
+		t.Fatalf("matches = %+v, want one visitor without country points", matches)
+	}
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -21,129 +20,19 @@ func NewMatchingService(db *gorm.DB) *MatchingService {
 	return &MatchingService{db: db}
 }
 
-// FindMatchingVisitors finds suitable visitors for a matching request
-// TODO: When visitor count increases, add country matching logic to filter visitors
-// based on their destination cities matching request's destination countries.
-// Currently, all approved visitors are matched to help with low visitor count.
+// FindMatchingVisitors finds suitable visitors for a matching request, best
+// match first. Scoring weights and thresholds come from the matching config.
 func (s *MatchingService) FindMatchingVisitors(matchingRequest *models.MatchingRequest) ([]models.Visitor, error) {
-	// Get all approved visitors
-	var allVisitors []models.Visitor
-	if err := s.db.Preload("User").Where("status = ?", "approved").Find(&allVisitors).Error; err != nil {
+	matches, err := s.ScoreVisitors(matchingRequest, GetMatchingScoreOptions())
+	if err != nil {
 		return nil, err
 	}
 
-	// TODO: Add country matching filter when visitor count increases
-	// For now, return all approved visitors
-	// Parse destination countries from matching request (for future use)
-	// requestCountries := parseCountries(matchingRequest.DestinationCountries)
-
-	// Filter and score visitors
-	var matchedVisitors []models.Visitor
-	scores := make(map[uint]int) // visitor ID -> score
-
-	for _, visitor := range allVisitors {
-		// TODO: Add country matching check here when visitor count increases
-		// For now, give all visitors a base score
-		score := s.calculateMatchingScore(matchingRequest, &visitor, nil) // Pass nil for now
-		if score > 0 {
-			matchedVisitors = append(matchedVisitors, visitor)
-			scores[visitor.ID] = score
-		}
-	}
-
-	// Sort by score (highest first)
-	// Simple bubble sort for now (can be optimized)
-	for i := 0; i < len(matchedVisitors)-1; i++ {
-		for j := i + 1; j < len(matchedVisitors); j++ {
-			if scores[matchedVisitors[i].ID] < scores[matchedVisitors[j].ID] {
-				matchedVisitors[i], matchedVisitors[j] = matchedVisitors[j], matchedVisitors[i]
-			}
-		}
+	visitors := make([]models.Visitor, 0, len(matches))
+	for _, match := range matches {
+		visitors = append(visitors, match.Visitor)
 	}
-
-	// Send to ALL matching visitors (no limit)
-	return matchedVisitors, nil
-}
-
-// calculateMatchingScore calculates a matching score for a visitor
-// Returns 0 if visitor doesn't match at all
-// TODO: When visitor count increases, re-enable country matching check
-// Currently, all approved visitors get a score regardless of country match
-func (s *MatchingService) calculateMatchingScore(request *models.MatchingRequest, visitor *models.Visitor, requestCountries []string) int {
-	score := 0
-
-	// TODO: Re-enable country matching when visitor count increases
-	// 1. Country match (highest priority - 50 points)
-	// visitorCountries := parseCountries(visitor.DestinationCities)
-	// countryMatch := false
-	// for _, reqCountry := range requestCountries {
-	// 	for _, visCountry := range visitorCountries {
-	// 		if strings.EqualFold(strings.TrimSpace(reqCountry), strings.TrimSpace(visCountry)) {
-	// 			countryMatch = true
-	// 			score += 50
-	// 			break
-	// 		}
-	// 	}
-	// 	if countryMatch {
-	// 		break
-	// 	}
-	// }
-	//
-	// // If no country match, return 0 (visitor is not suitable)
-	// if !countryMatch {
-	// 	return 0
-	// }
-
-	// For now, give base score to all approved visitors
-	// This allows all visitors to see all requests
-	if requestCountries == nil {
-		// No country filtering - give base score
-		score += 50 // Base score for all approved visitors
-	}
-
-	// 2. Product interest match (30 points)
-	if visitor.InterestedProducts != "" {
-		interestedProducts := parseProducts(visitor.InterestedProducts)
-		productNameLower := strings.ToLower(request.ProductName)
-		for _, product := range interestedProducts {
-			if strings.Contains(strings.ToLower(product), productNameLower) ||
-				strings.Contains(productNameLower, strings.ToLower(product)) {
-				score += 30
-				break
-			}
-		}
-	}
-
-	// 3. Language level (20 points)
-	// Excellent = 20, Good = 15, Weak = 10, None = 0
-	switch strings.ToLower(visitor.LanguageLevel) {
-	case "excellent":
-		score += 20
-	case "good":
-		score += 15
-	case "weak":
-		score += 10
-	}
-
-	// 4. Marketing experience (10 points)
-	if visitor.HasMarketingExperience {
-		score += 10
-	}
-
-	// 5. Featured visitor bonus (5 points)
-	if visitor.IsFeatured {
-		score += 5
-	}
-
-	// 6. Recent activity (5 points) - if visitor was approved recently
-	if visitor.ApprovedAt != nil {
-		daysSinceApproval := time.Since(*visitor.ApprovedAt).Hours() / 24
-		if daysSinceApproval < 30 {
-			score += 5
-		}
-	}
-
-	return score
+	return visitors, nil
 }
 
 // parseCountries parses comma-separated (Persian or English) countries
@@ -177,111 +66,82 @@ func parseProducts(productsStr string) []string {
 	return parseCountries(productsStr) // Same logic
 }
 
-// SendMatchingNotifications sends notifications to matched visitors
+// SendMatchingNotifications queues the in-app notification, web push and
+// (for licensed visitors) SMS of a new request for every matched visitor. A
+// MatchingNotification row tracks each delivery; the notification outbox
+// sends them and records the outcome.
 func (s *MatchingService) SendMatchingNotifications(matchingRequest *models.MatchingRequest, visitors []models.Visitor) error {
-	smsService := GetSMSService()
-
+	title := "درخواست Matching جدید"
+	inAppMessage := fmt.Sprintf("درخواست جدید برای فروش %s در %s", matchingRequest.ProductName, matchingRequest.DestinationCountries)
+	smsMessage := fmt.Sprintf(
+		"درخواست جدید برای فروش %s در %s. مبلغ: %s %s. برای مشاهده جزئیات وارد اپلیکیشن شوید.",
+		matchingRequest.ProductName,
+		matchingRequest.DestinationCountries,
+		matchingRequest.Price,
+		matchingRequest.Currency,
+	)
+	actionURL := fmt.Sprintf("/matching/requests/%d", matchingRequest.ID)
+	pushMessage := PushMessage{
+		Title:   title,
+		Message: inAppMessage,
+		Icon:    "/pwa.png",
+		Tag:     fmt.Sprintf("matching-%d", matchingRequest.ID),
+		Urgency: PushUrgencyHigh,
+		Data: map[string]interface{}{
+			"url":  actionURL,
+			"type": "matching",
+		},
+	}
+
+	var rows []models.MatchingNotification
+	var jobs []models.NotificationJob
 	for _, visitor := range visitors {
-		// Create notification record
-		notification := models.MatchingNotification{
-			MatchingRequestID: matchingRequest.ID,
-			VisitorID:         visitor.ID,
-			UserID:            visitor.UserID,
-			NotificationType:  "in_app",
-			Status:            "pending",
-		}
-
-		// Send SMS (if visitor has license and is approved)
-		if visitor.Status == "approved" && visitor.UserID > 0 {
-			// Check if user has active license
-			hasLicense, _ := models.CheckUserLicense(s.db, visitor.UserID)
-			if hasLicense && smsService != nil {
-				// Get user phone
-				var user models.User
-				if err := s.db.First(&user, visitor.UserID).Error; err == nil {
-					phoneNumber := ValidateIranianPhoneNumber(user.Phone)
-					if phoneNumber != "" {
-						message := fmt.Sprintf(
-							"درخواست جدید برای فروش %s در %s. مبلغ: %s %s. برای مشاهده جزئیات وارد اپلیکیشن شوید.",
-							matchingRequest.ProductName,
-							matchingRequest.DestinationCountries,
-							matchingRequest.Price,
-							matchingRequest.Currency,
-						)
-
-						// Try to send SMS
-						err := smsService.SendSimpleSMS(phoneNumber, message)
-						if err == nil {
-							notification.NotificationType = "sms"
-							notification.Status = "sent"
-							now := time.Now()
-							notification.SentAt = &now
-							notification.Message = message
-						} else {
-							notification.Status = "failed"
-							notification.Error = err.Error()
-							log.Printf("Failed to send SMS to visitor %d: %v", visitor.ID, err)
-						}
-					}
-				}
-			}
-		}
-
-		// Create in-app notification
-		if notification.Status == "pending" {
-			notification.NotificationType = "in_app"
-			notification.Status = "sent"
-			now := time.Now()
-			notification.SentAt = &now
-			notification.Message = fmt.Sprintf(
-				"درخواست جدید برای فروش %s در %s",
-				matchingRequest.ProductName,
-				matchingRequest.DestinationCountries,
-			)
+		if visitor.UserID == 0 {
+			continue
 		}
-
-		// Save notification
-		if err := s.db.Create(&notification).Error; err != nil {
-			log.Printf("Failed to create matching notification: %v", err)
+		add := func(job models.NotificationJob) {
+			rows = append(rows, models.MatchingNotification{
+				MatchingRequestID: matchingRequest.ID,
+				VisitorID:         visitor.ID,
+				UserID:            visitor.UserID,
+				NotificationType:  job.Channel,
+				Status:            "pending",
+				Message:           job.Message,
+			})
+			jobs = append(jobs, job)
 		}
 
-		// Create in-app notification for user
-		if visitor.UserID > 0 {
-			visitorUserID := visitor.UserID
-			inAppNotification := models.Notification{
-				UserID:      &visitorUserID,
-				Title:       "درخواست Matching جدید",
-				Message:     fmt.Sprintf("درخواست جدید برای فروش %s در %s", matchingRequest.ProductName, matchingRequest.DestinationCountries),
-				Type:        "matching",
-				Priority:    "high",
-				IsRead:      false,
-				CreatedByID: matchingRequest.UserID, // Created by supplier
-				ActionURL:   fmt.Sprintf("/matching/requests/%d", matchingRequest.ID),
-			}
-			if err := s.db.Create(&inAppNotification).Error; err != nil {
-				log.Printf("Failed to create in-app notification: %v", err)
+		add(NewInAppJob(visitor.UserID, models.NotificationTopicMatchingRequests, title, inAppMessage, InAppNotificationPayload{
+			Type:        "matching",
+			Priority:    "high",
+			ActionURL:   actionURL,
+			CreatedByID: matchingRequest.UserID, // Created by supplier
+		}))
+		add(NewPushJob(visitor.UserID, models.NotificationTopicMatchingRequests, pushMessage))
+
+		// SMS only reaches approved visitors with an active license (or one in
+		// its grace period)
+		if visitor.Status == "approved" {
+			if access, err := models.GetUserLicenseAccess(s.db, visitor.UserID, models.LicenseGracePeriod()); err == nil && access.HasLicense {
+				add(NewSMSJob(visitor.UserID, models.NotificationTopicMatchingRequests, "", smsMessage))
 			}
+		}
+	}
 
-			// Send push notification
-			pushService := GetPushNotificationService()
-			pushMessage := PushMessage{
-				Title:   "درخواست Matching جدید",
-				Message: fmt.Sprintf("درخواست جدید برای فروش %s در %s", matchingRequest.ProductName, matchingRequest.DestinationCountries),
-				Icon:    "/pwa.png",
-				Tag:     fmt.Sprintf("matching-%d", matchingRequest.ID),
-				Data: map[string]interface{}{
-					"url":  fmt.Sprintf("/matching/requests/%d", matchingRequest.ID),
-					"type": "matching",
-				},
-			}
-			if err := pushService.SendPushNotification(visitorUserID, pushMessage); err != nil {
-				log.Printf("Failed to send push notification: %v", err)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			if err := tx.CreateInBatches(&rows, 500).Error; err != nil {
+				return err
 			}
 		}
-
-		// Send Telegram notification to admin (optional)
-		// Note: We skip this to avoid spam. Matching notifications are sent via in-app notifications.
-		// If needed, we can add a method to send to admins later.
+		for i := range jobs {
+			jobs[i].SourceType = models.NotificationJobSourceMatching
+			jobs[i].SourceID = rows[i].ID
+		}
+		return models.EnqueueNotificationJobs(tx, jobs)
+	})
+	if err != nil {
+		return err
 	}
 
 	// Update matched visitor count
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// NotificationDispatcher sends admin notifications: it resolves the audience,
+// publishes the in-app notification and queues web push and SMS deliveries
+// in the notification outbox
+type NotificationDispatcher struct {
+	db *gorm.DB
+}
+
+// NewNotificationDispatcher creates a new notification dispatcher
+func NewNotificationDispatcher(db *gorm.DB) *NotificationDispatcher {
+	return &NotificationDispatcher{db: db}
+}
+
+// CreateAndDispatch creates a notification and sends it right away unless it
+// is scheduled for later
+func (d *NotificationDispatcher) CreateAndDispatch(createdByID uint, req models.CreateNotificationRequest) (*models.Notification, error) {
+	notification, err := models.CreateNotification(d.db, createdByID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if notification.ScheduledAt != nil && notification.ScheduledAt.After(time.Now()) {
+		return notification, nil
+	}
+	return d.Dispatch(notification.ID)
+}
+
+// Dispatch sends a due scheduled notification. It is a no-op returning the
+// current state if the notification was already claimed by another worker.
+func (d *NotificationDispatcher) Dispatch(notificationID uint) (*models.Notification, error) {
+	claimed, err := models.ClaimNotificationForSending(d.db, notificationID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return models.GetNotification(d.db, notificationID)
+	}
+
+	notification, err := models.GetNotification(d.db, notificationID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := models.ResolveNotificationRecipients(d.db, notification)
+	if err != nil {
+		d.releaseClaim(notificationID)
+		return nil, fmt.Errorf("failed to resolve notification audience: %v", err)
+	}
+
+	hidden, err := d.inAppOptOuts(notification, recipients)
+	if err != nil {
+		d.releaseClaim(notificationID)
+		return nil, err
+	}
+
+	jobs, err := d.deliveryJobs(notification, recipients)
+	if err != nil {
+		d.releaseClaim(notificationID)
+		return nil, err
+	}
+
+	// Publishing and queueing the deliveries commit together, so a published
+	// notification never loses its push and SMS
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		if err := models.PublishNotification(tx, notification, recipients, hidden); err != nil {
+			return err
+		}
+		return models.EnqueueNotificationJobs(tx, jobs)
+	})
+	if err != nil {
+		d.releaseClaim(notificationID)
+		return nil, err
+	}
+
+	log.Printf("Notification %d sent to %d recipients (%d push and SMS deliveries queued)",
+		notificationID, len(recipients), len(jobs))
+
+	return models.GetNotification(d.db, notificationID)
+}
+
+// DispatchDue sends every scheduled notification whose time has come
+func (d *NotificationDispatcher) DispatchDue() int {
+	ids, err := models.GetDueNotificationIDs(d.db)
+	if err != nil {
+		log.Printf("Failed to load due notifications: %v", err)
+		return 0
+	}
+
+	sent := 0
+	for _, id := range ids {
+		if _, err := d.Dispatch(id); err != nil {
+			log.Printf("Failed to dispatch notification %d: %v", id, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// releaseClaim returns a notification to scheduled so the next run retries it
+func (d *NotificationDispatcher) releaseClaim(notificationID uint) {
+	d.db.Model(&models.Notification{}).
+		Where("id = ? AND status = ?", notificationID, models.NotificationStatusSending).
+		Update("status", models.NotificationStatusScheduled)
+}
+
+// inAppOptOuts returns the recipients who turned the notification's topic or
+// in-app notifications off
+func (d *NotificationDispatcher) inAppOptOuts(notification *models.Notification, recipients []models.NotificationRecipientContact) ([]uint, error) {
+	userIDs := make([]uint, len(recipients))
+	for i, r := range recipients {
+		userIDs[i] = r.ID
+	}
+	accepted, err := models.FilterNotificationRecipients(d.db, userIDs, models.NotificationChannelInApp, NotificationTopic(notification), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification preferences: %v", err)
+	}
+	if len(accepted) == len(userIDs) {
+		return nil, nil
+	}
+	keep := make(map[uint]bool, len(accepted))
+	for _, id := range accepted {
+		keep[id] = true
+	}
+	var optedOut []uint
+	for _, id := range userIDs {
+		if !keep[id] {
+			optedOut = append(optedOut, id)
+		}
+	}
+	return optedOut, nil
+}
+
+// deliveryJobs builds the outbox jobs of a notification: one push job per
+// recipient with a push subscription and one SMS job per recipient
+func (d *NotificationDispatcher) deliveryJobs(notification *models.Notification, recipients []models.NotificationRecipientContact) ([]models.NotificationJob, error) {
+	var jobs []models.NotificationJob
+	topic := NotificationTopic(notification)
+
+	if notification.SendPush {
+		userIDs := make([]uint, len(recipients))
+		for i, r := range recipients {
+			userIDs[i] = r.ID
+		}
+		subscribed, err := models.FilterUsersWithPushSubscriptions(d.db, userIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		message := PushMessage{
+			Title:   notification.Title,
+			Message: notification.Message,
+			Icon:    "/pwa.png",
+			Tag:     fmt.Sprintf("notification-%d", notification.ID),
+			Urgency: PushUrgencyForPriority(notification.Priority),
+			Data: map[string]interface{}{
+				"url":  notification.ActionURL,
+				"type": notification.Type,
+			},
+		}
+		for _, userID := range subscribed {
+			jobs = append(jobs, NewPushJob(userID, topic, message))
+		}
+	}
+
+	if notification.SendSMS {
+		text := notification.Title + "\n" + notification.Message
+		for _, r := range recipients {
+			jobs = append(jobs, NewSMSJob(r.ID, topic, r.Phone, text))
+		}
+	}
+
+	for i := range jobs {
+		jobs[i].SourceType = models.NotificationJobSourceNotification
+		jobs[i].SourceID = notification.ID
+	}
+	return jobs, nil
+}
+
+// NotificationTopic returns the preference topic of a notification from its
+// type. Other notifications to the whole audience or a segment are marketing;
+// other notifications to one user are account notices without a topic.
+func NotificationTopic(notification *models.Notification) string {
+	switch notification.Type {
+	case "matching", "visitor_project":
+		return models.NotificationTopicMatchingRequests
+	case "chat":
+		return models.NotificationTopicChatMessages
+	case "ticket":
+		return models.NotificationTopicTicketReplies
+	case "withdrawal":
+		return models.NotificationTopicWithdrawalUpdates
+	}
+	if notification.Audience != models.NotificationAudienceUser {
+		return models.NotificationTopicMarketing
+	}
+	return ""
+}
+
+// StartNotificationScheduler sends scheduled notifications and push
+// campaigns every minute
+func StartNotificationScheduler() {
+	go func() {
+		dispatcher := NewNotificationDispatcher(models.GetDB())
+		campaigns := NewPushCampaignService(models.GetDB())
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+
+		// Run immediately on startup to catch anything missed while down
+		dispatcher.DispatchDue()
+		campaigns.DispatchDue()
+
+		for range ticker.C {
+			dispatcher.DispatchDue()
+			campaigns.DispatchDue()
+		}
+	}()
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// notificationJobLease is how long a worker owns a claimed job. A job whose
+// worker died is picked up again after it.
+const notificationJobLease = 5 * time.Minute
+
+// NotificationSender delivers one job. Returning PermanentDeliveryError
+// dead-letters the job without further retries.
+type NotificationSender func(job *models.NotificationJob) error
+
+// permanentDeliveryError marks an error that retrying cannot fix
+type permanentDeliveryError struct {
+	err error
+}
+
+func (e *permanentDeliveryError) Error() string { return e.err.Error() }
+func (e *permanentDeliveryError) Unwrap() error { return e.err }
+
+// PermanentDeliveryError wraps err so the job is not retried
+func PermanentDeliveryError(err error) error {
+	return &permanentDeliveryError{err: err}
+}
+
+// IsPermanentDeliveryError reports whether err was wrapped by
+// PermanentDeliveryError
+func IsPermanentDeliveryError(err error) bool {
+	var permanent *permanentDeliveryError
+	return errors.As(err, &permanent)
+}
+
+// InAppNotificationPayload is the payload of an in_app job: the fields of the
+// Notification row it creates besides title and message
+type InAppNotificationPayload struct {
+	Type        string `json:"type"`
+	Priority    string `json:"priority"`
+	ActionURL   string `json:"action_url"`
+	ActionText  string `json:"action_text"`
+	CreatedByID uint   `json:"created_by_id"`
+}
+
+// NotificationOutbox delivers queued notification jobs. Each channel has its
+// own workers and rate limit; failed jobs are retried with exponential
+// backoff and dead-lettered once their attempts run out.
+type NotificationOutbox struct {
+	db        *gorm.DB
+	senders   map[string]NotificationSender
+	limiters  map[string]*rateLimiter
+	workers   int
+	batchSize int
+	idle      time.Duration
+	now       func() time.Time // clock preferences are checked against
+}
+
+// NewNotificationOutbox creates an outbox using the outbox settings from the config
+func NewNotificationOutbox(db *gorm.DB) *NotificationOutbox {
+	cfg := config.OutboxConfig{Workers: 2, SMSPerSecond: 5, PushPerSecond: 50}
+	if config.AppConfig != nil {
+		cfg = config.AppConfig.Outbox
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+
+	o := &NotificationOutbox{
+		db:        db,
+		workers:   cfg.Workers,
+		batchSize: 20,
+		idle:      5 * time.Second,
+		now:       time.Now,
+		limiters: map[string]*rateLimiter{
+			models.NotificationChannelInApp: newRateLimiter(cfg.InAppPerSecond),
+			models.NotificationChannelPush:  newRateLimiter(cfg.PushPerSecond),
+			models.NotificationChannelSMS:   newRateLimiter(cfg.SMSPerSecond),
+		},
+	}
+	o.senders = map[string]NotificationSender{
+		models.NotificationChannelInApp: o.sendInApp,
+		models.NotificationChannelPush:  o.sendPush,
+		models.NotificationChannelSMS:   o.sendSMS,
+	}
+	return o
+}
+
+// SetSender replaces the sender of a channel
+func (o *NotificationOutbox) SetSender(channel string, sender NotificationSender) {
+	o.senders[channel] = sender
+}
+
+// SetClock replaces the clock quiet hours and the daily SMS limit are
+// checked against
+func (o *NotificationOutbox) SetClock(now func() time.Time) {
+	o.now = now
+}
+
+// ProcessChannel delivers one batch of due jobs of a channel and returns how
+// many jobs it handled
+func (o *NotificationOutbox) ProcessChannel(channel string) int {
+	jobs, err := models.ClaimNotificationJobs(o.db, channel, o.batchSize, notificationJobLease)
+	if err != nil {
+		log.Printf("Outbox: failed to claim %s jobs: %v", channel, err)
+	}
+	for i := range jobs {
+		o.limiters[channel].Wait()
+		o.deliver(&jobs[i])
+	}
+	return len(jobs)
+}
+
+// ProcessDue delivers due jobs of every channel until none are left. Jobs
+// waiting for a retry are left for later.
+func (o *NotificationOutbox) ProcessDue() int {
+	total := 0
+	for _, channel := range models.NotificationChannels {
+		for {
+			n := o.ProcessChannel(channel)
+			total += n
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return total
+}
+
+// Start runs the workers of every channel in the background
+func (o *NotificationOutbox) Start() {
+	for _, channel := range models.NotificationChannels {
+		for i := 0; i < o.workers; i++ {
+			go func(channel string) {
+				for {
+					if o.ProcessChannel(channel) == 0 {
+						time.Sleep(o.idle)
+					}
+				}
+			}(channel)
+		}
+	}
+}
+
+// deliver sends a claimed job and records the outcome. Jobs the recipient
+// opted out of are skipped and jobs falling in their quiet hours or past
+// their daily SMS limit are held back without using up an attempt.
+func (o *NotificationOutbox) deliver(job *models.NotificationJob) {
+	if job.UserID != nil {
+		decision, err := models.CheckNotificationPreference(o.db, *job.UserID, job.Channel, job.Topic, o.now())
+		if err != nil {
+			log.Printf("Outbox: failed to load preferences for job %d: %v", job.ID, err)
+		} else if decision.Skipped() {
+			if err := models.MarkNotificationJobSkipped(o.db, job, decision.Reason); err != nil {
+				log.Printf("Outbox: failed to skip job %d: %v", job.ID, err)
+			}
+			return
+		} else if !decision.Allowed {
+			if err := models.DeferNotificationJob(o.db, job, *decision.DeferUntil); err != nil {
+				log.Printf("Outbox: failed to defer job %d: %v", job.ID, err)
+			}
+			return
+		}
+	}
+
+	sender, ok := o.senders[job.Channel]
+	var err error
+	if !ok {
+		err = PermanentDeliveryError(fmt.Errorf("unknown channel %q", job.Channel))
+	} else {
+		err = sender(job)
+	}
+
+	if err == nil {
+		if err := models.MarkNotificationJobSent(o.db, job); err != nil {
+			log.Printf("Outbox: failed to mark job %d sent: %v", job.ID, err)
+		}
+		return
+	}
+
+	retryable := !IsPermanentDeliveryError(err)
+	if err := models.MarkNotificationJobFailed(o.db, job, err.Error(), retryable); err != nil {
+		log.Printf("Outbox: failed to record failure of job %d: %v", job.ID, err)
+	}
+	if !retryable || job.Attempts >= job.MaxAttempts {
+		log.Printf("Outbox: %s job %d dead after %d attempts: %v", job.Channel, job.ID, job.Attempts, err)
+	}
+}
+
+// sendInApp creates the in-app notification of a job
+func (o *NotificationOutbox) sendInApp(job *models.NotificationJob) error {
+	if job.UserID == nil {
+		return PermanentDeliveryError(errors.New("in-app job has no user"))
+	}
+	var payload InAppNotificationPayload
+	if job.Payload != "" {
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return PermanentDeliveryError(fmt.Errorf("invalid payload: %v", err))
+		}
+	}
+
+	userID := *job.UserID
+	notification := models.Notification{
+		UserID:      &userID,
+		Title:       job.Title,
+		Message:     job.Message,
+		Type:        payload.Type,
+		Priority:    payload.Priority,
+		IsRead:      false,
+		CreatedByID: payload.CreatedByID,
+		ActionURL:   payload.ActionURL,
+		ActionText:  payload.ActionText,
+	}
+	return o.db.Create(&notification).Error
+}
+
+// sendPush sends the web push of a job to the user's subscriptions
+func (o *NotificationOutbox) sendPush(job *models.NotificationJob) error {
+	if job.UserID == nil {
+		return PermanentDeliveryError(errors.New("push job has no user"))
+	}
+	message := PushMessage{Title: job.Title, Message: job.Message}
+	if job.Payload != "" {
+		if err := json.Unmarshal([]byte(job.Payload), &message); err != nil {
+			return PermanentDeliveryError(fmt.Errorf("invalid payload: %v", err))
+		}
+	}
+	return NewPushNotificationService(o.db).SendPushNotification(*job.UserID, message)
+}
+
+// sendSMS sends the text of a job to its phone, or the user's phone
+func (o *NotificationOutbox) sendSMS(job *models.NotificationJob) error {
+	smsService := GetSMSService()
+	if smsService == nil {
+		return PermanentDeliveryError(errors.New("SMS service not initialized"))
+	}
+
+	phone := job.Phone
+	if phone == "" && job.UserID != nil {
+		var user models.User
+		if err := o.db.Select("phone").First(&user, *job.UserID).Error; err != nil {
+			return err
+		}
+		phone = user.Phone
+	}
+	phone = ValidateIranianPhoneNumber(phone)
+	if phone == "" {
+		return PermanentDeliveryError(errors.New("recipient has no valid phone number"))
+	}
+
+	if job.Payload != "" {
+		var payload SMSPatternPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return PermanentDeliveryError(fmt.Errorf("invalid payload: %v", err))
+		}
+		if payload.Pattern != "" {
+			return smsService.SendPatternSMS(phone, payload.Pattern, payload.Values)
+		}
+	}
+
+	text := job.Message
+	if job.Title != "" {
+		text = job.Title + "\n" + job.Message
+	}
+	return smsService.SendSimpleSMS(phone, text)
+}
+
+// SMSPatternPayload is the payload of an sms job sent from a pattern; the
+// job's message keeps the text the pattern renders
+type SMSPatternPayload struct {
+	Pattern string            `json:"pattern"`
+	Values  map[string]string `json:"values"`
+}
+
+// NewInAppJob builds an in_app job about topic for a user
+func NewInAppJob(userID uint, topic, title, message string, payload InAppNotificationPayload) models.NotificationJob {
+	data, _ := json.Marshal(payload)
+	return models.NotificationJob{Channel: models.NotificationChannelInApp, UserID: &userID, Topic: topic, Title: title, Message: message, Payload: string(data)}
+}
+
+// NewPushJob builds a push job about topic for a user
+func NewPushJob(userID uint, topic string, message PushMessage) models.NotificationJob {
+	data, _ := json.Marshal(message)
+	return models.NotificationJob{Channel: models.NotificationChannelPush, UserID: &userID, Topic: topic, Title: message.Title, Message: message.Message, Payload: string(data)}
+}
+
+// NewSMSJob builds an SMS job about topic for a user. The phone is looked up
+// when the job is sent unless given.
+func NewSMSJob(userID uint, topic, phone, text string) models.NotificationJob {
+	return models.NotificationJob{Channel: models.NotificationChannelSMS, UserID: &userID, Topic: topic, Phone: phone, Message: text}
+}
+
+// NewPatternSMSJob builds an sms job sent from a pattern; text is what the
+// pattern renders
+func NewPatternSMSJob(userID uint, topic, phone, text, pattern string, values map[string]string) models.NotificationJob {
+	job := NewSMSJob(userID, topic, phone, text)
+	data, _ := json.Marshal(SMSPatternPayload{Pattern: pattern, Values: values})
+	job.Payload = string(data)
+	return job
+}
+
+// StartNotificationOutbox runs the outbox workers
+func StartNotificationOutbox() {
+	NewNotificationOutbox(models.GetDB()).Start()
+}
+
+// rateLimiter spaces out calls to at most a fixed number per second, shared
+// by every worker of a channel
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter creates a limiter; perSecond <= 0 means unlimited
+func newRateLimiter(perSecond float64) *rateLimiter {
+	l := &rateLimiter{}
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return l
+}
+
+// Wait blocks until the next call is allowed
+func (l *rateLimiter) Wait() {
+	if l.interval == 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
@@ -0,0 +1,262 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+)
+
+func TestOutboxRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+
+	config.AppConfig.Outbox.MaxAttempts = 3
+	t.Cleanup(func() { config.AppConfig.Outbox.MaxAttempts = 0 })
+
+	jobs := []models.NotificationJob{services.NewPushJob(user.ID, "", services.PushMessage{Title: "سلام", Message: "push"})}
+	if err := models.EnqueueNotificationJobs(db, jobs); err != nil {
+		t.Fatal(err)
+	}
+	jobID := jobs[0].ID
+
+	outbox := services.NewNotificationOutbox(db)
+	calls := 0
+	outbox.SetSender(models.NotificationChannelPush, func(*models.NotificationJob) error {
+		calls++
+		return errors.New("provider unavailable")
+	})
+	reload := func() models.NotificationJob {
+		t.Helper()
+		var job models.NotificationJob
+		if err := db.First(&job, jobID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	outbox.ProcessDue()
+	first := reload()
+	if first.Status != models.NotificationJobPending || first.Attempts != 1 || first.LastError != "provider unavailable" {
+		t.Fatalf("after first failure: %+v", first)
+	}
+	if wait := time.Until(first.NextAttemptAt); wait < 20*time.Second || wait > 31*time.Second {
+		t.Fatalf("first retry in %v, want about 30s", wait)
+	}
+
+	// Not due yet
+	outbox.ProcessDue()
+	if calls != 1 {
+		t.Fatalf("job retried before its backoff: %d calls", calls)
+	}
+
+	for attempt := 2; attempt <= 3; attempt++ {
+		db.Model(&models.NotificationJob{}).Where("id = ?", jobID).Update("next_attempt_at", time.Now().Add(-time.Second))
+		outbox.ProcessDue()
+	}
+	dead := reload()
+	if calls != 3 || dead.Status != models.NotificationJobDead || dead.DeadAt == nil {
+		t.Fatalf("after %d calls: %+v", calls, dead)
+	}
+
+	failed, total, _ := models.GetNotificationJobs(db, models.NotificationJobFilter{Status: models.NotificationJobDead}, 10, 0)
+	if total != 1 || failed[0].ID != jobID {
+		t.Fatalf("dead letters = %+v", failed)
+	}
+
+	// An admin retry starts over and succeeds once the provider is back
+	if _, err := models.RetryNotificationJob(db, jobID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.RetryNotificationJob(db, jobID); !errors.Is(err, models.ErrNotificationJobNotDead) {
+		t.Fatalf("second retry: err = %v", err)
+	}
+	outbox.SetSender(models.NotificationChannelPush, func(*models.NotificationJob) error { return nil })
+	outbox.ProcessDue()
+	if sent := reload(); sent.Status != models.NotificationJobSent || sent.Attempts != 1 || sent.SentAt == nil {
+		t.Fatalf("after retry: %+v", sent)
+	}
+}
+
+func TestOutboxPermanentErrorSkipsRetries(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+
+	jobs := []models.NotificationJob{services.NewSMSJob(user.ID, "", "", "پیامک")}
+	if err := models.EnqueueNotificationJobs(db, jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	// No SMS provider is configured in tests
+	services.NewNotificationOutbox(db).ProcessDue()
+
+	var job models.NotificationJob
+	db.First(&job, jobs[0].ID)
+	if job.Status != models.NotificationJobDead || job.Attempts != 1 {
+		t.Fatalf("job = %+v, want dead after one attempt", job)
+	}
+}
+
+func TestOutboxFollowsNotificationPreferences(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+
+	_, err := models.SaveNotificationPreference(db, user.ID, models.NotificationPreferenceSettings{
+		Topics: map[string]map[string]bool{
+			models.NotificationTopicMarketing: {models.NotificationChannelSMS: false},
+		},
+		QuietHours: &models.NotificationQuietHours{Enabled: true, Start: "22:00", End: "08:00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []models.NotificationJob{
+		services.NewSMSJob(user.ID, models.NotificationTopicMarketing, "", "تخفیف ویژه"),
+		services.NewPushJob(user.ID, models.NotificationTopicWithdrawalUpdates, services.PushMessage{Title: "برداشت", Message: "انجام شد"}),
+	}
+	if err := models.EnqueueNotificationJobs(db, jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tomorrow at 23:00 Tehran time, inside the quiet hours
+	tehran := time.FixedZone("Tehran", 3*3600+30*60)
+	today := time.Now().In(tehran)
+	night := time.Date(today.Year(), today.Month(), today.Day()+1, 23, 0, 0, 0, tehran)
+
+	outbox := services.NewNotificationOutbox(db)
+	outbox.SetClock(func() time.Time { return night })
+	outbox.SetSender(models.NotificationChannelPush, func(*models.NotificationJob) error {
+		t.Error("push sent during quiet hours")
+		return nil
+	})
+	outbox.SetSender(models.NotificationChannelSMS, func(*models.NotificationJob) error {
+		t.Error("opted out SMS sent")
+		return nil
+	})
+	outbox.ProcessDue()
+
+	var sms, push models.NotificationJob
+	db.First(&sms, jobs[0].ID)
+	db.First(&push, jobs[1].ID)
+	if sms.Status != models.NotificationJobSkipped {
+		t.Fatalf("sms = %+v, want skipped", sms)
+	}
+	morning := time.Date(night.Year(), night.Month(), night.Day()+1, 8, 0, 0, 0, tehran)
+	if push.Status != models.NotificationJobPending || push.Attempts != 0 || !push.NextAttemptAt.Equal(morning) {
+		t.Fatalf("push = %+v, want held until the quiet hours end", push)
+	}
+}
+
+func TestMatchingNotificationsAreQueued(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	supplierUser := testutil.CreateUser(t, db, "09120000001")
+	supplier := testutil.CreateSupplier(t, db, supplierUser.ID)
+
+	licensed := testutil.CreateUser(t, db, "09120000002")
+	testutil.GrantLicense(t, db, licensed.ID, "plus")
+	licensedVisitor := testutil.CreateVisitor(t, db, licensed.ID, "دبی", "زعفران")
+	unlicensed := testutil.CreateUser(t, db, "09120000003")
+	unlicensedVisitor := testutil.CreateVisitor(t, db, unlicensed.ID, "دبی", "زعفران")
+
+	request, err := models.CreateMatchingRequest(db, supplierUser.ID, supplier.ID, models.CreateMatchingRequestRequest{
+		ProductName: "زعفران", Quantity: "10", Unit: "kg", DestinationCountries: "AE",
+		Price: "1000", Currency: "USD", ExpiresAt: time.Now().Add(48 * time.Hour).Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := services.NewMatchingService(db)
+	if err := service.SendMatchingNotifications(request, []models.Visitor{*licensedVisitor, *unlicensedVisitor}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is delivered inline: in-app and push for both, SMS for the licensed visitor
+	var queued []models.NotificationJob
+	db.Where("source_type = ?", models.NotificationJobSourceMatching).Order("id").Find(&queued)
+	if len(queued) != 5 {
+		t.Fatalf("queued %d jobs, want 5", len(queued))
+	}
+	if unreadCount(t, licensed.ID) != 0 {
+		t.Fatal("in-app notification created before the outbox ran")
+	}
+
+	outbox := services.NewNotificationOutbox(db)
+	outbox.SetSender(models.NotificationChannelPush, func(*models.NotificationJob) error { return nil })
+	outbox.SetSender(models.NotificationChannelSMS, func(job *models.NotificationJob) error {
+		if *job.UserID != licensed.ID {
+			t.Errorf("SMS sent to user %d", *job.UserID)
+		}
+		return errors.New("temporary failure")
+	})
+	outbox.ProcessDue()
+
+	if unreadCount(t, licensed.ID) != 1 || unreadCount(t, unlicensed.ID) != 1 {
+		t.Fatal("in-app notifications not delivered")
+	}
+	var rows []models.MatchingNotification
+	db.Where("matching_request_id = ?", request.ID).Order("id").Find(&rows)
+	statuses := map[string]int{}
+	for _, row := range rows {
+		statuses[row.NotificationType+":"+row.Status]++
+	}
+	if len(rows) != 5 || statuses["in_app:sent"] != 2 || statuses["push:sent"] != 2 || statuses["sms:pending"] != 1 {
+		t.Fatalf("matching notification rows = %v", statuses)
+	}
+}
+
+func TestVisitorProjectNotifiesMatchingSuppliers(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	visitorUser := testutil.CreateUser(t, db, "09120000001")
+	visitor := testutil.CreateVisitor(t, db, visitorUser.ID, "دبی", "")
+
+	saffronUser := testutil.CreateUser(t, db, "09120000002")
+	saffron := testutil.CreateSupplier(t, db, saffronUser.ID)
+	carpetUser := testutil.CreateUser(t, db, "09120000003")
+	carpet := testutil.CreateSupplier(t, db, carpetUser.ID)
+	for supplierID, name := range map[uint]string{saffron.ID: "زعفران سرگل", carpet.ID: "فرش دستباف"} {
+		product := models.SupplierProduct{SupplierID: supplierID, ProductName: name, ProductType: "agriculture_food", Description: "-", MonthlyProductionMin: "1"}
+		if err := db.Create(&product).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	project, err := models.CreateVisitorProject(db, visitorUser.ID, visitor.ID, models.CreateVisitorProjectRequest{
+		ProjectTitle: "خرید زعفران", ProductName: "زعفران", Quantity: "5", Unit: "kg",
+		TargetCountries: "AE", Currency: "USD", ExpiresAt: time.Now().Add(72 * time.Hour).Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.NewVisitorProjectService(db).ProcessVisitorProject(project); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []models.VisitorProjectNotification
+	db.Where("visitor_project_id = ?", project.ID).Find(&rows)
+	if len(rows) != 2 {
+		t.Fatalf("rows = %d; want in-app and push for the saffron supplier only", len(rows))
+	}
+	// matched_supplier_count counts interested proposals, not notified suppliers
+	db.First(project, project.ID)
+	if project.MatchedSupplierCount != 0 {
+		t.Fatalf("matched_supplier_count = %d after notifying", project.MatchedSupplierCount)
+	}
+	for _, row := range rows {
+		if row.SupplierID != saffron.ID {
+			t.Fatalf("supplier %d notified", row.SupplierID)
+		}
+	}
+
+	outbox := services.NewNotificationOutbox(db)
+	outbox.SetSender(models.NotificationChannelPush, func(*models.NotificationJob) error { return nil })
+	outbox.ProcessDue()
+	if unreadCount(t, saffronUser.ID) != 1 || unreadCount(t, carpetUser.ID) != 0 {
+		t.Fatal("in-app notification not delivered to the matching supplier only")
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// OTPService issues and verifies one-time codes delivered by SMS
+type OTPService struct {
+	db   *gorm.DB
+	opts models.OTPOptions
+}
+
+// NewOTPService creates an OTP service using the otp section of the config
+func NewOTPService(db *gorm.DB) *OTPService {
+	opts := models.OTPOptions{
+		TTL:            5 * time.Minute,
+		MaxAttempts:    5,
+		ResendCooldown: 2 * time.Minute,
+	}
+	if config.AppConfig != nil {
+		cfg := config.AppConfig.OTP
+		if cfg.TTLMinutes > 0 {
+			opts.TTL = time.Duration(cfg.TTLMinutes) * time.Minute
+		}
+		if cfg.MaxAttempts > 0 {
+			opts.MaxAttempts = cfg.MaxAttempts
+		}
+		if cfg.ResendCooldownSeconds > 0 {
+			opts.ResendCooldown = time.Duration(cfg.ResendCooldownSeconds) * time.Second
+		}
+	}
+	return &OTPService{db: db, opts: opts}
+}
+
+// NormalizeOTPPhone returns the canonical phone key used to store codes
+func NormalizeOTPPhone(phone string) string {
+	return ValidateIranianPhoneNumber(phone)
+}
+
+// SendCode issues a new code for phone/purpose and sends it by SMS
+func (s *OTPService) SendCode(phone, purpose string) error {
+	normalized := NormalizeOTPPhone(phone)
+	if normalized == "" {
+		return fmt.Errorf("invalid phone number")
+	}
+
+	code, _, err := models.IssueOTPCode(s.db, normalized, purpose, s.opts)
+	if err != nil {
+		return err
+	}
+
+	smsService := GetSMSService()
+	if smsService == nil {
+		log.Printf("[OTP] SMS service not configured, code for %s (%s) not sent", normalized, purpose)
+		return nil
+	}
+
+	switch purpose {
+	case models.OTPPurposePasswordRecovery:
+		err = smsService.SendPasswordRecoverySMS(normalized, code)
+	default:
+		pattern := ""
+		if config.AppConfig != nil {
+			pattern = config.AppConfig.SMS.PhoneVerificationPattern
+		}
+		err = smsService.SendPhoneVerificationSMS(normalized, code, pattern)
+	}
+	if err != nil {
+		log.Printf("[OTP] failed to send %s code to %s: %v", purpose, normalized, err)
+	}
+	return err
+}
+
+// Verify checks and consumes a code
+func (s *OTPService) Verify(phone, purpose, code string) error {
+	return models.VerifyOTPCode(s.db, NormalizeOTPPhone(phone), purpose, code)
+}
+
+// VerifyPhone confirms a phone verification code so registration can proceed
+func (s *OTPService) VerifyPhone(phone, code string) error {
+	return models.MarkPhoneVerified(s.db, NormalizeOTPPhone(phone), code)
+}
+
+// RequirePhoneVerified consumes a prior phone verification when registration
+// requires a verified phone; it is a no-op when the requirement is disabled.
+func (s *OTPService) RequirePhoneVerified(phone string) error {
+	if config.AppConfig == nil || !config.AppConfig.OTP.RequirePhoneVerification {
+		return nil
+	}
+	return models.ConsumePhoneVerification(s.db, NormalizeOTPPhone(phone))
+}
+
+// CooldownRemaining returns the time left before another code can be sent
+func (s *OTPService) CooldownRemaining(phone, purpose string) time.Duration {
+	return models.OTPCooldownRemaining(s.db, NormalizeOTPPhone(phone), purpose, s.opts.ResendCooldown)
+}
+
+// IsOTPRejection reports whether err is a user-caused verification failure
+// (as opposed to a database error)
+func IsOTPRejection(err error) bool {
+	switch err {
+	case models.ErrOTPNotFound, models.ErrOTPExpired, models.ErrOTPTooManyAttempts,
+		models.ErrOTPInvalid, models.ErrOTPPhoneNotVerified:
+		return true
+	}
+	return false
+}
+
+// OTPErrorMessage maps OTP errors to user-facing messages
+func OTPErrorMessage(err error) string {
+	switch err {
+	case models.ErrOTPCooldown:
+		return "کد قبلاً ارسال شده است. لطفاً کمی صبر کنید و دوباره تلاش کنید"
+	case models.ErrOTPNotFound:
+		return "کد تایید یافت نشد. لطفاً کد جدید درخواست کنید"
+	case models.ErrOTPExpired:
+		return "کد تایید منقضی شده است. لطفاً کد جدید درخواست کنید"
+	case models.ErrOTPTooManyAttempts:
+		return "تعداد تلاش‌های ناموفق بیش از حد مجاز است. لطفاً کد جدید درخواست کنید"
+	case models.ErrOTPInvalid:
+		return "کد تایید اشتباه است"
+	case models.ErrOTPPhoneNotVerified:
+		return "لطفاً ابتدا شماره موبایل خود را تایید کنید"
+	}
+	return "خطا در بررسی کد تایید"
+}
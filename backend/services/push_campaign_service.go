@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// PushCampaignService sends marketing push campaigns: it picks the users of
+// the campaign's audience who have push, splits them evenly between the A/B
+// variants and queues their pushes in the notification outbox, spaced out to
+// the campaign's rate
+type PushCampaignService struct {
+	db *gorm.DB
+}
+
+// NewPushCampaignService creates a new push campaign service
+func NewPushCampaignService(db *gorm.DB) *PushCampaignService {
+	return &PushCampaignService{db: db}
+}
+
+// CreateAndDispatch creates a campaign and sends it right away unless it is
+// scheduled for later
+func (s *PushCampaignService) CreateAndDispatch(createdByID uint, req models.CreatePushCampaignRequest) (*models.PushCampaign, error) {
+	campaign, err := models.CreatePushCampaign(s.db, createdByID, req)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.ScheduledAt != nil && campaign.ScheduledAt.After(time.Now()) {
+		return campaign, nil
+	}
+	return s.Dispatch(campaign.ID)
+}
+
+// Dispatch sends a due scheduled campaign. It is a no-op returning the
+// current state if the campaign was already claimed by another worker.
+func (s *PushCampaignService) Dispatch(campaignID uint) (*models.PushCampaign, error) {
+	claimed, err := models.ClaimPushCampaignForSending(s.db, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return models.GetPushCampaign(s.db, campaignID)
+	}
+
+	campaign, err := models.GetPushCampaign(s.db, campaignID)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs, err := models.ResolvePushCampaignAudience(s.db, campaign)
+	if err != nil {
+		s.releaseClaim(campaignID)
+		return nil, fmt.Errorf("failed to resolve push campaign audience: %v", err)
+	}
+
+	// Shuffle before alternating so each variant gets a random half
+	rand.Shuffle(len(userIDs), func(i, j int) { userIDs[i], userIDs[j] = userIDs[j], userIDs[i] })
+	recipients := make([]models.PushCampaignRecipient, len(userIDs))
+	for i, userID := range userIDs {
+		variant := campaign.Variants[i%len(campaign.Variants)]
+		recipients[i] = models.NewPushCampaignRecipient(campaign.ID, userID, variant.ID)
+	}
+
+	// Publishing and queueing the pushes commit together; the jobs need the
+	// recipient IDs, so they are built inside the transaction
+	var queued int
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := models.PublishPushCampaign(tx, campaign, recipients); err != nil {
+			return err
+		}
+		jobs := s.deliveryJobs(campaign, recipients, time.Now())
+		queued = len(jobs)
+		return models.EnqueueNotificationJobs(tx, jobs)
+	})
+	if err != nil {
+		s.releaseClaim(campaignID)
+		return nil, err
+	}
+
+	log.Printf("Push campaign %d sent to %d recipients in %d variants", campaignID, queued, len(campaign.Variants))
+
+	return models.GetPushCampaign(s.db, campaignID)
+}
+
+// DispatchDue sends every scheduled campaign whose time has come
+func (s *PushCampaignService) DispatchDue() int {
+	ids, err := models.GetDuePushCampaignIDs(s.db)
+	if err != nil {
+		log.Printf("Failed to load due push campaigns: %v", err)
+		return 0
+	}
+
+	sent := 0
+	for _, id := range ids {
+		if _, err := s.Dispatch(id); err != nil {
+			log.Printf("Failed to dispatch push campaign %d: %v", id, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// releaseClaim returns a campaign to scheduled so the next run retries it
+func (s *PushCampaignService) releaseClaim(campaignID uint) {
+	s.db.Model(&models.PushCampaign{}).
+		Where("id = ? AND status = ?", campaignID, models.PushCampaignSending).
+		Update("status", models.PushCampaignScheduled)
+}
+
+// deliveryJobs builds one push job per recipient. Job i becomes due i/rate
+// minutes after start, so the outbox sends the campaign at its rate.
+func (s *PushCampaignService) deliveryJobs(campaign *models.PushCampaign, recipients []models.PushCampaignRecipient, start time.Time) []models.NotificationJob {
+	variants := make(map[uint]models.PushCampaignVariant, len(campaign.Variants))
+	for _, variant := range campaign.Variants {
+		variants[variant.ID] = variant
+	}
+
+	interval := time.Minute / time.Duration(campaign.RatePerMinute)
+	jobs := make([]models.NotificationJob, len(recipients))
+	for i, recipient := range recipients {
+		message := PushCampaignMessage(campaign, variants[recipient.VariantID], recipient.Token)
+		jobs[i] = NewPushJob(recipient.UserID, models.NotificationTopicMarketing, message)
+		jobs[i].SourceType = models.NotificationJobSourcePushCampaign
+		jobs[i].SourceID = recipient.ID
+		jobs[i].NextAttemptAt = start.Add(time.Duration(i) * interval)
+	}
+	return jobs
+}
+
+// PushCampaignMessage is the push of a campaign variant. With a click token
+// the push opens the tracked link, which counts the click and redirects to
+// the variant's deep link; without one (test sends) it opens the deep link.
+func PushCampaignMessage(campaign *models.PushCampaign, variant models.PushCampaignVariant, token string) PushMessage {
+	url := variant.DeepLink
+	if token != "" {
+		url = PushCampaignClickURL(token)
+	}
+	icon := variant.Icon
+	if icon == "" {
+		icon = "/pwa.png"
+	}
+	return PushMessage{
+		Title:   variant.Title,
+		Message: variant.Message,
+		Icon:    icon,
+		Tag:     fmt.Sprintf("campaign-%d", campaign.ID),
+		Urgency: PushUrgencyNormal,
+		Data: map[string]interface{}{
+			"url":         url,
+			"deep_link":   variant.DeepLink,
+			"type":        "campaign",
+			"campaign_id": campaign.ID,
+			"variant":     variant.Label,
+		},
+	}
+}
+
+// PushCampaignClickURL is the tracked link of a recipient
+func PushCampaignClickURL(token string) string {
+	base := "/backend/api/v1"
+	if config.AppConfig != nil && config.AppConfig.Push.ClickBaseURL != "" {
+		base = config.AppConfig.Push.ClickBaseURL
+	}
+	return strings.TrimRight(base, "/") + "/public/push/click/" + token
+}
+
+// SendTest sends every variant of a campaign to one user's devices so the
+// admin can check how they look. Test pushes are not tracked.
+func (s *PushCampaignService) SendTest(campaignID, userID uint) error {
+	campaign, err := models.GetPushCampaign(s.db, campaignID)
+	if err != nil {
+		return err
+	}
+	push := NewPushNotificationService(s.db)
+	var failed []string
+	for _, variant := range campaign.Variants {
+		message := PushCampaignMessage(campaign, variant, "")
+		message.Title = "[" + variant.Label + "] " + message.Title
+		// A tag per variant so the second does not replace the first
+		message.Tag = fmt.Sprintf("campaign-%d-%s", campaign.ID, variant.Label)
+		if err := push.SendPushNotification(userID, message); err != nil {
+			failed = append(failed, variant.Label+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+)
+
+func TestPushCampaignSplitsVariantsThrottlesAndTracksClicks(t *testing.T) {
+	db := testutil.NewTestDB(t)
+	admin := testutil.CreateUser(t, db, "09120000000")
+
+	// Four pro users with push, one of them opted out of marketing, one pro
+	// user without push and one plus user with push
+	var users []*models.User
+	for _, phone := range []string{"09120000001", "09120000002", "09120000003", "09120000004", "09120000005", "09120000006"} {
+		user := testutil.CreateUser(t, db, phone)
+		users = append(users, user)
+		licenseType := "pro"
+		if phone == "09120000006" {
+			licenseType = "plus"
+		}
+		testutil.GrantLicense(t, db, user.ID, licenseType)
+		if phone == "09120000005" {
+			continue
+		}
+		if _, err := models.CreatePushSubscription(db, user.ID, "https://push.example.com/"+phone, "key", "auth",
+			"Mozilla/5.0", models.PushTransportWebPush, models.PushPlatformWeb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := models.SaveNotificationPreference(db, users[3].ID, models.NotificationPreferenceSettings{
+		Topics: map[string]map[string]bool{models.NotificationTopicMarketing: {models.NotificationChannelPush: false}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := models.CreatePushCampaignRequest{
+		Name:          "نوروز",
+		Segment:       &models.NotificationSegment{LicenseTypes: []string{"pro"}},
+		RatePerMinute: 60,
+		Variants: []models.PushCampaignVariantRequest{
+			{Title: "تخفیف ویژه", Message: "۲۰٪ تخفیف", DeepLink: "/discount/nowruz"},
+			{Title: "فقط امروز", Message: "۲۰٪ تخفیف", DeepLink: "https://asllmarket.ir/discount/nowruz?v=b"},
+		},
+	}
+	service := services.NewPushCampaignService(db)
+
+	invalid := req
+	invalid.Variants = append(append([]models.PushCampaignVariantRequest{}, req.Variants...), req.Variants[0])
+	if _, err := service.CreateAndDispatch(admin.ID, invalid); err == nil {
+		t.Fatal("campaign with three variants was accepted")
+	}
+	invalid.Variants = []models.PushCampaignVariantRequest{{Title: "x", Message: "y", DeepLink: "//evil.example.com"}}
+	if _, err := service.CreateAndDispatch(admin.ID, invalid); err == nil {
+		t.Fatal("protocol-relative deep link was accepted")
+	}
+
+	campaign, err := service.CreateAndDispatch(admin.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if campaign.Status != models.PushCampaignSent || campaign.RecipientCount != 4 || campaign.Segment == nil {
+		t.Fatalf("campaign = %+v", campaign)
+	}
+
+	// Pushes are queued one second apart at 60 a minute, with tracked links
+	var jobs []models.NotificationJob
+	db.Where("source_type = ?", models.NotificationJobSourcePushCampaign).Order("next_attempt_at").Find(&jobs)
+	if len(jobs) != 4 {
+		t.Fatalf("queued %d jobs", len(jobs))
+	}
+	if gap := jobs[3].NextAttemptAt.Sub(jobs[0].NextAttemptAt); gap < 2900*time.Millisecond || gap > 3100*time.Millisecond {
+		t.Fatalf("jobs spread over %v, want 3s", gap)
+	}
+	if !strings.Contains(jobs[0].Payload, "/backend/api/v1/public/push/click/") || jobs[0].Topic != models.NotificationTopicMarketing {
+		t.Fatalf("job = %+v", jobs[0])
+	}
+
+	// Deliver everything now
+	db.Model(&models.NotificationJob{}).Where("source_type = ?", models.NotificationJobSourcePushCampaign).
+		Update("next_attempt_at", time.Now().Add(-time.Second))
+	outbox := services.NewNotificationOutbox(db)
+	outbox.SetSender(models.NotificationChannelPush, func(*models.NotificationJob) error { return nil })
+	outbox.ProcessDue()
+
+	var recipients []models.PushCampaignRecipient
+	db.Where("campaign_id = ?", campaign.ID).Order("id").Find(&recipients)
+	byVariant := map[uint]int{}
+	for _, r := range recipients {
+		byVariant[r.VariantID]++
+	}
+	if len(byVariant) != 2 || byVariant[campaign.Variants[0].ID] != 2 {
+		t.Fatalf("variants split %v", byVariant)
+	}
+
+	// Two clicks by one recipient count once as clicked
+	var clicker models.PushCampaignRecipient
+	db.Where("campaign_id = ? AND status = ?", campaign.ID, models.PushCampaignRecipientSent).First(&clicker)
+	for i := 0; i < 2; i++ {
+		link, err := models.RecordPushCampaignClick(db, clicker.Token)
+		if err != nil || !strings.Contains(link, "/discount/nowruz") {
+			t.Fatalf("click: link = %q, err = %v", link, err)
+		}
+	}
+	if _, err := models.RecordPushCampaignClick(db, "unknown"); err == nil {
+		t.Fatal("unknown token was accepted")
+	}
+
+	results, err := models.GetPushCampaignResults(db, campaign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent, skipped, clicked, clicks int64
+	for _, result := range results {
+		sent += result.Sent
+		skipped += result.Skipped
+		clicked += result.Clicked
+		clicks += result.Clicks
+		if result.VariantID == clicker.VariantID && result.ClickRate <= 0 {
+			t.Fatalf("result = %+v", result)
+		}
+	}
+	if len(results) != 2 || sent != 3 || skipped != 1 || clicked != 1 || clicks != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+
+	// A scheduled campaign waits, and can be canceled until it is sent
+	later := time.Now().Add(time.Hour)
+	req.ScheduledAt = &later
+	scheduled, err := service.CreateAndDispatch(admin.ID, req)
+	if err != nil || scheduled.Status != models.PushCampaignScheduled {
+		t.Fatalf("scheduled = %+v, err = %v", scheduled, err)
+	}
+	if service.DispatchDue() != 0 {
+		t.Fatal("campaign sent before its time")
+	}
+	if err := models.CancelPushCampaign(db, scheduled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.CancelPushCampaign(db, campaign.ID); err != models.ErrPushCampaignNotScheduled {
+		t.Fatalf("cancel sent campaign: err = %v", err)
+	}
+}
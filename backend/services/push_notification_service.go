@@ -2,11 +2,14 @@ package services
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -27,13 +30,16 @@ var pushNotificationServiceInstance *PushNotificationService
 // GetPushNotificationService returns the singleton instance
 func GetPushNotificationService() *PushNotificationService {
 	if pushNotificationServiceInstance == nil {
-		pushNotificationServiceInstance = &PushNotificationService{
-			db: models.GetDB(),
-		}
+		pushNotificationServiceInstance = NewPushNotificationService(models.GetDB())
 	}
 	return pushNotificationServiceInstance
 }
 
+// NewPushNotificationService creates a push notification service bound to db
+func NewPushNotificationService(db *gorm.DB) *PushNotificationService {
+	return &PushNotificationService{db: db}
+}
+
 // PushMessage represents a push notification message
 type PushMessage struct {
 	Title   string                 `json:"title"`
@@ -43,6 +49,104 @@ type PushMessage struct {
 	Tag     string                 `json:"tag,omitempty"`
 	Data    map[string]interface{} `json:"data,omitempty"`
 	Actions []PushAction           `json:"actions,omitempty"`
+
+	// Delivery options. TTL is how many seconds the push service keeps a
+	// message for an offline device (default one day), Urgency is one of
+	// very-low, low, normal (default) and high, and a newer message with the
+	// same Topic replaces one still pending. Topic defaults to Tag.
+	TTL     int    `json:"ttl,omitempty"`
+	Urgency string `json:"urgency,omitempty"`
+	Topic   string `json:"topic,omitempty"`
+}
+
+// Push urgencies
+const (
+	PushUrgencyVeryLow = "very-low"
+	PushUrgencyLow     = "low"
+	PushUrgencyNormal  = "normal"
+	PushUrgencyHigh    = "high"
+)
+
+// defaultPushTTL is how long undelivered messages are kept by default
+const defaultPushTTL = 24 * 60 * 60
+
+// deliveryTTL returns the TTL of the message in seconds
+func (m PushMessage) deliveryTTL() int {
+	if m.TTL > 0 {
+		return m.TTL
+	}
+	return defaultPushTTL
+}
+
+// deliveryUrgency returns the urgency of the message
+func (m PushMessage) deliveryUrgency() string {
+	switch m.Urgency {
+	case PushUrgencyVeryLow, PushUrgencyLow, PushUrgencyHigh:
+		return m.Urgency
+	}
+	return PushUrgencyNormal
+}
+
+// deliveryTopic returns the collapse topic of the message. Web Push allows at
+// most 32 URL-safe characters, so others are dropped.
+func (m PushMessage) deliveryTopic() string {
+	topic := m.Topic
+	if topic == "" {
+		topic = m.Tag
+	}
+	var b strings.Builder
+	for _, c := range topic {
+		if b.Len() == 32 {
+			break
+		}
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// PushUrgencyForPriority maps a notification priority to a push urgency
+func PushUrgencyForPriority(priority string) string {
+	switch priority {
+	case "urgent", "high":
+		return PushUrgencyHigh
+	case "low":
+		return PushUrgencyLow
+	}
+	return PushUrgencyNormal
+}
+
+// pushDeliveryError is a failed delivery to one subscription. Permanent
+// means the subscription is gone or unusable; config means our own push
+// credentials are missing or rejected, which says nothing about the
+// subscription.
+type pushDeliveryError struct {
+	permanent bool
+	config    bool
+	msg       string
+}
+
+func (e *pushDeliveryError) Error() string { return e.msg }
+
+func permanentPushError(format string, args ...interface{}) error {
+	return &pushDeliveryError{permanent: true, msg: fmt.Sprintf(format, args...)}
+}
+
+func configPushError(format string, args ...interface{}) error {
+	return &pushDeliveryError{config: true, msg: fmt.Sprintf(format, args...)}
+}
+
+// isPermanentPushError reports whether err means the subscription is gone
+func isPermanentPushError(err error) bool {
+	var pushErr *pushDeliveryError
+	return errors.As(err, &pushErr) && pushErr.permanent
+}
+
+// isConfigPushError reports whether err comes from our push configuration
+func isConfigPushError(err error) bool {
+	var pushErr *pushDeliveryError
+	return errors.As(err, &pushErr) && pushErr.config
 }
 
 // PushAction represents an action button in push notification
@@ -52,7 +156,13 @@ type PushAction struct {
 	Icon   string `json:"icon,omitempty"`
 }
 
-// SendPushNotification sends a push notification to a user
+// ErrNoPushSubscriptions is returned, as a permanent delivery error, when a
+// user has no active push subscription left to deliver to
+var ErrNoPushSubscriptions = errors.New("user has no active push subscriptions")
+
+// SendPushNotification sends a push notification to a user. It fails with
+// ErrNoPushSubscriptions unless some subscription was delivered to or failed
+// transiently.
 func (pns *PushNotificationService) SendPushNotification(userID uint, message PushMessage) error {
 	subscriptions, err := models.GetUserPushSubscriptions(pns.db, userID)
 	if err != nil {
@@ -60,76 +170,136 @@ func (pns *PushNotificationService) SendPushNotification(userID uint, message Pu
 	}
 
 	if len(subscriptions) == 0 {
-		return nil // No subscriptions, nothing to send
+		// Also the case when an earlier attempt deactivated them all: the
+		// push was not delivered, and retrying will not deliver it
+		return PermanentDeliveryError(ErrNoPushSubscriptions)
 	}
 
-	successCount := 0
-	for _, sub := range subscriptions {
-		if err := pns.sendToSubscription(sub, message); err != nil {
-			log.Printf("Failed to send push notification to subscription %d: %v", sub.ID, err)
-			// Mark subscription as inactive if it fails
-			models.DeactivatePushSubscription(pns.db, userID, sub.Endpoint)
-		} else {
-			successCount++
+	sent, transient := 0, 0
+	var lastErr error
+	for i := range subscriptions {
+		err := pns.deliver(&subscriptions[i], message)
+		switch {
+		case err == nil:
+			sent++
+		case !isPermanentPushError(err):
+			transient++
+			lastErr = err
 		}
 	}
 
-	if successCount == 0 {
-		return fmt.Errorf("failed to send to any subscription")
+	switch {
+	case sent > 0:
+		return nil
+	case transient > 0:
+		return fmt.Errorf("failed to send to any subscription: %v", lastErr)
 	}
-
-	return nil
+	// Every subscription is gone; retrying cannot help
+	return PermanentDeliveryError(ErrNoPushSubscriptions)
 }
 
 // SendPushNotificationToAll sends a push notification to all active subscriptions
 func (pns *PushNotificationService) SendPushNotificationToAll(message PushMessage) error {
+	sent, failed, err := pns.BroadcastPushNotification(message)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sent push notification to %d/%d subscriptions", sent, sent+failed)
+	return nil
+}
+
+// BroadcastPushNotification sends to all active subscriptions and returns
+// how many subscriptions were delivered to and how many failed
+func (pns *PushNotificationService) BroadcastPushNotification(message PushMessage) (int, int, error) {
 	subscriptions, err := models.GetAllActivePushSubscriptions(pns.db)
 	if err != nil {
-		return fmt.Errorf("failed to get subscriptions: %v", err)
+		return 0, 0, fmt.Errorf("failed to get subscriptions: %v", err)
 	}
 
-	successCount := 0
-	for _, sub := range subscriptions {
-		if err := pns.sendToSubscription(sub, message); err != nil {
-			log.Printf("Failed to send push notification to subscription %d: %v", sub.ID, err)
-			// Mark subscription as inactive if it fails
-			models.DeactivatePushSubscription(pns.db, sub.UserID, sub.Endpoint)
+	sent, failed := pns.deliverToSubscriptions(subscriptions, message)
+	return sent, failed, nil
+}
+
+// SendPushNotificationToUsers sends to every active subscription of the given
+// users and returns delivered and failed subscription counts
+func (pns *PushNotificationService) SendPushNotificationToUsers(userIDs []uint, message PushMessage) (int, int, error) {
+	sent, failed := 0, 0
+	// Chunk to keep the IN list bounded
+	for start := 0; start < len(userIDs); start += 500 {
+		end := start + 500
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+
+		subscriptions, err := models.GetActivePushSubscriptionsForUsers(pns.db, userIDs[start:end])
+		if err != nil {
+			return sent, failed, fmt.Errorf("failed to get subscriptions: %v", err)
+		}
+
+		s, f := pns.deliverToSubscriptions(subscriptions, message)
+		sent += s
+		failed += f
+	}
+	return sent, failed, nil
+}
+
+// deliverToSubscriptions sends to each subscription and returns how many
+// were delivered to and how many failed
+func (pns *PushNotificationService) deliverToSubscriptions(subscriptions []models.PushSubscription, message PushMessage) (int, int) {
+	sent, failed := 0, 0
+	for i := range subscriptions {
+		if err := pns.deliver(&subscriptions[i], message); err != nil {
+			failed++
 		} else {
-			successCount++
+			sent++
 		}
 	}
+	return sent, failed
+}
 
-	log.Printf("Sent push notification to %d/%d subscriptions", successCount, len(subscriptions))
-	return nil
+// deliver sends to one subscription and records the outcome on it. Only
+// permanent errors deactivate the subscription, and configuration errors are
+// not held against it.
+func (pns *PushNotificationService) deliver(sub *models.PushSubscription, message PushMessage) error {
+	err := pns.sendToSubscription(*sub, message)
+	if err == nil {
+		if recordErr := models.RecordPushDeliverySuccess(pns.db, sub); recordErr != nil {
+			log.Printf("Failed to record push delivery to subscription %d: %v", sub.ID, recordErr)
+		}
+		return nil
+	}
+	if isConfigPushError(err) {
+		log.Printf("Push configuration error, subscription %d not sent to: %v", sub.ID, err)
+		return err
+	}
+
+	permanent := isPermanentPushError(err)
+	if permanent {
+		log.Printf("Push subscription %d is gone, deactivating: %v", sub.ID, err)
+	} else {
+		log.Printf("Failed to send push notification to subscription %d: %v", sub.ID, err)
+	}
+	if recordErr := models.RecordPushDeliveryFailure(pns.db, sub, err.Error(), permanent); recordErr != nil {
+		log.Printf("Failed to record push failure of subscription %d: %v", sub.ID, recordErr)
+	}
+	return err
 }
 
 // sendToSubscription sends a push notification to a specific subscription
-// Supports both FCM and WebPush
+// using its transport
 func (pns *PushNotificationService) sendToSubscription(subscription models.PushSubscription, message PushMessage) error {
-	// Check if this is an FCM subscription
-	if pns.isFCMSubscription(subscription.Endpoint) {
+	if subscription.Transport == models.PushTransportFCM {
 		return pns.sendFCMNotification(subscription, message)
 	}
-	
-	// Otherwise use WebPush
 	return pns.sendWebPushNotification(subscription, message)
 }
 
-// isFCMSubscription checks if the endpoint is an FCM endpoint
-func (pns *PushNotificationService) isFCMSubscription(endpoint string) bool {
-	if len(endpoint) == 0 {
-		return false
-	}
-	// FCM tokens are typically long strings (152+ characters)
-	// WebPush endpoints are URLs
-	return len(endpoint) > 100 && !strings.HasPrefix(endpoint, "https://") && !strings.HasPrefix(endpoint, "http://")
-}
-
 // sendFCMNotification sends notification via FCM REST API
 func (pns *PushNotificationService) sendFCMNotification(subscription models.PushSubscription, message PushMessage) error {
 	// FCM token is stored directly in endpoint
 	fcmToken := subscription.Endpoint
-	
+
 	// Clean up token if it has prefix
 	if strings.HasPrefix(fcmToken, "fcm:") {
 		fcmToken = fcmToken[4:]
@@ -149,10 +319,14 @@ func (pns *PushNotificationService) sendFCMNotification(subscription models.Push
 	}
 
 	if fcmServerKey == "" {
-		return fmt.Errorf("FCM server key not configured")
+		return configPushError("FCM server key not configured")
 	}
 
 	// Prepare FCM payload
+	priority := "normal"
+	if message.deliveryUrgency() == PushUrgencyHigh {
+		priority = "high"
+	}
 	fcmPayload := map[string]interface{}{
 		"to": fcmToken,
 		"notification": map[string]interface{}{
@@ -162,8 +336,12 @@ func (pns *PushNotificationService) sendFCMNotification(subscription models.Push
 			"badge": message.Badge,
 			"tag":   message.Tag,
 		},
-		"data": message.Data,
-		"priority": "high",
+		"data":         message.Data,
+		"priority":     priority,
+		"time_to_live": message.deliveryTTL(),
+	}
+	if topic := message.deliveryTopic(); topic != "" {
+		fcmPayload["collapse_key"] = topic
 	}
 
 	payloadJSON, err := json.Marshal(fcmPayload)
@@ -172,7 +350,7 @@ func (pns *PushNotificationService) sendFCMNotification(subscription models.Push
 	}
 
 	// Send to FCM REST API
-	req, err := http.NewRequest("POST", "https://fcm.googleapis.com/fcm/send", bytes.NewBuffer(payloadJSON))
+	req, err := http.NewRequest("POST", fcmSendURL, bytes.NewBuffer(payloadJSON))
 	if err != nil {
 		return fmt.Errorf("failed to create FCM request: %v", err)
 	}
@@ -187,19 +365,43 @@ func (pns *PushNotificationService) sendFCMNotification(subscription models.Push
 	}
 	defer resp.Body.Close()
 
-	// Check response
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		if resp.StatusCode == 401 {
-			return fmt.Errorf("FCM authentication failed - check server key")
-		}
-		if resp.StatusCode == 404 || resp.StatusCode == 400 {
-			return fmt.Errorf("FCM token invalid or expired: %s", string(body))
-		}
-		return fmt.Errorf("FCM error (status %d): %s", resp.StatusCode, string(body))
+	body, _ := io.ReadAll(resp.Body)
+	return fcmResponseError(resp.StatusCode, body)
+}
+
+// fcmSendURL is the FCM legacy HTTP endpoint
+var fcmSendURL = "https://fcm.googleapis.com/fcm/send"
+
+// fcmResponseError returns the error of an FCM legacy response. FCM answers
+// 200 even when the token failed and reports it per result; NotRegistered
+// and InvalidRegistration mean the token is gone.
+func fcmResponseError(statusCode int, body []byte) error {
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return configPushError("FCM authentication failed - check server key")
+	case statusCode == http.StatusNotFound:
+		return permanentPushError("FCM token invalid or expired: %s", string(body))
+	case statusCode >= 400:
+		return fmt.Errorf("FCM error (status %d): %s", statusCode, string(body))
 	}
 
-	return nil
+	var result struct {
+		Failure int `json:"failure"`
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if json.Unmarshal(body, &result) != nil || result.Failure == 0 || len(result.Results) == 0 {
+		return nil
+	}
+	switch code := result.Results[0].Error; code {
+	case "":
+		return nil
+	case "NotRegistered", "InvalidRegistration", "MissingRegistration":
+		return permanentPushError("FCM token rejected: %s", code)
+	default:
+		return fmt.Errorf("FCM delivery failed: %s", code)
+	}
 }
 
 // sendWebPushNotification sends notification via WebPush
@@ -215,7 +417,10 @@ func (pns *PushNotificationService) sendWebPushNotification(subscription models.
 	}
 
 	if vapidPrivateKey == "" {
-		return fmt.Errorf("VAPID private key not configured. Please set push.vapid_private_key in config.yaml")
+		return configPushError("VAPID private key not configured. Please set push.vapid_private_key in config.yaml")
+	}
+	if !validVAPIDPrivateKey(vapidPrivateKey) {
+		return configPushError("VAPID private key is not a base64url P-256 key. Please check push.vapid_private_key in config.yaml")
 	}
 
 	if vapidSubject == "" {
@@ -248,30 +453,51 @@ func (pns *PushNotificationService) sendWebPushNotification(subscription models.
 
 	// Send notification using webpush
 	resp, err := webpush.SendNotification(payloadJSON, sub, &webpush.Options{
+		Subscriber:      vapidSubject,
 		VAPIDPublicKey:  vapidPublicKey,
 		VAPIDPrivateKey: vapidPrivateKey,
-		TTL:             86400, // 24 hours
-		Urgency:         "normal",
-		Topic:           message.Tag,
+		TTL:             message.deliveryTTL(),
+		Urgency:         webpush.Urgency(message.deliveryUrgency()),
+		Topic:           message.deliveryTopic(),
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to send notification: %v", err)
+		// Only the HTTP call can fail transiently; with the VAPID key checked
+		// above, earlier errors come from the subscription's endpoint or keys
+		var netErr *url.Error
+		if errors.As(err, &netErr) {
+			return fmt.Errorf("failed to send notification: %v", err)
+		}
+		return permanentPushError("subscription cannot be encrypted to: %v", err)
 	}
 	defer resp.Body.Close()
 
-	// Check response status
+	// 404 and 410 mean the subscription expired or was revoked, 401 and 403
+	// that the push service rejected our VAPID credentials; anything else
+	// (rate limits, 5xx) is worth retrying
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return permanentPushError("subscription expired or invalid (status: %d)", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return configPushError("push service rejected the VAPID credentials (status: %d)", resp.StatusCode)
+	}
 	if resp.StatusCode >= 400 {
-		// Subscription might be invalid
-		if resp.StatusCode == 410 || resp.StatusCode == 404 {
-			return fmt.Errorf("subscription expired or invalid (status: %d)", resp.StatusCode)
-		}
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
 	return nil
 }
 
+// validVAPIDPrivateKey reports whether key decodes the way webpush-go decodes
+// it, into a 32-byte P-256 scalar
+func validVAPIDPrivateKey(key string) bool {
+	decoded, err := base64.URLEncoding.DecodeString(key)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(key)
+	}
+	return err == nil && len(decoded) == 32
+}
+
 // GetVAPIDPublicKey returns the VAPID public key for frontend
 func (pns *PushNotificationService) GetVAPIDPublicKey() string {
 	publicKey := config.AppConfig.Push.VAPIDPublicKey
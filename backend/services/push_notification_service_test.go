@@ -0,0 +1,154 @@
+package services_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/testutil"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+func TestPushDeliveryTracksSubscriptionHealth(t *testing.T) {
+	testutil.LoadTestConfig()
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := config.AppConfig.Push
+	config.AppConfig.Push = config.PushConfig{VAPIDPublicKey: publicKey, VAPIDPrivateKey: privateKey}
+	t.Cleanup(func() { config.AppConfig.Push = saved })
+
+	// The push service answers with the next queued status
+	var mu sync.Mutex
+	var statuses []int
+	var lastHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		lastHeaders = r.Header.Clone()
+		status := http.StatusCreated
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	respond := func(codes ...int) {
+		mu.Lock()
+		statuses = codes
+		mu.Unlock()
+	}
+
+	clientKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	db := testutil.NewTestDB(t)
+	user := testutil.CreateUser(t, db, "09120000001")
+	sub, err := models.CreatePushSubscription(db, user.ID, server.URL+"/push/abc",
+		base64.RawURLEncoding.EncodeToString(clientKey.PublicKey().Bytes()),
+		base64.RawURLEncoding.EncodeToString(authSecret),
+		"Mozilla/5.0 (Linux; Android 14)", models.PushTransportWebPush, models.PushPlatformAndroid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reload := func() models.PushSubscription {
+		t.Helper()
+		var s models.PushSubscription
+		if err := db.First(&s, sub.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	push := services.NewPushNotificationService(db)
+	message := services.PushMessage{Title: "سلام", Message: "push", Tag: "chat:42", Urgency: services.PushUrgencyHigh, TTL: 600}
+
+	// A transient error keeps the subscription and is worth retrying
+	respond(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	for i := 0; i < 2; i++ {
+		if err := push.SendPushNotification(user.ID, message); err == nil || services.IsPermanentDeliveryError(err) {
+			t.Fatalf("transient failure: err = %v", err)
+		}
+	}
+	if s := reload(); !s.IsActive || s.FailureCount != 2 || s.ConsecutiveFailures != 2 || s.LastFailureAt == nil || s.LastError == "" {
+		t.Fatalf("after transient failures: %+v", s)
+	}
+
+	// A success clears the streak and sends the delivery options
+	respond()
+	if err := push.SendPushNotification(user.ID, message); err != nil {
+		t.Fatal(err)
+	}
+	if s := reload(); s.SuccessCount != 1 || s.ConsecutiveFailures != 0 || s.LastSuccessAt == nil {
+		t.Fatalf("after success: %+v", s)
+	}
+	if lastHeaders.Get("Urgency") != "high" || lastHeaders.Get("TTL") != "600" || lastHeaders.Get("Topic") != "chat42" {
+		t.Fatalf("headers = %v", lastHeaders)
+	}
+
+	// Rejected VAPID credentials are our problem, not the subscription's
+	respond(http.StatusForbidden)
+	if err := push.SendPushNotification(user.ID, message); err == nil || services.IsPermanentDeliveryError(err) {
+		t.Fatalf("rejected credentials: err = %v", err)
+	}
+	if s := reload(); !s.IsActive || s.FailureCount != 2 || s.ConsecutiveFailures != 0 {
+		t.Fatalf("after 403: %+v", s)
+	}
+
+	// Keys that cannot be encrypted to never will be
+	broken := testutil.CreateUser(t, db, "09120000002")
+	brokenSub, err := models.CreatePushSubscription(db, broken.ID, server.URL+"/push/def", "not-a-key", "auth",
+		"Mozilla/5.0 (Linux; Android 14)", models.PushTransportWebPush, models.PushPlatformAndroid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := push.SendPushNotification(broken.ID, message); !services.IsPermanentDeliveryError(err) {
+		t.Fatalf("malformed keys: err = %v", err)
+	}
+	var s models.PushSubscription
+	if db.First(&s, brokenSub.ID); s.IsActive {
+		t.Fatalf("subscription with malformed keys: %+v", s)
+	}
+
+	// A gone subscription is deactivated and the job is not retried
+	respond(http.StatusGone)
+	if err := push.SendPushNotification(user.ID, message); !services.IsPermanentDeliveryError(err) {
+		t.Fatalf("gone subscription: err = %v", err)
+	}
+	if s := reload(); s.IsActive || s.DeactivatedAt == nil {
+		t.Fatalf("after 410: %+v", s)
+	}
+	// A retry finds nothing to deliver to, which is not a delivery
+	if err := push.SendPushNotification(user.ID, message); !services.IsPermanentDeliveryError(err) || !errors.Is(err, services.ErrNoPushSubscriptions) {
+		t.Fatalf("retry after 410: err = %v", err)
+	}
+
+	metrics, err := models.GetPushDeliveryMetrics(db, sub.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int64{}
+	for _, metric := range metrics {
+		if metric.Transport != models.PushTransportWebPush || metric.Platform != models.PushPlatformAndroid {
+			t.Fatalf("metric = %+v", metric)
+		}
+		counts[metric.Outcome] += metric.Deliveries
+	}
+	if counts[models.PushOutcomeSent] != 1 || counts[models.PushOutcomeTransientFailure] != 2 || counts[models.PushOutcomePermanentFailure] != 2 {
+		t.Fatalf("metrics = %v", counts)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"strings"
+
+	"asl-market-backend/utils"
 )
 
 // SMS service for sending notifications
@@ -87,6 +89,34 @@ func (s *SMSService) SendPasswordRecoverySMS(phoneNumber, code string) error {
 	return nil
 }
 
+// SendPhoneVerificationSMS sends a phone verification code. Uses the dedicated
+// verification pattern when configured, otherwise the password recovery pattern.
+func (s *SMSService) SendPhoneVerificationSMS(phoneNumber, code, pattern string) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("SMS service not initialized")
+	}
+
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		pattern = s.passwordRecoveryPattern
+	}
+
+	messageID, err := s.client.SendPattern(
+		pattern,
+		s.originator,
+		phoneNumber,
+		map[string]string{"code": code},
+	)
+
+	if err != nil {
+		log.Printf("Error sending phone verification SMS to %s: %v", phoneNumber, err)
+		return fmt.Errorf("failed to send phone verification SMS: %v", err)
+	}
+
+	log.Printf("Phone verification SMS sent successfully to %s with message ID: %d", phoneNumber, messageID)
+	return nil
+}
+
 // Send simple SMS (for other notifications)
 func (s *SMSService) SendSimpleSMS(phoneNumber, message string) error {
 	if s == nil || s.client == nil {
@@ -137,6 +167,22 @@ func (s *SMSService) SendAffiliateRegistrationSMS(phoneNumber, userName, pattern
 	return nil
 }
 
+// SendPatternSMS sends an SMS from a pattern with the given values
+func (s *SMSService) SendPatternSMS(phoneNumber, patternCode string, values map[string]string) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("SMS service not initialized")
+	}
+
+	messageID, err := s.client.SendPattern(patternCode, s.originator, phoneNumber, values)
+	if err != nil {
+		log.Printf("Error sending pattern SMS to %s: %v", phoneNumber, err)
+		return fmt.Errorf("failed to send SMS: %v", err)
+	}
+
+	log.Printf("Pattern SMS sent successfully to %s with message ID: %d", phoneNumber, messageID)
+	return nil
+}
+
 // Check SMS credit
 func (s *SMSService) GetCredit() (float64, error) {
 	if s == nil || s.client == nil {
@@ -154,30 +200,6 @@ func (s *SMSService) GetCredit() (float64, error) {
 
 // Validate Iranian phone number
 func ValidateIranianPhoneNumber(phoneNumber string) string {
-	phoneNumber = strings.TrimSpace(phoneNumber)
-	// Remove any non-digit characters
-	cleanNumber := ""
-	for _, char := range phoneNumber {
-		if char >= '0' && char <= '9' {
-			cleanNumber += string(char)
-		}
-	}
-
-	// Handle different formats
-	if len(cleanNumber) == 11 && cleanNumber[0] == '0' {
-		// 09123456789 -> 989123456789
-		return "98" + cleanNumber[1:]
-	} else if len(cleanNumber) == 10 && cleanNumber[0] == '9' {
-		// 9123456789 -> 989123456789
-		return "98" + cleanNumber
-	} else if len(cleanNumber) == 13 && cleanNumber[:2] == "98" {
-		// 989123456789 -> keep as is
-		return cleanNumber
-	} else if len(cleanNumber) >= 13 && strings.HasPrefix(cleanNumber, "0098") {
-		// 00989123456789 -> 989123456789
-		return cleanNumber[2:]
-	}
-
-	// If none of the above, assume it's already in correct format or invalid
-	return cleanNumber
+	// 09123456789, +98 912 345 6789, ۰۹۱۲… -> 989123456789
+	return utils.NormalizeIranianPhone(phoneNumber)
 }
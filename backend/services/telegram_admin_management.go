@@ -249,6 +249,7 @@ func (s *TelegramService) handleAddAdminInput(chatID int64, message *tgbotapi.Me
 			sessionMutex.Unlock()
 			return
 		}
+		s.recordAudit(message.From.ID, "telegram_admin.add", "telegram_admin", telegramID, nil, admin, nil)
 
 		adminType := "👑 ادمین کل"
 		if !isFullAdmin {
@@ -399,7 +400,7 @@ func (s *TelegramService) promptRemoveAdmin(chatID int64) {
 }
 
 // handleRemoveAdmin handles removing an admin
-func (s *TelegramService) handleRemoveAdmin(chatID int64, telegramIDStr string) {
+func (s *TelegramService) handleRemoveAdmin(chatID int64, telegramIDStr string, adminTelegramID int64) {
 	telegramID, err := strconv.ParseInt(strings.TrimSpace(telegramIDStr), 10, 64)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ شناسه تلگرام نامعتبر است. لطفا فقط عدد وارد کنید.")
@@ -439,6 +440,7 @@ func (s *TelegramService) handleRemoveAdmin(chatID int64, telegramIDStr string)
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "telegram_admin.remove", "telegram_admin", telegramID, admin, nil, nil)
 
 	adminType := "ادمین کل"
 	if !admin.IsFullAdmin {
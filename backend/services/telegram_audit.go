@@ -0,0 +1,47 @@
+package services
+
+import (
+	"log"
+
+	"asl-market-backend/models"
+)
+
+// auditSnapshot loads the bare row of a record (no associations) for an audit
+// diff. It returns nil if the record cannot be loaded.
+func (s *TelegramService) auditSnapshot(dest interface{}, id uint) interface{} {
+	if err := s.db.First(dest, id).Error; err != nil {
+		return nil
+	}
+	return dest
+}
+
+// recordAudit stores an audit event for an action taken by a Telegram admin.
+// Failures are logged and never interrupt the bot flow.
+func (s *TelegramService) recordAudit(telegramID int64, action, targetType string, targetID interface{}, before, after interface{}, metadata map[string]interface{}) {
+	event := models.AuditEvent{
+		ActorType:       models.AuditActorTelegram,
+		ActorTelegramID: &telegramID,
+		Action:          action,
+		TargetType:      targetType,
+		TargetID:        models.AuditTargetID(targetID),
+	}
+
+	event.ActorName = s.telegramAdminName(telegramID)
+
+	if err := models.RecordAuditEvent(s.db, event, before, after, metadata); err != nil {
+		log.Printf("recordAudit: failed to record %s on %s %v: %v", action, targetType, targetID, err)
+	}
+}
+
+// telegramAdminName is the display name of a Telegram admin: their @username,
+// else their first name, else empty
+func (s *TelegramService) telegramAdminName(telegramID int64) string {
+	var admin models.TelegramAdmin
+	if err := s.db.Where("telegram_id = ?", telegramID).First(&admin).Error; err != nil {
+		return ""
+	}
+	if admin.Username != "" {
+		return "@" + admin.Username
+	}
+	return admin.FirstName
+}
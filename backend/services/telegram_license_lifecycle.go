@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"asl-market-backend/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// telegramLicenseRowsLimit caps how many licenses are listed per section
+const telegramLicenseRowsLimit = 50
+
+// telegramReportPartLength is where a report section is split into several
+// messages, under Telegram's 4096 character limit
+const telegramReportPartLength = 4000
+
+// showExpiringLicenses sends the "licenses expiring this week" report: a
+// summary, then one message per section, split where a section is too long
+func (s *TelegramService) showExpiringLicenses(chatID int64) {
+	report, err := models.GetLicenseExpiryReport(s.db, 7, models.LicenseGracePeriod())
+	if err != nil {
+		log.Printf("Telegram: failed to load expiring licenses: %v", err)
+		s.sendLicenseReportPart(chatID, "❌ خطا در دریافت لایسنس‌های رو به انقضا")
+		return
+	}
+
+	var summary strings.Builder
+	summary.WriteString("⏰ لایسنس‌های رو به انقضا\n\n")
+	summary.WriteString(fmt.Sprintf("📅 انقضا در %d روز آینده: %d\n", report.Days, len(report.Expiring)))
+	summary.WriteString(fmt.Sprintf("⚠️ در مهلت تمدید: %d\n", len(report.InGrace)))
+	if len(report.Expiring) == 0 && len(report.InGrace) == 0 {
+		summary.WriteString("\n✅ هیچ لایسنسی در این هفته منقضی نمی‌شود.")
+	}
+	s.sendLicenseReportPart(chatID, summary.String())
+
+	if len(report.Expiring) > 0 {
+		s.sendLicenseReportSection(chatID, "📅 رو به انقضا:", report.Expiring)
+	}
+	if len(report.InGrace) > 0 {
+		s.sendLicenseReportSection(chatID, "⚠️ منقضی شده (در مهلت تمدید):", report.InGrace)
+	}
+}
+
+// sendLicenseReportSection sends a titled list of licenses in as many
+// messages as it needs
+func (s *TelegramService) sendLicenseReportSection(chatID int64, title string, licenses []models.License) {
+	var b strings.Builder
+	b.WriteString(title + "\n")
+	writeTelegramLicenseRows(&b, licenses)
+	for _, part := range splitLongMessage(b.String(), telegramReportPartLength) {
+		s.sendLicenseReportPart(chatID, part)
+	}
+}
+
+func (s *TelegramService) sendLicenseReportPart(chatID int64, text string) {
+	if _, err := s.bot.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		log.Printf("Telegram: failed to send license report to chat %d: %v", chatID, err)
+	}
+}
+
+func writeTelegramLicenseRows(b *strings.Builder, licenses []models.License) {
+	now := time.Now()
+	for i, license := range licenses {
+		if i == telegramLicenseRowsLimit {
+			b.WriteString(fmt.Sprintf("... و %d مورد دیگر\n", len(licenses)-i))
+			return
+		}
+		name, phone := "---", ""
+		if license.User != nil {
+			name = license.User.Name()
+			phone = license.User.Mobile()
+		}
+		days := int(license.ExpiresAt.Sub(now).Hours() / 24)
+		b.WriteString(fmt.Sprintf("%d. %s %s | %s | %s (%d روز)\n",
+			i+1, name, phone, license.Type, license.ExpiresAt.Format("2006/01/02"), days))
+	}
+}
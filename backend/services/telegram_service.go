@@ -71,16 +71,17 @@ func hasAdminAccess(userID int64) bool {
 
 // Menu constants
 const (
-	MENU_USERS         = "👥 مدیریت کاربران"
-	MENU_STATS         = "📊 آمار سیستم"
-	MENU_SEARCH        = "🔍 جستجوی کاربر"
-	MENU_LICENSES      = "🔑 مدیریت لایسنس"
-	MENU_WITHDRAWALS   = "💰 مدیریت برداشت‌ها"
-	MENU_TRAINING      = "🎓 مدیریت آموزش"
-	MENU_GENERATE      = "➕ تولید لایسنس"
-	MENU_LIST_LICENSES = "📋 لیست لایسنس‌ها"
-	MENU_SETTINGS      = "⚙️ تنظیمات"
-	MENU_NOTIFICATIONS = "🔔 مدیریت نوتیفیکیشن‌ها"
+	MENU_USERS             = "👥 مدیریت کاربران"
+	MENU_STATS             = "📊 آمار سیستم"
+	MENU_SEARCH            = "🔍 جستجوی کاربر"
+	MENU_LICENSES          = "🔑 مدیریت لایسنس"
+	MENU_WITHDRAWALS       = "💰 مدیریت برداشت‌ها"
+	MENU_TRAINING          = "🎓 مدیریت آموزش"
+	MENU_GENERATE          = "➕ تولید لایسنس"
+	MENU_LIST_LICENSES     = "📋 لیست لایسنس‌ها"
+	MENU_EXPIRING_LICENSES = "⏰ لایسنس‌های رو به انقضا"
+	MENU_SETTINGS          = "⚙️ تنظیمات"
+	MENU_NOTIFICATIONS     = "🔔 مدیریت نوتیفیکیشن‌ها"
 
 	// Notification management sub-menus
 	MENU_SEND_NOTIFICATION    = "📤 ارسال نوتیفیکیشن"
@@ -886,7 +887,7 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 	case MENU_SUPPLIER_STATS:
 		s.showSupplierStats(message.Chat.ID)
 	case MENU_BULK_APPROVE_PENDING_SUPPLIERS:
-		s.handleBulkApprovePendingSuppliers(message.Chat.ID)
+		s.handleBulkApprovePendingSuppliers(message.Chat.ID, message.From.ID)
 	case MENU_VISITORS:
 		s.showVisitorMenu(message.Chat.ID)
 	case MENU_PENDING_VISITORS:
@@ -904,7 +905,7 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 	case MENU_VISITOR_STATS:
 		s.showVisitorStats(message.Chat.ID)
 	case MENU_BULK_APPROVE_PENDING_VISITORS:
-		s.handleBulkApprovePendingVisitors(message.Chat.ID)
+		s.handleBulkApprovePendingVisitors(message.Chat.ID, message.From.ID)
 
 	// Withdrawal management cases
 	case MENU_WITHDRAWALS_PENDING:
@@ -966,7 +967,7 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 	case MENU_INACTIVE_AVAILABLE_PRODUCTS:
 		s.showAvailableProductsListByStatus(message.Chat.ID, "inactive", 1)
 	case MENU_BULK_APPROVE_PENDING_PRODUCTS:
-		s.handleBulkApprovePendingProducts(message.Chat.ID)
+		s.handleBulkApprovePendingProducts(message.Chat.ID, message.From.ID)
 	case MENU_SEARCH_AVAILABLE_PRODUCT:
 		s.showAvailableProductSearchPrompt(message.Chat.ID)
 	case MENU_AVAILABLE_PRODUCT_STATS:
@@ -999,6 +1000,8 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 		s.showLicenseTypeSelection(message.Chat.ID)
 	case MENU_LIST_LICENSES:
 		s.showLicensesList(message.Chat.ID, 1)
+	case MENU_EXPIRING_LICENSES:
+		s.showExpiringLicenses(message.Chat.ID)
 	case MENU_NOTIFICATIONS:
 		s.showNotificationMenu(message.Chat.ID)
 	case MENU_SEND_NOTIFICATION:
@@ -1082,12 +1085,14 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 					return
 				}
 
-				err = models.UpdateWithdrawalStatus(s.db, uint(id), models.WithdrawalStatusApproved, nil, "تایید شده توسط ادمین", accountNumber)
+				before := s.auditSnapshot(&models.WithdrawalRequest{}, uint(id))
+				_, err = TransitionWithdrawal(s.db, uint(id), models.WithdrawalStatusApproved, s.withdrawalActor(message.From.ID), "تایید شده توسط ادمین", accountNumber)
 				if err != nil {
-					msg := tgbotapi.NewMessage(message.Chat.ID, "❌ خطا در تایید درخواست")
-					s.bot.Send(msg)
+					s.sendWithdrawalStatusError(message.Chat.ID, err, "❌ خطا در تایید درخواست")
 					return
 				}
+				s.recordAudit(message.From.ID, "withdrawal.status", "withdrawal_request", id, before,
+					s.auditSnapshot(&models.WithdrawalRequest{}, uint(id)), nil)
 
 				text := fmt.Sprintf("✅ درخواست برداشت %s تایید شد\n\n", withdrawalID)
 				text += fmt.Sprintf("شماره حساب مقصد: %s\n\n", accountNumber)
@@ -1122,12 +1127,14 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 					return
 				}
 
-				err = models.UpdateWithdrawalStatus(s.db, uint(id), models.WithdrawalStatusRejected, nil, rejectReason, "")
+				before := s.auditSnapshot(&models.WithdrawalRequest{}, uint(id))
+				_, err = TransitionWithdrawal(s.db, uint(id), models.WithdrawalStatusRejected, s.withdrawalActor(message.From.ID), rejectReason, "")
 				if err != nil {
-					msg := tgbotapi.NewMessage(message.Chat.ID, "❌ خطا در رد درخواست")
-					s.bot.Send(msg)
+					s.sendWithdrawalStatusError(message.Chat.ID, err, "❌ خطا در رد درخواست")
 					return
 				}
+				s.recordAudit(message.From.ID, "withdrawal.status", "withdrawal_request", id, before,
+					s.auditSnapshot(&models.WithdrawalRequest{}, uint(id)), nil)
 
 				text := fmt.Sprintf("❌ درخواست برداشت %s رد شد\n\n", withdrawalID)
 				text += fmt.Sprintf("دلیل رد: %s", rejectReason)
@@ -1144,27 +1151,40 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 				s.handleAddAdminInput(message.Chat.ID, message)
 				return
 			case state.WaitingForInput == "remove_admin_id":
-				s.handleRemoveAdmin(message.Chat.ID, message.Text)
+				s.handleRemoveAdmin(message.Chat.ID, message.Text, message.From.ID)
 				return
 			case state.WaitingForInput == "license_count":
-				if count, err := strconv.Atoi(message.Text); err == nil && count > 0 && count <= 100 {
-					// Get license type from session data
-					licenseType := "plus" // default
-					if state.Data != nil {
-						if lt, ok := state.Data["license_type"].(string); ok {
-							licenseType = lt
-						}
-					}
-
-					s.handleGenerateLicenses(message.Chat.ID, count, licenseType, message.From.ID)
-					// Clear session state
+				if count, err := strconv.Atoi(message.Text); err == nil && count > 0 && count <= models.MaxLicenseBatchSize {
 					sessionMutex.Lock()
-					delete(sessionStates, message.Chat.ID)
+					state.Data["license_count"] = count
+					state.WaitingForInput = "license_batch_name"
 					sessionMutex.Unlock()
+
+					msg := tgbotapi.NewMessage(message.Chat.ID, "🏷️ یک نام برای این دسته لایسنس وارد کنید (مثلاً «فروش نمایشگاه مهر»).\n\n"+
+						"برای نام‌گذاری خودکار، علامت - را ارسال کنید.")
+					s.bot.Send(msg)
 				} else {
-					msg := tgbotapi.NewMessage(message.Chat.ID, "❌ لطفا عددی بین 1 تا 100 وارد کنید.")
+					msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("❌ لطفا عددی بین 1 تا %d وارد کنید.", models.MaxLicenseBatchSize))
 					s.bot.Send(msg)
 				}
+			case state.WaitingForInput == "license_batch_name":
+				// Get license type and count from session data
+				licenseType := "plus" // default
+				if lt, ok := state.Data["license_type"].(string); ok {
+					licenseType = lt
+				}
+				count, _ := state.Data["license_count"].(int)
+
+				batchName := strings.TrimSpace(message.Text)
+				if batchName == "-" {
+					batchName = ""
+				}
+
+				s.handleGenerateLicenses(message.Chat.ID, count, licenseType, batchName, message.From.ID)
+				// Clear session state
+				sessionMutex.Lock()
+				delete(sessionStates, message.Chat.ID)
+				sessionMutex.Unlock()
 			case state.WaitingForInput == "search_query":
 				s.handleSearch(message.Chat.ID, message.Text)
 				// Clear session state
@@ -1188,7 +1208,7 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 
 				if state != nil && state.Data != nil {
 					if supplierID, ok := state.Data["supplier_id"].(uint); ok {
-						s.handleSupplierReject(message.Chat.ID, supplierID, message.Text)
+						s.handleSupplierReject(message.Chat.ID, supplierID, message.Text, message.From.ID)
 					}
 				}
 
@@ -1204,7 +1224,7 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 
 				if state != nil && state.Data != nil {
 					if visitorID, ok := state.Data["visitor_id"].(uint); ok {
-						s.handleVisitorReject(message.Chat.ID, visitorID, message.Text)
+						s.handleVisitorReject(message.Chat.ID, visitorID, message.Text, message.From.ID)
 					}
 				}
 
@@ -1299,12 +1319,12 @@ func (s *TelegramService) handleMessage(message *tgbotapi.Message) {
 			}
 		} else {
 			// Check for supplier command patterns
-			if s.handleSupplierCommands(message.Chat.ID, message.Text) {
+			if s.handleSupplierCommands(message.Chat.ID, message.Text, message.From.ID) {
 				return
 			}
 
 			// Check for visitor command patterns
-			if s.handleVisitorCommands(message.Chat.ID, message.Text) {
+			if s.handleVisitorCommands(message.Chat.ID, message.Text, message.From.ID) {
 				return
 			}
 
@@ -1467,7 +1487,7 @@ func (s *TelegramService) showSettings(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, "⚙️ تنظیمات سیستم:\n\n"+
 		"🎛️ نوع سیستم: لایسنس یکبار مصرف\n"+
 		"👤 ادمین‌ها: "+fmt.Sprint(ADMIN_IDS)+"\n"+
-		"🔑 حداکثر تولید لایسنس: 100 عدد در هر درخواست")
+		"🔑 حداکثر تولید لایسنس: "+fmt.Sprint(models.MaxLicenseBatchSize)+" عدد در هر دسته")
 	s.bot.Send(msg)
 }
 
@@ -2326,7 +2346,7 @@ func (s *TelegramService) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 
 	switch action {
 	case "approve", "reject", "recheck":
-		s.handleUserStatusChange(chatID, &user, action)
+		s.handleUserStatusChange(chatID, &user, action, query.From.ID)
 	case "details":
 		s.showUserDetails(chatID, user)
 	case "message":
@@ -2335,7 +2355,7 @@ func (s *TelegramService) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 	}
 }
 
-func (s *TelegramService) handleUserStatusChange(chatID int64, user *models.User, action string) {
+func (s *TelegramService) handleUserStatusChange(chatID int64, user *models.User, action string, adminTelegramID int64) {
 	var response string
 
 	switch action {
@@ -2346,6 +2366,7 @@ func (s *TelegramService) handleUserStatusChange(chatID int64, user *models.User
 			response = "❌ خطا در فعال کردن کاربر"
 		} else {
 			response = fmt.Sprintf("✅ کاربر %s %s فعال شد", user.FirstName, user.LastName)
+			s.recordAudit(adminTelegramID, "user.activate", "user", user.ID, nil, nil, nil)
 		}
 
 	case "reject":
@@ -2355,6 +2376,7 @@ func (s *TelegramService) handleUserStatusChange(chatID int64, user *models.User
 			response = "❌ خطا در غیرفعال کردن کاربر"
 		} else {
 			response = fmt.Sprintf("❌ کاربر %s %s غیرفعال شد", user.FirstName, user.LastName)
+			s.recordAudit(adminTelegramID, "user.deactivate", "user", user.ID, nil, nil, nil)
 		}
 
 	case "recheck":
@@ -2717,7 +2739,7 @@ func (s *TelegramService) showSupplierStats(chatID int64) {
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) handleBulkApprovePendingSuppliers(chatID int64) {
+func (s *TelegramService) handleBulkApprovePendingSuppliers(chatID int64, adminTelegramID int64) {
 	var count int64
 	s.db.Model(&models.Supplier{}).Where("status = ?", "pending").Count(&count)
 	if count == 0 {
@@ -2732,6 +2754,7 @@ func (s *TelegramService) handleBulkApprovePendingSuppliers(chatID int64) {
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "supplier.bulk_approve", "supplier", nil, nil, nil, map[string]interface{}{"count": result.RowsAffected})
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ **تأیید کلی انجام شد**\n\n%d تأمین‌کننده در انتظار به‌صورت یکجا تأیید شدند.", result.RowsAffected))
 	msg.ParseMode = "Markdown"
 	s.bot.Send(msg)
@@ -2744,7 +2767,7 @@ func (s *TelegramService) showFeaturedSuppliersList(chatID int64) {
 
 // Supplier Command Handlers
 
-func (s *TelegramService) handleSupplierCommands(chatID int64, text string) bool {
+func (s *TelegramService) handleSupplierCommands(chatID int64, text string, adminTelegramID int64) bool {
 	// Check for supplier action commands: /view123, /approve123, /reject123, /edit123, /delete123
 	if strings.HasPrefix(text, "/view") && len(text) > 5 {
 		supplierIDStr := strings.TrimPrefix(text, "/view")
@@ -2755,7 +2778,7 @@ func (s *TelegramService) handleSupplierCommands(chatID int64, text string) bool
 	} else if strings.HasPrefix(text, "/approve") && len(text) > 8 {
 		supplierIDStr := strings.TrimPrefix(text, "/approve")
 		if supplierID, err := strconv.ParseUint(supplierIDStr, 10, 32); err == nil {
-			s.handleSupplierApprove(chatID, uint(supplierID))
+			s.handleSupplierApprove(chatID, uint(supplierID), adminTelegramID)
 			return true
 		}
 	} else if strings.HasPrefix(text, "/reject") && len(text) > 7 {
@@ -2794,7 +2817,7 @@ func (s *TelegramService) handleSupplierCommands(chatID int64, text string) bool
 
 // Visitor Command Handlers
 
-func (s *TelegramService) handleVisitorCommands(chatID int64, text string) bool {
+func (s *TelegramService) handleVisitorCommands(chatID int64, text string, adminTelegramID int64) bool {
 	// Check for visitor action commands: /vview3, /vapprove3, /vreject3, /vedit3, /vdelete3
 	if strings.HasPrefix(text, "/vview") && len(text) > 6 {
 		visitorIDStr := strings.TrimPrefix(text, "/vview")
@@ -2805,7 +2828,7 @@ func (s *TelegramService) handleVisitorCommands(chatID int64, text string) bool
 	} else if strings.HasPrefix(text, "/vapprove") && len(text) > 9 {
 		visitorIDStr := strings.TrimPrefix(text, "/vapprove")
 		if visitorID, err := strconv.ParseUint(visitorIDStr, 10, 32); err == nil {
-			s.handleVisitorApprove(chatID, uint(visitorID))
+			s.handleVisitorApprove(chatID, uint(visitorID), adminTelegramID)
 			return true
 		}
 	} else if strings.HasPrefix(text, "/vreject") && len(text) > 8 {
@@ -3020,7 +3043,7 @@ func (s *TelegramService) showVisitorDetails(chatID int64, visitorID uint) {
 	}
 }
 
-func (s *TelegramService) handleVisitorApprove(chatID int64, visitorID uint) {
+func (s *TelegramService) handleVisitorApprove(chatID int64, visitorID uint, adminTelegramID int64) {
 	// Get visitor
 	var visitor models.Visitor
 	err := s.db.Preload("User").Where("id = ?", visitorID).First(&visitor).Error
@@ -3030,6 +3053,8 @@ func (s *TelegramService) handleVisitorApprove(chatID int64, visitorID uint) {
 		return
 	}
 
+	before := s.auditSnapshot(&models.Visitor{}, visitorID)
+
 	// Update status to approved
 	err = s.db.Model(&visitor).Update("status", "approved").Error
 	if err != nil {
@@ -3037,6 +3062,7 @@ func (s *TelegramService) handleVisitorApprove(chatID int64, visitorID uint) {
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "visitor.approve", "visitor", visitorID, before, s.auditSnapshot(&models.Visitor{}, visitorID), nil)
 
 	// Send success message
 	successMsg := fmt.Sprintf("✅ **ویزیتور تأیید شد**\n\n"+
@@ -3119,7 +3145,7 @@ func (s *TelegramService) promptVisitorReject(chatID int64, visitorID uint) {
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) handleVisitorReject(chatID int64, visitorID uint, reason string) {
+func (s *TelegramService) handleVisitorReject(chatID int64, visitorID uint, reason string, adminTelegramID int64) {
 	// Get visitor
 	var visitor models.Visitor
 	err := s.db.Preload("User").Where("id = ?", visitorID).First(&visitor).Error
@@ -3129,6 +3155,8 @@ func (s *TelegramService) handleVisitorReject(chatID int64, visitorID uint, reas
 		return
 	}
 
+	before := s.auditSnapshot(&models.Visitor{}, visitorID)
+
 	// Update status to rejected with reason
 	err = s.db.Model(&visitor).Updates(map[string]interface{}{
 		"status":        "rejected",
@@ -3139,6 +3167,7 @@ func (s *TelegramService) handleVisitorReject(chatID int64, visitorID uint, reas
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "visitor.reject", "visitor", visitorID, before, s.auditSnapshot(&models.Visitor{}, visitorID), nil)
 
 	// Send success message
 	successMsg := fmt.Sprintf("❌ **ویزیتور رد شد**\n\n"+
@@ -3459,7 +3488,7 @@ func (s *TelegramService) showSupplierDetails(chatID int64, supplierID uint) {
 	}
 }
 
-func (s *TelegramService) handleSupplierApprove(chatID int64, supplierID uint) {
+func (s *TelegramService) handleSupplierApprove(chatID int64, supplierID uint, adminTelegramID int64) {
 	// Find admin user ID for approval
 	adminID, err := s.findOrCreateAdminUser(chatID)
 	if err != nil {
@@ -3468,12 +3497,14 @@ func (s *TelegramService) handleSupplierApprove(chatID int64, supplierID uint) {
 		return
 	}
 
+	before := s.auditSnapshot(&models.Supplier{}, supplierID)
 	err = models.ApproveSupplier(s.db, supplierID, adminID, "تأیید شده توسط ادمین")
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در تأیید تأمین‌کننده")
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "supplier.approve", "supplier", supplierID, before, s.auditSnapshot(&models.Supplier{}, supplierID), nil)
 
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ تأمین‌کننده #%d با موفقیت تأیید شد", supplierID))
 	s.bot.Send(msg)
@@ -3957,7 +3988,7 @@ func (s *TelegramService) promptSupplierReject(chatID int64, supplierID uint) {
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) handleSupplierReject(chatID int64, supplierID uint, reason string) {
+func (s *TelegramService) handleSupplierReject(chatID int64, supplierID uint, reason string, adminTelegramID int64) {
 	// Find admin user ID for rejection
 	adminID, err := s.findOrCreateAdminUser(chatID)
 	if err != nil {
@@ -3966,12 +3997,14 @@ func (s *TelegramService) handleSupplierReject(chatID int64, supplierID uint, re
 		return
 	}
 
+	before := s.auditSnapshot(&models.Supplier{}, supplierID)
 	err = models.RejectSupplier(s.db, supplierID, adminID, reason)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در رد تأمین‌کننده")
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "supplier.reject", "supplier", supplierID, before, s.auditSnapshot(&models.Supplier{}, supplierID), nil)
 
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ تأمین‌کننده #%d رد شد\n📝 دلیل: %s", supplierID, reason))
 	s.bot.Send(msg)
@@ -4397,6 +4430,9 @@ func (s *TelegramService) showLicenseMenu(chatID int64) {
 			tgbotapi.NewKeyboardButton(MENU_GENERATE),
 			tgbotapi.NewKeyboardButton(MENU_LIST_LICENSES),
 		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(MENU_EXPIRING_LICENSES),
+		),
 		tgbotapi.NewKeyboardButtonRow(
 			tgbotapi.NewKeyboardButton("🔙 بازگشت به منو اصلی"),
 		),
@@ -4407,6 +4443,7 @@ func (s *TelegramService) showLicenseMenu(chatID int64) {
 		"در این بخش می‌توانید:\n"+
 		"• لایسنس‌های جدید تولید کنید\n"+
 		"• لیست لایسنس‌ها را مشاهده کنید\n"+
+		"• لایسنس‌های رو به انقضای این هفته را ببینید\n"+
 		"• وضعیت استفاده از لایسنس‌ها را بررسی کنید\n\n"+
 		"لطفا یکی از گزینه‌های زیر را انتخاب کنید:")
 	msg.ParseMode = "Markdown"
@@ -4462,7 +4499,7 @@ func (s *TelegramService) findOrCreateAdminUser(telegramID int64) (uint, error)
 	return adminUser.ID, nil
 }
 
-func (s *TelegramService) handleGenerateLicenses(chatID int64, count int, licenseType string, adminTelegramID int64) {
+func (s *TelegramService) handleGenerateLicenses(chatID int64, count int, licenseType, batchName string, adminTelegramID int64) {
 	// Find or create admin user for telegram bot
 	adminID, err := s.findOrCreateAdminUser(adminTelegramID)
 	if err != nil {
@@ -4471,12 +4508,29 @@ func (s *TelegramService) handleGenerateLicenses(chatID int64, count int, licens
 		return
 	}
 
-	licenses, err := models.GenerateLicenses(s.db, count, licenseType, adminID)
+	batch, licenses, err := models.CreateLicenseBatch(s.db, models.LicenseBatchRequest{
+		Name:    batchName,
+		Count:   count,
+		Type:    licenseType,
+		Channel: models.LicenseChannelTelegram,
+	}, models.LicenseBatchActor{
+		Type:   models.AuditActorTelegram,
+		ID:     adminID,
+		Name:   fmt.Sprintf("telegram:%d", adminTelegramID),
+		UserID: adminID,
+	})
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ خطا در تولید لایسنس‌ها: %v", err))
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "license.generate", "license_batch", batch.ID, nil, nil, map[string]interface{}{
+		"batch_id": batch.ID,
+		"count":    len(licenses),
+		"type":     licenseType,
+		"channel":  batch.Channel,
+		"name":     batch.Name,
+	})
 
 	// Send success message
 	licenseTypeName := "پلاس"
@@ -4490,10 +4544,27 @@ func (s *TelegramService) handleGenerateLicenses(chatID int64, count int, licens
 	}
 
 	successMsg := fmt.Sprintf("✅ **%d لایسنس %s (%s) با موفقیت تولید شد!**\n\n", count, licenseTypeName, duration)
+	successMsg += fmt.Sprintf("📦 دسته: %s (شناسه %d)\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, batch.Name), batch.ID)
 	successMsgObj := tgbotapi.NewMessage(chatID, successMsg)
 	successMsgObj.ParseMode = "Markdown"
 	s.bot.Send(successMsgObj)
 
+	// Large batches are sent as one CSV file instead of dozens of messages
+	if len(licenses) > 100 {
+		var csvData strings.Builder
+		csvData.WriteString("code\n")
+		for _, license := range licenses {
+			csvData.WriteString(license + "\n")
+		}
+		document := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("license_batch_%d.csv", batch.ID),
+			Bytes: []byte(csvData.String()),
+		})
+		document.Caption = fmt.Sprintf("🔑 %d کد لایسنس دسته #%d", len(licenses), batch.ID)
+		s.bot.Send(document)
+		licenses = nil
+	}
+
 	// Send licenses in chunks (Telegram has message length limits)
 	chunkSize := 10
 	for i := 0; i < len(licenses); i += chunkSize {
@@ -4928,7 +4999,7 @@ func (s *TelegramService) showVisitorStats(chatID int64) {
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) handleBulkApprovePendingVisitors(chatID int64) {
+func (s *TelegramService) handleBulkApprovePendingVisitors(chatID int64, adminTelegramID int64) {
 	var count int64
 	s.db.Model(&models.Visitor{}).Where("status = ?", "pending").Count(&count)
 	if count == 0 {
@@ -4943,6 +5014,7 @@ func (s *TelegramService) handleBulkApprovePendingVisitors(chatID int64) {
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "visitor.bulk_approve", "visitor", nil, nil, nil, map[string]interface{}{"count": result.RowsAffected})
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ **تأیید کلی انجام شد**\n\n%d ویزیتور در انتظار به‌صورت یکجا تأیید شدند.", result.RowsAffected))
 	msg.ParseMode = "Markdown"
 	s.bot.Send(msg)
@@ -5498,7 +5570,7 @@ func (s *TelegramService) showAvailableProductsListByStatus(chatID int64, status
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) handleBulkApprovePendingProducts(chatID int64) {
+func (s *TelegramService) handleBulkApprovePendingProducts(chatID int64, adminTelegramID int64) {
 	var count int64
 	s.db.Model(&models.AvailableProduct{}).Where("status = ?", "pending").Count(&count)
 	if count == 0 {
@@ -5513,6 +5585,7 @@ func (s *TelegramService) handleBulkApprovePendingProducts(chatID int64) {
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "available_product.bulk_approve", "available_product", nil, nil, nil, map[string]interface{}{"count": result.RowsAffected})
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ **تأیید کلی انجام شد**\n\n%d کالای در انتظار به‌صورت یکجا تأیید (فعال) شدند.", result.RowsAffected))
 	msg.ParseMode = "Markdown"
 	s.bot.Send(msg)
@@ -5560,12 +5633,12 @@ func (s *TelegramService) handleDeleteConfirmation(query *tgbotapi.CallbackQuery
 	if strings.HasPrefix(data, "confirm_delete_supplier_") {
 		idStr := strings.TrimPrefix(data, "confirm_delete_supplier_")
 		if id, err := strconv.ParseUint(idStr, 10, 32); err == nil {
-			s.executeSupplierDelete(chatID, uint(id))
+			s.executeSupplierDelete(chatID, uint(id), query.From.ID)
 		}
 	} else if strings.HasPrefix(data, "confirm_delete_visitor_") {
 		idStr := strings.TrimPrefix(data, "confirm_delete_visitor_")
 		if id, err := strconv.ParseUint(idStr, 10, 32); err == nil {
-			s.executeVisitorDelete(chatID, uint(id))
+			s.executeVisitorDelete(chatID, uint(id), query.From.ID)
 		}
 	} else if strings.HasPrefix(data, "confirm_delete_research_") {
 		idStr := strings.TrimPrefix(data, "confirm_delete_research_")
@@ -5588,7 +5661,7 @@ func (s *TelegramService) handleDeleteConfirmation(query *tgbotapi.CallbackQuery
 
 // =================== DELETE EXECUTION FUNCTIONS ===================
 
-func (s *TelegramService) executeSupplierDelete(chatID int64, supplierID uint) {
+func (s *TelegramService) executeSupplierDelete(chatID int64, supplierID uint, adminTelegramID int64) {
 	// Get supplier info for final confirmation
 	var supplier models.Supplier
 	err := s.db.Preload("User").Where("id = ?", supplierID).First(&supplier).Error
@@ -5598,6 +5671,8 @@ func (s *TelegramService) executeSupplierDelete(chatID int64, supplierID uint) {
 		return
 	}
 
+	before := s.auditSnapshot(&models.Supplier{}, supplierID)
+
 	// Delete supplier (this will also delete related products due to cascade)
 	err = s.db.Delete(&supplier).Error
 	if err != nil {
@@ -5605,6 +5680,7 @@ func (s *TelegramService) executeSupplierDelete(chatID int64, supplierID uint) {
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "supplier.delete", "supplier", supplierID, before, nil, nil)
 
 	successMsg := fmt.Sprintf("✅ **تأمین‌کننده با موفقیت حذف شد**\n\n"+
 		"👤 **نام:** %s\n"+
@@ -5774,6 +5850,8 @@ func (s *TelegramService) handleUpgradeApprovalNote(message *tgbotapi.Message, s
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(message.From.ID, "upgrade_request.approve", "upgrade_request", requestID, nil, nil,
+		map[string]interface{}{"note": adminNote})
 
 	// Get request details to update user license
 	request, err := models.GetUpgradeRequestByID(models.DB, requestID)
@@ -5835,6 +5913,8 @@ func (s *TelegramService) handleUpgradeRejectionNote(message *tgbotapi.Message,
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(message.From.ID, "upgrade_request.reject", "upgrade_request", requestID, nil, nil,
+		map[string]interface{}{"note": adminNote})
 
 	// Get request details for notification
 	request, err := models.GetUpgradeRequestByID(models.DB, requestID)
@@ -5862,7 +5942,7 @@ func (s *TelegramService) handleUpgradeRejectionNote(message *tgbotapi.Message,
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) executeVisitorDelete(chatID int64, visitorID uint) {
+func (s *TelegramService) executeVisitorDelete(chatID int64, visitorID uint, adminTelegramID int64) {
 	log.Printf("Executing visitor delete: chatID %d, visitorID %d", chatID, visitorID)
 
 	// Get visitor info for final confirmation
@@ -5874,6 +5954,8 @@ func (s *TelegramService) executeVisitorDelete(chatID int64, visitorID uint) {
 		return
 	}
 
+	before := s.auditSnapshot(&models.Visitor{}, visitorID)
+
 	// Delete visitor
 	err = s.db.Delete(&visitor).Error
 	if err != nil {
@@ -5881,6 +5963,7 @@ func (s *TelegramService) executeVisitorDelete(chatID int64, visitorID uint) {
 		s.bot.Send(msg)
 		return
 	}
+	s.recordAudit(adminTelegramID, "visitor.delete", "visitor", visitorID, before, nil, nil)
 
 	successMsg := fmt.Sprintf("✅ **ویزیتور با موفقیت حذف شد**\n\n"+
 		"👤 **نام:** %s\n"+
@@ -6991,8 +7074,8 @@ func (s *TelegramService) showNotificationStats(chatID int64) {
 	// Count active notifications
 	s.db.Model(&models.Notification{}).Where("is_active = ?", true).Count(&stats.Active)
 
-	// Count unread notifications
-	s.db.Model(&models.Notification{}).Where("is_active = ? AND is_read = ?", true, false).Count(&stats.Unread)
+	// Count unread user-specific notifications (broadcast read state is per user)
+	s.db.Model(&models.Notification{}).Where("is_active = ? AND user_id IS NOT NULL AND is_read = ?", true, false).Count(&stats.Unread)
 
 	// Get notifications by type
 	var typeStats []struct {
@@ -7145,40 +7228,14 @@ func (s *TelegramService) createNotification(chatID int64, data map[string]inter
 		notificationData.UserID = &userIDUint
 	}
 
-	// Create notification
-	notification, err := models.CreateNotification(s.db, adminID, notificationData)
+	// Create and send notification (in-app + push)
+	notification, err := NewNotificationDispatcher(s.db).CreateAndDispatch(adminID, notificationData)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در ایجاد نوتیفیکیشن: "+err.Error())
 		s.bot.Send(msg)
 		return
 	}
 
-	// Send FCM push notification
-	pushService := GetPushNotificationService()
-	pushMessage := PushMessage{
-		Title:   notification.Title,
-		Message: notification.Message,
-		Icon:    "/pwa.png",
-		Tag:     fmt.Sprintf("notification-%d", notification.ID),
-		Data: map[string]interface{}{
-			"url":  notification.ActionURL,
-			"type": notification.Type,
-		},
-	}
-
-	// Send to specific user or all users
-	if notification.UserID != nil {
-		// Send to specific user
-		if err := pushService.SendPushNotification(*notification.UserID, pushMessage); err != nil {
-			log.Printf("Failed to send FCM push notification to user %d: %v", *notification.UserID, err)
-		}
-	} else {
-		// Broadcast to all users
-		if err := pushService.SendPushNotificationToAll(pushMessage); err != nil {
-			log.Printf("Failed to send FCM push notification to all users: %v", err)
-		}
-	}
-
 	// Clear session state
 	sessionMutex.Lock()
 	delete(sessionStates, chatID)
@@ -7896,32 +7953,20 @@ func (s *TelegramService) sendExcelFile(chatID int64, f *excelize.File, title, i
 	}()
 }
 
-// SendBackupToAdmins sends backup files (uploads zip + DB SQL) to all static admins.
-func (t *TelegramService) SendBackupToAdmins(uploadsZipPath, dbSQLPath string) {
-	dateStr := time.Now().Format("2006-01-02")
-	for _, adminID := range ADMIN_IDS {
-		t.sendBackupFilesToChat(adminID, uploadsZipPath, dbSQLPath, dateStr)
-	}
-}
-
-// SendBackupToChat sends backup files (uploads zip + DB SQL) to a single chat (e.g. admin who requested manual backup).
-func (t *TelegramService) SendBackupToChat(chatID int64, uploadsZipPath, dbSQLPath string) {
-	t.sendBackupFilesToChat(chatID, uploadsZipPath, dbSQLPath, time.Now().Format("2006-01-02"))
-}
-
-func (t *TelegramService) sendBackupFilesToChat(chatID int64, uploadsZipPath, dbSQLPath, dateStr string) {
-	doc1 := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(uploadsZipPath))
-	doc1.Caption = fmt.Sprintf("📁 بک‌آپ پوشه uploads – %s", dateStr)
-	doc1.ParseMode = "Markdown"
-	if _, err := t.bot.Send(doc1); err != nil {
-		log.Printf("backup: failed to send uploads zip to chat %d: %v", chatID, err)
+// SendBackupArchiveToChat sends an encrypted backup archive to a chat; part
+// and parts number the pieces of an archive split for the size limit
+func (t *TelegramService) SendBackupArchiveToChat(chatID int64, archivePath, name string, part, parts int) error {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(archivePath))
+	archiveName := name
+	if parts > 1 {
+		archiveName = strings.TrimSuffix(name, filepath.Ext(name))
 	}
-	doc2 := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(dbSQLPath))
-	doc2.Caption = fmt.Sprintf("🗄️ بک‌آپ دیتابیس (full) – %s", dateStr)
-	doc2.ParseMode = "Markdown"
-	if _, err := t.bot.Send(doc2); err != nil {
-		log.Printf("backup: failed to send DB backup to chat %d: %v", chatID, err)
+	doc.Caption = fmt.Sprintf("💾 بک‌آپ رمزنگاری‌شده (دیتابیس + uploads) – %s\nبرای بازکردن: go run ./cmd/backup decrypt %s", time.Now().Format("2006-01-02"), archiveName)
+	if parts > 1 {
+		doc.Caption += fmt.Sprintf("\nبخش %d از %d؛ همه بخش‌ها را کنار هم ذخیره کنید", part, parts)
 	}
+	_, err := t.bot.Send(doc)
+	return err
 }
 
 // boolToPersian converts boolean to Persian text
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -169,7 +170,7 @@ func (s *TelegramService) showWithdrawalsList(chatID int64, status string, page
 }
 
 func (s *TelegramService) showWithdrawalStats(chatID int64) {
-	stats, err := models.GetWithdrawalStats(s.db, nil)
+	stats, err := models.GetWithdrawalStats(s.db, nil, models.CurrencyIRR)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در دریافت آمار")
 		s.bot.Send(msg)
@@ -181,7 +182,10 @@ func (s *TelegramService) showWithdrawalStats(chatID int64) {
 	text += fmt.Sprintf("✅ تکمیل شده: %v\n", stats["completed"])
 	text += fmt.Sprintf("⏳ در انتظار: %v\n", stats["pending"])
 	text += fmt.Sprintf("🔄 در حال پردازش: %v\n", stats["processing"])
-	text += fmt.Sprintf("💰 کل مبلغ پرداخت شده: %.2f\n", stats["total_amount"])
+	text += fmt.Sprintf("💰 کل مبلغ پرداخت شده: %.0f ریال\n", stats["total_amount"])
+	if unconverted, ok := stats["unconverted_currencies"].([]string); ok && len(unconverted) > 0 {
+		text += fmt.Sprintf("⚠️ بدون نرخ ارز (در جمع لحاظ نشده): %s\n", strings.Join(unconverted, ", "))
+	}
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	s.bot.Send(msg)
@@ -241,10 +245,10 @@ func (s *TelegramService) handleWithdrawalCallback(callbackQuery *tgbotapi.Callb
 		s.sendWithdrawalRejectionPrompt(chatID, withdrawalID, adminID)
 	} else if strings.HasPrefix(data, "process_withdrawal_") {
 		withdrawalID := strings.TrimPrefix(data, "process_withdrawal_")
-		s.processWithdrawal(chatID, withdrawalID, adminID)
+		s.processWithdrawal(chatID, withdrawalID, callbackQuery.From.ID)
 	} else if strings.HasPrefix(data, "complete_withdrawal_") {
 		withdrawalID := strings.TrimPrefix(data, "complete_withdrawal_")
-		s.completeWithdrawal(chatID, withdrawalID, adminID)
+		s.completeWithdrawal(chatID, withdrawalID, callbackQuery.From.ID)
 	} else if strings.HasPrefix(data, "withdrawal_details_") {
 		withdrawalID := strings.TrimPrefix(data, "withdrawal_details_")
 		s.showWithdrawalDetailsFromCallback(chatID, withdrawalID)
@@ -336,7 +340,7 @@ func (s *TelegramService) sendWithdrawalRejectionPrompt(chatID int64, withdrawal
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) processWithdrawal(chatID int64, withdrawalID string, adminID uint) {
+func (s *TelegramService) processWithdrawal(chatID int64, withdrawalID string, adminTelegramID int64) {
 	// Convert withdrawalID to uint
 	id := s.parseWithdrawalID(withdrawalID)
 	if id == 0 {
@@ -345,12 +349,13 @@ func (s *TelegramService) processWithdrawal(chatID int64, withdrawalID string, a
 		return
 	}
 
-	err := models.UpdateWithdrawalStatus(s.db, id, models.WithdrawalStatusProcessing, nil, "در حال پردازش توسط ادمین", "")
+	before := s.auditSnapshot(&models.WithdrawalRequest{}, id)
+	_, err := TransitionWithdrawal(s.db, id, models.WithdrawalStatusProcessing, s.withdrawalActor(adminTelegramID), "در حال پردازش توسط ادمین", "")
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "❌ خطا در بروزرسانی وضعیت درخواست")
-		s.bot.Send(msg)
+		s.sendWithdrawalStatusError(chatID, err, "❌ خطا در بروزرسانی وضعیت درخواست")
 		return
 	}
+	s.recordAudit(adminTelegramID, "withdrawal.status", "withdrawal_request", id, before, s.auditSnapshot(&models.WithdrawalRequest{}, id), nil)
 
 	text := fmt.Sprintf("🔄 درخواست برداشت %s به حالت پردازش تغییر یافت\n\n", withdrawalID)
 	text += "کاربر می‌تواند فیش واریز را بارگذاری کند."
@@ -359,7 +364,7 @@ func (s *TelegramService) processWithdrawal(chatID int64, withdrawalID string, a
 	s.bot.Send(msg)
 }
 
-func (s *TelegramService) completeWithdrawal(chatID int64, withdrawalID string, adminID uint) {
+func (s *TelegramService) completeWithdrawal(chatID int64, withdrawalID string, adminTelegramID int64) {
 	// Convert withdrawalID to uint
 	id := s.parseWithdrawalID(withdrawalID)
 	if id == 0 {
@@ -368,15 +373,42 @@ func (s *TelegramService) completeWithdrawal(chatID int64, withdrawalID string,
 		return
 	}
 
-	err := models.UpdateWithdrawalStatus(s.db, id, models.WithdrawalStatusCompleted, nil, "تکمیل شده توسط ادمین", "")
+	before := s.auditSnapshot(&models.WithdrawalRequest{}, id)
+	request, err := TransitionWithdrawal(s.db, id, models.WithdrawalStatusCompleted, s.withdrawalActor(adminTelegramID), "تکمیل شده توسط ادمین", "")
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "❌ خطا در بروزرسانی وضعیت درخواست")
-		s.bot.Send(msg)
+		s.sendWithdrawalStatusError(chatID, err, "❌ خطا در بروزرسانی وضعیت درخواست")
 		return
 	}
+	s.recordAudit(adminTelegramID, "withdrawal.status", "withdrawal_request", id, before, s.auditSnapshot(&models.WithdrawalRequest{}, id), nil)
 
 	text := fmt.Sprintf("✅ درخواست برداشت %s با موفقیت تکمیل شد", withdrawalID)
+	if request.SettledAmountIRR != nil && request.AppliedRateIRR != nil {
+		text += fmt.Sprintf("\n\n💱 نرخ: %.0f ریال به ازای هر %s\n💰 مبلغ تسویه: %.0f ریال", *request.AppliedRateIRR, request.Currency, *request.SettledAmountIRR)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	s.bot.Send(msg)
+}
+
+// withdrawalActor identifies a Telegram admin in a withdrawal's status history
+func (s *TelegramService) withdrawalActor(telegramID int64) models.WithdrawalActor {
+	return models.WithdrawalActor{
+		Type:       models.AuditActorTelegram,
+		TelegramID: &telegramID,
+		Name:       s.telegramAdminName(telegramID),
+	}
+}
 
+// sendWithdrawalStatusError reports a failed status change, explaining when
+// the request's current status does not allow it
+func (s *TelegramService) sendWithdrawalStatusError(chatID int64, err error, fallback string) {
+	text := fallback
+	switch {
+	case errors.Is(err, models.ErrInvalidWithdrawalTransition):
+		text = "❌ این تغییر وضعیت برای وضعیت فعلی درخواست مجاز نیست. لطفاً لیست درخواست‌ها را دوباره باز کنید."
+	case errors.Is(err, models.ErrExchangeRateNotFound):
+		text = "❌ نرخ ارز این درخواست برای امروز ثبت نشده است. ابتدا نرخ روز را در پنل مدیریت وارد کنید."
+	}
 	msg := tgbotapi.NewMessage(chatID, text)
 	s.bot.Send(msg)
 }
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// VisitorProjectService tells suppliers about new visitor projects
+type VisitorProjectService struct {
+	db *gorm.DB
+}
+
+// NewVisitorProjectService creates a new visitor project service
+func NewVisitorProjectService(db *gorm.DB) *VisitorProjectService {
+	return &VisitorProjectService{db: db}
+}
+
+// FindMatchingSuppliers finds approved suppliers with a product whose name
+// contains the project's product name. The project's own creator is skipped.
+func (s *VisitorProjectService) FindMatchingSuppliers(project *models.VisitorProject) ([]models.Supplier, error) {
+	productName := strings.ToLower(strings.TrimSpace(project.ProductName))
+	if productName == "" {
+		return nil, nil
+	}
+
+	var suppliers []models.Supplier
+	err := s.db.
+		Where("status = ? AND user_id <> ?", "approved", project.UserID).
+		Where("id IN (?)", s.db.Model(&models.SupplierProduct{}).Select("supplier_id").
+			Where("LOWER(product_name) LIKE ?", "%"+productName+"%")).
+		Order("is_featured DESC, id ASC").
+		Find(&suppliers).Error
+	return suppliers, err
+}
+
+// SendVisitorProjectNotifications queues the in-app notification, web push
+// and (for licensed suppliers) SMS of a new project for every matched
+// supplier, tracked by VisitorProjectNotification rows
+func (s *VisitorProjectService) SendVisitorProjectNotifications(project *models.VisitorProject, suppliers []models.Supplier) error {
+	title := "پروژه ویزیتوری جدید"
+	message := fmt.Sprintf("ویزیتور به %s %s %s در %s نیاز دارد", project.Quantity, project.Unit, project.ProductName, project.TargetCountries)
+	actionURL := "/visitor-projects"
+	pushMessage := PushMessage{
+		Title:   title,
+		Message: message,
+		Icon:    "/pwa.png",
+		Tag:     fmt.Sprintf("visitor-project-%d", project.ID),
+		Urgency: PushUrgencyHigh,
+		Data: map[string]interface{}{
+			"url":  actionURL,
+			"type": "visitor_project",
+		},
+	}
+	smsMessage := message + ". برای ارسال پیشنهاد وارد اپلیکیشن شوید."
+
+	var rows []models.VisitorProjectNotification
+	var jobs []models.NotificationJob
+	for _, supplier := range suppliers {
+		add := func(job models.NotificationJob) {
+			rows = append(rows, models.VisitorProjectNotification{
+				VisitorProjectID: project.ID,
+				SupplierID:       supplier.ID,
+				UserID:           supplier.UserID,
+				NotificationType: job.Channel,
+				Status:           "pending",
+				Message:          job.Message,
+			})
+			jobs = append(jobs, job)
+		}
+
+		add(NewInAppJob(supplier.UserID, models.NotificationTopicMatchingRequests, title, message, InAppNotificationPayload{
+			Type:        "visitor_project",
+			Priority:    "high",
+			ActionURL:   actionURL,
+			CreatedByID: project.UserID, // Created by visitor
+		}))
+		add(NewPushJob(supplier.UserID, models.NotificationTopicMatchingRequests, pushMessage))
+		if access, err := models.GetUserLicenseAccess(s.db, supplier.UserID, models.LicenseGracePeriod()); err == nil && access.HasLicense {
+			add(NewSMSJob(supplier.UserID, models.NotificationTopicMatchingRequests, supplier.Mobile, smsMessage))
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			if err := tx.CreateInBatches(&rows, 500).Error; err != nil {
+				return err
+			}
+		}
+		for i := range jobs {
+			jobs[i].SourceType = models.NotificationJobSourceVisitorProject
+			jobs[i].SourceID = rows[i].ID
+		}
+		return models.EnqueueNotificationJobs(tx, jobs)
+	})
+}
+
+// ProcessVisitorProject notifies the suppliers matching a new project
+func (s *VisitorProjectService) ProcessVisitorProject(project *models.VisitorProject) error {
+	suppliers, err := s.FindMatchingSuppliers(project)
+	if err != nil {
+		return fmt.Errorf("failed to find matching suppliers: %v", err)
+	}
+	if err := s.SendVisitorProjectNotifications(project, suppliers); err != nil {
+		return fmt.Errorf("failed to send notifications: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"asl-market-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// TransitionWithdrawal changes a withdrawal request's status through the
+// transition table and notifies its user of the new status. Both the admin
+// API and the Telegram bot go through here.
+func TransitionWithdrawal(db *gorm.DB, requestID uint, status models.WithdrawalStatus, actor models.WithdrawalActor, note, destinationAccount string) (*models.WithdrawalRequest, error) {
+	request, err := models.UpdateWithdrawalStatus(db, requestID, status, actor, note, destinationAccount)
+	if err != nil {
+		return nil, err
+	}
+	notifyWithdrawalStatus(db, request, note)
+	return request, nil
+}
+
+// notifyWithdrawalStatus sends the in-app notification (with web push) for a
+// withdrawal request's new status
+func notifyWithdrawalStatus(db *gorm.DB, request *models.WithdrawalRequest, note string) {
+	amount := fmt.Sprintf("%.2f %s", request.Amount, request.Currency)
+	var title, message string
+	switch request.Status {
+	case models.WithdrawalStatusApproved:
+		title = "درخواست برداشت شما تایید شد"
+		message = fmt.Sprintf("درخواست برداشت #%d به مبلغ %s تایید شد. لطفاً مبلغ را به حساب مقصد واریز کرده و فیش را بارگذاری کنید.", request.ID, amount)
+		if request.DestinationAccount != "" {
+			message += fmt.Sprintf("\nحساب مقصد: %s", request.DestinationAccount)
+		}
+	case models.WithdrawalStatusProcessing:
+		title = "درخواست برداشت شما در حال پردازش است"
+		message = fmt.Sprintf("درخواست برداشت #%d به مبلغ %s در حال پردازش است.", request.ID, amount)
+	case models.WithdrawalStatusCompleted:
+		title = "درخواست برداشت شما تکمیل شد"
+		message = fmt.Sprintf("مبلغ درخواست برداشت #%d (%s) به حساب شما واریز شد.", request.ID, amount)
+	case models.WithdrawalStatusRejected:
+		title = "درخواست برداشت شما رد شد"
+		message = fmt.Sprintf("درخواست برداشت #%d به مبلغ %s رد شد.", request.ID, amount)
+		if note != "" {
+			message += fmt.Sprintf("\nدلیل: %s", note)
+		}
+	default:
+		return
+	}
+
+	userID := request.UserID
+	sendPush := true
+	_, err := NewNotificationDispatcher(db).CreateAndDispatch(userID, models.CreateNotificationRequest{
+		Title:      title,
+		Message:    message,
+		Type:       "withdrawal",
+		Priority:   "high",
+		UserID:     &userID,
+		ActionURL:  "/aslpay",
+		ActionText: "مشاهده درخواست",
+		SendPush:   &sendPush,
+	})
+	if err != nil {
+		log.Printf("Withdrawal: failed to notify user %d about request %d: %v", userID, request.ID, err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package testutil provides an in-process database and request helpers for tests.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"asl-market-backend/config"
+	"asl-market-backend/models"
+	"asl-market-backend/services"
+	"asl-market-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var dbCounter int64
+
+// LoadTestConfig installs a minimal config suitable for tests
+func LoadTestConfig() {
+	if config.AppConfig != nil {
+		return
+	}
+	config.AppConfig = &config.Config{
+		JWT: config.JWTConfig{Secret: "test-secret", ExpiryHours: 1},
+		OTP: config.OTPConfig{TTLMinutes: 5, MaxAttempts: 3, ResendCooldownSeconds: 60},
+		Matching: config.MatchingConfig{
+			CountryWeight: 40, ProductWeight: 25, LanguageWeight: 10, RatingWeight: 10,
+			ResponseRateWeight: 10, ExperienceWeight: 3, FeaturedWeight: 2,
+			RequireCountryMatch: true, MinScore: 30, TopN: 50,
+		},
+		License: config.LicenseConfig{GracePeriodDays: 3, ReminderDays: []int{30, 7, 1}},
+	}
+}
+
+// NewTestDB opens a fresh in-memory SQLite database, migrates every model and
+// installs it as the global models.DB for the duration of the test.
+func NewTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	LoadTestConfig()
+
+	name := fmt.Sprintf("file:testdb%d?mode=memory&cache=shared&_fk=1", atomic.AddInt64(&dbCounter, 1))
+	db, err := gorm.Open(sqlite.Open(name), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := models.MigrateDatabase(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+
+	previous := models.GetDB()
+	models.SetDB(db)
+	t.Cleanup(func() {
+		models.SetDB(previous)
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// CreateUser inserts an active user with the given phone
+func CreateUser(t testing.TB, db *gorm.DB, phone string) *models.User {
+	t.Helper()
+	hashed, err := utils.HashPassword("secret123")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := models.User{
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     "user_" + phone + "@aslmarket.local",
+		Password:  hashed,
+		Phone:     phone,
+		IsActive:  true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return &user
+}
+
+// GrantLicense gives a user an active license of the given type
+func GrantLicense(t testing.TB, db *gorm.DB, userID uint, licenseType string) *models.License {
+	t.Helper()
+	code, err := models.GenerateLicenseCode()
+	if err != nil {
+		t.Fatalf("generate license code: %v", err)
+	}
+	now := time.Now()
+	expires := now.AddDate(0, 12, 0)
+	license := models.License{
+		Code:        code,
+		Type:        licenseType,
+		Duration:    12,
+		IsUsed:      true,
+		UsedBy:      &userID,
+		UsedAt:      &now,
+		ExpiresAt:   &expires,
+		GeneratedBy: userID,
+	}
+	if err := db.Create(&license).Error; err != nil {
+		t.Fatalf("create license: %v", err)
+	}
+	return &license
+}
+
+// Token returns a bearer token for a new session of a user
+func Token(t testing.TB, user *models.User) string {
+	t.Helper()
+	return SessionToken(t, utils.SubjectUser, user.ID, user.Email)
+}
+
+// SessionToken starts an auth session for any subject and returns its access token
+func SessionToken(t testing.TB, subjectType string, subjectID uint, identifier string) string {
+	t.Helper()
+	_, hash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		t.Fatalf("generate refresh token: %v", err)
+	}
+	session, err := models.CreateAuthSession(models.GetDB(), subjectType, subjectID, hash, "test", "127.0.0.1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("create auth session: %v", err)
+	}
+	var token string
+	if subjectType == utils.SubjectAffiliate {
+		token, err = utils.GenerateAffiliateToken(subjectID, identifier, session.ID)
+	} else {
+		token, err = utils.GenerateToken(subjectType, subjectID, identifier, session.ID)
+	}
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	return token
+}
+
+// DoJSON performs a request against a gin engine and decodes the JSON response
+func DoJSON(t testing.TB, router *gin.Engine, method, path, token string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	result := map[string]interface{}{}
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, result
+}
+
+// ExpectStatus fails the test when the recorder status differs
+func ExpectStatus(t testing.TB, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d (%s): %s", rec.Code, want, http.StatusText(want), rec.Body.String())
+	}
+}
+
+// CreateSupplier inserts an approved supplier owned by userID
+func CreateSupplier(t testing.TB, db *gorm.DB, userID uint) *models.Supplier {
+	t.Helper()
+	supplier := models.Supplier{
+		UserID:            userID,
+		FullName:          "Test Supplier",
+		Mobile:            "09120000000",
+		City:              "تهران",
+		Address:           "Test address",
+		WholesaleMinPrice: "100",
+		Status:            "approved",
+	}
+	if err := db.Create(&supplier).Error; err != nil {
+		t.Fatalf("create supplier: %v", err)
+	}
+	return &supplier
+}
+
+// CreateVisitor inserts an approved visitor owned by userID covering the given destinations
+func CreateVisitor(t testing.TB, db *gorm.DB, userID uint, destinations, products string) *models.Visitor {
+	t.Helper()
+	visitor := models.Visitor{
+		UserID:             userID,
+		FullName:           "Test Visitor",
+		NationalID:         "0012345678",
+		BirthDate:          "1370/01/01",
+		Mobile:             "09130000000",
+		ResidenceAddress:   "Test address",
+		CityProvince:       "دبی",
+		DestinationCities:  destinations,
+		BankAccountIBAN:    "IR000000000000000000000000",
+		BankName:           "Test Bank",
+		LanguageLevel:      "good",
+		InterestedProducts: products,
+		Status:             "approved",
+	}
+	if err := db.Create(&visitor).Error; err != nil {
+		t.Fatalf("create visitor: %v", err)
+	}
+	return &visitor
+}
+
+// CreateAvailableProduct inserts an active product listed by sellerID
+func CreateAvailableProduct(t testing.TB, db *gorm.DB, sellerID uint, quantity int) *models.AvailableProduct {
+	t.Helper()
+	product := models.AvailableProduct{
+		AddedByID:         sellerID,
+		SaleType:          "wholesale",
+		ProductName:       "زعفران",
+		Category:          "food",
+		Unit:              "kg",
+		WholesalePrice:    "1000",
+		Currency:          "USD",
+		Location:          "تهران",
+		AvailableQuantity: quantity,
+		MinOrderQuantity:  1,
+		Status:            "active",
+	}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatalf("create available product: %v", err)
+	}
+	return &product
+}
+
+// SendNotification creates and dispatches a notification. Push fan-out is
+// disabled unless the request asks for it explicitly.
+func SendNotification(t testing.TB, db *gorm.DB, createdByID uint, req models.CreateNotificationRequest) *models.Notification {
+	t.Helper()
+	if req.SendPush == nil {
+		noPush := false
+		req.SendPush = &noPush
+	}
+	notification, err := services.NewNotificationDispatcher(db).CreateAndDispatch(createdByID, req)
+	if err != nil {
+		t.Fatalf("send notification: %v", err)
+	}
+	return notification
+}
+
+// CreateWebAdmin creates an active admin panel account with the given role and
+// returns it with a bearer token. Admin IDs may overlap user IDs; tokens carry
+// their subject type.
+func CreateWebAdmin(t testing.TB, db *gorm.DB, username, role string) (*models.WebAdmin, string) {
+	t.Helper()
+	hashed, err := utils.HashPassword("secret123")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	admin := models.WebAdmin{
+		Name:     "Admin " + username,
+		Email:    username + "@admin.aslmarket.local",
+		Phone:    "09120000000",
+		Username: username,
+		Password: hashed,
+		Role:     role,
+		IsActive: true,
+	}
+	if err := db.Create(&admin).Error; err != nil {
+		t.Fatalf("create web admin: %v", err)
+	}
+	return &admin, SessionToken(t, utils.SubjectWebAdmin, admin.ID, admin.Username)
+}
@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -9,9 +12,22 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token subject types. A token is only ever checked against the table of its
+// own subject type, so IDs from different tables never collide.
+const (
+	SubjectUser      = "user"
+	SubjectWebAdmin  = "web_admin"
+	SubjectAffiliate = "affiliate"
+)
+
+// ErrTokenSubject is returned when a token belongs to another kind of subject
+var ErrTokenSubject = errors.New("token subject type mismatch")
+
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID      uint   `json:"user_id"`
+	Email       string `json:"email"`
+	SubjectType string `json:"sub_type"`
+	SessionID   uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -19,15 +35,37 @@ type Claims struct {
 type AffiliateClaims struct {
 	AffiliateID uint   `json:"affiliate_id"`
 	Username    string `json:"username"`
+	SubjectType string `json:"sub_type"`
+	SessionID   uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uint, email string) (string, error) {
-	expirationTime := time.Now().Add(time.Hour * time.Duration(config.AppConfig.JWT.ExpiryHours))
+// AccessTokenTTL is the lifetime of an access token
+func AccessTokenTTL() time.Duration {
+	return time.Hour * time.Duration(config.AppConfig.JWT.ExpiryHours)
+}
+
+// RefreshTokenTTL is the lifetime of a refresh token (and of its session)
+func RefreshTokenTTL() time.Duration {
+	hours := config.AppConfig.JWT.RefreshExpiryHours
+	if hours <= 0 {
+		hours = 24 * 30
+	}
+	return time.Hour * time.Duration(hours)
+}
+
+// GenerateToken creates an access token for a user or web admin session
+func GenerateToken(subjectType string, subjectID uint, email string, sessionID uint) (string, error) {
+	if subjectType != SubjectUser && subjectType != SubjectWebAdmin {
+		return "", ErrTokenSubject
+	}
+	expirationTime := time.Now().Add(AccessTokenTTL())
 
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:      subjectID,
+		Email:       email,
+		SubjectType: subjectType,
+		SessionID:   sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -38,6 +76,8 @@ func GenerateToken(userID uint, email string) (string, error) {
 	return token.SignedString([]byte(config.AppConfig.JWT.Secret))
 }
 
+// ValidateToken parses an access token issued by GenerateToken. Tokens
+// without a subject type (issued before subjects existed) are rejected.
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
@@ -53,15 +93,21 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	if claims.SubjectType != SubjectUser && claims.SubjectType != SubjectWebAdmin {
+		return nil, ErrTokenSubject
+	}
+
 	return claims, nil
 }
 
 // GenerateAffiliateToken creates JWT for affiliate panel
-func GenerateAffiliateToken(affiliateID uint, username string) (string, error) {
-	expirationTime := time.Now().Add(time.Hour * time.Duration(config.AppConfig.JWT.ExpiryHours))
+func GenerateAffiliateToken(affiliateID uint, username string, sessionID uint) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL())
 	claims := &AffiliateClaims{
 		AffiliateID: affiliateID,
 		Username:    username,
+		SubjectType: SubjectAffiliate,
+		SessionID:   sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -83,5 +129,25 @@ func ValidateAffiliateToken(tokenString string) (*AffiliateClaims, error) {
 	if !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	if claims.SubjectType != SubjectAffiliate {
+		return nil, ErrTokenSubject
+	}
 	return claims, nil
 }
+
+// GenerateRefreshToken returns a random opaque refresh token and its hash.
+// Only the hash is stored.
+func GenerateRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(buf)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken hashes a refresh token for storage and lookup
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
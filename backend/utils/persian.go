@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// persianReplacer maps Arabic letter variants and Persian/Arabic-Indic digits
+// to the forms used for comparison
+var persianReplacer = strings.NewReplacer(
+	"ي", "ی", "ى", "ی", "ئ", "ی",
+	"ك", "ک",
+	"ة", "ه", "ۀ", "ه",
+	"أ", "ا", "إ", "ا", "آ", "ا", "ٱ", "ا",
+	"ؤ", "و",
+	"۰", "0", "۱", "1", "۲", "2", "۳", "3", "۴", "4",
+	"۵", "5", "۶", "6", "۷", "7", "۸", "8", "۹", "9",
+	"٠", "0", "١", "1", "٢", "2", "٣", "3", "٤", "4",
+	"٥", "5", "٦", "6", "٧", "7", "٨", "8", "٩", "9",
+	"\u200c", " ", // zero-width non-joiner
+	"\u200e", "", "\u200f", "", // direction marks
+	"ـ", "", // tatweel
+)
+
+// NormalizePersianText prepares text for comparison: Arabic letter variants
+// (ي/ی, ك/ک…) and digits are unified, diacritics and tatweel are dropped,
+// Latin letters are lower-cased and whitespace is collapsed
+func NormalizePersianText(s string) string {
+	s = persianReplacer.Replace(s)
+	s = strings.Map(func(r rune) rune {
+		// Arabic diacritics (harakat, tanwin, superscript alef)
+		if (r >= 0x064B && r <= 0x065F) || r == 0x0670 {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// NormalizeIranianPhone converts an Iranian mobile number in any common
+// format (09…, 9…, +98…, 0098…, with Persian digits or separators) to the
+// 98XXXXXXXXXX form. Numbers in no known format are returned as their digits.
+func NormalizeIranianPhone(phone string) string {
+	phone = persianReplacer.Replace(strings.TrimSpace(phone))
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	switch {
+	case len(digits) == 11 && digits[0] == '0':
+		// 09123456789 -> 989123456789
+		return "98" + digits[1:]
+	case len(digits) == 10 && digits[0] == '9':
+		// 9123456789 -> 989123456789
+		return "98" + digits
+	case len(digits) == 12 && strings.HasPrefix(digits, "98"):
+		// 989123456789 -> keep as is
+		return digits
+	case len(digits) >= 13 && strings.HasPrefix(digits, "0098"):
+		// 00989123456789 -> 989123456789
+		return digits[2:]
+	}
+	return digits
+}